@@ -0,0 +1,75 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var assetListCmd = &cobra.Command{
+	Use:   "list [repoName]",
+	Short: "list assets of a repository",
+	Long:  "List the distribution, component and group assets installed in a repository",
+	Run:   listAssets,
+}
+
+func init() {
+	AssetCmd.AddCommand(assetListCmd)
+}
+
+func listAssets(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "asset list takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	assets, err := client.GetAssets(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		if assets == nil {
+			assets = []libferry.AssetItem{}
+		}
+		printJSON(assets)
+		return
+	}
+	if len(assets) == 0 {
+		fmt.Printf("No assets installed in '%s'.\n", args[0])
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Size", "Modified"})
+	table.SetBorder(false)
+	for _, a := range assets {
+		table.Append([]string{
+			a.Name,
+			formatBytes(uint64(a.Size)),
+			a.Modified.Local().Format("2006-01-02 15:04:05"),
+		})
+	}
+	table.Render()
+}
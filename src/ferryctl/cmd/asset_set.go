@@ -0,0 +1,70 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var assetSetCmd = &cobra.Command{
+	Use:   "set [repoName] [file]",
+	Short: "install a repository asset",
+	Long:  "Upload a distribution.xml, components.xml or groups.xml to the repository, replacing any existing one, and reindex it",
+	Run:   setAsset,
+}
+
+var assetSetName string
+
+func init() {
+	assetSetCmd.Flags().StringVarP(&assetSetName, "name", "n", "", "Asset name, if the file isn't named after it")
+	AssetCmd.AddCommand(assetSetCmd)
+}
+
+func setAsset(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "asset set takes exactly 2 arguments\n")
+		return
+	}
+
+	name := assetSetName
+	if name == "" {
+		name = filepath.Base(args[1])
+	}
+
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.SetAsset(args[0], name, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,51 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var assetShowCmd = &cobra.Command{
+	Use:   "show [repoName] [assetName]",
+	Short: "print a repository asset",
+	Long:  "Print the contents of a repository asset, i.e. components.xml",
+	Run:   showAsset,
+}
+
+func init() {
+	AssetCmd.AddCommand(assetShowCmd)
+}
+
+func showAsset(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "asset show takes exactly 2 arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	data, err := client.GetAsset(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	os.Stdout.Write(data)
+}
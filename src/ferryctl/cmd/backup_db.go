@@ -0,0 +1,64 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var backupDbCmd = &cobra.Command{
+	Use:   "backup-db [file]",
+	Short: "back up the ferryd database",
+	Long:  "Write a consistent snapshot of the ferryd database to a file, without stopping the daemon. Restore it with ferryd --restore-db",
+	Run:   backupDb,
+}
+
+func init() {
+	RootCmd.AddCommand(backupDbCmd)
+}
+
+func backupDb(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "backup-db takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	// Only put the backup in place once it's complete
+	partPath := args[0] + ".partial"
+	out, err := os.Create(partPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	err = client.BackupDatabase(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partPath, args[0])
+	}
+	if err != nil {
+		os.Remove(partPath)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,68 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var banAddCmd = &cobra.Command{
+	Use:   "add [repoName] [name]",
+	Short: "ban a package or source from a repository",
+	Long:  "Stop matching packages being imported, pulled, cloned, copied or promoted into the repository",
+	Run:   banAdd,
+}
+
+var (
+	banSource      bool
+	banReleaseFrom int
+	banReleaseTo   int
+	banReason      string
+)
+
+func init() {
+	banAddCmd.Flags().BoolVar(&banSource, "source", false, "Ban every package built from the named source")
+	banAddCmd.Flags().IntVar(&banReleaseFrom, "from", 0, "Lowest release to ban (0 for no lower bound)")
+	banAddCmd.Flags().IntVar(&banReleaseTo, "to", 0, "Highest release to ban (0 for no upper bound)")
+	banAddCmd.Flags().StringVarP(&banReason, "reason", "r", "", "Why the ban is in place")
+	BanCmd.AddCommand(banAddCmd)
+}
+
+func banAdd(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: ban add [repoName] [name]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	ban := libferry.Ban{
+		Name:        args[1],
+		Source:      banSource,
+		ReleaseFrom: banReleaseFrom,
+		ReleaseTo:   banReleaseTo,
+		Reason:      banReason,
+	}
+	if err := client.AddBan(args[0], ban); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
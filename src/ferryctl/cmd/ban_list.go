@@ -0,0 +1,92 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var banListCmd = &cobra.Command{
+	Use:   "list [repoName]",
+	Short: "list bans of a repository",
+	Long:  "List the packages and sources which may not be introduced into the repository",
+	Run:   banList,
+}
+
+func init() {
+	BanCmd.AddCommand(banListCmd)
+}
+
+// formatBanReleases will describe the releases covered by the ban
+func formatBanReleases(ban *libferry.Ban) string {
+	switch {
+	case ban.ReleaseFrom > 0 && ban.ReleaseFrom == ban.ReleaseTo:
+		return fmt.Sprintf("%d", ban.ReleaseFrom)
+	case ban.ReleaseFrom > 0 && ban.ReleaseTo > 0:
+		return fmt.Sprintf("%d-%d", ban.ReleaseFrom, ban.ReleaseTo)
+	case ban.ReleaseFrom > 0:
+		return fmt.Sprintf(">= %d", ban.ReleaseFrom)
+	case ban.ReleaseTo > 0:
+		return fmt.Sprintf("<= %d", ban.ReleaseTo)
+	}
+	return "all"
+}
+
+func banList(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "ban list takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	bans, err := client.GetBans(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(bans)
+		return
+	}
+	if len(bans) == 0 {
+		fmt.Printf("Nothing is banned from '%s'.\n", args[0])
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Type", "Releases", "Reason"})
+	table.SetBorder(false)
+	for i := range bans {
+		kind := "package"
+		if bans[i].Source {
+			kind = "source"
+		}
+		table.Append([]string{
+			bans[i].Name,
+			kind,
+			formatBanReleases(&bans[i]),
+			bans[i].Reason,
+		})
+	}
+	table.Render()
+}
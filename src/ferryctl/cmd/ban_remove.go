@@ -0,0 +1,59 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var banRemoveCmd = &cobra.Command{
+	Use:   "remove [repoName] [name]",
+	Short: "lift a ban from a repository",
+	Long:  "Lift the ban covering exactly the given name and releases, as shown by ban list",
+	Run:   banRemove,
+}
+
+func init() {
+	banRemoveCmd.Flags().BoolVar(&banSource, "source", false, "The ban is on a source")
+	banRemoveCmd.Flags().IntVar(&banReleaseFrom, "from", 0, "Lowest release of the ban")
+	banRemoveCmd.Flags().IntVar(&banReleaseTo, "to", 0, "Highest release of the ban")
+	BanCmd.AddCommand(banRemoveCmd)
+}
+
+func banRemove(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: ban remove [repoName] [name]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	ban := libferry.Ban{
+		Name:        args[1],
+		Source:      banSource,
+		ReleaseFrom: banReleaseFrom,
+		ReleaseTo:   banReleaseTo,
+	}
+	if err := client.RemoveBan(args[0], ban); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -19,7 +19,6 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
-	"libferry"
 	"os"
 )
 
@@ -45,10 +44,15 @@ func cloneRepo(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	if err := client.CloneRepo(args[0], args[1], fullClone); err != nil {
+	jobID, err := client.CloneRepo(args[0], args[1], fullClone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
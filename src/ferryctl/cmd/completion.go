@@ -0,0 +1,305 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"libferry"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of positional argument which may be completed
+const (
+	completeRepo    = "repo"    // Repository name
+	completePackage = "package" // Package name within the repository named first
+	completePool    = "pool"    // Pool ID
+	completeEvent   = "event"   // Daemon event name
+	completeFile    = "file"    // Local path, left to the shell
+)
+
+const (
+	// completeArgsAnnotation lists the kind of each positional argument of a
+	// command. A kind ending in "..." applies to every remaining argument.
+	completeArgsAnnotation = "ferryctl_complete_args"
+
+	// completeTimeout bounds how long the daemon is given to answer, as the
+	// user is waiting on the shell
+	completeTimeout = 2 * time.Second
+
+	// completeFilesReply is printed when the shell should complete paths
+	// itself
+	completeFilesReply = ":files"
+)
+
+// completeBashFunction is called by the cobra generated script whenever it
+// has nothing to offer, i.e. for positional arguments
+const completeBashFunction = `__custom_func()
+{
+    local out
+    out=$(ferryctl __complete "${words[@]:1:$((cword-1))}" "${cur}" 2>/dev/null | cut -f1) || return
+    [[ ${out} == "` + completeFilesReply + `" ]] && return
+    COMPREPLY=( $(compgen -W "${out}" -- "${cur}") )
+}
+`
+
+// completeBashAlias lets the script generated for "ferry" complete ferryctl,
+// which it only recognises by its first word
+const completeBashAlias = `
+__start_ferryctl()
+{
+    local first=${COMP_WORDS[0]}
+    COMP_WORDS[0]=ferry
+    COMP_LINE=ferry${COMP_LINE#"${first}"}
+    COMP_POINT=$((COMP_POINT - ${#first} + 5))
+    __start_ferry
+}
+
+complete -o default -F __start_ferryctl ferryctl
+`
+
+// completeFishScript hands every completion to ferryctl itself, which also
+// gives fish the descriptions of each command and flag
+const completeFishScript = `function __ferry_complete
+    set -l words (commandline -opc)
+    set -l out (ferryctl __complete $words[2..-1] (commandline -ct) 2>/dev/null)
+    if test "$out" = "` + completeFilesReply + `"
+        __fish_complete_path (commandline -ct)
+        return
+    end
+    printf '%s\n' $out
+end
+
+complete -c ferryctl -f -a '(__ferry_complete)'
+complete -c ferry -f -a '(__ferry_complete)'
+`
+
+var completionCmd = &cobra.Command{
+	Use:   "completion [bash|zsh|fish]",
+	Short: "generate shell completions",
+	Long:  "Print a completion script for the shell, completing repository and package names by asking ferryd",
+	Run:   generateCompletion,
+}
+
+// completeCmd is invoked by the completion scripts with the words typed so
+// far, the last being the word under the cursor
+var completeCmd = &cobra.Command{
+	Use:                "__complete [word...]",
+	Run:                complete,
+	Hidden:             true,
+	DisableFlagParsing: true,
+}
+
+// completeArgs will record the kind of each positional argument of the
+// commands for completion
+func completeArgs(kinds string, cmds ...*cobra.Command) {
+	for _, c := range cmds {
+		if c.Annotations == nil {
+			c.Annotations = make(map[string]string)
+		}
+		c.Annotations[completeArgsAnnotation] = kinds
+	}
+}
+
+func init() {
+	completeArgs("repo", assetListCmd, assetShowCmd, banListCmd, deltaCmd, deltaSkippedCmd,
+		deltaStatsCmd, historyCmd, holdAddCmd, holdListCmd, holdRemoveCmd, importDirectoryCmd, indexCmd,
+		listPackagesCmd, listPackagesRootCmd, relinkCmd, removeRepoCmd,
+		removeSourceCmd, repoConfigCmd, reportCmd, snapshotCreateCmd, snapshotDeleteCmd,
+		snapshotListCmd, snapshotRestoreCmd, trimDeltasCmd, trimObsoleteCmd,
+		trimPackagesCmd, validateIndexCmd)
+	completeArgs("repo repo", cloneRepoCmd, copySourceCmd, diffCmd, promoteCmd,
+		pullRepoCmd, pullSourceCmd)
+	completeArgs("repo package", banAddCmd, banRemoveCmd, deltaRetryCmd, showCmd)
+	completeArgs("repo file...", importCmd)
+	completeArgs("repo file", assetSetCmd, exportRepoCmd)
+	completeArgs("file", backupDbCmd, importRepoCmd)
+	completeArgs("pool", rewriteMetaCmd)
+	completeArgs("event...", eventsCmd)
+
+	RootCmd.AddCommand(completionCmd)
+	RootCmd.AddCommand(completeCmd)
+}
+
+func generateCompletion(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: completion [bash|zsh|fish]\n")
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "bash":
+		err = writeBashCompletion(os.Stdout)
+	case "zsh":
+		// zsh is perfectly able to run the bash script, which knows far
+		// more than the one cobra generates for zsh
+		fmt.Printf("#compdef ferryctl ferry\n\nautoload -U +X bashcompinit && bashcompinit\n\n")
+		err = writeBashCompletion(os.Stdout)
+	case "fish":
+		_, err = os.Stdout.WriteString(completeFishScript)
+	default:
+		fmt.Fprintf(os.Stderr, "Unsupported shell: %s\n", args[0])
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// writeBashCompletion will generate the bash script for both ferry and
+// ferryctl
+func writeBashCompletion(w *os.File) error {
+	RootCmd.BashCompletionFunction = completeBashFunction
+	buf := &bytes.Buffer{}
+	if err := RootCmd.GenBashCompletion(buf); err != nil {
+		return err
+	}
+	buf.WriteString(completeBashAlias)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// completeKind returns the kind of the nth positional argument of the command
+func completeKind(c *cobra.Command, n int) string {
+	kinds := strings.Fields(c.Annotations[completeArgsAnnotation])
+	if len(kinds) == 0 {
+		return ""
+	}
+	if n >= len(kinds) {
+		last := kinds[len(kinds)-1]
+		if !strings.HasSuffix(last, "...") {
+			return ""
+		}
+		n = len(kinds) - 1
+	}
+	return strings.TrimSuffix(kinds[n], "...")
+}
+
+// completeFlags returns every visible flag of the command with its usage
+func completeFlags(c *cobra.Command) []string {
+	var ret []string
+	add := func(f *pflag.Flag) {
+		if f.Hidden || f.Deprecated != "" {
+			return
+		}
+		ret = append(ret, fmt.Sprintf("--%s\t%s", f.Name, f.Usage))
+		if f.Shorthand != "" {
+			ret = append(ret, fmt.Sprintf("-%s\t%s", f.Shorthand, f.Usage))
+		}
+	}
+	c.NonInheritedFlags().VisitAll(add)
+	c.InheritedFlags().VisitAll(add)
+	return ret
+}
+
+// completeNames asks the daemon for the names of the given kind, quietly
+// giving up if it can't be reached
+func completeNames(kind string, args []string) []string {
+	client := dialClient()
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+	defer cancel()
+
+	var names []string
+	switch kind {
+	case completeRepo:
+		names, _ = client.GetReposContext(ctx)
+	case completePackage:
+		if len(args) == 0 {
+			return nil
+		}
+		pkgs, err := client.ListPackagesContext(ctx, args[0], "", 0, 0)
+		if err != nil {
+			return nil
+		}
+		for _, p := range pkgs.Items {
+			names = append(names, p.Name)
+		}
+	case completePool:
+		items, err := client.GetPoolItemsContext(ctx)
+		if err != nil {
+			return nil
+		}
+		for _, item := range items {
+			names = append(names, item.ID)
+		}
+	case completeEvent:
+		names = []string{
+			libferry.EventJobQueued,
+			libferry.EventJobStarted,
+			libferry.EventJobCompleted,
+			libferry.EventJobFailed,
+			libferry.EventJobOverdue,
+			libferry.EventIndexPublished,
+			libferry.EventRepoCreated,
+			libferry.EventRepoDeleted,
+			"disk.low",
+			"repo.quota",
+			"publish.failed",
+		}
+	}
+	return names
+}
+
+// completeWords returns the candidates for the word under the cursor, given
+// the words before it. Subcommands and flags carry a tab separated
+// description, which fish shows and bash ignores.
+func completeWords(words []string, cur string) []string {
+	c, rest, _ := RootCmd.Find(words)
+	if strings.HasPrefix(cur, "-") {
+		return completeFlags(c)
+	}
+
+	// Pick up --socket so that we ask the right daemon
+	c.ParseFlags(rest)
+	args := c.Flags().Args()
+
+	if c.HasAvailableSubCommands() && len(args) == 0 {
+		var ret []string
+		for _, sub := range c.Commands() {
+			if sub.IsAvailableCommand() {
+				ret = append(ret, fmt.Sprintf("%s\t%s", sub.Name(), sub.Short))
+			}
+		}
+		return ret
+	}
+
+	kind := completeKind(c, len(args))
+	if kind == completeFile {
+		return []string{completeFilesReply}
+	}
+	names := completeNames(kind, args)
+	sort.Strings(names)
+	return names
+}
+
+func complete(cmd *cobra.Command, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	candidates := completeWords(args[:len(args)-1], args[len(args)-1])
+	for _, candidate := range candidates {
+		fmt.Println(candidate)
+	}
+}
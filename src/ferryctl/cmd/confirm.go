@@ -0,0 +1,70 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"libferry"
+	"os"
+	"strings"
+)
+
+var (
+	// Skip the confirmation prompt for destructive commands
+	forceDestructive = false
+
+	// errNotConfirmed is returned when the user declines the prompt
+	errNotConfirmed = errors.New("Aborted")
+)
+
+// promptYesNo will ask the user the question, returning true only if they
+// explicitly answered yes
+func promptYesNo(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// runConfirmed will run a destructive call, asking the user to confirm the
+// action when ferryd requires it, unless --force was passed. The ID of the
+// job queued by the call is returned.
+func runConfirmed(call func(conf libferry.Confirmation) (string, error)) (string, error) {
+	if forceDestructive {
+		return call(libferry.Confirmation{Force: true})
+	}
+
+	jobID, err := call(libferry.Confirmation{})
+	cerr, ok := err.(*libferry.ConfirmationError)
+	if !ok {
+		return jobID, err
+	}
+	if !promptYesNo(cerr.Action + "?") {
+		return "", errNotConfirmed
+	}
+	return call(libferry.Confirmation{Token: cerr.Token})
+}
@@ -19,7 +19,6 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
-	"libferry"
 	"os"
 	"strconv"
 )
@@ -69,10 +68,15 @@ func copySource(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	if err := client.CopySource(repoID, targetID, sourceID, sourceRelease); err != nil {
+	jobID, err := client.CopySource(repoID, targetID, sourceID, sourceRelease)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
@@ -19,7 +19,6 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
-	"libferry"
 	"os"
 )
 
@@ -40,10 +39,15 @@ func createRepo(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	if err := client.CreateRepo(args[0]); err != nil {
+	jobID, err := client.CreateRepo(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
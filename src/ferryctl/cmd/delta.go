@@ -18,8 +18,8 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"libferry"
 	"os"
 )
 
@@ -30,7 +30,26 @@ var deltaCmd = &cobra.Command{
 	Run:   delta,
 }
 
+var deltaRetryCmd = &cobra.Command{
+	Use:   "retry [repo] [package]",
+	Short: "Retry failed deltas",
+	Long:  "Forget which deltas of the package failed, and attempt them again",
+	Run:   deltaRetry,
+}
+
+var deltaSkippedCmd = &cobra.Command{
+	Use:   "skipped [repo]",
+	Short: "List skipped deltas",
+	Long:  "List the deltas of the repository which are no longer attempted, as they were pointless or failed",
+	Run:   deltaSkipped,
+}
+
+var deltaHistory string
+
 func init() {
+	deltaCmd.Flags().StringVar(&deltaHistory, "history", "", "Also produce deltas from older releases in this repository")
+	deltaCmd.AddCommand(deltaRetryCmd)
+	deltaCmd.AddCommand(deltaSkippedCmd)
 	RootCmd.AddCommand(deltaCmd)
 }
 
@@ -40,11 +59,80 @@ func delta(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.DeltaRepo(args[0], deltaHistory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
+
+func deltaRetry(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: delta retry [repo] [package]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.RetryDeltas(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
+
+func deltaSkipped(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: delta skipped [repo]\n")
+		return
+	}
+
+	client := newClient()
 	defer client.Close()
 
-	if err := client.DeltaRepo(args[0]); err != nil {
+	skips, err := client.GetDeltaSkips(args[0])
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
+	if jsonOutput {
+		printJSON(skips)
+		return
+	}
+	if len(skips) == 0 {
+		fmt.Printf("No deltas are skipped\n")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Delta", "Releases", "Reason", "Failures", "Retry"})
+	table.SetBorder(false)
+	for _, s := range skips {
+		retry := "never"
+		if s.Expired {
+			retry = "next run"
+		} else if !s.Expires.IsZero() {
+			retry = s.Expires.Local().Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{
+			s.ID,
+			fmt.Sprintf("%d -> %d", s.FromRelease, s.ToRelease),
+			s.Reason,
+			fmt.Sprintf("%d", s.Failures),
+			retry,
+		})
+	}
+	table.Render()
 }
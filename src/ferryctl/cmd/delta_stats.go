@@ -0,0 +1,91 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var deltaStatsCmd = &cobra.Command{
+	Use:   "delta-stats [repoName]",
+	Short: "show how effective deltas are",
+	Long:  "Show how much the deltas of a repository save over their full packages, and which deltas save the least",
+	Run:   deltaStats,
+}
+
+var deltaStatsLimit int
+
+func init() {
+	deltaStatsCmd.Flags().IntVarP(&deltaStatsLimit, "limit", "n", 10, "Show at most this many of the least effective deltas (0 for all)")
+	RootCmd.AddCommand(deltaStatsCmd)
+}
+
+// formatRatio will print the size ratio as a percentage
+func formatRatio(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
+func deltaStats(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "delta-stats takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	stats, err := client.GetDeltaStats(args[0], deltaStatsLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(stats)
+		return
+	}
+
+	fmt.Printf("Repository        : %s\n", stats.Repo)
+	fmt.Printf("Deltas            : %d (%d in the index)\n", stats.Deltas, stats.Current)
+	if stats.Unknown > 0 {
+		fmt.Printf("Unmeasured        : %d (full package no longer known)\n", stats.Unknown)
+	}
+	fmt.Printf("Delta size        : %s\n", formatBytes(uint64(stats.Bytes)))
+	fmt.Printf("Full package size : %s\n", formatBytes(uint64(stats.TargetBytes)))
+	fmt.Printf("Saved per fetch   : %s (deltas are %s of the full size)\n",
+		formatBytes(uint64(stats.Saved)), formatRatio(stats.Ratio))
+	if len(stats.Worst) == 0 {
+		return
+	}
+
+	fmt.Printf("\nLeast effective deltas:\n")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Delta", "Releases", "Size", "Full size", "Ratio"})
+	table.SetBorder(false)
+	for _, d := range stats.Worst {
+		table.Append([]string{
+			d.ID,
+			fmt.Sprintf("%d -> %d", d.FromRelease, d.ToRelease),
+			formatBytes(uint64(d.Size)),
+			formatBytes(uint64(d.TargetSize)),
+			formatRatio(d.Ratio),
+		})
+	}
+	table.Render()
+}
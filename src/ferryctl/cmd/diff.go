@@ -0,0 +1,96 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [sourceRepo] [targetRepo]",
+	Short: "compare two repositories",
+	Long:  "Show what a pull from the source repository into the target would change",
+	Run:   diffRepos,
+}
+
+func init() {
+	RootCmd.AddCommand(diffCmd)
+}
+
+// formatRelease will print the version-release, or a dash if absent
+func formatRelease(version string, release int) string {
+	if release == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s-%d", version, release)
+}
+
+// printDiffItems will print a single section of the diff
+func printDiffItems(title string, items []libferry.DiffItem, source, target string) {
+	if len(items) == 0 {
+		return
+	}
+	fmt.Printf("%s: (%d)\n\n", title, len(items))
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", source, target})
+	table.SetBorder(false)
+	for _, i := range items {
+		table.Append([]string{
+			i.Name,
+			formatRelease(i.SourceVersion, i.SourceRelease),
+			formatRelease(i.TargetVersion, i.TargetRelease),
+		})
+	}
+	table.Render()
+	fmt.Println()
+}
+
+func diffRepos(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: diff [sourceRepo] [targetRepo]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	diff, err := client.DiffRepos(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(diff)
+		return
+	}
+
+	if len(diff.Newer)+len(diff.Missing)+len(diff.Obsolete)+len(diff.Inconsistent)+len(diff.Held)+len(diff.Banned) == 0 {
+		fmt.Printf("'%s' is up to date with '%s'.\n", diff.Target, diff.Source)
+		return
+	}
+
+	printDiffItems("Newer in "+diff.Source, diff.Newer, diff.Source, diff.Target)
+	printDiffItems("Missing from "+diff.Target, diff.Missing, diff.Source, diff.Target)
+	printDiffItems("Only in "+diff.Target, diff.Obsolete, diff.Source, diff.Target)
+	printDiffItems("Newer in "+diff.Target+" (pull will fail)", diff.Inconsistent, diff.Source, diff.Target)
+	printDiffItems("Held in "+diff.Target, diff.Held, diff.Source, diff.Target)
+	printDiffItems("Banned in "+diff.Target, diff.Banned, diff.Source, diff.Target)
+}
@@ -0,0 +1,68 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events [event...]",
+	Short: "stream daemon events",
+	Long:  "Print each event from the daemon as it happens, optionally only those named, i.e. job.failed or index.published",
+	Run:   streamEvents,
+}
+
+func init() {
+	RootCmd.AddCommand(eventsCmd)
+}
+
+// printDaemonEvent will print a single line for the event
+func printDaemonEvent(e *libferry.Event) error {
+	if jsonOutput {
+		printJSON(e)
+		return nil
+	}
+	subject := e.Repo
+	if e.Job != nil {
+		subject = e.Job.Description
+		if e.Job.Failed {
+			subject += ": " + e.Job.Error
+		}
+	}
+	if e.Message != "" {
+		if subject != "" {
+			subject += ": "
+		}
+		subject += e.Message
+	}
+	fmt.Printf("%s %-15s %s\n", e.Time.Local().Format("15:04:05"), e.Event, subject)
+	return nil
+}
+
+func streamEvents(cmd *cobra.Command, args []string) {
+	client := newClient()
+	defer client.Close()
+
+	if err := client.Events(args, 0, printDaemonEvent); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
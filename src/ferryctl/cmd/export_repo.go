@@ -0,0 +1,68 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var exportRepoCmd = &cobra.Command{
+	Use:   "export-repo [repo] [file.tar]",
+	Short: "export a repository to an archive",
+	Long:  "Write the repository, with its settings and every package and delta it holds, to a tar archive which import-repo can load into another ferryd",
+	Run:   exportRepo,
+}
+
+func init() {
+	RootCmd.AddCommand(exportRepoCmd)
+}
+
+func exportRepo(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "export-repo takes exactly 2 arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+	// Whole repositories take a while, so only limit them when asked to
+	if !cmd.Flags().Changed("timeout") {
+		client.SetTimeout(0)
+	}
+
+	// Only put the archive in place once it's complete
+	partPath := args[1] + ".partial"
+	out, err := os.Create(partPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	err = client.ExportRepo(args[0], out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partPath, args[1])
+	}
+	if err != nil {
+		os.Remove(partPath)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
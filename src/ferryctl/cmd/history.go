@@ -0,0 +1,92 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history [repoName]",
+	Short: "show the change history of a repository",
+	Long:  "Show every operation which changed the packages in a repository, newest first",
+	Run:   history,
+}
+
+var (
+	historyPackage string
+	historyLimit   int
+)
+
+func init() {
+	historyCmd.Flags().StringVarP(&historyPackage, "package", "p", "", "Only show changes to this package")
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 0, "Show at most this many entries (0 for all)")
+	RootCmd.AddCommand(historyCmd)
+}
+
+// printHistoryItem will print the operation followed by each package it
+// added or removed
+func printHistoryItem(item *libferry.HistoryItem) {
+	fmt.Printf("%s  %s  [job %s]\n", item.Time.Local().Format("2006-01-02 15:04:05"), item.Description, item.JobID)
+	if item.Error != "" {
+		fmt.Printf("    failed: %s\n", item.Error)
+	}
+	for _, p := range item.Added {
+		if historyPackage == "" || p.Name == historyPackage {
+			fmt.Printf("    + %s\n", p.ID)
+		}
+	}
+	for _, p := range item.Removed {
+		if historyPackage == "" || p.Name == historyPackage {
+			fmt.Printf("    - %s\n", p.ID)
+		}
+	}
+}
+
+func history(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: history [repoName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	items, err := client.GetHistory(args[0], historyPackage, historyLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		if items == nil {
+			items = []libferry.HistoryItem{}
+		}
+		printJSON(items)
+		return
+	}
+	if len(items) == 0 {
+		fmt.Printf("No history found for '%s'.\n", args[0])
+		return
+	}
+
+	for i := range items {
+		printHistoryItem(&items[i])
+	}
+}
@@ -0,0 +1,49 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var holdAddCmd = &cobra.Command{
+	Use:   "add [repoName] [sourceName...]",
+	Short: "hold sources in a repository",
+	Long:  "Freeze the named sources in the repository, so that pulls and promotions skip them even if a newer release is available",
+	Run:   holdAdd,
+}
+
+func init() {
+	HoldCmd.AddCommand(holdAddCmd)
+}
+
+func holdAdd(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: hold add [repoName] [sourceName...]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	if err := client.HoldSources(args[0], args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
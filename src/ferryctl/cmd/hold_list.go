@@ -0,0 +1,61 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var holdListCmd = &cobra.Command{
+	Use:   "list [repoName]",
+	Short: "list sources held in a repository",
+	Long:  "List the sources which pulls and promotions into the repository will skip",
+	Run:   holdList,
+}
+
+func init() {
+	HoldCmd.AddCommand(holdListCmd)
+}
+
+func holdList(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "hold list takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	sources, err := client.GetHeld(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(sources)
+		return
+	}
+	if len(sources) == 0 {
+		fmt.Printf("No sources are held in '%s'.\n", args[0])
+		return
+	}
+	for _, source := range sources {
+		fmt.Println(source)
+	}
+}
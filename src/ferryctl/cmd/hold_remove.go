@@ -0,0 +1,49 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var holdRemoveCmd = &cobra.Command{
+	Use:   "remove [repoName] [sourceName...]",
+	Short: "release held sources in a repository",
+	Long:  "Allow the named sources to be pulled and promoted into the repository again",
+	Run:   holdRemove,
+}
+
+func init() {
+	HoldCmd.AddCommand(holdRemoveCmd)
+}
+
+func holdRemove(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: hold remove [repoName] [sourceName...]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	if err := client.UnholdSources(args[0], args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
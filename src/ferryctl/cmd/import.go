@@ -19,7 +19,6 @@ package cmd
 import (
 	"fmt"
 	"github.com/spf13/cobra"
-	"libferry"
 	"os"
 	"path/filepath"
 )
@@ -41,7 +40,7 @@ func importEx(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
 	repoID := args[0]
@@ -59,7 +58,12 @@ func importEx(cmd *cobra.Command, args []string) {
 		packages = append(packages, f)
 	}
 
-	if err := client.ImportPackages(repoID, packages); err != nil {
+	jobID, err := client.ImportPackages(repoID, packages)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Import error: %v\n", err)
 		return
 	}
@@ -0,0 +1,66 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+var (
+	importRecursive bool
+)
+
+var importDirectoryCmd = &cobra.Command{
+	Use:   "import-directory [repo] [path]",
+	Short: "Import every package in a directory into repository",
+	Long:  "Add every package within a directory on the server to the named repository",
+	Run:   importDirectory,
+}
+
+func init() {
+	importDirectoryCmd.PersistentFlags().BoolVarP(&importRecursive, "recursive", "r", false, "Include packages in subdirectories")
+	RootCmd.AddCommand(importDirectoryCmd)
+}
+
+func importDirectory(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "import-directory takes exactly 2 arguments\n")
+		return
+	}
+
+	path, err := filepath.Abs(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to probe: %s: %v\n", args[1], err)
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.ImportDirectory(args[0], path, importRecursive)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Import error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Import error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,69 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var importRepoCmd = &cobra.Command{
+	Use:   "import-repo [file.tar] [name]",
+	Short: "import a repository from an archive",
+	Long:  "Create a repository from an archive written by export-repo, optionally under a new name. The repository must not already exist",
+	Run:   importRepo,
+}
+
+func init() {
+	RootCmd.AddCommand(importRepoCmd)
+}
+
+func importRepo(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "import-repo takes 1 or 2 arguments\n")
+		return
+	}
+	name := ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	defer in.Close()
+
+	client := newClient()
+	defer client.Close()
+	// Whole repositories take a while, so only limit them when asked to
+	if !cmd.Flags().Changed("timeout") {
+		client.SetTimeout(0)
+	}
+
+	jobID, err := client.ImportRepo(in, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,114 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var listJobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List finished jobs",
+	Long:  "List the jobs ferryd still remembers completing, newest first",
+	Run:   listJobs,
+}
+
+var (
+	listJobsFailed bool
+	listJobsRepo   string
+	listJobsOffset int
+	listJobsLimit  int
+)
+
+func init() {
+	listJobsCmd.Flags().BoolVarP(&listJobsFailed, "failed", "f", false, "List the failed jobs instead")
+	listJobsCmd.Flags().StringVarP(&listJobsRepo, "repo", "r", "", "Only list jobs acting on this repository")
+	listJobsCmd.Flags().IntVar(&listJobsOffset, "offset", 0, "Skip this many jobs")
+	listJobsCmd.Flags().IntVarP(&listJobsLimit, "limit", "n", 0, "Show at most this many jobs (0 for all)")
+	ListCmd.AddCommand(listJobsCmd)
+}
+
+func listJobs(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "usage: list jobs\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	list := client.ListCompletedJobs
+	if listJobsFailed {
+		list = client.ListFailedJobs
+	}
+	jobs, err := list(listJobsRepo, listJobsOffset, listJobsLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(jobs)
+		return
+	}
+	if len(jobs.Items) == 0 {
+		fmt.Printf("No matching jobs found.\n")
+		return
+	}
+
+	printJobListing(jobs.Items, listJobsFailed)
+
+	if len(jobs.Items) < jobs.Total {
+		fmt.Printf("\nShowing %d-%d of %d jobs\n", jobs.Offset+1, jobs.Offset+len(jobs.Items), jobs.Total)
+	}
+}
+
+// printJobListing will print every finished job, with the error for those
+// which failed
+func printJobListing(js []*libferry.Job, failed bool) {
+	header := []string{
+		"ID",
+		"Completed",
+		"Duration",
+		"Repo",
+		"Description",
+	}
+	if failed {
+		header = append(header, "Error")
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+
+	for _, j := range js {
+		row := []string{
+			j.ID,
+			j.Timing.End.Format("2006-01-02 15:04:05"),
+			j.ExecutionTime().String(),
+			j.Repo,
+			j.Description,
+		}
+		if failed {
+			row = append(row, j.Error)
+		}
+		table.Append(row)
+	}
+	table.Render()
+}
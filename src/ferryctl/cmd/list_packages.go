@@ -0,0 +1,103 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var listPackagesCmd = &cobra.Command{
+	Use:   "packages [repoName]",
+	Short: "List the packages in a repository",
+	Long:  "List the published release of every package in a repository",
+	Run:   listPackages,
+}
+
+// listPackagesRootCmd keeps "ferryctl list-packages" working alongside
+// "ferryctl list packages"
+var listPackagesRootCmd = &cobra.Command{
+	Use:    "list-packages [repoName]",
+	Short:  listPackagesCmd.Short,
+	Long:   listPackagesCmd.Long,
+	Run:    listPackages,
+	Hidden: true,
+}
+
+var (
+	listPackagesGlob   string
+	listPackagesOffset int
+	listPackagesLimit  int
+)
+
+func init() {
+	for _, c := range []*cobra.Command{listPackagesCmd, listPackagesRootCmd} {
+		c.Flags().StringVarP(&listPackagesGlob, "glob", "g", "", "Only list package names matching this pattern")
+		c.Flags().IntVar(&listPackagesOffset, "offset", 0, "Skip this many packages")
+		c.Flags().IntVarP(&listPackagesLimit, "limit", "n", 0, "Show at most this many packages (0 for all)")
+	}
+	ListCmd.AddCommand(listPackagesCmd)
+	RootCmd.AddCommand(listPackagesRootCmd)
+}
+
+func listPackages(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: list packages [repoName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	pkgs, err := client.ListPackages(args[0], listPackagesGlob, listPackagesOffset, listPackagesLimit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(pkgs)
+		return
+	}
+	if len(pkgs.Items) == 0 {
+		fmt.Printf("No matching packages found in '%s'.\n", args[0])
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Name",
+		"Version",
+		"Release",
+		"Size",
+	})
+	table.SetBorder(false)
+	for _, p := range pkgs.Items {
+		table.Append([]string{
+			p.Name,
+			p.Version,
+			fmt.Sprintf("%d", p.Release),
+			formatBytes(uint64(p.Size)),
+		})
+	}
+	table.Render()
+
+	if len(pkgs.Items) < pkgs.Total {
+		fmt.Printf("\nShowing %d-%d of %d packages\n", pkgs.Offset+1, pkgs.Offset+len(pkgs.Items), pkgs.Total)
+	}
+}
@@ -25,12 +25,23 @@ import (
 
 var listPoolCmd = &cobra.Command{
 	Use:   "pool",
-	Short: "List all pool items",
-	Long:  "List all the entries currently stored in the pool",
+	Short: "List the pool items",
+	Long:  "List the entries currently stored in the pool, optionally only those matching a prefix or no longer referenced",
 	Run:   listPool,
 }
 
+var (
+	listPoolPrefix   string
+	listPoolOrphaned bool
+	listPoolOffset   int
+	listPoolLimit    int
+)
+
 func init() {
+	listPoolCmd.Flags().StringVarP(&listPoolPrefix, "prefix", "p", "", "Only list items whose ID begins with this")
+	listPoolCmd.Flags().BoolVar(&listPoolOrphaned, "orphaned", false, "Only list items no repository refers to")
+	listPoolCmd.Flags().IntVar(&listPoolOffset, "offset", 0, "Skip this many items")
+	listPoolCmd.Flags().IntVarP(&listPoolLimit, "limit", "n", 0, "Show at most this many items (0 for all)")
 	ListCmd.AddCommand(listPoolCmd)
 }
 
@@ -40,20 +51,40 @@ func listPool(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	pools, err := client.GetPoolItems()
+	listing, err := client.ListPoolItems(listPoolPrefix, listPoolOrphaned, listPoolOffset, listPoolLimit)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
+	pools := listing.Item
+	if jsonOutput {
+		// Scripts expect an empty list, not null
+		if pools == nil {
+			pools = []libferry.PoolItem{}
+		}
+		printJSON(pools)
+		return
+	}
 	if len(pools) == 0 {
+		if listPoolPrefix != "" || listPoolOrphaned || listing.Total > 0 {
+			fmt.Printf("No matching pool items found.\n\n")
+			return
+		}
 		fmt.Printf("No pool items have been created yet.\n\n")
 		return
 	}
 	fmt.Printf("Currently known pool items: \n\n")
 	for _, pool := range pools {
+		if pool.Alias != "" {
+			fmt.Printf(" - RefCount: %d | %v (shares %v)\n", pool.RefCount, pool.ID, pool.Alias)
+			continue
+		}
 		fmt.Printf(" - RefCount: %d | %v\n", pool.RefCount, pool.ID)
 	}
+	if len(pools) < listing.Total {
+		fmt.Printf("\nShowing %d-%d of %d items\n", listing.Offset+1, listing.Offset+len(pools), listing.Total)
+	}
 }
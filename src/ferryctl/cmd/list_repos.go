@@ -18,16 +18,19 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 	"libferry"
 	"os"
 	"sort"
+	"strconv"
+	"time"
 )
 
 var listReposCmd = &cobra.Command{
 	Use:   "repos",
 	Short: "List the currently known repositories",
-	Long:  "List the currently known repositories",
+	Long:  "List the currently known repositories, with their package counts, size, held sources and when they were last indexed",
 	Run:   listRepos,
 }
 
@@ -41,22 +44,61 @@ func listRepos(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	repos, err := client.GetRepos()
+	repos, err := client.GetRepoSummaries()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
-	sort.Strings(repos)
+	sort.Slice(repos, func(i, j int) bool { return repos[i].ID < repos[j].ID })
+	if jsonOutput {
+		// Scripts expect an empty list, not null
+		if repos == nil {
+			repos = []libferry.RepoSummary{}
+		}
+		printJSON(repos)
+		return
+	}
 	if len(repos) == 0 {
 		fmt.Printf("No repositories have been created yet.\n\n")
 		fmt.Println("Create one with 'ferryctl create-repo $name'.")
 		return
 	}
-	fmt.Printf("Currently registered repositories: \n\n")
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Repository", "Packages", "Deltas", "Size", "Held", "Last indexed", "Index"})
+	table.SetBorder(false)
 	for _, repo := range repos {
-		fmt.Printf(" * %v\n", repo)
+		indexed := "never"
+		if !repo.Indexed.IsZero() {
+			indexed = repo.Indexed.Local().Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{
+			repo.ID,
+			strconv.Itoa(repo.Packages),
+			strconv.Itoa(repo.Deltas),
+			formatBytes(uint64(repo.Size)),
+			strconv.Itoa(repo.Held),
+			indexed,
+			indexState(&repo),
+		})
+	}
+	table.Render()
+}
+
+// indexState will describe the last index of the repository, and whether
+// it is out of date
+func indexState(repo *libferry.RepoSummary) string {
+	switch {
+	case repo.LastIndex.Failed():
+		return "failed: " + repo.LastIndex.Error
+	case repo.Dirty:
+		return "dirty"
+	case repo.LastIndex.Begin.IsZero():
+		return "-"
+	default:
+		return fmt.Sprintf("ok (%v)", repo.LastIndex.Duration().Round(time.Millisecond))
 	}
 }
@@ -0,0 +1,94 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "show database schema migrations",
+	Long:  "ferryd migrates old records to the current schema when it starts, and refuses to run against a newer schema. Use --status to see the state of each kind of record",
+	Run:   migrate,
+}
+
+var migrateStatus bool
+
+func init() {
+	migrateCmd.Flags().BoolVar(&migrateStatus, "status", false, "Show the schema of every kind of record and the migrations applied")
+	RootCmd.AddCommand(migrateCmd)
+}
+
+func migrate(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "migrate takes no arguments\n")
+		return
+	}
+	if !migrateStatus {
+		fmt.Fprintf(os.Stderr, "Migrations are applied when ferryd starts, use --status to view them\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	status, err := client.GetMigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(status)
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Kind", "Current", "Database", "Pending"})
+	table.SetBorder(false)
+	for _, k := range status.Kinds {
+		database := k.Database
+		if database == "" {
+			database = "unknown"
+		}
+		pending := strings.Join(k.Pending, "\n")
+		if pending == "" {
+			pending = "-"
+		}
+		table.Append([]string{
+			k.Kind,
+			k.Current,
+			database,
+			pending,
+		})
+	}
+	table.Render()
+
+	if len(status.Applied) == 0 {
+		fmt.Printf("\nNo migrations have been applied.\n")
+		return
+	}
+	fmt.Printf("\nApplied migrations:\n")
+	for _, m := range status.Applied {
+		fmt.Printf("  %s  %s %s -> %s: %s (%d records)\n", m.Time.Local().Format("2006-01-02 15:04:05"),
+			m.Kind, m.From, m.To, m.Description, m.Records)
+	}
+}
@@ -0,0 +1,159 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+	"sort"
+	"time"
+)
+
+var monitorCmd = &cobra.Command{
+	Use:   "monitor",
+	Short: "follow job activity",
+	Long:  "Print each job as it is queued, started and completed until interrupted",
+	Run:   monitor,
+}
+
+func init() {
+	RootCmd.AddCommand(monitorCmd)
+}
+
+// A monitorEvent is a single line of output from the monitor
+type monitorEvent struct {
+	When  time.Time     `json:"time"`
+	Event string        `json:"event"` // queued, running, completed, failed
+	Job   *libferry.Job `json:"job"`
+}
+
+// jobKey returns a key to track a job between status updates. Older
+// daemons don't report an ID so we fall back to the queue time.
+func jobKey(j *libferry.Job) string {
+	if j.ID != "" {
+		return j.ID
+	}
+	return j.Description + j.Timing.Queued.String()
+}
+
+// A jobTracker remembers the state of every job we've seen so that we only
+// print the changes between status updates
+type jobTracker struct {
+	running  map[string]bool // Active jobs, true once they've begun
+	finished map[string]bool // Completed or failed jobs already reported
+}
+
+// update will compare the status against the previously seen state and
+// return the events that happened in between
+func (t *jobTracker) update(status *libferry.StatusRequest) []*monitorEvent {
+	var events []*monitorEvent
+
+	running := make(map[string]bool)
+	for _, j := range status.CurrentJobs {
+		key := jobKey(j)
+		began := !j.Timing.Begin.IsZero()
+		running[key] = began
+
+		seen, known := t.running[key]
+		if !known {
+			events = append(events, &monitorEvent{When: j.Timing.Queued, Event: "queued", Job: j})
+		}
+		if began && !seen {
+			events = append(events, &monitorEvent{When: j.Timing.Begin, Event: "running", Job: j})
+		}
+	}
+	t.running = running
+
+	finished := func(js libferry.JobSet, event string) {
+		for _, j := range js {
+			key := jobKey(j)
+			if t.finished[key] {
+				continue
+			}
+			t.finished[key] = true
+			events = append(events, &monitorEvent{When: j.Timing.End, Event: event, Job: j})
+		}
+	}
+	finished(status.CompletedJobs, "completed")
+	finished(status.FailedJobs, "failed")
+
+	sort.SliceStable(events, func(a, b int) bool {
+		return events[a].When.Before(events[b].When)
+	})
+	return events
+}
+
+// printEvent will print a single line for the event
+func printEvent(e *monitorEvent) {
+	if jsonOutput {
+		printJSON(e)
+		return
+	}
+	detail := ""
+	switch e.Event {
+	case "completed":
+		detail = fmt.Sprintf(" (%s)", e.Job.ExecutionTime().Truncate(time.Millisecond))
+	case "failed":
+		detail = fmt.Sprintf(" (%s): %s", e.Job.ExecutionTime().Truncate(time.Millisecond), e.Job.Error)
+	}
+	fmt.Printf("%s %-9s %s%s\n", e.When.Local().Format("15:04:05"), e.Event, e.Job.Description, detail)
+}
+
+func monitor(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "monitor takes no arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	status, err := client.GetStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	// Existing history isn't interesting, only show what's live right now
+	tracker := &jobTracker{
+		finished: make(map[string]bool),
+	}
+	for _, js := range []libferry.JobSet{status.CompletedJobs, status.FailedJobs} {
+		for _, j := range js {
+			tracker.finished[jobKey(j)] = true
+		}
+	}
+	if !jsonOutput {
+		fmt.Printf("Monitoring ferryd (%d sequential, %d async jobs queued). Press Ctrl+C to exit.\n\n",
+			status.Queues.Sequential, status.Queues.Async)
+	}
+
+	for {
+		for _, e := range tracker.update(status) {
+			printEvent(e)
+		}
+
+		next, err := client.WaitStatus(status.Generation)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		status = next
+	}
+}
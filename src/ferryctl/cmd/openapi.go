@@ -0,0 +1,48 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var openAPICmd = &cobra.Command{
+	Use:   "openapi",
+	Short: "print the ferryd API description",
+	Long:  "Print the OpenAPI 3 document describing the ferryd API, for use with client generators",
+	Run:   printOpenAPI,
+}
+
+func init() {
+	RootCmd.AddCommand(openAPICmd)
+}
+
+func printOpenAPI(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "openapi takes no arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	if err := client.WriteOpenAPI(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
@@ -0,0 +1,72 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+)
+
+var (
+	promoteMove bool
+)
+
+var promoteCmd = &cobra.Command{
+	Use:   "promote [fromRepo] [targetRepo] [sourceName] [releaseNumber]",
+	Short: "promote a source release to another repository",
+	Long: "Copy every package built from the source release into the target repository, " +
+		"as long as its -dbginfo packages and deltas are present and no dependencies " +
+		"would be broken in the target repository",
+	Run: promote,
+}
+
+func init() {
+	promoteCmd.PersistentFlags().BoolVarP(&promoteMove, "move", "m", false, "Remove the release from the original repository once promoted")
+	RootCmd.AddCommand(promoteCmd)
+}
+
+func promote(cmd *cobra.Command, args []string) {
+	if len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "usage: promote [fromRepo] [targetRepo] [sourceName] [releaseNumber]\n")
+		return
+	}
+
+	release, err := strconv.ParseInt(args[3], 10, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid integer: %v\n", err)
+		return
+	}
+	if release < 1 {
+		fmt.Fprintf(os.Stderr, "Release should be higher than 1\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.Promote(args[0], args[1], args[2], int(release), promoteMove)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,60 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var promoteStandbyCmd = &cobra.Command{
+	Use:   "promote-standby",
+	Short: "promote a standby to primary",
+	Long:  "Stop a standby ferryd replicating, and have it accept writes as the new primary. The old primary must have been stopped first.",
+	Run:   promoteStandby,
+}
+
+var (
+	// Promote even though the old primary still answers
+	ignorePrimary = false
+)
+
+func init() {
+	promoteStandbyCmd.Flags().BoolVar(&ignorePrimary, "ignore-primary", false, "Promote even if the primary is still running")
+	RootCmd.AddCommand(promoteStandbyCmd)
+}
+
+func promoteStandby(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "promote-standby takes no arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	_, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return "", client.PromoteStandby(ignorePrimary, conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	fmt.Println("Promoted to primary")
+}
@@ -0,0 +1,54 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var pullSourceCmd = &cobra.Command{
+	Use:   "pull-source [into] [from] [sourceName]",
+	Short: "pull a single source from another repository",
+	Long:  "Pull the tip of the named source, along with its -dbginfo packages, from another repository",
+	Run:   pullSource,
+}
+
+func init() {
+	RootCmd.AddCommand(pullSourceCmd)
+}
+
+func pullSource(cmd *cobra.Command, args []string) {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: pull-source [into] [from] [sourceName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.PullSource(args[1], args[0], args[2])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
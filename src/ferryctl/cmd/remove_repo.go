@@ -40,10 +40,17 @@ func removeRepo(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	if err := client.DeleteRepo(args[0]); err != nil {
+	jobID, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return client.DeleteRepo(args[0], conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
@@ -65,10 +65,17 @@ func removeSource(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
-	if err := client.RemoveSource(repoID, sourceID, sourceRelease); err != nil {
+	jobID, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return client.RemoveSource(repoID, sourceID, sourceRelease, conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
@@ -0,0 +1,180 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+	"strings"
+)
+
+var repoConfigCmd = &cobra.Command{
+	Use:   "repo-config [repoName]",
+	Short: "show or change repository settings",
+	Long:  "Show the settings of a repository, or change them if any flags are given",
+	Run:   repoConfig,
+}
+
+var (
+	repoConfigDeltas      bool
+	repoConfigMaxDeltas   int
+	repoConfigMinDistance int
+	repoConfigMaxSize     int64
+	repoConfigVerify      bool
+	repoConfigConflicts   string
+	repoConfigVerifyIndex bool
+	repoConfigQuota       int64
+	repoConfigArchs       []string
+	repoConfigComponents  bool
+	repoConfigVerifyDelta bool
+	repoConfigVersioned   bool
+)
+
+func init() {
+	repoConfigCmd.Flags().BoolVar(&repoConfigDeltas, "deltas", true, "Produce delta packages")
+	repoConfigCmd.Flags().IntVar(&repoConfigMaxDeltas, "max-deltas", 0, "Most deltas to produce per package (0 for no limit)")
+	repoConfigCmd.Flags().IntVar(&repoConfigMinDistance, "min-distance", 0, "Only delta from releases at least this far behind")
+	repoConfigCmd.Flags().Int64Var(&repoConfigMaxSize, "max-size", 0, "Don't delta packages larger than this many MiB (0 for no limit)")
+	repoConfigCmd.Flags().BoolVar(&repoConfigVerify, "verify-hashes", false, "Verify package contents on import (costs CPU)")
+	repoConfigCmd.Flags().StringVar(&repoConfigConflicts, "on-conflict", "keep", "Handle duplicate release numbers: keep, reject or newer")
+	repoConfigCmd.Flags().BoolVar(&repoConfigVerifyIndex, "verify-index", false, "Validate each index against the tree before publishing it")
+	repoConfigCmd.Flags().Int64Var(&repoConfigQuota, "quota", 0, "Most MiB the repository may use (0 for no limit)")
+	repoConfigCmd.Flags().StringSliceVar(&repoConfigArchs, "archs", nil, "Architectures packages may be built for, each indexed separately (empty for any)")
+	repoConfigCmd.Flags().BoolVar(&repoConfigComponents, "component-indexes", false, "Emit an index fragment for each component alongside the main index")
+	repoConfigCmd.Flags().BoolVar(&repoConfigVerifyDelta, "verify-deltas", false, "Check each new delta against its packages before indexing it (costs CPU)")
+	repoConfigCmd.Flags().BoolVar(&repoConfigVersioned, "versioned-indexes", false, "Publish every index artifact at once by flipping a symlink to a new generation")
+	RootCmd.AddCommand(repoConfigCmd)
+}
+
+// formatLimit will print a numerical limit, where 0 means unlimited
+func formatLimit(n int64, format func(int64) string) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return format(n)
+}
+
+// printRepoConfig will print the repository settings
+func printRepoConfig(config *libferry.RepoConfigRequest) {
+	d := config.Delta
+	fmt.Printf("Repository        : %s\n", config.Repo)
+	fmt.Printf("Deltas            : %v\n", d.Enabled)
+	fmt.Printf("Max deltas        : %s\n", formatLimit(int64(d.MaxDeltas), func(n int64) string {
+		return fmt.Sprintf("%d per package", n)
+	}))
+	fmt.Printf("Min distance      : %d releases\n", d.MinDistance)
+	fmt.Printf("Max package size  : %s\n", formatLimit(d.MaxSize, func(n int64) string {
+		return formatBytes(uint64(n))
+	}))
+	fmt.Printf("Verify hashes     : %v\n", config.VerifyHashes)
+	fmt.Printf("On conflict       : %s\n", config.ConflictPolicy)
+	fmt.Printf("Verify index      : %v\n", config.VerifyIndex)
+	archs := "any"
+	if len(config.Architectures) > 0 {
+		archs = strings.Join(config.Architectures, ", ")
+	}
+	fmt.Printf("Architectures     : %s\n", archs)
+	fmt.Printf("Component indexes : %v\n", config.ComponentIndexes)
+	fmt.Printf("Verify deltas     : %v\n", config.VerifyDeltas)
+	fmt.Printf("Versioned indexes : %v\n", config.VersionedIndexes)
+	fmt.Printf("Quota             : %s (%s used)\n", formatLimit(config.Quota, func(n int64) string {
+		return formatBytes(uint64(n))
+	}), formatBytes(uint64(config.Used)))
+}
+
+func repoConfig(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: repo-config [repoName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	config, err := client.GetRepoConfig(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+
+	// Only update the settings which were explicitly given, ignoring
+	// global flags such as --json
+	flags := cmd.Flags()
+	changed := false
+	if flags.Changed("deltas") {
+		changed = true
+		config.Delta.Enabled = repoConfigDeltas
+	}
+	if flags.Changed("max-deltas") {
+		changed = true
+		config.Delta.MaxDeltas = repoConfigMaxDeltas
+	}
+	if flags.Changed("min-distance") {
+		changed = true
+		config.Delta.MinDistance = repoConfigMinDistance
+	}
+	if flags.Changed("max-size") {
+		changed = true
+		config.Delta.MaxSize = repoConfigMaxSize * 1024 * 1024
+	}
+	if flags.Changed("verify-hashes") {
+		changed = true
+		config.VerifyHashes = repoConfigVerify
+	}
+	if flags.Changed("on-conflict") {
+		changed = true
+		config.ConflictPolicy = repoConfigConflicts
+	}
+	if flags.Changed("verify-index") {
+		changed = true
+		config.VerifyIndex = repoConfigVerifyIndex
+	}
+	if flags.Changed("quota") {
+		changed = true
+		config.Quota = repoConfigQuota * 1024 * 1024
+	}
+	if flags.Changed("archs") {
+		changed = true
+		config.Architectures = repoConfigArchs
+	}
+	if flags.Changed("component-indexes") {
+		changed = true
+		config.ComponentIndexes = repoConfigComponents
+	}
+	if flags.Changed("verify-deltas") {
+		changed = true
+		config.VerifyDeltas = repoConfigVerifyDelta
+	}
+	if flags.Changed("versioned-indexes") {
+		changed = true
+		config.VersionedIndexes = repoConfigVersioned
+	}
+	if changed {
+		if err := client.SetRepoConfig(args[0], config); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+	}
+
+	if jsonOutput {
+		printJSON(config)
+		return
+	}
+	printRepoConfig(config)
+}
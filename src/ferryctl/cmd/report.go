@@ -0,0 +1,74 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report [repoName]",
+	Short: "show problems found while indexing",
+	Long:  "Show the problems found during the last index of a repository, such as uninstallable packages",
+	Run:   showReport,
+}
+
+func init() {
+	RootCmd.AddCommand(reportCmd)
+}
+
+func showReport(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "report takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	report, err := client.GetIndexReport(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(report)
+		return
+	}
+
+	fmt.Printf("Indexed %d packages in '%s' at %s\n\n", report.Packages, report.Repo,
+		report.Time.Local().Format("2006-01-02 15:04:05"))
+	if len(report.Findings) == 0 {
+		fmt.Printf("No problems found.\n")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Problem", "Package", "Detail"})
+	table.SetBorder(false)
+	for _, f := range report.Findings {
+		table.Append([]string{
+			f.Kind,
+			f.Package,
+			f.Detail,
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,82 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var rewriteMetaCmd = &cobra.Command{
+	Use:   "rewrite-meta [packageID]",
+	Short: "fix metadata of a stored package",
+	Long:  "Patch the metadata of a package in the pool, and republish every repository containing it",
+	Run:   rewriteMeta,
+}
+
+var (
+	rewriteMetaSummary     string
+	rewriteMetaDescription string
+	rewriteMetaPartOf      string
+	rewriteMetaLicense     []string
+)
+
+func init() {
+	rewriteMetaCmd.Flags().StringVar(&rewriteMetaSummary, "summary", "", "Replace the summary")
+	rewriteMetaCmd.Flags().StringVar(&rewriteMetaDescription, "description", "", "Replace the description")
+	rewriteMetaCmd.Flags().StringVar(&rewriteMetaPartOf, "part-of", "", "Move the package to another component")
+	rewriteMetaCmd.Flags().StringSliceVar(&rewriteMetaLicense, "license", nil, "Replace the licenses")
+	RootCmd.AddCommand(rewriteMetaCmd)
+}
+
+func rewriteMeta(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: rewrite-meta [packageID] --summary ... --part-of ...\n")
+		return
+	}
+
+	// Only send the fields which were explicitly given
+	req := &libferry.RewriteMetadataRequest{}
+	flags := cmd.Flags()
+	if flags.Changed("summary") {
+		req.Summary = &rewriteMetaSummary
+	}
+	if flags.Changed("description") {
+		req.Description = &rewriteMetaDescription
+	}
+	if flags.Changed("part-of") {
+		req.PartOf = &rewriteMetaPartOf
+	}
+	if flags.Changed("license") {
+		req.License = rewriteMetaLicense
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.RewriteMetadata(args[0], req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -17,7 +17,12 @@
 package cmd
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+	"sync"
 )
 
 // RootCmd is the main entry point into ferry
@@ -26,16 +31,30 @@ var RootCmd = &cobra.Command{
 	Short: "ferry is the Solus package repository tool",
 }
 
+// BanCmd is the parent for commands managing repository bans
+var BanCmd = &cobra.Command{
+	Use:   "ban [add] [remove] [list]",
+	Short: "manage packages banned from a repository",
+}
+
+// HoldCmd is the parent for commands managing held sources
+var HoldCmd = &cobra.Command{
+	Use:   "hold [add] [remove] [list]",
+	Short: "manage sources held in a repository",
+}
+
 // ListCmd is a parent for list type commands
 var ListCmd = &cobra.Command{
-	Use:   "list  [repos] [pool]",
-	Short: "list",
+	Use:     "list  [repos] [pool]",
+	Aliases: []string{"ls"},
+	Short:   "list",
 }
 
 // RemoveCmd is the parent for remove type commands
 var RemoveCmd = &cobra.Command{
-	Use:   "remove [repo] [source]",
-	Short: "remove",
+	Use:     "remove [repo] [source]",
+	Aliases: []string{"rm"},
+	Short:   "remove",
 }
 
 // ResetCmd is the parent for reset type commands
@@ -44,29 +63,122 @@ var ResetCmd = &cobra.Command{
 	Short: "reset job logs",
 }
 
+// AssetCmd is the parent for repository asset commands
+var AssetCmd = &cobra.Command{
+	Use:   "asset [list] [show] [set]",
+	Short: "manage repository assets",
+}
+
 // CopyCmd is the parent for copy type commands
 var CopyCmd = &cobra.Command{
-	Use:   "copy [source]",
-	Short: "copy",
+	Use:     "copy [source]",
+	Aliases: []string{"cp"},
+	Short:   "copy",
+}
+
+// SnapshotCmd is the parent for snapshot type commands
+var SnapshotCmd = &cobra.Command{
+	Use:   "snapshot [create] [list] [restore] [delete]",
+	Short: "manage repository snapshots",
 }
 
 // TrimCmd is the parent for trim type commands
 var TrimCmd = &cobra.Command{
-	Use:   "trim [packages] [obsoletes]",
+	Use:   "trim [packages] [obsoletes] [deltas]",
 	Short: "trim",
 }
 
 var (
 	// Default location for the unix socket
 	socketPath = "/run/ferryd.sock"
+
+	// Print machine readable JSON instead of tables
+	jsonOutput = false
+
+	// How long each request to ferryd may take
+	requestTimeout = libferry.DefaultTimeout
+
+	// Block until queued jobs have finished
+	waitJobs = false
+
+	// Key identifying the job to ferryd, so that retries don't queue it twice
+	idempotencyKey = ""
+
+	// Only warn once about an incompatible daemon
+	compatOnce sync.Once
 )
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&socketPath, "socket", "s", "/run/ferryd.sock", "Set the socket path to talk to ferryd")
+	RootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print output as JSON for scripting")
+	RootCmd.PersistentFlags().DurationVar(&requestTimeout, "timeout", libferry.DefaultTimeout, "Set how long requests to ferryd may take, 0 for no limit")
+	RootCmd.PersistentFlags().BoolVar(&waitJobs, "wait", false, "Wait for queued jobs to finish")
+	RootCmd.PersistentFlags().StringVar(&idempotencyKey, "idempotency-key", "", "Return the job already queued with this key instead of queueing another")
+	RemoveCmd.PersistentFlags().BoolVarP(&forceDestructive, "force", "f", false, "Do not ask for confirmation")
+	TrimCmd.PersistentFlags().BoolVarP(&forceDestructive, "force", "f", false, "Do not ask for confirmation")
 
+	RootCmd.AddCommand(AssetCmd)
+	RootCmd.AddCommand(BanCmd)
 	RootCmd.AddCommand(CopyCmd)
+	RootCmd.AddCommand(HoldCmd)
 	RootCmd.AddCommand(ListCmd)
 	RootCmd.AddCommand(RemoveCmd)
 	RootCmd.AddCommand(ResetCmd)
+	RootCmd.AddCommand(SnapshotCmd)
 	RootCmd.AddCommand(TrimCmd)
 }
+
+// newClient will return a client for the ferryd socket, using the timeout
+// and idempotency key given on the command line. The first client made
+// checks that the daemon is compatible, warning if it isn't.
+func newClient() *libferry.Client {
+	client := dialClient()
+	compatOnce.Do(func() {
+		warnIncompatible(client)
+	})
+	return client
+}
+
+// dialClient is newClient without the compatibility check, for when
+// nothing may be printed
+func dialClient() *libferry.Client {
+	client := libferry.NewClient(socketPath)
+	client.SetTimeout(requestTimeout)
+	client.SetIdempotencyKey(idempotencyKey)
+	return client
+}
+
+// warnIncompatible will warn when the daemon doesn't match ferryctl. Failing
+// to ask is left for the command itself to report.
+func warnIncompatible(client *libferry.Client) {
+	err := client.CheckCompatibility()
+	compat, ok := err.(*libferry.CompatibilityError)
+	if !ok {
+		return
+	}
+	if compat.Breaking() {
+		fmt.Fprintf(os.Stderr, "Warning: %v, requests are likely to fail\n", compat)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", compat)
+}
+
+// waitForJob will block until the job has finished when --wait was passed,
+// returning an error if it failed
+func waitForJob(client *libferry.Client, jobID string) error {
+	if !waitJobs {
+		return nil
+	}
+	_, err := client.WaitForJob(jobID)
+	return err
+}
+
+// printJSON will write the object to stdout as indented JSON, for use when
+// --json has been passed
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
@@ -0,0 +1,79 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var searchCmd = &cobra.Command{
+	Use:   "search [pattern]",
+	Short: "search for packages",
+	Long:  "Search package names, summaries and descriptions in one or all repositories",
+	Run:   searchPackages,
+}
+
+var (
+	searchRepo  string
+	searchRegex bool
+)
+
+func init() {
+	searchCmd.Flags().StringVarP(&searchRepo, "repo", "r", "", "Only search this repository")
+	searchCmd.Flags().BoolVarP(&searchRegex, "regex", "e", false, "Treat the pattern as a regular expression")
+	RootCmd.AddCommand(searchCmd)
+}
+
+func searchPackages(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: search [pattern]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	results, err := client.Search(args[0], searchRepo, searchRegex)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(results)
+		return
+	}
+	if len(results) == 0 {
+		fmt.Printf("No packages matched '%s'.\n", args[0])
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Repo", "Name", "Version", "Summary"})
+	table.SetBorder(false)
+	for _, r := range results {
+		table.Append([]string{
+			r.Repo,
+			r.Name,
+			fmt.Sprintf("%s-%d", r.Version, r.Release),
+			r.Summary,
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,165 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libeopkg"
+	"libferry"
+	"os"
+	"strings"
+	"time"
+)
+
+var showCmd = &cobra.Command{
+	Use:     "show [repoName] [packageName]",
+	Aliases: []string{"info"},
+	Short:   "show package details",
+	Long:    "Show the published metadata, available releases and deltas for a package",
+	Run:     showPackage,
+}
+
+func init() {
+	RootCmd.AddCommand(showCmd)
+}
+
+// localised will return the English (or unlabelled) value of the field
+func localised(fields []libeopkg.LocalisedField) string {
+	for _, f := range fields {
+		if f.Lang == "" || f.Lang == "en" {
+			return strings.TrimSpace(f.Value)
+		}
+	}
+	if len(fields) > 0 {
+		return strings.TrimSpace(fields[0].Value)
+	}
+	return ""
+}
+
+// printMeta will print the interesting parts of the package metadata
+func printMeta(meta *libeopkg.MetaPackage) {
+	fmt.Printf("Name        : %s\n", meta.Name)
+	fmt.Printf("Version     : %s-%d\n", meta.GetVersion(), meta.GetRelease())
+	fmt.Printf("Source      : %s\n", meta.Source.Name)
+	fmt.Printf("Component   : %s\n", meta.PartOf)
+	fmt.Printf("License     : %s\n", strings.Join(meta.License, ", "))
+	if meta.Source.Homepage != "" {
+		fmt.Printf("Homepage    : %s\n", meta.Source.Homepage)
+	}
+	fmt.Printf("Summary     : %s\n", localised(meta.Summary))
+	fmt.Printf("Package size: %s (installed: %s)\n",
+		formatBytes(uint64(meta.PackageSize)),
+		formatBytes(uint64(meta.InstalledSize)))
+	fmt.Printf("Package URI : %s\n", meta.PackageURI)
+	fmt.Printf("SHA1        : %s\n", meta.PackageHash)
+	if meta.RuntimeDependencies != nil && len(*meta.RuntimeDependencies) > 0 {
+		var deps []string
+		for _, d := range *meta.RuntimeDependencies {
+			deps = append(deps, d.Name)
+		}
+		fmt.Printf("Depends     : %s\n", strings.Join(deps, ", "))
+	}
+	if desc := localised(meta.Description); desc != "" {
+		fmt.Printf("\n%s\n", desc)
+	}
+}
+
+// printProvenance will print where the published package came from
+func printProvenance(prov *libferry.Provenance) {
+	fmt.Printf("\n")
+	if prov.Builder != "" {
+		fmt.Printf("Builder     : %s\n", prov.Builder)
+	}
+	if prov.Build != "" {
+		fmt.Printf("Build       : %s\n", prov.Build)
+	}
+	if prov.Manifest != "" {
+		fmt.Printf("Manifest    : %s\n", prov.Manifest)
+	}
+	fmt.Printf("Uploaded    : %s\n", prov.Uploaded.Local().Format(time.RFC1123))
+	if prov.JobID != "" {
+		fmt.Printf("Job         : %s\n", prov.JobID)
+	}
+}
+
+func showPackage(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: show [repoName] [packageName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	info, err := client.GetPackageInfo(args[0], args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		printJSON(info)
+		return
+	}
+
+	if info.Package != nil {
+		printMeta(info.Package)
+		if info.Provenance != nil {
+			printProvenance(info.Provenance)
+		}
+	} else {
+		fmt.Printf("No release of '%s' is currently published.\n", args[1])
+	}
+
+	if len(info.Available) > 0 {
+		fmt.Printf("\nAvailable releases:\n\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Release", "Version", "Size", "Builder", "ID"})
+		table.SetBorder(false)
+		for _, p := range info.Available {
+			builder := "-"
+			if p.Provenance != nil && p.Provenance.Builder != "" {
+				builder = p.Provenance.Builder
+			}
+			table.Append([]string{
+				fmt.Sprintf("%d", p.Release),
+				p.Version,
+				formatBytes(uint64(p.Size)),
+				builder,
+				p.ID,
+			})
+		}
+		table.Render()
+	}
+
+	if len(info.Deltas) > 0 {
+		fmt.Printf("\nDeltas:\n\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"From", "To", "Size", "ID"})
+		table.SetBorder(false)
+		for _, d := range info.Deltas {
+			table.Append([]string{
+				fmt.Sprintf("%d", d.FromRelease),
+				fmt.Sprintf("%d", d.ToRelease),
+				formatBytes(uint64(d.Size)),
+				d.ID,
+			})
+		}
+		table.Render()
+	}
+}
@@ -0,0 +1,59 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var snapshotCreateCmd = &cobra.Command{
+	Use:   "create [repoName] [snapshotName]",
+	Short: "snapshot a repository",
+	Long:  "Record the current state of a repository so it can be restored later. If no name is given, the current time is used.",
+	Run:   createSnapshot,
+}
+
+func init() {
+	SnapshotCmd.AddCommand(snapshotCreateCmd)
+}
+
+func createSnapshot(cmd *cobra.Command, args []string) {
+	var name string
+	switch len(args) {
+	case 1:
+	case 2:
+		name = args[1]
+	default:
+		fmt.Fprintf(os.Stderr, "usage: snapshot create [repoName] [snapshotName]\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.CreateSnapshot(args[0], name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
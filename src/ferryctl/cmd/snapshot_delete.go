@@ -0,0 +1,58 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var snapshotDeleteCmd = &cobra.Command{
+	Use:   "delete [repoName] [snapshotName]",
+	Short: "delete a snapshot",
+	Long:  "Delete a snapshot, allowing packages only it refers to to be removed from the pool",
+	Run:   deleteSnapshot,
+}
+
+func init() {
+	snapshotDeleteCmd.Flags().BoolVarP(&forceDestructive, "force", "f", false, "Do not ask for confirmation")
+	SnapshotCmd.AddCommand(snapshotDeleteCmd)
+}
+
+func deleteSnapshot(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "snapshot delete takes exactly 2 arguments\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return client.DeleteSnapshot(args[0], args[1], conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
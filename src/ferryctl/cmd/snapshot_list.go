@@ -0,0 +1,75 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var snapshotListCmd = &cobra.Command{
+	Use:   "list [repoName]",
+	Short: "list snapshots of a repository",
+	Long:  "List every snapshot recorded for a repository, oldest first",
+	Run:   listSnapshots,
+}
+
+func init() {
+	SnapshotCmd.AddCommand(snapshotListCmd)
+}
+
+func listSnapshots(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "snapshot list takes exactly 1 argument\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	snaps, err := client.GetSnapshots(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		if snaps == nil {
+			snaps = []libferry.SnapshotItem{}
+		}
+		printJSON(snaps)
+		return
+	}
+	if len(snaps) == 0 {
+		fmt.Printf("No snapshots exist for '%s'.\n", args[0])
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Name", "Created", "Packages"})
+	table.SetBorder(false)
+	for _, s := range snaps {
+		table.Append([]string{
+			s.Name,
+			s.Created.Local().Format("2006-01-02 15:04:05"),
+			fmt.Sprintf("%d", s.Packages),
+		})
+	}
+	table.Render()
+}
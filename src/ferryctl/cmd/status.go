@@ -23,13 +23,15 @@ import (
 	"libferry"
 	"os"
 	"sort"
+	"time"
 )
 
 var statusCmd = &cobra.Command{
-	Use:   "status",
-	Short: "display ferryd status",
-	Long:  "Show an overview of currently registered jobs, and any failures",
-	Run:   getStatus,
+	Use:     "status",
+	Aliases: []string{"st"},
+	Short:   "display ferryd status",
+	Long:    "Show an overview of currently registered jobs, and any failures",
+	Run:     getStatus,
 }
 
 var (
@@ -43,35 +45,117 @@ func init() {
 	RootCmd.AddCommand(statusCmd)
 }
 
+// printLimit will return how many jobs of each kind to print, or -1 when
+// they should all be printed
+func printLimit() int {
+	if allJobs {
+		return -1
+	}
+	return maxPrintJobs
+}
+
+// groupFamilies will reorder the jobs so that child jobs directly follow
+// their parent, where the parent is still around
+func groupFamilies(js []*libferry.Job) []*libferry.Job {
+	parents := make(map[string]bool)
+	children := make(map[string][]*libferry.Job)
+	for _, j := range js {
+		parents[j.ID] = true
+	}
+	for _, j := range js {
+		if j.ParentID != "" && parents[j.ParentID] {
+			children[j.ParentID] = append(children[j.ParentID], j)
+		}
+	}
+
+	ret := make([]*libferry.Job, 0, len(js))
+	for _, j := range js {
+		if j.ParentID != "" && parents[j.ParentID] {
+			continue
+		}
+		ret = append(ret, j)
+		ret = append(ret, children[j.ID]...)
+	}
+	return ret
+}
+
+// groupRepos will return the first max jobs, or all of them when max is
+// negative, with those acting on the same repository kept together. Jobs
+// otherwise keep their order.
+func groupRepos(js []*libferry.Job, max int) []*libferry.Job {
+	if max >= 0 && len(js) > max {
+		js = js[:max]
+	}
+	ret := make([]*libferry.Job, len(js))
+	copy(ret, js)
+	sort.SliceStable(ret, func(a, b int) bool {
+		return ret[a].Repo < ret[b].Repo
+	})
+	return ret
+}
+
+// formatProgress will return a short summary of the job progress, if any
+func formatProgress(p *libferry.JobProgress) string {
+	if p == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%% %s %s", p.Percent(), p.Stage, p.Current)
+}
+
+// waitingOn will determine if the job depends on any of the pending jobs
+func waitingOn(j *libferry.Job, pending map[string]bool) bool {
+	for _, dep := range j.DependsOn {
+		if pending[dep] {
+			return true
+		}
+	}
+	return false
+}
+
 func printActiveJobs(js []*libferry.Job) {
 	header := []string{
 		"Status",
 		"Queued",
 		"Waited",
+		"Progress",
+		"Repo",
 		"Description",
 	}
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader(header)
 	table.SetBorder(false)
 
+	pending := make(map[string]bool)
+	for _, j := range js {
+		pending[j.ID] = true
+	}
+
 	i := 0
 
-	for _, j := range js {
+	for _, j := range groupFamilies(js) {
 		if i >= maxPrintJobs && !allJobs {
 			break
 		}
 		i++
+		description := j.Description
+		if j.ParentID != "" {
+			description = " └ " + description
+		}
 		var runType string
-		if j.Timing.Begin.IsZero() {
-			runType = "queued"
-		} else {
+		if !j.Timing.Begin.IsZero() {
 			runType = "running"
+		} else if waitingOn(j, pending) {
+			runType = "waiting"
+		} else {
+			runType = "queued"
 		}
 		table.Append([]string{
 			runType,
 			j.Timing.Queued.Format("2006-01-02 15:04:05"),
 			j.QueuedSince().String(),
-			j.Description,
+			formatProgress(j.Progress),
+			j.Repo,
+			description,
 		})
 	}
 	table.Render()
@@ -83,6 +167,7 @@ func printFailedJobs(js []*libferry.Job) {
 		"Status",
 		"Completed",
 		"Duration",
+		"Repo",
 		"Description",
 		"Error",
 	}
@@ -90,17 +175,12 @@ func printFailedJobs(js []*libferry.Job) {
 	table.SetHeader(header)
 	table.SetBorder(false)
 
-	i := 0
-
-	for _, j := range js {
-		if i >= maxPrintJobs && !allJobs {
-			break
-		}
-		i++
+	for _, j := range groupRepos(js, printLimit()) {
 		table.Append([]string{
 			"failed",
 			j.Timing.End.Format("2006-01-02 15:04:05"),
 			j.ExecutionTime().String(),
+			j.Repo,
 			j.Description,
 			j.Error,
 		})
@@ -115,37 +195,149 @@ func printCompletedJobs(js []*libferry.Job) {
 		"Completed",
 		"Duration",
 		"Execution time",
+		"Repo",
 		"Description",
 	}
 	table := tablewriter.NewWriter(os.Stdout)
 	table.SetHeader(header)
 	table.SetBorder(false)
 
-	i := 0
-
-	for _, j := range js {
-		if i >= maxPrintJobs && !allJobs {
-			break
-		}
-		i++
+	for _, j := range groupRepos(js, printLimit()) {
 		table.Append([]string{
 			"success",
 			j.Timing.End.Format("2006-01-02 15:04:05"),
 			j.TotalTime().String(),
 			j.ExecutionTime().String(),
+			j.Repo,
 			j.Description,
 		})
 	}
 	table.Render()
 }
 
+// formatBytes will return a human readable representation of the size
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Print the duplicate release numbers awaiting a fix
+func printConflicts(cs []libferry.ReleaseConflict) {
+	header := []string{
+		"Repo",
+		"Package",
+		"Release",
+		"Existing",
+		"New",
+		"Resolution",
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+
+	for _, c := range cs {
+		table.Append([]string{
+			c.Repo,
+			c.Name,
+			fmt.Sprintf("%d", c.Release),
+			c.Existing,
+			c.New,
+			c.Resolution,
+		})
+	}
+	table.Render()
+}
+
+// Print the sync state of each repository on the publish targets
+func printPublish(ps []libferry.PublishStatus) {
+	header := []string{
+		"Target",
+		"Repo",
+		"State",
+		"Last synced",
+		"Error",
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+
+	for _, p := range ps {
+		state := "synced"
+		if p.Failures > 0 {
+			state = fmt.Sprintf("failing (%d), retry %s", p.Failures, p.NextAttempt.Local().Format("15:04:05"))
+		} else if p.Pending {
+			state = "pending"
+		}
+		synced := "never"
+		if !p.Synced.IsZero() {
+			synced = p.Synced.Local().Format("2006-01-02 15:04:05")
+		}
+		table.Append([]string{
+			p.Target,
+			p.Repo,
+			state,
+			synced,
+			p.Error,
+		})
+	}
+	table.Render()
+}
+
+// Print the state of each worker
+func printWorkers(ws []libferry.WorkerStatus) {
+	header := []string{
+		"Worker",
+		"Queue",
+		"Status",
+		"Running",
+		"Description",
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(header)
+	table.SetBorder(false)
+
+	for _, w := range ws {
+		queue := "async"
+		if w.Sequential {
+			queue = "sequential"
+		}
+		status := "idle"
+		running := ""
+		if w.Busy {
+			status = "busy"
+			running = time.Since(w.Since).Truncate(time.Second).String()
+		}
+		if w.TimedOut {
+			status = "timed out"
+		} else if w.Overdue {
+			status = "overdue"
+		}
+		table.Append([]string{
+			fmt.Sprintf("%d", w.ID),
+			queue,
+			status,
+			running,
+			w.Description,
+		})
+	}
+	table.Render()
+}
+
 func getStatus(cmd *cobra.Command, args []string) {
 	if len(args) != 0 {
 		fmt.Fprintf(os.Stderr, "status takes no arguments\n")
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
 	status, err := client.GetStatus()
@@ -154,9 +346,67 @@ func getStatus(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if jsonOutput {
+		// Same ordering as the tables, but never truncated
+		sort.Sort(sort.Reverse(status.FailedJobs))
+		sort.Sort(status.CurrentJobs)
+		sort.Sort(sort.Reverse(status.CompletedJobs))
+		printJSON(status)
+		return
+	}
+
 	// Show uptime
 	fmt.Printf(" - Daemon uptime: %v\n", status.Uptime())
 	fmt.Printf(" - Daemon version: %v\n", status.Version)
+	if status.Degraded {
+		fmt.Printf(" - Mode: degraded, the database couldn't be recovered so only reads are served\n")
+	} else if status.Standby {
+		fmt.Printf(" - Mode: standby, only serving reads\n")
+	}
+	fmt.Printf(" - Queued jobs: %d sequential, %d async\n", status.Queues.Sequential, status.Queues.Async)
+	fmt.Printf(" - Busy workers: %d of %d\n", status.BusyWorkers(), len(status.Workers))
+	fmt.Printf(" - Database size: %s (jobs: %s)\n",
+		formatBytes(uint64(status.Storage.DatabaseSize)),
+		formatBytes(uint64(status.Storage.JobDatabaseSize)))
+	fmt.Printf(" - Disk free: %s of %s\n",
+		formatBytes(status.Storage.DiskFree),
+		formatBytes(status.Storage.DiskTotal))
+	fmt.Printf(" - Pool layout: %s\n", status.Storage.PoolLayout)
+	if status.Storage.ObjectStore != "" {
+		fmt.Printf(" - Object store: %s\n", status.Storage.ObjectStore)
+	}
+	fmt.Println()
+
+	if status.Storage.Pressure {
+		fmt.Printf("Disk pressure: less than %s free, imports and deltas are being refused\n\n",
+			formatBytes(status.Storage.MinFree))
+	}
+	if status.Storage.Refused > 0 {
+		fmt.Printf("Refused for disk space or quota: %d operations since startup\n\n", status.Storage.Refused)
+	}
+
+	if status.Incoming.StaleFiles > 0 || status.Incoming.MissedManifests > 0 {
+		fmt.Printf("Incoming uploads: %d stale files, %d manifests missed by the watcher\n\n",
+			status.Incoming.StaleFiles, status.Incoming.MissedManifests)
+	}
+
+	if n := status.OverdueWorkers(); n > 0 {
+		fmt.Printf("Overdue jobs: %d running for longer than expected, and may be stuck\n\n", n)
+	}
+
+	if status.BusyWorkers() > 0 {
+		printWorkers(status.Workers)
+	}
+
+	if len(status.Conflicts) > 0 {
+		fmt.Printf("Release conflicts: (%d outstanding)\n\n", len(status.Conflicts))
+		printConflicts(status.Conflicts)
+	}
+
+	if len(status.Publish) > 0 {
+		fmt.Printf("Publish targets:\n\n")
+		printPublish(status.Publish)
+	}
 
 	// Show failing
 	if len(status.FailedJobs) > 0 {
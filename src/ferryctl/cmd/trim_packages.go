@@ -52,12 +52,19 @@ func trimPackages(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	client := libferry.NewClient(socketPath)
+	client := newClient()
 	defer client.Close()
 
 	repoID := args[0]
 
-	if err := client.TrimPackages(repoID, int(maxKeep)); err != nil {
+	jobID, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return client.TrimPackages(repoID, int(maxKeep), conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		return
 	}
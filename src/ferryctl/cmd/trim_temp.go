@@ -0,0 +1,62 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var trimTempCmd = &cobra.Command{
+	Use:   "temp",
+	Short: "remove leftover temporary files",
+	Long:  "Request the removal of temporary files left behind by jobs which didn't finish",
+	Run:   trimTemp,
+}
+
+var trimTempMaxAge time.Duration
+
+func init() {
+	trimTempCmd.Flags().DurationVar(&trimTempMaxAge, "max-age", 0, "Only remove files older than this, defaults to the daemon's max_age")
+	TrimCmd.AddCommand(trimTempCmd)
+}
+
+func trimTemp(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		fmt.Fprintf(os.Stderr, "trim temp takes no arguments\n")
+		return
+	}
+	if trimTempMaxAge < 0 {
+		fmt.Fprintf(os.Stderr, "--max-age cannot be negative\n")
+		return
+	}
+
+	client := newClient()
+	defer client.Close()
+
+	jobID, err := client.TrimTemp(trimTempMaxAge)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
@@ -0,0 +1,99 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package cmd
+
+import (
+	"fmt"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"libferry"
+	"os"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo [jobID]",
+	Short: "undo a destructive job",
+	Long: "Restore a repository to the automatic snapshot taken before a destructive job ran. " +
+		"Without a job ID, list the jobs which can still be undone.",
+	Run: undo,
+}
+
+func init() {
+	undoCmd.Flags().BoolVarP(&forceDestructive, "force", "f", false, "Do not ask for confirmation")
+	RootCmd.AddCommand(undoCmd)
+}
+
+// listUndoJobs will print every job that can still be undone
+func listUndoJobs(client *libferry.Client) {
+	items, err := client.GetUndoJobs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if jsonOutput {
+		if items == nil {
+			items = []libferry.UndoItem{}
+		}
+		printJSON(items)
+		return
+	}
+	if len(items) == 0 {
+		fmt.Printf("There are no jobs which can be undone.\n")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Job", "Repository", "Ran", "Expires", "Description"})
+	table.SetBorder(false)
+	for _, i := range items {
+		table.Append([]string{
+			i.JobID,
+			i.Repo,
+			i.Created.Local().Format("2006-01-02 15:04:05"),
+			i.Expires.Local().Format("2006-01-02 15:04:05"),
+			i.Description,
+		})
+	}
+	table.Render()
+}
+
+func undo(cmd *cobra.Command, args []string) {
+	client := newClient()
+	defer client.Close()
+
+	switch len(args) {
+	case 0:
+		listUndoJobs(client)
+		return
+	case 1:
+	default:
+		fmt.Fprintf(os.Stderr, "usage: undo [jobID]\n")
+		return
+	}
+
+	jobID, err := runConfirmed(func(conf libferry.Confirmation) (string, error) {
+		return client.UndoJob(args[0], conf)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+	if err = waitForJob(client, jobID); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return
+	}
+}
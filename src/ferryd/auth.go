@@ -0,0 +1,225 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const (
+	// DefaultAuthGroup is the group which, besides root, may use the
+	// privileged operations
+	DefaultAuthGroup = "ferry"
+)
+
+var (
+	// ErrNoPeer is returned when the credentials of the client are unknown,
+	// i.e. it didn't connect over the unix socket
+	ErrNoPeer = errors.New("client credentials are unavailable")
+)
+
+// A Peer is the process on the other end of a unix socket connection, as
+// reported by the kernel with SO_PEERCRED
+type Peer struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// Name will return the name of the peer's user, or its uid if it has none
+func (p *Peer) Name() string {
+	uid := strconv.FormatUint(uint64(p.UID), 10)
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
+
+// inGroup will determine if the peer's user is in the group, either as its
+// primary group or a supplementary one
+func (p *Peer) inGroup(gid uint32) bool {
+	if p.GID == gid {
+		return true
+	}
+	u, err := user.LookupId(strconv.FormatUint(uint64(p.UID), 10))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	want := strconv.FormatUint(uint64(gid), 10)
+	for _, g := range groups {
+		if g == want {
+			return true
+		}
+	}
+	return false
+}
+
+// peerKey stores the Peer in the connection context
+type peerKey struct{}
+
+// peerContext will store the credentials of the unix socket client in the
+// context of its connection. Connections we can't identify are left alone,
+// and are refused anything needing authorization.
+func peerContext(ctx context.Context, c net.Conn) context.Context {
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return ctx
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err == nil {
+		err = credErr
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to read client credentials")
+		return ctx
+	}
+	return context.WithValue(ctx, peerKey{}, &Peer{
+		PID: cred.Pid,
+		UID: cred.Uid,
+		GID: cred.Gid,
+	})
+}
+
+// requestPeer will return the client which sent the request
+func requestPeer(r *http.Request) (*Peer, error) {
+	peer, ok := r.Context().Value(peerKey{}).(*Peer)
+	if !ok {
+		return nil, ErrNoPeer
+	}
+	return peer, nil
+}
+
+// The Authorizer decides which clients may use the privileged operations,
+// allowing root and the members of one group
+type Authorizer struct {
+	group string
+	gid   uint32
+	valid bool // Only root is allowed when the group doesn't exist
+	mut   *sync.RWMutex
+}
+
+// NewAuthorizer will return an Authorizer which only allows root
+func NewAuthorizer() *Authorizer {
+	return &Authorizer{
+		mut: &sync.RWMutex{},
+	}
+}
+
+// SetGroup will change the group allowed besides root. An empty or unknown
+// group allows only root.
+func (a *Authorizer) SetGroup(name string) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	a.group = name
+	a.valid = false
+	if name == "" {
+		return
+	}
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"group": name,
+			"error": err,
+		}).Warning("Unknown authorization group, only root may use privileged operations")
+		return
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return
+	}
+	a.gid = uint32(gid)
+	a.valid = true
+}
+
+// Group will return the gid of the allowed group, if it exists
+func (a *Authorizer) Group() (int, bool) {
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+	return int(a.gid), a.valid
+}
+
+// Allowed will determine if the peer may use the privileged operations.
+// Our own user is always allowed, as it owns everything anyway.
+func (a *Authorizer) Allowed(peer *Peer) error {
+	if peer.UID == 0 || int(peer.UID) == os.Getuid() {
+		return nil
+	}
+	a.mut.RLock()
+	defer a.mut.RUnlock()
+	if a.valid && peer.inGroup(a.gid) {
+		return nil
+	}
+	if a.valid {
+		return fmt.Errorf("only root or members of the %s group may do this", a.group)
+	}
+	return errors.New("only root may do this")
+}
+
+// authorize will wrap the handler of a privileged operation so that it's
+// refused unless the client is allowed to use it. Every attempt is logged
+// with the identity of the client, for auditing.
+func (s *Server) authorize(op string, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		peer, err := requestPeer(r)
+		if err == nil {
+			err = s.auth.Allowed(peer)
+		}
+		fields := log.Fields{
+			"operation": op,
+			"path":      r.URL.Path,
+		}
+		if peer != nil {
+			fields["uid"] = peer.UID
+			fields["pid"] = peer.PID
+			fields["user"] = peer.Name()
+		}
+		if err != nil {
+			fields["error"] = err
+			log.WithFields(fields).Warning("Audit: refused privileged request")
+			s.sendStockError(&requestError{kind: libferry.ErrorForbidden, err: err}, w, r)
+			return
+		}
+		log.WithFields(fields).Info("Audit: authorized privileged request")
+		handle(w, r, p)
+	}
+}
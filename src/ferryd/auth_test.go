@@ -0,0 +1,137 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"libferry"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newTestServer will create a server with its routes, which is never bound
+// and so has no manager
+func newTestServer(t *testing.T) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "ferryd-auth")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	config := NewConfig()
+	config.BaseDir = dir
+	s, err := NewServer(config)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	return s, func() {
+		s.lockFile.Unlock()
+		s.lockFile.Clean()
+		os.RemoveAll(dir)
+	}
+}
+
+// serveAs will send the request to the server as if the peer sent it over
+// the socket
+func serveAs(s *Server, peer *Peer, method, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	if peer != nil {
+		r = r.WithContext(context.WithValue(r.Context(), peerKey{}, peer))
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, r)
+	return w
+}
+
+func TestPrivilegedRoutes(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// Everything which changes settings or exposes the whole database
+	want := map[string]bool{
+		"AddBans":         true,
+		"BackupDatabase":  true,
+		"DeleteRepo":      true,
+		"DeleteSnapshot":  true,
+		"HoldSources":     true,
+		"ImportDirectory": true,
+		"ImportRepo":      true,
+		"MigratePool":     true,
+		"PromoteStandby":  true,
+		"RemoveBans":      true,
+		"RemoveSource":    true,
+		"ResetCompleted":  true,
+		"ResetFailed":     true,
+		"RestoreSnapshot": true,
+		"RewriteMetadata": true,
+		"SetAsset":        true,
+		"SetRepoConfig":   true,
+		"TrimObsolete":    true,
+		"TrimPackages":    true,
+		"UndoJob":         true,
+		"UnholdSources":   true,
+	}
+	for _, route := range s.routes {
+		if route.doc.Privileged != want[route.name] {
+			t.Errorf("%s %s (%s) should have Privileged %v", route.method, route.path, route.name, want[route.name])
+		}
+		if route.doc.Confirm && !route.doc.Privileged {
+			t.Errorf("%s %s needs confirmation but isn't privileged", route.method, route.path)
+		}
+		delete(want, route.name)
+	}
+	for name := range want {
+		t.Errorf("No route for %s", name)
+	}
+}
+
+func TestAuthorizeRefused(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	// Only root and our own user are allowed without a group
+	stranger := &Peer{PID: 1, UID: 65534, GID: 65534}
+	if os.Getuid() == int(stranger.UID) {
+		t.Skip("Running as the unprivileged test user")
+	}
+
+	// Neither route asks for confirmation, and the server has no manager
+	// so getting past authorization would panic
+	for _, path := range []string{"/api/v1/backup/db", "/api/v1/reset/failed"} {
+		for _, peer := range []*Peer{stranger, nil} {
+			w := serveAs(s, peer, http.MethodGet, path)
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("Unauthorized request for %s gave status %d", path, w.Code)
+			}
+			resp := libferry.Response{}
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode refusal: %v", err)
+			}
+			if !resp.Error || resp.ErrorKind != libferry.ErrorForbidden {
+				t.Fatalf("Expected a forbidden error, got %+v", resp)
+			}
+		}
+	}
+
+	// Whereas anyone may read
+	if w := serveAs(s, stranger, http.MethodGet, "/api/v1/openapi.json"); w.Code != http.StatusOK {
+		t.Fatalf("Unprivileged request gave status %d", w.Code)
+	}
+}
@@ -0,0 +1,125 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"ferryd/jobs"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// DefaultTempCleanInterval is how often leftover temporary files are looked
+// for, besides at startup
+const DefaultTempCleanInterval = 6 * time.Hour
+
+// The TempCleaner periodically queues a CleanTemp job, so that files left
+// behind by crashed or killed jobs don't slowly fill the disk
+type TempCleaner struct {
+	jproc  *jobs.Processor
+	config TempConfig
+	cancel context.CancelFunc
+	group  *sync.WaitGroup
+	mut    *sync.Mutex
+}
+
+// NewTempCleaner will return a TempCleaner which isn't running yet
+func NewTempCleaner(jproc *jobs.Processor) *TempCleaner {
+	return &TempCleaner{
+		jproc: jproc,
+		group: &sync.WaitGroup{},
+		mut:   &sync.Mutex{},
+	}
+}
+
+// SetConfig will start cleaning on the configured interval, restarting if
+// the configuration changed. Nothing is queued when the interval is 0.
+func (c *TempCleaner) SetConfig(config TempConfig) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.cancel != nil && config == c.config {
+		return
+	}
+	c.stopLocked()
+	c.config = config
+	if config.Interval.Duration <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.group.Add(1)
+	go c.run(ctx, config)
+}
+
+// Stop will stop queueing cleanups
+func (c *TempCleaner) Stop() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.stopLocked()
+}
+
+// stopLocked does the work of Stop, and requires that mut is already held
+func (c *TempCleaner) stopLocked() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	c.group.Wait()
+	c.cancel = nil
+}
+
+// run will queue a cleanup every interval until ctx is done
+func (c *TempCleaner) run(ctx context.Context, config TempConfig) {
+	defer c.group.Done()
+
+	ticker := time.NewTicker(config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := c.jproc.PushJob(jobs.NewCleanTempJob(config.MaxAge.Duration)); err != nil {
+			log.WithFields(log.Fields{
+				"error": err,
+			}).Error("Failed to queue temporary file cleanup")
+		}
+	}
+}
+
+// sweepTemp will remove every leftover from before we started, which is
+// only safe while no jobs are running
+func (s *Server) sweepTemp() {
+	cleanup, err := s.manager.SweepTemp()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to remove leftover temporary files")
+		return
+	}
+	if len(cleanup.Paths) == 0 {
+		return
+	}
+	log.WithFields(log.Fields{
+		"removed":   len(cleanup.Paths),
+		"reclaimed": cleanup.Bytes,
+	}).Info("Removed temporary files left behind last time")
+}
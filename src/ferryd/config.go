@@ -0,0 +1,772 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"ferryd/core"
+	"ferryd/jobs"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"io"
+	"libdb"
+	"libeopkg"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultConfigPath is where we look for the configuration file if the
+	// user didn't tell us otherwise. It's fine for it not to exist.
+	DefaultConfigPath = "/etc/ferryd/ferryd.conf"
+
+	// redactedSecret replaces credentials in the printed configuration
+	redactedSecret = "<redacted>"
+)
+
+// Duration allows time.Duration values to be written as "24h" in the config
+type Duration struct {
+	time.Duration
+}
+
+// MarshalText implements encoding.TextMarshaler, for printing the config
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler for the TOML decoder
+func (d *Duration) UnmarshalText(text []byte) error {
+	var err error
+	d.Duration, err = time.ParseDuration(string(text))
+	return err
+}
+
+// LogConfig controls the format and rotation of ferryd.log
+type LogConfig struct {
+	Format     string `toml:"format"`      // Either "text" or "json"
+	MaxSize    int    `toml:"max_size"`    // Rotate at this size in MiB, 0 disables
+	MaxBackups int    `toml:"max_backups"` // Rotated files to keep
+	Compress   bool   `toml:"compress"`    // Compress rotated files with the internal compressor
+}
+
+// CompressionConfig controls the xz settings used for indexes and deltas,
+// and which compressors are used
+type CompressionConfig struct {
+	Level    int    `toml:"level"`    // xz preset, 0-9
+	Threads  int    `toml:"threads"`  // xz -T value, 0 uses all cores
+	Xz       string `toml:"xz"`       // Either "xz" or the pure Go "go-xz"
+	Internal string `toml:"internal"` // Used for files only ferryd reads, i.e. rotated logs
+	Memory   int    `toml:"memory"`   // MiB all xz compressions may use at once, 0 for no limit
+}
+
+// DiskConfig sets the guardrails protecting the base directory's filesystem
+type DiskConfig struct {
+	MinFree int `toml:"min_free"` // MiB to keep free, refusing imports and deltas below it. 0 disables
+}
+
+// HashConfig controls how files are read when they're hashed
+type HashConfig struct {
+	ChunkSize int `toml:"chunk_size"` // KiB read at once
+	ReadAhead int `toml:"read_ahead"` // Chunks read ahead of the hashing, 0 disables
+	Parallel  int `toml:"parallel"`   // Files hashed at once during imports, 0 uses all cores
+}
+
+// IndexConfig controls how index files are written
+type IndexConfig struct {
+	Buffer      int      `toml:"buffer"`      // KiB of each index built up before it's written
+	Preallocate bool     `toml:"preallocate"` // Allocate the size of the last index up front
+	Debounce    Duration `toml:"debounce"`    // Quiet time before indexing after imports
+}
+
+// DeltaConfig controls how delta production is retried
+type DeltaConfig struct {
+	SkipExpiry Duration `toml:"skip_expiry"` // Retry failed deltas after this long, 0 never retries
+}
+
+// RepoDefaultsConfig holds the settings newly created repositories start out
+// with, as "ferryctl repo-config" would set them. Existing repositories are
+// left alone.
+type RepoDefaultsConfig struct {
+	ConflictPolicy   string            `toml:"conflict_policy"` // "keep", "reject" or "newer"
+	VerifyHashes     bool              `toml:"verify_hashes"`
+	VerifyIndex      bool              `toml:"verify_index"`
+	VerifyDeltas     bool              `toml:"verify_deltas"`
+	ComponentIndexes bool              `toml:"component_indexes"`
+	VersionedIndexes bool              `toml:"versioned_indexes"`
+	Quota            int64             `toml:"quota"` // MiB, 0 for no limit
+	Architectures    []string          `toml:"architectures"`
+	Delta            DeltaPolicyConfig `toml:"delta"`
+}
+
+// DeltaPolicyConfig controls which deltas are produced for a repository
+type DeltaPolicyConfig struct {
+	Disabled    bool  `toml:"disabled"`
+	MaxDeltas   int   `toml:"max_deltas"`   // Per package, 0 for no limit
+	MinDistance int   `toml:"min_distance"` // Releases behind the tip
+	MaxSize     int64 `toml:"max_size"`     // MiB, skip larger packages. 0 for no limit
+}
+
+// settings will return the repository settings for the defaults
+func (r *RepoDefaultsConfig) settings() (*core.RepoSettings, error) {
+	if r.Quota < 0 {
+		return nil, fmt.Errorf("repo_defaults.quota cannot be negative: %d", r.Quota)
+	}
+	if r.Delta.MaxSize < 0 {
+		return nil, fmt.Errorf("repo_defaults.delta.max_size cannot be negative: %d", r.Delta.MaxSize)
+	}
+	settings := &core.RepoSettings{
+		DeltaPolicy: core.DeltaPolicy{
+			Disabled:    r.Delta.Disabled,
+			MaxDeltas:   r.Delta.MaxDeltas,
+			MinDistance: r.Delta.MinDistance,
+			MaxSize:     r.Delta.MaxSize * 1024 * 1024,
+		},
+		VerifyHashes:     r.VerifyHashes,
+		ConflictPolicy:   core.ConflictPolicy(r.ConflictPolicy),
+		VerifyIndex:      r.VerifyIndex,
+		Quota:            r.Quota * 1024 * 1024,
+		Architectures:    r.Architectures,
+		ComponentIndexes: r.ComponentIndexes,
+		VerifyDeltas:     r.VerifyDeltas,
+		VersionedIndexes: r.VersionedIndexes,
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid repo_defaults: %v", err)
+	}
+	return settings, nil
+}
+
+// TempConfig controls the removal of leftover temporary files
+type TempConfig struct {
+	MaxAge   Duration `toml:"max_age"`  // Remove leftovers untouched for this long
+	Interval Duration `toml:"interval"` // Look for leftovers this often, 0 only at startup
+}
+
+// StorageConfig places the pool and repository trees on other filesystems.
+// Each root must exist, and is laid out just like the base directory.
+type StorageConfig struct {
+	Pool  string            `toml:"pool"`  // Root for the pool
+	Repos map[string]string `toml:"repos"` // Repository ID to the root for its tree
+	S3    S3Config          `toml:"s3"`    // Publish the repository trees to a bucket
+}
+
+// S3Config publishes the repository trees to an S3 compatible bucket after
+// each index. Unset credentials are taken from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+type S3Config struct {
+	Endpoint      string   `toml:"endpoint"`
+	Region        string   `toml:"region"`
+	Bucket        string   `toml:"bucket"` // Publishing is disabled when empty
+	Prefix        string   `toml:"prefix"`
+	AccessKey     string   `toml:"access_key"`
+	SecretKey     string   `toml:"secret_key"`
+	VirtualHosted bool     `toml:"virtual_hosted"` // Use bucket.endpoint rather than endpoint/bucket
+	Timeout       Duration `toml:"timeout"`        // Per request, defaults to 10m
+}
+
+// store will return the object store for the bucket, or nil if publishing
+// to S3 hasn't been enabled
+func (s *S3Config) store() (core.ObjectStore, error) {
+	if s.Bucket == "" {
+		return nil, nil
+	}
+	config := &core.S3Config{
+		Endpoint:      s.Endpoint,
+		Region:        s.Region,
+		Bucket:        s.Bucket,
+		Prefix:        s.Prefix,
+		AccessKey:     s.AccessKey,
+		SecretKey:     s.SecretKey,
+		VirtualHosted: s.VirtualHosted,
+		Timeout:       s.Timeout.Duration,
+	}
+	if config.AccessKey == "" {
+		config.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if config.SecretKey == "" {
+		config.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return core.NewS3Store(config)
+}
+
+// roots will return the storage roots for the manager
+func (s *StorageConfig) roots() *core.StorageRoots {
+	return &core.StorageRoots{
+		Pool:  s.Pool,
+		Repos: s.Repos,
+	}
+}
+
+// PublishConfig describes a downstream mirror which repositories are pushed
+// to after each index, either with rsync over ssh or HTTP PUT requests
+type PublishConfig struct {
+	Name  string   `toml:"name"`
+	Type  string   `toml:"type"`  // Either "rsync" or "http"
+	URL   string   `toml:"url"`   // i.e. "mirror@host:/srv/solus" or "https://mirror/solus"
+	Token string   `toml:"token"` // Bearer token for http targets
+	SSH   string   `toml:"ssh"`   // Remote shell used by rsync
+	Repos []string `toml:"repos"` // Only push these repositories, or every one if empty
+}
+
+// publishTargets will return the publish targets for the configuration
+func (c *Config) publishTargets() ([]*core.PublishTarget, error) {
+	var targets []*core.PublishTarget
+	seen := make(map[string]bool)
+	for i := range c.Publish {
+		p := &c.Publish[i]
+		if seen[p.Name] {
+			return nil, fmt.Errorf("publish target %s is defined more than once", p.Name)
+		}
+		seen[p.Name] = true
+		target, err := core.NewPublishTarget(&core.PublishTargetConfig{
+			Name:  p.Name,
+			Type:  p.Type,
+			URL:   p.URL,
+			Token: p.Token,
+			SSH:   p.SSH,
+			Repos: p.Repos,
+		})
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// HookConfig describes a post-publish hook, run after each successful index
+// of a repository. Either a shell command is run or an HTTP request is sent,
+// with the command, url, body and headers expanded as Go templates of the
+// HookVars.
+type HookConfig struct {
+	Name    string            `toml:"name"`
+	Command string            `toml:"command"` // Run with /bin/sh -c
+	URL     string            `toml:"url"`
+	Method  string            `toml:"method"` // Defaults to POST
+	Body    string            `toml:"body"`
+	Headers map[string]string `toml:"headers"`
+	Repos   []string          `toml:"repos"`   // Only run for these repositories, or every one if empty
+	Timeout Duration          `toml:"timeout"` // Defaults to 30s
+}
+
+// postHooks will return the post-publish hooks for the configuration
+func (c *Config) postHooks() ([]*PostHook, error) {
+	var hooks []*PostHook
+	seen := make(map[string]bool)
+	for i := range c.Hooks {
+		h := &c.Hooks[i]
+		if seen[h.Name] {
+			return nil, fmt.Errorf("hook %s is defined more than once", h.Name)
+		}
+		seen[h.Name] = true
+		hook, err := NewPostHook(h)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// WebhookConfig describes a URL that will be sent a JSON POST whenever one
+// of the given events happens
+type WebhookConfig struct {
+	URL    string   `toml:"url"`
+	Events []string `toml:"events"` // i.e. "job.completed", "job.failed"
+}
+
+// TimeoutConfig overrides how long a type of job is expected to take, and
+// how long it may run before it is asked to stop. Either may be "0" to
+// disable it, and anything left out keeps the default.
+type TimeoutConfig struct {
+	Expected Duration `toml:"expected"` // Flag the job as overdue after this long
+	Timeout  Duration `toml:"timeout"`  // Cancel the job after this long
+}
+
+// PriorityConfig sets the CPU and I/O priority of one class of jobs, i.e.
+// "delta", "index" or "default"
+type PriorityConfig struct {
+	Nice    int    `toml:"nice"`     // Added to ferryd's own niceness
+	IOClass string `toml:"io_class"` // "realtime", "best-effort" or "idle"
+	IOLevel int    `toml:"io_level"` // 0 (highest) to 7 within the I/O class
+	Cgroup  string `toml:"cgroup"`   // Threaded cgroup v2 directory to run the jobs in
+}
+
+// APIConfig protects the daemon from clients flooding the API socket
+type APIConfig struct {
+	MaxInFlight int                        `toml:"max_in_flight"` // Requests handled at once, 0 for no limit
+	RateLimits  map[string]RateLimitConfig `toml:"rate_limits"`   // Keyed by the operation, i.e. "ImportPackages"
+}
+
+// RateLimitConfig limits how often one operation may be used
+type RateLimitConfig struct {
+	Rate  float64 `toml:"rate"`  // Requests per second on average
+	Burst int     `toml:"burst"` // Requests allowed at once, defaults to 1
+}
+
+// rateLimits will return the configured rate limits
+func (a *APIConfig) rateLimits() map[string]RateLimit {
+	ret := make(map[string]RateLimit)
+	for op, l := range a.RateLimits {
+		ret[op] = RateLimit{
+			Rate:  l.Rate,
+			Burst: l.Burst,
+		}
+	}
+	return ret
+}
+
+// validate will ensure the limits make sense, filling in the default burst
+func (a *APIConfig) validate() error {
+	if a.MaxInFlight < 0 {
+		return fmt.Errorf("api.max_in_flight cannot be negative: %d", a.MaxInFlight)
+	}
+	for op, l := range a.RateLimits {
+		if l.Rate <= 0 {
+			return fmt.Errorf("rate limit for %s must be above 0: %v", op, l.Rate)
+		}
+		if l.Burst < 0 {
+			return fmt.Errorf("burst for %s cannot be negative: %d", op, l.Burst)
+		}
+		if l.Burst == 0 {
+			l.Burst = 1
+			a.RateLimits[op] = l
+		}
+	}
+	return nil
+}
+
+// AuthConfig controls which clients may use the privileged operations
+type AuthConfig struct {
+	Group string `toml:"group"` // Allowed besides root, or only root if empty
+}
+
+// ReplicationConfig makes this ferryd a replica of another, following the
+// primary's repositories over its API socket. A primary on another host can
+// be reached by forwarding its socket, i.e. with ssh.
+type ReplicationConfig struct {
+	Primary  string   `toml:"primary"`  // Socket of the primary, replication is off if empty
+	Repos    []string `toml:"repos"`    // Repositories to follow, or all of them if empty
+	Interval Duration `toml:"interval"` // Replicate everything this often regardless, 0 disables
+}
+
+// follows will determine if the repository should be replicated
+func (r *ReplicationConfig) follows(repoID string) bool {
+	if repoID == "" {
+		return false
+	}
+	if len(r.Repos) == 0 {
+		return true
+	}
+	for _, id := range r.Repos {
+		if id == repoID {
+			return true
+		}
+	}
+	return false
+}
+
+// Config is the ferryd configuration file. Command line flags always take
+// priority over values set in the file.
+//
+// Everything except the base directory, socket, database and storage settings
+// can be changed at runtime by editing the file and sending ferryd a SIGHUP.
+type Config struct {
+	BaseDir      string             `toml:"base"`
+	Socket       string             `toml:"socket"`
+	Jobs         int                `toml:"jobs"`
+	HTTP         string             `toml:"http"`
+	Metrics      string             `toml:"metrics"`        // Serve Prometheus metrics on this address
+	Database     string             `toml:"database"`       // Backend for new databases, i.e. "leveldb" or "bolt"
+	Standby      bool               `toml:"standby"`        // Only serve reads until promoted
+	Undo         Duration           `toml:"undo_retention"` // Keep automatic snapshots this long, 0 disables
+	Log          LogConfig          `toml:"log"`
+	Compression  CompressionConfig  `toml:"compression"`
+	Disk         DiskConfig         `toml:"disk"`
+	Hash         HashConfig         `toml:"hash"`
+	Index        IndexConfig        `toml:"index"`
+	Delta        DeltaConfig        `toml:"delta"`
+	RepoDefaults RepoDefaultsConfig `toml:"repo_defaults"`
+	Temp         TempConfig         `toml:"temp"`
+	Storage      StorageConfig      `toml:"storage"`
+	Publish      []PublishConfig    `toml:"publish"`
+	Hooks        []HookConfig       `toml:"hook"`
+	Webhooks     []WebhookConfig    `toml:"webhook"`
+	API          APIConfig          `toml:"api"`
+	Auth         AuthConfig         `toml:"auth"`
+	Replication  ReplicationConfig  `toml:"replication"`
+
+	// Keyed by the job type, i.e. "DeltaPair"
+	Timeouts map[string]TimeoutConfig `toml:"timeouts"`
+
+	// Keyed by the job class, i.e. "delta"
+	Priorities map[string]PriorityConfig `toml:"priority"`
+}
+
+// NewConfig will return a Config populated with the defaults
+func NewConfig() *Config {
+	return &Config{
+		BaseDir: "/var/lib/ferryd",
+		Socket:  "/run/ferryd.sock",
+		Jobs:    -1,
+		Undo:    Duration{core.DefaultUndoRetention},
+		Log: LogConfig{
+			Format:     "text",
+			MaxBackups: 5,
+		},
+		Compression: CompressionConfig{
+			Level:    6,
+			Threads:  2,
+			Xz:       libeopkg.DefaultXzCompressor,
+			Internal: libeopkg.DefaultInternalCompressor,
+		},
+		Disk: DiskConfig{
+			MinFree: 1024,
+		},
+		Hash: HashConfig{
+			ChunkSize: core.DefaultHashChunkSize / 1024,
+			ReadAhead: core.DefaultHashReadAhead,
+		},
+		Index: IndexConfig{
+			Buffer:      core.DefaultIndexBufferSize / 1024,
+			Preallocate: true,
+			Debounce:    Duration{jobs.DefaultIndexDelay},
+		},
+		Delta: DeltaConfig{
+			SkipExpiry: Duration{core.DefaultDeltaSkipExpiry},
+		},
+		RepoDefaults: RepoDefaultsConfig{
+			ConflictPolicy: string(core.ConflictKeep),
+		},
+		Temp: TempConfig{
+			MaxAge:   Duration{core.DefaultTempMaxAge},
+			Interval: Duration{DefaultTempCleanInterval},
+		},
+		API: APIConfig{
+			MaxInFlight: 64,
+		},
+		Auth: AuthConfig{
+			Group: DefaultAuthGroup,
+		},
+		Replication: ReplicationConfig{
+			Interval: Duration{DefaultReplicationInterval},
+		},
+	}
+}
+
+// LoadConfig will return the configuration from the given path, with any
+// explicitly set command line flags applied over the top. A missing file
+// is only an error if the user asked for it explicitly.
+func LoadConfig(path string) (*Config, error) {
+	c := NewConfig()
+
+	var md toml.MetaData
+	if core.PathExists(path) || pflag.CommandLine.Changed("config") {
+		var err error
+		if md, err = toml.DecodeFile(path, c); err != nil {
+			return nil, fmt.Errorf("failed to load config %s: %v", path, err)
+		}
+	}
+	// Misspelt settings would otherwise be silently ignored
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return nil, fmt.Errorf("unknown settings in %s: %s", path, strings.Join(keys, ", "))
+	}
+	if err := c.resolveTimeouts(md); err != nil {
+		return nil, err
+	}
+	if _, err := c.jobPriorities(); err != nil {
+		return nil, err
+	}
+
+	c.applyFlags()
+
+	// Ensure all joined directories are correct
+	b, err := filepath.Abs(c.BaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve directory %v: %v", c.BaseDir, err)
+	}
+	c.BaseDir = b
+
+	if c.Storage.Pool != "" {
+		if c.Storage.Pool, err = filepath.Abs(c.Storage.Pool); err != nil {
+			return nil, fmt.Errorf("cannot resolve directory %v: %v", c.Storage.Pool, err)
+		}
+	}
+	for id, root := range c.Storage.Repos {
+		if c.Storage.Repos[id], err = filepath.Abs(root); err != nil {
+			return nil, fmt.Errorf("cannot resolve directory %v: %v", root, err)
+		}
+	}
+
+	if _, err := c.Storage.S3.store(); err != nil {
+		return nil, err
+	}
+	if _, err := c.publishTargets(); err != nil {
+		return nil, err
+	}
+	if _, err := c.postHooks(); err != nil {
+		return nil, err
+	}
+	for i := range c.Webhooks {
+		if err := c.Webhooks[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := c.RepoDefaults.settings(); err != nil {
+		return nil, err
+	}
+	for _, addr := range []struct{ name, value string }{{"http", c.HTTP}, {"metrics", c.Metrics}} {
+		if addr.value == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(addr.value); err != nil {
+			return nil, fmt.Errorf("invalid %s address %s: %v", addr.name, addr.value, err)
+		}
+	}
+
+	if c.Undo.Duration < 0 {
+		return nil, fmt.Errorf("undo_retention cannot be negative: %v", c.Undo.Duration)
+	}
+	if c.Disk.MinFree < 0 {
+		return nil, fmt.Errorf("disk.min_free cannot be negative: %d", c.Disk.MinFree)
+	}
+	if c.Hash.ChunkSize <= 0 {
+		return nil, fmt.Errorf("hash.chunk_size must be positive: %d", c.Hash.ChunkSize)
+	}
+	if c.Hash.ReadAhead < 0 {
+		return nil, fmt.Errorf("hash.read_ahead cannot be negative: %d", c.Hash.ReadAhead)
+	}
+	if c.Hash.Parallel < 0 {
+		return nil, fmt.Errorf("hash.parallel cannot be negative: %d", c.Hash.Parallel)
+	}
+	if c.Index.Buffer < 4 {
+		return nil, fmt.Errorf("index.buffer must be at least 4: %d", c.Index.Buffer)
+	}
+	if c.Index.Debounce.Duration < 0 {
+		return nil, fmt.Errorf("index.debounce cannot be negative: %v", c.Index.Debounce.Duration)
+	}
+	if c.Delta.SkipExpiry.Duration < 0 {
+		return nil, fmt.Errorf("delta.skip_expiry cannot be negative: %v", c.Delta.SkipExpiry.Duration)
+	}
+	if c.Temp.MaxAge.Duration <= 0 {
+		return nil, fmt.Errorf("temp.max_age must be positive: %v", c.Temp.MaxAge.Duration)
+	}
+	if c.Temp.Interval.Duration < 0 {
+		return nil, fmt.Errorf("temp.interval cannot be negative: %v", c.Temp.Interval.Duration)
+	}
+	if err := c.API.validate(); err != nil {
+		return nil, err
+	}
+	if c.Replication.Interval.Duration < 0 {
+		return nil, fmt.Errorf("replication.interval cannot be negative: %v", c.Replication.Interval.Duration)
+	}
+	if c.Replication.Primary != "" {
+		if c.Replication.Primary, err = filepath.Abs(c.Replication.Primary); err != nil {
+			return nil, fmt.Errorf("cannot resolve socket %v: %v", c.Replication.Primary, err)
+		}
+		if c.Replication.Primary == c.Socket {
+			return nil, fmt.Errorf("replication.primary cannot be our own socket: %s", c.Socket)
+		}
+	}
+
+	if c.Compression.Level < 0 || c.Compression.Level > 9 {
+		return nil, fmt.Errorf("compression.level must be between 0 and 9: %d", c.Compression.Level)
+	}
+	if c.Compression.Threads < 0 {
+		return nil, fmt.Errorf("compression.threads cannot be negative: %d", c.Compression.Threads)
+	}
+	if c.Compression.Memory < 0 {
+		return nil, fmt.Errorf("compression.memory cannot be negative: %d", c.Compression.Memory)
+	}
+	xz, err := libeopkg.GetCompressor(c.Compression.Xz)
+	if err != nil {
+		return nil, err
+	}
+	if xz.Suffix() != ".xz" {
+		return nil, fmt.Errorf("compressor %s cannot be used for xz files", c.Compression.Xz)
+	}
+	if _, err := libeopkg.GetCompressor(c.Compression.Internal); err != nil {
+		return nil, err
+	}
+
+	if c.Database != "" {
+		if _, _, err := libdb.ParseURI(c.Database + "://"); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Log.MaxSize < 0 || c.Log.MaxBackups < 0 {
+		return nil, fmt.Errorf("log.max_size and log.max_backups cannot be negative")
+	}
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", c.Log.Format)
+	}
+	return c, nil
+}
+
+// resolveTimeouts will ensure each job type in the timeouts exists, filling
+// in the defaults for anything which wasn't set
+func (c *Config) resolveTimeouts(md toml.MetaData) error {
+	for name, t := range c.Timeouts {
+		limit, ok := jobs.DefaultJobLimits[jobs.JobType(name)]
+		if !ok {
+			return fmt.Errorf("unknown job type in timeouts: %s", name)
+		}
+		if !md.IsDefined("timeouts", name, "expected") {
+			t.Expected.Duration = limit.Expected
+		}
+		if !md.IsDefined("timeouts", name, "timeout") {
+			t.Timeout.Duration = limit.Timeout
+		}
+		if t.Expected.Duration < 0 || t.Timeout.Duration < 0 {
+			return fmt.Errorf("timeouts for %s cannot be negative", name)
+		}
+		c.Timeouts[name] = t
+	}
+	return nil
+}
+
+// jobLimits will return the configured overrides for the job limits
+func (c *Config) jobLimits() map[jobs.JobType]jobs.JobLimit {
+	ret := make(map[jobs.JobType]jobs.JobLimit)
+	for name, t := range c.Timeouts {
+		ret[jobs.JobType(name)] = jobs.JobLimit{
+			Expected: t.Expected.Duration,
+			Timeout:  t.Timeout.Duration,
+		}
+	}
+	return ret
+}
+
+// jobPriorities will return the configured priority of each job class
+func (c *Config) jobPriorities() (map[string]jobs.Priority, error) {
+	ret := make(map[string]jobs.Priority)
+	for class, p := range c.Priorities {
+		switch class {
+		case jobs.ClassDelta, jobs.ClassIndex, jobs.ClassDefault:
+		default:
+			return nil, fmt.Errorf("unknown job class in priority: %s", class)
+		}
+		priority := jobs.Priority{
+			Nice:    p.Nice,
+			IOClass: p.IOClass,
+			IOLevel: p.IOLevel,
+			Cgroup:  p.Cgroup,
+		}
+		if err := priority.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid priority for %s jobs: %v", class, err)
+		}
+		ret[class] = priority
+	}
+	return ret, nil
+}
+
+// applyFlags will override configuration values with any flags that were
+// explicitly passed on the command line
+func (c *Config) applyFlags() {
+	flags := pflag.CommandLine
+	if flags.Changed("base") {
+		c.BaseDir = baseDir
+	}
+	if flags.Changed("socket") {
+		c.Socket = socketPath
+	}
+	if flags.Changed("jobs") {
+		c.Jobs = backgroundJobCount
+	}
+	if flags.Changed("http") {
+		c.HTTP = httpAddress
+	}
+	if flags.Changed("log-format") {
+		c.Log.Format = logFormat
+	}
+	if flags.Changed("log-max-size") {
+		c.Log.MaxSize = logMaxSize
+	}
+	if flags.Changed("log-max-backups") {
+		c.Log.MaxBackups = logMaxBackups
+	}
+	if flags.Changed("log-compress") {
+		c.Log.Compress = logCompress
+	}
+	if flags.Changed("standby") {
+		c.Standby = standbyMode
+	}
+}
+
+// redacted will return a copy of the configuration with the credentials
+// blanked out, so that it may be shown. Hook headers are redacted as they
+// usually carry credentials.
+func (c *Config) redacted() *Config {
+	r := *c
+	if r.Storage.S3.SecretKey != "" {
+		r.Storage.S3.SecretKey = redactedSecret
+	}
+	r.Publish = append([]PublishConfig(nil), c.Publish...)
+	for i := range r.Publish {
+		if r.Publish[i].Token != "" {
+			r.Publish[i].Token = redactedSecret
+		}
+	}
+	r.Hooks = append([]HookConfig(nil), c.Hooks...)
+	for i := range r.Hooks {
+		headers := make(map[string]string)
+		for k := range r.Hooks[i].Headers {
+			headers[k] = redactedSecret
+		}
+		r.Hooks[i].Headers = headers
+	}
+	return &r
+}
+
+// checkConfig will ensure the directories the configuration relies upon
+// exist, and then write out the effective configuration, for
+// "ferryd check-config"
+func checkConfig(c *Config, w io.Writer) error {
+	dirs := []string{c.BaseDir}
+	if c.Storage.Pool != "" {
+		dirs = append(dirs, c.Storage.Pool)
+	}
+	for _, root := range c.Storage.Repos {
+		dirs = append(dirs, root)
+	}
+	for _, dir := range dirs {
+		st, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("directory %s is unavailable: %v", dir, err)
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+
+	source := configPath
+	if !core.PathExists(configPath) {
+		source = "the defaults, " + configPath + " doesn't exist"
+	}
+	fmt.Fprintf(w, "# Effective configuration from %s, with any flags applied\n\n", source)
+	return toml.NewEncoder(w).Encode(c.redacted())
+}
@@ -0,0 +1,116 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"libferry"
+	"sync"
+	"time"
+)
+
+const (
+	// ConfirmationTimeout is how long a confirmation token remains valid
+	ConfirmationTimeout = 2 * time.Minute
+)
+
+var (
+	// ErrInvalidConfirmation is returned when a token is unknown, expired,
+	// or was issued for a different action
+	ErrInvalidConfirmation = errors.New("Invalid or expired confirmation token")
+)
+
+// A pendingConfirmation is an action awaiting confirmation from the client
+type pendingConfirmation struct {
+	action  string
+	expires time.Time
+}
+
+// The ConfirmationStore hands out one-time tokens for destructive actions.
+// A token is bound to the exact action it was issued for, so it can't be
+// used to confirm anything else.
+type ConfirmationStore struct {
+	pending map[string]*pendingConfirmation
+	mut     *sync.Mutex
+}
+
+// NewConfirmationStore will return a new, empty ConfirmationStore
+func NewConfirmationStore() *ConfirmationStore {
+	return &ConfirmationStore{
+		pending: make(map[string]*pendingConfirmation),
+		mut:     &sync.Mutex{},
+	}
+}
+
+// newToken will return a random token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// expire will forget any tokens that are past their expiry time
+func (c *ConfirmationStore) expire(now time.Time) {
+	for token, p := range c.pending {
+		if now.After(p.expires) {
+			delete(c.pending, token)
+		}
+	}
+}
+
+// Check will determine whether the action has been confirmed. If it has not,
+// a new confirmation is returned which the client must send back to us.
+func (c *ConfirmationStore) Check(action string, conf *libferry.Confirmation) (*libferry.ConfirmationResponse, error) {
+	if conf.Force {
+		return nil, nil
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := time.Now().UTC()
+	c.expire(now)
+
+	if conf.Token != "" {
+		p, ok := c.pending[conf.Token]
+		if !ok || p.action != action {
+			return nil, ErrInvalidConfirmation
+		}
+		delete(c.pending, conf.Token)
+		return nil, nil
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	p := &pendingConfirmation{
+		action:  action,
+		expires: now.Add(ConfirmationTimeout),
+	}
+	c.pending[token] = p
+
+	return &libferry.ConfirmationResponse{
+		Token:   token,
+		Action:  action,
+		Expires: p.expires,
+	}, nil
+}
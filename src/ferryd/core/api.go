@@ -17,8 +17,12 @@
 package core
 
 import (
+	"context"
 	"libeopkg"
+	"path"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
 // This file provides the public API functions which are used by ferryd
@@ -29,6 +33,7 @@ func (m *Manager) CreateRepo(id string) error {
 	if _, err := m.repo.CreateRepo(m.db, id); err != nil {
 		return err
 	}
+	m.emit(RepoCreated, id)
 	// Index the newly created repo
 	return m.Index(id)
 }
@@ -51,9 +56,23 @@ func (m *Manager) CloneRepo(repoID, newClone string, fullClone bool) error {
 	if err != nil {
 		return err
 	}
+	m.emit(RepoCreated, newClone)
+
+	// The clone inherits the bans, so that it doesn't receive anything
+	// banned in the source since it was added there
+	if len(sourceRepo.Bans) > 0 {
+		for i := range sourceRepo.Bans {
+			if err = m.repo.AddBan(m.db, newClone, &sourceRepo.Bans[i]); err != nil {
+				return err
+			}
+		}
+		if newRepo, err = m.repo.GetRepo(m.db, newClone); err != nil {
+			return err
+		}
+	}
 
 	// Now ask it to clone..
-	if err = newRepo.CloneFrom(m.db, m.pool, sourceRepo, fullClone); err != nil {
+	if err = newRepo.CloneFrom(m.logger, m.db, m.pool, sourceRepo, fullClone); err != nil {
 		return err
 	}
 
@@ -75,8 +94,12 @@ func (m *Manager) PullRepo(sourceID, targetID string) ([]string, error) {
 		return nil, err
 	}
 
+	if err = m.markDirty(targetID); err != nil {
+		return nil, err
+	}
+
 	// Now ask it to pull..
-	changed, err := targetRepo.PullFrom(m.db, m.pool, sourceRepo)
+	changed, err := targetRepo.PullFrom(m.logger, m.db, m.pool, sourceRepo)
 	if err != nil {
 		return nil, err
 	}
@@ -90,6 +113,49 @@ func (m *Manager) PullRepo(sourceID, targetID string) ([]string, error) {
 	return changed, nil
 }
 
+// PullSource will pull the tip of a single source from one repo, the source
+// ID, into the target repository
+func (m *Manager) PullSource(sourceID, targetID, sourceName string) ([]string, error) {
+	sourceRepo, err := m.repo.GetRepo(m.db, sourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	targetRepo, err := m.repo.GetRepo(m.db, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.markDirty(targetID); err != nil {
+		return nil, err
+	}
+
+	changed, err := targetRepo.PullSourceFrom(m.logger, m.db, m.pool, sourceRepo, sourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.Index(targetID); err != nil {
+		return nil, err
+	}
+
+	return changed, nil
+}
+
+// DiffRepos will compare the target repository against the source, which is
+// how PullRepo determines what to copy
+func (m *Manager) DiffRepos(sourceID, targetID string) (*RepoDiff, error) {
+	sourceRepo, err := m.repo.GetRepo(m.db, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	targetRepo, err := m.repo.GetRepo(m.db, targetID)
+	if err != nil {
+		return nil, err
+	}
+	return targetRepo.DiffFrom(m.db, m.pool, sourceRepo)
+}
+
 // RemoveSource will ask the repo to remove all matching source==release
 // packages.
 func (m *Manager) RemoveSource(repoID, sourceID string, release int) error {
@@ -98,7 +164,10 @@ func (m *Manager) RemoveSource(repoID, sourceID string, release int) error {
 		return err
 	}
 
-	if err = repo.RemoveSource(m.db, m.pool, sourceID, release); err != nil {
+	if err = m.markDirty(repoID); err != nil {
+		return err
+	}
+	if err = repo.RemoveSource(m.logger, m.db, m.pool, sourceID, release); err != nil {
 		return err
 	}
 
@@ -117,13 +186,70 @@ func (m *Manager) CopySource(repoID, target, sourceID string, release int) error
 		return err
 	}
 
-	if err = targetRepo.CopySourceFrom(m.db, m.pool, sourceRepo, sourceID, release); err != nil {
+	if err = m.markDirty(target); err != nil {
+		return err
+	}
+	if err = targetRepo.CopySourceFrom(m.logger, m.db, m.pool, sourceRepo, sourceID, release); err != nil {
 		return err
 	}
 
 	return m.Index(repoID)
 }
 
+// CheckPromotion will apply the promotion policy checks without promoting
+// anything, returning a PromotionError if they fail
+func (m *Manager) CheckPromotion(repoID, target, sourceID string, release int) error {
+	sourceRepo, err := m.repo.GetRepo(m.db, repoID)
+	if err != nil {
+		return err
+	}
+	targetRepo, err := m.repo.GetRepo(m.db, target)
+	if err != nil {
+		return err
+	}
+	return targetRepo.CheckPromotion(m.db, m.pool, sourceRepo, sourceID, release)
+}
+
+// PromoteSource will copy the packages built from the source & release into
+// the target repository if the promotion policy checks pass, then index it.
+// When move is set the packages are then removed from the source repository.
+// The names of the promoted packages are returned.
+func (m *Manager) PromoteSource(repoID, target, sourceID string, release int, move bool) ([]string, error) {
+	sourceRepo, err := m.repo.GetRepo(m.db, repoID)
+	if err != nil {
+		return nil, err
+	}
+	targetRepo, err := m.repo.GetRepo(m.db, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = m.markDirty(target); err != nil {
+		return nil, err
+	}
+	names, err := targetRepo.PromoteFrom(m.logger, m.db, m.pool, sourceRepo, sourceID, release)
+	if err != nil {
+		return nil, err
+	}
+	if err = m.Index(target); err != nil {
+		return nil, err
+	}
+
+	// Only take it out of the source once the target is published
+	if move {
+		if err = m.markDirty(repoID); err != nil {
+			return nil, err
+		}
+		if err = sourceRepo.RemoveSource(m.logger, m.db, m.pool, sourceID, release); err != nil {
+			return nil, err
+		}
+		if err = m.Index(repoID); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
 // TrimObsolete will ask the repo to remove obsolete packages
 func (m *Manager) TrimObsolete(repoID string) error {
 	repo, err := m.repo.GetRepo(m.db, repoID)
@@ -131,7 +257,10 @@ func (m *Manager) TrimObsolete(repoID string) error {
 		return err
 	}
 
-	if err = repo.TrimObsolete(m.db, m.pool); err != nil {
+	if err = m.markDirty(repoID); err != nil {
+		return err
+	}
+	if err = repo.TrimObsolete(m.logger, m.db, m.pool); err != nil {
 		return err
 	}
 
@@ -145,7 +274,10 @@ func (m *Manager) TrimPackages(repoID string, maxKeep int) error {
 		return err
 	}
 
-	if err = repo.TrimPackages(m.db, m.pool, maxKeep); err != nil {
+	if err = m.markDirty(repoID); err != nil {
+		return err
+	}
+	if err = repo.TrimPackages(m.logger, m.db, m.pool, maxKeep); err != nil {
 		return err
 	}
 
@@ -159,7 +291,255 @@ func (m *Manager) GetRepos() ([]*Repository, error) {
 
 // DeleteRepo exposes the API for repository deletion
 func (m *Manager) DeleteRepo(id string) error {
-	return m.repo.DeleteRepo(m.db, m.pool, id)
+	if err := m.repo.DeleteRepo(m.logger, m.db, m.pool, id); err != nil {
+		return err
+	}
+	if err := clearRepoConflicts(m.db, id); err != nil {
+		return err
+	}
+	if err := removeIndexReport(m.db, id); err != nil {
+		return err
+	}
+	if err := removeObjects(m.logger, m.db, m.store, id); err != nil {
+		return err
+	}
+	if err := m.mirror.forgetRepo(m.logger, m.db, id); err != nil {
+		return err
+	}
+	if err := m.search.RemoveRepo(m.db, id); err != nil {
+		return err
+	}
+	m.emit(RepoDeleted, id)
+	return nil
+}
+
+// CreateSnapshot will record the current state of the repository. If no
+// name is given, one is generated from the current time.
+func (m *Manager) CreateSnapshot(repoID, name string) (*Snapshot, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = DefaultSnapshotName()
+	}
+	return m.snaps.CreateSnapshot(m.db, m.pool, repo, name)
+}
+
+// GetSnapshots will return all snapshots of the repository, oldest first.
+// Snapshots outlive their repository, so it doesn't need to exist.
+func (m *Manager) GetSnapshots(repoID string) ([]*Snapshot, error) {
+	return m.snaps.GetSnapshots(m.db, repoID)
+}
+
+// SetUndoRetention will change how long automatic snapshots are kept for
+func (m *Manager) SetUndoRetention(retention time.Duration) {
+	m.snaps.SetUndoRetention(retention)
+}
+
+// UndoRetention returns how long automatic snapshots are kept for
+func (m *Manager) UndoRetention() time.Duration {
+	return m.snaps.UndoRetention()
+}
+
+// CreateUndoSnapshot is called by destructive jobs before they modify the
+// repository, so that they can be undone later. Nothing is recorded if the
+// repository doesn't exist (yet).
+func (m *Manager) CreateUndoSnapshot(repoID, jobID, description string) error {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil
+	}
+	return m.snaps.CreateUndoSnapshot(m.logger, m.db, m.pool, repo, jobID, description)
+}
+
+// GetUndoSnapshots will return every automatic snapshot which can still be
+// used to undo a job, oldest first
+func (m *Manager) GetUndoSnapshots() ([]*Snapshot, error) {
+	if err := m.snaps.PruneUndoSnapshots(m.logger, m.db, m.pool); err != nil {
+		return nil, err
+	}
+	snaps, err := m.snaps.GetSnapshots(m.db, "")
+	if err != nil {
+		return nil, err
+	}
+	var ret []*Snapshot
+	for _, snap := range snaps {
+		if snap.Automatic {
+			ret = append(ret, snap)
+		}
+	}
+	return ret, nil
+}
+
+// GetUndoSnapshot will return the automatic snapshot taken before the job
+func (m *Manager) GetUndoSnapshot(jobID string) (*Snapshot, error) {
+	return m.snaps.GetUndoSnapshot(m.db, jobID)
+}
+
+// GetSnapshot will return the named snapshot of the repository
+func (m *Manager) GetSnapshot(repoID, name string) (*Snapshot, error) {
+	return m.snaps.GetSnapshot(m.db, repoID, name)
+}
+
+// RestoreSnapshot will rewind the repository to the named snapshot. If the
+// repository has since been deleted, it is created again.
+func (m *Manager) RestoreSnapshot(repoID, name string) error {
+	snap, err := m.snaps.GetSnapshot(m.db, repoID, name)
+	if err != nil {
+		return err
+	}
+
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		if repo, err = m.repo.CreateRepo(m.db, repoID); err != nil {
+			return err
+		}
+	}
+
+	if err := m.markDirty(repoID); err != nil {
+		return err
+	}
+	if err := repo.RestoreEntries(m.logger, m.db, m.pool, snap.Entries); err != nil {
+		return err
+	}
+	return m.Index(repoID)
+}
+
+// DeleteSnapshot will remove the named snapshot, allowing any packages only
+// it was holding on to to leave the pool
+func (m *Manager) DeleteSnapshot(repoID, name string) error {
+	return m.snaps.DeleteSnapshot(m.logger, m.db, m.pool, repoID, name)
+}
+
+// RecordHistory will run the operation, recording the packages it added to
+// or removed from each of the repositories in their history.
+func (m *Manager) RecordHistory(entry *HistoryEntry, repoIDs []string, op func() error) error {
+	return m.hist.Record(m.db, m.repo, entry, repoIDs, op)
+}
+
+// GetHistory will return the history of the repository, newest first,
+// optionally limited to the given package name
+func (m *Manager) GetHistory(repoID, pkgName string, limit int) ([]*HistoryEntry, error) {
+	return m.hist.GetEntries(m.db, repoID, pkgName, limit)
+}
+
+// SetRepoDefaults will change the settings that repositories created from
+// now on start out with
+func (m *Manager) SetRepoDefaults(settings *RepoSettings) error {
+	return m.repo.SetDefaults(settings)
+}
+
+// SetDeltaPolicy will change which deltas are produced for the repository
+func (m *Manager) SetDeltaPolicy(repoID string, policy *DeltaPolicy) error {
+	return m.repo.SetDeltaPolicy(m.db, repoID, policy)
+}
+
+// SetVerifyHashes will change whether packages imported into the repository
+// have their contents verified first
+func (m *Manager) SetVerifyHashes(repoID string, verify bool) error {
+	return m.repo.SetVerifyHashes(m.db, repoID, verify)
+}
+
+// SetVerifyIndex will change whether the repository's index is validated
+// before being published
+func (m *Manager) SetVerifyIndex(repoID string, verify bool) error {
+	return m.repo.SetVerifyIndex(m.db, repoID, verify)
+}
+
+// SetVerifyDeltas will change whether deltas produced for the repository are
+// verified before they can be indexed
+func (m *Manager) SetVerifyDeltas(repoID string, verify bool) error {
+	return m.repo.SetVerifyDeltas(m.db, repoID, verify)
+}
+
+// SetVersionedIndexes will change whether the repository publishes each
+// index as a complete generation at once, from the next index
+func (m *Manager) SetVersionedIndexes(repoID string, enabled bool) error {
+	return m.repo.SetVersionedIndexes(m.db, repoID, enabled)
+}
+
+// SetComponentIndexes will change whether the repository is also indexed
+// per component, so tooling can fetch only what it needs
+func (m *Manager) SetComponentIndexes(repoID string, enabled bool) error {
+	return m.repo.SetComponentIndexes(m.db, repoID, enabled)
+}
+
+// SetArchitectures will change which architectures packages entering the
+// repository may be built for, each of which gets its own index
+func (m *Manager) SetArchitectures(repoID string, archs []string) error {
+	return m.repo.SetArchitectures(m.db, repoID, archs)
+}
+
+// HoldSource will stop the source being pulled or promoted into the repository
+func (m *Manager) HoldSource(repoID, sourceID string) error {
+	return m.repo.HoldSource(m.db, repoID, sourceID)
+}
+
+// UnholdSource will allow the source to be pulled or promoted into the
+// repository again
+func (m *Manager) UnholdSource(repoID, sourceID string) error {
+	return m.repo.UnholdSource(m.db, repoID, sourceID)
+}
+
+// AddBan will stop matching packages being introduced into the repository
+func (m *Manager) AddBan(repoID string, ban *Ban) error {
+	return m.repo.AddBan(m.db, repoID, ban)
+}
+
+// RemoveBan will lift the matching ban from the repository
+func (m *Manager) RemoveBan(repoID string, ban *Ban) error {
+	return m.repo.RemoveBan(m.db, repoID, ban)
+}
+
+// ValidateIndex will check that the published index of the repository
+// matches the packages on disk
+func (m *Manager) ValidateIndex(repoID string) error {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return err
+	}
+	return repo.ValidateIndex()
+}
+
+// SetConflictPolicy will change how the repository handles imports which
+// duplicate the release number of the published package
+func (m *Manager) SetConflictPolicy(repoID string, policy ConflictPolicy) error {
+	return m.repo.SetConflictPolicy(m.db, repoID, policy)
+}
+
+// GetConflicts will return the outstanding release conflicts. If repoID is
+// empty, the conflicts of every repository are returned.
+func (m *Manager) GetConflicts(repoID string) ([]*ReleaseConflict, error) {
+	return GetConflicts(m.db, repoID)
+}
+
+// GetAssets will return the assets installed in the repository
+func (m *Manager) GetAssets(repoID string) ([]*Asset, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAssets()
+}
+
+// GetAsset will return the contents of the named repository asset
+func (m *Manager) GetAsset(repoID, name string) ([]byte, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetAsset(name)
+}
+
+// SetAsset will validate and install the named repository asset. The
+// repository must be reindexed afterwards.
+func (m *Manager) SetAsset(repoID, name string, data []byte) error {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return err
+	}
+	return repo.SetAsset(name, data)
 }
 
 // GetRepo will grab the repository if it exists
@@ -173,30 +553,278 @@ func (m *Manager) GetPoolItems() ([]*PoolEntry, error) {
 	return m.pool.GetPoolItems(m.db)
 }
 
-// AddPackages will attempt to add the named packages to the repository
-func (m *Manager) AddPackages(repoID string, packages []string, anal bool) error {
+// ListPoolItems will return the page of pool items matching the query, and
+// how many match in total
+func (m *Manager) ListPoolItems(query *PoolQuery) ([]*PoolEntry, int, error) {
+	return m.pool.ListPoolItems(m.db, query)
+}
+
+// PoolLayout returns the layout used for new files in the pool
+func (m *Manager) PoolLayout() PoolLayout {
+	return m.pool.Layout()
+}
+
+// MigratePoolLayout will switch the pool to the layout, and move every file
+// already in the pool into place. The number of files moved is returned.
+func (m *Manager) MigratePoolLayout(layout PoolLayout, progress PoolLayoutProgressFunc) (int, error) {
+	return m.pool.MigrateLayout(m.logger, m.db, layout, progress)
+}
+
+// RelinkRepo will ensure every file in the repository tree is the one in the
+// pool, replacing any left pointing at old files once the pool has moved
+func (m *Manager) RelinkRepo(repoID string, progress RelinkProgressFunc) (*RelinkResult, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Relink(m.logger, m.db, m.pool, progress)
+}
+
+// SetObjectStore will publish the repository trees to the store after each
+// index. It must be set before any jobs run.
+func (m *Manager) SetObjectStore(store ObjectStore) {
+	m.store = store
+}
+
+// ObjectStore returns where the repository trees are published, if anywhere
+func (m *Manager) ObjectStore() ObjectStore {
+	return m.store
+}
+
+// SetRepoEventFunc will set the function told about changes to the
+// repositories. It must be set before any jobs run.
+func (m *Manager) SetRepoEventFunc(f RepoEventFunc) {
+	m.events = f
+}
+
+// emit will report the event to the RepoEventFunc, if set
+func (m *Manager) emit(event, repoID string) {
+	m.summaries.forget(repoID)
+	if m.events != nil {
+		m.events(event, repoID)
+	}
+}
+
+// SetPublishTargets will replace the mirrors which repositories are pushed to
+// after each index
+func (m *Manager) SetPublishTargets(targets []*PublishTarget) {
+	m.mirror.SetTargets(targets)
+}
+
+// SetPublishPendingFunc will set the function called whenever a repository
+// has been indexed and needs pushing to the publish targets
+func (m *Manager) SetPublishPendingFunc(f func()) {
+	m.mirror.SetPendingFunc(f)
+}
+
+// GetPublishStates will return the sync state of each repository on the
+// publish targets
+func (m *Manager) GetPublishStates() ([]*PublishState, error) {
+	return m.mirror.States(m.db)
+}
+
+// DuePublishes will return every push to a publish target which should be
+// attempted now, the oldest first
+func (m *Manager) DuePublishes() ([]*PublishState, error) {
+	return m.mirror.Due(m.db)
+}
+
+// Publish will push the repository to the publish target. The sync state is
+// returned, even if the push itself failed.
+func (m *Manager) Publish(ctx context.Context, target, repoID string) (*PublishState, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		// Deleted while the push was pending
+		if ferr := m.mirror.forgetRepo(m.logger, m.db, repoID); ferr != nil {
+			return nil, ferr
+		}
+		return nil, err
+	}
+	return m.mirror.Push(ctx, m.logger, m.db, target, repo)
+}
+
+// checkDisk will ensure there is room for needed more bytes in the base
+// directory, and in the pool and repository tree when stored elsewhere
+func (m *Manager) checkDisk(repo *Repository, needed int64) error {
+	if err := m.space.CheckDisk(uint64(needed)); err != nil {
+		return err
+	}
+	for _, path := range []string{m.pool.poolDir, repo.path} {
+		if sameDevice(path, m.ctx.BaseDir) {
+			continue
+		}
+		if err := m.space.CheckDiskAt(path, uint64(needed)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSpace will ensure there is room for needed more bytes on disk, and
+// within the repository's quota
+func (m *Manager) checkSpace(repo *Repository, needed int64) error {
+	if err := m.checkDisk(repo, needed); err != nil {
+		return err
+	}
+	return m.space.CheckQuota(m.db, m.pool, repo, needed)
+}
+
+// SetMinFreeSpace will change how many bytes must remain free on the base
+// directory's filesystem. Imports and deltas are refused below it.
+func (m *Manager) SetMinFreeSpace(minFree uint64) {
+	m.space.SetMinFree(minFree)
+}
+
+// MinFreeSpace returns how many bytes must remain free on the base directory's
+// filesystem
+func (m *Manager) MinFreeSpace() uint64 {
+	return m.space.MinFree()
+}
+
+// SetSpaceRefusedFunc will set the function called whenever an import or
+// delta is refused for lack of space, or by a repository quota
+func (m *Manager) SetSpaceRefusedFunc(f SpaceRefusedFunc) {
+	m.space.SetRefusedFunc(f)
+}
+
+// SpaceRefusals returns how many imports and deltas have been refused for lack
+// of space, or by a repository quota, since startup
+func (m *Manager) SpaceRefusals() uint64 {
+	return m.space.Refusals()
+}
+
+// SetQuota will change the most bytes the repository may use, 0 for no limit
+func (m *Manager) SetQuota(repoID string, quota int64) error {
+	return m.repo.SetQuota(m.db, repoID, quota)
+}
+
+// RepoSize will return the total size of the repository's packages and deltas
+func (m *Manager) RepoSize(repoID string) (int64, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return 0, err
+	}
+	return repo.Size(m.db, m.pool)
+}
+
+// AddPackages will attempt to add the named packages to the repository,
+// and then index it. prov may be nil, otherwise it is recorded for packages
+// new to the pool.
+func (m *Manager) AddPackages(repoID string, packages []string, anal bool, prov *Provenance) error {
+	if err := m.StagePackages(repoID, packages, anal, prov); err != nil {
+		return err
+	}
+	return m.Index(repoID)
+}
+
+// StagePackages is AddPackages without the index, leaving the repository
+// dirty until the caller indexes it
+func (m *Manager) StagePackages(repoID string, packages []string, anal bool, prov *Provenance) error {
 	repo, err := m.GetRepo(repoID)
 	if err != nil {
 		return err
 	}
 
-	for _, pkg := range packages {
-		if err := repo.AddPackage(m.db, m.pool, pkg, anal); err != nil {
+	if err := m.checkSpace(repo, filesSize(packages)); err != nil {
+		return err
+	}
+
+	if err := m.markDirty(repoID); err != nil {
+		return err
+	}
+
+	// Hashing dominates the import, so get it out of the way all at once
+	hashes := hashFiles(m.threadSetup, packages)
+	for i, pkg := range packages {
+		if err := repo.addPackageFile(m.logger, m.db, m.pool, pkg, anal, hashes[i], prov); err != nil {
 			return err
 		}
 	}
+	return nil
+}
+
+// BulkAddPackages will add the packages to the repository through the bulk
+// import pipeline, which is much faster than AddPackages when seeding a
+// repository, and then index it. prov and progress may be nil.
+func (m *Manager) BulkAddPackages(repoID string, packages []string, prov *Provenance, progress BulkProgressFunc) error {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return err
+	}
+
+	if err := m.checkSpace(repo, filesSize(packages)); err != nil {
+		return err
+	}
+
+	if err := m.markDirty(repoID); err != nil {
+		return err
+	}
+	if err := repo.BulkAddPackages(m.logger, m.threadSetup, m.db, m.pool, packages, prov, progress); err != nil {
+		return err
+	}
 
 	return m.Index(repoID)
 }
 
-// Index will cause the repository's index to be reconstructed
-func (m *Manager) Index(repoID string) error {
+// Index will cause the repository's index to be reconstructed. The outcome
+// is recorded against the repository, clearing its dirty flag on success.
+func (m *Manager) Index(repoID string) (err error) {
 	repo, err := m.GetRepo(repoID)
 	if err != nil {
 		return err
 	}
 
-	return repo.Index(m.db, m.pool)
+	started := time.Now()
+	defer func() {
+		m.recordIndex(repoID, started, err)
+	}()
+
+	if err := repo.Index(m.logger, m.db, m.pool); err != nil {
+		return err
+	}
+	if err := m.search.IndexRepo(m.db, m.pool, repo); err != nil {
+		return err
+	}
+	if m.store != nil {
+		if _, err = repo.PublishObjects(m.logger, m.db, m.store); err != nil {
+			return err
+		}
+	}
+	if err := m.mirror.markPending(m.db, repoID); err != nil {
+		return err
+	}
+	m.emit(RepoIndexed, repoID)
+	return nil
+}
+
+// GetIndexReport will return the problems found during the last index of
+// the repository
+func (m *Manager) GetIndexReport(repoID string) (*IndexReport, error) {
+	if _, err := m.GetRepo(repoID); err != nil {
+		return nil, err
+	}
+	return GetIndexReport(m.db, repoID)
+}
+
+// DeltaStats will measure how much the deltas of the repository save over
+// their full packages, listing at most limit of the least effective
+func (m *Manager) DeltaStats(repoID string, limit int) (*DeltaStats, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.DeltaStats(m.db, m.pool, limit)
+}
+
+// Search will find all published packages whose name, summary or description
+// match the query
+func (m *Manager) Search(query *SearchQuery) ([]*SearchDocument, error) {
+	if query.Repo != "" {
+		if _, err := m.GetRepo(query.Repo); err != nil {
+			return nil, err
+		}
+	}
+	return m.search.Search(m.db, query)
 }
 
 // GetPackageNames will attempt to load all package names for the given
@@ -221,14 +849,121 @@ func (m *Manager) GetPackages(repoID, pkgName string) ([]*libeopkg.MetaPackage,
 	return repo.GetPackages(m.db, m.pool, pkgName)
 }
 
-// CreateDelta will attempt to create a new delta package between the old and new IDs
-func (m *Manager) CreateDelta(repoID string, oldPkg, newPkg *libeopkg.MetaPackage) (string, error) {
+// ListPackages will return the pool entry for the published release of each
+// package in the repository, sorted by name. If pattern is not empty, only
+// names matching the glob are returned.
+func (m *Manager) ListPackages(repoID, pattern string) ([]*PoolEntry, error) {
+	if pattern != "" {
+		// Catch malformed patterns before we go anywhere near the DB
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+	}
+
 	repo, err := m.GetRepo(repoID)
 	if err != nil {
+		return nil, err
+	}
+
+	entries, err := repo.GetEntries(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*PoolEntry
+	for _, entry := range entries {
+		if entry.Published == "" {
+			continue
+		}
+		if pattern != "" {
+			if match, _ := path.Match(pattern, entry.Name); !match {
+				continue
+			}
+		}
+		p, err := m.pool.GetEntry(m.db, entry.Published)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Meta.Name < ret[b].Meta.Name
+	})
+	return ret, nil
+}
+
+// PackageInfo describes everything we know about a package name within a
+// single repository
+type PackageInfo struct {
+	Entry     *RepoEntry   // The raw repository entry
+	Published *PoolEntry   // The tip release, may be nil
+	Available []*PoolEntry // Every release in the repository, oldest first
+	Deltas    []*PoolEntry // Every delta package in the repository
+}
+
+// GetPackageInfo will return the published release, available releases and
+// known deltas for the named package in the repository
+func (m *Manager) GetPackageInfo(repoID, pkgName string) (*PackageInfo, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := repo.GetEntry(m.db, pkgName)
+	if err != nil || entry == nil {
+		return nil, notFoundf("The package '%s' does not exist in repository '%s'", pkgName, repoID)
+	}
+
+	info := &PackageInfo{
+		Entry: entry,
+	}
+
+	for _, id := range entry.Available {
+		p, err := m.pool.GetEntry(m.db, id)
+		if err != nil {
+			return nil, err
+		}
+		info.Available = append(info.Available, p)
+		if id == entry.Published {
+			info.Published = p
+		}
+	}
+	sort.Slice(info.Available, func(a, b int) bool {
+		return info.Available[a].Meta.GetRelease() < info.Available[b].Meta.GetRelease()
+	})
+
+	for _, id := range entry.Deltas {
+		p, err := m.pool.GetEntry(m.db, id)
+		if err != nil {
+			return nil, err
+		}
+		info.Deltas = append(info.Deltas, p)
+	}
+
+	return info, nil
+}
+
+// CreateDelta will attempt to create a new delta package between the old and new IDs,
+// optionally reporting progress as it goes. Production is abandoned if cancel
+// is closed.
+func (m *Manager) CreateDelta(repoID string, oldPkg, newPkg *libeopkg.MetaPackage, progress libeopkg.DeltaProgressFunc, cancel <-chan struct{}) (string, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return "", err
+	}
+
+	// We can't know the delta size up front, but it won't be bigger than
+	// the package. It'll usually be much smaller, so only refuse it on
+	// quota once the repository is already over.
+	if err = m.checkDisk(repo, newPkg.PackageSize); err != nil {
+		return "", err
+	}
+	if err = m.space.CheckQuota(m.db, m.pool, repo, 0); err != nil {
 		return "", err
 	}
 
-	return repo.CreateDelta(m.db, oldPkg, newPkg)
+	return repo.CreateDelta(m.db, m.pool, oldPkg, newPkg, progress, cancel)
 }
 
 // HasDelta will query the repository to determine if it already has the
@@ -249,7 +984,10 @@ func (m *Manager) AddDelta(repoID, deltaPath string, mapping *DeltaInformation)
 		return err
 	}
 
-	return repo.AddDelta(m.db, m.pool, deltaPath, mapping)
+	if err := m.markDirty(repoID); err != nil {
+		return err
+	}
+	return repo.AddDelta(m.logger, m.db, m.pool, deltaPath, mapping)
 }
 
 // RefDelta will dupe an existing delta into the target repository
@@ -258,21 +996,168 @@ func (m *Manager) RefDelta(repoID, deltaID string) error {
 	if err != nil {
 		return err
 	}
-	return repo.RefDelta(m.db, m.pool, deltaID)
+	if err := m.markDirty(repoID); err != nil {
+		return err
+	}
+	return repo.RefDelta(m.logger, m.db, m.pool, deltaID)
 }
 
-// MarkDeltaFailed will permanently record the delta package as failing so we do
-// not attempt to recreate it (expensive)
+// InvalidateDeltas will remove the stale deltas from the repository, and
+// reindex it if any were removed.
+func (m *Manager) InvalidateDeltas(repoID string) ([]string, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+
+	removed, err := repo.InvalidateDeltas(m.logger, m.db, m.pool)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(removed) < 1 {
+		return nil, nil
+	}
+	if err = m.markDirty(repoID); err != nil {
+		return nil, err
+	}
+	return removed, m.Index(repoID)
+}
+
+// RewriteMetadata will patch the metadata of the stored package, swap the
+// rewritten archive into every repository containing it, and reindex those
+// repositories. The IDs of the affected repositories are returned.
+func (m *Manager) RewriteMetadata(pkgID string, patch *MetadataPatch) ([]string, error) {
+	if patch.IsEmpty() {
+		return nil, ErrEmptyPatch
+	}
+
+	pkgID = filepath.Base(pkgID)
+	if _, err := m.pool.RewritePackage(m.logger, m.db, pkgID, patch); err != nil {
+		return nil, err
+	}
+
+	repos, err := m.GetRepos()
+	if err != nil {
+		return nil, err
+	}
+
+	var affected []string
+	for _, r := range repos {
+		// GetRepos only hands back the stored records
+		repo, err := m.GetRepo(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		has, err := repo.HasPackage(m.db, m.pool, pkgID)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			continue
+		}
+		if err := m.markDirty(repo.ID); err != nil {
+			return nil, err
+		}
+		if err := repo.RelinkPackage(m.logger, m.db, m.pool, pkgID); err != nil {
+			return nil, err
+		}
+		if err := m.Index(repo.ID); err != nil {
+			return nil, err
+		}
+		affected = append(affected, repo.ID)
+	}
+	return affected, nil
+}
+
+// MarkDeltaFailed will record the delta package as failing so we do not
+// attempt to recreate it (expensive) until the record expires
 func (m *Manager) MarkDeltaFailed(deltaID string, delta *DeltaInformation) error {
 	return m.pool.MarkDeltaFailed(m.db, deltaID, delta)
 }
 
+// MarkDeltaPointless will record that the delta package would be of no use,
+// so we never attempt to recreate it unless asked to retry
+func (m *Manager) MarkDeltaPointless(deltaID string, delta *DeltaInformation) error {
+	return m.pool.MarkDeltaPointless(m.db, deltaID, delta)
+}
+
 // GetDeltaFailed will determine via the pool transaction whether a delta has
 // previously failed.
 func (m *Manager) GetDeltaFailed(deltaID string) bool {
 	return m.pool.GetDeltaFailed(m.db, deltaID)
 }
 
+// SetDeltaSkipExpiry will change how long failed deltas are skipped before
+// being attempted again, where 0 skips them for ever
+func (m *Manager) SetDeltaSkipExpiry(expiry time.Duration) {
+	m.pool.SetSkipExpiry(expiry)
+}
+
+// RetryDeltas will forget every delta of the package in the repository
+// which was skipped, so that the next delta run attempts them again. The IDs of
+// the deltas forgotten are returned.
+func (m *Manager) RetryDeltas(repoID, pkgName string) ([]string, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := repo.GetEntry(m.db, pkgName)
+	if err != nil {
+		return nil, notFoundf("No such package '%s' in '%s'", pkgName, repoID)
+	}
+	return m.pool.ClearSkipEntries(m.db, entry.Available)
+}
+
+// GetDeltaSkips will return the record of every delta skipped for the
+// packages in the repository, sorted by delta ID
+func (m *Manager) GetDeltaSkips(repoID string) ([]*DeltaSkipEntry, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := repo.GetEntries(m.db)
+	if err != nil {
+		return nil, err
+	}
+	var pkgIDs []string
+	for _, entry := range entries {
+		pkgIDs = append(pkgIDs, entry.Available...)
+	}
+	skips, err := m.pool.GetSkipEntries(m.db, pkgIDs)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(skips, func(i, j int) bool {
+		return skips[i].Name < skips[j].Name
+	})
+	return skips, nil
+}
+
+// HasPackage will determine whether the package with the given ID is
+// available in the repository
+func (m *Manager) HasPackage(repoID, pkgID string) (bool, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return false, err
+	}
+	pkgID = filepath.Base(pkgID)
+	meta, err := m.GetPoolEntry(pkgID)
+	if err != nil {
+		return false, nil
+	}
+	entry, err := repo.GetEntry(m.db, meta.Name)
+	if err != nil {
+		return false, nil
+	}
+	for _, id := range entry.Available {
+		if id == pkgID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // GetPoolEntry will return the metadata for a pool entry with the given pkg ID
 func (m *Manager) GetPoolEntry(pkgID string) (*libeopkg.MetaPackage, error) {
 	entry, err := m.pool.GetEntry(m.db, filepath.Base(pkgID))
@@ -0,0 +1,150 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+	"sort"
+	"strings"
+)
+
+// ValidateArchitecture will ensure the architecture name is safe to use
+// within index file names, i.e. "x86_64" or "aarch64"
+func ValidateArchitecture(arch string) error {
+	if arch == "" {
+		return fmt.Errorf("architecture cannot be empty")
+	}
+	for _, c := range arch {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+		default:
+			return fmt.Errorf("invalid architecture name: '%s'", arch)
+		}
+	}
+	return nil
+}
+
+// An ArchError is returned when a package is built for an architecture the
+// repository doesn't allow
+type ArchError struct {
+	Repo         string   // Repository refusing the package
+	Package      string   // ID of the refused package
+	Architecture string   // Architecture the package was built for
+	Allowed      []string // Architectures the repository allows
+}
+
+// Error will explain why the package was refused
+func (e *ArchError) Error() string {
+	return fmt.Sprintf("%s is built for %s, but '%s' only allows %s", e.Package, e.Architecture, e.Repo, strings.Join(e.Allowed, ", "))
+}
+
+// AllowsArchitecture will determine if packages built for the architecture
+// may enter the repository. Any architecture is allowed if none are set.
+func (r *Repository) AllowsArchitecture(arch string) bool {
+	if len(r.Architectures) == 0 {
+		return true
+	}
+	for _, allowed := range r.Architectures {
+		if allowed == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// checkArchitecture will return an ArchError if the package was built for an
+// architecture this repository doesn't allow
+func (r *Repository) checkArchitecture(meta *libeopkg.MetaPackage, id string) error {
+	if r.AllowsArchitecture(meta.Architecture) {
+		return nil
+	}
+	return &ArchError{
+		Repo:         r.ID,
+		Package:      id,
+		Architecture: meta.Architecture,
+		Allowed:      r.Architectures,
+	}
+}
+
+// checkAdmissible will ensure the package may enter this repository, being
+// built for an allowed architecture and not banned
+func (r *Repository) checkAdmissible(meta *libeopkg.MetaPackage, id string) error {
+	if err := r.checkArchitecture(meta, id); err != nil {
+		return err
+	}
+	return r.checkBanned(meta, id)
+}
+
+// checkSameArchitecture will refuse to publish a package for another
+// architecture than the one currently published under the same name. The
+// repository keys packages by name alone, so one name can't yet be held for
+// several architectures at once.
+func checkSameArchitecture(published *libeopkg.MetaPackage, newPkg *libeopkg.MetaPackage, newID string) error {
+	if published.Architecture == newPkg.Architecture {
+		return nil
+	}
+	return fmt.Errorf("%s is built for %s, but %s is published for %s", newID, newPkg.Architecture, published.Name, published.Architecture)
+}
+
+// filterArchitectures will drop any IDs built for an architecture this
+// repository doesn't allow from the list, logging each one skipped
+func (r *Repository) filterArchitectures(logger *log.Entry, db libdb.Database, pool *Pool, ids []string) ([]string, error) {
+	if len(r.Architectures) == 0 {
+		return ids, nil
+	}
+	entries, err := pool.GetEntries(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []string
+	for i, entry := range entries {
+		if !r.AllowsArchitecture(entry.Meta.Architecture) {
+			logger.WithFields(log.Fields{
+				"repo":         r.ID,
+				"id":           ids[i],
+				"architecture": entry.Meta.Architecture,
+			}).Warning("Skipping package for disallowed architecture")
+			continue
+		}
+		allowed = append(allowed, ids[i])
+	}
+	return allowed, nil
+}
+
+// SetArchitectures will change which architectures packages may be built for
+// to enter the repository. An index is emitted for each of them alongside
+// the main index. An empty list allows any architecture.
+func (r *RepositoryManager) SetArchitectures(db libdb.Database, id string, archs []string) error {
+	seen := make(map[string]bool)
+	var set []string
+	for _, arch := range archs {
+		if err := ValidateArchitecture(arch); err != nil {
+			return err
+		}
+		if !seen[arch] {
+			seen[arch] = true
+			set = append(set, arch)
+		}
+	}
+	sort.Strings(set)
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.Architectures = set
+	})
+}
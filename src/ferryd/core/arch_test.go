@@ -0,0 +1,93 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRepoArchitectures(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	for _, archs := range [][]string{{""}, {"../x86_64"}, {"x86 64"}} {
+		if err := manager.SetArchitectures("unstable", archs); err == nil {
+			t.Fatalf("Architectures %q should be invalid", archs)
+		}
+	}
+
+	// Packages for other architectures are refused
+	if err := manager.SetArchitectures("unstable", []string{"aarch64"}); err != nil {
+		t.Fatalf("Failed to set architectures: %v", err)
+	}
+	err = manager.AddPackages("unstable", []string{searchTestPackage}, false, nil)
+	if _, ok := err.(*ArchError); !ok {
+		t.Fatalf("Expected an ArchError importing an x86_64 package, got %v", err)
+	}
+
+	// Each allowed architecture gets its own index
+	if err := manager.SetArchitectures("unstable", []string{"x86_64", "aarch64", "x86_64"}); err != nil {
+		t.Fatalf("Failed to set architectures: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if strings.Join(repo.Architectures, ",") != "aarch64,x86_64" {
+		t.Fatalf("Architectures should be sorted and unique, got %v", repo.Architectures)
+	}
+	pkgName := filepath.Base(searchTestPackage)
+	for arch, want := range map[string]bool{"x86_64": true, "aarch64": false} {
+		data, err := ioutil.ReadFile(filepath.Join(repo.path, archIndexName(arch)))
+		if err != nil {
+			t.Fatalf("Missing %s index: %v", arch, err)
+		}
+		if got := strings.Contains(string(data), pkgName); got != want {
+			t.Fatalf("Expected %s index to include package: %v, got %v", arch, want, got)
+		}
+		if !PathExists(filepath.Join(repo.path, archIndexName(arch)+".xz.sha1sum")) {
+			t.Fatalf("Missing compressed %s index", arch)
+		}
+	}
+
+	// Indexes of architectures no longer allowed are removed
+	if err := manager.SetArchitectures("unstable", []string{"x86_64"}); err != nil {
+		t.Fatalf("Failed to set architectures: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index: %v", err)
+	}
+	stale, _ := filepath.Glob(filepath.Join(repo.path, "eopkg-index.aarch64.xml*"))
+	if len(stale) != 0 {
+		t.Fatalf("Stale architecture index left behind: %v", stale)
+	}
+	if !PathExists(filepath.Join(repo.path, "eopkg-index.xml")) {
+		t.Fatalf("Main index was removed")
+	}
+}
@@ -0,0 +1,512 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"libdb"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ImportPathComponent is where uploaded repository archives wait to be
+	// imported
+	ImportPathComponent = "imports"
+
+	// ArchiveFormatVersion is the current version of a repository archive
+	ArchiveFormatVersion = 1
+
+	// archiveManifestName is the first file of every repository archive
+	archiveManifestName = "ferryd-export.json"
+
+	// Prefixes of the files within a repository archive
+	archivePoolPrefix  = "pool/"
+	archiveAssetPrefix = "assets/"
+)
+
+// A RepoArchive is the manifest of a repository archive, which holds every
+// package and delta of the repository alongside the manifest, so that the
+// repository can be moved to another ferryd instance.
+type RepoArchive struct {
+	FormatVersion int
+	Repo          string
+	Exported      time.Time
+	Settings      RepoSettings
+	Entries       []*RepoEntry
+	Pool          []*ArchivePoolEntry
+	Assets        []string
+}
+
+// RepoSettings are the settings stored with a repository
+type RepoSettings struct {
+	DeltaPolicy    DeltaPolicy
+	VerifyHashes   bool
+	ConflictPolicy ConflictPolicy
+	VerifyIndex    bool
+	Held           []string
+	Bans           []Ban
+	Quota          int64
+	Architectures  []string
+
+	ComponentIndexes bool
+	VerifyDeltas     bool
+	VersionedIndexes bool
+}
+
+// An ArchivePoolEntry describes a pool file within a repository archive
+type ArchivePoolEntry struct {
+	Name        string
+	Size        int64
+	ContentHash string            // sha256sum, checked when importing
+	Delta       *DeltaInformation `json:",omitempty"`
+	Provenance  *Provenance       `json:",omitempty"`
+}
+
+// settings returns the stored settings of the repository
+func (r *Repository) settings() RepoSettings {
+	return RepoSettings{
+		DeltaPolicy:    r.DeltaPolicy,
+		VerifyHashes:   r.VerifyHashes,
+		ConflictPolicy: r.ConflictPolicy,
+		VerifyIndex:    r.VerifyIndex,
+		Held:           r.Held,
+		Bans:           r.Bans,
+		Quota:          r.Quota,
+		Architectures:  r.Architectures,
+
+		ComponentIndexes: r.ComponentIndexes,
+		VerifyDeltas:     r.VerifyDeltas,
+		VersionedIndexes: r.VersionedIndexes,
+	}
+}
+
+// applySettings will replace the stored settings of the repository
+func (r *Repository) applySettings(s *RepoSettings) {
+	r.DeltaPolicy = s.DeltaPolicy
+	r.VerifyHashes = s.VerifyHashes
+	r.ConflictPolicy = s.ConflictPolicy
+	r.VerifyIndex = s.VerifyIndex
+	r.Held = s.Held
+	r.Bans = s.Bans
+	r.Quota = s.Quota
+	r.Architectures = s.Architectures
+	r.ComponentIndexes = s.ComponentIndexes
+	r.VerifyDeltas = s.VerifyDeltas
+	r.VersionedIndexes = s.VersionedIndexes
+}
+
+// writeArchiveFile will add the file at path to the archive
+func writeArchiveFile(tw *tar.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    00644,
+		Size:    st.Size(),
+		ModTime: st.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// manifest will describe the repository as it is now, returning the paths
+// of the pool files alongside. insertMut must already be held.
+func (r *Repository) manifest(db libdb.Database, pool *Pool) (*RepoArchive, []string, error) {
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest := &RepoArchive{
+		FormatVersion: ArchiveFormatVersion,
+		Repo:          r.ID,
+		Exported:      time.Now().UTC(),
+		Settings:      r.settings(),
+		Entries:       entries,
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		for _, id := range append(entry.Available, entry.Deltas...) {
+			poolEntry, err := pool.GetEntry(db, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			path := pool.EntryPath(poolEntry)
+			st, err := os.Stat(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			manifest.Pool = append(manifest.Pool, &ArchivePoolEntry{
+				Name:        id,
+				Size:        st.Size(),
+				ContentHash: poolEntry.ContentHash,
+				Delta:       poolEntry.Delta,
+				Provenance:  poolEntry.Provenance,
+			})
+			paths = append(paths, path)
+		}
+	}
+	assets, err := r.GetAssets()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, asset := range assets {
+		manifest.Assets = append(manifest.Assets, asset.Name)
+	}
+	return manifest, paths, nil
+}
+
+// Manifest will describe every package, delta and asset of the repository,
+// as it would be exported
+func (r *Repository) Manifest(db libdb.Database, pool *Pool) (*RepoArchive, error) {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+	manifest, _, err := r.manifest(db, pool)
+	return manifest, err
+}
+
+// Export will write the repository as a tar archive, holding the manifest
+// followed by every pool file it references and its assets. Nothing can be
+// added to or removed from the repository until the export is complete.
+func (r *Repository) Export(db libdb.Database, pool *Pool, w io.Writer) error {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	manifest, paths, err := r.manifest(db, pool)
+	if err != nil {
+		return err
+	}
+
+	blob, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	hdr := &tar.Header{
+		Name:    archiveManifestName,
+		Mode:    00644,
+		Size:    int64(len(blob)),
+		ModTime: manifest.Exported,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(blob); err != nil {
+		return err
+	}
+	for i, entry := range manifest.Pool {
+		if err := writeArchiveFile(tw, archivePoolPrefix+entry.Name, paths[i]); err != nil {
+			return err
+		}
+	}
+	for _, name := range manifest.Assets {
+		if err := writeArchiveFile(tw, archiveAssetPrefix+name, filepath.Join(r.assetPath, name)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// readArchiveManifest will read the manifest from the start of the archive
+func readArchiveManifest(tr *tar.Reader) (*RepoArchive, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("not a repository archive: %v", err)
+	}
+	if hdr.Name != archiveManifestName {
+		return nil, fmt.Errorf("not a repository archive: missing %s", archiveManifestName)
+	}
+	manifest := &RepoArchive{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid repository archive manifest: %v", err)
+	}
+	if manifest.FormatVersion != ArchiveFormatVersion {
+		return nil, fmt.Errorf("unsupported repository archive version %d", manifest.FormatVersion)
+	}
+	return manifest, nil
+}
+
+// ReadArchiveManifest will return the manifest of the repository archive
+func ReadArchiveManifest(path string) (*RepoArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readArchiveManifest(tar.NewReader(f))
+}
+
+// extractArchiveFile will write the current file of the archive to path,
+// returning its sha256sum
+func extractArchiveFile(tr *tar.Reader, path string) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), tr)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// validPoolFileName will determine if the name of a pool file from another
+// host is safe to create within a single directory
+func validPoolFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && name == filepath.Base(name)
+}
+
+// extractArchive will unpack the pool files and assets of the archive into
+// dir, ensuring each pool file is intact and that none are missing
+func extractArchive(tr *tar.Reader, manifest *RepoArchive, dir string) error {
+	files := make(map[string]*ArchivePoolEntry)
+	for _, entry := range manifest.Pool {
+		if !validPoolFileName(entry.Name) {
+			return fmt.Errorf("invalid pool file in archive: %s", entry.Name)
+		}
+		files[entry.Name] = entry
+	}
+	assets := make(map[string]bool)
+	for _, name := range manifest.Assets {
+		if _, ok := assetValidators[name]; !ok {
+			return fmt.Errorf("unknown asset in archive: %s", name)
+		}
+		assets[name] = true
+	}
+	if err := os.MkdirAll(filepath.Join(dir, AssetPathComponent), 00755); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(hdr.Name, archivePoolPrefix):
+			name := strings.TrimPrefix(hdr.Name, archivePoolPrefix)
+			entry, ok := files[name]
+			if !ok || seen[name] {
+				return fmt.Errorf("unexpected file in archive: %s", hdr.Name)
+			}
+			seen[name] = true
+			sum, err := extractArchiveFile(tr, filepath.Join(dir, name))
+			if err != nil {
+				return err
+			}
+			if entry.ContentHash != "" && sum != entry.ContentHash {
+				return fmt.Errorf("%s in archive has hash %s, expected %s", name, sum, entry.ContentHash)
+			}
+		case strings.HasPrefix(hdr.Name, archiveAssetPrefix):
+			name := strings.TrimPrefix(hdr.Name, archiveAssetPrefix)
+			if !assets[name] {
+				return fmt.Errorf("unexpected file in archive: %s", hdr.Name)
+			}
+			if _, err := extractArchiveFile(tr, filepath.Join(dir, AssetPathComponent, name)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unexpected file in archive: %s", hdr.Name)
+		}
+	}
+	for name := range files {
+		if !seen[name] {
+			return fmt.Errorf("archive is missing %s", name)
+		}
+	}
+	return nil
+}
+
+// importPoolFile will add the extracted file to the pool, taking our
+// reference on it, and link it into our tree
+func (r *Repository) importPoolFile(logger *log.Entry, db libdb.Database, pool *Pool, path string, entry *ArchivePoolEntry) error {
+	pkg, err := libeopkg.Open(path)
+	if err != nil {
+		return err
+	}
+	defer pkg.Close()
+	if err = pkg.ReadMetadata(); err != nil {
+		return err
+	}
+	poolEntry, err := pool.addPackageInternal(logger, db, pkg, false, entry.Delta, nil, entry.Provenance)
+	if err != nil {
+		return err
+	}
+	return r.linkPoolFile(pool, poolEntry)
+}
+
+// importArchive will fill the newly created repository from the extracted
+// archive in dir. Should it fail, the pool references it took are dropped
+// again, leaving an empty repository.
+func (r *Repository) importArchive(logger *log.Entry, db libdb.Database, pool *Pool, manifest *RepoArchive, dir string) (err error) {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	var added []string
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, id := range added {
+			pool.UnrefEntry(logger, db, id)
+		}
+	}()
+
+	for _, name := range manifest.Assets {
+		data, err := ioutil.ReadFile(filepath.Join(dir, AssetPathComponent, name))
+		if err != nil {
+			return err
+		}
+		if err := r.SetAsset(name, data); err != nil {
+			return err
+		}
+	}
+	for _, entry := range manifest.Pool {
+		if err := r.importPoolFile(logger, db, pool, filepath.Join(dir, entry.Name), entry); err != nil {
+			return fmt.Errorf("failed to import %s: %v", entry.Name, err)
+		}
+		added = append(added, entry.Name)
+	}
+	// The entries now own the references, so must be stored all at once
+	return db.Update(func(db libdb.Database) error {
+		for _, entry := range manifest.Entries {
+			if err := r.putEntry(db, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExportRepo will write the repository, along with every package and delta
+// it holds, as a portable archive
+func (m *Manager) ExportRepo(repoID string, w io.Writer) error {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return err
+	}
+	return repo.Export(m.db, m.pool, w)
+}
+
+// StageRepoArchive will store an uploaded repository archive until it can
+// be imported, returning where it was put
+func (m *Manager) StageRepoArchive(r io.Reader) (string, error) {
+	dir := filepath.Join(m.ctx.BaseDir, ImportPathComponent)
+	if err := os.MkdirAll(dir, 00755); err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(dir, "upload-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// ImportRepo will create the repository from the archive at path, bringing
+// its packages and deltas into the pool. If repoID is empty, the repository
+// keeps the name it was exported with. The repository must not exist yet,
+// and is removed again should the import fail. The manifest of the archive
+// is returned.
+func (m *Manager) ImportRepo(path, repoID string) (*RepoArchive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	manifest, err := readArchiveManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	if repoID == "" {
+		repoID = manifest.Repo
+	}
+	if _, err := m.GetRepo(repoID); err == nil {
+		return nil, fmt.Errorf("The specified repository '%s' already exists", repoID)
+	}
+
+	if err := os.MkdirAll(filepath.Join(m.ctx.BaseDir, ImportPathComponent), 00755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(m.ctx.BaseDir, ImportPathComponent), repoID+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := extractArchive(tr, manifest, dir); err != nil {
+		return nil, err
+	}
+
+	if _, err := m.repo.CreateRepo(m.db, repoID); err != nil {
+		return nil, err
+	}
+	err = m.repo.updateRepo(m.db, repoID, func(repo *Repository) {
+		repo.applySettings(&manifest.Settings)
+		repo.Dirty = true
+	})
+	if err == nil {
+		var repo *Repository
+		if repo, err = m.GetRepo(repoID); err == nil {
+			err = repo.importArchive(m.logger, m.db, m.pool, manifest, dir)
+		}
+	}
+	if err != nil {
+		if delErr := m.repo.DeleteRepo(m.logger, m.db, m.pool, repoID); delErr != nil {
+			return nil, fmt.Errorf("%v, and failed to remove the partial repository: %v", err, delErr)
+		}
+		return nil, err
+	}
+	m.emit(RepoCreated, repoID)
+	return manifest, m.Index(repoID)
+}
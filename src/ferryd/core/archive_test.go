@@ -0,0 +1,111 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepoArchive(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if err := manager.HoldSource("unstable", "nano"); err != nil {
+		t.Fatalf("Failed to hold source: %v", err)
+	}
+
+	var archive bytes.Buffer
+	if err := manager.ExportRepo("unstable", &archive); err != nil {
+		t.Fatalf("Failed to export repo: %v", err)
+	}
+	path, err := manager.StageRepoArchive(&archive)
+	if err != nil {
+		t.Fatalf("Failed to stage archive: %v", err)
+	}
+	manifest, err := ReadArchiveManifest(path)
+	if err != nil {
+		t.Fatalf("Failed to read archive manifest: %v", err)
+	}
+	if manifest.Repo != "unstable" || len(manifest.Pool) != 1 || len(manifest.Entries) != 1 {
+		t.Fatalf("Unexpected archive manifest: %+v", manifest)
+	}
+
+	// Existing repositories are never overwritten
+	if _, err := manager.ImportRepo(path, ""); err == nil {
+		t.Fatalf("Importing over an existing repo should fail")
+	}
+
+	if _, err := manager.ImportRepo(path, "imported"); err != nil {
+		t.Fatalf("Failed to import repo: %v", err)
+	}
+	repo, err := manager.GetRepo("imported")
+	if err != nil {
+		t.Fatalf("Failed to get imported repo: %v", err)
+	}
+	if len(repo.Held) != 1 || repo.Held[0] != "nano" {
+		t.Fatalf("Settings weren't imported: %v", repo.Held)
+	}
+	id := filepath.Base(searchTestPackage)
+	entry, err := manager.pool.GetEntry(manager.db, id)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if entry.RefCount != 2 {
+		t.Fatalf("Expected both repos to reference the package, got %d", entry.RefCount)
+	}
+
+	// The import stands alone once the original is gone
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if _, err := manager.GetPackageInfo("imported", "nano"); err != nil {
+		t.Fatalf("Imported package is missing: %v", err)
+	}
+	if !PathExists(filepath.Join(repo.path, entry.Meta.GetPathComponent(), id)) {
+		t.Fatalf("Imported package wasn't linked into the tree")
+	}
+
+	// Damaged archives are refused without leaving anything behind
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open archive: %v", err)
+	}
+	st, _ := f.Stat()
+	if _, err := f.WriteAt([]byte("corrupt"), st.Size()/2); err != nil {
+		t.Fatalf("Failed to corrupt archive: %v", err)
+	}
+	f.Close()
+	if _, err := manager.ImportRepo(path, "broken"); err == nil {
+		t.Fatalf("Importing a corrupt archive should fail")
+	}
+	if _, err := manager.GetRepo("broken"); err == nil {
+		t.Fatalf("A failed import shouldn't create the repo")
+	}
+}
@@ -0,0 +1,145 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// assetValidators maps each asset a repository may have to the libeopkg
+// loader used to validate it
+var assetValidators = map[string]func(path string) error{
+	"distribution.xml": func(path string) error {
+		dist, err := libeopkg.NewDistribution(path)
+		if err != nil {
+			return err
+		}
+		if dist.SourceName == "" || dist.BinaryName == "" {
+			return fmt.Errorf("missing SourceName or BinaryName")
+		}
+		return nil
+	},
+	"components.xml": func(path string) error {
+		comp, err := libeopkg.NewComponents(path)
+		if err != nil {
+			return err
+		}
+		if len(comp.Components) < 1 {
+			return fmt.Errorf("no components defined")
+		}
+		for _, c := range comp.Components {
+			if c.Name == "" {
+				return fmt.Errorf("component without a Name")
+			}
+		}
+		return nil
+	},
+	"groups.xml": func(path string) error {
+		grp, err := libeopkg.NewGroups(path)
+		if err != nil {
+			return err
+		}
+		if len(grp.Groups) < 1 {
+			return fmt.Errorf("no groups defined")
+		}
+		for _, g := range grp.Groups {
+			if g.Name == "" {
+				return fmt.Errorf("group without a Name")
+			}
+		}
+		return nil
+	},
+}
+
+// AssetNames returns the names of every asset a repository may have
+func AssetNames() []string {
+	return []string{"distribution.xml", "components.xml", "groups.xml"}
+}
+
+// An Asset is a file merged into the index of the repository
+type Asset struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// GetAssets will return the assets currently installed in the repository
+func (r *Repository) GetAssets() ([]*Asset, error) {
+	var ret []*Asset
+	for _, name := range AssetNames() {
+		st, err := os.Stat(filepath.Join(r.assetPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		ret = append(ret, &Asset{
+			Name:     name,
+			Size:     st.Size(),
+			Modified: st.ModTime().UTC(),
+		})
+	}
+	return ret, nil
+}
+
+// GetAsset will return the contents of the named asset
+func (r *Repository) GetAsset(name string) ([]byte, error) {
+	if _, ok := assetValidators[name]; !ok {
+		return nil, notFoundf("Unknown asset '%s'", name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(r.assetPath, name))
+	if os.IsNotExist(err) {
+		return nil, notFoundf("Repository '%s' has no %s", r.ID, name)
+	}
+	return data, err
+}
+
+// SetAsset will install or replace the named asset, after ensuring that it
+// can actually be loaded. The repository must be reindexed for the change to
+// be published.
+func (r *Repository) SetAsset(name string, data []byte) error {
+	validate, ok := assetValidators[name]
+	if !ok {
+		return fmt.Errorf("Unknown asset '%s'", name)
+	}
+
+	if err := os.MkdirAll(r.assetPath, 00755); err != nil {
+		return err
+	}
+
+	// Never leave a broken asset in place for the indexer to trip over
+	assetPath := filepath.Join(r.assetPath, name)
+	tmpPath := assetPath + ".new"
+	if err := ioutil.WriteFile(tmpPath, data, 00644); err != nil {
+		return err
+	}
+	if err := validate(tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("Invalid %s: %v", name, err)
+	}
+	if err := os.Rename(tmpPath, assetPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestAssets(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+
+	assets, err := manager.GetAssets("unstable")
+	if err != nil {
+		t.Fatalf("Failed to list assets: %v", err)
+	}
+	if len(assets) != 0 {
+		t.Fatalf("New repository should have no assets: %v", assets)
+	}
+
+	for _, name := range AssetNames() {
+		data, err := ioutil.ReadFile("../../libeopkg/testdata/" + name)
+		if err != nil {
+			t.Fatalf("Failed to read test asset: %v", err)
+		}
+		if err := manager.SetAsset("unstable", name, data); err != nil {
+			t.Fatalf("Failed to set %s: %v", name, err)
+		}
+		got, err := manager.GetAsset("unstable", name)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s was not stored correctly", name)
+		}
+	}
+
+	if assets, _ = manager.GetAssets("unstable"); len(assets) != 3 {
+		t.Fatalf("Expected 3 assets, got %d", len(assets))
+	}
+
+	// Broken or unknown assets must not replace the existing ones
+	bad := []struct {
+		name string
+		data string
+	}{
+		{"components.xml", "not xml"},
+		{"groups.xml", "<PISI><Groups></Groups></PISI>"},
+		{"distribution.xml", "<PISI></PISI>"},
+		{"packages.xml", "<PISI></PISI>"},
+	}
+	for _, b := range bad {
+		if err := manager.SetAsset("unstable", b.name, []byte(b.data)); err == nil {
+			t.Fatalf("Invalid %s should be rejected", b.name)
+		}
+	}
+	if _, err := manager.GetAsset("unstable", "components.xml"); err != nil {
+		t.Fatalf("Valid asset was lost: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index with assets: %v", err)
+	}
+}
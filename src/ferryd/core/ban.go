@@ -0,0 +1,187 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+)
+
+// A Ban stops matching packages from being introduced into a repository,
+// whether by import, pull, clone, copy or promotion. Packages already in the
+// repository are left alone.
+type Ban struct {
+	Name        string // Package name, or source name when Source is set
+	Source      bool   // Match every package built from the named source
+	ReleaseFrom int    // Lowest banned release, 0 for no lower bound
+	ReleaseTo   int    // Highest banned release, 0 for no upper bound
+	Reason      string // Why the ban was put in place
+}
+
+// Validate will ensure the ban makes sense
+func (b *Ban) Validate() error {
+	if b.Name == "" {
+		return errors.New("ban requires a name")
+	}
+	if b.ReleaseFrom < 0 || b.ReleaseTo < 0 {
+		return errors.New("banned releases cannot be negative")
+	}
+	if b.ReleaseTo > 0 && b.ReleaseFrom > b.ReleaseTo {
+		return fmt.Errorf("invalid release range %d to %d", b.ReleaseFrom, b.ReleaseTo)
+	}
+	return nil
+}
+
+// Matches will determine if the package is covered by the ban
+func (b *Ban) Matches(meta *libeopkg.MetaPackage) bool {
+	name := meta.Name
+	if b.Source {
+		name = meta.Source.Name
+	}
+	if name != b.Name {
+		return false
+	}
+	release := meta.GetRelease()
+	if b.ReleaseFrom > 0 && release < b.ReleaseFrom {
+		return false
+	}
+	if b.ReleaseTo > 0 && release > b.ReleaseTo {
+		return false
+	}
+	return true
+}
+
+// sameTarget will determine if both bans cover exactly the same packages
+func (b *Ban) sameTarget(o *Ban) bool {
+	return b.Name == o.Name && b.Source == o.Source && b.ReleaseFrom == o.ReleaseFrom && b.ReleaseTo == o.ReleaseTo
+}
+
+// String will describe what the ban covers, i.e. "source nano (releases 60-62)"
+func (b *Ban) String() string {
+	kind := "package"
+	if b.Source {
+		kind = "source"
+	}
+	switch {
+	case b.ReleaseFrom > 0 && b.ReleaseFrom == b.ReleaseTo:
+		return fmt.Sprintf("%s %s (release %d)", kind, b.Name, b.ReleaseFrom)
+	case b.ReleaseFrom > 0 && b.ReleaseTo > 0:
+		return fmt.Sprintf("%s %s (releases %d-%d)", kind, b.Name, b.ReleaseFrom, b.ReleaseTo)
+	case b.ReleaseFrom > 0:
+		return fmt.Sprintf("%s %s (release >= %d)", kind, b.Name, b.ReleaseFrom)
+	case b.ReleaseTo > 0:
+		return fmt.Sprintf("%s %s (release <= %d)", kind, b.Name, b.ReleaseTo)
+	}
+	return fmt.Sprintf("%s %s", kind, b.Name)
+}
+
+// A BanError is returned when a ban stops a package entering a repository
+type BanError struct {
+	Repo    string // Repository holding the ban
+	Package string // ID of the banned package
+	Ban     Ban
+}
+
+// Error will explain which ban blocked the package
+func (e *BanError) Error() string {
+	msg := fmt.Sprintf("%s is banned in '%s' by %s", e.Package, e.Repo, e.Ban.String())
+	if e.Ban.Reason != "" {
+		msg += ": " + e.Ban.Reason
+	}
+	return msg
+}
+
+// bannedBy returns the first ban covering the package, or nil
+func (r *Repository) bannedBy(meta *libeopkg.MetaPackage) *Ban {
+	for i := range r.Bans {
+		if r.Bans[i].Matches(meta) {
+			return &r.Bans[i]
+		}
+	}
+	return nil
+}
+
+// checkBanned will return a BanError if the package may not enter this
+// repository
+func (r *Repository) checkBanned(meta *libeopkg.MetaPackage, id string) error {
+	if ban := r.bannedBy(meta); ban != nil {
+		return &BanError{
+			Repo:    r.ID,
+			Package: id,
+			Ban:     *ban,
+		}
+	}
+	return nil
+}
+
+// filterBanned will drop any IDs this repository has banned from the list,
+// logging each one skipped
+func (r *Repository) filterBanned(logger *log.Entry, db libdb.Database, pool *Pool, ids []string) ([]string, error) {
+	if len(r.Bans) == 0 {
+		return ids, nil
+	}
+	entries, err := pool.GetEntries(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	var allowed []string
+	for i, entry := range entries {
+		if ban := r.bannedBy(entry.Meta); ban != nil {
+			logger.WithFields(log.Fields{
+				"repo":   r.ID,
+				"id":     ids[i],
+				"ban":    ban.String(),
+				"reason": ban.Reason,
+			}).Warning("Skipping banned package")
+			continue
+		}
+		allowed = append(allowed, ids[i])
+	}
+	return allowed, nil
+}
+
+// AddBan will ban matching packages from the repository. A ban covering the
+// same packages as an existing one replaces it, to update the reason.
+func (r *RepositoryManager) AddBan(db libdb.Database, id string, ban *Ban) error {
+	if err := ban.Validate(); err != nil {
+		return err
+	}
+	return r.updateRepo(db, id, func(repo *Repository) {
+		for i := range repo.Bans {
+			if repo.Bans[i].sameTarget(ban) {
+				repo.Bans[i] = *ban
+				return
+			}
+		}
+		repo.Bans = append(repo.Bans, *ban)
+	})
+}
+
+// RemoveBan will lift the ban covering exactly the same packages
+func (r *RepositoryManager) RemoveBan(db libdb.Database, id string, ban *Ban) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		for i := range repo.Bans {
+			if repo.Bans[i].sameTarget(ban) {
+				repo.Bans = append(repo.Bans[:i], repo.Bans[i+1:]...)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,143 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libeopkg"
+	"testing"
+)
+
+func TestBanMatches(t *testing.T) {
+	meta := &libeopkg.MetaPackage{
+		Name:    "nano-dbginfo",
+		History: []libeopkg.Update{{Release: 63}},
+	}
+	meta.Source.Name = "nano"
+
+	tests := []struct {
+		ban     Ban
+		matches bool
+		desc    string
+	}{
+		{Ban{Name: "nano-dbginfo"}, true, "package nano-dbginfo"},
+		{Ban{Name: "nano"}, false, "package nano"},
+		{Ban{Name: "nano", Source: true}, true, "source nano"},
+		{Ban{Name: "nano", Source: true, ReleaseFrom: 63, ReleaseTo: 63}, true, "source nano (release 63)"},
+		{Ban{Name: "nano", Source: true, ReleaseFrom: 60, ReleaseTo: 62}, false, "source nano (releases 60-62)"},
+		{Ban{Name: "nano", Source: true, ReleaseFrom: 64}, false, "source nano (release >= 64)"},
+		{Ban{Name: "nano", Source: true, ReleaseTo: 63}, true, "source nano (release <= 63)"},
+	}
+	for _, test := range tests {
+		if err := test.ban.Validate(); err != nil {
+			t.Fatalf("Ban %s should be valid: %v", test.desc, err)
+		}
+		if got := test.ban.Matches(meta); got != test.matches {
+			t.Fatalf("Ban %s: expected match %v, got %v", test.desc, test.matches, got)
+		}
+		if got := test.ban.String(); got != test.desc {
+			t.Fatalf("Expected ban to be described as '%s', got '%s'", test.desc, got)
+		}
+	}
+
+	for _, ban := range []Ban{{}, {Name: "nano", ReleaseFrom: -1}, {Name: "nano", ReleaseFrom: 5, ReleaseTo: 4}} {
+		if err := ban.Validate(); err == nil {
+			t.Fatalf("Ban %+v should be invalid", ban)
+		}
+	}
+}
+
+func TestBanRepo(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	for _, repoID := range []string{"unstable", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+
+	ban := &Ban{Name: "nano", Source: true, ReleaseFrom: 76, Reason: "CVE-2017-0001"}
+	if err := manager.AddBan("stable", ban); err != nil {
+		t.Fatalf("Failed to add ban: %v", err)
+	}
+
+	// Imports and copies are refused
+	err = manager.AddPackages("stable", pkgs[1:], false, nil)
+	if _, ok := err.(*BanError); !ok {
+		t.Fatalf("Expected a BanError importing a banned package, got %v", err)
+	}
+	err = manager.CopySource("unstable", "stable", "nano", 76)
+	if _, ok := err.(*BanError); !ok {
+		t.Fatalf("Expected a BanError copying a banned source, got %v", err)
+	}
+
+	// Pulls skip it, and the diff says why
+	diff, err := manager.DiffRepos("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to diff repos: %v", err)
+	}
+	if len(diff.Missing) != 0 || len(diff.Banned) != 1 {
+		t.Fatalf("Expected nano to be banned, got %+v", diff)
+	}
+	changed, err := manager.PullRepo("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("Banned package was pulled: %v", changed)
+	}
+
+	// Clones inherit the ban, and leave out what it covers
+	if err := manager.AddBan("unstable", ban); err != nil {
+		t.Fatalf("Failed to add ban: %v", err)
+	}
+	if err := manager.CloneRepo("unstable", "clone", true); err != nil {
+		t.Fatalf("Failed to clone repo: %v", err)
+	}
+	clone, err := manager.GetRepo("clone")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if len(clone.Bans) != 1 || clone.Bans[0] != *ban {
+		t.Fatalf("Clone should inherit the ban, got %+v", clone.Bans)
+	}
+	info, err := manager.GetPackageInfo("clone", "nano")
+	if err != nil {
+		t.Fatalf("Failed to get package info: %v", err)
+	}
+	if len(info.Available) != 1 || info.Published.Meta.GetRelease() != 75 {
+		t.Fatalf("Clone should only contain release 75: %+v", info.Available)
+	}
+
+	// Lifting the ban lets it in again
+	if err := manager.RemoveBan("stable", &Ban{Name: "nano", Source: true, ReleaseFrom: 76}); err != nil {
+		t.Fatalf("Failed to remove ban: %v", err)
+	}
+	if err := manager.AddPackages("stable", pkgs[1:], false, nil); err != nil {
+		t.Fatalf("Failed to add package once the ban was lifted: %v", err)
+	}
+}
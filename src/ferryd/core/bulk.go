@@ -0,0 +1,200 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+	"os"
+	"sync"
+)
+
+const (
+	// BulkImportBatchSize is how many packages a bulk import will add to the
+	// database within each transaction
+	BulkImportBatchSize = 64
+)
+
+// A BulkProgressFunc is called each time a bulk import has committed another
+// batch of packages
+type BulkProgressFunc func(done, total int)
+
+// preparedPackage is a package that has been opened, checked and hashed
+// ahead of being added to the repository
+type preparedPackage struct {
+	pkg    *libeopkg.Package
+	hashes *fileHashes
+	err    error
+}
+
+// close will release the package, if it was opened
+func (p *preparedPackage) close() {
+	if p.pkg != nil {
+		p.pkg.Close()
+	}
+}
+
+// preparePackage will do everything needed to add the package that doesn't
+// involve the database, so that it can happen in parallel
+func (r *Repository) preparePackage(path string) *preparedPackage {
+	pkg, err := libeopkg.Open(path)
+	if err != nil {
+		return &preparedPackage{err: err}
+	}
+	ret := &preparedPackage{pkg: pkg}
+	if ret.err = pkg.ReadMetadata(); ret.err != nil {
+		return ret
+	}
+	if r.VerifyHashes {
+		if err = pkg.VerifyFiles(); err != nil {
+			ret.err = fmt.Errorf("%s failed verification: %v", pkg.ID, err)
+			return ret
+		}
+	}
+	ret.hashes, ret.err = hashFile(path)
+	return ret
+}
+
+// prepareBatch will prepare as many packages at once as may be hashed at
+// once, delivering them in order once they're all ready. setup, if set, is
+// run at the start of each goroutine preparing them.
+func (r *Repository) prepareBatch(setup ThreadSetup, paths []string) <-chan []*preparedPackage {
+	ret := make(chan []*preparedPackage, 1)
+	go func() {
+		prepared := make([]*preparedPackage, len(paths))
+		indices := make(chan int)
+		workers := hashWorkers(len(paths))
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				setup.run()
+				for i := range indices {
+					prepared[i] = r.preparePackage(paths[i])
+				}
+			}()
+		}
+		for i := range paths {
+			indices <- i
+		}
+		close(indices)
+		wg.Wait()
+		ret <- prepared
+	}()
+	return ret
+}
+
+// addPreparedBatch will add the packages to the repository within a single
+// transaction. Packages before the first one that failed to prepare are
+// still added before the failure is returned. If any package can't be added
+// to the database, the whole transaction is rolled back and none of the
+// batch is added.
+func (r *Repository) addPreparedBatch(logger *log.Entry, db libdb.Database, pool *Pool, batch []*preparedPackage, prov *Provenance) error {
+	var failure error
+	for i, p := range batch {
+		if p.err != nil {
+			failure = p.err
+			batch = batch[:i]
+			break
+		}
+	}
+
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	// Pool files that this batch creates must go again if it can't commit
+	var created []string
+	for _, p := range batch {
+		target := pool.newFilePath(p.pkg.ID, &p.pkg.Meta.Package, p.hashes.sha256)
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			created = append(created, target)
+		}
+	}
+
+	err := db.Update(func(db libdb.Database) error {
+		for _, p := range batch {
+			if err := r.addLocalPackageLocked(logger, db, pool, p.pkg, p.hashes, prov); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		for _, target := range created {
+			os.Remove(target)
+			RemovePackageParents(target)
+		}
+		return err
+	}
+	return failure
+}
+
+// BulkAddPackages will add many packages to the repository at once. Packages
+// are opened and hashed in parallel while the previous batch is written to
+// the database, one transaction per BulkImportBatchSize packages. The import
+// stops at the first package that can't be added. Unlike AddPackages, that
+// loses every package in its batch, while earlier batches stay added.
+//
+// prov, progress and setup may be nil. prov is recorded for each package new
+// to the pool, progress is called after each batch, and setup is run at the
+// start of each goroutine opening and hashing the packages.
+func (r *Repository) BulkAddPackages(logger *log.Entry, setup ThreadSetup, db libdb.Database, pool *Pool, paths []string, prov *Provenance, progress BulkProgressFunc) error {
+	var batches [][]string
+	for i := 0; i < len(paths); i += BulkImportBatchSize {
+		end := i + BulkImportBatchSize
+		if end > len(paths) {
+			end = len(paths)
+		}
+		batches = append(batches, paths[i:end])
+	}
+	if len(batches) == 0 {
+		return nil
+	}
+
+	done := 0
+	next := r.prepareBatch(setup, batches[0])
+	for i := range batches {
+		batch := <-next
+		if i+1 < len(batches) {
+			next = r.prepareBatch(setup, batches[i+1])
+		}
+
+		err := r.addPreparedBatch(logger, db, pool, batch, prov)
+		for _, p := range batch {
+			p.close()
+		}
+		if err != nil {
+			// Don't leave the next batch open behind us
+			if i+1 < len(batches) {
+				for _, p := range <-next {
+					p.close()
+				}
+			}
+			return err
+		}
+
+		done += len(batch)
+		if progress != nil {
+			progress(done, len(paths))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeBulkPackages will write n uniquely named builds of the search test
+// package into dir
+func writeBulkPackages(t testing.TB, dir string, n int) []string {
+	pkg, err := libeopkg.Open(searchTestPackage)
+	if err != nil {
+		t.Fatalf("Failed to open package: %v", err)
+	}
+	defer pkg.Close()
+	if err = pkg.ReadAll(); err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+	if err = os.MkdirAll(dir, 00755); err != nil {
+		t.Fatalf("Failed to create package dir: %v", err)
+	}
+
+	var paths []string
+	for i := 0; i < n; i++ {
+		pkg.Meta.Package.Name = fmt.Sprintf("bulk%d", i)
+		outPath := filepath.Join(dir, fmt.Sprintf("bulk%d-2.7.1-63-1-x86_64.eopkg", i))
+
+		pw, err := libeopkg.NewPackageWriter(outPath)
+		if err != nil {
+			t.Fatalf("Failed to create package writer: %v", err)
+		}
+		if err = pw.WriteMetadata(pkg.Meta); err != nil {
+			t.Fatalf("Failed to write metadata: %v", err)
+		}
+		if err = pw.WriteFiles(pkg.Files); err != nil {
+			t.Fatalf("Failed to write files: %v", err)
+		}
+		if err = pw.CopyFile(pkg.FindFile("install.tar.xz")); err != nil {
+			t.Fatalf("Failed to copy install.tar.xz: %v", err)
+		}
+		if err = pw.Commit(); err != nil {
+			t.Fatalf("Failed to commit package: %v", err)
+		}
+		pw.Close()
+		paths = append(paths, outPath)
+	}
+	return paths
+}
+
+func TestBulkAddPackages(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	pkgs := writeBulkPackages(t, filepath.Join(dir, "bulk"), 2*BulkImportBatchSize+10)
+
+	var progress []int
+	err = manager.BulkAddPackages("unstable", pkgs, nil, func(done, total int) {
+		if total != len(pkgs) {
+			t.Fatalf("Expected a total of %d packages, got %d", len(pkgs), total)
+		}
+		progress = append(progress, done)
+	})
+	if err != nil {
+		t.Fatalf("Failed to bulk add packages: %v", err)
+	}
+	expected := []int{BulkImportBatchSize, 2 * BulkImportBatchSize, len(pkgs)}
+	if !reflect.DeepEqual(progress, expected) {
+		t.Fatalf("Expected progress %v, got %v", expected, progress)
+	}
+
+	names, err := manager.GetPackageNames("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get package names: %v", err)
+	}
+	if len(names) != len(pkgs) {
+		t.Fatalf("Expected %d packages, found %d", len(pkgs), len(names))
+	}
+	entry, err := manager.pool.GetEntry(manager.db, filepath.Base(pkgs[len(pkgs)-1]))
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if entry.RefCount != 1 || entry.Meta.PackageHash == "" {
+		t.Fatalf("Pool entry wasn't stored properly: %+v", entry)
+	}
+
+	// Everything before a bad package must still be added
+	if err := manager.CreateRepo("broken"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	bad := BulkImportBatchSize + 5
+	broken := append([]string{}, pkgs...)
+	broken[bad] = filepath.Join(dir, "missing.eopkg")
+	if err := manager.BulkAddPackages("broken", broken, nil, nil); err == nil {
+		t.Fatalf("Importing a missing package should fail")
+	}
+	if names, _ = manager.GetPackageNames("broken"); len(names) != bad {
+		t.Fatalf("Expected %d packages before the failure, found %d", bad, len(names))
+	}
+}
+
+func BenchmarkBulkAddPackages(b *testing.B) {
+	dir := initTestArea(b)
+	pkgs := writeBulkPackages(b, filepath.Join(dir, "bulk"), 4*BulkImportBatchSize)
+
+	// Each import starts from an empty pool, as when seeding a new repo
+	add := map[string]func(m *Manager, repoID string) error{
+		"AddPackages": func(m *Manager, repoID string) error {
+			return m.AddPackages(repoID, pkgs, false, nil)
+		},
+		"BulkAddPackages": func(m *Manager, repoID string) error {
+			return m.BulkAddPackages(repoID, pkgs, nil, nil)
+		},
+	}
+	for name, f := range add {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				root := filepath.Join(dir, fmt.Sprintf("%s%d", name, i))
+				os.RemoveAll(root)
+				if err := os.MkdirAll(root, 00755); err != nil {
+					b.Fatalf("Failed to create manager dir: %v", err)
+				}
+				manager, err := NewManager(root)
+				if err != nil {
+					b.Fatalf("Failed to initialise manager: %v", err)
+				}
+				if err := manager.CreateRepo("unstable"); err != nil {
+					b.Fatalf("Failed to create repo: %v", err)
+				}
+
+				b.StartTimer()
+				if err := f(manager, "unstable"); err != nil {
+					b.Fatalf("Failed to add packages: %v", err)
+				}
+				b.StopTimer()
+				manager.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultTempMaxAge is how old leftovers in the temporary directories must
+// be before they're removed, long enough that nothing could still be using
+// them
+const DefaultTempMaxAge = 24 * time.Hour
+
+// A TempCleanup reports the leftovers removed from the temporary directories
+type TempCleanup struct {
+	Paths []string // Removed, relative to the base directory
+	Bytes int64    // Disk space reclaimed
+}
+
+// CleanTemp will remove everything in the delta work and staging areas, and
+// the archive import area, which hasn't changed for maxAge. Leftovers from
+// repositories which no longer exist are removed too. Uploaded archives
+// waiting to be imported are only removed once they're as old.
+func (m *Manager) CleanTemp(maxAge time.Duration) (*TempCleanup, error) {
+	return m.cleanTemp(time.Now().Add(-maxAge), true)
+}
+
+// SweepTemp will remove everything in the delta work and staging areas and
+// any half extracted archives, no matter how new. This is only safe before
+// any jobs are running, i.e. at startup, to clear up after a crash.
+// Uploaded archives are left for the jobs which will import them.
+func (m *Manager) SweepTemp() (*TempCleanup, error) {
+	return m.cleanTemp(time.Now(), false)
+}
+
+// cleanTemp will remove leftovers last changed before the cutoff
+func (m *Manager) cleanTemp(cutoff time.Time, uploads bool) (*TempCleanup, error) {
+	ret := &TempCleanup{}
+	for _, base := range []string{DeltaPathComponent, DeltaStagePathComponent} {
+		repoDirs, err := ioutil.ReadDir(filepath.Join(m.ctx.BaseDir, base))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return ret, err
+		}
+		for _, repoDir := range repoDirs {
+			rel := filepath.Join(base, repoDir.Name())
+			// Nothing is left to use it once the repository has gone
+			if _, err := m.GetRepo(repoDir.Name()); IsNotFound(err) {
+				if err := m.removeStale(ret, rel, cutoff); err != nil {
+					return ret, err
+				}
+				continue
+			}
+			entries, err := ioutil.ReadDir(filepath.Join(m.ctx.BaseDir, rel))
+			if err != nil {
+				return ret, err
+			}
+			for _, entry := range entries {
+				if err := m.removeStale(ret, filepath.Join(rel, entry.Name()), cutoff); err != nil {
+					return ret, err
+				}
+			}
+		}
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(m.ctx.BaseDir, ImportPathComponent))
+	if err != nil && !os.IsNotExist(err) {
+		return ret, err
+	}
+	for _, entry := range entries {
+		// Staged uploads belong to queued import jobs
+		if !entry.IsDir() && strings.HasPrefix(entry.Name(), "upload-") && !uploads {
+			continue
+		}
+		if err := m.removeStale(ret, filepath.Join(ImportPathComponent, entry.Name()), cutoff); err != nil {
+			return ret, err
+		}
+	}
+	return ret, nil
+}
+
+// removeStale will remove the path, relative to the base directory, unless
+// anything within it changed after the cutoff
+func (m *Manager) removeStale(ret *TempCleanup, rel string, cutoff time.Time) error {
+	path := filepath.Join(m.ctx.BaseDir, rel)
+	var size int64
+	stale := true
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			stale = false
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		// Already cleaned up by its owner
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !stale {
+		return nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	m.logger.WithFields(log.Fields{
+		"path": rel,
+		"size": size,
+	}).Debug("Removed leftover temporary files")
+	ret.Paths = append(ret.Paths, rel)
+	ret.Bytes += size
+	return nil
+}
@@ -0,0 +1,104 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeTemp will create the file relative to the base directory, aged by age
+func writeTemp(t *testing.T, base, rel string, size int, age time.Duration) {
+	path := filepath.Join(base, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		t.Fatalf("Failed to create %s: %v", filepath.Dir(path), err)
+	}
+	if err := ioutil.WriteFile(path, make([]byte, size), 00644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	when := time.Now().Add(-age)
+	for p := path; p != base; p = filepath.Dir(p) {
+		if err := os.Chtimes(p, when, when); err != nil {
+			t.Fatalf("Failed to age %s: %v", p, err)
+		}
+	}
+}
+
+func TestCleanTemp(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	base := manager.ctx.BaseDir
+	stale := 48 * time.Hour
+	writeTemp(t, base, "deltaBuilds/unstable/nano-1/root/file", 100, stale)
+	writeTemp(t, base, "deltaStaging/unstable/nano-1-2.delta.eopkg", 20, stale)
+	writeTemp(t, base, "deltaBuilds/removed/nano-2/file", 3, stale)
+	writeTemp(t, base, "imports/upload-1.tar", 4000, stale)
+	writeTemp(t, base, "deltaBuilds/unstable/nano-3/file", 10, 0)
+	writeTemp(t, base, "imports/upload-2.tar", 10, 0)
+	writeTemp(t, base, "imports/extract-1/file", 10, 0)
+
+	cleanup, err := manager.CleanTemp(DefaultTempMaxAge)
+	if err != nil {
+		t.Fatalf("Failed to clean temporary files: %v", err)
+	}
+	sort.Strings(cleanup.Paths)
+	expected := []string{
+		"deltaBuilds/removed",
+		"deltaBuilds/unstable/nano-1",
+		"deltaStaging/unstable/nano-1-2.delta.eopkg",
+		"imports/upload-1.tar",
+	}
+	if len(cleanup.Paths) != len(expected) {
+		t.Fatalf("Expected %v removed, got %v", expected, cleanup.Paths)
+	}
+	for i, rel := range expected {
+		if cleanup.Paths[i] != rel {
+			t.Fatalf("Expected %v removed, got %v", expected, cleanup.Paths)
+		}
+		if _, err := os.Stat(filepath.Join(base, rel)); !os.IsNotExist(err) {
+			t.Fatalf("%s wasn't removed", rel)
+		}
+	}
+	if cleanup.Bytes != 4123 {
+		t.Fatalf("Expected 4123 bytes reclaimed, got %d", cleanup.Bytes)
+	}
+
+	// Everything but uploads goes at startup
+	cleanup, err = manager.SweepTemp()
+	if err != nil {
+		t.Fatalf("Failed to sweep temporary files: %v", err)
+	}
+	if len(cleanup.Paths) != 2 || cleanup.Bytes != 20 {
+		t.Fatalf("Expected 2 paths of 20 bytes swept, got %v of %d", cleanup.Paths, cleanup.Bytes)
+	}
+	for _, rel := range []string{"imports/upload-2.tar", "deltaBuilds/unstable", "deltaStaging/unstable"} {
+		if _, err := os.Stat(filepath.Join(base, rel)); err != nil {
+			t.Fatalf("%s should have been kept: %v", rel, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libdb"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// storedCodec returns the codec that wrote the pool entry
+func storedCodec(t testing.TB, manager *Manager, id string) string {
+	var codec string
+	err := manager.db.Bucket([]byte(DatabaseBucketPool)).ForEach(func(k, v []byte) error {
+		if string(k) != id {
+			return nil
+		}
+		var err error
+		codec, err = libdb.CodecOf(v)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to read pool: %v", err)
+	}
+	return codec
+}
+
+func TestCodecMigration(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgID := filepath.Base(searchTestPackage)
+	if err := manager.db.SetCodec("gob"); err != nil {
+		t.Fatalf("Failed to set codec: %v", err)
+	}
+	if err := manager.db.SetCodec("json"); err == nil {
+		t.Fatalf("Setting an unknown codec should fail")
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if codec := storedCodec(t, manager, pkgID); codec != "gob" {
+		t.Fatalf("Expected gob encoded entry, got %s", codec)
+	}
+
+	// Reading with another codec in use will migrate the entry once the
+	// next transaction commits
+	if err := manager.db.SetCodec("msgpack"); err != nil {
+		t.Fatalf("Failed to set codec: %v", err)
+	}
+	before, err := manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if codec := storedCodec(t, manager, pkgID); codec != "gob" {
+		t.Fatalf("Entry should not be rewritten outside a transaction, got %s", codec)
+	}
+	if err := manager.db.Update(func(db libdb.Database) error { return nil }); err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if codec := storedCodec(t, manager, pkgID); codec != "msgpack" {
+		t.Fatalf("Expected migrated entry, got %s", codec)
+	}
+	after, err := manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("Migrated entry differs:\n%+v\n%+v", before, after)
+	}
+}
+
+// BenchmarkPoolEntryCodecs measures reading a pool entry, which is what
+// dominates the database time when indexing a repository
+func BenchmarkPoolEntryCodecs(b *testing.B) {
+	manager, err := NewManager(initTestArea(b))
+	if err != nil {
+		b.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgID := filepath.Base(searchTestPackage)
+	if err := manager.CreateRepo("unstable"); err != nil {
+		b.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		b.Fatalf("Failed to add package: %v", err)
+	}
+
+	for _, codec := range libdb.Codecs() {
+		b.Run(codec, func(b *testing.B) {
+			if err := manager.db.SetCodec(codec); err != nil {
+				b.Fatalf("Failed to set codec: %v", err)
+			}
+			entry, err := manager.pool.GetEntry(manager.db, pkgID)
+			if err != nil {
+				b.Fatalf("Failed to get entry: %v", err)
+			}
+			if err := manager.pool.putEntry(manager.db, entry); err != nil {
+				b.Fatalf("Failed to put entry: %v", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := manager.pool.GetEntry(manager.db, pkgID); err != nil {
+					b.Fatalf("Failed to get entry: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkIndexCodecs measures indexing a repository whose records were all
+// written with each codec
+func BenchmarkIndexCodecs(b *testing.B) {
+	dir := initTestArea(b)
+	pkgs := writeBulkPackages(b, filepath.Join(dir, "bulk"), 2*BulkImportBatchSize)
+
+	for _, codec := range libdb.Codecs() {
+		root := filepath.Join(dir, codec)
+		if err := os.MkdirAll(root, 00755); err != nil {
+			b.Fatalf("Failed to create manager dir: %v", err)
+		}
+		manager, err := NewManager(root)
+		if err != nil {
+			b.Fatalf("Failed to initialise manager: %v", err)
+		}
+		defer manager.Close()
+		if err := manager.db.SetCodec(codec); err != nil {
+			b.Fatalf("Failed to set codec: %v", err)
+		}
+		if err := manager.CreateRepo("unstable"); err != nil {
+			b.Fatalf("Failed to create repo: %v", err)
+		}
+		if err := manager.BulkAddPackages("unstable", pkgs, nil, nil); err != nil {
+			b.Fatalf("Failed to add packages: %v", err)
+		}
+
+		b.Run(codec, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := manager.Index("unstable"); err != nil {
+					b.Fatalf("Failed to index: %v", err)
+				}
+			}
+		})
+	}
+}
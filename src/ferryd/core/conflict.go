@@ -0,0 +1,193 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libdb"
+	"libeopkg"
+	"sort"
+	"time"
+)
+
+const (
+	// DatabaseBucketConflict is the root bucket for duplicate release records
+	DatabaseBucketConflict = "conflict"
+
+	// ConflictSchemaVersion is the current version of a ReleaseConflict
+	ConflictSchemaVersion = "1.0"
+)
+
+// A ConflictPolicy decides what happens when a package is imported with the
+// same release number as the published package, but a different version.
+type ConflictPolicy string
+
+const (
+	// ConflictKeep will keep both packages, leaving the existing one published
+	ConflictKeep ConflictPolicy = "keep"
+
+	// ConflictReject will fail the import
+	ConflictReject ConflictPolicy = "reject"
+
+	// ConflictNewer will keep whichever package was built most recently
+	ConflictNewer ConflictPolicy = "newer"
+)
+
+// Validate will ensure the policy is a known one. An empty policy is the
+// same as ConflictKeep, which was the behaviour before policies existed.
+func (c ConflictPolicy) Validate() error {
+	switch c {
+	case "", ConflictKeep, ConflictReject, ConflictNewer:
+		return nil
+	default:
+		return fmt.Errorf("Unknown conflict policy '%s'", c)
+	}
+}
+
+// A ReleaseConflict records a duplicate release number seen while importing,
+// and how it was resolved, until a higher release supersedes it.
+type ReleaseConflict struct {
+	SchemaVersion string
+	Time          time.Time
+	Repo          string
+	Name          string // Package name
+	Release       int
+	Existing      string // ID of the package already in the repository
+	New           string // ID of the package being imported
+	Resolution    string // What was done about it
+}
+
+// conflictKey returns the key for a package's conflict within the repo
+func conflictKey(repoID, name string) []byte {
+	return []byte(fmt.Sprintf("%s/%s", repoID, name))
+}
+
+// recordConflict will store the conflict, replacing any earlier one for the
+// same package
+func recordConflict(db libdb.Database, conflict *ReleaseConflict) error {
+	conflict.SchemaVersion = ConflictSchemaVersion
+	conflict.Time = time.Now().UTC()
+	return db.Bucket([]byte(DatabaseBucketConflict)).PutObject(conflictKey(conflict.Repo, conflict.Name), conflict)
+}
+
+// clearConflict will forget any conflict for the package
+func clearConflict(db libdb.Database, repoID, name string) error {
+	bucket := db.Bucket([]byte(DatabaseBucketConflict))
+	key := conflictKey(repoID, name)
+	if has, err := bucket.HasObject(key); err != nil || !has {
+		return err
+	}
+	return bucket.DeleteObject(key)
+}
+
+// GetConflicts will return the outstanding release conflicts, ordered by
+// repository and package name. If repoID is empty, the conflicts of every
+// repository are returned.
+func GetConflicts(db libdb.Database, repoID string) ([]*ReleaseConflict, error) {
+	var ret []*ReleaseConflict
+	var prefix []byte
+	if repoID != "" {
+		prefix = []byte(repoID + "/")
+	}
+	err := db.Bucket([]byte(DatabaseBucketConflict)).View(func(db libdb.ReadOnlyView) error {
+		return db.ForEachPrefix(prefix, func(k, v []byte) error {
+			conflict := &ReleaseConflict{}
+			if err := db.Decode(v, conflict); err != nil {
+				return err
+			}
+			ret = append(ret, conflict)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Repo != ret[j].Repo {
+			return ret[i].Repo < ret[j].Repo
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// clearRepoConflicts will forget every conflict for the repository
+func clearRepoConflicts(db libdb.Database, repoID string) error {
+	conflicts, err := GetConflicts(db, repoID)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range conflicts {
+		if err := clearConflict(db, repoID, conflict.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildTime returns when the package at the given path was built
+func buildTime(path string) (time.Time, error) {
+	pkg, err := libeopkg.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer pkg.Close()
+	return pkg.BuildTime()
+}
+
+// resolveConflict will apply the repository's conflict policy to a package
+// sharing the published release. It returns whether the new package should
+// replace the existing one, and whether it should be skipped entirely.
+// Otherwise the two are kept alongside each other.
+func (r *Repository) resolveConflict(db libdb.Database, pool *Pool, existing *PoolEntry, newPkg *libeopkg.MetaPackage, newID, newPath string) (replace, skip bool, err error) {
+	conflict := &ReleaseConflict{
+		Repo:     r.ID,
+		Name:     newPkg.Name,
+		Release:  newPkg.GetRelease(),
+		Existing: existing.Name,
+		New:      newID,
+	}
+
+	switch r.ConflictPolicy {
+	case ConflictReject:
+		conflict.Resolution = "rejected"
+		err = fmt.Errorf("duplicate release number %d for %s: %s is already in '%s'", conflict.Release, newPkg.Name, existing.Name, r.ID)
+	case ConflictNewer:
+		oldTime, err := buildTime(pool.EntryPath(existing))
+		if err != nil {
+			return false, false, err
+		}
+		newTime, err := buildTime(newPath)
+		if err != nil {
+			return false, false, err
+		}
+		if newTime.After(oldTime) {
+			conflict.Resolution = "replaced with newer build"
+			replace = true
+		} else {
+			conflict.Resolution = "ignored older build"
+			skip = true
+		}
+	default:
+		conflict.Resolution = "kept both"
+	}
+
+	if e2 := recordConflict(db, conflict); e2 != nil {
+		return false, false, e2
+	}
+	return replace, skip, err
+}
@@ -0,0 +1,157 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConflictingPackage will write a new build of the search test package
+// with a different version, but the same release number
+func writeConflictingPackage(t *testing.T, dir string) string {
+	pkg, err := libeopkg.Open(searchTestPackage)
+	if err != nil {
+		t.Fatalf("Failed to open package: %v", err)
+	}
+	defer pkg.Close()
+	if err = pkg.ReadAll(); err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+
+	pkg.Meta.Package.History[0].Version = "2.7.2"
+	outPath := filepath.Join(dir, "nano-2.7.2-63-1-x86_64.eopkg")
+
+	pw, err := libeopkg.NewPackageWriter(outPath)
+	if err != nil {
+		t.Fatalf("Failed to create package writer: %v", err)
+	}
+	defer pw.Close()
+	if err = pw.WriteMetadata(pkg.Meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err = pw.WriteFiles(pkg.Files); err != nil {
+		t.Fatalf("Failed to write files: %v", err)
+	}
+	if err = pw.CopyFile(pkg.FindFile("install.tar.xz")); err != nil {
+		t.Fatalf("Failed to copy install.tar.xz: %v", err)
+	}
+	if err = pw.Commit(); err != nil {
+		t.Fatalf("Failed to commit package: %v", err)
+	}
+	return outPath
+}
+
+func TestConflictPolicy(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	dir, err := ioutil.TempDir("", "conflict")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	newBuild := writeConflictingPackage(t, dir)
+	oldID := filepath.Base(searchTestPackage)
+	newID := filepath.Base(newBuild)
+
+	if err := ConflictPolicy("sometimes").Validate(); err == nil {
+		t.Fatalf("Unknown conflict policy should be invalid")
+	}
+
+	tests := []struct {
+		policy    ConflictPolicy
+		fail      bool
+		published string
+		available int
+	}{
+		{ConflictKeep, false, oldID, 2},
+		{ConflictReject, true, oldID, 1},
+		{ConflictNewer, false, newID, 1},
+	}
+
+	for _, test := range tests {
+		repoID := string(test.policy)
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+		if err := manager.SetConflictPolicy(repoID, test.policy); err != nil {
+			t.Fatalf("Failed to set conflict policy: %v", err)
+		}
+		if err := manager.AddPackages(repoID, []string{searchTestPackage}, false, nil); err != nil {
+			t.Fatalf("Failed to add package: %v", err)
+		}
+
+		err := manager.AddPackages(repoID, []string{newBuild}, false, nil)
+		if test.fail != (err != nil) {
+			t.Fatalf("Unexpected result for policy %s: %v", test.policy, err)
+		}
+
+		repo, err := manager.GetRepo(repoID)
+		if err != nil {
+			t.Fatalf("Failed to get repo: %v", err)
+		}
+		entry, err := repo.GetEntry(manager.db, "nano")
+		if err != nil {
+			t.Fatalf("Failed to get repo entry: %v", err)
+		}
+		if entry.Published != test.published {
+			t.Fatalf("Policy %s published %s, expected %s", test.policy, entry.Published, test.published)
+		}
+		if len(entry.Available) != test.available {
+			t.Fatalf("Policy %s kept %d packages, expected %d", test.policy, len(entry.Available), test.available)
+		}
+
+		conflicts, err := manager.GetConflicts(repoID)
+		if err != nil {
+			t.Fatalf("Failed to get conflicts: %v", err)
+		}
+		if len(conflicts) != 1 || conflicts[0].Existing != oldID || conflicts[0].New != newID {
+			t.Fatalf("Conflict for policy %s not recorded: %v", test.policy, conflicts)
+		}
+	}
+
+	// The replaced build must be gone from the tree
+	repo, _ := manager.GetRepo(string(ConflictNewer))
+	meta, err := manager.GetPoolEntry(newID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if PathExists(filepath.Join(repo.path, meta.GetPathComponent(), oldID)) {
+		t.Fatalf("Replaced package was not removed")
+	}
+
+	conflicts, err := manager.GetConflicts("")
+	if err != nil {
+		t.Fatalf("Failed to get conflicts: %v", err)
+	}
+	if len(conflicts) != 3 {
+		t.Fatalf("Expected 3 conflicts, got %d", len(conflicts))
+	}
+	if err := manager.DeleteRepo(string(ConflictKeep)); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if conflicts, _ = manager.GetConflicts(""); len(conflicts) != 2 {
+		t.Fatalf("Conflicts of deleted repo were kept")
+	}
+}
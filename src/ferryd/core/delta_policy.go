@@ -0,0 +1,75 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libeopkg"
+	"sort"
+)
+
+// A DeltaPolicy controls which deltas are produced for a repository. The
+// zero value produces every possible delta, matching older repositories.
+type DeltaPolicy struct {
+	Disabled    bool  // Don't produce any deltas at all
+	MaxDeltas   int   // Most deltas to produce per package, 0 for no limit
+	MinDistance int   // Only delta from releases at least this far behind the tip
+	MaxSize     int64 // Skip packages larger than this many bytes, 0 for no limit
+}
+
+// Validate will ensure the policy is sane
+func (p *DeltaPolicy) Validate() error {
+	if p.MaxDeltas < 0 {
+		return fmt.Errorf("Maximum deltas cannot be negative: %d", p.MaxDeltas)
+	}
+	if p.MinDistance < 0 {
+		return fmt.Errorf("Minimum release distance cannot be negative: %d", p.MinDistance)
+	}
+	if p.MaxSize < 0 {
+		return fmt.Errorf("Maximum package size cannot be negative: %d", p.MaxSize)
+	}
+	return nil
+}
+
+// SelectCandidates will return those older packages which the policy allows
+// a delta to be produced from, newest first. Newer releases are preferred as
+// they're the most likely to be installed.
+func (p *DeltaPolicy) SelectCandidates(tip *libeopkg.MetaPackage, candidates []*libeopkg.MetaPackage) []*libeopkg.MetaPackage {
+	if p.Disabled {
+		return nil
+	}
+	if p.MaxSize > 0 && tip.PackageSize > p.MaxSize {
+		return nil
+	}
+
+	var ret []*libeopkg.MetaPackage
+	for _, c := range candidates {
+		if tip.GetRelease()-c.GetRelease() < p.MinDistance {
+			continue
+		}
+		ret = append(ret, c)
+	}
+
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].GetRelease() > ret[j].GetRelease()
+	})
+
+	if p.MaxDeltas > 0 && len(ret) > p.MaxDeltas {
+		ret = ret[:p.MaxDeltas]
+	}
+	return ret
+}
@@ -0,0 +1,149 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libeopkg"
+	"os"
+	"sort"
+	"testing"
+)
+
+// policyTestPackage returns a minimal package at the given release
+func policyTestPackage(release int, size int64) *libeopkg.MetaPackage {
+	return &libeopkg.MetaPackage{
+		History:     []libeopkg.Update{{Release: release}},
+		PackageSize: size,
+	}
+}
+
+func TestDeltaPolicy(t *testing.T) {
+	tip := policyTestPackage(10, 1024)
+	var candidates []*libeopkg.MetaPackage
+	for i := 1; i < 10; i++ {
+		candidates = append(candidates, policyTestPackage(i, 1024))
+	}
+
+	policy := &DeltaPolicy{}
+	if got := policy.SelectCandidates(tip, candidates); len(got) != 9 {
+		t.Fatalf("Default policy should allow all candidates, got %d", len(got))
+	}
+
+	policy = &DeltaPolicy{MaxDeltas: 3, MinDistance: 2}
+	got := policy.SelectCandidates(tip, candidates)
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 candidates, got %d", len(got))
+	}
+	if got[0].GetRelease() != 8 || got[2].GetRelease() != 6 {
+		t.Fatalf("Expected releases 8 to 6, got %d to %d", got[0].GetRelease(), got[2].GetRelease())
+	}
+
+	policy = &DeltaPolicy{MaxSize: 512}
+	if got := policy.SelectCandidates(tip, candidates); len(got) != 0 {
+		t.Fatalf("Oversized package should not be deltaed, got %d candidates", len(got))
+	}
+
+	policy = &DeltaPolicy{Disabled: true}
+	if got := policy.SelectCandidates(tip, candidates); len(got) != 0 {
+		t.Fatalf("Disabled policy should not allow deltas, got %d candidates", len(got))
+	}
+
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetDeltaPolicy("unstable", &DeltaPolicy{MaxDeltas: -1}); err == nil {
+		t.Fatalf("Should not accept a negative delta limit")
+	}
+	if err := manager.SetDeltaPolicy("unstable", &DeltaPolicy{MaxDeltas: 2}); err != nil {
+		t.Fatalf("Failed to set delta policy: %v", err)
+	}
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if repo.DeltaPolicy.MaxDeltas != 2 {
+		t.Fatalf("Expected stored delta limit of 2, got %d", repo.DeltaPolicy.MaxDeltas)
+	}
+}
+
+func TestInvalidateDeltas(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+
+	metas, err := manager.GetPackages("unstable", "nano")
+	if err != nil || len(metas) != 2 {
+		t.Fatalf("Expected 2 packages, got %d: %v", len(metas), err)
+	}
+	sort.Sort(libeopkg.PackageSet(metas))
+	old, tip := metas[0], metas[1]
+
+	deltaPath, err := manager.CreateDelta("unstable", old, tip, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create delta: %v", err)
+	}
+	defer os.Remove(deltaPath)
+
+	// Pretend the delta leads to a release which is no longer published
+	mapping := &DeltaInformation{
+		FromID:      old.GetID(),
+		ToID:        old.GetID(),
+		FromRelease: old.GetRelease(),
+		ToRelease:   old.GetRelease(),
+	}
+	if err := manager.AddDelta("unstable", deltaPath, mapping); err != nil {
+		t.Fatalf("Failed to add delta: %v", err)
+	}
+	deltaID := libeopkg.ComputeDeltaName(old, tip)
+
+	removed, err := manager.InvalidateDeltas("unstable")
+	if err != nil {
+		t.Fatalf("Failed to invalidate deltas: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != deltaID {
+		t.Fatalf("Expected %s to be removed, got %v", deltaID, removed)
+	}
+	if entry, _ := manager.GetPoolEntry(deltaID); entry != nil {
+		t.Fatalf("Stale delta should have been removed from the pool")
+	}
+	if has, _ := manager.HasDelta("unstable", "nano", deltaID); has {
+		t.Fatalf("Stale delta should have been removed from the repo")
+	}
+
+	if removed, err = manager.InvalidateDeltas("unstable"); err != nil || len(removed) != 0 {
+		t.Fatalf("Expected nothing further to remove, got %v: %v", removed, err)
+	}
+}
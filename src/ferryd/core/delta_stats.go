@@ -0,0 +1,133 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libdb"
+	"sort"
+)
+
+// A DeltaStat describes how much one delta package saves over fetching the
+// full package it produces
+type DeltaStat struct {
+	ID          string
+	Package     string // Name of the package the delta is for
+	FromRelease int
+	ToRelease   int
+	Size        int64 // Size of the delta package
+	TargetSize  int64 // Size of the full package, 0 if no longer known
+	Current     bool  // Goes to the published release, so is in the index
+}
+
+// Ratio returns the size of the delta relative to the full package, or 0 if
+// the size of the full package isn't known
+func (d *DeltaStat) Ratio() float64 {
+	if d.TargetSize <= 0 {
+		return 0
+	}
+	return float64(d.Size) / float64(d.TargetSize)
+}
+
+// DeltaStats summarise how effective the deltas of a repository are, so that
+// the delta policy can be tuned
+type DeltaStats struct {
+	Repo        string
+	Deltas      int          // Delta packages held by the repository
+	Current     int          // Deltas to a published release, and so in the index
+	Unknown     int          // Deltas whose full package size isn't known, left out of the totals
+	Bytes       int64        // Combined size of the deltas
+	TargetBytes int64        // Combined size of the full packages they produce
+	Worst       []*DeltaStat // Least effective deltas first
+}
+
+// Saved returns the bytes saved by fetching each delta once rather than its
+// full package
+func (s *DeltaStats) Saved() int64 {
+	return s.TargetBytes - s.Bytes
+}
+
+// Ratio returns the combined size of the deltas relative to the full
+// packages they produce
+func (s *DeltaStats) Ratio() float64 {
+	if s.TargetBytes <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.TargetBytes)
+}
+
+// DeltaStats will measure every delta in the repository against the full
+// package it produces. Deltas produced before their target size was recorded
+// fall back to the target still in the pool. At most limit of the least
+// effective deltas are returned, or all of them if limit is 0.
+func (r *Repository) DeltaStats(db libdb.Database, pool *Pool, limit int) (*DeltaStats, error) {
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &DeltaStats{Repo: r.ID}
+	var all []*DeltaStat
+	for _, entry := range entries {
+		deltas, err := pool.GetEntries(db, entry.Deltas)
+		if err != nil {
+			return nil, err
+		}
+		for _, delta := range deltas {
+			if delta.Delta == nil {
+				continue
+			}
+			stat := &DeltaStat{
+				ID:          delta.Name,
+				Package:     entry.Name,
+				FromRelease: delta.Delta.FromRelease,
+				ToRelease:   delta.Delta.ToRelease,
+				Size:        delta.Meta.PackageSize,
+				TargetSize:  delta.Delta.TargetSize,
+				Current:     delta.Delta.ToID == entry.Published,
+			}
+			if stat.TargetSize == 0 {
+				if target, err := pool.GetEntry(db, delta.Delta.ToID); err == nil {
+					stat.TargetSize = target.Meta.PackageSize
+				}
+			}
+
+			stats.Deltas++
+			if stat.Current {
+				stats.Current++
+			}
+			if stat.TargetSize == 0 {
+				stats.Unknown++
+				continue
+			}
+			stats.Bytes += stat.Size
+			stats.TargetBytes += stat.TargetSize
+			all = append(all, stat)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Ratio() == all[j].Ratio() {
+			return all[i].ID < all[j].ID
+		}
+		return all[i].Ratio() > all[j].Ratio()
+	})
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+	stats.Worst = all
+	return stats, nil
+}
@@ -0,0 +1,98 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libeopkg"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestDeltaStats(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+
+	stats, err := manager.DeltaStats("unstable", 0)
+	if err != nil {
+		t.Fatalf("Failed to get delta stats: %v", err)
+	}
+	if stats.Deltas != 0 || stats.Ratio() != 0 || len(stats.Worst) != 0 {
+		t.Fatalf("Expected no deltas yet: %+v", stats)
+	}
+
+	metas, err := manager.GetPackages("unstable", "nano")
+	if err != nil || len(metas) != 2 {
+		t.Fatalf("Expected 2 packages, got %d: %v", len(metas), err)
+	}
+	sort.Sort(libeopkg.PackageSet(metas))
+	old, tip := metas[0], metas[1]
+
+	deltaPath, err := manager.CreateDelta("unstable", old, tip, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create delta: %v", err)
+	}
+	defer os.Remove(deltaPath)
+	mapping := &DeltaInformation{
+		FromID: old.GetID(),
+		ToID:   tip.GetID(),
+	}
+	if err := manager.AddDelta("unstable", deltaPath, mapping); err != nil {
+		t.Fatalf("Failed to add delta: %v", err)
+	}
+
+	// The size of the full package is recorded with the delta
+	deltaID := libeopkg.ComputeDeltaName(old, tip)
+	entry, err := manager.pool.GetEntry(manager.db, deltaID)
+	if err != nil {
+		t.Fatalf("Failed to get delta pool entry: %v", err)
+	}
+	if entry.Delta.TargetSize != tip.PackageSize {
+		t.Fatalf("Expected target size %d, got %d", tip.PackageSize, entry.Delta.TargetSize)
+	}
+
+	stats, err = manager.DeltaStats("unstable", 0)
+	if err != nil {
+		t.Fatalf("Failed to get delta stats: %v", err)
+	}
+	if stats.Deltas != 1 || stats.Current != 1 || stats.Unknown != 0 {
+		t.Fatalf("Expected one current delta: %+v", stats)
+	}
+	if stats.Bytes != entry.Meta.PackageSize || stats.TargetBytes != tip.PackageSize {
+		t.Fatalf("Unexpected totals: %+v", stats)
+	}
+	if stats.Saved() <= 0 || stats.Ratio() <= 0 || stats.Ratio() >= 1 {
+		t.Fatalf("Delta should save something: saved %d, ratio %f", stats.Saved(), stats.Ratio())
+	}
+	if len(stats.Worst) != 1 || stats.Worst[0].ID != deltaID || stats.Worst[0].Ratio() != stats.Ratio() {
+		t.Fatalf("Expected the delta to be listed: %+v", stats.Worst)
+	}
+}
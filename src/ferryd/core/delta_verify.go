@@ -0,0 +1,65 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libdb"
+	"libeopkg"
+)
+
+// A DeltaVerifyError is returned when a delta doesn't produce the package it
+// claims to, and would break updates if it were indexed
+type DeltaVerifyError struct {
+	Delta string // ID of the refused delta
+	Err   error  // What was wrong with it
+}
+
+// Error will explain why the delta was refused
+func (e *DeltaVerifyError) Error() string {
+	return fmt.Sprintf("Delta %s failed verification: %v", e.Delta, e.Err)
+}
+
+// verifyDelta will ensure the staged delta goes between the packages in the
+// mapping, both of which must be in the pool, and that its contents really
+// turn one into the other
+func (r *Repository) verifyDelta(db libdb.Database, pool *Pool, pkg *libeopkg.Package, mapping *DeltaInformation) error {
+	fromEntry, err := pool.GetEntry(db, mapping.FromID)
+	if err != nil {
+		return &DeltaVerifyError{pkg.ID, fmt.Errorf("source package %s is not in the pool", mapping.FromID)}
+	}
+	toEntry, err := pool.GetEntry(db, mapping.ToID)
+	if err != nil {
+		return &DeltaVerifyError{pkg.ID, fmt.Errorf("target package %s is not in the pool", mapping.ToID)}
+	}
+
+	from, err := libeopkg.Open(pool.EntryPath(fromEntry))
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := libeopkg.Open(pool.EntryPath(toEntry))
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	if err = pkg.VerifyDelta(from, to); err != nil {
+		return &DeltaVerifyError{pkg.ID, err}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libeopkg"
+	"os"
+	"sort"
+	"testing"
+)
+
+func TestVerifyDeltas(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetVerifyDeltas("unstable", true); err != nil {
+		t.Fatalf("Failed to enable delta verification: %v", err)
+	}
+	pkgs := []string{
+		searchTestPackage,
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+	metas, err := manager.GetPackages("unstable", "nano")
+	if err != nil || len(metas) != 3 {
+		t.Fatalf("Expected 3 packages, got %d: %v", len(metas), err)
+	}
+	sort.Sort(libeopkg.PackageSet(metas))
+	older, old, tip := metas[0], metas[1], metas[2]
+
+	deltaPath, err := manager.CreateDelta("unstable", old, tip, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create delta: %v", err)
+	}
+	defer os.Remove(deltaPath)
+
+	// Claiming the delta goes from another release is caught
+	for _, fromID := range []string{older.GetID(), "nano-2.8.4-74-1-x86_64.eopkg"} {
+		mapping := &DeltaInformation{FromID: fromID, ToID: tip.GetID()}
+		err = manager.AddDelta("unstable", deltaPath, mapping)
+		if _, ok := err.(*DeltaVerifyError); !ok {
+			t.Fatalf("Expected a DeltaVerifyError for a delta from %s, got %v", fromID, err)
+		}
+	}
+	if entry, _ := manager.GetPoolEntry(libeopkg.ComputeDeltaName(old, tip)); entry != nil {
+		t.Fatalf("Delta failing verification entered the pool")
+	}
+
+	mapping := &DeltaInformation{FromID: old.GetID(), ToID: tip.GetID()}
+	if err := manager.AddDelta("unstable", deltaPath, mapping); err != nil {
+		t.Fatalf("Valid delta failed verification: %v", err)
+	}
+}
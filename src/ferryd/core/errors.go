@@ -0,0 +1,43 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+)
+
+// A NotFoundError is returned when the repository, package or other object
+// a request names doesn't exist, rather than the request failing outright
+type NotFoundError struct {
+	msg string
+}
+
+// Error implements the error interface
+func (e *NotFoundError) Error() string {
+	return e.msg
+}
+
+// notFoundf will return a NotFoundError with the formatted message
+func notFoundf(format string, args ...interface{}) error {
+	return &NotFoundError{msg: fmt.Sprintf(format, args...)}
+}
+
+// IsNotFound will determine if the error was caused by something not existing
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
@@ -0,0 +1,256 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+const (
+	// DefaultHashChunkSize is how much of a file is read for hashing at once
+	DefaultHashChunkSize = 1024 * 1024
+
+	// DefaultHashReadAhead is how many chunks may be read ahead of the
+	// hashing, so that the disk and CPU are kept busy at the same time
+	DefaultHashReadAhead = 4
+)
+
+// hashOptions control how files are read for hashing, shared by everything
+// in the process
+var hashOptions = struct {
+	sync.RWMutex
+	chunkSize int
+	readAhead int
+	parallel  int
+}{
+	chunkSize: DefaultHashChunkSize,
+	readAhead: DefaultHashReadAhead,
+}
+
+// SetHashOptions will set the size of each chunk read for hashing, how many
+// chunks may be read ahead of the hashing, and how many files may be hashed
+// at once when importing several. A chunkSize of 0 uses the default, a
+// readAhead of 0 reads and hashes in turn, and a parallel of 0 hashes as
+// many files at once as there are cores.
+func SetHashOptions(chunkSize, readAhead, parallel int) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultHashChunkSize
+	}
+	if readAhead < 0 {
+		readAhead = 0
+	}
+	if parallel < 0 {
+		parallel = 0
+	}
+	hashOptions.Lock()
+	defer hashOptions.Unlock()
+	hashOptions.chunkSize = chunkSize
+	hashOptions.readAhead = readAhead
+	hashOptions.parallel = parallel
+}
+
+// A ThreadSetup is run at the start of each goroutine that a job's work is
+// spread over, to give its thread the same scheduling as the job's own. A
+// setup locking the thread should leave it locked, so that the thread is
+// thrown away once the goroutine is done rather than going back to the
+// runtime with the job's scheduling.
+type ThreadSetup func()
+
+// run will call the setup, if there is one
+func (s ThreadSetup) run() {
+	if s != nil {
+		s()
+	}
+}
+
+// hashWorkers will return how many of n files may be hashed at once
+func hashWorkers(n int) int {
+	hashOptions.RLock()
+	workers := hashOptions.parallel
+	hashOptions.RUnlock()
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
+	if workers > n {
+		workers = n
+	}
+	return workers
+}
+
+// hashStream will feed everything read from r through each of the hashes.
+// Reading happens in chunks, up to the read ahead in front of the hashing,
+// so only that much of the file is ever held in memory. When there are
+// several hashes each chunk is hashed by all of them at once.
+//
+// The reading always happens on the calling goroutine, so that it's done
+// with the scheduling of the caller's thread, i.e. a job's I/O priority.
+// Only the hashing is handed off.
+func hashStream(r io.Reader, hashes ...hash.Hash) error {
+	hashOptions.RLock()
+	chunkSize, readAhead := hashOptions.chunkSize, hashOptions.readAhead
+	hashOptions.RUnlock()
+
+	if readAhead == 0 {
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				writeHashes(hashes, buf[:n])
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Only readAhead buffers ever exist, so sending either way can't block
+	free := make(chan []byte, readAhead)
+	full := make(chan []byte, readAhead)
+	for i := 0; i < readAhead; i++ {
+		free <- make([]byte, chunkSize)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for buf := range full {
+			writeHashes(hashes, buf)
+			free <- buf[:cap(buf)]
+		}
+	}()
+
+	var readErr error
+	for {
+		buf := <-free
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			full <- buf[:n]
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	close(full)
+	<-done
+	return readErr
+}
+
+// writeHashes will write the chunk to every hash, in parallel if there
+// are several
+func writeHashes(hashes []hash.Hash, chunk []byte) {
+	if len(hashes) == 1 {
+		hashes[0].Write(chunk)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, h := range hashes[1:] {
+		wg.Add(1)
+		go func(h hash.Hash) {
+			defer wg.Done()
+			h.Write(chunk)
+		}(h)
+	}
+	hashes[0].Write(chunk)
+	wg.Wait()
+}
+
+// hashPath will stream the file through each of the hashes
+func hashPath(path string, hashes ...hash.Hash) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return hashStream(f, hashes...)
+}
+
+// FileSha1sum is a quick wrapper to grab the sha1sum for the given file
+func FileSha1sum(path string) (string, error) {
+	h := sha1.New()
+	if err := hashPath(path, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// FileSha256sum is a quick wrapper to grab the sha256sum for the given file
+func FileSha256sum(path string) (string, error) {
+	h := sha256.New()
+	if err := hashPath(path, h); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fileHashes holds the sha1sum used in the index, and the sha256sum used to
+// find identical files in the pool
+type fileHashes struct {
+	sha1   string
+	sha256 string
+}
+
+// hashFile will compute both sums for the file from a single read of it
+func hashFile(path string) (*fileHashes, error) {
+	h1 := sha1.New()
+	h256 := sha256.New()
+	if err := hashPath(path, h1, h256); err != nil {
+		return nil, err
+	}
+	return &fileHashes{
+		sha1:   hex.EncodeToString(h1.Sum(nil)),
+		sha256: hex.EncodeToString(h256.Sum(nil)),
+	}, nil
+}
+
+// hashFiles will compute the sums for each of the files, several at once.
+// Files which couldn't be hashed are left nil, for the caller to run into
+// the same problem when it gets to them. setup, if set, is run at the start
+// of each goroutine doing the hashing.
+func hashFiles(setup ThreadSetup, paths []string) []*fileHashes {
+	ret := make([]*fileHashes, len(paths))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < hashWorkers(len(paths)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			setup.run()
+			for i := range indices {
+				ret[i], _ = hashFile(paths[i])
+			}
+		}()
+	}
+	for i := range paths {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+	return ret
+}
@@ -0,0 +1,190 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+)
+
+// writeRandomFile will write size bytes of noise to a new file in dir
+func writeRandomFile(t testing.TB, dir string, size int) string {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(data)
+	f, err := ioutil.TempFile(dir, "hash-")
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return f.Name()
+}
+
+func TestHashFile(t *testing.T) {
+	dir := initTestArea(t)
+	defer SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 0)
+
+	sizes := []int{0, 1, 4096, 3*4096 + 7}
+	options := [][2]int{{4096, 0}, {4096, 1}, {4096, 4}, {1000, 2}, {DefaultHashChunkSize, DefaultHashReadAhead}}
+	for _, size := range sizes {
+		path := writeRandomFile(t, dir, size)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read back file: %v", err)
+		}
+		sum1 := sha1.Sum(data)
+		sum256 := sha256.Sum256(data)
+
+		for _, opt := range options {
+			SetHashOptions(opt[0], opt[1], 0)
+			hashes, err := hashFile(path)
+			if err != nil {
+				t.Fatalf("Failed to hash %d bytes with %v: %v", size, opt, err)
+			}
+			if hashes.sha1 != hex.EncodeToString(sum1[:]) {
+				t.Fatalf("Wrong sha1sum for %d bytes with %v", size, opt)
+			}
+			if hashes.sha256 != hex.EncodeToString(sum256[:]) {
+				t.Fatalf("Wrong sha256sum for %d bytes with %v", size, opt)
+			}
+		}
+	}
+
+	if _, err := FileSha1sum(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("Expected a missing file to fail, got %v", err)
+	}
+}
+
+func TestHashStreamError(t *testing.T) {
+	defer SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 0)
+
+	failure := errors.New("disk on fire")
+	for _, readAhead := range []int{0, 2} {
+		SetHashOptions(16, readAhead, 0)
+		r := io.MultiReader(iotest.HalfReader(io.LimitReader(rand.New(rand.NewSource(1)), 100)), iotest.ErrReader(failure))
+		if err := hashStream(r, sha1.New()); err != failure {
+			t.Fatalf("Expected the read error with a read ahead of %d, got %v", readAhead, err)
+		}
+	}
+}
+
+func TestHashFiles(t *testing.T) {
+	dir := initTestArea(t)
+	defer SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 0)
+	SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 2)
+
+	var paths []string
+	for i := 1; i <= 5; i++ {
+		paths = append(paths, writeRandomFile(t, dir, i*1000))
+	}
+	paths = append(paths, filepath.Join(dir, "missing"))
+
+	var setups int32
+	hashes := hashFiles(func() { atomic.AddInt32(&setups, 1) }, paths)
+	if setups != 2 {
+		t.Fatalf("Expected each of 2 workers to run the setup, ran %d times", setups)
+	}
+	for i, path := range paths[:5] {
+		want, err := FileSha256sum(path)
+		if err != nil {
+			t.Fatalf("Failed to hash %s: %v", path, err)
+		}
+		if hashes[i] == nil || hashes[i].sha256 != want {
+			t.Fatalf("Wrong hashes for %s", path)
+		}
+	}
+	if hashes[5] != nil {
+		t.Fatalf("Expected no hashes for a missing file")
+	}
+}
+
+// benchFileSize is large enough that the read ahead matters
+const benchFileSize = 64 * 1024 * 1024
+
+// BenchmarkHashFile compares hashing a whole mapping of the file, as was
+// done before, with streaming it
+func BenchmarkHashFile(b *testing.B) {
+	dir := initTestArea(b)
+	defer SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 0)
+	path := writeRandomFile(b, dir, benchFileSize)
+
+	b.Run("mmap", func(b *testing.B) {
+		b.SetBytes(benchFileSize)
+		for i := 0; i < b.N; i++ {
+			mfile, err := MapFile(path)
+			if err != nil {
+				b.Fatalf("Failed to map file: %v", err)
+			}
+			h1 := sha1.New()
+			h256 := sha256.New()
+			h1.Write(mfile.Data)
+			h256.Write(mfile.Data)
+			mfile.Close()
+		}
+	})
+	for _, readAhead := range []int{0, DefaultHashReadAhead} {
+		readAhead := readAhead
+		b.Run("readahead-"+strconv.Itoa(readAhead), func(b *testing.B) {
+			SetHashOptions(DefaultHashChunkSize, readAhead, 0)
+			b.SetBytes(benchFileSize)
+			for i := 0; i < b.N; i++ {
+				if _, err := hashFile(path); err != nil {
+					b.Fatalf("Failed to hash file: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHashFiles compares hashing an import's packages one at a time
+// with hashing them in parallel
+func BenchmarkHashFiles(b *testing.B) {
+	dir := initTestArea(b)
+	defer SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, 0)
+	var paths []string
+	for i := 0; i < 8; i++ {
+		paths = append(paths, writeRandomFile(b, dir, benchFileSize/8+i))
+	}
+
+	for _, parallel := range []int{1, 0} {
+		name := "serial"
+		if parallel == 0 {
+			name = "parallel"
+		}
+		parallel := parallel
+		b.Run(name, func(b *testing.B) {
+			SetHashOptions(DefaultHashChunkSize, DefaultHashReadAhead, parallel)
+			b.SetBytes(benchFileSize)
+			for i := 0; i < b.N; i++ {
+				hashFiles(nil, paths)
+			}
+		})
+	}
+}
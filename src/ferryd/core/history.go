@@ -0,0 +1,191 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libdb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DatabaseBucketHistory is the append-only log of repository changes
+	DatabaseBucketHistory = "history"
+
+	// HistorySchemaVersion is the current version of a HistoryEntry
+	HistorySchemaVersion = "1.0"
+)
+
+// A HistoryPackage identifies a package added to or removed from a repository
+type HistoryPackage struct {
+	Name string // Base package name
+	ID   string // eopkg ID
+}
+
+// A HistoryEntry records a single operation which modified a repository
+type HistoryEntry struct {
+	SchemaVersion string
+	Time          time.Time // When the operation began
+	Repo          string
+	JobID         string   // Correlation ID of the job which made the change
+	Operation     string   // i.e. "RemoveSource"
+	Description   string   // Human readable description of the job
+	Params        []string // Parameters of the job
+	Added         []HistoryPackage
+	Removed       []HistoryPackage
+	Error         string // Set if the operation failed part way
+}
+
+// Touches determines whether the named package was added or removed
+func (h *HistoryEntry) Touches(name string) bool {
+	for _, pkgs := range [][]HistoryPackage{h.Added, h.Removed} {
+		for _, p := range pkgs {
+			if p.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The History records every change made to the packages within each
+// repository. Entries are never removed, even when the repository is deleted.
+type History struct {
+	mut *sync.Mutex
+}
+
+// Init will prepare the history for use
+func (h *History) Init(ctx *Context, db libdb.Database) error {
+	h.mut = &sync.Mutex{}
+	return nil
+}
+
+// Close doesn't currently do anything
+func (h *History) Close() {}
+
+// packageState returns a mapping of every package ID in the repository to
+// its name. Missing repositories are empty.
+func (h *History) packageState(db libdb.Database, repos *RepositoryManager, repoID string) (map[string]string, error) {
+	ret := make(map[string]string)
+	repo, err := repos.GetRepo(db, repoID)
+	if err != nil {
+		return ret, nil
+	}
+	entries, err := repo.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		for _, id := range entry.Available {
+			ret[id] = entry.Name
+		}
+	}
+	return ret, nil
+}
+
+// changes returns the packages only present in b
+func changes(a, b map[string]string) []HistoryPackage {
+	var ret []HistoryPackage
+	for id, name := range b {
+		if _, ok := a[id]; !ok {
+			ret = append(ret, HistoryPackage{Name: name, ID: id})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// Record will run the operation, and then add an entry to the history of
+// each repository describing the packages which were added or removed.
+//
+// Entries are recorded even if the operation fails, as it may have already
+// made some changes. The error from the operation is returned.
+func (h *History) Record(db libdb.Database, repos *RepositoryManager, entry *HistoryEntry, repoIDs []string, op func() error) error {
+	before := make(map[string]map[string]string)
+	for _, id := range repoIDs {
+		state, err := h.packageState(db, repos, id)
+		if err != nil {
+			return err
+		}
+		before[id] = state
+	}
+
+	entry.SchemaVersion = HistorySchemaVersion
+	entry.Time = time.Now().UTC()
+
+	opErr := op()
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	bucket := db.Bucket([]byte(DatabaseBucketHistory))
+	for _, id := range repoIDs {
+		after, err := h.packageState(db, repos, id)
+		if err != nil {
+			return err
+		}
+		repoEntry := *entry
+		repoEntry.Repo = id
+		repoEntry.Added = changes(before[id], after)
+		repoEntry.Removed = changes(after, before[id])
+		if err := bucket.PutObject(bucket.NextSequence(), &repoEntry); err != nil {
+			return err
+		}
+	}
+	return opErr
+}
+
+// GetEntries will return the history of the repository, newest first. If
+// pkgName is set, only entries which added or removed that package are
+// returned. A limit of 0 returns every matching entry.
+func (h *History) GetEntries(db libdb.Database, repoID, pkgName string, limit int) ([]*HistoryEntry, error) {
+	var ret []*HistoryEntry
+	err := db.Bucket([]byte(DatabaseBucketHistory)).View(func(db libdb.ReadOnlyView) error {
+		return db.ForEach(func(k, v []byte) error {
+			entry := &HistoryEntry{}
+			if err := db.Decode(v, entry); err != nil {
+				return err
+			}
+			if entry.Repo != repoID {
+				return nil
+			}
+			if pkgName != "" && !entry.Touches(pkgName) {
+				return nil
+			}
+			ret = append(ret, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Stored oldest first
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
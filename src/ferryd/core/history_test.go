@@ -0,0 +1,85 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"testing"
+)
+
+func TestHistory(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	repos := []string{"unstable"}
+
+	err = manager.RecordHistory(&HistoryEntry{Operation: "CreateRepo"}, repos, func() error {
+		return manager.CreateRepo("unstable")
+	})
+	if err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	err = manager.RecordHistory(&HistoryEntry{Operation: "BulkAdd"}, repos, func() error {
+		return manager.AddPackages("unstable", []string{searchTestPackage}, false, nil)
+	})
+	if err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	err = manager.RecordHistory(&HistoryEntry{Operation: "RemoveSource"}, repos, func() error {
+		return manager.RemoveSource("unstable", "nano", -1)
+	})
+	if err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	// Failures are still recorded
+	err = manager.RecordHistory(&HistoryEntry{Operation: "RemoveSource"}, []string{"shannon"}, func() error {
+		return manager.RemoveSource("shannon", "nano", -1)
+	})
+	if err == nil {
+		t.Fatalf("Should not be able to remove from a missing repo")
+	}
+
+	entries, err := manager.GetHistory("unstable", "", 0)
+	if err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 history entries, got %d", len(entries))
+	}
+	if entries[0].Operation != "RemoveSource" || len(entries[0].Removed) != 1 {
+		t.Fatalf("Expected newest entry to remove nano, got %v", entries[0])
+	}
+	if entries[1].Operation != "BulkAdd" || len(entries[1].Added) != 1 {
+		t.Fatalf("Expected second entry to add nano, got %v", entries[1])
+	}
+
+	if entries, err = manager.GetHistory("unstable", "nano", 1); err != nil {
+		t.Fatalf("Failed to get package history: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Removed[0].Name != "nano" {
+		t.Fatalf("Expected the removal of nano, got %v", entries)
+	}
+
+	if entries, err = manager.GetHistory("shannon", "", 0); err != nil {
+		t.Fatalf("Failed to get history: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Error == "" {
+		t.Fatalf("Expected a failed entry for shannon, got %v", entries)
+	}
+}
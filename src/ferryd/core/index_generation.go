@@ -0,0 +1,152 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	// IndexPathComponent is the directory within a repository tree holding
+	// each generation of its versioned indexes
+	IndexPathComponent = "index"
+
+	// indexCurrentLink points at the published generation within the
+	// IndexPathComponent directory
+	indexCurrentLink = "current"
+
+	// keepIndexGenerations is how many generations are kept, including the
+	// current one, so that clients part way through fetching an older set
+	// of artifacts can finish
+	keepIndexGenerations = 3
+)
+
+// indexDir returns the directory holding the versioned index generations
+func (r *Repository) indexDir() string {
+	return filepath.Join(r.path, IndexPathComponent)
+}
+
+// indexGenerations will return the generations within the index directory,
+// oldest first
+func (r *Repository) indexGenerations() ([]int, error) {
+	files, err := ioutil.ReadDir(r.indexDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ret []int
+	for _, fi := range files {
+		if !fi.IsDir() {
+			continue
+		}
+		if gen, err := strconv.Atoi(fi.Name()); err == nil {
+			ret = append(ret, gen)
+		}
+	}
+	sort.Ints(ret)
+	return ret, nil
+}
+
+// newIndexGeneration will create the directory for the next generation of
+// the index, returning its name
+func (r *Repository) newIndexGeneration() (string, error) {
+	gens, err := r.indexGenerations()
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(gens) > 0 {
+		next = gens[len(gens)-1] + 1
+	}
+	name := strconv.Itoa(next)
+	if err := os.MkdirAll(filepath.Join(r.indexDir(), name), 00755); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// replaceSymlink will atomically point link at target, replacing whatever
+// was there before
+func replaceSymlink(target, link string) error {
+	tmp := link + ".new"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// publishIndexGeneration will make the generation current by flipping the
+// one symlink every artifact is reached through, so that clients only ever
+// see a complete set. Each of the named artifacts is then linked into the
+// top of the tree, which is only needed the first time an artifact appears.
+func (r *Repository) publishIndexGeneration(generation string, names []string) error {
+	if err := replaceSymlink(generation, filepath.Join(r.indexDir(), indexCurrentLink)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		target := filepath.Join(IndexPathComponent, indexCurrentLink, name)
+		link := filepath.Join(r.path, name)
+		if dest, err := os.Readlink(link); err == nil && dest == target {
+			continue
+		}
+		if err := replaceSymlink(target, link); err != nil {
+			return err
+		}
+	}
+	return r.pruneIndexGenerations(generation)
+}
+
+// pruneIndexGenerations will remove every generation other than the current
+// one and those just before it
+func (r *Repository) pruneIndexGenerations(current string) error {
+	cur, err := strconv.Atoi(current)
+	if err != nil {
+		return err
+	}
+	gens, err := r.indexGenerations()
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		if gen <= cur && gen > cur-keepIndexGenerations {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(r.indexDir(), strconv.Itoa(gen))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIndexGenerations will remove the versioned indexes once the
+// repository is indexed in place again
+func (r *Repository) removeIndexGenerations() error {
+	return os.RemoveAll(r.indexDir())
+}
@@ -0,0 +1,117 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libdb"
+	"time"
+)
+
+const (
+	// DatabaseBucketReport is the root bucket for the index reports
+	DatabaseBucketReport = "indexReport"
+
+	// ReportSchemaVersion is the current version of an IndexReport
+	ReportSchemaVersion = "1.0"
+)
+
+// Kinds of problem that may be found while indexing
+const (
+	// FindingAbandonedObsolete is a package hidden from the index because
+	// it is obsolete, but still present in the repository
+	FindingAbandonedObsolete = "abandoned-obsolete"
+
+	// FindingObsoleteDependency is a package which can't be installed as
+	// it depends on an obsolete package
+	FindingObsoleteDependency = "obsolete-dependency"
+
+	// FindingDuplicateRelease is a package with more than one build of the
+	// published release number
+	FindingDuplicateRelease = "duplicate-release"
+)
+
+// An IndexFinding is a single problem found while indexing
+type IndexFinding struct {
+	Kind    string
+	Package string // ID of the offending package
+	Detail  string // i.e. the obsolete dependency
+}
+
+// An IndexReport collects every problem found during the last successful
+// index of a repository, so that they may be fixed.
+type IndexReport struct {
+	SchemaVersion string
+	Repo          string
+	Time          time.Time
+	Packages      int // How many packages were emitted
+	Findings      []IndexFinding
+}
+
+// add will record a new finding
+func (i *IndexReport) add(kind, pkg, detail string) {
+	i.Findings = append(i.Findings, IndexFinding{
+		Kind:    kind,
+		Package: pkg,
+		Detail:  detail,
+	})
+}
+
+// putIndexReport will store the report, replacing the previous one
+func putIndexReport(db libdb.Database, report *IndexReport) error {
+	report.SchemaVersion = ReportSchemaVersion
+	return db.Bucket([]byte(DatabaseBucketReport)).PutObject([]byte(report.Repo), report)
+}
+
+// GetIndexReport will return the report from the last index of the repository
+func GetIndexReport(db libdb.Database, repoID string) (*IndexReport, error) {
+	report := &IndexReport{}
+	if err := db.Bucket([]byte(DatabaseBucketReport)).GetObject([]byte(repoID), report); err != nil {
+		return nil, fmt.Errorf("Repository '%s' has not been indexed", repoID)
+	}
+	return report, nil
+}
+
+// removeIndexReport will forget the report for the repository
+func removeIndexReport(db libdb.Database, repoID string) error {
+	bucket := db.Bucket([]byte(DatabaseBucketReport))
+	if has, err := bucket.HasObject([]byte(repoID)); err != nil || !has {
+		return err
+	}
+	return bucket.DeleteObject([]byte(repoID))
+}
+
+// findDuplicateReleases will report any other available builds sharing the
+// release number of the published package
+func findDuplicateReleases(db libdb.Database, pool *Pool, entry *RepoEntry, published *PoolEntry, report *IndexReport) error {
+	if len(entry.Available) < 2 {
+		return nil
+	}
+	for _, id := range entry.Available {
+		if id == entry.Published {
+			continue
+		}
+		other, err := pool.GetEntry(db, id)
+		if err != nil {
+			return err
+		}
+		if other.Meta.GetRelease() == published.Meta.GetRelease() {
+			report.add(FindingDuplicateRelease, entry.Published, fmt.Sprintf("release %d also provided by %s", published.Meta.GetRelease(), id))
+		}
+	}
+	return nil
+}
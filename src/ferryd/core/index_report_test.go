@@ -0,0 +1,98 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const reportTestDistribution = `<PISI>
+	<SourceName>Solus</SourceName>
+	<Version>1</Version>
+	<BinaryName>Solus</BinaryName>
+	<Obsoletes>
+		<Package>ncurses</Package>
+	</Obsoletes>
+</PISI>`
+
+func TestIndexReport(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index repo: %v", err)
+	}
+	report, err := manager.GetIndexReport("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get report: %v", err)
+	}
+	if report.Packages != 0 || len(report.Findings) != 0 {
+		t.Fatalf("Empty repository has wrong report: %v", report)
+	}
+
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	report, err = manager.GetIndexReport("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get report: %v", err)
+	}
+	if report.Packages != 1 || len(report.Findings) != 0 {
+		t.Fatalf("Clean repository has wrong report: %v", report)
+	}
+
+	// Depend on an obsolete package, and duplicate the release number
+	if err := manager.SetAsset("unstable", "distribution.xml", []byte(reportTestDistribution)); err != nil {
+		t.Fatalf("Failed to set distribution: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{writeConflictingPackage(t, dir)}, false, nil); err != nil {
+		t.Fatalf("Failed to add conflicting package: %v", err)
+	}
+
+	report, err = manager.GetIndexReport("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get report: %v", err)
+	}
+	kinds := make(map[string]bool)
+	for _, f := range report.Findings {
+		kinds[f.Kind] = true
+	}
+	if len(report.Findings) != 2 || !kinds[FindingObsoleteDependency] || !kinds[FindingDuplicateRelease] {
+		t.Fatalf("Expected obsolete dependency and duplicate release, got: %v", report.Findings)
+	}
+
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if _, err := GetIndexReport(manager.db, "unstable"); err == nil {
+		t.Fatalf("Report of deleted repository was kept")
+	}
+}
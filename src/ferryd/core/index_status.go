@@ -0,0 +1,93 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"time"
+)
+
+// IndexStatus records how the last index of a repository went
+type IndexStatus struct {
+	Time     time.Time     // When the index started, zero if never indexed
+	Duration time.Duration // How long it took
+	Error    string        // Why it failed, empty if it succeeded
+}
+
+// Succeeded will return true if the index was written and published
+func (i *IndexStatus) Succeeded() bool {
+	return !i.Time.IsZero() && i.Error == ""
+}
+
+// updateStatus will apply the change to the index status kept in the stored
+// repository record. The status is only ever read back from the record, as
+// with GetRepos, so unlike updateRepo the cached repository stays valid.
+func (r *RepositoryManager) updateStatus(db libdb.Database, id string, change func(repo *Repository)) error {
+	r.repoLock.Lock()
+	defer r.repoLock.Unlock()
+
+	var stored Repository
+	rootBucket := db.Bucket([]byte(DatabaseBucketRepo))
+	if err := rootBucket.GetObject([]byte(id), &stored); err != nil {
+		return notFoundf("The specified repository '%s' does not exist", id)
+	}
+	change(&stored)
+	return rootBucket.PutObject([]byte(id), &stored)
+}
+
+// MarkDirty will flag the repository as having changed since its last
+// successful index, until it is indexed again
+func (r *RepositoryManager) MarkDirty(db libdb.Database, id string) error {
+	return r.updateStatus(db, id, func(repo *Repository) {
+		repo.Dirty = true
+	})
+}
+
+// RecordIndex will store the outcome of an index of the repository, which
+// only clears the dirty flag if it succeeded
+func (r *RepositoryManager) RecordIndex(db libdb.Database, id string, status IndexStatus) error {
+	return r.updateStatus(db, id, func(repo *Repository) {
+		repo.LastIndex = status
+		if status.Succeeded() {
+			repo.Dirty = false
+		}
+	})
+}
+
+// markDirty will flag the repository as changed before its packages are
+// touched, so that a failed or missing index is noticed
+func (m *Manager) markDirty(repoID string) error {
+	return m.repo.MarkDirty(m.db, repoID)
+}
+
+// recordIndex will store the outcome of the index which started at started
+func (m *Manager) recordIndex(repoID string, started time.Time, err error) {
+	status := IndexStatus{
+		Time:     started.UTC(),
+		Duration: time.Since(started),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	if err := m.repo.RecordIndex(m.db, repoID, status); err != nil {
+		m.logger.WithFields(log.Fields{
+			"repo":  repoID,
+			"error": err,
+		}).Error("Failed to record index status")
+	}
+}
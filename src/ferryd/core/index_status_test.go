@@ -0,0 +1,101 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestIndexStatus(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	stored := func() *Repository {
+		repos, err := manager.GetRepos()
+		if err != nil {
+			t.Fatalf("Failed to get repos: %v", err)
+		}
+		if len(repos) != 1 {
+			t.Fatalf("Expected one repo, got: %v", repos)
+		}
+		return repos[0]
+	}
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if repo := stored(); repo.Dirty || !repo.LastIndex.Succeeded() {
+		t.Fatalf("New repository has wrong index status: %v", repo.LastIndex)
+	}
+
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	repo := stored()
+	if repo.Dirty || !repo.LastIndex.Succeeded() || repo.LastIndex.Duration <= 0 {
+		t.Fatalf("Wrong index status after adding package: %v", repo.LastIndex)
+	}
+	previous := repo.LastIndex.Time
+
+	// Deltas are added ahead of the index
+	if err := manager.markDirty("unstable"); err != nil {
+		t.Fatalf("Failed to mark repo dirty: %v", err)
+	}
+	if repo = stored(); !repo.Dirty {
+		t.Fatalf("Repository wasn't marked dirty")
+	}
+
+	// Stop the index being written, which must leave the repo dirty
+	cached, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if err := os.RemoveAll(cached.path); err != nil {
+		t.Fatalf("Failed to remove repo tree: %v", err)
+	}
+	if err := ioutil.WriteFile(cached.path, nil, 00644); err != nil {
+		t.Fatalf("Failed to block repo tree: %v", err)
+	}
+	if err := manager.Index("unstable"); err == nil {
+		t.Fatalf("Index should have failed")
+	}
+	repo = stored()
+	if !repo.Dirty || repo.LastIndex.Succeeded() || repo.LastIndex.Error == "" {
+		t.Fatalf("Failed index has wrong status: %v", repo.LastIndex)
+	}
+	if repo.LastIndex.Time.Before(previous) {
+		t.Fatalf("Index time wasn't updated")
+	}
+
+	if err := os.Remove(cached.path); err != nil {
+		t.Fatalf("Failed to unblock repo tree: %v", err)
+	}
+	if err := os.MkdirAll(cached.path, 00755); err != nil {
+		t.Fatalf("Failed to restore repo tree: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index repo: %v", err)
+	}
+	if repo = stored(); repo.Dirty || !repo.LastIndex.Succeeded() {
+		t.Fatalf("Successful index has wrong status: %v", repo.LastIndex)
+	}
+}
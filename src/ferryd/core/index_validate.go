@@ -0,0 +1,119 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxReportedProblems limits how many problems are included in the error
+// message, as a broken tree could otherwise produce a huge one
+const maxReportedProblems = 5
+
+// An IndexValidationError lists every inconsistency found between an index
+// and the files it describes
+type IndexValidationError struct {
+	Repo     string
+	Problems []string
+}
+
+// Error will summarise the problems found
+func (i *IndexValidationError) Error() string {
+	problems := i.Problems
+	if len(problems) > maxReportedProblems {
+		problems = problems[:maxReportedProblems]
+	}
+	return fmt.Sprintf("Index of '%s' is inconsistent (%d problems): %s", i.Repo, len(i.Problems), strings.Join(problems, "; "))
+}
+
+// validateFile will check that the file referenced by the index matches
+// the recorded size and hash
+func (r *Repository) validateFile(uri string, size int64, hash string) string {
+	if uri == "" {
+		return "package without PackageURI"
+	}
+	path := filepath.Join(r.path, uri)
+	st, err := os.Stat(path)
+	if err != nil {
+		return fmt.Sprintf("%s: missing from disk", uri)
+	}
+	if st.Size() != size {
+		return fmt.Sprintf("%s: size is %d, index says %d", uri, st.Size(), size)
+	}
+	sha, err := FileSha1sum(path)
+	if err != nil {
+		return fmt.Sprintf("%s: %v", uri, err)
+	}
+	if sha != hash {
+		return fmt.Sprintf("%s: hash is %s, index says %s", uri, sha, hash)
+	}
+	return ""
+}
+
+// validateIndexFile will parse the index back in and ensure that every
+// package and delta it references exists with the correct size and hash.
+func (r *Repository) validateIndexFile(indexPath string) error {
+	index, err := libeopkg.NewIndex(indexPath)
+	if err != nil {
+		return fmt.Errorf("Failed to parse index of '%s': %v", r.ID, err)
+	}
+
+	verr := &IndexValidationError{Repo: r.ID}
+	names := make(map[string]bool)
+
+	for i := range index.Packages {
+		pkg := &index.Packages[i]
+		if names[pkg.Name] {
+			verr.Problems = append(verr.Problems, fmt.Sprintf("%s: listed more than once", pkg.Name))
+		}
+		names[pkg.Name] = true
+
+		if problem := r.validateFile(pkg.PackageURI, pkg.PackageSize, pkg.PackageHash); problem != "" {
+			verr.Problems = append(verr.Problems, problem)
+		}
+		if pkg.DeltaPackages == nil {
+			continue
+		}
+		for _, delta := range *pkg.DeltaPackages {
+			if problem := r.validateFile(delta.PackageURI, delta.PackageSize, delta.PackageHash); problem != "" {
+				verr.Problems = append(verr.Problems, problem)
+			}
+		}
+	}
+
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// ValidateIndex will check the currently published index of the repository
+// against the files on disk
+func (r *Repository) ValidateIndex() error {
+	r.indexMut.Lock()
+	defer r.indexMut.Unlock()
+
+	indexPath := filepath.Join(r.path, "eopkg-index.xml")
+	if !PathExists(indexPath) {
+		return fmt.Errorf("Repository '%s' has not been indexed", r.ID)
+	}
+	return r.validateIndexFile(indexPath)
+}
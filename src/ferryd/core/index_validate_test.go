@@ -0,0 +1,83 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateIndex(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetVerifyIndex("unstable", true); err != nil {
+		t.Fatalf("Failed to enable index verification: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add and index package: %v", err)
+	}
+	if err := manager.ValidateIndex("unstable"); err != nil {
+		t.Fatalf("Valid index failed validation: %v", err)
+	}
+
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	indexPath := filepath.Join(repo.path, "eopkg-index.xml")
+	st, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatalf("Index was not published: %v", err)
+	}
+
+	// Pull the package out from under the index
+	pkgID := filepath.Base(searchTestPackage)
+	meta, err := manager.GetPoolEntry(pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if err := os.Remove(filepath.Join(repo.path, meta.PackageURI)); err != nil {
+		t.Fatalf("Failed to remove package: %v", err)
+	}
+
+	if _, ok := manager.ValidateIndex("unstable").(*IndexValidationError); !ok {
+		t.Fatalf("Published index should now be inconsistent")
+	}
+	if _, ok := manager.Index("unstable").(*IndexValidationError); !ok {
+		t.Fatalf("Inconsistent index should not be published")
+	}
+
+	// The old index must be left alone
+	st2, err := os.Stat(indexPath)
+	if err != nil {
+		t.Fatalf("Published index went missing: %v", err)
+	}
+	if !st2.ModTime().Equal(st.ModTime()) {
+		t.Fatalf("Inconsistent index replaced the published one")
+	}
+	if PathExists(indexPath + ".new") {
+		t.Fatalf("Inconsistent index was not cleaned up")
+	}
+}
@@ -0,0 +1,112 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bufio"
+	"os"
+	"sync"
+	"syscall"
+)
+
+const (
+	// DefaultIndexBufferSize is how much of an index is built up in memory
+	// before it is written out, so that large indexes aren't written in
+	// thousands of tiny pieces
+	DefaultIndexBufferSize = 1024 * 1024
+
+	// minIndexBufferSize is the smallest buffer the XML encoder will use
+	// as is, rather than wrapping it in another
+	minIndexBufferSize = 4096
+
+	// fallocKeepSize allocates the blocks without changing the file size
+	fallocKeepSize = 0x01
+)
+
+// indexWriteOptions control how index files are written, shared by every
+// repository
+var indexWriteOptions = struct {
+	sync.RWMutex
+	bufferSize  int
+	preallocate bool
+}{
+	bufferSize:  DefaultIndexBufferSize,
+	preallocate: true,
+}
+
+// indexBuffers holds the write buffers of finished indexes for reuse
+var indexBuffers sync.Pool
+
+// SetIndexWriteOptions will set how much of an index is buffered before it
+// is written, with 0 using the default, and whether the space for each index
+// is allocated up front from the size of the one it replaces
+func SetIndexWriteOptions(bufferSize int, preallocate bool) {
+	if bufferSize == 0 {
+		bufferSize = DefaultIndexBufferSize
+	}
+	if bufferSize < minIndexBufferSize {
+		bufferSize = minIndexBufferSize
+	}
+	indexWriteOptions.Lock()
+	defer indexWriteOptions.Unlock()
+	indexWriteOptions.bufferSize = bufferSize
+	indexWriteOptions.preallocate = preallocate
+}
+
+// An indexWriter buffers an index on its way to the file
+type indexWriter struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// createIndexFile will create the file at path for writing an index into.
+// When preallocating, sizeHint is the expected size, i.e. that of the
+// index being replaced.
+func createIndexFile(path string, sizeHint int64) (*indexWriter, error) {
+	indexWriteOptions.RLock()
+	bufferSize, preallocate := indexWriteOptions.bufferSize, indexWriteOptions.preallocate
+	indexWriteOptions.RUnlock()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if preallocate && sizeHint > 0 {
+		// Only a hint to keep the index contiguous, not every filesystem can
+		syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, sizeHint)
+	}
+
+	buf, _ := indexBuffers.Get().(*bufio.Writer)
+	if buf == nil || buf.Size() != bufferSize {
+		buf = bufio.NewWriterSize(f, bufferSize)
+	} else {
+		buf.Reset(f)
+	}
+	return &indexWriter{Writer: buf, file: f}, nil
+}
+
+// Close will write out anything still buffered and close the file, handing
+// the buffer back for the next index
+func (w *indexWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.Reset(nil)
+	indexBuffers.Put(w.Writer)
+	return err
+}
@@ -0,0 +1,133 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexWriter(t *testing.T) {
+	dir := initTestArea(t)
+	defer SetIndexWriteOptions(DefaultIndexBufferSize, true)
+	path := filepath.Join(dir, "eopkg-index.xml")
+
+	for i, bufferSize := range []int{0, 1, 8192} {
+		SetIndexWriteOptions(bufferSize, true)
+		content := bytes.Repeat([]byte(fmt.Sprintf("<Package>%d</Package>\n", i)), 5000)
+
+		// The hint is larger than what's written, which mustn't leave a tail
+		w, err := createIndexFile(path, int64(len(content)*2))
+		if err != nil {
+			t.Fatalf("Failed to create index: %v", err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatalf("Failed to write index: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Failed to close index: %v", err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read index: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("Index with buffer size %d has %d bytes, expected %d", bufferSize, len(got), len(content))
+		}
+	}
+}
+
+// benchIndexPackage stands in for the metadata of a package in an index
+type benchIndexPackage struct {
+	Name        string
+	Summary     string
+	Description string
+	Version     string
+	Release     int
+	Dependency  []string
+}
+
+// benchIndexPackages is roughly the size of a large repository
+const benchIndexPackages = 20000
+
+// BenchmarkIndexWrite compares encoding an index straight into the file, as
+// was done before, with encoding it through the index writer
+func BenchmarkIndexWrite(b *testing.B) {
+	dir := initTestArea(b)
+	defer SetIndexWriteOptions(DefaultIndexBufferSize, true)
+	path := filepath.Join(dir, "eopkg-index.xml")
+
+	pkgs := make([]benchIndexPackage, benchIndexPackages)
+	for i := range pkgs {
+		pkgs[i] = benchIndexPackage{
+			Name:        fmt.Sprintf("package-%d", i),
+			Summary:     "A package which exists to fill an index",
+			Description: "Nothing more than a long enough description for the package, as most have",
+			Version:     "1.0.0",
+			Release:     i,
+			Dependency:  []string{"glibc", "zlib", "libgcc"},
+		}
+	}
+	elem := xml.StartElement{Name: xml.Name{Local: "Package"}}
+	encode := func(b *testing.B, encoder *xml.Encoder) {
+		encoder.Indent("    ", "    ")
+		for i := range pkgs {
+			if err := encoder.EncodeElement(&pkgs[i], elem); err != nil {
+				b.Fatalf("Failed to encode package: %v", err)
+			}
+		}
+		if err := encoder.Flush(); err != nil {
+			b.Fatalf("Failed to flush index: %v", err)
+		}
+	}
+
+	b.Run("unbuffered", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			f, err := os.Create(path)
+			if err != nil {
+				b.Fatalf("Failed to create index: %v", err)
+			}
+			encode(b, xml.NewEncoder(f))
+			f.Close()
+		}
+	})
+	for _, preallocate := range []bool{false, true} {
+		preallocate := preallocate
+		b.Run(fmt.Sprintf("buffered-preallocate-%v", preallocate), func(b *testing.B) {
+			SetIndexWriteOptions(DefaultIndexBufferSize, preallocate)
+			for i := 0; i < b.N; i++ {
+				var sizeHint int64
+				if st, err := os.Stat(path); err == nil {
+					sizeHint = st.Size()
+				}
+				w, err := createIndexFile(path, sizeHint)
+				if err != nil {
+					b.Fatalf("Failed to create index: %v", err)
+				}
+				encode(b, xml.NewEncoder(w.Writer))
+				if err := w.Close(); err != nil {
+					b.Fatalf("Failed to close index: %v", err)
+				}
+			}
+		})
+	}
+}
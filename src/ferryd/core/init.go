@@ -21,6 +21,7 @@
 package core
 
 import (
+	"fmt"
 	"libdb"
 	"os"
 	"path/filepath"
@@ -47,6 +48,9 @@ type Context struct {
 	BaseDir   string // Base directory of operations
 	DbPath    string // Path to the main database file
 	JobDbPath string // Path to the job database file
+
+	PoolDir   string            // Where the pool is stored
+	RepoRoots map[string]string // Storage roots of repositories kept outside BaseDir
 }
 
 // NewContext will construct a context from the given base directory for
@@ -65,9 +69,31 @@ func NewContext(root string) (*Context, error) {
 		BaseDir:   basedir,
 		DbPath:    filepath.Join(basedir, DatabasePathComponent),
 		JobDbPath: filepath.Join(basedir, JobDbPathComponent),
+		PoolDir:   filepath.Join(basedir, PoolPathComponent),
 	}, nil
 }
 
+// DatabaseURI returns the location of the main database for the backend.
+// Backends other than the default keep their database alongside it,
+// suffixed with the backend name, so that migrations don't collide.
+func (c *Context) DatabaseURI(backend string) string {
+	if backend == libdb.DefaultBackend {
+		return fmt.Sprintf("%s://%s", backend, c.DbPath)
+	}
+	return fmt.Sprintf("%s://%s.%s", backend, c.DbPath, backend)
+}
+
+// DatabaseBackend returns the backend used by the existing main database,
+// or an empty string if it hasn't been created yet
+func (c *Context) DatabaseBackend() string {
+	for _, backend := range libdb.Backends() {
+		if _, path, err := libdb.ParseURI(c.DatabaseURI(backend)); err == nil && PathExists(path) {
+			return backend
+		}
+	}
+	return ""
+}
+
 // A Component of ferryd has special considerations to bootstrap itself
 // during ferryd start, and clean up during ferryd shutdown.
 type Component interface {
@@ -17,39 +17,148 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
 	"libdb"
 	"os"
 	"path/filepath"
 )
 
+// Repository events passed to the RepoEventFunc
+const (
+	// RepoCreated is reported once a repository has been created
+	RepoCreated = "repo.created"
+
+	// RepoDeleted is reported once a repository has been deleted
+	RepoDeleted = "repo.deleted"
+
+	// RepoIndexed is reported whenever a new index has been published
+	RepoIndexed = "index.published"
+)
+
+// RepoEventFunc is called whenever a repository is created, deleted or has a
+// new index published. It is called from the job workers, so mustn't block.
+type RepoEventFunc func(event, repoID string)
+
 // A Manager is the the singleton responsible for slip management
 type Manager struct {
-	db   libdb.Database     // Our main database
-	ctx  *Context           // Context shares all our path assignments
-	pool *Pool              // Our main pool for eopkgs
-	repo *RepositoryManager // Repo management
+	db     libdb.Database     // Our main database
+	ctx    *Context           // Context shares all our path assignments
+	pool   *Pool              // Our main pool for eopkgs
+	repo   *RepositoryManager // Repo management
+	search *SearchIndex       // Package search
+	snaps  *SnapshotManager   // Repository snapshots
+	hist   *History           // Log of repository changes
+	space  *SpaceGuard        // Refuse writes when short of space
+	store  ObjectStore        // Where repository trees are published, if set
+	mirror *Publisher         // Downstream mirrors pushed after each index
+	events RepoEventFunc      // Told about changes to the repositories
+
+	summaries   *summaryCache // Repository summaries until they next change
+	logger      *log.Entry    // Fields attached to our log lines
+	threadSetup ThreadSetup   // Run by goroutines doing work for the caller
 
 	IncomingPath string // Incoming directory
+	readOnly     bool   // Whether the database refuses writes
 }
 
 // NewManager will attempt to instaniate a manager for the given path,
 // which will yield an error if the database cannot be opened for access.
 func NewManager(path string) (*Manager, error) {
+	return newManager(path, "", nil, openReadWrite)
+}
+
+// NewManagerWithBackend will instaniate a manager using the given database
+// backend. New databases are created with it, and an existing database
+// using a different backend must be migrated with MigrateDatabase first.
+func NewManagerWithBackend(path, backend string) (*Manager, error) {
+	return newManager(path, backend, nil, openReadWrite)
+}
+
+// NewManagerWithStorage is NewManagerWithBackend with the pool and repository
+// trees placed in the given storage roots
+func NewManagerWithStorage(path, backend string, roots *StorageRoots) (*Manager, error) {
+	return newManager(path, backend, roots, openReadWrite)
+}
+
+// NewManagerReadOnly will instaniate a manager that cannot modify the
+// database, which is safe to use alongside a running ferryd instance for
+// inspecting the repositories. Any write will fail with libdb.ErrReadOnly.
+func NewManagerReadOnly(path string) (*Manager, error) {
+	return newManager(path, "", nil, openReadOnly)
+}
+
+// NewManagerReadOnlyWithStorage is NewManagerReadOnly for a daemon which
+// must keep serving what it can, i.e. when its database couldn't be
+// recovered, so it uses the same backend and storage roots as ever
+func NewManagerReadOnlyWithStorage(path, backend string, roots *StorageRoots) (*Manager, error) {
+	return newManager(path, backend, roots, openReadOnly)
+}
+
+// NewManagerRecoveredWithStorage is NewManagerReadOnlyWithStorage for a
+// database which is too corrupted to open. A repaired copy is read instead,
+// leaving the database itself as it was for the operator to look at.
+func NewManagerRecoveredWithStorage(path, backend string, roots *StorageRoots) (*Manager, error) {
+	return newManager(path, backend, roots, openRecovered)
+}
+
+// openMode selects how newManager opens the database
+type openMode int
+
+const (
+	openReadWrite openMode = iota
+	openReadOnly
+	openRecovered // Read-only, from a repaired copy of the database
+)
+
+// newManager will open the database in the given mode and set up the manager.
+// If backend is empty, we'll use whichever one the database already has.
+// roots may be nil to keep everything within the base directory.
+func newManager(path, backend string, roots *StorageRoots, mode openMode) (*Manager, error) {
+	readOnly := mode != openReadWrite
 	ctx, err := NewContext(path)
 	if err != nil {
 		return nil, err
 	}
+	if roots != nil {
+		if err = ctx.SetStorageRoots(roots); err != nil {
+			return nil, err
+		}
+	}
+
+	existing := ctx.DatabaseBackend()
+	if backend == "" {
+		backend = existing
+	} else if existing != "" && existing != backend {
+		return nil, fmt.Errorf("database uses the %s backend and must be migrated to %s first", existing, backend)
+	}
+	if backend == "" {
+		backend = libdb.DefaultBackend
+	}
 
 	// Open the database if we can
-	db, err := libdb.Open(ctx.DbPath)
+	var db libdb.Database
+	switch mode {
+	case openReadOnly:
+		db, err = libdb.OpenReadOnly(ctx.DatabaseURI(backend))
+	case openRecovered:
+		db, err = libdb.OpenRecovered(ctx.DatabaseURI(backend))
+	default:
+		db, err = libdb.Open(ctx.DatabaseURI(backend))
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	// Need incoming to monitor uploads
 	incomingPath := filepath.Join(ctx.BaseDir, IncomingPathComponent)
-	if err := os.MkdirAll(incomingPath, 00755); err != nil {
-		return nil, err
+	if !readOnly {
+		if err := os.MkdirAll(incomingPath, 00755); err != nil {
+			db.Close()
+			return nil, err
+		}
 	}
 
 	m := &Manager{
@@ -57,7 +166,15 @@ func NewManager(path string) (*Manager, error) {
 		ctx:          ctx,
 		pool:         &Pool{},
 		repo:         &RepositoryManager{},
+		search:       &SearchIndex{},
+		snaps:        &SnapshotManager{},
+		hist:         &History{},
+		space:        newSpaceGuard(ctx.BaseDir),
+		mirror:       NewPublisher(),
+		summaries:    newSummaryCache(),
+		logger:       log.NewEntry(log.StandardLogger()),
 		IncomingPath: incomingPath,
+		readOnly:     readOnly,
 	}
 
 	// Initialise the buckets in a one-time
@@ -66,6 +183,18 @@ func NewManager(path string) (*Manager, error) {
 		return nil, err
 	}
 
+	// Bring old records up to date before anything reads them
+	if err = m.migrateSchema(); err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	// Older databases won't have a search index yet
+	if err = m.buildSearchIndex(); err != nil {
+		m.Close()
+		return nil, err
+	}
+
 	return m, nil
 }
 
@@ -76,6 +205,9 @@ func (m *Manager) initComponents() error {
 	components := []Component{
 		m.pool,
 		m.repo,
+		m.search,
+		m.snaps,
+		m.hist,
 	}
 
 	// Create all root-level buckets in a single transaction
@@ -89,6 +221,47 @@ func (m *Manager) initComponents() error {
 	})
 }
 
+// buildSearchIndex will populate the search index from every repository if
+// it has never been built before
+func (m *Manager) buildSearchIndex() error {
+	if m.readOnly || m.search.IsBuilt(m.db) {
+		return nil
+	}
+	repos, err := m.repo.GetRepos(m.db)
+	if err != nil {
+		return err
+	}
+	for _, repo := range repos {
+		if err := m.search.IndexRepo(m.db, m.pool, repo); err != nil {
+			return err
+		}
+	}
+	return m.search.MarkBuilt(m.db)
+}
+
+// ReadOnly will return true if this manager cannot modify the database
+func (m *Manager) ReadOnly() bool {
+	return m.readOnly
+}
+
+// WithLogger will return a Manager sharing everything with this one, except
+// that its log lines carry the fields of logger, i.e. to identify the job
+// calling into it. It must not be closed.
+func (m *Manager) WithLogger(logger *log.Entry) *Manager {
+	ret := *m
+	ret.logger = logger
+	return &ret
+}
+
+// WithThreadSetup will return a Manager sharing everything with this one,
+// except that the goroutines it spreads work over, i.e. to hash packages,
+// run setup first to take on the caller's scheduling. It must not be closed.
+func (m *Manager) WithThreadSetup(setup ThreadSetup) *Manager {
+	ret := *m
+	ret.threadSetup = setup
+	return &ret
+}
+
 // Close will close and clean up any associated resources, such as the
 // underlying database.
 func (m *Manager) Close() {
@@ -99,6 +272,9 @@ func (m *Manager) Close() {
 	components := []Component{
 		m.pool,
 		m.repo,
+		m.search,
+		m.snaps,
+		m.hist,
 	}
 	for _, component := range components {
 		component.Close()
@@ -106,3 +282,100 @@ func (m *Manager) Close() {
 	m.db.Close()
 	m.db = nil
 }
+
+// BackupDatabase will write a consistent snapshot of the database, which
+// may be taken while the manager is busy
+func (m *Manager) BackupDatabase(w io.Writer) error {
+	return m.db.Backup(w)
+}
+
+// RestoreDatabase will replace the database for the given base path with a
+// backup written by BackupDatabase. This must be done while ferryd is
+// stopped, so that no cached state survives the restore.
+func RestoreDatabase(path string, r io.Reader) error {
+	ctx, err := NewContext(path)
+	if err != nil {
+		return err
+	}
+	backend := ctx.DatabaseBackend()
+	if backend == "" {
+		backend = libdb.DefaultBackend
+	}
+	db, err := libdb.Open(ctx.DatabaseURI(backend))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Restore(r)
+}
+
+// RecoverDatabase will attempt to repair the corrupted database for the given
+// base path, keeping everything that can still be read. The damaged database
+// is copied aside first, and the path of the copy is returned. This must be
+// done while the database isn't open.
+func RecoverDatabase(path string) (string, error) {
+	ctx, err := NewContext(path)
+	if err != nil {
+		return "", err
+	}
+	backend := ctx.DatabaseBackend()
+	if backend == "" {
+		return "", errors.New("there is no database to recover")
+	}
+	return libdb.Recover(ctx.DatabaseURI(backend))
+}
+
+// MigrateDatabase will copy the database for the given base path into a new
+// backend, which is then used from the next start. The old database is kept
+// aside with a .migrated suffix. This must be done while ferryd is stopped.
+func MigrateDatabase(path, backend string) error {
+	ctx, err := NewContext(path)
+	if err != nil {
+		return err
+	}
+	existing := ctx.DatabaseBackend()
+	if existing == "" {
+		return errors.New("there is no database to migrate")
+	}
+	if existing == backend {
+		return fmt.Errorf("database already uses the %s backend", backend)
+	}
+	_, oldPath, err := libdb.ParseURI(ctx.DatabaseURI(existing))
+	if err != nil {
+		return err
+	}
+	_, newPath, err := libdb.ParseURI(ctx.DatabaseURI(backend))
+	if err != nil {
+		return err
+	}
+	for _, p := range []string{newPath, oldPath + ".migrated"} {
+		if PathExists(p) {
+			return fmt.Errorf("refusing to overwrite %s", p)
+		}
+	}
+
+	src, err := libdb.Open(ctx.DatabaseURI(existing))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := libdb.Open(ctx.DatabaseURI(backend))
+	if err != nil {
+		return err
+	}
+	if err = libdb.Copy(dst, src); err != nil {
+		dst.Close()
+		os.RemoveAll(newPath)
+		return err
+	}
+	dst.Close()
+	src.Close()
+
+	log.WithFields(log.Fields{
+		"from": existing,
+		"to":   backend,
+	}).Info("Migrated database")
+
+	// Move the old one aside so that the new one is picked up
+	return os.Rename(oldPath, oldPath+".migrated")
+}
@@ -17,13 +17,17 @@
 package core
 
 import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"libdb"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
 // initTestArea is a very simple helper to set up a database staging tree
-func initTestArea(t *testing.T) string {
+func initTestArea(t testing.TB) string {
 	dirName := filepath.Join(".", "testenv")
 	if _, err := os.Stat(dirName); err == nil {
 		if err = os.RemoveAll(dirName); err != nil {
@@ -45,3 +49,197 @@ func TestManagerBasic(t *testing.T) {
 	}
 	manager.Close()
 }
+
+// TestVerifyHashes will ensure the verification setting is kept and that
+// valid packages still make it in
+func TestVerifyHashes(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetVerifyHashes("unstable", true); err != nil {
+		t.Fatalf("Failed to enable verification: %v", err)
+	}
+	manager.Close()
+
+	manager, err = NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen manager: %v", err)
+	}
+	defer manager.Close()
+
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if !repo.VerifyHashes {
+		t.Fatalf("Verification setting was not stored")
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add valid package: %v", err)
+	}
+}
+
+// TestManagerWithLogger will ensure log lines written on behalf of a job
+// carry the job's fields
+func TestManagerWithLogger(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+	job := manager.WithLogger(logger.WithField("jobID", "0123456789abcdef"))
+
+	// The repository has no assets, which is warned about while indexing
+	if err := job.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index repo: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "jobID=0123456789abcdef") {
+			t.Fatalf("Log line is missing the job: %s", line)
+		}
+	}
+	if !strings.Contains(buf.String(), "No distribution.xml defined") {
+		t.Fatalf("Expected warnings from the index, got %q", buf.String())
+	}
+}
+
+// TestManagerReadOnly will ensure we can inspect a database held open by
+// another manager, without being able to modify it
+func TestManagerReadOnly(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	reader, err := NewManagerReadOnly(dir)
+	if err != nil {
+		t.Fatalf("Failed to open live database read-only: %v", err)
+	}
+	defer reader.Close()
+	if !reader.ReadOnly() {
+		t.Fatalf("Manager should be read-only")
+	}
+
+	repos, err := reader.GetRepos()
+	if err != nil {
+		t.Fatalf("Failed to list repos: %v", err)
+	}
+	if len(repos) != 1 || repos[0].ID != "unstable" {
+		t.Fatalf("Read-only manager should see the repo: %v", repos)
+	}
+	if err := reader.ValidateIndex("unstable"); err != nil {
+		t.Fatalf("Failed to validate index read-only: %v", err)
+	}
+	if err := reader.CreateRepo("shannon"); err != libdb.ErrReadOnly {
+		t.Fatalf("Read-only manager should refuse writes: %v", err)
+	}
+}
+
+// TestBackupDatabase will ensure a backup restores the database to the
+// point it was taken, and that incomplete backups are refused
+func TestBackupDatabase(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	backup := bytes.Buffer{}
+	if err := manager.BackupDatabase(&backup); err != nil {
+		t.Fatalf("Failed to back up database: %v", err)
+	}
+	if err := manager.CreateRepo("shannon"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	manager.Close()
+
+	truncated := bytes.NewReader(backup.Bytes()[:backup.Len()-1])
+	if err := RestoreDatabase(dir, truncated); err != libdb.ErrTruncatedBackup {
+		t.Fatalf("Truncated backup should be refused: %v", err)
+	}
+	if err := RestoreDatabase(dir, &backup); err != nil {
+		t.Fatalf("Failed to restore database: %v", err)
+	}
+
+	manager, err = NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen manager: %v", err)
+	}
+	defer manager.Close()
+	repos, err := manager.GetRepos()
+	if err != nil {
+		t.Fatalf("Failed to list repos: %v", err)
+	}
+	if len(repos) != 1 || repos[0].ID != "unstable" {
+		t.Fatalf("Restored database should only contain unstable: %v", repos)
+	}
+}
+
+// TestDatabaseBackends will ensure the manager works on the bolt backend,
+// and that a database can be migrated between backends
+func TestDatabaseBackends(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManagerWithBackend(dir, "bolt")
+	if err != nil {
+		t.Fatalf("Failed to initialise bolt manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	// Reading a live bolt database has to work from a copy
+	reader, err := NewManagerReadOnly(dir)
+	if err != nil {
+		t.Fatalf("Failed to open live bolt database read-only: %v", err)
+	}
+	if _, err := reader.GetRepo("unstable"); err != nil {
+		t.Fatalf("Read-only manager should see the repo: %v", err)
+	}
+	reader.Close()
+	manager.Close()
+
+	if err := MigrateDatabase(dir, "leveldb"); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	if _, err := NewManagerWithBackend(dir, "bolt"); err == nil {
+		t.Fatalf("Opening a migrated database with the old backend should fail")
+	}
+
+	manager, err = NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to open migrated database: %v", err)
+	}
+	defer manager.Close()
+	if _, err := manager.GetRepo("unstable"); err != nil {
+		t.Fatalf("Migrated database lost the repo: %v", err)
+	}
+	entries, err := manager.pool.GetSourceEntries(manager.db, "nano")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Migrated database lost the source index: %v %v", entries, err)
+	}
+}
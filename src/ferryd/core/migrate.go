@@ -0,0 +1,402 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DatabaseBucketSchema records the schema version of each kind of record
+	DatabaseBucketSchema = "schema"
+
+	// DatabaseBucketMigration records every migration applied to the database
+	DatabaseBucketMigration = "migration"
+
+	// baseSchemaVersion is assumed for records written before they carried
+	// a SchemaVersion
+	baseSchemaVersion = "1.0"
+)
+
+// ErrSchemaTooNew is returned when the database has been used by a newer
+// version of ferryd, which we cannot safely write to
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of ferryd supports")
+
+// A Migration upgrades one kind of record from a schema version to the next
+type Migration struct {
+	Kind        string // i.e. "pool"
+	From        string // Schema version being upgraded
+	To          string // Schema version produced
+	Description string
+
+	// Upgrade will modify the decoded record in place. The SchemaVersion
+	// is then set to To for us.
+	Upgrade func(record interface{}) error
+}
+
+// migrations lists every known upgrade. Whenever a SchemaVersion is bumped,
+// a migration from the previous version must be added here.
+var migrations = []*Migration{
+	{
+		Kind:        DatabaseBucketSnapshot,
+		From:        "1.0",
+		To:          "1.1",
+		Description: "Prefix snapshot keys with the length of the repository name",
+		Upgrade:     func(record interface{}) error { return nil },
+	},
+}
+
+// An AppliedMigration records a migration having been run on the database
+type AppliedMigration struct {
+	Kind        string
+	From        string
+	To          string
+	Description string
+	Records     int       // How many records were upgraded
+	Time        time.Time // When the migration completed
+}
+
+// A SchemaStatus describes the state of one kind of record
+type SchemaStatus struct {
+	Kind     string
+	Current  string       // Version written by this ferryd
+	Database string       // Version of the records in the database, if known
+	Pending  []*Migration // Migrations needed to reach Current
+}
+
+// schemaVersioned is used to read the version of any record
+type schemaVersioned struct {
+	SchemaVersion string
+}
+
+// schemaRecordFunc is called for every record of a kind, with the bucket
+// holding it
+type schemaRecordFunc func(bucket libdb.Database, id, value []byte) error
+
+// A schemaKind is a type of record carrying a SchemaVersion
+type schemaKind struct {
+	name      string
+	current   string
+	newRecord func() interface{}
+	walk      func(db libdb.Database, f schemaRecordFunc) error
+
+	// key returns where an upgraded record belongs, if that may differ
+	// from where it was found
+	key func(record interface{}) []byte
+}
+
+// walkBucket returns a walk function visiting every record in the bucket
+func walkBucket(path ...string) func(db libdb.Database, f schemaRecordFunc) error {
+	return func(db libdb.Database, f schemaRecordFunc) error {
+		bucket := db
+		for _, p := range path {
+			bucket = bucket.Bucket([]byte(p))
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			return f(bucket, k, v)
+		})
+	}
+}
+
+// walkRepoEntries will visit the package entries of every repository
+func walkRepoEntries(db libdb.Database, f schemaRecordFunc) error {
+	repoBucket := db.Bucket([]byte(DatabaseBucketRepo))
+	var repoIDs []string
+	err := repoBucket.ForEach(func(k, v []byte) error {
+		repoIDs = append(repoIDs, string(k))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range repoIDs {
+		err := walkBucket(DatabaseBucketRepo, id, DatabaseBucketPackage)(db, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaKinds returns every kind of versioned record we store
+func schemaKinds() []*schemaKind {
+	return []*schemaKind{
+		{
+			name:      DatabaseBucketPool,
+			current:   PoolSchemaVersion,
+			newRecord: func() interface{} { return &PoolEntry{} },
+			walk:      walkBucket(DatabaseBucketPool),
+		},
+		{
+			name:      DatabaseBucketDeltaSkip,
+			current:   PoolSchemaVersion,
+			newRecord: func() interface{} { return &DeltaSkipEntry{} },
+			walk:      walkBucket(DatabaseBucketDeltaSkip),
+		},
+		{
+			name:      DatabaseBucketRepo,
+			current:   RepoSchemaVersion,
+			newRecord: func() interface{} { return &RepoEntry{} },
+			walk:      walkRepoEntries,
+		},
+		{
+			name:      DatabaseBucketSearch,
+			current:   SearchSchemaVersion,
+			newRecord: func() interface{} { return &SearchDocument{} },
+			walk:      walkBucket(DatabaseBucketSearch, DatabaseBucketSearchDocument),
+		},
+		{
+			name:      DatabaseBucketSnapshot,
+			current:   SnapshotSchemaVersion,
+			newRecord: func() interface{} { return &Snapshot{} },
+			walk:      walkBucket(DatabaseBucketSnapshot),
+			key: func(record interface{}) []byte {
+				snap := record.(*Snapshot)
+				return snapshotKey(snap.Repo, snap.Name)
+			},
+		},
+		{
+			name:      DatabaseBucketHistory,
+			current:   HistorySchemaVersion,
+			newRecord: func() interface{} { return &HistoryEntry{} },
+			walk:      walkBucket(DatabaseBucketHistory),
+		},
+		{
+			name:      DatabaseBucketConflict,
+			current:   ConflictSchemaVersion,
+			newRecord: func() interface{} { return &ReleaseConflict{} },
+			walk:      walkBucket(DatabaseBucketConflict),
+		},
+		{
+			name:      DatabaseBucketReport,
+			current:   ReportSchemaVersion,
+			newRecord: func() interface{} { return &IndexReport{} },
+			walk:      walkBucket(DatabaseBucketReport),
+		},
+	}
+}
+
+// compareSchemaVersions will return -1, 0 or 1 as a is older, the same as or
+// newer than b. Versions are dotted numbers, i.e. "1.10" is newer than "1.9".
+func compareSchemaVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// findMigration returns the migration upgrading the kind from version
+func findMigration(kind, from string) *Migration {
+	for _, m := range migrations {
+		if m.Kind == kind && m.From == from {
+			return m
+		}
+	}
+	return nil
+}
+
+// pendingMigrations returns the chain of migrations from one version to the
+// next, or an error if there is a gap
+func pendingMigrations(kind, from, to string) ([]*Migration, error) {
+	var ret []*Migration
+	for compareSchemaVersions(from, to) < 0 {
+		m := findMigration(kind, from)
+		if m == nil {
+			return nil, fmt.Errorf("no migration for %s records from schema %s", kind, from)
+		}
+		ret = append(ret, m)
+		from = m.To
+	}
+	return ret, nil
+}
+
+// getSchemaVersion returns the version recorded for the kind, or an empty
+// string if the database predates schema tracking
+func getSchemaVersion(db libdb.Database, kind string) (string, error) {
+	var version string
+	err := db.Bucket([]byte(DatabaseBucketSchema)).GetObject([]byte(kind), &version)
+	if err == libdb.ErrNotFound {
+		return "", nil
+	}
+	return version, err
+}
+
+// migrateSchema will bring every kind of record up to the current schema,
+// refusing to touch a database written by a newer ferryd
+func (m *Manager) migrateSchema() error {
+	for _, kind := range schemaKinds() {
+		if err := m.migrateKind(kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateKind will upgrade every record of the kind within one transaction
+func (m *Manager) migrateKind(kind *schemaKind) error {
+	stored, err := getSchemaVersion(m.db, kind.name)
+	if err != nil {
+		return err
+	}
+	if stored != "" {
+		switch compareSchemaVersions(stored, kind.current) {
+		case 0:
+			return nil
+		case 1:
+			return fmt.Errorf("%v: %s records are at %s, we support %s", ErrSchemaTooNew, kind.name, stored, kind.current)
+		}
+	}
+
+	// Old records remain readable, we just can't upgrade them
+	if m.readOnly {
+		log.WithFields(log.Fields{
+			"kind":    kind.name,
+			"version": stored,
+		}).Warning("Database needs migrating, but is opened read-only")
+		return nil
+	}
+
+	return m.db.Update(func(db libdb.Database) error {
+		counts := make(map[*Migration]int)
+		err := kind.walk(db, func(bucket libdb.Database, id, value []byte) error {
+			var v schemaVersioned
+			if err := bucket.Decode(value, &v); err != nil {
+				return err
+			}
+			version := v.SchemaVersion
+			if version == "" {
+				version = baseSchemaVersion
+			}
+			if compareSchemaVersions(version, kind.current) > 0 {
+				return fmt.Errorf("%v: %s record %s is at %s, we support %s", ErrSchemaTooNew, kind.name, string(id), version, kind.current)
+			}
+			if version == v.SchemaVersion && version == kind.current {
+				return nil
+			}
+
+			chain, err := pendingMigrations(kind.name, version, kind.current)
+			if err != nil {
+				return err
+			}
+			record := kind.newRecord()
+			if err := bucket.Decode(value, record); err != nil {
+				return err
+			}
+			for _, migration := range chain {
+				if err := migration.Upgrade(record); err != nil {
+					return fmt.Errorf("failed to migrate %s record %s: %v", kind.name, string(id), err)
+				}
+				counts[migration]++
+			}
+			reflect.ValueOf(record).Elem().FieldByName("SchemaVersion").SetString(kind.current)
+			key := id
+			if kind.key != nil {
+				key = kind.key(record)
+			}
+			if !bytes.Equal(key, id) {
+				if err := bucket.DeleteObject(id); err != nil {
+					return err
+				}
+			}
+			return bucket.PutObject(key, record)
+		})
+		if err != nil {
+			return err
+		}
+
+		// Keep a record of what we've done
+		migrationBucket := db.Bucket([]byte(DatabaseBucketMigration))
+		for migration, count := range counts {
+			applied := &AppliedMigration{
+				Kind:        migration.Kind,
+				From:        migration.From,
+				To:          migration.To,
+				Description: migration.Description,
+				Records:     count,
+				Time:        time.Now().UTC(),
+			}
+			if err := migrationBucket.PutObject(migrationBucket.NextSequence(), applied); err != nil {
+				return err
+			}
+			log.WithFields(log.Fields{
+				"kind":    migration.Kind,
+				"from":    migration.From,
+				"to":      migration.To,
+				"records": count,
+			}).Info("Migrated records")
+		}
+		return db.Bucket([]byte(DatabaseBucketSchema)).PutObject([]byte(kind.name), kind.current)
+	})
+}
+
+// SchemaStatus will describe the schema of every kind of record, along with
+// the migrations applied to the database so far
+func (m *Manager) SchemaStatus() ([]*SchemaStatus, []*AppliedMigration, error) {
+	var kinds []*SchemaStatus
+	for _, kind := range schemaKinds() {
+		stored, err := getSchemaVersion(m.db, kind.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		status := &SchemaStatus{
+			Kind:     kind.name,
+			Current:  kind.current,
+			Database: stored,
+		}
+		if stored != "" {
+			if status.Pending, err = pendingMigrations(kind.name, stored, kind.current); err != nil {
+				return nil, nil, err
+			}
+		}
+		kinds = append(kinds, status)
+	}
+
+	var applied []*AppliedMigration
+	err := m.db.Bucket([]byte(DatabaseBucketMigration)).ForEach(func(k, v []byte) error {
+		a := &AppliedMigration{}
+		if err := m.db.Decode(v, a); err != nil {
+			return err
+		}
+		applied = append(applied, a)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return kinds, applied, nil
+}
@@ -0,0 +1,136 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setPoolSchema will rewrite the pool entry and forget the pool's schema
+// version, as though an older ferryd had written it
+func setPoolSchema(t *testing.T, path, id, version string) {
+	manager, err := NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	entry, err := manager.pool.GetEntry(manager.db, id)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	entry.SchemaVersion = version
+	if err := manager.pool.putEntry(manager.db, entry); err != nil {
+		t.Fatalf("Failed to put entry: %v", err)
+	}
+	if err := manager.db.Bucket([]byte(DatabaseBucketSchema)).DeleteObject([]byte(DatabaseBucketPool)); err != nil {
+		t.Fatalf("Failed to reset schema: %v", err)
+	}
+}
+
+func TestSchemaMigration(t *testing.T) {
+	path := initTestArea(t)
+	pkgID := filepath.Base(searchTestPackage)
+
+	manager, err := NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	kinds, applied, err := manager.SchemaStatus()
+	if err != nil {
+		t.Fatalf("Failed to get schema status: %v", err)
+	}
+	manager.Close()
+	for _, kind := range kinds {
+		if kind.Database != kind.Current || len(kind.Pending) > 0 {
+			t.Fatalf("New database should be current: %+v", kind)
+		}
+	}
+	if len(applied) != 0 {
+		t.Fatalf("New database should need no migrations: %+v", applied)
+	}
+
+	// Without a migration from the old version, we can't start
+	setPoolSchema(t, path, pkgID, "0.9")
+	if _, err := NewManager(path); err == nil || !strings.Contains(err.Error(), "no migration") {
+		t.Fatalf("Expected missing migration error, got %v", err)
+	}
+
+	defer func(known []*Migration) { migrations = known }(migrations)
+	migrations = []*Migration{
+		{
+			Kind:        DatabaseBucketPool,
+			From:        "0.9",
+			To:          PoolSchemaVersion,
+			Description: "Count references",
+			Upgrade: func(record interface{}) error {
+				record.(*PoolEntry).RefCount += 10
+				return nil
+			},
+		},
+	}
+	manager, err = NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	entry, err := manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if entry.SchemaVersion != PoolSchemaVersion || entry.RefCount != 11 {
+		t.Fatalf("Entry was not migrated: %s with %d refs", entry.SchemaVersion, entry.RefCount)
+	}
+	_, applied, err = manager.SchemaStatus()
+	if err != nil {
+		t.Fatalf("Failed to get schema status: %v", err)
+	}
+	manager.Close()
+	if len(applied) != 1 || applied[0].Records != 1 || applied[0].From != "0.9" {
+		t.Fatalf("Expected one applied migration, got %+v", applied)
+	}
+
+	// Records from a newer ferryd must be left alone
+	setPoolSchema(t, path, pkgID, "1.1")
+	if _, err := NewManager(path); err == nil || !strings.Contains(err.Error(), ErrSchemaTooNew.Error()) {
+		t.Fatalf("Expected newer schema error, got %v", err)
+	}
+}
+
+func TestCompareSchemaVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1", "1.0", 0},
+		{"1.9", "1.10", -1},
+		{"2.0", "1.10", 1},
+	}
+	for _, tt := range tests {
+		if got := compareSchemaVersions(tt.a, tt.b); got != tt.want {
+			t.Fatalf("compareSchemaVersions(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DatabaseBucketObjects is the root bucket recording what has been
+	// uploaded to the object store, with a sub-bucket for each repository
+	DatabaseBucketObjects = "objects"
+)
+
+// An ObjectStore receives the published repository trees, i.e. a bucket
+// acting as the origin of a CDN. The local trees remain our working copy, and
+// the database records what has been uploaded so only changes are sent.
+//
+// Keys are the path of the file relative to the repository base directory,
+// i.e. "unstable/n/nano/nano-2.8.6-76-1-x86_64.eopkg".
+type ObjectStore interface {
+	// Put will upload the local file to the key, replacing any existing object
+	Put(key, localPath string, size int64, sha256 string) error
+
+	// Delete will remove the object, which may already be gone
+	Delete(key string) error
+
+	// String will describe the store for logs and status
+	String() string
+}
+
+// A StoredObject records the local file last uploaded to a key
+type StoredObject struct {
+	Size    int64
+	ModTime time.Time
+	Sha256  string
+}
+
+// An ObjectSyncResult describes what changed when publishing a repository
+// to the object store
+type ObjectSyncResult struct {
+	Uploaded  int
+	Deleted   int
+	Unchanged int
+	Bytes     int64 // Total size of the uploads
+}
+
+// objectsBucket returns the bucket tracking the uploads of the repository
+func objectsBucket(db libdb.Database, repoID string) libdb.Database {
+	return db.Bucket([]byte(DatabaseBucketObjects)).Bucket([]byte(repoID))
+}
+
+// storedObjects will return every object recorded in the bucket
+func storedObjects(bucket libdb.Database) (map[string]*StoredObject, error) {
+	stored := make(map[string]*StoredObject)
+	err := bucket.ForEach(func(k, v []byte) error {
+		obj := &StoredObject{}
+		if err := bucket.Decode(v, obj); err != nil {
+			return err
+		}
+		stored[string(k)] = obj
+		return nil
+	})
+	return stored, err
+}
+
+// isIndexArtifact determines if the file belongs to the index, rather than
+// being a package or delta within the tree
+func isIndexArtifact(relPath string) bool {
+	return !strings.Contains(relPath, "/")
+}
+
+// syncObject will upload the file unless the store already has it. The hash
+// is only computed when the size or modification time has changed.
+func syncObject(bucket libdb.Database, store ObjectStore, key, localPath string, fi os.FileInfo, stored *StoredObject, result *ObjectSyncResult) error {
+	if stored != nil && stored.Size == fi.Size() && stored.ModTime.Equal(fi.ModTime()) {
+		result.Unchanged++
+		return nil
+	}
+	hash, err := FileSha256sum(localPath)
+	if err != nil {
+		return err
+	}
+	obj := &StoredObject{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Sha256:  hash,
+	}
+
+	if stored == nil || stored.Sha256 != hash {
+		if err := store.Put(key, localPath, obj.Size, hash); err != nil {
+			return fmt.Errorf("failed to upload %s to %s: %v", key, store, err)
+		}
+		result.Uploaded++
+		result.Bytes += obj.Size
+	} else {
+		result.Unchanged++
+	}
+	return bucket.PutObject([]byte(key), obj)
+}
+
+// PublishObjects will bring the object store up to date with the repository
+// tree. Packages and deltas are uploaded before the index, and anything no
+// longer in the tree is only removed once the new index is in place, so the
+// store never serves an index referencing missing files.
+//
+// Every upload is recorded as it completes, so a failed publish resumes where
+// it left off the next time around.
+func (r *Repository) PublishObjects(logger *log.Entry, db libdb.Database, store ObjectStore) (*ObjectSyncResult, error) {
+	r.indexMut.Lock()
+	defer r.indexMut.Unlock()
+	return r.publishObjects(logger, objectsBucket(db, r.ID), store)
+}
+
+// publishObjects will sync the tree to the store, using the bucket to record
+// what has been uploaded
+func (r *Repository) publishObjects(logger *log.Entry, bucket libdb.Database, store ObjectStore) (*ObjectSyncResult, error) {
+	stored, err := storedObjects(bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	// The tree may be a symlink into another storage root
+	root, err := filepath.EvalSymlinks(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var index []string
+	files := make(map[string]os.FileInfo)
+	err = filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		// Stores have no symlinks, so versioned indexes are published
+		// through the links at the top of the tree instead
+		if fi.IsDir() && rel == IndexPathComponent {
+			return filepath.SkipDir
+		}
+		if fi.Mode()&os.ModeSymlink != 0 && isIndexArtifact(rel) {
+			if fi, err = os.Stat(p); err != nil {
+				return err
+			}
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if isIndexArtifact(rel) {
+			// Leftovers from an interrupted index
+			if strings.Contains(rel, ".new") {
+				return nil
+			}
+			index = append(index, rel)
+		}
+		files[rel] = fi
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ObjectSyncResult{}
+	publish := func(rel string) error {
+		key := path.Join(r.ID, rel)
+		return syncObject(bucket, store, key, filepath.Join(root, rel), files[rel], stored[key], result)
+	}
+
+	for rel := range files {
+		if isIndexArtifact(rel) {
+			continue
+		}
+		if err := publish(rel); err != nil {
+			return result, err
+		}
+	}
+	for _, rel := range index {
+		if err := publish(rel); err != nil {
+			return result, err
+		}
+	}
+
+	for key := range stored {
+		if _, ok := files[strings.TrimPrefix(key, r.ID+"/")]; ok {
+			continue
+		}
+		if err := store.Delete(key); err != nil {
+			return result, fmt.Errorf("failed to delete %s from %s: %v", key, store, err)
+		}
+		if err := bucket.DeleteObject([]byte(key)); err != nil {
+			return result, err
+		}
+		result.Deleted++
+	}
+
+	logger.WithFields(log.Fields{
+		"repo":      r.ID,
+		"store":     store.String(),
+		"uploaded":  result.Uploaded,
+		"deleted":   result.Deleted,
+		"unchanged": result.Unchanged,
+		"bytes":     result.Bytes,
+	}).Info("Published repository to object store")
+	return result, nil
+}
+
+// removeObjects will delete every object uploaded for the repository. Failures
+// are only logged, as the repository itself has already gone.
+func removeObjects(logger *log.Entry, db libdb.Database, store ObjectStore, repoID string) error {
+	return forgetObjects(logger, objectsBucket(db, repoID), store, repoID)
+}
+
+// forgetObjects will delete every object recorded in the bucket from the
+// store, if given, and forget about them
+func forgetObjects(logger *log.Entry, bucket libdb.Database, store ObjectStore, repoID string) error {
+	stored, err := storedObjects(bucket)
+	if err != nil {
+		return err
+	}
+	for key := range stored {
+		if store != nil {
+			if err := store.Delete(key); err != nil {
+				logger.WithFields(log.Fields{
+					"repo":  repoID,
+					"key":   key,
+					"store": store.String(),
+					"error": err,
+				}).Warning("Failed to delete object")
+			}
+		}
+		if err := bucket.DeleteObject([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -23,6 +23,8 @@ import (
 	"libeopkg"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
 const (
@@ -37,6 +39,35 @@ const (
 
 	// PoolSchemaVersion is the current schema version for a PoolEntry
 	PoolSchemaVersion = "1.0"
+
+	// PoolIndexSource is the name of the pool index from source name to
+	// package IDs. Deltas aren't included.
+	PoolIndexSource = "source"
+
+	// PoolIndexContent is the name of the pool index from sha256sum to the
+	// IDs of every entry with that content
+	PoolIndexContent = "content"
+
+	// PoolCacheSize is how many pool entries are kept decoded in memory, as
+	// indexing and delta jobs will look up the same entries many times over
+	PoolCacheSize = 4096
+
+	// DefaultDeltaSkipExpiry is how long a delta marked as failed is skipped
+	// before it's attempted again. Each further failure skips it for longer.
+	DefaultDeltaSkipExpiry = 7 * 24 * time.Hour
+)
+
+// A DeltaSkipReason explains why a delta is no longer attempted
+type DeltaSkipReason string
+
+const (
+	// DeltaSkipPointless is recorded when the packages share the same files,
+	// so the delta would never be of use. These are skipped for good.
+	DeltaSkipPointless DeltaSkipReason = "pointless"
+
+	// DeltaSkipFailed is recorded when producing the delta went wrong, and
+	// it's worth attempting again later
+	DeltaSkipFailed DeltaSkipReason = "failed"
 )
 
 // DeltaInformation is included in pool entries if they're actually a delta
@@ -46,6 +77,17 @@ type DeltaInformation struct {
 	FromID      string // ID for the source package
 	ToRelease   int    // The target release for this delta
 	ToID        string // ID for the target package
+	TargetSize  int64  // Size of the target package, 0 for older deltas
+}
+
+// Provenance records where a package in the pool came from, so that any
+// binary can be traced back to the build that produced it
+type Provenance struct {
+	Builder  string    // Builder that uploaded the package, if known
+	Build    string    // The builder's own identifier for the build, if known
+	Manifest string    // ID of the transit manifest the package arrived with
+	Uploaded time.Time // When the package was uploaded or imported
+	JobID    string    // Job which added the package to the pool
 }
 
 // A DeltaSkipEntry is used to record skipped deltas from some kind of generation
@@ -54,6 +96,24 @@ type DeltaSkipEntry struct {
 	SchemaVersion string // Version used when this skip entry was created
 	Name          string
 	Delta         DeltaInformation
+	Marked        time.Time       // When the delta failed, zero for older entries
+	Expires       time.Time       // When the delta may be attempted again, zero for never
+	Reason        DeltaSkipReason // Empty for older entries, which were all pointless
+	Failures      int             // Times production failed, 0 for older entries
+}
+
+// SkipReason returns why the delta is skipped, treating older entries as
+// pointless as nothing else was recorded before the reason
+func (s *DeltaSkipEntry) SkipReason() DeltaSkipReason {
+	if s.Reason == "" {
+		return DeltaSkipPointless
+	}
+	return s.Reason
+}
+
+// Expired will determine if the delta may be attempted again
+func (s *DeltaSkipEntry) Expired(now time.Time) bool {
+	return !s.Expires.IsZero() && !now.Before(s.Expires)
 }
 
 // A PoolEntry is the main storage unit within ferryd.
@@ -69,18 +129,87 @@ type PoolEntry struct {
 	RefCount      uint64                // How many instances of this file exist right now
 	Meta          *libeopkg.MetaPackage // The eopkg metadata
 	Delta         *DeltaInformation     // May actually be nil if not a delta
+	Provenance    *Provenance           // May be nil for deltas and older entries
+	ContentHash   string                // sha256sum of the file, empty for older entries
+	Alias         string                // Entry whose identical file we share, if any
+	Path          string                // Location within the pool, empty for the name layout
 }
 
 // A Pool is used to manage and deduplicate resources between multiple resources,
 // and represents the real backing store for referenced eopkg files.
 type Pool struct {
-	poolDir string // Storage area
+	poolDir   string     // Storage area
+	layout    PoolLayout // Where new files are stored
+	layoutMut *sync.RWMutex
+
+	skipExpiry time.Duration // How long failed deltas are skipped, 0 for ever
+	skipMut    *sync.RWMutex
 }
 
 // Init will create our initial working paths and DB bucket
 func (p *Pool) Init(ctx *Context, db libdb.Database) error {
-	p.poolDir = filepath.Join(ctx.BaseDir, PoolPathComponent)
-	return os.MkdirAll(p.poolDir, 00755)
+	p.poolDir = ctx.PoolDir
+	p.layoutMut = &sync.RWMutex{}
+	p.skipExpiry = DefaultDeltaSkipExpiry
+	p.skipMut = &sync.RWMutex{}
+	if err := os.MkdirAll(p.poolDir, 00755); err != nil {
+		return err
+	}
+	if err := p.loadLayout(db); err != nil {
+		return err
+	}
+	bucket := db.Bucket([]byte(DatabaseBucketPool))
+	if err := bucket.Cache(PoolCacheSize, copyPoolEntry); err != nil {
+		return err
+	}
+	if err := bucket.Index(PoolIndexSource, indexPoolSource); err != nil {
+		return err
+	}
+	return bucket.Index(PoolIndexContent, indexPoolContent)
+}
+
+// copyPoolEntry will copy a cached entry for a reader. Meta, Delta and
+// Provenance are copied too, as readers will modify them, i.e. index emission
+// setting the DeltaPackages
+func copyPoolEntry(dst, src interface{}) {
+	out, in := dst.(*PoolEntry), src.(*PoolEntry)
+	*out = *in
+	if in.Meta != nil {
+		meta := *in.Meta
+		out.Meta = &meta
+	}
+	if in.Delta != nil {
+		delta := *in.Delta
+		out.Delta = &delta
+	}
+	if in.Provenance != nil {
+		prov := *in.Provenance
+		out.Provenance = &prov
+	}
+}
+
+// indexPoolSource will index normal packages by their source name
+func indexPoolSource(id []byte, decode func(o interface{}) error) ([][]byte, error) {
+	entry := PoolEntry{}
+	if err := decode(&entry); err != nil {
+		return nil, err
+	}
+	if entry.Delta != nil || entry.Meta == nil {
+		return nil, nil
+	}
+	return [][]byte{[]byte(entry.Meta.Source.Name)}, nil
+}
+
+// indexPoolContent will index entries by the sha256sum of their file
+func indexPoolContent(id []byte, decode func(o interface{}) error) ([][]byte, error) {
+	entry := PoolEntry{}
+	if err := decode(&entry); err != nil {
+		return nil, err
+	}
+	if entry.ContentHash == "" {
+		return nil, nil
+	}
+	return [][]byte{[]byte(entry.ContentHash)}, nil
 }
 
 // Close doesn't currently do anything
@@ -105,6 +234,48 @@ func (p *Pool) GetPoolItems(db libdb.Database) ([]*PoolEntry, error) {
 	return ret, nil
 }
 
+// A PoolQuery selects a page of the pool entries, which are in ID order
+type PoolQuery struct {
+	Prefix   string // Only entries whose ID begins with this
+	Orphaned bool   // Only entries no longer referenced by any repository
+	Offset   int    // Matching entries to skip
+	Limit    int    // Most entries to return, 0 for all
+}
+
+// ListPoolItems will return the page of pool entries matching the query,
+// along with the number of matches before pagination. The pool is walked
+// rather than loaded, and entries outside the page are only decoded when
+// their refcount is needed, so only the page is ever held in memory.
+func (p *Pool) ListPoolItems(db libdb.Database, query *PoolQuery) ([]*PoolEntry, int, error) {
+	var ret []*PoolEntry
+	total := 0
+	err := db.Bucket([]byte(DatabaseBucketPool)).View(func(db libdb.ReadOnlyView) error {
+		return db.ForEachPrefix([]byte(query.Prefix), func(key, value []byte) error {
+			inPage := total >= query.Offset && (query.Limit == 0 || len(ret) < query.Limit)
+			if !inPage && !query.Orphaned {
+				total++
+				return nil
+			}
+			var entry PoolEntry
+			if err := db.Decode(value, &entry); err != nil {
+				return err
+			}
+			if query.Orphaned && entry.RefCount != 0 {
+				return nil
+			}
+			if inPage {
+				ret = append(ret, &entry)
+			}
+			total++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, total, nil
+}
+
 // GetEntry will return the package entry for the given ID
 func (p *Pool) GetEntry(db libdb.Database, id string) (*PoolEntry, error) {
 	bucket := db.Bucket([]byte(DatabaseBucketPool))
@@ -116,6 +287,66 @@ func (p *Pool) GetEntry(db libdb.Database, id string) (*PoolEntry, error) {
 	return entry, nil
 }
 
+// GetEntries will return the entries for every ID in one read, failing if
+// any of them are missing
+func (p *Pool) GetEntries(db libdb.Database, ids []string) ([]*PoolEntry, error) {
+	keys := make([][]byte, len(ids))
+	for i, id := range ids {
+		keys[i] = []byte(id)
+	}
+	ret := make([]*PoolEntry, len(ids))
+	err := db.Bucket([]byte(DatabaseBucketPool)).GetObjects(keys, func(i int) interface{} {
+		ret[i] = &PoolEntry{}
+		return ret[i]
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range ret {
+		if entry == nil {
+			return nil, notFoundf("pool entry %s does not exist", ids[i])
+		}
+	}
+	return ret, nil
+}
+
+// GetSourceEntries will return every package entry built from the given
+// source name
+func (p *Pool) GetSourceEntries(db libdb.Database, source string) ([]*PoolEntry, error) {
+	ids, err := db.Bucket([]byte(DatabaseBucketPool)).LookupIndex(PoolIndexSource, []byte(source))
+	if err != nil {
+		return nil, err
+	}
+	var ret []*PoolEntry
+	for _, id := range ids {
+		entry, err := p.GetEntry(db, string(id))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, entry)
+	}
+	return ret, nil
+}
+
+// findIdentical will return an entry whose file has the given sha256sum and
+// is still present in the pool, or nil if there is none
+func (p *Pool) findIdentical(db libdb.Database, sha256sum string) (*PoolEntry, error) {
+	ids, err := db.Bucket([]byte(DatabaseBucketPool)).LookupIndex(PoolIndexContent, []byte(sha256sum))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		entry, err := p.GetEntry(db, string(id))
+		if err != nil {
+			return nil, err
+		}
+		if PathExists(p.EntryPath(entry)) {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
 // Private method to re-put the entry into the DB
 func (p *Pool) putEntry(db libdb.Database, entry *PoolEntry) error {
 	return db.Bucket([]byte(DatabaseBucketPool)).PutObject([]byte(entry.Name), entry)
@@ -137,17 +368,9 @@ func (p *Pool) putSkipEntry(db libdb.Database, entry *DeltaSkipEntry) error {
 	return db.Bucket([]byte(DatabaseBucketDeltaSkip)).PutObject([]byte(entry.Name), entry)
 }
 
-// GetMetaPoolPath will return the internal path for a given meta package
-func (p *Pool) GetMetaPoolPath(id string, meta *libeopkg.MetaPackage) string {
-	pkgDir := filepath.Join(p.poolDir, meta.GetPathComponent())
-	pkgTarget := filepath.Join(pkgDir, id)
-	return pkgTarget
-}
-
-// GetPackagePoolPath Convenience function to grab the target for the given package
-// within the current pool
-func (p *Pool) GetPackagePoolPath(pkg *libeopkg.Package) string {
-	return p.GetMetaPoolPath(pkg.ID, &pkg.Meta.Package)
+// newFilePath will return where a file new to the pool will be stored
+func (p *Pool) newFilePath(id string, meta *libeopkg.MetaPackage, sha256sum string) string {
+	return filepath.Join(p.poolDir, layoutPath(p.Layout(), id, meta, sha256sum))
 }
 
 // AddDelta will add a delta package to the pool if doesn't exist, otherwise
@@ -156,7 +379,7 @@ func (p *Pool) GetPackagePoolPath(pkg *libeopkg.Package) string {
 // This is a very loose wrapper around AddPackage, but will add some delta
 // information too. Note that a delta package is still a package in its own
 // right, its just installed and handled differently (lacking files, etc.)
-func (p *Pool) AddDelta(db libdb.Database, pkg *libeopkg.Package, mapping *DeltaInformation, copyDisk bool) (*PoolEntry, error) {
+func (p *Pool) AddDelta(logger *log.Entry, db libdb.Database, pkg *libeopkg.Package, mapping *DeltaInformation, copyDisk bool) (*PoolEntry, error) {
 	// Check if this is just a simple case of bumping the refcount
 	if entry, err := p.GetEntry(db, pkg.ID); err == nil {
 		entry.RefCount++
@@ -176,13 +399,32 @@ func (p *Pool) AddDelta(db libdb.Database, pkg *libeopkg.Package, mapping *Delta
 	// Now set the rest of the metadata before storing
 	mapping.ToRelease = targetEntry.Meta.GetRelease()
 	mapping.FromRelease = sourceEntry.Meta.GetRelease()
+	mapping.TargetSize = targetEntry.Meta.PackageSize
 
-	return p.addPackageInternal(db, pkg, copyDisk, mapping)
+	entry, err := p.addPackageInternal(logger, db, pkg, copyDisk, mapping, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Produced after failing before, so forget the failure
+	if _, err := p.GetSkipEntry(db, pkg.ID); err == nil {
+		if err := db.Bucket([]byte(DatabaseBucketDeltaSkip)).DeleteObject([]byte(pkg.ID)); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
 }
 
 // addPackageInternal used by both AddDelta and AddPackage for the main bulk of
-// the work
-func (p *Pool) addPackageInternal(db libdb.Database, pkg *libeopkg.Package, copyDisk bool, delta *DeltaInformation) (*PoolEntry, error) {
+// the work. The hashes of the package are only computed if nil.
+// The provenance is only recorded when the package is new to the pool, as
+// the first upload is the one that produced the file.
+//
+// A file byte-identical to one already in the pool under another name, i.e. a
+// rebuild artifact, is hard linked to the existing pool file rather than
+// stored again, and the entry records the alias. With the sha256 layout the
+// entries simply share the one file.
+func (p *Pool) addPackageInternal(logger *log.Entry, db libdb.Database, pkg *libeopkg.Package, copyDisk bool, delta *DeltaInformation, hashes *fileHashes, prov *Provenance) (*PoolEntry, error) {
 	// Check if this is just a simple case of bumping the refcount
 	if entry, err := p.GetEntry(db, pkg.ID); err == nil {
 		entry.RefCount++
@@ -194,24 +436,46 @@ func (p *Pool) addPackageInternal(db libdb.Database, pkg *libeopkg.Package, copy
 		return nil, err
 	}
 
+	if hashes == nil {
+		if hashes, err = hashFile(pkg.Path); err != nil {
+			return nil, err
+		}
+	}
+	identical, err := p.findIdentical(db, hashes.sha256)
+	if err != nil {
+		return nil, err
+	}
+
 	// We have no refcount, so now we need to actually include this package
 	// into the repositories.
-	pkgTarget := p.GetPackagePoolPath(pkg)
+	layout := p.Layout()
+	relPath := layoutPath(layout, pkg.ID, &pkg.Meta.Package, hashes.sha256)
+	pkgTarget := filepath.Join(p.poolDir, relPath)
 	pkgDir := filepath.Dir(pkgTarget)
 	if err := os.MkdirAll(pkgDir, 00755); err != nil {
 		return nil, err
 	}
-	// Try to hard link the file into place
-	if err := LinkOrCopyFile(pkg.Path, pkgTarget, copyDisk); err != nil {
-		return nil, err
-	}
-	sha, err := FileSha1sum(pkg.Path)
-	if err != nil {
+	created := true
+	if layout == PoolLayoutContent && PathExists(pkgTarget) {
+		// Identical content is already stored
+		created = false
+	} else if identical != nil {
+		// Share the existing file, which is always on the same filesystem
+		source := p.EntryPath(identical)
+		if err := LinkOrCopyFile(source, pkgTarget, false); err != nil {
+			return nil, err
+		}
+		logger.WithFields(log.Fields{
+			"id":    pkg.ID,
+			"alias": identical.Name,
+		}).Info("Sharing identical pool file")
+	} else if err := LinkOrCopyFile(pkg.Path, pkgTarget, copyDisk); err != nil {
+		// Try to hard link the file into place
 		return nil, err
 	}
 
 	// Store immediately useful index bits here
-	pkg.Meta.Package.PackageHash = sha
+	pkg.Meta.Package.PackageHash = hashes.sha1
 	pkg.Meta.Package.PackageSize = st.Size()
 	pkg.Meta.Package.PackageURI = fmt.Sprintf("%s/%s", pkg.Meta.Package.GetPathComponent(), pkg.ID)
 
@@ -221,13 +485,24 @@ func (p *Pool) addPackageInternal(db libdb.Database, pkg *libeopkg.Package, copy
 		RefCount:      1,
 		Meta:          &pkg.Meta.Package,
 		Delta:         delta, // Might be nil, thats OK
+		ContentHash:   hashes.sha256,
+	}
+	setEntryPath(entry, relPath)
+	if identical != nil {
+		entry.Alias = identical.Name
+	}
+	if prov != nil {
+		copied := *prov
+		entry.Provenance = &copied
 	}
 
 	if err := p.putEntry(db, entry); err != nil {
 		// Just clean out what we did because we can't write it into the DB
 		// Error isn't important, really.
-		os.Remove(pkgTarget)
-		RemovePackageParents(pkgTarget)
+		if created {
+			os.Remove(pkgTarget)
+			RemovePackageParents(pkgTarget)
+		}
 		return nil, err
 	}
 	return entry, nil
@@ -236,62 +511,161 @@ func (p *Pool) addPackageInternal(db libdb.Database, pkg *libeopkg.Package, copy
 // AddPackage will determine where the new eopkg goes, and whether we need
 // to actually push it on disk, or simply bump the ref count. Any file
 // passed to us is believed to be under our ownership now.
-func (p *Pool) AddPackage(db libdb.Database, pkg *libeopkg.Package, copy bool) (*PoolEntry, error) {
-	return p.addPackageInternal(db, pkg, copy, nil)
+func (p *Pool) AddPackage(logger *log.Entry, db libdb.Database, pkg *libeopkg.Package, copy bool) (*PoolEntry, error) {
+	return p.addPackageInternal(logger, db, pkg, copy, nil, nil, nil)
 }
 
 // RefEntry will include the given eopkg if it doesn't yet exist, otherwise
 // it will simply increase the ref count by 1.
 func (p *Pool) RefEntry(db libdb.Database, id string) error {
-	entry, err := p.GetEntry(db, id)
-	if err != nil {
-		return err
+	return db.Update(func(db libdb.Database) error {
+		entry, err := p.GetEntry(db, id)
+		if err != nil {
+			return err
+		}
+		entry.RefCount++
+		return p.putEntry(db, entry)
+	})
+}
+
+// RefEntries will take a reference on every ID in a single transaction.
+// An ID listed more than once is referenced that many times.
+func (p *Pool) RefEntries(db libdb.Database, ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
-	entry.RefCount++
-	return p.putEntry(db, entry)
+	return db.Update(func(db libdb.Database) error {
+		entries, err := p.GetEntries(db, ids)
+		if err != nil {
+			return err
+		}
+		refs := make(map[string]interface{})
+		for _, entry := range entries {
+			if prev, ok := refs[entry.Name]; ok {
+				entry = prev.(*PoolEntry)
+			}
+			entry.RefCount++
+			refs[entry.Name] = entry
+		}
+		return db.Bucket([]byte(DatabaseBucketPool)).PutObjects(refs)
+	})
 }
 
 // UnrefEntry will unref a given ID from the repository.
 // Should the refcount hit 0, the package will then be removed from the pool
 // storage.
-func (p *Pool) UnrefEntry(db libdb.Database, id string) error {
-	entry, err := p.GetEntry(db, id)
-	if err != nil {
+func (p *Pool) UnrefEntry(logger *log.Entry, db libdb.Database, id string) error {
+	var pkgPath string
+	err := db.Update(func(db libdb.Database) error {
+		entry, err := p.GetEntry(db, id)
+		if err != nil {
+			return err
+		}
+		entry.RefCount--
+		if entry.RefCount > 0 {
+			return p.putEntry(db, entry)
+		}
+
+		// RefCount is 0 so we now need to delete this entry
+		b := db.Bucket([]byte(DatabaseBucketPool))
+		if err := b.DeleteObject([]byte(id)); err != nil {
+			return err
+		}
+
+		// Identical entries may still be using the file
+		relPath := p.relativePath(entry)
+		shared, err := p.pathShared(db, entry, entry.ContentHash, relPath)
+		if err != nil || shared {
+			return err
+		}
+		pkgPath = filepath.Join(p.poolDir, relPath)
+		return nil
+	})
+	if err != nil || pkgPath == "" {
 		return err
 	}
-	entry.RefCount--
-	if entry.RefCount > 0 {
-		return p.putEntry(db, entry)
-	}
 
-	// RefCount is 0 so we now need to delete this entry
-	pkgPath := filepath.Join(p.poolDir, entry.Meta.GetPathComponent(), id)
+	// Only drop the file once nothing can reference it
 	if err := os.Remove(pkgPath); err != nil {
 		return err
 	}
 
 	// Warn if unable to delete parents
 	if err := RemovePackageParents(pkgPath); err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"path":  pkgPath,
 			"error": err,
 		}).Warning("Failed to remove package parents")
 	}
+	return nil
+}
 
-	// Now remove from DB
-	b := db.Bucket([]byte(DatabaseBucketPool))
-	return b.DeleteObject([]byte(id))
+// SetSkipExpiry will change how long deltas marked as failed from now on
+// are skipped for. An expiry of 0 skips them for ever.
+func (p *Pool) SetSkipExpiry(expiry time.Duration) {
+	p.skipMut.Lock()
+	defer p.skipMut.Unlock()
+	p.skipExpiry = expiry
 }
 
-// MarkDeltaFailed will insert a record indicating that it is not possible
-// to actually produce a given delta ID
+// SkipExpiry returns how long deltas marked as failed are skipped for
+func (p *Pool) SkipExpiry() time.Duration {
+	p.skipMut.RLock()
+	defer p.skipMut.RUnlock()
+	return p.skipExpiry
+}
+
+// MarkDeltaPointless will insert a record indicating that the delta would
+// never be of use, so it's never attempted again unless explicitly retried
+func (p *Pool) MarkDeltaPointless(db libdb.Database, id string, delta *DeltaInformation) error {
+	skip, err := p.GetSkipEntry(db, id)
+	if err != nil {
+		skip = newSkipEntry(id, delta)
+	} else if skip.SkipReason() == DeltaSkipPointless {
+		// Already recorded? Skip again..
+		return nil
+	}
+	skip.Reason = DeltaSkipPointless
+	skip.Marked = time.Now().UTC()
+	skip.Expires = time.Time{}
+	return p.putSkipEntry(db, skip)
+}
+
+// MarkDeltaFailed will insert a record indicating that production of the
+// delta failed, so it's skipped until the record expires. Each failure in a
+// row doubles how long the delta is skipped for.
 func (p *Pool) MarkDeltaFailed(db libdb.Database, id string, delta *DeltaInformation) error {
-	// Already recorded? Skip again..
-	if _, err := p.GetSkipEntry(db, id); err == nil {
+	now := time.Now().UTC()
+
+	skip, err := p.GetSkipEntry(db, id)
+	if err != nil {
+		skip = newSkipEntry(id, delta)
+	} else if skip.SkipReason() == DeltaSkipPointless || !skip.Expired(now) {
+		// Already recorded? Skip again..
 		return nil
 	}
+	skip.Reason = DeltaSkipFailed
+	skip.Failures++
+	skip.Marked = now
+	skip.Expires = time.Time{}
+	if expiry := p.SkipExpiry(); expiry > 0 {
+		skip.Expires = now.Add(skipBackoff(expiry, skip.Failures))
+	}
+	return p.putSkipEntry(db, skip)
+}
+
+// skipBackoff returns how long a delta which has failed the given number of
+// times is skipped for, doubling with each failure up to 8 times the expiry
+func skipBackoff(expiry time.Duration, failures int) time.Duration {
+	for i := 1; i < failures && i < 4; i++ {
+		expiry *= 2
+	}
+	return expiry
+}
 
-	skip := &DeltaSkipEntry{
+// newSkipEntry returns an empty skip record for the delta
+func newSkipEntry(id string, delta *DeltaInformation) *DeltaSkipEntry {
+	return &DeltaSkipEntry{
 		SchemaVersion: PoolSchemaVersion,
 		Name:          id,
 		Delta: DeltaInformation{
@@ -301,15 +675,79 @@ func (p *Pool) MarkDeltaFailed(db libdb.Database, id string, delta *DeltaInforma
 			ToRelease:   delta.ToRelease,
 		},
 	}
-	return p.putSkipEntry(db, skip)
 }
 
 // GetDeltaFailed will determine if generation of this delta ID has actually
-// failed in the past, skipping a potentially expensive delta examination.
+// failed in the past, or was pointless, skipping a potentially expensive
+// delta examination. Expired records no longer count.
 func (p *Pool) GetDeltaFailed(db libdb.Database, id string) bool {
 	skip, err := p.GetSkipEntry(db, id)
 	if err == nil && skip != nil {
-		return true
+		return !skip.Expired(time.Now().UTC())
 	}
 	return false
 }
+
+// ClearSkipEntries will remove the record of every failed delta to or from
+// any of the package IDs, so that they're attempted again. The IDs of the
+// deltas cleared are returned.
+func (p *Pool) ClearSkipEntries(db libdb.Database, pkgIDs []string) ([]string, error) {
+	want := make(map[string]bool)
+	for _, id := range pkgIDs {
+		want[id] = true
+	}
+
+	var cleared []string
+	err := db.Update(func(db libdb.Database) error {
+		bucket := db.Bucket([]byte(DatabaseBucketDeltaSkip))
+		err := bucket.ForEach(func(k, v []byte) error {
+			skip := DeltaSkipEntry{}
+			if err := bucket.Decode(v, &skip); err != nil {
+				return err
+			}
+			if want[skip.Delta.ToID] || want[skip.Delta.FromID] {
+				cleared = append(cleared, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range cleared {
+			if err := bucket.DeleteObject([]byte(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cleared, nil
+}
+
+// GetSkipEntries will return the record of every skipped delta to or from
+// any of the package IDs, including those which have expired
+func (p *Pool) GetSkipEntries(db libdb.Database, pkgIDs []string) ([]*DeltaSkipEntry, error) {
+	want := make(map[string]bool)
+	for _, id := range pkgIDs {
+		want[id] = true
+	}
+
+	var skips []*DeltaSkipEntry
+	bucket := db.Bucket([]byte(DatabaseBucketDeltaSkip))
+	err := bucket.ForEach(func(k, v []byte) error {
+		skip := &DeltaSkipEntry{}
+		if err := bucket.Decode(v, skip); err != nil {
+			return err
+		}
+		if want[skip.Delta.ToID] || want[skip.Delta.FromID] {
+			skips = append(skips, skip)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return skips, nil
+}
@@ -0,0 +1,271 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DatabaseBucketPoolSettings stores the settings of the pool itself
+	DatabaseBucketPoolSettings = "poolSettings"
+
+	// poolLayoutKey is where the layout is kept in the settings bucket
+	poolLayoutKey = "layout"
+
+	// poolContentComponent is the pool directory of the sha256 layout
+	poolContentComponent = "sha256"
+)
+
+// PoolLayout decides where new files are kept within the pool
+type PoolLayout string
+
+const (
+	// PoolLayoutName keeps each file as pool/<component>/<ID>, with byte
+	// identical files hard linked together
+	PoolLayoutName PoolLayout = "name"
+
+	// PoolLayoutContent keeps a single file for each content, stored as
+	// pool/sha256/ab/cd/<sha256sum>, with the ID only known to the database
+	PoolLayoutContent PoolLayout = "sha256"
+)
+
+// Validate will ensure the layout is one we know about
+func (l PoolLayout) Validate() error {
+	switch l {
+	case PoolLayoutName, PoolLayoutContent:
+		return nil
+	default:
+		return fmt.Errorf("unknown pool layout: %s", l)
+	}
+}
+
+// PoolLayoutProgressFunc is called after each pool entry is migrated
+type PoolLayoutProgressFunc func(done, total int)
+
+// loadLayout will read the layout from the database, which defaults to the
+// name layout
+func (p *Pool) loadLayout(db libdb.Database) error {
+	var layout string
+	err := db.Bucket([]byte(DatabaseBucketPoolSettings)).GetObject([]byte(poolLayoutKey), &layout)
+	if err == libdb.ErrNotFound {
+		p.setLayout(PoolLayoutName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	p.setLayout(PoolLayout(layout))
+	return nil
+}
+
+// setLayout will change the layout used for new files
+func (p *Pool) setLayout(layout PoolLayout) {
+	p.layoutMut.Lock()
+	defer p.layoutMut.Unlock()
+	p.layout = layout
+}
+
+// Layout returns the layout used for new files in the pool
+func (p *Pool) Layout() PoolLayout {
+	p.layoutMut.RLock()
+	defer p.layoutMut.RUnlock()
+	return p.layout
+}
+
+// layoutPath returns where the file would be kept by the layout, relative
+// to the pool directory
+func layoutPath(layout PoolLayout, id string, meta *libeopkg.MetaPackage, sha256sum string) string {
+	if layout == PoolLayoutContent {
+		return filepath.Join(poolContentComponent, sha256sum[0:2], sha256sum[2:4], sha256sum)
+	}
+	return filepath.Join(meta.GetPathComponent(), id)
+}
+
+// relativePath returns where the entry's file is kept, relative to the pool
+// directory. Entries without a Path are stored with the name layout.
+func (p *Pool) relativePath(entry *PoolEntry) string {
+	if entry.Path != "" {
+		return entry.Path
+	}
+	return layoutPath(PoolLayoutName, entry.Name, entry.Meta, "")
+}
+
+// EntryPath returns the location of the entry's file within the pool
+func (p *Pool) EntryPath(entry *PoolEntry) string {
+	return filepath.Join(p.poolDir, p.relativePath(entry))
+}
+
+// setEntryPath will record where the entry's file now lives. Files kept by
+// the name layout don't record a Path, just as before layouts existed.
+func setEntryPath(entry *PoolEntry, relPath string) {
+	if relPath == layoutPath(PoolLayoutName, entry.Name, entry.Meta, "") {
+		entry.Path = ""
+		return
+	}
+	entry.Path = relPath
+}
+
+// pathShared determines if another entry still uses the file, which can only
+// happen for identical content
+func (p *Pool) pathShared(db libdb.Database, entry *PoolEntry, contentHash, relPath string) (bool, error) {
+	if contentHash == "" {
+		return false, nil
+	}
+	ids, err := db.Bucket([]byte(DatabaseBucketPool)).LookupIndex(PoolIndexContent, []byte(contentHash))
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		if string(id) == entry.Name {
+			continue
+		}
+		other, err := p.GetEntry(db, string(id))
+		if err != nil {
+			return false, err
+		}
+		if p.relativePath(other) == relPath {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removePoolFile will remove the file and any directories it leaves empty
+func removePoolFile(logger *log.Entry, path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Warning("Failed to remove pool file")
+		return
+	}
+	if err := RemovePackageParents(path); err != nil {
+		logger.WithFields(log.Fields{
+			"path":  path,
+			"error": err,
+		}).Warning("Failed to remove package parents")
+	}
+}
+
+// relocateEntry will move the entry's file to where the layout keeps it,
+// returning the old path if it is no longer used by anything. Older entries
+// have their content hash computed along the way.
+func (p *Pool) relocateEntry(logger *log.Entry, db libdb.Database, id string, layout PoolLayout) (oldPath string, moved bool, err error) {
+	entry, err := p.GetEntry(db, id)
+	if err != nil {
+		return "", false, err
+	}
+	oldRel := p.relativePath(entry)
+	oldPath = filepath.Join(p.poolDir, oldRel)
+	oldHash := entry.ContentHash
+
+	if entry.ContentHash == "" {
+		if entry.ContentHash, err = FileSha256sum(oldPath); err != nil {
+			return "", false, err
+		}
+	}
+	newRel := layoutPath(layout, entry.Name, entry.Meta, entry.ContentHash)
+	if newRel == oldRel {
+		if oldHash == entry.ContentHash {
+			return "", false, nil
+		}
+		return "", false, p.putEntry(db, entry)
+	}
+
+	newPath := filepath.Join(p.poolDir, newRel)
+	created := false
+	if !PathExists(newPath) {
+		if err = os.MkdirAll(filepath.Dir(newPath), 00755); err != nil {
+			return "", false, err
+		}
+		if err = LinkOrCopyFile(oldPath, newPath, false); err != nil {
+			return "", false, err
+		}
+		created = true
+	}
+
+	setEntryPath(entry, newRel)
+	entry.Alias = ""
+	if err = p.putEntry(db, entry); err != nil {
+		if created {
+			removePoolFile(logger, newPath)
+		}
+		return "", false, err
+	}
+
+	shared, err := p.pathShared(db, entry, entry.ContentHash, oldRel)
+	if err != nil || shared {
+		return "", true, err
+	}
+	return oldPath, true, nil
+}
+
+// MigrateLayout will switch the pool to the layout, so that new files are
+// stored with it, and then move every existing file into place. Each entry
+// is moved in its own transaction so the pool stays usable throughout, and
+// an interrupted migration may simply be run again.
+func (p *Pool) MigrateLayout(logger *log.Entry, db libdb.Database, layout PoolLayout, progress PoolLayoutProgressFunc) (int, error) {
+	if err := layout.Validate(); err != nil {
+		return 0, err
+	}
+	err := db.Update(func(db libdb.Database) error {
+		if err := db.Bucket([]byte(DatabaseBucketPoolSettings)).PutObject([]byte(poolLayoutKey), string(layout)); err != nil {
+			return err
+		}
+		p.setLayout(layout)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := p.GetPoolItems(db)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for i, entry := range entries {
+		var oldPath string
+		var relocated bool
+		err := db.Update(func(db libdb.Database) error {
+			var err error
+			oldPath, relocated, err = p.relocateEntry(logger, db, entry.Name, layout)
+			return err
+		})
+		// Entries freed since we listed them are fine
+		if err != nil && err != libdb.ErrNotFound {
+			return moved, fmt.Errorf("failed to migrate %s: %v", entry.Name, err)
+		}
+		if relocated {
+			moved++
+		}
+		// Only drop the old file once nothing can reference it
+		if oldPath != "" {
+			removePoolFile(logger, oldPath)
+		}
+		if progress != nil {
+			progress(i+1, len(entries))
+		}
+	}
+	return moved, nil
+}
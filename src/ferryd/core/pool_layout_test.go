@@ -0,0 +1,130 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPoolLayoutMigrate(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	// Reopened part way through
+	defer func() {
+		manager.Close()
+	}()
+
+	origID := filepath.Base(searchTestPackage)
+	rebuilt := filepath.Join(dir, "nano-2.7.1-63-1-rebuild-x86_64.eopkg")
+	if err := CopyFile(searchTestPackage, rebuilt); err != nil {
+		t.Fatalf("Failed to copy package: %v", err)
+	}
+	rebuiltID := filepath.Base(rebuilt)
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if err := manager.AddPackages("shannon", []string{rebuilt}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if _, err := manager.MigratePoolLayout("bogus", nil); err == nil {
+		t.Fatalf("An unknown layout should be refused")
+	}
+
+	getEntries := func() (*PoolEntry, *PoolEntry) {
+		entries, err := manager.pool.GetEntries(manager.db, []string{origID, rebuiltID})
+		if err != nil {
+			t.Fatalf("Failed to get pool entries: %v", err)
+		}
+		return entries[0], entries[1]
+	}
+	orig, alias := getEntries()
+	namePaths := []string{manager.pool.EntryPath(orig), manager.pool.EntryPath(alias)}
+
+	// Identical content ends up in a single file
+	var calls int
+	moved, err := manager.MigratePoolLayout(PoolLayoutContent, func(done, total int) {
+		calls++
+	})
+	if err != nil {
+		t.Fatalf("Failed to migrate pool: %v", err)
+	}
+	if moved != 2 || calls != 2 {
+		t.Fatalf("Expected 2 entries to be moved, got %d with %d progress calls", moved, calls)
+	}
+	orig, alias = getEntries()
+	contentPath := manager.pool.EntryPath(orig)
+	want := filepath.Join(manager.pool.poolDir, "sha256", orig.ContentHash[0:2], orig.ContentHash[2:4], orig.ContentHash)
+	if contentPath != want || manager.pool.EntryPath(alias) != want {
+		t.Fatalf("Expected both entries to use %s, got %s", want, manager.pool.EntryPath(alias))
+	}
+	if !PathExists(contentPath) || PathExists(namePaths[0]) || PathExists(namePaths[1]) {
+		t.Fatalf("Pool files weren't moved into place")
+	}
+
+	// New files use the layout, and shared files outlive either entry
+	delta := "../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg"
+	if err := manager.AddPackages("shannon", []string{delta}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	entry, err := manager.pool.GetEntry(manager.db, filepath.Base(delta))
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if entry.Path == "" {
+		t.Fatalf("New entries should be stored by content")
+	}
+	if err := manager.RemoveSource("shannon", "nano", 63); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if !PathExists(contentPath) {
+		t.Fatalf("A file still used by another entry was removed")
+	}
+
+	// The layout is remembered, and can be switched back
+	manager.Close()
+	if manager, err = NewManager(dir); err != nil {
+		t.Fatalf("Failed to reopen manager: %v", err)
+	}
+	if layout := manager.PoolLayout(); layout != PoolLayoutContent {
+		t.Fatalf("Expected the pool layout to persist, got %s", layout)
+	}
+	if moved, err = manager.MigratePoolLayout(PoolLayoutName, nil); err != nil || moved != 2 {
+		t.Fatalf("Failed to migrate pool back, moved %d: %v", moved, err)
+	}
+	orig, err = manager.pool.GetEntry(manager.db, origID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if orig.Path != "" || manager.pool.EntryPath(orig) != namePaths[0] || !PathExists(namePaths[0]) {
+		t.Fatalf("Entry wasn't moved back to the name layout: %+v", orig)
+	}
+	if PathExists(contentPath) {
+		t.Fatalf("Unused content file should have been removed")
+	}
+	if _, err := manager.GetPackageInfo("unstable", "nano"); err != nil {
+		t.Fatalf("Repository should be unaffected: %v", err)
+	}
+}
@@ -0,0 +1,588 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"libdb"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSourceIndex(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgID := filepath.Base(searchTestPackage)
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	entries, err := manager.pool.GetSourceEntries(manager.db, "nano")
+	if err != nil {
+		t.Fatalf("Failed to look up source: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != pkgID {
+		t.Fatalf("Source index should contain %s: %v", pkgID, entries)
+	}
+
+	if err := manager.CopySource("unstable", "shannon", "nano", 62); err == nil {
+		t.Fatalf("Copying a missing release should fail")
+	}
+	if err := manager.CopySource("unstable", "shannon", "nano", -1); err != nil {
+		t.Fatalf("Failed to copy source: %v", err)
+	}
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.RemoveSource(repoID, "nano", 63); err != nil {
+			t.Fatalf("Failed to remove source from %s: %v", repoID, err)
+		}
+	}
+	if err := manager.RemoveSource("unstable", "nano", 63); err == nil {
+		t.Fatalf("Removing a source twice should fail")
+	}
+
+	// Freeing the pool entry must drop it from the index
+	entries, err = manager.pool.GetSourceEntries(manager.db, "nano")
+	if err != nil {
+		t.Fatalf("Failed to look up source: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Source index still contains freed packages: %v", entries)
+	}
+}
+
+func TestPoolRefConcurrent(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgID := filepath.Base(searchTestPackage)
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	checkRefs := func(want uint64) {
+		entry, err := manager.pool.GetEntry(manager.db, pkgID)
+		if err != nil {
+			t.Fatalf("Failed to get pool entry: %v", err)
+		}
+		if entry.RefCount != want {
+			t.Fatalf("Expected refcount %d, got %d", want, entry.RefCount)
+		}
+	}
+
+	// Reads within a transaction must see its own writes
+	err = manager.db.Update(func(db libdb.Database) error {
+		if err := manager.pool.RefEntry(db, pkgID); err != nil {
+			return err
+		}
+		return manager.pool.RefEntry(db, pkgID)
+	})
+	if err != nil {
+		t.Fatalf("Failed to ref entry: %v", err)
+	}
+	checkRefs(3)
+
+	// Concurrent updates mustn't lose any counts
+	const workers, rounds = 16, 8
+	run := func(f func(db libdb.Database, id string) error) {
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < rounds; j++ {
+					if err := f(manager.db, pkgID); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("Failed to update refcount: %v", err)
+			}
+		}
+	}
+	run(manager.pool.RefEntry)
+	checkRefs(3 + workers*rounds)
+	run(func(db libdb.Database, id string) error {
+		return manager.pool.UnrefEntry(manager.logger, db, id)
+	})
+	checkRefs(3)
+}
+
+func TestPoolRefEntries(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	oldID, tipID := filepath.Base(pkgs[0]), filepath.Base(pkgs[1])
+	for _, repoID := range []string{"unstable", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", pkgs[:1], false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if _, err := manager.PullRepo("unstable", "stable"); err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", pkgs[1:], false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if err := manager.CloneRepo("unstable", "full", true); err != nil {
+		t.Fatalf("Failed to clone repo: %v", err)
+	}
+	if err := manager.CloneRepo("unstable", "tip", false); err != nil {
+		t.Fatalf("Failed to clone repo: %v", err)
+	}
+	if _, err := manager.PullRepo("unstable", "stable"); err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+
+	want := map[string]uint64{oldID: 3, tipID: 4}
+	entries, err := manager.pool.GetEntries(manager.db, []string{oldID, tipID})
+	if err != nil {
+		t.Fatalf("Failed to get pool entries: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.RefCount != want[entry.Name] {
+			t.Fatalf("Expected refcount %d for %s, got %d", want[entry.Name], entry.Name, entry.RefCount)
+		}
+	}
+
+	// Every listed ID takes a reference, and nothing is taken if any is missing
+	if err := manager.pool.RefEntries(manager.db, []string{oldID, oldID}); err != nil {
+		t.Fatalf("Failed to ref entries: %v", err)
+	}
+	if err := manager.pool.RefEntries(manager.db, []string{tipID, "missing.eopkg"}); err == nil {
+		t.Fatalf("Referencing a missing entry should fail")
+	}
+	entries, err = manager.pool.GetEntries(manager.db, []string{oldID, tipID})
+	if err != nil {
+		t.Fatalf("Failed to get pool entries: %v", err)
+	}
+	if entries[0].RefCount != 5 || entries[1].RefCount != 4 {
+		t.Fatalf("Unexpected refcounts %d and %d", entries[0].RefCount, entries[1].RefCount)
+	}
+}
+
+func TestPoolCache(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	pkgID := filepath.Base(searchTestPackage)
+
+	refCount := func() uint64 {
+		entry, err := manager.pool.GetEntry(manager.db, pkgID)
+		if err != nil {
+			t.Fatalf("Failed to get entry: %v", err)
+		}
+		return entry.RefCount
+	}
+
+	// Readers are free to modify what they're given
+	entry, err := manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	entry.RefCount = 100
+	entry.Meta.DeltaPackages = &[]libeopkg.Delta{{ReleaseFrom: 1}}
+	entry, err = manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get entry: %v", err)
+	}
+	if entry.RefCount != 1 || entry.Meta.DeltaPackages != nil {
+		t.Fatalf("Cached entry was modified by a reader")
+	}
+
+	// Committed writes are seen straight away, aborted ones never are
+	if err := manager.pool.RefEntry(manager.db, pkgID); err != nil {
+		t.Fatalf("Failed to ref entry: %v", err)
+	}
+	if n := refCount(); n != 2 {
+		t.Fatalf("Expected refcount 2 after commit, got %d", n)
+	}
+	errAbort := errors.New("abort")
+	err = manager.db.Update(func(db libdb.Database) error {
+		if err := manager.pool.RefEntry(db, pkgID); err != nil {
+			return err
+		}
+		entry, err := manager.pool.GetEntry(db, pkgID)
+		if err != nil {
+			return err
+		}
+		if entry.RefCount != 3 {
+			t.Errorf("Transaction should read its own write, got refcount %d", entry.RefCount)
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("Expected transaction to abort, got %v", err)
+	}
+	if n := refCount(); n != 2 {
+		t.Fatalf("Expected refcount 2 after abort, got %d", n)
+	}
+
+	// Missing entries are still missing when the rest are cached
+	if _, err := manager.pool.GetEntries(manager.db, []string{pkgID, "missing.eopkg"}); err == nil {
+		t.Fatalf("Getting a missing entry should fail")
+	}
+}
+
+func TestPoolProvenance(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	prov := &Provenance{
+		Builder:  "build-1",
+		Manifest: "nano.tram",
+		Uploaded: time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+		JobID:    "0123456789abcdef",
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, true, prov); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	// Only the first upload of a file is recorded
+	again := &Provenance{Builder: "build-2", Uploaded: time.Now().UTC()}
+	if err := manager.AddPackages("shannon", []string{searchTestPackage}, true, again); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	info, err := manager.GetPackageInfo("shannon", "nano")
+	if err != nil {
+		t.Fatalf("Failed to get package info: %v", err)
+	}
+	if info.Published == nil || info.Published.Provenance == nil {
+		t.Fatalf("Published package has no provenance: %+v", info.Published)
+	}
+	if got := info.Published.Provenance; !reflect.DeepEqual(got, prov) {
+		t.Fatalf("Expected provenance %+v, got %+v", prov, got)
+	}
+}
+
+func TestPoolContentDedup(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	// A rebuild artifact, identical but uploaded under another name
+	origID := filepath.Base(searchTestPackage)
+	rebuilt := filepath.Join(dir, "nano-2.7.1-63-1-rebuild-x86_64.eopkg")
+	if err := CopyFile(searchTestPackage, rebuilt); err != nil {
+		t.Fatalf("Failed to copy package: %v", err)
+	}
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if err := manager.AddPackages("shannon", []string{rebuilt}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	entries, err := manager.pool.GetEntries(manager.db, []string{origID, filepath.Base(rebuilt)})
+	if err != nil {
+		t.Fatalf("Failed to get pool entries: %v", err)
+	}
+	orig, alias := entries[0], entries[1]
+	if orig.ContentHash == "" || orig.ContentHash != alias.ContentHash {
+		t.Fatalf("Identical files should share a content hash: %s, %s", orig.ContentHash, alias.ContentHash)
+	}
+	if orig.Alias != "" || alias.Alias != origID {
+		t.Fatalf("Expected %s to alias %s, got %q", alias.Name, origID, alias.Alias)
+	}
+	origPath := manager.pool.EntryPath(orig)
+	aliasPath := manager.pool.EntryPath(alias)
+	st1, err := os.Stat(origPath)
+	if err != nil {
+		t.Fatalf("Failed to stat pool file: %v", err)
+	}
+	st2, err := os.Stat(aliasPath)
+	if err != nil {
+		t.Fatalf("Failed to stat pool file: %v", err)
+	}
+	if !os.SameFile(st1, st2) {
+		t.Fatalf("Identical pool files should be linked together")
+	}
+
+	// Freeing the original leaves the alias intact
+	if err := manager.RemoveSource("unstable", "nano", 63); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if PathExists(origPath) || !PathExists(aliasPath) {
+		t.Fatalf("Only the freed pool file should have been removed")
+	}
+	found, err := manager.pool.findIdentical(manager.db, alias.ContentHash)
+	if err != nil {
+		t.Fatalf("Failed to find identical file: %v", err)
+	}
+	if found == nil || found.Name != alias.Name {
+		t.Fatalf("Expected %s to remain for its content, got %v", alias.Name, found)
+	}
+}
+
+func TestDeltaSkipExpiry(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	pkgID := filepath.Base(searchTestPackage)
+	mapping := &DeltaInformation{FromID: "nano-2.7.0-62-1-x86_64.eopkg", ToID: pkgID}
+
+	// Permanent until retried
+	manager.SetDeltaSkipExpiry(0)
+	if err := manager.MarkDeltaFailed("permanent.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+	if !manager.GetDeltaFailed("permanent.delta.eopkg") {
+		t.Fatalf("Delta should be marked as failed")
+	}
+	skip, err := manager.pool.GetSkipEntry(manager.db, "permanent.delta.eopkg")
+	if err != nil || !skip.Expires.IsZero() || skip.Marked.IsZero() {
+		t.Fatalf("Expected a permanent record: %+v %v", skip, err)
+	}
+
+	// Expired records no longer count, and are replaced when marked again
+	manager.SetDeltaSkipExpiry(time.Nanosecond)
+	if err := manager.MarkDeltaFailed("expiring.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if manager.GetDeltaFailed("expiring.delta.eopkg") {
+		t.Fatalf("Expired record should no longer skip the delta")
+	}
+	manager.SetDeltaSkipExpiry(time.Hour)
+	if err := manager.MarkDeltaFailed("expiring.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+	if !manager.GetDeltaFailed("expiring.delta.eopkg") {
+		t.Fatalf("Delta marked again should be skipped")
+	}
+
+	// Retrying the package forgets both
+	if _, err := manager.RetryDeltas("unstable", "missing"); !IsNotFound(err) {
+		t.Fatalf("Expected retrying a missing package to fail, got %v", err)
+	}
+	cleared, err := manager.RetryDeltas("unstable", "nano")
+	if err != nil {
+		t.Fatalf("Failed to retry deltas: %v", err)
+	}
+	if len(cleared) != 2 {
+		t.Fatalf("Expected 2 records cleared, got %v", cleared)
+	}
+	for _, id := range cleared {
+		if manager.GetDeltaFailed(id) {
+			t.Fatalf("%s should no longer be marked", id)
+		}
+	}
+}
+
+func TestDeltaSkipReasons(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	mapping := &DeltaInformation{FromID: "nano-2.7.0-62-1-x86_64.eopkg", ToID: filepath.Base(searchTestPackage)}
+
+	// Failures back off, and are counted
+	manager.SetDeltaSkipExpiry(time.Nanosecond)
+	for i := 0; i < 2; i++ {
+		if err := manager.MarkDeltaFailed("failed.delta.eopkg", mapping); err != nil {
+			t.Fatalf("Failed to mark delta: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	skip, err := manager.pool.GetSkipEntry(manager.db, "failed.delta.eopkg")
+	if err != nil || skip.SkipReason() != DeltaSkipFailed || skip.Failures != 2 {
+		t.Fatalf("Expected a record of 2 failures: %+v %v", skip, err)
+	}
+	if d := skip.Expires.Sub(skip.Marked); d != 2*time.Nanosecond {
+		t.Fatalf("Expected the second failure to be skipped for longer, got %v", d)
+	}
+	if got := skipBackoff(time.Hour, 10); got != 8*time.Hour {
+		t.Fatalf("Expected backoff to stop at 8 times the expiry, got %v", got)
+	}
+
+	// Pointless deltas are skipped for good, and replace failures
+	if err := manager.MarkDeltaPointless("failed.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+	if err := manager.MarkDeltaPointless("pointless.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	for _, id := range []string{"failed.delta.eopkg", "pointless.delta.eopkg"} {
+		if !manager.GetDeltaFailed(id) {
+			t.Fatalf("Pointless delta %s should always be skipped", id)
+		}
+	}
+
+	// A later failure doesn't turn a pointless delta into a failed one
+	if err := manager.MarkDeltaFailed("pointless.delta.eopkg", mapping); err != nil {
+		t.Fatalf("Failed to mark delta: %v", err)
+	}
+
+	skips, err := manager.GetDeltaSkips("unstable")
+	if err != nil {
+		t.Fatalf("Failed to list skipped deltas: %v", err)
+	}
+	if len(skips) != 2 || skips[0].Name != "failed.delta.eopkg" || skips[1].Name != "pointless.delta.eopkg" {
+		t.Fatalf("Expected both deltas listed, got %+v", skips)
+	}
+	for _, skip := range skips {
+		if skip.SkipReason() != DeltaSkipPointless || !skip.Expires.IsZero() {
+			t.Fatalf("Expected %s to be pointless for good: %+v", skip.Name, skip)
+		}
+	}
+	if skips[0].Failures != 2 || skips[1].Failures != 0 {
+		t.Fatalf("Unexpected failure counts: %d, %d", skips[0].Failures, skips[1].Failures)
+	}
+}
+
+func TestListPoolItems(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	pkgs := []string{
+		searchTestPackage,
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+	// Nothing refers to it any more, as a crash might leave behind
+	orphan := &PoolEntry{Name: "zlib-1.2.11-10-1-x86_64.eopkg"}
+	if err := manager.db.Update(func(db libdb.Database) error {
+		return manager.pool.putEntry(db, orphan)
+	}); err != nil {
+		t.Fatalf("Failed to store orphan: %v", err)
+	}
+
+	all, err := manager.GetPoolItems()
+	if err != nil {
+		t.Fatalf("Failed to get pool items: %v", err)
+	}
+	var names []string
+	for _, entry := range all {
+		names = append(names, entry.Name)
+	}
+
+	tests := []struct {
+		query PoolQuery
+		names []string
+		total int
+	}{
+		{PoolQuery{}, names, len(names)},
+		{PoolQuery{Prefix: "nano-2.8.5"}, []string{"nano-2.8.5-75-1-x86_64.eopkg"}, 1},
+		{PoolQuery{Prefix: "vim"}, nil, 0},
+		{PoolQuery{Orphaned: true}, []string{orphan.Name}, 1},
+		{PoolQuery{Orphaned: true, Prefix: "nano"}, nil, 0},
+		{PoolQuery{Offset: 1, Limit: 2}, names[1:3], len(names)},
+		{PoolQuery{Offset: 10}, nil, len(names)},
+	}
+	for _, test := range tests {
+		entries, total, err := manager.ListPoolItems(&test.query)
+		if err != nil {
+			t.Fatalf("Failed to list pool items for %+v: %v", test.query, err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Name)
+		}
+		if !reflect.DeepEqual(got, test.names) || total != test.total {
+			t.Fatalf("Expected %v of %d for %+v, got %v of %d", test.names, test.total, test.query, got, total)
+		}
+	}
+}
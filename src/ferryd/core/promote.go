@@ -0,0 +1,329 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+	"strings"
+)
+
+// A PromotionProblem is a policy check which failed for one package
+type PromotionProblem struct {
+	Package string // ID of the package with the problem
+	Problem string // Human readable description
+}
+
+// A PromotionError is returned when a promotion is refused by the policy
+// checks, listing every problem found
+type PromotionError struct {
+	Problems []PromotionProblem
+}
+
+// Error will summarise the problems preventing promotion
+func (p *PromotionError) Error() string {
+	var msgs []string
+	for _, problem := range p.Problems {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", problem.Package, problem.Problem))
+	}
+	return fmt.Sprintf("promotion refused: %s", strings.Join(msgs, "; "))
+}
+
+// releaseSatisfies will determine if the release meets the release constraints
+// of the dependency. Version constraints aren't considered, as the release
+// number is what the repository orders packages by.
+func releaseSatisfies(dep *libeopkg.Dependency, release int) bool {
+	if dep.Release > 0 && release != dep.Release {
+		return false
+	}
+	if dep.ReleaseFrom > 0 && release < dep.ReleaseFrom {
+		return false
+	}
+	if dep.ReleaseTo > 0 && release > dep.ReleaseTo {
+		return false
+	}
+	return true
+}
+
+// describeDependency will return the dependency along with any release
+// constraints, i.e. "glibc (release >= 40)"
+func describeDependency(dep *libeopkg.Dependency) string {
+	var constraints []string
+	if dep.Release > 0 {
+		constraints = append(constraints, fmt.Sprintf("release == %d", dep.Release))
+	}
+	if dep.ReleaseFrom > 0 {
+		constraints = append(constraints, fmt.Sprintf("release >= %d", dep.ReleaseFrom))
+	}
+	if dep.ReleaseTo > 0 {
+		constraints = append(constraints, fmt.Sprintf("release <= %d", dep.ReleaseTo))
+	}
+	if len(constraints) == 0 {
+		return dep.Name
+	}
+	return fmt.Sprintf("%s (%s)", dep.Name, strings.Join(constraints, ", "))
+}
+
+// runtimeDependencies returns the runtime dependencies of the package, if any
+func runtimeDependencies(meta *libeopkg.MetaPackage) []libeopkg.Dependency {
+	if meta.RuntimeDependencies == nil {
+		return nil
+	}
+	return *meta.RuntimeDependencies
+}
+
+// checkDeltas will ensure every delta the source repository's policy calls
+// for has either been produced or is known to have failed
+func (r *Repository) checkDeltas(db libdb.Database, pool *Pool, tip *PoolEntry) ([]PromotionProblem, error) {
+	entry, err := r.GetEntry(db, tip.Meta.Name)
+	if err != nil {
+		return nil, err
+	}
+	older, err := pool.GetEntries(db, entry.Available)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*libeopkg.MetaPackage
+	for _, old := range older {
+		if old.Meta.GetRelease() < tip.Meta.GetRelease() && libeopkg.IsDeltaPossible(old.Meta, tip.Meta) {
+			candidates = append(candidates, old.Meta)
+		}
+	}
+
+	have := make(map[string]bool)
+	for _, id := range entry.Deltas {
+		have[id] = true
+	}
+
+	var problems []PromotionProblem
+	for _, old := range r.DeltaPolicy.SelectCandidates(tip.Meta, candidates) {
+		deltaID := libeopkg.ComputeDeltaName(old, tip.Meta)
+		if have[deltaID] || pool.GetDeltaFailed(db, deltaID) {
+			continue
+		}
+		problems = append(problems, PromotionProblem{
+			Package: tip.Name,
+			Problem: fmt.Sprintf("delta from release %d has not been built", old.GetRelease()),
+		})
+	}
+	return problems, nil
+}
+
+// checkPromotion will apply the policy checks to promoting the packages into
+// this repository from the source repository:
+//
+//   - Every package with a -dbginfo sibling in the source must bring it along
+//   - Every delta required by the source repository's policy must be built
+//   - The runtime dependencies of each package must be met in this repository
+//   - No package in this repository may have its dependencies broken
+//   - This repository mustn't already have a newer release
+//   - The source mustn't be held in this repository
+//   - None of the packages may be banned in this repository
+func (r *Repository) checkPromotion(db libdb.Database, pool *Pool, sourceRepo *Repository, promoted []*PoolEntry) ([]PromotionProblem, error) {
+	var problems []PromotionProblem
+
+	releases := make(map[string]int)
+	for _, p := range promoted {
+		releases[p.Meta.Name] = p.Meta.GetRelease()
+	}
+
+	for _, p := range promoted {
+		name := p.Meta.Name
+		release := p.Meta.GetRelease()
+
+		if r.IsHeld(p.Meta.Source.Name) {
+			problems = append(problems, PromotionProblem{
+				Package: p.Name,
+				Problem: fmt.Sprintf("source %s is held in '%s'", p.Meta.Source.Name, r.ID),
+			})
+		}
+		if !r.AllowsArchitecture(p.Meta.Architecture) {
+			problems = append(problems, PromotionProblem{
+				Package: p.Name,
+				Problem: fmt.Sprintf("built for %s, which '%s' doesn't allow", p.Meta.Architecture, r.ID),
+			})
+		}
+		if ban := r.bannedBy(p.Meta); ban != nil {
+			problem := fmt.Sprintf("banned in '%s' by %s", r.ID, ban.String())
+			if ban.Reason != "" {
+				problem += ": " + ban.Reason
+			}
+			problems = append(problems, PromotionProblem{
+				Package: p.Name,
+				Problem: problem,
+			})
+		}
+
+		if !strings.HasSuffix(name, "-dbginfo") {
+			sibling := name + "-dbginfo"
+			if _, err := sourceRepo.GetEntry(db, sibling); err == nil {
+				if _, ok := releases[sibling]; !ok {
+					problems = append(problems, PromotionProblem{
+						Package: p.Name,
+						Problem: fmt.Sprintf("%s for release %d is missing", sibling, release),
+					})
+				}
+			}
+		}
+
+		deltaProblems, err := sourceRepo.checkDeltas(db, pool, p)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, deltaProblems...)
+
+		if entry, err := r.GetEntry(db, name); err == nil && entry.Published != "" {
+			published, err := pool.GetEntry(db, entry.Published)
+			if err != nil {
+				return nil, err
+			}
+			if published.Meta.GetRelease() > release {
+				problems = append(problems, PromotionProblem{
+					Package: p.Name,
+					Problem: fmt.Sprintf("'%s' already has the newer release %d", r.ID, published.Meta.GetRelease()),
+				})
+			}
+		}
+
+		for _, dep := range runtimeDependencies(p.Meta) {
+			depRelease, ok := releases[dep.Name]
+			if !ok {
+				entry, err := r.GetEntry(db, dep.Name)
+				if err != nil || entry.Published == "" {
+					problems = append(problems, PromotionProblem{
+						Package: p.Name,
+						Problem: fmt.Sprintf("dependency %s is not in '%s'", dep.Name, r.ID),
+					})
+					continue
+				}
+				published, err := pool.GetEntry(db, entry.Published)
+				if err != nil {
+					return nil, err
+				}
+				depRelease = published.Meta.GetRelease()
+			}
+			if !releaseSatisfies(&dep, depRelease) {
+				problems = append(problems, PromotionProblem{
+					Package: p.Name,
+					Problem: fmt.Sprintf("dependency %s isn't met by release %d", describeDependency(&dep), depRelease),
+				})
+			}
+		}
+	}
+
+	// Now make sure nothing already here would be broken by the new releases
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if _, ok := releases[entry.Name]; ok || entry.Published == "" {
+			continue
+		}
+		published, err := pool.GetEntry(db, entry.Published)
+		if err != nil {
+			return nil, err
+		}
+		for _, dep := range runtimeDependencies(published.Meta) {
+			release, ok := releases[dep.Name]
+			if !ok || releaseSatisfies(&dep, release) {
+				continue
+			}
+			problems = append(problems, PromotionProblem{
+				Package: published.Name,
+				Problem: fmt.Sprintf("dependency %s would be broken by release %d", describeDependency(&dep), release),
+			})
+		}
+	}
+
+	return problems, nil
+}
+
+// promotedEntries returns the pool entries for the source & release within the
+// source repository
+func (r *Repository) promotedEntries(db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string, release int) ([]string, []*PoolEntry, error) {
+	if r.ID == sourceRepo.ID {
+		return nil, nil, errors.New("cannot promote a repository into itself")
+	}
+	if release < 1 {
+		return nil, nil, errors.New("a specific release must be promoted")
+	}
+	ids, err := sourceRepo.getSourceIDs(db, pool, sourceID, release)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil, errors.New("no matching sources found")
+	}
+	entries, err := pool.GetEntries(db, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ids, entries, nil
+}
+
+// CheckPromotion will return a PromotionError if the source & release in the
+// source repository may not be promoted into this repository
+func (r *Repository) CheckPromotion(db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string, release int) error {
+	_, entries, err := r.promotedEntries(db, pool, sourceRepo, sourceID, release)
+	if err != nil {
+		return err
+	}
+	problems, err := r.checkPromotion(db, pool, sourceRepo, entries)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return &PromotionError{Problems: problems}
+	}
+	return nil
+}
+
+// PromoteFrom will copy every package built from the source & release in the
+// source repository into this repository, in a single transaction, as long
+// as the policy checks pass. The names of the promoted packages are returned.
+func (r *Repository) PromoteFrom(logger *log.Entry, db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string, release int) ([]string, error) {
+	// Don't allow the source to change between checking and copying
+	sourceRepo.insertMut.Lock()
+	defer sourceRepo.insertMut.Unlock()
+
+	ids, entries, err := r.promotedEntries(db, pool, sourceRepo, sourceID, release)
+	if err != nil {
+		return nil, err
+	}
+	problems, err := r.checkPromotion(db, pool, sourceRepo, entries)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &PromotionError{Problems: problems}
+	}
+
+	if err = r.RefPackages(logger, db, pool, ids); err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Meta.Name)
+	}
+	return names, nil
+}
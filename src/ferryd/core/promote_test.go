@@ -0,0 +1,168 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libeopkg"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePromotePackage will write a build of the search test package with the
+// given name, source, release and runtime dependencies into dir
+func writePromotePackage(t *testing.T, dir, name, source string, release int, deps ...libeopkg.Dependency) string {
+	pkg, err := libeopkg.Open(searchTestPackage)
+	if err != nil {
+		t.Fatalf("Failed to open package: %v", err)
+	}
+	defer pkg.Close()
+	if err = pkg.ReadAll(); err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+
+	pkg.Meta.Package.Name = name
+	pkg.Meta.Package.Source.Name = source
+	pkg.Meta.Package.History[0].Release = release
+	pkg.Meta.Package.RuntimeDependencies = &deps
+	outPath := filepath.Join(dir, fmt.Sprintf("%s-2.7.1-%d-1-x86_64.eopkg", name, release))
+
+	pw, err := libeopkg.NewPackageWriter(outPath)
+	if err != nil {
+		t.Fatalf("Failed to create package writer: %v", err)
+	}
+	defer pw.Close()
+	if err = pw.WriteMetadata(pkg.Meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err = pw.WriteFiles(pkg.Files); err != nil {
+		t.Fatalf("Failed to write files: %v", err)
+	}
+	if err = pw.CopyFile(pkg.FindFile("install.tar.xz")); err != nil {
+		t.Fatalf("Failed to copy install.tar.xz: %v", err)
+	}
+	if err = pw.Commit(); err != nil {
+		t.Fatalf("Failed to commit package: %v", err)
+	}
+	return outPath
+}
+
+// expectPromotionProblems will ensure the promotion was refused for exactly
+// the expected reasons
+func expectPromotionProblems(t *testing.T, err error, expected ...string) {
+	perr, ok := err.(*PromotionError)
+	if !ok {
+		t.Fatalf("Expected a PromotionError, got %v", err)
+	}
+	if len(perr.Problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got: %v", len(expected), perr)
+	}
+	for i, problem := range perr.Problems {
+		if !strings.Contains(problem.Problem, expected[i]) {
+			t.Fatalf("Expected problem %d to mention '%s', got: %v", i, expected[i], perr)
+		}
+	}
+}
+
+func TestPromoteSource(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	for _, repoID := range []string{"staging", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+
+	lib := libeopkg.Dependency{Name: "lib"}
+	staging := []string{
+		writePromotePackage(t, dir, "app", "app", 1, lib),
+		writePromotePackage(t, dir, "app-dbginfo", "app", 1),
+		writePromotePackage(t, dir, "app", "app", 2, lib),
+	}
+	if err := manager.AddPackages("staging", staging, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+
+	// Everything is wrong to begin with
+	err = manager.CheckPromotion("staging", "stable", "app", 2)
+	expectPromotionProblems(t, err, "app-dbginfo for release 2 is missing", "delta from release 1", "dependency lib is not in")
+
+	// Fix them up, but leave a package in stable which can't take release 2
+	dbginfo := writePromotePackage(t, dir, "app-dbginfo", "app", 2)
+	if err := manager.AddPackages("staging", []string{dbginfo}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	for _, name := range []string{"app", "app-dbginfo"} {
+		pkgs, err := manager.GetPackages("staging", name)
+		if err != nil || len(pkgs) != 2 {
+			t.Fatalf("Failed to get packages: %v", err)
+		}
+		if pkgs[0].GetRelease() > pkgs[1].GetRelease() {
+			pkgs[0], pkgs[1] = pkgs[1], pkgs[0]
+		}
+		if err := manager.MarkDeltaFailed(libeopkg.ComputeDeltaName(pkgs[0], pkgs[1]), &DeltaInformation{}); err != nil {
+			t.Fatalf("Failed to mark delta failed: %v", err)
+		}
+	}
+	stable := []string{
+		writePromotePackage(t, dir, "lib", "lib", 1),
+		writePromotePackage(t, dir, "tool", "tool", 1, libeopkg.Dependency{Name: "app", ReleaseTo: 1}),
+	}
+	if err := manager.AddPackages("stable", stable, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+	_, err = manager.PromoteSource("staging", "stable", "app", 2, true)
+	expectPromotionProblems(t, err, "dependency app (release <= 1) would be broken by release 2")
+
+	if err := manager.RemoveSource("stable", "tool", 1); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	names, err := manager.PromoteSource("staging", "stable", "app", 2, true)
+	if err != nil {
+		t.Fatalf("Failed to promote source: %v", err)
+	}
+	if len(names) != 2 {
+		t.Fatalf("Expected 2 packages to be promoted, got %v", names)
+	}
+
+	info, err := manager.GetPackageInfo("stable", "app-dbginfo")
+	if err != nil {
+		t.Fatalf("Failed to get package info: %v", err)
+	}
+	if info.Published == nil || info.Published.Meta.GetRelease() != 2 {
+		t.Fatalf("Release 2 should be published in stable: %+v", info.Published)
+	}
+
+	// Moving takes it out of staging, leaving release 1 published
+	info, err = manager.GetPackageInfo("staging", "app")
+	if err != nil {
+		t.Fatalf("Failed to get package info: %v", err)
+	}
+	if len(info.Available) != 1 || info.Published.Meta.GetRelease() != 1 {
+		t.Fatalf("Only release 1 should remain in staging: %+v", info.Available)
+	}
+
+	if _, err := manager.PromoteSource("staging", "stable", "app", 2, false); err == nil {
+		t.Fatalf("Promoting a missing release should fail")
+	}
+}
@@ -0,0 +1,513 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"libdb"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DatabaseBucketPublish is the root bucket for the sync state of each
+	// publish target, with a sub-bucket for each target
+	DatabaseBucketPublish = "publish"
+
+	// DatabaseBucketPublishObjects records what has been uploaded to each
+	// HTTP publish target, with sub-buckets for each target and repository
+	DatabaseBucketPublishObjects = "publishObjects"
+
+	// PublishRsync pushes with rsync, usually over ssh
+	PublishRsync = "rsync"
+
+	// PublishHTTP pushes with HTTP PUT and DELETE requests, i.e. to a WebDAV
+	// server
+	PublishHTTP = "http"
+
+	// DefaultPublishSSH is the remote shell used by rsync targets
+	DefaultPublishSSH = "ssh -o BatchMode=yes -o ConnectTimeout=30"
+
+	// publishRetryMin is how long we wait before retrying a failed push,
+	// doubling with every consecutive failure up to publishRetryMax
+	publishRetryMin = 30 * time.Second
+	publishRetryMax = time.Hour
+)
+
+// PublishTargetConfig describes a downstream mirror which every repository is
+// pushed to after it has been indexed
+type PublishTargetConfig struct {
+	Name  string
+	Type  string   // Either PublishRsync or PublishHTTP
+	URL   string   // rsync destination, i.e. "mirror@host:/srv/solus", or the base URL for PUT requests
+	Token string   // Bearer token sent to HTTP targets
+	SSH   string   // Remote shell for rsync, defaults to DefaultPublishSSH
+	Repos []string // Only push these repositories, or every one if empty
+}
+
+// A PublishTarget pushes the files of a repository tree to a mirror. Packages
+// and deltas are always pushed before the index, and files are only removed
+// from the mirror once the new index is in place.
+type PublishTarget struct {
+	name   string
+	repos  map[string]bool
+	pusher publishPusher
+}
+
+// publishPusher is implemented by each type of publish target
+type publishPusher interface {
+	push(ctx context.Context, logger *log.Entry, db libdb.Database, repo *Repository, name string) error
+	String() string
+}
+
+// A PublishState is the sync state of one repository on a publish target
+type PublishState struct {
+	Target      string
+	Repo        string
+	Pending     bool      // The repository has changed since it was last pushed
+	Indexed     time.Time // When the repository was last indexed
+	Synced      time.Time // When the last push succeeded
+	Failures    int       // Consecutive failed pushes
+	Error       string    // Why the last push failed
+	NextAttempt time.Time // Don't retry before this time
+}
+
+// NewPublishTarget will validate the configuration and return a new target
+func NewPublishTarget(config *PublishTargetConfig) (*PublishTarget, error) {
+	if config.Name == "" || strings.Contains(config.Name, "/") {
+		return nil, fmt.Errorf("invalid publish target name: '%s'", config.Name)
+	}
+	if config.URL == "" {
+		return nil, fmt.Errorf("publish target %s has no URL", config.Name)
+	}
+
+	t := &PublishTarget{
+		name:  config.Name,
+		repos: make(map[string]bool),
+	}
+	for _, id := range config.Repos {
+		t.repos[id] = true
+	}
+
+	switch config.Type {
+	case PublishRsync:
+		ssh := config.SSH
+		if ssh == "" {
+			ssh = DefaultPublishSSH
+		}
+		t.pusher = &rsyncPusher{
+			dest: strings.TrimSuffix(config.URL, "/"),
+			ssh:  ssh,
+		}
+	case PublishHTTP:
+		store, err := NewHTTPStore(config.URL, config.Token)
+		if err != nil {
+			return nil, fmt.Errorf("publish target %s: %v", config.Name, err)
+		}
+		t.pusher = &httpPusher{store: store}
+	default:
+		return nil, fmt.Errorf("publish target %s has unknown type: '%s'", config.Name, config.Type)
+	}
+	return t, nil
+}
+
+// Name returns the unique name of the target
+func (t *PublishTarget) Name() string {
+	return t.name
+}
+
+// String will describe where the target pushes to
+func (t *PublishTarget) String() string {
+	return t.pusher.String()
+}
+
+// Publishes determines if the repository should be pushed to this target
+func (t *PublishTarget) Publishes(repoID string) bool {
+	return len(t.repos) == 0 || t.repos[repoID]
+}
+
+// publishRetryDelay returns how long to wait after the given number of
+// consecutive failures
+func publishRetryDelay(failures int) time.Duration {
+	delay := publishRetryMin
+	for i := 1; i < failures && delay < publishRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > publishRetryMax {
+		delay = publishRetryMax
+	}
+	return delay
+}
+
+// publishBucket returns the bucket holding the sync state for the target
+func publishBucket(db libdb.Database, target string) libdb.Database {
+	return db.Bucket([]byte(DatabaseBucketPublish)).Bucket([]byte(target))
+}
+
+// publishObjectsBucket returns the bucket recording the uploads of the
+// repository to an HTTP target
+func publishObjectsBucket(db libdb.Database, target, repoID string) libdb.Database {
+	return db.Bucket([]byte(DatabaseBucketPublishObjects)).Bucket([]byte(target)).Bucket([]byte(repoID))
+}
+
+// getPublishState will return the sync state of the repository on the
+// target, which is empty if it has never been pushed
+func getPublishState(db libdb.Database, target, repoID string) (*PublishState, error) {
+	state := &PublishState{}
+	err := publishBucket(db, target).GetObject([]byte(repoID), state)
+	if err == libdb.ErrNotFound {
+		return &PublishState{Target: target, Repo: repoID}, nil
+	}
+	return state, err
+}
+
+// putPublishState will store the sync state
+func putPublishState(db libdb.Database, state *PublishState) error {
+	return publishBucket(db, state.Target).PutObject([]byte(state.Repo), state)
+}
+
+// A Publisher keeps track of the publish targets, which may be replaced at
+// any time
+type Publisher struct {
+	targets []*PublishTarget
+	pending func() // Called whenever a push becomes due
+	mut     *sync.RWMutex
+}
+
+// NewPublisher will return a Publisher without any targets
+func NewPublisher() *Publisher {
+	return &Publisher{
+		mut: &sync.RWMutex{},
+	}
+}
+
+// SetTargets will replace the publish targets
+func (p *Publisher) SetTargets(targets []*PublishTarget) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.targets = targets
+}
+
+// Targets returns the current publish targets
+func (p *Publisher) Targets() []*PublishTarget {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.targets
+}
+
+// SetPendingFunc will set the function called whenever a repository has
+// been indexed and needs pushing
+func (p *Publisher) SetPendingFunc(f func()) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.pending = f
+}
+
+// target will return the named target, if it is configured
+func (p *Publisher) target(name string) *PublishTarget {
+	for _, t := range p.Targets() {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// markPending will record that the repository has been indexed, and must be
+// pushed to each target that publishes it
+func (p *Publisher) markPending(db libdb.Database, repoID string) error {
+	targets := p.Targets()
+	now := time.Now().UTC()
+	marked := false
+	for _, t := range targets {
+		if !t.Publishes(repoID) {
+			continue
+		}
+		state, err := getPublishState(db, t.name, repoID)
+		if err != nil {
+			return err
+		}
+		state.Pending = true
+		state.Indexed = now
+		if err := putPublishState(db, state); err != nil {
+			return err
+		}
+		marked = true
+	}
+
+	p.mut.RLock()
+	pending := p.pending
+	p.mut.RUnlock()
+	if marked && pending != nil {
+		pending()
+	}
+	return nil
+}
+
+// States will return the sync state of every repository on each of the
+// current targets
+func (p *Publisher) States(db libdb.Database) ([]*PublishState, error) {
+	var states []*PublishState
+	for _, t := range p.Targets() {
+		bucket := publishBucket(db, t.name)
+		err := bucket.ForEach(func(k, v []byte) error {
+			state := &PublishState{}
+			if err := bucket.Decode(v, state); err != nil {
+				return err
+			}
+			states = append(states, state)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return states, nil
+}
+
+// Due will return every pending push which may be attempted now
+func (p *Publisher) Due(db libdb.Database) ([]*PublishState, error) {
+	states, err := p.States(db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	var due []*PublishState
+	for _, state := range states {
+		if state.Pending && !now.Before(state.NextAttempt) {
+			due = append(due, state)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Indexed.Before(due[j].Indexed)
+	})
+	return due, nil
+}
+
+// Push will push the repository to the named target, and record the outcome.
+// Should the repository be indexed again while we push, it remains pending.
+func (p *Publisher) Push(ctx context.Context, logger *log.Entry, db libdb.Database, target string, repo *Repository) (*PublishState, error) {
+	t := p.target(target)
+	if t == nil {
+		return nil, fmt.Errorf("unknown publish target: %s", target)
+	}
+	started := time.Now().UTC()
+	pushErr := t.pusher.push(ctx, logger, db, repo, t.name)
+
+	state, err := getPublishState(db, t.name, repo.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pushErr != nil {
+		state.Failures++
+		state.Error = pushErr.Error()
+		state.NextAttempt = time.Now().UTC().Add(publishRetryDelay(state.Failures))
+	} else {
+		state.Pending = state.Indexed.After(started)
+		state.Synced = time.Now().UTC()
+		state.Failures = 0
+		state.Error = ""
+		state.NextAttempt = time.Time{}
+	}
+	if err := putPublishState(db, state); err != nil {
+		return nil, err
+	}
+	return state, pushErr
+}
+
+// forgetRepo will drop the sync state of the repository on each target.
+// Nothing is removed from the mirrors themselves.
+func (p *Publisher) forgetRepo(logger *log.Entry, db libdb.Database, repoID string) error {
+	for _, t := range p.Targets() {
+		bucket := publishBucket(db, t.name)
+		has, err := bucket.HasObject([]byte(repoID))
+		if err != nil {
+			return err
+		}
+		if has {
+			if err := bucket.DeleteObject([]byte(repoID)); err != nil {
+				return err
+			}
+		}
+		if err := forgetObjects(logger, publishObjectsBucket(db, t.name, repoID), nil, repoID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rsyncPusher pushes each repository tree to "<dest>/<repo>/" with rsync
+type rsyncPusher struct {
+	dest string
+	ssh  string
+}
+
+// String returns the destination of the pushes
+func (r *rsyncPusher) String() string {
+	return r.dest
+}
+
+// push will rsync the tree in two passes, so that the mirror only sees the new
+// index once every file it references is in place. Versioned indexes are
+// sent with the first pass, but not the link publishing them.
+func (r *rsyncPusher) push(ctx context.Context, logger *log.Entry, db libdb.Database, repo *Repository, name string) error {
+	root, err := filepath.EvalSymlinks(repo.path)
+	if err != nil {
+		return err
+	}
+	common := []string{
+		"--recursive",
+		"--links",
+		"--times",
+		"--timeout=300",
+		"--exclude=/*.new*",
+		"-e", r.ssh,
+	}
+	src := root + "/"
+	dest := r.dest + "/" + repo.ID + "/"
+
+	passes := [][]string{
+		append(append([]string{}, common...), "--exclude=/eopkg-index.*", "--exclude=/"+IndexPathComponent+"/"+indexCurrentLink, src, dest),
+		append(append([]string{}, common...), "--delete-after", "--delay-updates", src, dest),
+	}
+	for _, args := range passes {
+		out, err := exec.CommandContext(ctx, "rsync", args...).CombinedOutput()
+		if err != nil {
+			if msg := strings.TrimSpace(string(out)); msg != "" {
+				lines := strings.Split(msg, "\n")
+				return fmt.Errorf("rsync to %s failed: %v: %s", dest, err, lines[len(lines)-1])
+			}
+			return fmt.Errorf("rsync to %s failed: %v", dest, err)
+		}
+	}
+	return nil
+}
+
+// httpPusher pushes each repository tree to an HTTPStore
+type httpPusher struct {
+	store *HTTPStore
+}
+
+// String returns the base URL of the store
+func (h *httpPusher) String() string {
+	return h.store.String()
+}
+
+// push will upload the changes to the tree since the last push
+func (h *httpPusher) push(ctx context.Context, logger *log.Entry, db libdb.Database, repo *Repository, name string) error {
+	store := *h.store
+	store.ctx = ctx
+	_, err := repo.publishObjects(logger, publishObjectsBucket(db, name, repo.ID), &store)
+	return err
+}
+
+// An HTTPStore is an ObjectStore on a plain HTTP server accepting PUT and
+// DELETE requests, such as a WebDAV share
+type HTTPStore struct {
+	base   *url.URL
+	token  string
+	client *http.Client
+	ctx    context.Context
+}
+
+// NewHTTPStore will return a new store for the base URL. Credentials may be
+// given in the URL for basic authentication, or as a bearer token.
+func NewHTTPStore(baseURL, token string) (*HTTPStore, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", baseURL)
+	}
+	return &HTTPStore{
+		base:   base,
+		token:  token,
+		client: &http.Client{},
+		ctx:    context.Background(),
+	}, nil
+}
+
+// String returns the base URL, without any credentials
+func (h *HTTPStore) String() string {
+	u := *h.base
+	u.User = nil
+	return u.String()
+}
+
+// do will send the request to the key, returning an error for any status
+// other than those allowed
+func (h *HTTPStore) do(method, key string, body io.Reader, size int64, allowed ...int) error {
+	u := *h.base
+	u.Path = path.Join("/", u.Path, key)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(h.ctx)
+	if body != nil {
+		req.ContentLength = size
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	for _, status := range allowed {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	return errors.New(resp.Status)
+}
+
+// Put will upload the local file to the key
+func (h *HTTPStore) Put(key, localPath string, size int64, sha256 string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return h.do(http.MethodPut, key, f, size)
+}
+
+// Delete will remove the key, which may already be gone
+func (h *HTTPStore) Delete(key string) error {
+	return h.do(http.MethodDelete, key, nil, 0, http.StatusNotFound)
+}
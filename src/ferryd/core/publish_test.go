@@ -0,0 +1,224 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// publishTestMirror is an HTTP server accepting PUT and DELETE requests
+type publishTestMirror struct {
+	files map[string][]byte
+	fail  bool
+	lock  sync.Mutex
+}
+
+func (m *publishTestMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.fail || r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.files[r.URL.Path] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := m.files[r.URL.Path]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(m.files, r.URL.Path)
+	}
+}
+
+func TestPublishTargets(t *testing.T) {
+	for _, config := range []*PublishTargetConfig{
+		{Name: "", Type: PublishHTTP, URL: "http://localhost"},
+		{Name: "a", Type: "ftp", URL: "ftp://localhost"},
+		{Name: "a", Type: PublishHTTP, URL: "localhost"},
+		{Name: "a", Type: PublishRsync},
+	} {
+		if _, err := NewPublishTarget(config); err == nil {
+			t.Fatalf("Invalid target should be refused: %+v", config)
+		}
+	}
+
+	if publishRetryDelay(1) != publishRetryMin || publishRetryDelay(2) != 2*publishRetryMin {
+		t.Fatalf("Retries should back off from %v", publishRetryMin)
+	}
+	if publishRetryDelay(100) != publishRetryMax {
+		t.Fatalf("Retries should never wait longer than %v", publishRetryMax)
+	}
+}
+
+func TestPublishHTTP(t *testing.T) {
+	mirror := &publishTestMirror{files: make(map[string][]byte)}
+	server := httptest.NewServer(mirror)
+	defer server.Close()
+
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	target, err := NewPublishTarget(&PublishTargetConfig{
+		Name:  "mirror",
+		Type:  PublishHTTP,
+		URL:   server.URL + "/solus",
+		Token: "secret",
+		Repos: []string{"unstable"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	manager.SetPublishTargets([]*PublishTarget{target})
+	woken := 0
+	manager.SetPublishPendingFunc(func() { woken++ })
+
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+		if err := manager.AddPackages(repoID, []string{searchTestPackage}, false, nil); err != nil {
+			t.Fatalf("Failed to add package: %v", err)
+		}
+	}
+	if woken == 0 {
+		t.Fatalf("Publisher wasn't told about the index")
+	}
+
+	// Only the listed repository is pushed
+	due, err := manager.DuePublishes()
+	if err != nil {
+		t.Fatalf("Failed to get due pushes: %v", err)
+	}
+	if len(due) != 1 || due[0].Target != "mirror" || due[0].Repo != "unstable" {
+		t.Fatalf("Wrong pushes due: %+v", due)
+	}
+
+	// A failure is recorded and retried later
+	mirror.fail = true
+	state, err := manager.Publish(context.Background(), "mirror", "unstable")
+	if err == nil || state == nil || state.Failures != 1 || !state.Pending {
+		t.Fatalf("Failed push wasn't recorded: %+v: %v", state, err)
+	}
+	if due, _ = manager.DuePublishes(); len(due) != 0 {
+		t.Fatalf("Failed push shouldn't be retried straight away")
+	}
+
+	mirror.fail = false
+	state, err = manager.Publish(context.Background(), "mirror", "unstable")
+	if err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if state.Pending || state.Failures != 0 || state.Synced.IsZero() {
+		t.Fatalf("Successful push wasn't recorded: %+v", state)
+	}
+	pkgPath := "/solus/unstable/n/nano/" + filepath.Base(searchTestPackage)
+	if _, ok := mirror.files[pkgPath]; !ok {
+		t.Fatalf("Package wasn't pushed to %s", pkgPath)
+	}
+	if _, ok := mirror.files["/solus/unstable/eopkg-index.xml.xz"]; !ok {
+		t.Fatalf("Index wasn't pushed")
+	}
+
+	// Removals are pushed after the next index
+	if err := manager.RemoveSource("unstable", "nano", 63); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if _, err = manager.Publish(context.Background(), "mirror", "unstable"); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if _, ok := mirror.files[pkgPath]; ok {
+		t.Fatalf("Removed package should be deleted from the mirror")
+	}
+
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if states, _ := manager.GetPublishStates(); len(states) != 0 {
+		t.Fatalf("Deleted repository should be forgotten: %+v", states)
+	}
+}
+
+func TestPublishRsync(t *testing.T) {
+	if _, err := exec.LookPath("rsync"); err != nil {
+		t.Skip("rsync is not installed")
+	}
+	dir, err := filepath.Abs(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to resolve test area: %v", err)
+	}
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	dest := filepath.Join(dir, "mirror")
+	target, err := NewPublishTarget(&PublishTargetConfig{
+		Name: "local",
+		Type: PublishRsync,
+		URL:  dest,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	manager.SetPublishTargets([]*PublishTarget{target})
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if _, err := manager.Publish(context.Background(), "local", "unstable"); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	for _, p := range []string{"eopkg-index.xml.xz", "n/nano/" + filepath.Base(searchTestPackage)} {
+		if !PathExists(filepath.Join(dest, "unstable", p)) {
+			t.Fatalf("%s wasn't pushed", p)
+		}
+	}
+
+	// The mirror follows removals
+	if err := manager.RemoveSource("unstable", "nano", 63); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if _, err := manager.Publish(context.Background(), "local", "unstable"); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if PathExists(filepath.Join(dest, "unstable", "n", "nano", filepath.Base(searchTestPackage))) {
+		t.Fatalf("Removed package should be deleted from the mirror")
+	}
+}
@@ -0,0 +1,124 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"libdb"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// corruptTestDatabase will create a database holding the unstable repo, and
+// then corrupt it much as a crash mid-compaction would. The path of the
+// database and its damaged manifests are returned.
+func corruptTestDatabase(t *testing.T, area string) (string, []string) {
+	manager, err := NewManager(area)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	_, dbPath, err := libdb.ParseURI(manager.ctx.DatabaseURI(libdb.DefaultBackend))
+	if err != nil {
+		t.Fatalf("Failed to find database: %v", err)
+	}
+	manager.Close()
+
+	manifests, err := filepath.Glob(filepath.Join(dbPath, "MANIFEST-*"))
+	if err != nil || len(manifests) == 0 {
+		t.Fatalf("Failed to find the manifest: %v", err)
+	}
+	for _, manifest := range manifests {
+		if err := ioutil.WriteFile(manifest, []byte("not a manifest"), 00644); err != nil {
+			t.Fatalf("Failed to corrupt manifest: %v", err)
+		}
+	}
+	if _, err := NewManager(area); !libdb.IsCorrupted(err) {
+		t.Fatalf("Expected the database to be corrupted, got %v", err)
+	}
+	return dbPath, manifests
+}
+
+func TestRecoverDatabase(t *testing.T) {
+	area := initTestArea(t)
+	_, manifests := corruptTestDatabase(t, area)
+
+	backup, err := RecoverDatabase(area)
+	if err != nil {
+		t.Fatalf("Failed to recover database: %v", err)
+	}
+	if !PathExists(filepath.Join(backup, filepath.Base(manifests[0]))) {
+		t.Fatalf("The damaged database wasn't kept at %s", backup)
+	}
+	manager, err := NewManager(area)
+	if err != nil {
+		t.Fatalf("Failed to open recovered database: %v", err)
+	}
+	defer manager.Close()
+	if _, err := manager.GetRepo("unstable"); err != nil {
+		t.Fatalf("Repository was lost in recovery: %v", err)
+	}
+}
+
+func TestRecoverDatabaseFailed(t *testing.T) {
+	area := initTestArea(t)
+	dbPath, manifests := corruptTestDatabase(t, area)
+
+	// Holding the lock stops the database being repaired in place
+	lock, err := os.OpenFile(filepath.Join(dbPath, "LOCK"), os.O_RDWR|os.O_CREATE, 00644)
+	if err != nil {
+		t.Fatalf("Failed to open lock: %v", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatalf("Failed to take lock: %v", err)
+	}
+	if _, err := RecoverDatabase(area); err == nil {
+		t.Fatalf("Recovered a database that was locked")
+	}
+
+	// Nor can it be read as it is
+	if _, err := NewManagerReadOnlyWithStorage(area, "", nil); !libdb.IsCorrupted(err) {
+		t.Fatalf("Expected the read-only database to be corrupted, got %v", err)
+	}
+
+	// But a repaired copy of it can be
+	manager, err := NewManagerRecoveredWithStorage(area, "", nil)
+	if err != nil {
+		t.Fatalf("Failed to open a recovered copy: %v", err)
+	}
+	defer manager.Close()
+	if !manager.ReadOnly() {
+		t.Fatalf("Recovered copy of the database accepts writes")
+	}
+	if _, err := manager.GetRepo("unstable"); err != nil {
+		t.Fatalf("Repository missing from the recovered copy: %v", err)
+	}
+	if err := manager.CreateRepo("shannon"); err == nil {
+		t.Fatalf("Created a repository in the recovered copy")
+	}
+
+	// The damaged database is left as it was
+	data, err := ioutil.ReadFile(manifests[0])
+	if err != nil || string(data) != "not a manifest" {
+		t.Fatalf("Damaged database was changed: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"os"
+	"path/filepath"
+)
+
+// RelinkProgressFunc is called after each file of the repository is checked
+type RelinkProgressFunc func(done, total int)
+
+// RelinkResult describes what Relink found in a repository tree
+type RelinkResult struct {
+	Checked  int      // Files in the tree that were examined
+	Relinked int      // Files replaced by a link or copy of the pool's file
+	Failed   []string // IDs whose pool file is missing or corrupt
+}
+
+// verifyPoolFile will ensure the pool file matches the hash we recorded for
+// it, so that damage in the pool isn't spread to the repositories. Older
+// entries without a content hash are checked against the package sha1sum.
+func verifyPoolFile(path string, entry *PoolEntry) error {
+	want, hash := entry.ContentHash, FileSha256sum
+	if want == "" {
+		want, hash = entry.Meta.PackageHash, FileSha1sum
+	}
+	if want == "" {
+		return nil
+	}
+	got, err := hash(path)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%s has hash %s, expected %s", path, got, want)
+	}
+	return nil
+}
+
+// relinkFile will ensure the file in our tree is the pool's file, returning
+// true if it had to be replaced. Trees on the same filesystem as the pool
+// must share its inode, and copies elsewhere must match its hash.
+func (r *Repository) relinkFile(pool *Pool, entry *PoolEntry) (bool, error) {
+	source := pool.EntryPath(entry)
+	target := filepath.Join(r.path, entry.Meta.GetPathComponent(), entry.Name)
+
+	sourceInfo, err := os.Stat(source)
+	if err != nil {
+		return false, err
+	}
+	if targetInfo, err := os.Stat(target); err == nil {
+		if os.SameFile(sourceInfo, targetInfo) {
+			return false, nil
+		}
+		// Copies are fine so long as they're intact
+		if !sameDevice(source, filepath.Dir(target)) && verifyPoolFile(target, entry) == nil {
+			return false, nil
+		}
+	}
+
+	if err := verifyPoolFile(source, entry); err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 00755); err != nil {
+		return false, err
+	}
+	// Replace the file atomically so the tree is never missing it
+	tmp := target + ".relink"
+	os.Remove(tmp)
+	if err := LinkOrCopyFile(source, tmp, false); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// Relink will walk every package and delta in the repository, ensuring the
+// files in our tree are the ones currently in the pool. This repairs trees
+// still pointing at old inodes after the pool was moved to another layout
+// or filesystem. Files whose pool copy is missing or corrupt are left alone
+// and reported in the result.
+func (r *Repository) Relink(logger *log.Entry, db libdb.Database, pool *Pool, progress RelinkProgressFunc) (*RelinkResult, error) {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.Available...)
+		ids = append(ids, entry.Deltas...)
+	}
+
+	result := &RelinkResult{}
+	for i, id := range ids {
+		poolEntry, err := pool.GetEntry(db, id)
+		if err != nil {
+			return result, err
+		}
+		relinked, err := r.relinkFile(pool, poolEntry)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"repo":  r.ID,
+				"id":    id,
+				"error": err,
+			}).Error("Failed to relink file")
+			result.Failed = append(result.Failed, id)
+		} else if relinked {
+			result.Relinked++
+		}
+		result.Checked++
+		if progress != nil {
+			progress(i+1, len(ids))
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,115 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelinkRepo(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	id := filepath.Base(searchTestPackage)
+	entry, err := manager.pool.GetEntry(manager.db, id)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	poolPath := manager.pool.EntryPath(entry)
+	treePath := filepath.Join(repo.path, entry.Meta.GetPathComponent(), id)
+
+	sameFile := func() bool {
+		a, err := os.Stat(poolPath)
+		if err != nil {
+			t.Fatalf("Failed to stat pool file: %v", err)
+		}
+		b, err := os.Stat(treePath)
+		if err != nil {
+			t.Fatalf("Failed to stat tree file: %v", err)
+		}
+		return os.SameFile(a, b)
+	}
+
+	// Nothing to do for a healthy tree
+	result, err := manager.RelinkRepo("unstable", nil)
+	if err != nil {
+		t.Fatalf("Failed to relink repo: %v", err)
+	}
+	if result.Checked != 1 || result.Relinked != 0 || len(result.Failed) != 0 {
+		t.Fatalf("Expected a single healthy file, got %+v", result)
+	}
+
+	// A tree pointing at an old inode is relinked
+	if err := os.Remove(treePath); err != nil {
+		t.Fatalf("Failed to remove tree file: %v", err)
+	}
+	if err := CopyFile(poolPath, treePath); err != nil {
+		t.Fatalf("Failed to copy package: %v", err)
+	}
+	if sameFile() {
+		t.Fatalf("Copied file should have its own inode")
+	}
+	var calls int
+	result, err = manager.RelinkRepo("unstable", func(done, total int) {
+		calls++
+	})
+	if err != nil {
+		t.Fatalf("Failed to relink repo: %v", err)
+	}
+	if result.Relinked != 1 || calls != 1 || !sameFile() {
+		t.Fatalf("Expected the file to be relinked, got %+v", result)
+	}
+
+	// A corrupt pool file isn't spread to the tree
+	if err := os.Remove(treePath); err != nil {
+		t.Fatalf("Failed to remove tree file: %v", err)
+	}
+	if err := ioutil.WriteFile(poolPath+".new", []byte("corrupt"), 00644); err != nil {
+		t.Fatalf("Failed to write pool file: %v", err)
+	}
+	if err := os.Rename(poolPath+".new", poolPath); err != nil {
+		t.Fatalf("Failed to replace pool file: %v", err)
+	}
+	result, err = manager.RelinkRepo("unstable", nil)
+	if err != nil {
+		t.Fatalf("Failed to relink repo: %v", err)
+	}
+	if len(result.Failed) != 1 || result.Failed[0] != id || PathExists(treePath) {
+		t.Fatalf("Expected the corrupt file to be refused, got %+v", result)
+	}
+
+	if _, err := manager.RelinkRepo("missing", nil); err == nil {
+		t.Fatalf("Relinking an unknown repo should fail")
+	}
+}
@@ -0,0 +1,210 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"libdb"
+	"os"
+	"path/filepath"
+)
+
+// A ReplicaSource provides the files of a repository on the primary ferryd
+// being replicated
+type ReplicaSource interface {
+	// FetchPoolFile will write the pool file with the given ID to w
+	FetchPoolFile(id string, w io.Writer) error
+
+	// FetchAsset will return the content of the named asset
+	FetchAsset(name string) ([]byte, error)
+}
+
+// ReplicaResult describes what ReplicateRepo changed in our repository
+type ReplicaResult struct {
+	Fetched int   // Pool files downloaded from the primary
+	Bytes   int64 // Total size of the files downloaded
+	Added   int   // Packages and deltas linked into the repository
+	Removed int   // Packages and deltas no longer on the primary
+	Assets  int   // Assets which were replaced
+}
+
+// Changed will determine if the repository needs indexing again
+func (r *ReplicaResult) Changed() bool {
+	return r.Added > 0 || r.Removed > 0 || r.Assets > 0
+}
+
+// fetchReplicaFile will download the pool file into dir, ensuring it has the
+// hash the primary recorded for it
+func fetchReplicaFile(source ReplicaSource, entry *ArchivePoolEntry, dir string) error {
+	// The name comes from the primary, so it mustn't escape dir
+	if !validPoolFileName(entry.Name) {
+		return fmt.Errorf("invalid pool file from primary: %s", entry.Name)
+	}
+	path := filepath.Join(dir, entry.Name)
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	err = source.FetchPoolFile(entry.Name, io.MultiWriter(f, h))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %v", entry.Name, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); entry.ContentHash != "" && sum != entry.ContentHash {
+		return fmt.Errorf("%s from primary has hash %s, expected %s", entry.Name, sum, entry.ContentHash)
+	}
+	return nil
+}
+
+// replicate will make the repository hold exactly the entries of the
+// manifest. Files we didn't have in the pool must already be staged in dir.
+func (r *Repository) replicate(logger *log.Entry, db libdb.Database, pool *Pool, manifest *RepoArchive, dir string) (added, removed int, err error) {
+	files := make(map[string]*ArchivePoolEntry)
+	for _, entry := range manifest.Pool {
+		files[entry.Name] = entry
+	}
+	return r.syncEntries(logger, db, pool, manifest.Entries, func(id string) error {
+		path := filepath.Join(dir, id)
+		if !PathExists(path) {
+			return r.linkPackageInternal(db, pool, id)
+		}
+		entry, ok := files[id]
+		if !ok {
+			return fmt.Errorf("manifest has no file for %s", id)
+		}
+		r.insertMut.Lock()
+		defer r.insertMut.Unlock()
+		return r.importPoolFile(logger, db, pool, path, entry)
+	})
+}
+
+// replicateAssets will replace any asset which differs from the primary's.
+// Assets the primary doesn't have are left alone.
+func (r *Repository) replicateAssets(source ReplicaSource, names []string) (int, error) {
+	changed := 0
+	for _, name := range names {
+		data, err := source.FetchAsset(name)
+		if err != nil {
+			return changed, fmt.Errorf("failed to fetch asset %s: %v", name, err)
+		}
+		if have, err := r.GetAsset(name); err == nil && bytes.Equal(have, data) {
+			continue
+		}
+		if err := r.SetAsset(name, data); err != nil {
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// RepoManifest will describe every package, delta and asset of the
+// repository, so that a replica can find what it's missing
+func (m *Manager) RepoManifest(repoID string) (*RepoArchive, error) {
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.Manifest(m.db, m.pool)
+}
+
+// OpenPoolFile will open the file of the pool entry for reading
+func (m *Manager) OpenPoolFile(id string) (*os.File, error) {
+	entry, err := m.pool.GetEntry(m.db, id)
+	if err != nil {
+		return nil, notFoundf("No such pool entry '%s'", id)
+	}
+	return os.Open(m.pool.EntryPath(entry))
+}
+
+// ReplicateRepo will bring our copy of the repository in line with the
+// manifest of the primary, creating it if needed. Only the pool files we
+// don't already have are fetched from source. The repository settings are
+// left alone, and it's reindexed only if anything changed.
+func (m *Manager) ReplicateRepo(manifest *RepoArchive, source ReplicaSource) (*ReplicaResult, error) {
+	repoID := manifest.Repo
+	repo, err := m.GetRepo(repoID)
+	if err != nil {
+		if repo, err = m.repo.CreateRepo(m.db, repoID); err != nil {
+			return nil, err
+		}
+		m.emit(RepoCreated, repoID)
+	}
+
+	want := make(map[string]bool)
+	for _, entry := range manifest.Entries {
+		for _, id := range append(entry.Available, entry.Deltas...) {
+			want[id] = true
+		}
+	}
+	var missing []*ArchivePoolEntry
+	var needed int64
+	for _, entry := range manifest.Pool {
+		if !want[entry.Name] {
+			continue
+		}
+		if _, err := m.pool.GetEntry(m.db, entry.Name); err == nil {
+			continue
+		}
+		missing = append(missing, entry)
+		needed += entry.Size
+	}
+	if err := m.checkDisk(repo, needed); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(m.ctx.BaseDir, ImportPathComponent), 00755); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Join(m.ctx.BaseDir, ImportPathComponent), repoID+"-replica-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	result := &ReplicaResult{}
+	for _, entry := range missing {
+		if err := fetchReplicaFile(source, entry, dir); err != nil {
+			return result, err
+		}
+		result.Fetched++
+		result.Bytes += entry.Size
+	}
+
+	if result.Assets, err = repo.replicateAssets(source, manifest.Assets); err != nil {
+		return result, err
+	}
+	if result.Added, result.Removed, err = repo.replicate(m.logger, m.db, m.pool, manifest, dir); err != nil {
+		return result, err
+	}
+	if !result.Changed() {
+		return result, nil
+	}
+	if err = m.markDirty(repoID); err != nil {
+		return result, err
+	}
+	return result, m.Index(repoID)
+}
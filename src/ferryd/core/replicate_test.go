@@ -0,0 +1,139 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// managerSource serves a replica straight from the primary's manager
+type managerSource struct {
+	manager *Manager
+	repoID  string
+	fetched int
+}
+
+func (s *managerSource) FetchPoolFile(id string, w io.Writer) error {
+	f, err := s.manager.OpenPoolFile(id)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s.fetched++
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *managerSource) FetchAsset(name string) ([]byte, error) {
+	return s.manager.GetAsset(s.repoID, name)
+}
+
+func TestReplicateRepo(t *testing.T) {
+	dir := initTestArea(t)
+	for _, name := range []string{"primary", "replica"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 00755); err != nil {
+			t.Fatalf("Cannot mkdirs for test: %v", err)
+		}
+	}
+	primary, err := NewManager(filepath.Join(dir, "primary"))
+	if err != nil {
+		t.Fatalf("Failed to initialise primary: %v", err)
+	}
+	defer primary.Close()
+	replica, err := NewManager(filepath.Join(dir, "replica"))
+	if err != nil {
+		t.Fatalf("Failed to initialise replica: %v", err)
+	}
+	defer replica.Close()
+
+	if err := primary.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := primary.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	source := &managerSource{manager: primary, repoID: "unstable"}
+	manifest, err := primary.RepoManifest("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get manifest: %v", err)
+	}
+	result, err := replica.ReplicateRepo(manifest, source)
+	if err != nil {
+		t.Fatalf("Failed to replicate repo: %v", err)
+	}
+	if result.Fetched != 1 || result.Added != 1 || source.fetched != 1 {
+		t.Fatalf("Unexpected replication result: %+v", result)
+	}
+	if _, err := replica.GetPackageInfo("unstable", "nano"); err != nil {
+		t.Fatalf("Replicated package is missing: %v", err)
+	}
+
+	// Nothing is fetched again once we're in step
+	if result, err = replica.ReplicateRepo(manifest, source); err != nil {
+		t.Fatalf("Failed to replicate repo again: %v", err)
+	}
+	if result.Changed() || source.fetched != 1 {
+		t.Fatalf("Replicating an unchanged repo changed something: %+v", result)
+	}
+
+	// Removals on the primary are followed
+	if err := primary.RemoveSource("unstable", "nano", -1); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if manifest, err = primary.RepoManifest("unstable"); err != nil {
+		t.Fatalf("Failed to get manifest: %v", err)
+	}
+	if result, err = replica.ReplicateRepo(manifest, source); err != nil {
+		t.Fatalf("Failed to replicate removal: %v", err)
+	}
+	if result.Removed != 1 {
+		t.Fatalf("Expected the package to be removed: %+v", result)
+	}
+	if has, _ := replica.HasPackage("unstable", filepath.Base(searchTestPackage)); has {
+		t.Fatalf("Removed package is still in the replica")
+	}
+}
+
+func TestReplicaFileNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replica")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	staging := filepath.Join(dir, "staging")
+	if err := os.Mkdir(staging, 00755); err != nil {
+		t.Fatalf("Failed to create staging directory: %v", err)
+	}
+
+	source := &managerSource{}
+	for _, name := range []string{"", ".", "..", "../escaped", "/tmp/escaped", "sub/escaped"} {
+		if err := fetchReplicaFile(source, &ArchivePoolEntry{Name: name}, staging); err == nil {
+			t.Fatalf("Invalid pool file name accepted: '%s'", name)
+		}
+	}
+	if source.fetched != 0 {
+		t.Fatalf("Fetched files with invalid names: %d", source.fetched)
+	}
+	if PathExists(filepath.Join(dir, "escaped")) {
+		t.Fatalf("File was written outside of the staging directory")
+	}
+}
@@ -59,10 +59,24 @@ type RepositoryManager struct {
 	assetBase      string
 	deltaBase      string
 	deltaStageBase string
+	incomingBase   string
+	roots          map[string]string // Storage roots of repositories kept elsewhere
 
-	repoLock *sync.Mutex
+	repoLock *sync.Mutex  // Serialises creating, changing and deleting repos
+	defaults RepoSettings // New repositories start out with these, guarded by repoLock
 
-	repos map[string]*Repository // Cache all repositories.
+	repos      map[string]*Repository // Cache all repositories.
+	mutexes    map[string]*repoMutexes
+	generation uint64        // Bumped whenever a repository is invalidated
+	cacheLock  *sync.RWMutex // Protects the cache and mutexes
+}
+
+// repoMutexes are kept for every repository ID for the lifetime of the
+// manager, so that anyone still holding an invalidated Repository shares
+// them with the one that replaced it
+type repoMutexes struct {
+	insertMut *sync.Mutex
+	indexMut  *sync.Mutex
 }
 
 // A Repository is a simplistic representation of a exported repository
@@ -70,15 +84,40 @@ type RepositoryManager struct {
 type Repository struct {
 	ID             string                 // Name of this repository (unique)
 	path           string                 // Where this is on disk
+	treeLink       string                 // Symlink to path in the base directory, if stored elsewhere
 	assetPath      string                 // Where our assets are stored on disk
 	deltaPath      string                 // Where we'll produce deltas
 	deltaStagePath string                 // Where we'll stage final deltas
+	incomingPath   string                 // Where uploads for this repo alone land
 	dist           *libeopkg.Distribution // Distribution
 
+	DeltaPolicy    DeltaPolicy    // Which deltas to produce, stored with the repository
+	VerifyHashes   bool           // Check file hashes of imported packages
+	ConflictPolicy ConflictPolicy // What to do with duplicate release numbers
+	VerifyIndex    bool           // Validate the index before publishing it
+	Held           []string       // Sources frozen against pulls and promotion
+	Bans           []Ban          // Packages which may never enter the repository
+	Quota          int64          // Most bytes the packages and deltas may use, 0 for no limit
+	Architectures  []string       // Architectures packages may be built for, empty for any
+
+	ComponentIndexes bool // Emit an index fragment for each component too
+	VerifyDeltas     bool // Check new deltas really produce their target
+	VersionedIndexes bool // Publish each index as a new generation behind a symlink
+
+	// Only kept in the stored record, see updateStatus
+	LastIndex IndexStatus // How the last index went
+	Dirty     bool        // Packages changed since the last successful index
+
 	insertMut *sync.Mutex // Prevent parallel inserts
 	indexMut  *sync.Mutex // Indexing requires a special, separate lock
 }
 
+// IncomingPath returns the directory where uploads meant only for this
+// repository are dropped, alongside their transit manifests
+func (r *Repository) IncomingPath() string {
+	return r.incomingPath
+}
+
 // RepoEntry is the basic repository storage unit, and details what packages
 // are exported in the index.
 type RepoEntry struct {
@@ -95,20 +134,28 @@ func (r *RepositoryManager) Init(ctx *Context, db libdb.Database) error {
 	r.assetBase = filepath.Join(ctx.BaseDir, AssetPathComponent)
 	r.deltaBase = filepath.Join(ctx.BaseDir, DeltaPathComponent)
 	r.deltaStageBase = filepath.Join(ctx.BaseDir, DeltaStagePathComponent)
+	r.incomingBase = filepath.Join(ctx.BaseDir, IncomingPathComponent)
+	r.roots = ctx.RepoRoots
 	r.repoLock = &sync.Mutex{}
 	r.repos = make(map[string]*Repository)
+	r.mutexes = make(map[string]*repoMutexes)
+	r.cacheLock = &sync.RWMutex{}
 
 	paths := []string{
 		r.repoBase,
 		r.assetBase,
 		r.deltaBase,
 	}
+	for _, root := range r.roots {
+		paths = append(paths, filepath.Join(root, RepoPathComponent))
+	}
 	// Ensure we have all paths
 	for _, p := range paths {
 		if err := os.MkdirAll(p, 00755); err != nil {
 			return err
 		}
 	}
+	r.warnCrossDevice(ctx.PoolDir)
 	return nil
 }
 
@@ -121,14 +168,25 @@ func (r *RepositoryManager) Close() {}
 // This ensures the first time we GetRepo on an existing repo, we ensure that
 // we actually have all support paths too.
 func (r *RepositoryManager) bakeRepo(id string) (*Repository, error) {
+	mutexes := r.getMutexes(id)
 	repository := &Repository{
 		ID:             id,
 		path:           filepath.Join(r.repoBase, id),
 		assetPath:      filepath.Join(r.assetBase, id),
 		deltaPath:      filepath.Join(r.deltaBase, id),
 		deltaStagePath: filepath.Join(r.deltaStageBase, id),
-		indexMut:       &sync.Mutex{},
-		insertMut:      &sync.Mutex{},
+		incomingPath:   filepath.Join(r.incomingBase, id),
+		indexMut:       mutexes.indexMut,
+		insertMut:      mutexes.insertMut,
+	}
+
+	// Keep the tree in its own root, linked from where it'd usually be
+	if root, ok := r.roots[id]; ok {
+		repository.treeLink = repository.path
+		repository.path = filepath.Join(root, RepoPathComponent, id)
+		if err := linkRepoTree(repository.treeLink, repository.path); err != nil {
+			return nil, err
+		}
 	}
 
 	paths := []string{
@@ -136,6 +194,7 @@ func (r *RepositoryManager) bakeRepo(id string) (*Repository, error) {
 		repository.assetPath,
 		repository.deltaPath,
 		repository.deltaStagePath,
+		repository.incomingPath,
 	}
 
 	// Create all required paths
@@ -151,6 +210,47 @@ func (r *RepositoryManager) bakeRepo(id string) (*Repository, error) {
 	return repository, nil
 }
 
+// getMutexes will return the mutexes for the repository ID
+func (r *RepositoryManager) getMutexes(id string) *repoMutexes {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	mutexes, ok := r.mutexes[id]
+	if !ok {
+		mutexes = &repoMutexes{
+			insertMut: &sync.Mutex{},
+			indexMut:  &sync.Mutex{},
+		}
+		r.mutexes[id] = mutexes
+	}
+	return mutexes
+}
+
+// cacheRepo will store the repository for later, unless the cache was
+// invalidated after generation, as it may have been loaded from a stale
+// record. Whichever repository ends up cached is returned.
+func (r *RepositoryManager) cacheRepo(repo *Repository, generation uint64) *Repository {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	if generation != r.generation {
+		return repo
+	}
+	if cached, ok := r.repos[repo.ID]; ok {
+		return cached
+	}
+	r.repos[repo.ID] = repo
+	return repo
+}
+
+// Invalidate will drop the cached repository, so that it is loaded from the
+// database again. This must be called after anything changes the stored
+// repository record, once the change is committed.
+func (r *RepositoryManager) Invalidate(id string) {
+	r.cacheLock.Lock()
+	defer r.cacheLock.Unlock()
+	delete(r.repos, id)
+	r.generation++
+}
+
 // GetRepos will return a copy of the repositores in our database
 func (r *RepositoryManager) GetRepos(db libdb.Database) ([]*Repository, error) {
 	var ret []*Repository
@@ -170,29 +270,174 @@ func (r *RepositoryManager) GetRepos(db libdb.Database) ([]*Repository, error) {
 	return ret, nil
 }
 
+// cachedRepo will return the cached repository, and the cache generation
+func (r *RepositoryManager) cachedRepo(id string) (*Repository, uint64, bool) {
+	r.cacheLock.RLock()
+	defer r.cacheLock.RUnlock()
+	repo, ok := r.repos[id]
+	return repo, r.generation, ok
+}
+
 // GetRepo will attempt to get the named repo if it exists, otherwise
 // return an error. This is a transactional helper to make the API simpler
 func (r *RepositoryManager) GetRepo(db libdb.Database, id string) (*Repository, error) {
+	if repo, _, ok := r.cachedRepo(id); ok {
+		return repo, nil
+	}
+
+	// Loading will create the repo paths, so it mustn't race with deletion
+	r.repoLock.Lock()
+	defer r.repoLock.Unlock()
+	return r.getRepoLocked(db, id)
+}
+
+// getRepoLocked is GetRepo for callers already holding the repoLock
+func (r *RepositoryManager) getRepoLocked(db libdb.Database, id string) (*Repository, error) {
 	// Cache each repository.
-	if repo, ok := r.repos[id]; ok {
+	repo, generation, ok := r.cachedRepo(id)
+	if ok {
 		return repo, nil
 	}
 
 	var rTmp Repository
 	rootBucket := db.Bucket([]byte(DatabaseBucketRepo))
 	if err := rootBucket.GetObject([]byte(id), &rTmp); err != nil {
-		return nil, fmt.Errorf("The specified repository '%s' does not exist", id)
+		return nil, notFoundf("The specified repository '%s' does not exist", id)
 	}
 
 	repository, err := r.bakeRepo(id)
 	if err != nil {
 		return nil, err
 	}
+	repository.DeltaPolicy = rTmp.DeltaPolicy
+	repository.VerifyHashes = rTmp.VerifyHashes
+	repository.ConflictPolicy = rTmp.ConflictPolicy
+	repository.VerifyIndex = rTmp.VerifyIndex
+	repository.Held = rTmp.Held
+	repository.Bans = rTmp.Bans
+	repository.Quota = rTmp.Quota
+	repository.Architectures = rTmp.Architectures
+	repository.ComponentIndexes = rTmp.ComponentIndexes
+	repository.VerifyDeltas = rTmp.VerifyDeltas
+	repository.VersionedIndexes = rTmp.VersionedIndexes
 
 	// Cache this guy for later
-	r.repos[id] = repository
+	return r.cacheRepo(repository, generation), nil
+}
 
-	return repository, nil
+// SetDeltaPolicy will store the new delta policy for the repository
+func (r *RepositoryManager) SetDeltaPolicy(db libdb.Database, id string, policy *DeltaPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.DeltaPolicy = *policy
+	})
+}
+
+// SetVerifyHashes will change whether imported packages have their file
+// hashes checked before entering the pool
+func (r *RepositoryManager) SetVerifyHashes(db libdb.Database, id string, verify bool) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.VerifyHashes = verify
+	})
+}
+
+// SetVerifyIndex will change whether new indexes are validated against the
+// tree before being published
+func (r *RepositoryManager) SetVerifyIndex(db libdb.Database, id string, verify bool) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.VerifyIndex = verify
+	})
+}
+
+// SetVerifyDeltas will change whether new deltas are checked against the
+// packages they go between before entering the repository
+func (r *RepositoryManager) SetVerifyDeltas(db libdb.Database, id string, verify bool) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.VerifyDeltas = verify
+	})
+}
+
+// SetVersionedIndexes will change whether each index is written into a new
+// generation directory, published with a single symlink flip
+func (r *RepositoryManager) SetVersionedIndexes(db libdb.Database, id string, enabled bool) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.VersionedIndexes = enabled
+	})
+}
+
+// SetComponentIndexes will change whether an index fragment is emitted for
+// each component alongside the main index
+func (r *RepositoryManager) SetComponentIndexes(db libdb.Database, id string, enabled bool) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.ComponentIndexes = enabled
+	})
+}
+
+// SetConflictPolicy will change how duplicate release numbers are handled
+func (r *RepositoryManager) SetConflictPolicy(db libdb.Database, id string, policy ConflictPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.ConflictPolicy = policy
+	})
+}
+
+// SetQuota will change the most bytes the repository's packages and deltas
+// may use, where 0 means no limit
+func (r *RepositoryManager) SetQuota(db libdb.Database, id string, quota int64) error {
+	if quota < 0 {
+		return fmt.Errorf("quota cannot be negative: %d", quota)
+	}
+	return r.updateRepo(db, id, func(repo *Repository) {
+		repo.Quota = quota
+	})
+}
+
+// HoldSource will freeze the source in the repository, so that pulls and
+// promotions leave it alone until it is released again
+func (r *RepositoryManager) HoldSource(db libdb.Database, id, sourceID string) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		if !repo.IsHeld(sourceID) {
+			repo.Held = append(repo.Held, sourceID)
+			sort.Strings(repo.Held)
+		}
+	})
+}
+
+// UnholdSource will release a previously held source in the repository
+func (r *RepositoryManager) UnholdSource(db libdb.Database, id, sourceID string) error {
+	return r.updateRepo(db, id, func(repo *Repository) {
+		for i, held := range repo.Held {
+			if held == sourceID {
+				repo.Held = append(repo.Held[:i], repo.Held[i+1:]...)
+				break
+			}
+		}
+	})
+}
+
+// updateRepo will apply the change to the stored repository record. The
+// cached repository may be in use, so rather than change it we invalidate
+// it, and the next GetRepo will see the change.
+func (r *RepositoryManager) updateRepo(db libdb.Database, id string, change func(repo *Repository)) error {
+	r.repoLock.Lock()
+	defer r.repoLock.Unlock()
+
+	var stored Repository
+	rootBucket := db.Bucket([]byte(DatabaseBucketRepo))
+	if err := rootBucket.GetObject([]byte(id), &stored); err != nil {
+		return notFoundf("The specified repository '%s' does not exist", id)
+	}
+	change(&stored)
+	if err := rootBucket.PutObject([]byte(id), &stored); err != nil {
+		return err
+	}
+
+	r.Invalidate(id)
+	return nil
 }
 
 // CreateRepo will create a new repository (bucket) within the top level
@@ -201,12 +446,15 @@ func (r *RepositoryManager) CreateRepo(db libdb.Database, id string) (*Repositor
 	r.repoLock.Lock()
 	defer r.repoLock.Unlock()
 
-	if _, err := r.GetRepo(db, id); err == nil {
+	if _, err := r.getRepoLocked(db, id); err == nil {
 		return nil, fmt.Errorf("The specified repository '%s' already exists", id)
 	}
 
 	// Make sure someone isn't intentionally fucking with us
 	rbase := filepath.Join(r.repoBase, id)
+	if root, ok := r.roots[id]; ok {
+		rbase = filepath.Join(root, RepoPathComponent, id)
+	}
 	if PathExists(rbase) {
 		return nil, fmt.Errorf("The specified repository '%s' has artifacts on disk", id)
 	}
@@ -216,6 +464,7 @@ func (r *RepositoryManager) CreateRepo(db libdb.Database, id string) (*Repositor
 	repo := Repository{
 		ID: id,
 	}
+	repo.applySettings(&r.defaults)
 
 	if err := rootBucket.PutObject([]byte(id), &repo); err != nil {
 		return nil, err
@@ -225,24 +474,31 @@ func (r *RepositoryManager) CreateRepo(db libdb.Database, id string) (*Repositor
 	if err != nil {
 		return nil, err
 	}
+	repository.applySettings(&r.defaults)
 
-	// Cache this guy for later
+	// Replace anything cached while we created it
+	r.cacheLock.Lock()
 	r.repos[id] = repository
+	r.generation++
+	r.cacheLock.Unlock()
 
 	return repository, nil
 }
 
-// DeleteRepo is not yet implemented
-func (r *RepositoryManager) DeleteRepo(db libdb.Database, pool *Pool, id string) error {
+// DeleteRepo will remove the repository and drop its references to the pool
+func (r *RepositoryManager) DeleteRepo(logger *log.Entry, db libdb.Database, pool *Pool, id string) error {
 	r.repoLock.Lock()
 	defer r.repoLock.Unlock()
 
-	repo, err := r.GetRepo(db, id)
+	repo, err := r.getRepoLocked(db, id)
 	if err != nil {
-		return fmt.Errorf("The specified repository '%s' does not exist", id)
+		return notFoundf("The specified repository '%s' does not exist", id)
 	}
 
-	delete(r.repos, id)
+	// The database lock must always be taken last, so hold off any inserts
+	// before starting the transaction
+	repo.insertMut.Lock()
+	defer repo.insertMut.Unlock()
 
 	// Let's iterate over every one of our packages here and start up an unref
 	// cycle
@@ -259,14 +515,14 @@ func (r *RepositoryManager) DeleteRepo(db libdb.Database, pool *Pool, id string)
 
 			// First up, find all the packages to unref
 			for _, id := range entry.Available {
-				if err := repo.removePackageInternal(db, pool, id); err != nil {
+				if err := repo.removePackageLocked(logger, db, pool, id); err != nil {
 					return err
 				}
 			}
 
 			// Next up, find all the deltas to unref
 			for _, id := range entry.Deltas {
-				if err := repo.removeDeltaInternal(db, pool, id); err != nil {
+				if err := repo.removePackageLocked(logger, db, pool, id); err != nil {
 					return err
 				}
 			}
@@ -282,6 +538,8 @@ func (r *RepositoryManager) DeleteRepo(db libdb.Database, pool *Pool, id string)
 		return repoBucket.DeleteObject([]byte(repo.ID))
 	})
 
+	// Drop the cached repo, along with anything loaded while we deleted it
+	r.Invalidate(id)
 	if err != nil {
 		return err
 	}
@@ -291,6 +549,7 @@ func (r *RepositoryManager) DeleteRepo(db libdb.Database, pool *Pool, id string)
 		repo.assetPath,
 		repo.deltaPath,
 		repo.deltaStagePath,
+		repo.incomingPath,
 	}
 
 	// Clean up the repo paths.
@@ -300,17 +559,36 @@ func (r *RepositoryManager) DeleteRepo(db libdb.Database, pool *Pool, id string)
 		}
 		// Just continue, warn in the log - do what we can here
 		if err := os.RemoveAll(p); err != nil {
-			log.WithFields(log.Fields{
+			logger.WithFields(log.Fields{
 				"repo":  id,
 				"path":  p,
 				"error": err,
 			}).Warning("Failed to remove repository path")
 		}
 	}
+	if repo.treeLink != "" {
+		if err := os.Remove(repo.treeLink); err != nil && !os.IsNotExist(err) {
+			logger.WithFields(log.Fields{
+				"repo":  id,
+				"path":  repo.treeLink,
+				"error": err,
+			}).Warning("Failed to remove repository path")
+		}
+	}
 
 	return nil
 }
 
+// IsHeld will determine if the source is held in this repository
+func (r *Repository) IsHeld(sourceID string) bool {
+	for _, held := range r.Held {
+		if held == sourceID {
+			return true
+		}
+	}
+	return false
+}
+
 // GetEntry will return the package entry for the given ID
 func (r *Repository) GetEntry(db libdb.Database, id string) (*RepoEntry, error) {
 	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(r.ID)).Bucket([]byte(DatabaseBucketPackage))
@@ -328,62 +606,91 @@ func (r *Repository) putEntry(db libdb.Database, entry *RepoEntry) error {
 }
 
 // RefDelta will take the existing delta from the pool and insert it into our own repository
-func (r *Repository) RefDelta(db libdb.Database, pool *Pool, deltaID string) error {
+func (r *Repository) RefDelta(logger *log.Entry, db libdb.Database, pool *Pool, deltaID string) error {
+	return r.RefDeltas(logger, db, pool, []string{deltaID})
+}
+
+// RefDeltas will take the existing deltas from the pool and insert them into
+// our own repository, with all database changes made in one transaction
+func (r *Repository) RefDeltas(logger *log.Entry, db libdb.Database, pool *Pool, deltaIDs []string) error {
 	r.insertMut.Lock()
 	defer r.insertMut.Unlock()
 
-	// Ensure we REALLY have the delta.
-	poolEntry, err := pool.GetEntry(db, deltaID)
+	// Ensure we REALLY have the deltas.
+	poolEntries, err := pool.GetEntries(db, deltaIDs)
 	if err != nil {
 		return err
 	}
 
-	// Now make sure we actually have the local entry
-	entry, err := r.GetEntry(db, poolEntry.Meta.Name)
-	if err != nil {
-		return err
-	}
+	return db.Update(func(db libdb.Database) error {
+		var linked []*PoolEntry
+		var linkedIDs []string
 
-	// Extract our relevant paths
-	localPath := pool.GetMetaPoolPath(deltaID, poolEntry.Meta)
-	targetDir := filepath.Join(r.path, poolEntry.Meta.GetPathComponent())
-	targetPath := filepath.Join(targetDir, deltaID)
+		for i, poolEntry := range poolEntries {
+			deltaID := deltaIDs[i]
 
-	// Check we don't know about this delta already
-	for _, id := range entry.Deltas {
-		if id == deltaID {
-			log.WithFields(log.Fields{
-				"id":   id,
-				"repo": r.ID,
-			}).Info("Skipping already included delta")
-			return nil
+			// Now make sure we actually have the local entry
+			entry, err := r.GetEntry(db, poolEntry.Meta.Name)
+			if err != nil {
+				return err
+			}
+
+			// Check we don't know about this delta already
+			known := false
+			for _, id := range entry.Deltas {
+				if id == deltaID {
+					known = true
+					break
+				}
+			}
+			if known {
+				logger.WithFields(log.Fields{
+					"id":   deltaID,
+					"repo": r.ID,
+				}).Info("Skipping already included delta")
+				continue
+			}
+
+			// Insert this deltas ID to this package map
+			entry.Deltas = append(entry.Deltas, deltaID)
+			sort.Strings(entry.Deltas)
+			if err := r.putEntry(db, entry); err != nil {
+				return err
+			}
+			linked = append(linked, poolEntry)
+			linkedIDs = append(linkedIDs, deltaID)
 		}
-	}
 
-	// Insert this deltas ID to this package map
-	entry.Deltas = append(entry.Deltas, deltaID)
-	sort.Strings(entry.Deltas)
+		// Grab the pool references for these deltas
+		if err := pool.RefEntries(db, linkedIDs); err != nil {
+			return err
+		}
 
-	// Construct root dirs
-	if err := os.MkdirAll(targetDir, 00755); err != nil {
-		return err
-	}
+		for _, poolEntry := range linked {
+			if err := r.linkPoolFile(pool, poolEntry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
 
-	// Grab the pool reference for this package
-	if err := pool.RefEntry(db, deltaID); err != nil {
-		return err
-	}
+// linkPoolFile will ensure the pool's file for the entry is linked inside our
+// own tree
+func (r *Repository) linkPoolFile(pool *Pool, poolEntry *PoolEntry) error {
+	localPath := pool.EntryPath(poolEntry)
+	targetDir := filepath.Join(r.path, poolEntry.Meta.GetPathComponent())
+	targetPath := filepath.Join(targetDir, poolEntry.Name)
 
-	// Ensure the eopkg file is linked inside our own tree
-	if err := LinkOrCopyFile(localPath, targetPath, false); err != nil {
+	// Construct root dirs
+	if err := os.MkdirAll(targetDir, 00755); err != nil {
 		return err
 	}
-
-	return r.putEntry(db, entry)
+	return LinkOrCopyFile(localPath, targetPath, false)
 }
 
 // AddDelta will first open and read the .delta.eopkg, before passing it back off to AddLocalDelta
-func (r *Repository) AddDelta(db libdb.Database, pool *Pool, filename string, mapping *DeltaInformation) error {
+func (r *Repository) AddDelta(logger *log.Entry, db libdb.Database, pool *Pool, filename string, mapping *DeltaInformation) error {
 	pkg, err := libeopkg.Open(filename)
 	if err != nil {
 		return err
@@ -394,13 +701,19 @@ func (r *Repository) AddDelta(db libdb.Database, pool *Pool, filename string, ma
 		return err
 	}
 
-	return r.AddLocalDelta(db, pool, pkg, mapping)
+	if r.VerifyDeltas {
+		if err = r.verifyDelta(db, pool, pkg, mapping); err != nil {
+			return err
+		}
+	}
+
+	return r.AddLocalDelta(logger, db, pool, pkg, mapping)
 }
 
 // AddLocalDelta will attempt to add the delta to this repository, if possible
 // All ref'd deltas are retained, but not necessarily emitted unless they're
 // valid for the from-to relationship.
-func (r *Repository) AddLocalDelta(db libdb.Database, pool *Pool, pkg *libeopkg.Package, mapping *DeltaInformation) error {
+func (r *Repository) AddLocalDelta(logger *log.Entry, db libdb.Database, pool *Pool, pkg *libeopkg.Package, mapping *DeltaInformation) error {
 	r.insertMut.Lock()
 	defer r.insertMut.Unlock()
 
@@ -416,7 +729,7 @@ func (r *Repository) AddLocalDelta(db libdb.Database, pool *Pool, pkg *libeopkg.
 	// Check we don't know about this delta already
 	for _, id := range entry.Deltas {
 		if id == pkg.ID {
-			log.WithFields(log.Fields{
+			logger.WithFields(log.Fields{
 				"id":   id,
 				"repo": r.ID,
 			}).Info("Skipping already included delta")
@@ -434,13 +747,13 @@ func (r *Repository) AddLocalDelta(db libdb.Database, pool *Pool, pkg *libeopkg.
 	}
 
 	// Grab the pool reference for this package
-	if _, err = pool.AddDelta(db, pkg, mapping, false); err != nil {
+	poolEntry, err := pool.AddDelta(logger, db, pkg, mapping, false)
+	if err != nil {
 		return err
 	}
 
 	// Ensure the eopkg file is linked inside our own tree
-	source := pool.GetPackagePoolPath(pkg)
-	if err = LinkOrCopyFile(source, pkgTarget, false); err != nil {
+	if err = LinkOrCopyFile(pool.EntryPath(poolEntry), pkgTarget, false); err != nil {
 		return err
 	}
 
@@ -448,10 +761,15 @@ func (r *Repository) AddLocalDelta(db libdb.Database, pool *Pool, pkg *libeopkg.
 }
 
 // Internal helper to remove packages
-func (r *Repository) removePackageInternal(db libdb.Database, pool *Pool, id string) error {
+func (r *Repository) removePackageInternal(logger *log.Entry, db libdb.Database, pool *Pool, id string) error {
 	r.insertMut.Lock()
 	defer r.insertMut.Unlock()
+	return r.removePackageLocked(logger, db, pool, id)
+}
 
+// removePackageLocked does the work of removePackageInternal, and requires
+// that insertMut is already held
+func (r *Repository) removePackageLocked(logger *log.Entry, db libdb.Database, pool *Pool, id string) error {
 	poolEntry, err := pool.GetEntry(db, id)
 	if err != nil {
 		return nil
@@ -467,7 +785,7 @@ func (r *Repository) removePackageInternal(db libdb.Database, pool *Pool, id str
 
 	// This is a "we tried but oh noes, not fatal.
 	if err = RemovePackageParents(pkgTarget); err != nil {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo":  r.ID,
 			"id":    id,
 			"error": err,
@@ -475,13 +793,13 @@ func (r *Repository) removePackageInternal(db libdb.Database, pool *Pool, id str
 	}
 
 	// Tell the pool we no longer need this guy
-	return pool.UnrefEntry(db, id)
+	return pool.UnrefEntry(logger, db, id)
 }
 
 // removeDeltaInternal has the same job as removePackageInternal, but in future should
 // be extended to remove the skip records
-func (r *Repository) removeDeltaInternal(db libdb.Database, pool *Pool, id string) error {
-	return r.removePackageInternal(db, pool, id)
+func (r *Repository) removeDeltaInternal(logger *log.Entry, db libdb.Database, pool *Pool, id string) error {
+	return r.removePackageInternal(logger, db, pool, id)
 }
 
 // UnrefPackage will remove a package from our storage, and potentially remove the
@@ -489,7 +807,7 @@ func (r *Repository) removeDeltaInternal(db libdb.Database, pool *Pool, id strin
 //
 // Additionally, we'll locate stray deltas which lead either TO or FROM the given
 // package as they'll now be useless to anyone.
-func (r *Repository) UnrefPackage(db libdb.Database, pool *Pool, pkgID string) error {
+func (r *Repository) UnrefPackage(logger *log.Entry, db libdb.Database, pool *Pool, pkgID string) error {
 	newHighest := 0
 	var newHighestID string
 
@@ -506,7 +824,7 @@ func (r *Repository) UnrefPackage(db libdb.Database, pool *Pool, pkgID string) e
 	}
 
 	// Try to remove it from disk first
-	if err := r.removePackageInternal(db, pool, pkgID); err != nil {
+	if err := r.removePackageInternal(logger, db, pool, pkgID); err != nil {
 		return err
 	}
 
@@ -522,7 +840,7 @@ func (r *Repository) UnrefPackage(db libdb.Database, pool *Pool, pkgID string) e
 
 		// We found a delta that is referencing us, we must garbage collect it now
 		if pkgDelta.Delta.FromID == pkgID || pkgDelta.Delta.ToID == pkgID {
-			if err := r.removeDeltaInternal(db, pool, pkgDelta.Name); err != nil {
+			if err := r.removeDeltaInternal(logger, db, pool, pkgDelta.Name); err != nil {
 				return err
 			}
 		} else {
@@ -570,53 +888,90 @@ func (r *Repository) UnrefPackage(db libdb.Database, pool *Pool, pkgID string) e
 }
 
 // RefPackage will dupe a package from the pool into our own storage
-func (r *Repository) RefPackage(db libdb.Database, pool *Pool, pkgID string) error {
+func (r *Repository) RefPackage(logger *log.Entry, db libdb.Database, pool *Pool, pkgID string) error {
+	return r.RefPackages(logger, db, pool, []string{pkgID})
+}
+
+// RefPackages will dupe many packages from the pool into our own storage,
+// with all database changes made in one transaction. Nothing is added if
+// any of the packages are banned or built for a disallowed architecture.
+func (r *Repository) RefPackages(logger *log.Entry, db libdb.Database, pool *Pool, pkgIDs []string) error {
 	r.insertMut.Lock()
 	defer r.insertMut.Unlock()
 
 	// Require a pool entry to clone from
-	poolEntry, err := pool.GetEntry(db, pkgID)
+	poolEntries, err := pool.GetEntries(db, pkgIDs)
 	if err != nil {
 		return err
 	}
-
-	localPath := pool.GetMetaPoolPath(pkgID, poolEntry.Meta)
-	targetDir := filepath.Join(r.path, poolEntry.Meta.GetPathComponent())
-	targetPath := filepath.Join(targetDir, pkgID)
-
-	repoEntry := r.buildSaneEntry(db, pool, poolEntry.Meta, pkgID)
-	// Already included
-	if repoEntry == nil {
-		return nil
+	for i, poolEntry := range poolEntries {
+		if err := r.checkAdmissible(poolEntry.Meta, pkgIDs[i]); err != nil {
+			return err
+		}
 	}
 
-	// Construct root dirs
-	if err := os.MkdirAll(targetDir, 00755); err != nil {
-		return err
-	}
+	return db.Update(func(db libdb.Database) error {
+		var linked []*PoolEntry
+		var linkedIDs []string
+		var replacedIDs []string
 
-	// Grab the pool reference for this package (Always copy)
-	if err = pool.RefEntry(db, pkgID); err != nil {
-		return err
-	}
+		for i, poolEntry := range poolEntries {
+			pkgID := pkgIDs[i]
+			localPath := pool.EntryPath(poolEntry)
 
-	// Ensure the eopkg file is linked inside our own tree
-	if err = LinkOrCopyFile(localPath, targetPath, false); err != nil {
-		return err
-	}
+			// Earlier packages in the set are visible here, as we're
+			// within the same transaction
+			repoEntry, replaced, err := r.buildSaneEntry(logger, db, pool, poolEntry.Meta, pkgID, localPath)
+			if err != nil {
+				return err
+			}
+			// Already included
+			if repoEntry == nil {
+				continue
+			}
+			if err := r.putEntry(db, repoEntry); err != nil {
+				return err
+			}
+			linked = append(linked, poolEntry)
+			linkedIDs = append(linkedIDs, pkgID)
+			replacedIDs = append(replacedIDs, replaced)
+		}
 
-	return r.putEntry(db, repoEntry)
+		// Grab the pool references for these packages (Always copy), before
+		// any replaced package can drop the last one
+		if err := pool.RefEntries(db, linkedIDs); err != nil {
+			return err
+		}
+
+		for i, poolEntry := range linked {
+			// Ensure the eopkg file is linked inside our own tree
+			if err := r.linkPoolFile(pool, poolEntry); err != nil {
+				return err
+			}
+			if replacedIDs[i] == "" {
+				continue
+			}
+			if err := r.removePackageLocked(logger, db, pool, replacedIDs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // buildSaneEntry will either return a plain entry if none exists already, otherwise it will
-// take an existing entry and correctly set up the available/published fields
-func (r *Repository) buildSaneEntry(db libdb.Database, pool *Pool, newPkg *libeopkg.MetaPackage, newID string) *RepoEntry {
+// take an existing entry and correctly set up the available/published fields.
+//
+// If the package replaces one with the same release, due to the conflict policy, the ID
+// of the replaced package is returned so that the caller can remove it.
+func (r *Repository) buildSaneEntry(logger *log.Entry, db libdb.Database, pool *Pool, newPkg *libeopkg.MetaPackage, newID, newPath string) (*RepoEntry, string, error) {
 	// Fallback in case one actually doesn't exist yet
 	repoEntry := &RepoEntry{
 		SchemaVersion: RepoSchemaVersion,
 		Name:          newPkg.Name,
 		Published:     newID,
 	}
+	var replaced string
 
 	// Not so worried about the error, just having the entry
 	entry, _ := r.GetEntry(db, newPkg.Name)
@@ -628,14 +983,41 @@ func (r *Repository) buildSaneEntry(db libdb.Database, pool *Pool, newPkg *libeo
 
 		pkgAvail, err := pool.GetEntry(db, repoEntry.Published)
 		if err == nil {
+			if err := checkSameArchitecture(pkgAvail.Meta, newPkg, newID); err != nil {
+				return nil, "", err
+			}
 			if newPkg.GetRelease() > pkgAvail.Meta.GetRelease() {
 				repoEntry.Published = newID
+				// A higher release supersedes any conflict
+				if err := clearConflict(db, r.ID, newPkg.Name); err != nil {
+					return nil, "", err
+				}
 			} else if newPkg.GetRelease() == pkgAvail.Meta.GetRelease() && pkgAvail.Name != newID {
-				log.WithFields(log.Fields{
+				logger.WithFields(log.Fields{
 					"existing":   pkgAvail.Name,
 					"newPackage": newID,
 					"repo":       r.ID,
+					"policy":     r.ConflictPolicy,
 				}).Error("Duplicate release number detected. Fix immediately!")
+
+				replace, skip, err := r.resolveConflict(db, pool, pkgAvail, newPkg, newID, newPath)
+				if err != nil {
+					return nil, "", err
+				}
+				if skip {
+					return nil, "", nil
+				}
+				if replace {
+					replaced = pkgAvail.Name
+					repoEntry.Published = newID
+					var remain []string
+					for _, id := range repoEntry.Available {
+						if id != replaced {
+							remain = append(remain, id)
+						}
+					}
+					repoEntry.Available = remain
+				}
 			}
 		} else {
 			repoEntry.Published = newID
@@ -645,11 +1027,11 @@ func (r *Repository) buildSaneEntry(db libdb.Database, pool *Pool, newPkg *libeo
 	// Check if we've already indexed it, non-fatal
 	for _, id := range repoEntry.Available {
 		if id == newID {
-			log.WithFields(log.Fields{
+			logger.WithFields(log.Fields{
 				"id":   id,
 				"repo": r.ID,
 			}).Info("Skipping already included package")
-			return nil
+			return nil, "", nil
 		}
 	}
 
@@ -657,19 +1039,32 @@ func (r *Repository) buildSaneEntry(db libdb.Database, pool *Pool, newPkg *libeo
 	repoEntry.Available = append(repoEntry.Available, newID)
 	sort.Strings(repoEntry.Available)
 
-	return repoEntry
+	return repoEntry, replaced, nil
 }
 
-// AddLocalPackage will do the real work of adding an open & loaded eopkg to the repository
-func (r *Repository) AddLocalPackage(db libdb.Database, pool *Pool, pkg *libeopkg.Package) error {
+// AddLocalPackage will do the real work of adding an open & loaded eopkg to the repository.
+// prov may be nil, otherwise it is recorded if the package is new to the pool.
+func (r *Repository) AddLocalPackage(logger *log.Entry, db libdb.Database, pool *Pool, pkg *libeopkg.Package, prov *Provenance) error {
 	r.insertMut.Lock()
 	defer r.insertMut.Unlock()
+	return r.addLocalPackageLocked(logger, db, pool, pkg, nil, prov)
+}
+
+// addLocalPackageLocked is AddLocalPackage for callers already holding the
+// insertMut. The hashes of the package are only computed if nil.
+func (r *Repository) addLocalPackageLocked(logger *log.Entry, db libdb.Database, pool *Pool, pkg *libeopkg.Package, hashes *fileHashes, prov *Provenance) error {
+	if err := r.checkAdmissible(&pkg.Meta.Package, pkg.ID); err != nil {
+		return err
+	}
 
 	pkgDir := filepath.Join(r.path, pkg.Meta.Package.GetPathComponent())
 	pkgTarget := filepath.Join(pkgDir, pkg.ID)
 
 	// Already have a package, so let's copy the existing bits over
-	repoEntry := r.buildSaneEntry(db, pool, &pkg.Meta.Package, pkg.ID)
+	repoEntry, replaced, err := r.buildSaneEntry(logger, db, pool, &pkg.Meta.Package, pkg.ID, pkg.Path)
+	if err != nil {
+		return err
+	}
 
 	// nil == already included
 	if repoEntry == nil {
@@ -682,22 +1077,34 @@ func (r *Repository) AddLocalPackage(db libdb.Database, pool *Pool, pkg *libeopk
 	}
 
 	// Grab the pool reference for this package (Always copy)
-	if _, err := pool.AddPackage(db, pkg, false); err != nil {
+	poolEntry, err := pool.addPackageInternal(logger, db, pkg, false, nil, hashes, prov)
+	if err != nil {
 		return err
 	}
 
 	// Ensure the eopkg file is linked inside our own tree
-	source := pool.GetPackagePoolPath(pkg)
-	if err := LinkOrCopyFile(source, pkgTarget, false); err != nil {
+	if err := LinkOrCopyFile(pool.EntryPath(poolEntry), pkgTarget, false); err != nil {
 		return err
 	}
 
+	if replaced != "" {
+		if err := r.removePackageLocked(logger, db, pool, replaced); err != nil {
+			return err
+		}
+	}
+
 	return r.putEntry(db, repoEntry)
 }
 
 // AddPackage will attempt to load the local package and then add it to the
 // repository via AddLocalPackage
-func (r *Repository) AddPackage(db libdb.Database, pool *Pool, filename string, anal bool) error {
+func (r *Repository) AddPackage(logger *log.Entry, db libdb.Database, pool *Pool, filename string, anal bool, prov *Provenance) error {
+	return r.addPackageFile(logger, db, pool, filename, anal, nil, prov)
+}
+
+// addPackageFile is AddPackage for a file which may already have been
+// hashed, otherwise hashes is nil
+func (r *Repository) addPackageFile(logger *log.Entry, db libdb.Database, pool *Pool, filename string, anal bool, hashes *fileHashes, prov *Provenance) error {
 	pkg, err := libeopkg.Open(filename)
 	if err != nil {
 		return err
@@ -710,13 +1117,13 @@ func (r *Repository) AddPackage(db libdb.Database, pool *Pool, filename string,
 
 	// Not being strict, just let it in
 	if !anal {
-		return r.AddLocalPackage(db, pool, pkg)
+		return r.addCheckedPackage(logger, db, pool, pkg, hashes, prov)
 	}
 
 	// Do we have this?
 	localPkg, err := r.GetEntry(db, pkg.Meta.Package.Name)
 	if err != nil {
-		return r.AddLocalPackage(db, pool, pkg)
+		return r.addCheckedPackage(logger, db, pool, pkg, hashes, prov)
 	}
 
 	// We have this package, so Published link must work
@@ -739,7 +1146,20 @@ func (r *Repository) AddPackage(db libdb.Database, pool *Pool, filename string,
 	}
 
 	// Hey look buddy, you made it.
-	return r.AddLocalPackage(db, pool, pkg)
+	return r.addCheckedPackage(logger, db, pool, pkg, hashes, prov)
+}
+
+// addCheckedPackage will verify the package contents if the repository
+// requires it, before adding it as AddLocalPackage would
+func (r *Repository) addCheckedPackage(logger *log.Entry, db libdb.Database, pool *Pool, pkg *libeopkg.Package, hashes *fileHashes, prov *Provenance) error {
+	if r.VerifyHashes {
+		if err := pkg.VerifyFiles(); err != nil {
+			return fmt.Errorf("%s failed verification: %v", pkg.ID, err)
+		}
+	}
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+	return r.addLocalPackageLocked(logger, db, pool, pkg, hashes, prov)
 }
 
 // GetPackageNames will traverse the buckets and find all package names as stored
@@ -764,6 +1184,26 @@ func (r *Repository) GetPackageNames(db libdb.Database) ([]string, error) {
 	return pkgIds, nil
 }
 
+// GetEntries will return every RepoEntry within the repository
+func (r *Repository) GetEntries(db libdb.Database) ([]*RepoEntry, error) {
+	var entries []*RepoEntry
+	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(r.ID)).Bucket([]byte(DatabaseBucketPackage))
+
+	err := rootBucket.ForEach(func(k, v []byte) error {
+		entry := &RepoEntry{}
+		if err := rootBucket.Decode(v, entry); err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
 // GetPackages will return all package objects for a given name
 func (r *Repository) GetPackages(db libdb.Database, pool *Pool, pkgName string) ([]*libeopkg.MetaPackage, error) {
 	var pkgs []*libeopkg.MetaPackage
@@ -795,7 +1235,10 @@ func (r *Repository) GetPackages(db libdb.Database, pool *Pool, pkgName string)
 // staging area if it successfully produces a delta. This does not mark a delta
 // attempt as "pointless", nor does it actually *include* the delta package
 // within the repository.
-func (r *Repository) CreateDelta(db libdb.Database, oldPkg, newPkg *libeopkg.MetaPackage) (string, error) {
+//
+// If progress is set, it will be called periodically during production, and
+// closing cancel will abandon it.
+func (r *Repository) CreateDelta(db libdb.Database, pool *Pool, oldPkg, newPkg *libeopkg.MetaPackage, progress libeopkg.DeltaProgressFunc, cancel <-chan struct{}) (string, error) {
 	if !libeopkg.IsDeltaPossible(oldPkg, newPkg) {
 		return "", libeopkg.ErrMismatchedDelta
 	}
@@ -807,10 +1250,15 @@ func (r *Repository) CreateDelta(db libdb.Database, oldPkg, newPkg *libeopkg.Met
 		return fullPath, nil
 	}
 
-	oldPath := filepath.Join(r.path, oldPkg.PackageURI)
-	newPath := filepath.Join(r.path, newPkg.PackageURI)
+	// Use the pool copies, as the old package may come from another repository
+	entries, err := pool.GetEntries(db, []string{oldPkg.GetID(), newPkg.GetID()})
+	if err != nil {
+		return "", err
+	}
+	oldPath := pool.EntryPath(entries[0])
+	newPath := pool.EntryPath(entries[1])
 
-	if err := ProduceDelta(r.deltaPath, oldPath, newPath, fullPath); err != nil {
+	if err := ProduceDelta(r.deltaPath, oldPath, newPath, fullPath, progress, cancel); err != nil {
 		return "", err
 	}
 
@@ -833,10 +1281,9 @@ func (r *Repository) HasDelta(db libdb.Database, pkgName, deltaPath string) (boo
 
 // pullAssets will pull the various asset files in prior to indexing
 func (r *Repository) pullAssets(sourceRepo *Repository) error {
-	copyPaths := []string{
-		filepath.Join(sourceRepo.assetPath, "distribution.xml"),
-		filepath.Join(sourceRepo.assetPath, "components.xml"),
-		filepath.Join(sourceRepo.assetPath, "groups.xml"),
+	var copyPaths []string
+	for _, name := range AssetNames() {
+		copyPaths = append(copyPaths, filepath.Join(sourceRepo.assetPath, name))
 	}
 
 	// In case anyone is being cranky ..
@@ -861,7 +1308,7 @@ func (r *Repository) pullAssets(sourceRepo *Repository) error {
 
 // CloneFrom will attempt to clone everything from the target repository into
 // ourselves
-func (r *Repository) CloneFrom(db libdb.Database, pool *Pool, sourceRepo *Repository, fullClone bool) error {
+func (r *Repository) CloneFrom(logger *log.Entry, db libdb.Database, pool *Pool, sourceRepo *Repository, fullClone bool) error {
 	// First things first, instigate a write lock on the target
 	sourceRepo.insertMut.Lock()
 	defer sourceRepo.insertMut.Unlock()
@@ -897,23 +1344,144 @@ func (r *Repository) CloneFrom(db libdb.Database, pool *Pool, sourceRepo *Reposi
 		return err
 	}
 
-	// Now we'll insert all the new IDs. We can't really transaction this as
-	// we're going to rely on on the refcount cycle and updating published/available
+	// Leave out anything banned since it entered the source, or built for
+	// an architecture we don't allow
+	if copyIDs, err = r.filterBanned(logger, db, pool, copyIDs); err != nil {
+		return err
+	}
+	if deltaIDs, err = r.filterBanned(logger, db, pool, deltaIDs); err != nil {
+		return err
+	}
+	if copyIDs, err = r.filterArchitectures(logger, db, pool, copyIDs); err != nil {
+		return err
+	}
+	if deltaIDs, err = r.filterArchitectures(logger, db, pool, deltaIDs); err != nil {
+		return err
+	}
+
+	// Now we'll insert all the new IDs, updating published/available
 	// depending on tip or ALL
-	for _, id := range copyIDs {
-		if err := r.RefPackage(db, pool, id); err != nil {
-			return err
-		}
+	if err := r.RefPackages(logger, db, pool, copyIDs); err != nil {
+		return err
 	}
 
 	// We can only copy deltas across on full clones.
-	for _, id := range deltaIDs {
-		if err := r.RefDelta(db, pool, id); err != nil {
+	return r.RefDeltas(logger, db, pool, deltaIDs)
+}
+
+// A DiffEntry describes how a single package differs between a source and
+// target repository. IDs are empty when the package is absent on that side.
+type DiffEntry struct {
+	Name     string
+	SourceID string
+	TargetID string
+	Source   *libeopkg.MetaPackage
+	Target   *libeopkg.MetaPackage
+}
+
+// A RepoDiff is the comparison of a target repository against a source,
+// from the point of view of pulling the source into the target.
+type RepoDiff struct {
+	Newer        []*DiffEntry // Newer in the source than the target
+	Missing      []*DiffEntry // In the source, but not in the target
+	Obsolete     []*DiffEntry // In the target, but no longer in the source
+	Inconsistent []*DiffEntry // Newer in the target than the source
+	Held         []*DiffEntry // Newer or missing, but the source is held in the target
+	Banned       []*DiffEntry // Newer or missing, but banned in the target
+}
+
+// DiffFrom will compare the published packages of this repository against
+// the source repository. This is exactly the comparison used by PullFrom.
+func (r *Repository) DiffFrom(db libdb.Database, pool *Pool, sourceRepo *Repository) (*RepoDiff, error) {
+	diff := &RepoDiff{}
+	seen := make(map[string]bool)
+
+	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(sourceRepo.ID)).Bucket([]byte(DatabaseBucketPackage))
+
+	// Grab every package
+	err := rootBucket.ForEach(func(k, v []byte) error {
+		entry := RepoEntry{}
+		if err := rootBucket.Decode(v, &entry); err != nil {
+			return err
+		}
+		// Nothing is published, so there's nothing to compare
+		if entry.Published == "" {
+			return nil
+		}
+		seen[entry.Name] = true
+
+		tipVer, err := pool.GetEntry(db, entry.Published)
+		if err != nil {
+			return err
+		}
+		d := &DiffEntry{
+			Name:     entry.Name,
+			SourceID: entry.Published,
+			Source:   tipVer.Meta,
+		}
+
+		localEntry, _ := r.GetEntry(db, entry.Name)
+		held := r.IsHeld(tipVer.Meta.Source.Name)
+		banned := r.bannedBy(tipVer.Meta) != nil
+
+		// We haven't got this
+		if localEntry == nil || localEntry.Published == "" {
+			if banned {
+				diff.Banned = append(diff.Banned, d)
+			} else if held {
+				diff.Held = append(diff.Held, d)
+			} else {
+				diff.Missing = append(diff.Missing, d)
+			}
+			return nil
+		}
+
+		// We have got this, so is it newer than ours?
+		ourTip, err := pool.GetEntry(db, localEntry.Published)
+		if err != nil {
 			return err
 		}
+		d.TargetID = localEntry.Published
+		d.Target = ourTip.Meta
+
+		if tipVer.Meta.GetRelease() > ourTip.Meta.GetRelease() {
+			if banned {
+				diff.Banned = append(diff.Banned, d)
+			} else if held {
+				diff.Held = append(diff.Held, d)
+			} else {
+				diff.Newer = append(diff.Newer, d)
+			}
+		} else if tipVer.Meta.GetRelease() < ourTip.Meta.GetRelease() {
+			diff.Inconsistent = append(diff.Inconsistent, d)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// Anything we have that the source doesn't is obsolete
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if seen[entry.Name] || entry.Published == "" {
+			continue
+		}
+		ourTip, err := pool.GetEntry(db, entry.Published)
+		if err != nil {
+			return nil, err
+		}
+		diff.Obsolete = append(diff.Obsolete, &DiffEntry{
+			Name:     entry.Name,
+			TargetID: entry.Published,
+			Target:   ourTip.Meta,
+		})
+	}
+
+	return diff, nil
 }
 
 // PullFrom will iterate the source repositories contents, looking for any packages
@@ -921,7 +1489,8 @@ func (r *Repository) CloneFrom(db libdb.Database, pool *Pool, sourceRepo *Reposi
 //
 // If a package is missing from our own indexes (i.e. no key) we'll pull that package.
 // If a package is present in our own indexes, but the package in sourceRepo's published
-// field is actually _newer_ than ours, we'll pull that guy in too.
+// field is actually _newer_ than ours, we'll pull that guy in too. Packages built
+// from a source that we hold, or which we have banned, are left alone in either case.
 //
 // Note this isn't "pull" in the git sense, as we'll not perform any removals or attempt
 // to sync the states completely. Pull is typically used on a clone from a volatile target,
@@ -938,7 +1507,7 @@ func (r *Repository) CloneFrom(db libdb.Database, pool *Pool, sourceRepo *Reposi
 // Drift can be corrected by nuking a repository and performing a full clone from the
 // source to have identical mirrors again. This should be performed rarely and only
 // during periods of maintenance due to this method violating atomic indexes.
-func (r *Repository) PullFrom(db libdb.Database, pool *Pool, sourceRepo *Repository) ([]string, error) {
+func (r *Repository) PullFrom(logger *log.Entry, db libdb.Database, pool *Pool, sourceRepo *Repository) ([]string, error) {
 	// First things first, instigate a write lock on the source
 	sourceRepo.insertMut.Lock()
 	defer sourceRepo.insertMut.Unlock()
@@ -946,65 +1515,92 @@ func (r *Repository) PullFrom(db libdb.Database, pool *Pool, sourceRepo *Reposit
 	var copyIDs []string
 	var changedNames []string
 
-	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(sourceRepo.ID)).Bucket([]byte(DatabaseBucketPackage))
-
 	// Before doing anything, sync the assets
 	if err := r.pullAssets(sourceRepo); err != nil {
 		return nil, err
 	}
 
-	// Grab every package
-	err := rootBucket.ForEach(func(k, v []byte) error {
-		entry := RepoEntry{}
-		if err := rootBucket.Decode(v, &entry); err != nil {
-			return err
-		}
+	diff, err := r.DiffFrom(db, pool, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
 
-		localEntry, _ := r.GetEntry(db, entry.Name)
+	// Is something completely bork?
+	if len(diff.Inconsistent) > 0 {
+		return nil, fmt.Errorf("inconsistent target repository, %v is NEWER in target not SOURCE", diff.Inconsistent[0].Name)
+	}
 
-		// We haven't got this, copy the published version
-		if localEntry == nil {
-			copyIDs = append(copyIDs, entry.Published)
-			changedNames = append(changedNames, entry.Name)
-			return nil
+	// We haven't got these, or theirs is newer, so copy the published version
+	for _, set := range [][]*DiffEntry{diff.Missing, diff.Newer} {
+		for _, d := range set {
+			copyIDs = append(copyIDs, d.SourceID)
+			changedNames = append(changedNames, d.Name)
 		}
+	}
 
-		// We have got this, so is it newer than ours?
-		tipVer, err := pool.GetEntry(db, entry.Published)
-		if err != nil {
-			return err
-		}
-		ourTip, err := pool.GetEntry(db, localEntry.Published)
-		if err != nil {
-			return err
-		}
+	// Now we'll insert all the new IDs in one go
+	if err := r.RefPackages(logger, db, pool, copyIDs); err != nil {
+		return nil, err
+	}
 
-		// Their tip is newer than ours, copy it
-		if tipVer.Meta.GetRelease() > ourTip.Meta.GetRelease() {
-			copyIDs = append(copyIDs, entry.Published)
-			changedNames = append(changedNames, entry.Name)
-		}
+	return changedNames, nil
+}
 
-		// Is something completely bork?
-		if tipVer.Meta.GetRelease() < ourTip.Meta.GetRelease() {
-			return fmt.Errorf("inconsistent target repository, %v is NEWER in target not SOURCE", localEntry.Name)
-		}
-		return nil
-	})
+// PullSourceFrom is PullFrom limited to the packages built from a single
+// source, which includes its -dbginfo packages. Only the source's tip in
+// sourceRepo is pulled, and the names of the packages pulled are returned.
+func (r *Repository) PullSourceFrom(logger *log.Entry, db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string) ([]string, error) {
+	if r.ID == sourceRepo.ID {
+		return nil, errors.New("cannot pull a repository into itself")
+	}
+
+	sourceRepo.insertMut.Lock()
+	defer sourceRepo.insertMut.Unlock()
+
+	if r.IsHeld(sourceID) {
+		return nil, fmt.Errorf("source '%s' is held in '%s'", sourceID, r.ID)
+	}
 
+	ids, err := sourceRepo.getSourceIDs(db, pool, sourceID, -1)
 	if err != nil {
 		return nil, err
 	}
+	if len(ids) == 0 {
+		return nil, errors.New("no matching sources found")
+	}
 
-	// Now we'll insert all the new IDs. We can't really transaction this as
-	// we're going to rely on on the refcount cycle and updating published/available
-	// depending on tip or ALL
-	for _, id := range copyIDs {
-		if err := r.RefPackage(db, pool, id); err != nil {
-			return nil, err
+	diff, err := r.DiffFrom(db, pool, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range diff.Inconsistent {
+		if d.Source.Source.Name == sourceID {
+			return nil, fmt.Errorf("inconsistent target repository, %v is NEWER in target not SOURCE", d.Name)
+		}
+	}
+	for _, d := range diff.Banned {
+		if d.Source.Source.Name == sourceID {
+			return nil, r.checkBanned(d.Source, d.SourceID)
+		}
+	}
+
+	var copyIDs []string
+	var changedNames []string
+	for _, set := range [][]*DiffEntry{diff.Missing, diff.Newer} {
+		for _, d := range set {
+			if d.Source.Source.Name != sourceID {
+				continue
+			}
+			copyIDs = append(copyIDs, d.SourceID)
+			changedNames = append(changedNames, d.Name)
 		}
 	}
 
+	if err := r.RefPackages(logger, db, pool, copyIDs); err != nil {
+		return nil, err
+	}
+
 	return changedNames, nil
 }
 
@@ -1014,41 +1610,8 @@ func (r *Repository) PullFrom(db libdb.Database, pool *Pool, sourceRepo *Reposit
 //
 // Distributions tend to split packages across a common identifier/release
 // and this method will allow us to remove "bad actors" from the index.
-func (r *Repository) RemoveSource(db libdb.Database, pool *Pool, sourceID string, release int) error {
-	var deleteIDs []string
-
-	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(r.ID)).Bucket([]byte(DatabaseBucketPackage))
-
-	// Grab every package
-	err := rootBucket.ForEach(func(k, v []byte) error {
-		entry := RepoEntry{}
-		if err := rootBucket.Decode(v, &entry); err != nil {
-			return err
-		}
-
-		// Now we must grab every "available" package for this bucket entry
-		for _, id := range entry.Available {
-			poolEntry, err := pool.GetEntry(db, id)
-			if err != nil {
-				return err
-			}
-
-			if poolEntry.Meta.Source.Name != sourceID {
-				continue
-			}
-
-			// if release is -1 we remove all matching source
-			if release > 0 && poolEntry.Meta.GetRelease() != release {
-				continue
-			}
-
-			// We've got a match.
-			deleteIDs = append(deleteIDs, id)
-		}
-
-		return nil
-	})
-
+func (r *Repository) RemoveSource(logger *log.Entry, db libdb.Database, pool *Pool, sourceID string, release int) error {
+	deleteIDs, err := r.getSourceIDs(db, pool, sourceID, release)
 	if err != nil {
 		return err
 	}
@@ -1061,7 +1624,7 @@ func (r *Repository) RemoveSource(db libdb.Database, pool *Pool, sourceID string
 	// Now we'll remove all the defunct IDs. We can't really transaction this as
 	// we're going to rely on on the refcount cycle.
 	for _, id := range deleteIDs {
-		if err = r.UnrefPackage(db, pool, id); err != nil {
+		if err = r.UnrefPackage(logger, db, pool, id); err != nil {
 			return err
 		}
 	}
@@ -1069,43 +1632,39 @@ func (r *Repository) RemoveSource(db libdb.Database, pool *Pool, sourceID string
 	return nil
 }
 
-// CopySourceFrom will find all records within sourceRepo that have both the
-// specified sourceID and release number.
-func (r *Repository) CopySourceFrom(db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string, release int) error {
-	var copyIDs []string
+// getSourceIDs will return the IDs of every package in this repository that
+// was built from the given source and release. A release of -1 matches all
+// releases of the source.
+func (r *Repository) getSourceIDs(db libdb.Database, pool *Pool, sourceID string, release int) ([]string, error) {
+	var ids []string
 
-	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(sourceRepo.ID)).Bucket([]byte(DatabaseBucketPackage))
+	entries, err := pool.GetSourceEntries(db, sourceID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Grab every package
-	err := rootBucket.ForEach(func(k, v []byte) error {
-		entry := RepoEntry{}
-		if err := rootBucket.Decode(v, &entry); err != nil {
-			return err
+	for _, poolEntry := range entries {
+		// if release is -1 we want all matching source
+		if release > 0 && poolEntry.Meta.GetRelease() != release {
+			continue
 		}
 
-		// Now we must grab every "available" package for this bucket entry
-		for _, id := range entry.Available {
-			poolEntry, err := pool.GetEntry(db, id)
-			if err != nil {
-				return err
-			}
-
-			if poolEntry.Meta.Source.Name != sourceID {
-				continue
-			}
-
-			// if release is -1 we copy all matching source
-			if release > 0 && poolEntry.Meta.GetRelease() != release {
-				continue
-			}
-
-			// We've got a match.
-			copyIDs = append(copyIDs, id)
+		has, err := r.HasPackage(db, pool, poolEntry.Name)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			ids = append(ids, poolEntry.Name)
 		}
+	}
 
-		return nil
-	})
+	return ids, nil
+}
 
+// CopySourceFrom will find all records within sourceRepo that have both the
+// specified sourceID and release number.
+func (r *Repository) CopySourceFrom(logger *log.Entry, db libdb.Database, pool *Pool, sourceRepo *Repository, sourceID string, release int) error {
+	copyIDs, err := sourceRepo.getSourceIDs(db, pool, sourceID, release)
 	if err != nil {
 		return err
 	}
@@ -1116,13 +1675,7 @@ func (r *Repository) CopySourceFrom(db libdb.Database, pool *Pool, sourceRepo *R
 	}
 
 	// Now to insert all of those IDs
-	for _, id := range copyIDs {
-		if err = r.RefPackage(db, pool, id); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return r.RefPackages(logger, db, pool, copyIDs)
 }
 
 // TrimObsolete isn't very straight forward as it has to account for some
@@ -1132,11 +1685,11 @@ func (r *Repository) CopySourceFrom(db libdb.Database, pool *Pool, sourceRepo *R
 // to remove from the repository. However, we also need to apply certain
 // modifications to ensure child packages (-dbginfo) are also nuked along
 // with them.
-func (r *Repository) TrimObsolete(db libdb.Database, pool *Pool) error {
+func (r *Repository) TrimObsolete(logger *log.Entry, db libdb.Database, pool *Pool) error {
 	r.indexMut.Lock()
 	defer r.indexMut.Unlock()
 
-	if err := r.initDistribution(); err != nil {
+	if err := r.initDistribution(logger); err != nil {
 		return err
 	}
 
@@ -1175,7 +1728,7 @@ func (r *Repository) TrimObsolete(db libdb.Database, pool *Pool) error {
 			if r.dist != nil && r.dist.IsObsolete(nom) {
 				if nom != entry.Name {
 					// Scream really loudly, but remove it because its "just" dbginfo.
-					log.WithFields(log.Fields{
+					logger.WithFields(log.Fields{
 						"repo": r.ID,
 						"name": poolEntry.Meta.Name,
 					}).Error("Abandoned obsolete package. Removing!")
@@ -1194,11 +1747,11 @@ func (r *Repository) TrimObsolete(db libdb.Database, pool *Pool) error {
 
 	// Now attempt to unref every one of the packages marked as obsolete
 	for _, id := range removalIDs {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo": r.ID,
 			"id":   id,
 		}).Info("Removing obsolete package")
-		if err := r.UnrefPackage(db, pool, id); err != nil {
+		if err := r.UnrefPackage(logger, db, pool, id); err != nil {
 			return err
 		}
 	}
@@ -1209,7 +1762,7 @@ func (r *Repository) TrimObsolete(db libdb.Database, pool *Pool) error {
 // TrimPackages will trim back the packages in each package entry to a maximum
 // amount of packages, which helps to combat the issue of rapidly inserting
 // many builds into a repo, i.e. removing old backversions
-func (r *Repository) TrimPackages(db libdb.Database, pool *Pool, maxKeep int) error {
+func (r *Repository) TrimPackages(logger *log.Entry, db libdb.Database, pool *Pool, maxKeep int) error {
 	// All the guys who we're sending to the big bitsink in the sky
 	var removalIDs []string
 
@@ -1257,14 +1810,158 @@ func (r *Repository) TrimPackages(db libdb.Database, pool *Pool, maxKeep int) er
 
 	// Now attempt to unref every one of the packages marked as obsolete
 	for _, id := range removalIDs {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo": r.ID,
 			"id":   id,
 		}).Info("Trimming old package")
-		if err := r.UnrefPackage(db, pool, id); err != nil {
+		if err := r.UnrefPackage(logger, db, pool, id); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// InvalidateDeltas will remove every delta which no longer leads to the
+// published release of its package, as nobody can make use of it any more.
+// The IDs of the removed deltas are returned.
+func (r *Repository) InvalidateDeltas(logger *log.Entry, db libdb.Database, pool *Pool) ([]string, error) {
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+
+	for _, entry := range entries {
+		var remainDeltas []string
+
+		for _, deltaID := range entry.Deltas {
+			pkgDelta, err := pool.GetEntry(db, deltaID)
+			if err != nil {
+				// Dangling record, nothing left to unref
+				logger.WithFields(log.Fields{
+					"repo":  r.ID,
+					"id":    deltaID,
+					"error": err,
+				}).Warning("Dropping unknown delta")
+				removed = append(removed, deltaID)
+				continue
+			}
+
+			if pkgDelta.Delta != nil && pkgDelta.Delta.ToID == entry.Published {
+				remainDeltas = append(remainDeltas, deltaID)
+				continue
+			}
+
+			if err := r.removeDeltaInternal(logger, db, pool, deltaID); err != nil {
+				return nil, err
+			}
+			removed = append(removed, deltaID)
+		}
+
+		if len(remainDeltas) == len(entry.Deltas) {
+			continue
+		}
+
+		entry.Deltas = remainDeltas
+		sort.Strings(entry.Deltas)
+		if err := r.putEntry(db, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return removed, nil
+}
+
+// linkPackageInternal will link a pool entry into our tree and take a
+// reference on it, without touching any RepoEntry
+func (r *Repository) linkPackageInternal(db libdb.Database, pool *Pool, id string) error {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	poolEntry, err := pool.GetEntry(db, id)
+	if err != nil {
+		return err
+	}
+
+	if err := pool.RefEntry(db, id); err != nil {
+		return err
+	}
+
+	return r.linkPoolFile(pool, poolEntry)
+}
+
+// RestoreEntries will rewind the repository so that it contains exactly the
+// given entries, as recorded in a Snapshot. Every package and delta in the
+// entries must still be present in the pool.
+func (r *Repository) RestoreEntries(logger *log.Entry, db libdb.Database, pool *Pool, entries []*RepoEntry) error {
+	_, _, err := r.syncEntries(logger, db, pool, entries, func(id string) error {
+		return r.linkPackageInternal(db, pool, id)
+	})
+	return err
+}
+
+// syncEntries will change the repository so that it contains exactly the
+// given entries, calling link for each package or delta it doesn't have yet.
+// The number of files added and removed is returned.
+func (r *Repository) syncEntries(logger *log.Entry, db libdb.Database, pool *Pool, entries []*RepoEntry, link func(id string) error) (added, removed int, err error) {
+	current, err := r.GetEntries(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	have := make(map[string]bool)
+	for _, entry := range current {
+		for _, id := range append(entry.Available, entry.Deltas...) {
+			have[id] = true
+		}
+	}
+
+	want := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		names[entry.Name] = true
+		for _, id := range append(entry.Available, entry.Deltas...) {
+			want[id] = true
+		}
+	}
+
+	// Drop anything that is no longer wanted
+	for id := range have {
+		if want[id] {
+			continue
+		}
+		if err := r.removePackageInternal(logger, db, pool, id); err != nil {
+			return added, removed, err
+		}
+		removed++
+	}
+
+	// Bring in anything we are missing
+	for id := range want {
+		if have[id] {
+			continue
+		}
+		if err := link(id); err != nil {
+			return added, removed, err
+		}
+		added++
+	}
+
+	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(r.ID)).Bucket([]byte(DatabaseBucketPackage))
+	for _, entry := range current {
+		if names[entry.Name] {
+			continue
+		}
+		if err := rootBucket.DeleteObject([]byte(entry.Name)); err != nil {
+			return added, removed, err
+		}
+	}
+	for _, entry := range entries {
+		if err := r.putEntry(db, entry); err != nil {
+			return added, removed, err
+		}
+	}
+	return added, removed, nil
+}
@@ -0,0 +1,70 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Validate will ensure the settings could be applied to a repository
+func (s *RepoSettings) Validate() error {
+	if err := s.DeltaPolicy.Validate(); err != nil {
+		return err
+	}
+	if err := s.ConflictPolicy.Validate(); err != nil {
+		return err
+	}
+	if s.Quota < 0 {
+		return fmt.Errorf("quota cannot be negative: %d", s.Quota)
+	}
+	for _, arch := range s.Architectures {
+		if err := ValidateArchitecture(arch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDefaults will change the settings which newly created repositories
+// start out with. Holds and bans only make sense for existing repositories,
+// so they can't be given.
+func (r *RepositoryManager) SetDefaults(settings *RepoSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if len(settings.Held) > 0 || len(settings.Bans) > 0 {
+		return errors.New("holds and bans cannot be set for new repositories")
+	}
+
+	defaults := *settings
+	seen := make(map[string]bool)
+	defaults.Architectures = nil
+	for _, arch := range settings.Architectures {
+		if !seen[arch] {
+			seen[arch] = true
+			defaults.Architectures = append(defaults.Architectures, arch)
+		}
+	}
+	sort.Strings(defaults.Architectures)
+
+	r.repoLock.Lock()
+	defer r.repoLock.Unlock()
+	r.defaults = defaults
+	return nil
+}
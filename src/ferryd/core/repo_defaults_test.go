@@ -0,0 +1,93 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRepoDefaults(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("plain"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+
+	bad := []RepoSettings{
+		{ConflictPolicy: "sometimes"},
+		{DeltaPolicy: DeltaPolicy{MaxDeltas: -1}},
+		{Quota: -1},
+		{Architectures: []string{"x86/64"}},
+		{Held: []string{"nano"}},
+	}
+	for i := range bad {
+		if err := manager.SetRepoDefaults(&bad[i]); err == nil {
+			t.Fatalf("Invalid defaults were accepted: %v", bad[i])
+		}
+	}
+
+	defaults := &RepoSettings{
+		DeltaPolicy:      DeltaPolicy{MaxDeltas: 2},
+		ConflictPolicy:   ConflictReject,
+		VerifyHashes:     true,
+		Quota:            1024,
+		Architectures:    []string{"x86_64", "aarch64", "x86_64"},
+		VersionedIndexes: true,
+	}
+	if err := manager.SetRepoDefaults(defaults); err != nil {
+		t.Fatalf("Failed to set defaults: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+
+	// Both the cached repository and the stored one have them
+	check := func(repo *Repository) {
+		if repo.DeltaPolicy.MaxDeltas != 2 || repo.ConflictPolicy != ConflictReject || !repo.VerifyHashes {
+			t.Fatalf("New repository is missing the defaults: %+v", repo)
+		}
+		if repo.Quota != 1024 || !repo.VersionedIndexes {
+			t.Fatalf("New repository is missing the defaults: %+v", repo)
+		}
+		if !reflect.DeepEqual(repo.Architectures, []string{"aarch64", "x86_64"}) {
+			t.Fatalf("Wrong architectures for new repository: %v", repo.Architectures)
+		}
+	}
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	check(repo)
+	manager.repo.Invalidate("unstable")
+	if repo, err = manager.GetRepo("unstable"); err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	check(repo)
+
+	// Existing repositories are left alone
+	if repo, err = manager.GetRepo("plain"); err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if repo.VerifyHashes || repo.Quota != 0 || len(repo.Architectures) != 0 {
+		t.Fatalf("Defaults were applied to an existing repository: %+v", repo)
+	}
+}
@@ -26,16 +26,17 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // initDistribution will look for the distribution.xml file which will define
 // the all-important Obsoletes set
-func (r *Repository) initDistribution() error {
+func (r *Repository) initDistribution(logger *log.Entry) error {
 	r.dist = nil
 
 	dpath := filepath.Join(r.assetPath, "distribution.xml")
 	if !PathExists(dpath) {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo": r.ID,
 		}).Warning("No distribution.xml defined")
 		return nil
@@ -61,10 +62,10 @@ func (r *Repository) emitDistribution(encoder *xml.Encoder) error {
 
 // emitComponents is responsible for loading the components.xml file from
 // the assets store and merging it into the final index
-func (r *Repository) emitComponents(encoder *xml.Encoder) error {
+func (r *Repository) emitComponents(logger *log.Entry, encoder *xml.Encoder) error {
 	dpath := filepath.Join(r.assetPath, "components.xml")
 	if !PathExists(dpath) {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo": r.ID,
 		}).Warning("No components.xml defined")
 		return nil
@@ -92,10 +93,10 @@ func (r *Repository) emitComponents(encoder *xml.Encoder) error {
 
 // emitGroups is responsible for loading the groups.xml file from
 // the assets store and merging it into the final index
-func (r *Repository) emitGroups(encoder *xml.Encoder) error {
+func (r *Repository) emitGroups(logger *log.Entry, encoder *xml.Encoder) error {
 	dpath := filepath.Join(r.assetPath, "groups.xml")
 	if !PathExists(dpath) {
-		log.WithFields(log.Fields{
+		logger.WithFields(log.Fields{
 			"repo": r.ID,
 		}).Warning("No groups.xml defined")
 		return nil
@@ -163,14 +164,9 @@ func (r *Repository) pushDeltaPackages(db libdb.Database, pool *Pool, entry *Poo
 	return nil
 }
 
-func (r *Repository) emitIndexPackage(db libdb.Database, pool *Pool, pkg string, encoder *xml.Encoder, entry *PoolEntry) error {
-	// Wrap every output item as Package
-	elem := xml.StartElement{
-		Name: xml.Name{
-			Local: "Package",
-		},
-	}
-
+// prepareIndexPackage will determine if the package belongs in the index,
+// attaching its delta packages if so
+func (r *Repository) prepareIndexPackage(logger *log.Entry, db libdb.Database, pool *Pool, pkg string, entry *PoolEntry, report *IndexReport) (bool, error) {
 	// Retain compatibility with eopkg, auto-drop -dbginfo
 	nom := entry.Meta.Name
 	if strings.HasSuffix(nom, "-dbginfo") {
@@ -181,12 +177,13 @@ func (r *Repository) emitIndexPackage(db libdb.Database, pool *Pool, pkg string,
 	// dbginfo trick, warn in the console
 	if r.dist != nil && r.dist.IsObsolete(nom) {
 		if nom != entry.Name {
-			log.WithFields(log.Fields{
+			logger.WithFields(log.Fields{
 				"repo": r.ID,
 				"id":   pkg,
 			}).Error("Abandoned obsolete package, please run 'trim obsolete'")
+			report.add(FindingAbandonedObsolete, pkg, nom)
 		}
-		return nil
+		return false, nil
 	}
 
 	// Warn that a package depends on an obsolete package so that it can be
@@ -194,27 +191,31 @@ func (r *Repository) emitIndexPackage(db libdb.Database, pool *Pool, pkg string,
 	if entry.Meta.RuntimeDependencies != nil && r.dist != nil {
 		for _, p := range *entry.Meta.RuntimeDependencies {
 			if r.dist.IsObsolete(p.Name) {
-				log.WithFields(log.Fields{
+				logger.WithFields(log.Fields{
 					"repo":       r.ID,
 					"package":    entry.Name,
 					"dependency": p.Name,
 				}).Warning("Encountered uninstallable package depending on obsolete package. Please address")
+				report.add(FindingObsoleteDependency, pkg, p.Name)
 			}
 		}
 	}
 
 	// Shove in the delta packages now
 	if err := r.pushDeltaPackages(db, pool, entry); err != nil {
-		return err
+		return false, err
 	}
 
-	return encoder.EncodeElement(entry.Meta, elem)
+	report.Packages++
+	return true, nil
 }
 
-// emitIndex does the heavy lifting of writing to the given file descriptor,
-// i.e. serialising the DB repo out to the index file
-func (r *Repository) emitIndex(db libdb.Database, pool *Pool, file *os.File) error {
+// indexEntries will return the pool entries of every package belonging in
+// the index, in a sane order and with their delta packages attached. Any
+// problems found are added to the report.
+func (r *Repository) indexEntries(logger *log.Entry, db libdb.Database, pool *Pool, report *IndexReport) ([]*PoolEntry, error) {
 	var pkgIds []string
+	repoEntries := make(map[string]*RepoEntry)
 	rootBucket := db.Bucket([]byte(DatabaseBucketRepo)).Bucket([]byte(r.ID)).Bucket([]byte(DatabaseBucketPackage))
 
 	err := rootBucket.ForEach(func(k, v []byte) error {
@@ -223,22 +224,106 @@ func (r *Repository) emitIndex(db libdb.Database, pool *Pool, file *os.File) err
 			return err
 		}
 
+		// Hidden from the index, but should really be trimmed
 		if r.dist != nil && r.dist.IsObsolete(entry.Name) {
+			report.add(FindingAbandonedObsolete, entry.Published, entry.Name)
 			return nil
 		}
 
 		pkgIds = append(pkgIds, entry.Published)
+		repoEntries[entry.Published] = &entry
 		return nil
 	})
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Ensure we'll emit in a sane order
 	sort.Strings(pkgIds)
 
-	encoder := xml.NewEncoder(file)
+	var entries []*PoolEntry
+	for _, pkg := range pkgIds {
+		entry, err := pool.GetEntry(db, pkg)
+		if err != nil {
+			return nil, err
+		}
+		if err = findDuplicateReleases(db, pool, repoEntries[pkg], entry, report); err != nil {
+			return nil, err
+		}
+		include, err := r.prepareIndexPackage(logger, db, pool, pkg, entry, report)
+		if err != nil {
+			return nil, err
+		}
+		if include {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// An indexFilter decides whether the package is included in an index
+type indexFilter func(meta *libeopkg.MetaPackage) bool
+
+// archIndexName returns the file name of the index holding only the packages
+// built for the architecture, i.e. eopkg-index.aarch64.xml
+func archIndexName(arch string) string {
+	return fmt.Sprintf("eopkg-index.%s.xml", arch)
+}
+
+// componentIndexName returns the file name of the index fragment holding
+// only the packages of the component, i.e. eopkg-index.component.system.base.xml
+func componentIndexName(component string) string {
+	return fmt.Sprintf("eopkg-index.component.%s.xml", component)
+}
+
+// validComponentName determines if the component name is safe to use within
+// a file name
+func validComponentName(component string) bool {
+	if component == "" || strings.HasPrefix(component, ".") {
+		return false
+	}
+	for _, c := range component {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// indexComponents will return the sorted components the packages are part
+// of. Components which can't be used in a file name are skipped.
+func (r *Repository) indexComponents(logger *log.Entry, entries []*PoolEntry) []string {
+	seen := make(map[string]bool)
+	var components []string
+	for _, entry := range entries {
+		component := entry.Meta.PartOf
+		if seen[component] {
+			continue
+		}
+		seen[component] = true
+		if !validComponentName(component) {
+			logger.WithFields(log.Fields{
+				"repo":      r.ID,
+				"id":        entry.Name,
+				"component": component,
+			}).Warning("Not emitting index fragment for invalid component name")
+			continue
+		}
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	return components
+}
+
+// emitIndex does the heavy lifting of writing to the given index writer,
+// i.e. serialising the packages from indexEntries out to the index file.
+// Only packages passing the filter are included, unless it is nil.
+func (r *Repository) emitIndex(logger *log.Entry, w *indexWriter, entries []*PoolEntry, filter indexFilter) error {
+	// Encodes straight into our buffer, which is large enough to be used as is
+	encoder := xml.NewEncoder(w.Writer)
 	encoder.Indent("    ", "    ")
 
 	// Ensure we have the start element
@@ -251,23 +336,28 @@ func (r *Repository) emitIndex(db libdb.Database, pool *Pool, file *os.File) err
 		return err
 	}
 
-	for _, pkg := range pkgIds {
-		entry, err := pool.GetEntry(db, pkg)
-		if err != nil {
-			return err
+	// Wrap every output item as Package
+	elem := xml.StartElement{
+		Name: xml.Name{
+			Local: "Package",
+		},
+	}
+	for _, entry := range entries {
+		if filter != nil && !filter(entry.Meta) {
+			continue
 		}
-		if err = r.emitIndexPackage(db, pool, pkg, encoder, entry); err != nil {
+		if err := encoder.EncodeElement(entry.Meta, elem); err != nil {
 			return err
 		}
 	}
 
 	// Stick in the components
-	if err := r.emitComponents(encoder); err != nil {
+	if err := r.emitComponents(logger, encoder); err != nil {
 		return err
 	}
 
 	// Stick in the groups ..
-	if err := r.emitGroups(encoder); err != nil {
+	if err := r.emitGroups(logger, encoder); err != nil {
 		return err
 	}
 
@@ -279,22 +369,119 @@ func (r *Repository) emitIndex(db libdb.Database, pool *Pool, file *os.File) err
 	return encoder.Flush()
 }
 
-// Index will attempt to write the eopkg index out to disk
-// This only requires a read-only database view
-func (r *Repository) Index(db libdb.Database, pool *Pool) error {
+// writeIndex will write the named index into dir, along with its compressed
+// form and the sha1sums of both. Each file is written with a .new suffix, and
+// added to mapping along with the final path it should be renamed to.
+func (r *Repository) writeIndex(dir, name string, mapping map[string]string, verify bool, emit func(w *indexWriter) error) error {
+	indexPath := filepath.Join(dir, name+".new")
+	mapping[indexPath] = filepath.Join(dir, name)
+
+	// Create index file, expecting it to be much like the last one
+	var sizeHint int64
+	if st, err := os.Stat(filepath.Join(r.path, name)); err == nil {
+		sizeHint = st.Size()
+	}
+	w, err := createIndexFile(indexPath, sizeHint)
+	if err != nil {
+		return err
+	}
+
+	// Write the index file
+	err = emit(w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Make sure we'd never publish a broken index
+	if verify {
+		if err := r.validateIndexFile(indexPath); err != nil {
+			return err
+		}
+	}
+
+	// Sing the theme tune
+	indexPathSha := filepath.Join(dir, name+".sha1sum.new")
+	mapping[indexPathSha] = filepath.Join(dir, name+".sha1sum")
+
+	// Star in it
+	if err := WriteSha1sum(indexPath, indexPathSha); err != nil {
+		return err
+	}
+
+	// Write our XZ index out
+	indexPathXz := indexPath + ".xz"
+	mapping[indexPathXz] = filepath.Join(dir, name+".xz")
+
+	if err := libeopkg.XzFile(indexPath, true); err != nil {
+		return err
+	}
+
+	// Write sha1sum for our xz file
+	indexPathXzSha := filepath.Join(dir, name+".xz.sha1sum.new")
+	mapping[indexPathXzSha] = filepath.Join(dir, name+".xz.sha1sum")
+
+	// xz sha1
+	return WriteSha1sum(indexPathXz, indexPathXzSha)
+}
+
+// removeStaleIndexes will remove any architecture indexes or component
+// fragments which weren't just published, such as those of an architecture
+// no longer allowed
+func (r *Repository) removeStaleIndexes(published []string) error {
+	keep := make(map[string]bool)
+	for _, name := range published {
+		keep[name] = true
+	}
+	paths, err := filepath.Glob(filepath.Join(r.path, "eopkg-index.*.xml*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if keep[filepath.Base(path)] {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Index will attempt to write the eopkg index out to disk, and store a
+// report of any problems found along the way. An index is also written for
+// each of the architectures the repository allows, and for each component
+// if the repository asks for component fragments.
+//
+// Versioned indexes are written into a new generation directory instead,
+// which is only published once every artifact is in place.
+func (r *Repository) Index(logger *log.Entry, db libdb.Database, pool *Pool) error {
 	r.indexMut.Lock()
 	defer r.indexMut.Unlock()
 	var errAbort error
 
-	indexPath := filepath.Join(r.path, "eopkg-index.xml.new")
-	indexPathFinal := filepath.Join(r.path, "eopkg-index.xml")
 	mapping := make(map[string]string)
-	mapping[indexPath] = indexPathFinal
+
+	dir := r.path
+	generation := ""
+	if r.VersionedIndexes {
+		var err error
+		if generation, err = r.newIndexGeneration(); err != nil {
+			return err
+		}
+		dir = filepath.Join(r.indexDir(), generation)
+	}
 
 	defer func() {
 		if errAbort != nil {
+			if generation != "" {
+				os.RemoveAll(dir)
+				return
+			}
 			for k := range mapping {
-				log.WithFields(log.Fields{
+				logger.WithFields(log.Fields{
 					"id":    r.ID,
 					"path":  k,
 					"error": errAbort,
@@ -304,58 +491,70 @@ func (r *Repository) Index(db libdb.Database, pool *Pool) error {
 		}
 	}()
 
-	if err := r.initDistribution(); err != nil {
+	if err := r.initDistribution(logger); err != nil {
 		return err
 	}
 
-	// Create index file
-	f, err := os.Create(indexPath)
+	report := &IndexReport{
+		Repo: r.ID,
+		Time: time.Now().UTC(),
+	}
+	entries, err := r.indexEntries(logger, db, pool, report)
 	if err != nil {
 		errAbort = err
 		return errAbort
 	}
-
-	// Write the index file
-	errAbort = r.emitIndex(db, pool, f)
-	f.Close()
+	errAbort = r.writeIndex(dir, "eopkg-index.xml", mapping, r.VerifyIndex, func(w *indexWriter) error {
+		return r.emitIndex(logger, w, entries, nil)
+	})
 	if errAbort != nil {
 		return errAbort
 	}
 
-	// Sing the theme tune
-	indexPathSha := filepath.Join(r.path, "eopkg-index.xml.sha1sum.new")
-	indexPathShaFinal := filepath.Join(r.path, "eopkg-index.xml.sha1sum")
-	mapping[indexPathSha] = indexPathShaFinal
-
-	// Star in it
-	if errAbort = WriteSha1sum(indexPath, indexPathSha); err != nil {
-		return errAbort
-	}
-
-	// Write our XZ index out
-	indexPathXz := filepath.Join(r.path, "eopkg-index.xml.new.xz")
-	indexPathXzFinal := filepath.Join(r.path, "eopkg-index.xml.xz")
-	mapping[indexPathXz] = indexPathXzFinal
-
-	if errAbort = libeopkg.XzFile(indexPath, true); errAbort != nil {
-		return errAbort
+	for _, arch := range r.Architectures {
+		arch := arch
+		errAbort = r.writeIndex(dir, archIndexName(arch), mapping, false, func(w *indexWriter) error {
+			return r.emitIndex(logger, w, entries, func(meta *libeopkg.MetaPackage) bool {
+				return meta.Architecture == arch
+			})
+		})
+		if errAbort != nil {
+			return errAbort
+		}
 	}
 
-	// Write sha1sum for our xz file
-	indexPathXzSha := filepath.Join(r.path, "eopkg-index.xml.xz.sha1sum.new")
-	indexPathXzShaFinal := filepath.Join(r.path, "eopkg-index.xml.xz.sha1sum")
-	mapping[indexPathXzSha] = indexPathXzShaFinal
-
-	// xz sha1
-	if errAbort = WriteSha1sum(indexPathXz, indexPathXzSha); err != nil {
-		return errAbort
+	// Fragments for tooling which only needs some of the components
+	if r.ComponentIndexes {
+		for _, component := range r.indexComponents(logger, entries) {
+			component := component
+			errAbort = r.writeIndex(dir, componentIndexName(component), mapping, false, func(w *indexWriter) error {
+				return r.emitIndex(logger, w, entries, func(meta *libeopkg.MetaPackage) bool {
+					return meta.PartOf == component
+				})
+			})
+			if errAbort != nil {
+				return errAbort
+			}
+		}
 	}
 
+	var published []string
 	for k, v := range mapping {
 		if errAbort = os.Rename(k, v); errAbort != nil {
 			return errAbort
 		}
+		published = append(published, filepath.Base(v))
+	}
+	if generation != "" {
+		if err := r.publishIndexGeneration(generation, published); err != nil {
+			return err
+		}
+	} else if err := r.removeIndexGenerations(); err != nil {
+		return err
+	}
+	if err := r.removeStaleIndexes(published); err != nil {
+		return err
 	}
 
-	return nil
+	return putIndexReport(db, report)
 }
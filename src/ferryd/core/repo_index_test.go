@@ -0,0 +1,171 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComponentIndexes(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetComponentIndexes("unstable", true); err != nil {
+		t.Fatalf("Failed to enable component indexes: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	info, err := manager.GetPackageInfo("unstable", "nano")
+	if err != nil {
+		t.Fatalf("Failed to get package info: %v", err)
+	}
+	component := info.Published.Meta.PartOf
+	if component == "" {
+		t.Fatalf("Test package has no component")
+	}
+
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	fragment := filepath.Join(repo.path, componentIndexName(component))
+	data, err := ioutil.ReadFile(fragment)
+	if err != nil {
+		t.Fatalf("Missing index fragment for %s: %v", component, err)
+	}
+	if !strings.Contains(string(data), filepath.Base(searchTestPackage)) {
+		t.Fatalf("Index fragment for %s doesn't include the package", component)
+	}
+
+	// Fragments go away again once disabled
+	if err := manager.SetComponentIndexes("unstable", false); err != nil {
+		t.Fatalf("Failed to disable component indexes: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index: %v", err)
+	}
+	if PathExists(fragment) || PathExists(fragment+".xz") {
+		t.Fatalf("Index fragment left behind after disabling")
+	}
+}
+
+func TestVersionedIndexes(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetVersionedIndexes("unstable", true); err != nil {
+		t.Fatalf("Failed to enable versioned indexes: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := manager.Index("unstable"); err != nil {
+			t.Fatalf("Failed to index: %v", err)
+		}
+	}
+
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	index := filepath.Join(repo.path, "eopkg-index.xml")
+	for _, name := range []string{"eopkg-index.xml", "eopkg-index.xml.sha1sum", "eopkg-index.xml.xz", "eopkg-index.xml.xz.sha1sum"} {
+		dest, err := os.Readlink(filepath.Join(repo.path, name))
+		if err != nil {
+			t.Fatalf("%s isn't a symlink: %v", name, err)
+		}
+		if dest != filepath.Join(IndexPathComponent, indexCurrentLink, name) {
+			t.Fatalf("%s doesn't point at the current generation: %s", name, dest)
+		}
+	}
+	if current, err := os.Readlink(filepath.Join(repo.indexDir(), indexCurrentLink)); err != nil || current != "5" {
+		t.Fatalf("Expected generation 5 to be current, got %s: %v", current, err)
+	}
+	gens, err := repo.indexGenerations()
+	if err != nil {
+		t.Fatalf("Failed to list generations: %v", err)
+	}
+	if len(gens) != keepIndexGenerations || gens[0] != 3 {
+		t.Fatalf("Old generations weren't pruned: %v", gens)
+	}
+
+	sum, err := ioutil.ReadFile(index + ".sha1sum")
+	if err != nil {
+		t.Fatalf("Failed to read index sha1sum: %v", err)
+	}
+	want, err := FileSha1sum(index)
+	if err != nil {
+		t.Fatalf("Failed to hash index: %v", err)
+	}
+	if strings.TrimSpace(string(sum)) != want {
+		t.Fatalf("Index and sha1sum don't match: %s != %s", sum, want)
+	}
+	data, err := ioutil.ReadFile(index)
+	if err != nil || !strings.Contains(string(data), filepath.Base(searchTestPackage)) {
+		t.Fatalf("Versioned index doesn't include the package: %v", err)
+	}
+
+	// Going back to plain indexes leaves no generations behind
+	if err := manager.SetVersionedIndexes("unstable", false); err != nil {
+		t.Fatalf("Failed to disable versioned indexes: %v", err)
+	}
+	if err := manager.Index("unstable"); err != nil {
+		t.Fatalf("Failed to index: %v", err)
+	}
+	st, err := os.Lstat(index)
+	if err != nil || !st.Mode().IsRegular() {
+		t.Fatalf("Index wasn't replaced with a regular file: %v", err)
+	}
+	if PathExists(repo.indexDir()) {
+		t.Fatalf("Index generations left behind after disabling")
+	}
+}
+
+func TestValidComponentName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"system.base":     true,
+		"desktop.gnome":   true,
+		"xorg":            true,
+		"":                false,
+		".hidden":         false,
+		"../system.base":  false,
+		"system/base":     false,
+		"programming lua": false,
+	} {
+		if got := validComponentName(name); got != valid {
+			t.Fatalf("Component '%s': expected valid %v, got %v", name, valid, got)
+		}
+	}
+}
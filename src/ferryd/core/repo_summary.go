@@ -0,0 +1,131 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"libdb"
+	"sync"
+	"time"
+)
+
+// A RepoSummary describes a repository at a glance
+type RepoSummary struct {
+	ID       string
+	Packages int       // Package names in the repository
+	Releases int       // Releases available across all of them
+	Deltas   int       // Delta packages known to the repository
+	Size     int64     // Bytes used by the packages and deltas
+	Held     int       // Sources frozen against pulls and promotion
+	Indexed  time.Time // When the last index was written, zero if never
+
+	LastIndex IndexStatus // How the last index went, successful or not
+	Dirty     bool        // Packages changed since the last successful index
+}
+
+// The summaryCache keeps the summary of each repository until it changes,
+// as working one out means visiting every package in the repository
+type summaryCache struct {
+	entries    map[string]*RepoSummary
+	generation uint64 // Bumped on every change, so a stale summary isn't cached
+	mut        *sync.Mutex
+}
+
+// newSummaryCache will return an empty summaryCache
+func newSummaryCache() *summaryCache {
+	return &summaryCache{
+		entries: make(map[string]*RepoSummary),
+		mut:     &sync.Mutex{},
+	}
+}
+
+// get will return the cached summary of the repository, if any, and the
+// generation to store a new one with
+func (c *summaryCache) get(repoID string) (*RepoSummary, uint64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.entries[repoID], c.generation
+}
+
+// put will cache the summary, unless anything changed since generation
+func (c *summaryCache) put(summary *RepoSummary, generation uint64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if generation == c.generation {
+		c.entries[summary.ID] = summary
+	}
+}
+
+// forget will drop the cached summary of the repository
+func (c *summaryCache) forget(repoID string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.generation++
+	delete(c.entries, repoID)
+}
+
+// summarise will work out the summary of the repository, other than the
+// held sources and index status which may change at any time
+func (r *Repository) summarise(db libdb.Database, pool *Pool) (*RepoSummary, error) {
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return nil, err
+	}
+	ret := &RepoSummary{
+		ID:       r.ID,
+		Packages: len(entries),
+	}
+	var ids []string
+	for _, entry := range entries {
+		ret.Releases += len(entry.Available)
+		ret.Deltas += len(entry.Deltas)
+		ids = append(ids, entry.Available...)
+		ids = append(ids, entry.Deltas...)
+	}
+	if ret.Size, err = poolEntriesSize(db, pool, ids); err != nil {
+		return nil, err
+	}
+	if report, err := GetIndexReport(db, r.ID); err == nil {
+		ret.Indexed = report.Time
+	}
+	return ret, nil
+}
+
+// GetRepoSummaries will return the summary of every repository. Summaries
+// are cached until the repository is next indexed, so only repositories
+// which changed since the last call need to be visited.
+func (m *Manager) GetRepoSummaries() ([]*RepoSummary, error) {
+	repos, err := m.GetRepos()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*RepoSummary, 0, len(repos))
+	for _, repo := range repos {
+		summary, generation := m.summaries.get(repo.ID)
+		if summary == nil {
+			if summary, err = repo.summarise(m.db, m.pool); err != nil {
+				return nil, err
+			}
+			m.summaries.put(summary, generation)
+		}
+		copied := *summary
+		copied.Held = len(repo.Held)
+		copied.LastIndex = repo.LastIndex
+		copied.Dirty = repo.Dirty
+		ret = append(ret, &copied)
+	}
+	return ret, nil
+}
@@ -0,0 +1,92 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRepoSummaries(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	summarise := func() *RepoSummary {
+		summaries, err := manager.GetRepoSummaries()
+		if err != nil {
+			t.Fatalf("Failed to get summaries: %v", err)
+		}
+		if len(summaries) != 1 || summaries[0].ID != "unstable" {
+			t.Fatalf("Expected a summary of unstable, got: %v", summaries)
+		}
+		return summaries[0]
+	}
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	summary := summarise()
+	if summary.Packages != 0 || summary.Size != 0 {
+		t.Fatalf("New repository has wrong summary: %v", summary)
+	}
+
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	st, err := os.Stat(searchTestPackage)
+	if err != nil {
+		t.Fatalf("Failed to stat package: %v", err)
+	}
+	summary = summarise()
+	if summary.Packages != 1 || summary.Releases != 1 || summary.Size != st.Size() {
+		t.Fatalf("Wrong summary after adding package: %v", summary)
+	}
+	if summary.Indexed.IsZero() {
+		t.Fatalf("Index time missing from summary")
+	}
+	indexed := summary.Indexed
+
+	// Held sources don't need an index to show up
+	if err := manager.HoldSource("unstable", "nano"); err != nil {
+		t.Fatalf("Failed to hold source: %v", err)
+	}
+	if summary = summarise(); summary.Held != 1 {
+		t.Fatalf("Held source missing from summary: %v", summary)
+	}
+
+	// The cached summary must be replaced after the next index
+	if err := manager.AddPackages("unstable", []string{writeConflictingPackage(t, dir)}, false, nil); err != nil {
+		t.Fatalf("Failed to add conflicting package: %v", err)
+	}
+	summary = summarise()
+	if summary.Packages != 1 || summary.Releases != 2 || summary.Size <= st.Size() {
+		t.Fatalf("Summary wasn't refreshed after index: %v", summary)
+	}
+	if summary.Indexed.Before(indexed) {
+		t.Fatalf("Index time wasn't refreshed: %v", summary)
+	}
+}
@@ -0,0 +1,267 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRepoCache(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	before, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+
+	// Changes are seen by the next lookup, without touching repos in use
+	if err := manager.SetVerifyHashes("unstable", true); err != nil {
+		t.Fatalf("Failed to set verify hashes: %v", err)
+	}
+	after, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if before.VerifyHashes || !after.VerifyHashes {
+		t.Fatalf("Expected only the new repo to verify hashes")
+	}
+	if before.insertMut != after.insertMut || before.indexMut != after.indexMut {
+		t.Fatalf("Invalidated repos must share their locks with their replacement")
+	}
+
+	// Look the repo up constantly while it is deleted and created again
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					manager.GetRepo("unstable")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if err := manager.DeleteRepo("unstable"); err != nil {
+			t.Fatalf("Failed to delete repo: %v", err)
+		}
+		if err := manager.CreateRepo("unstable"); err != nil {
+			t.Fatalf("Failed to create repo again: %v", err)
+		}
+	}
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	close(stop)
+	wg.Wait()
+
+	if _, err := manager.GetRepo("unstable"); err == nil {
+		t.Fatalf("Deleted repo should not be served from the cache")
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo again: %v", err)
+	}
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if repo.VerifyHashes {
+		t.Fatalf("Recreated repo has the settings of the deleted one")
+	}
+}
+
+func TestRepoHold(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	for _, repoID := range []string{"unstable", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", pkgs[:1], false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+	if _, err := manager.PullRepo("unstable", "stable"); err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", pkgs[1:], false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := manager.HoldSource("stable", "nano"); err != nil {
+			t.Fatalf("Failed to hold source: %v", err)
+		}
+	}
+	repo, err := manager.GetRepo("stable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	if len(repo.Held) != 1 || !repo.IsHeld("nano") {
+		t.Fatalf("Expected nano to be held once, got %v", repo.Held)
+	}
+
+	// Held sources are reported by the diff, and left alone by pull & promote
+	diff, err := manager.DiffRepos("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to diff repos: %v", err)
+	}
+	if len(diff.Newer) != 0 || len(diff.Held) != 1 {
+		t.Fatalf("Expected nano to be held back, got %+v", diff)
+	}
+	changed, err := manager.PullRepo("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("Held source was pulled: %v", changed)
+	}
+	if err := manager.CheckPromotion("unstable", "stable", "nano", 76); err == nil || !strings.Contains(err.Error(), "held") {
+		t.Fatalf("Held source should not be promoted, got %v", err)
+	}
+
+	if err := manager.UnholdSource("stable", "nano"); err != nil {
+		t.Fatalf("Failed to release source: %v", err)
+	}
+	changed, err = manager.PullRepo("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to pull repo: %v", err)
+	}
+	if len(changed) != 1 {
+		t.Fatalf("Released source should be pulled, got %v", changed)
+	}
+}
+
+func TestRepoPullSource(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	for _, repoID := range []string{"unstable", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	pkgs := []string{
+		searchTestPackage,
+		writePromotePackage(t, dir, "app", "app", 1),
+		writePromotePackage(t, dir, "app-dbginfo", "app", 1),
+	}
+	if err := manager.AddPackages("unstable", pkgs, false, nil); err != nil {
+		t.Fatalf("Failed to add packages: %v", err)
+	}
+
+	changed, err := manager.PullSource("unstable", "stable", "app")
+	if err != nil {
+		t.Fatalf("Failed to pull source: %v", err)
+	}
+	if len(changed) != 2 {
+		t.Fatalf("Expected app and app-dbginfo to be pulled, got %v", changed)
+	}
+	if _, err := manager.GetPackageInfo("stable", "nano"); err == nil {
+		t.Fatalf("Only the requested source should be pulled")
+	}
+
+	// Pulling again is a no-op
+	changed, err = manager.PullSource("unstable", "stable", "app")
+	if err != nil {
+		t.Fatalf("Failed to pull source again: %v", err)
+	}
+	if len(changed) != 0 {
+		t.Fatalf("Up to date source was pulled again: %v", changed)
+	}
+
+	if _, err := manager.PullSource("unstable", "stable", "missing"); err == nil {
+		t.Fatalf("Pulling a missing source should fail")
+	}
+	if err := manager.HoldSource("stable", "nano"); err != nil {
+		t.Fatalf("Failed to hold source: %v", err)
+	}
+	if _, err := manager.PullSource("unstable", "stable", "nano"); err == nil {
+		t.Fatalf("Pulling a held source should fail")
+	}
+}
+
+func TestRepoDiffUnpublished(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	for _, repoID := range []string{"unstable", "stable"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+	}
+	if err := manager.AddPackages("unstable", []string{"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg"}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	// Entries left with nothing published, on either side
+	unpublished := map[string][]string{
+		"unstable": {"vim"},
+		"stable":   {"nano", "emacs"},
+	}
+	for repoID, names := range unpublished {
+		repo, err := manager.GetRepo(repoID)
+		if err != nil {
+			t.Fatalf("Failed to get repo: %v", err)
+		}
+		for _, name := range names {
+			if err := repo.putEntry(manager.db, &RepoEntry{Name: name}); err != nil {
+				t.Fatalf("Failed to store entry: %v", err)
+			}
+		}
+	}
+
+	diff, err := manager.DiffRepos("unstable", "stable")
+	if err != nil {
+		t.Fatalf("Failed to diff repos with unpublished entries: %v", err)
+	}
+	if len(diff.Missing) != 1 || diff.Missing[0].Name != "nano" {
+		t.Fatalf("Expected nano to be missing from stable, got %+v", diff.Missing)
+	}
+	if len(diff.Newer) != 0 || len(diff.Obsolete) != 0 || len(diff.Inconsistent) != 0 {
+		t.Fatalf("Unpublished entries should not be compared, got %+v", diff)
+	}
+}
@@ -0,0 +1,329 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrEmptyPatch is returned when asked to rewrite a package without
+	// changing anything
+	ErrEmptyPatch = errors.New("No metadata changes were requested")
+)
+
+// A MetadataPatch describes changes to the metadata of a stored package, to
+// fix mistakes without needing a new build. Only fields which are set will
+// be changed.
+type MetadataPatch struct {
+	Summary     *string  // English summary
+	Description *string  // English description
+	PartOf      *string  // Component
+	License     []string // Replaces all licenses
+}
+
+// IsEmpty will return true if the patch doesn't change anything
+func (m *MetadataPatch) IsEmpty() bool {
+	return m.Summary == nil && m.Description == nil && m.PartOf == nil && len(m.License) == 0
+}
+
+// setLocalised will replace the English value of the localised field
+func setLocalised(fields *[]libeopkg.LocalisedField, value string) {
+	for i := range *fields {
+		f := &(*fields)[i]
+		if f.Lang == "" || f.Lang == "en" {
+			f.Value = value
+			return
+		}
+	}
+	*fields = append(*fields, libeopkg.LocalisedField{Value: value, Lang: "en"})
+}
+
+// Apply will modify the package metadata according to the patch
+func (m *MetadataPatch) Apply(meta *libeopkg.MetaPackage) {
+	if m.Summary != nil {
+		setLocalised(&meta.Summary, *m.Summary)
+	}
+	if m.Description != nil {
+		setLocalised(&meta.Description, *m.Description)
+	}
+	if m.PartOf != nil {
+		meta.PartOf = *m.PartOf
+	}
+	if len(m.License) > 0 {
+		meta.License = append([]string{}, m.License...)
+	}
+}
+
+// String will return a short summary of the changed fields
+func (m *MetadataPatch) String() string {
+	var changes []string
+	if m.Summary != nil {
+		changes = append(changes, "summary")
+	}
+	if m.Description != nil {
+		changes = append(changes, "description")
+	}
+	if m.PartOf != nil {
+		changes = append(changes, fmt.Sprintf("component=%s", *m.PartOf))
+	}
+	if len(m.License) > 0 {
+		changes = append(changes, fmt.Sprintf("license=%s", strings.Join(m.License, ",")))
+	}
+	return strings.Join(changes, " ")
+}
+
+// RewritePackage will replace the pool copy of the package with a rewritten
+// one carrying the patched metadata. The new archive gets a new hash and
+// size, but keeps the same ID so that all references remain valid. It isn't
+// signed, as ferryd has no support for signing packages.
+//
+// Repositories still hold links to the old file, and must be relinked.
+func (p *Pool) RewritePackage(logger *log.Entry, db libdb.Database, id string, patch *MetadataPatch) (*PoolEntry, error) {
+	entry, err := p.GetEntry(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Delta != nil {
+		return nil, fmt.Errorf("Cannot rewrite delta package: %s", id)
+	}
+
+	oldRel := p.relativePath(entry)
+	oldHash := entry.ContentHash
+	poolPath := filepath.Join(p.poolDir, oldRel)
+	pkg, err := libeopkg.Open(poolPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pkg.Close()
+	if err = pkg.ReadAll(); err != nil {
+		return nil, err
+	}
+
+	patch.Apply(&pkg.Meta.Package)
+
+	// Compose the new archive alongside the old one so we can swap it in
+	tmpPath := poolPath + ".rewrite"
+	pw, err := libeopkg.NewPackageWriter(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pw.Close()
+
+	if err = pw.WriteMetadata(pkg.Meta); err != nil {
+		return nil, err
+	}
+	if err = pw.WriteFiles(pkg.Files); err != nil {
+		return nil, err
+	}
+	// Everything else, i.e. install.tar.xz and comar scripts, is untouched
+	for _, f := range pkg.FileHeaders() {
+		if f.Name == "metadata.xml" || f.Name == "files.xml" {
+			continue
+		}
+		if err = pw.CopyFile(f); err != nil {
+			return nil, err
+		}
+	}
+	if err = pw.Commit(); err != nil {
+		return nil, err
+	}
+
+	st, err := os.Stat(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	hashes, err := hashFile(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	meta := &pkg.Meta.Package
+
+	// Files stored by content may be shared, so the new content gets its
+	// own file rather than replacing the old one
+	newRel := oldRel
+	if entry.Path != "" {
+		newRel = layoutPath(PoolLayoutContent, id, meta, hashes.sha256)
+	}
+	newPath := filepath.Join(p.poolDir, newRel)
+	undo, err := swapRewrittenFile(tmpPath, newPath, newRel == oldRel)
+	if err != nil {
+		return nil, err
+	}
+
+	meta.PackageHash = hashes.sha1
+	meta.PackageSize = st.Size()
+	meta.PackageURI = entry.Meta.PackageURI
+	entry.Meta = meta
+
+	// The rename gave us our own file, no longer shared with any alias
+	entry.ContentHash = hashes.sha256
+	entry.Alias = ""
+	setEntryPath(entry, newRel)
+
+	if err = p.putEntry(db, entry); err != nil {
+		undo(false)
+		return nil, err
+	}
+	undo(true)
+	if newRel != oldRel {
+		shared, err := p.pathShared(db, entry, oldHash, oldRel)
+		if err != nil {
+			return nil, err
+		}
+		if !shared {
+			removePoolFile(logger, poolPath)
+		}
+	}
+	return entry, nil
+}
+
+// swapRewrittenFile will move the rewritten archive into place at path,
+// replacing the old archive if it's inPlace. Otherwise a file already at
+// path is stored by the same content, and is left alone. Once the pool entry
+// has been updated, the returned function must be called with whether the
+// new file is to be kept, so that the old one is put back on failure.
+func swapRewrittenFile(tmpPath, path string, inPlace bool) (func(keep bool), error) {
+	if !inPlace && PathExists(path) {
+		os.Remove(tmpPath)
+		return func(bool) {}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 00755); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	// Keep the old file around until the entry describes the new one
+	backup := path + ".orig"
+	if inPlace {
+		os.Remove(backup)
+		if err := os.Link(path, backup); err != nil {
+			os.Remove(tmpPath)
+			return nil, err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		if inPlace {
+			os.Remove(backup)
+		}
+		return nil, err
+	}
+
+	return func(keep bool) {
+		switch {
+		case keep:
+			os.Remove(backup)
+		case inPlace:
+			os.Rename(backup, path)
+		default:
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// RelinkPackage will replace our link to the package with a link to the
+// current pool copy, i.e. after it was rewritten. Any deltas leading to the
+// package are removed, as they embed the old metadata.
+func (r *Repository) RelinkPackage(logger *log.Entry, db libdb.Database, pool *Pool, id string) error {
+	poolEntry, err := pool.GetEntry(db, id)
+	if err != nil {
+		return err
+	}
+
+	if err = r.relinkPackageInternal(pool, id, poolEntry); err != nil {
+		return err
+	}
+
+	entry, err := r.GetEntry(db, poolEntry.Meta.Name)
+	if err != nil {
+		return err
+	}
+
+	var remainDeltas []string
+	for _, deltaID := range entry.Deltas {
+		pkgDelta, err := pool.GetEntry(db, deltaID)
+		if err != nil {
+			return err
+		}
+		if pkgDelta.Delta == nil || pkgDelta.Delta.ToID != id {
+			remainDeltas = append(remainDeltas, deltaID)
+			continue
+		}
+		logger.WithFields(log.Fields{
+			"repo":  r.ID,
+			"delta": deltaID,
+		}).Info("Removing delta to rewritten package")
+		if err := r.removeDeltaInternal(logger, db, pool, deltaID); err != nil {
+			return err
+		}
+	}
+	if len(remainDeltas) == len(entry.Deltas) {
+		return nil
+	}
+	entry.Deltas = remainDeltas
+	return r.putEntry(db, entry)
+}
+
+// relinkPackageInternal will atomically swap our link for a new one, so
+// that the package never goes missing from the tree
+func (r *Repository) relinkPackageInternal(pool *Pool, id string, poolEntry *PoolEntry) error {
+	r.insertMut.Lock()
+	defer r.insertMut.Unlock()
+
+	source := pool.EntryPath(poolEntry)
+	target := filepath.Join(r.path, poolEntry.Meta.GetPathComponent(), id)
+	tmpTarget := target + ".relink"
+
+	os.Remove(tmpTarget)
+	if err := LinkOrCopyFile(source, tmpTarget, false); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpTarget, target); err != nil {
+		os.Remove(tmpTarget)
+		return err
+	}
+	return nil
+}
+
+// HasPackage will determine whether the given package ID is available in
+// the repository
+func (r *Repository) HasPackage(db libdb.Database, pool *Pool, id string) (bool, error) {
+	poolEntry, err := pool.GetEntry(db, id)
+	if err != nil {
+		return false, err
+	}
+	entry, err := r.GetEntry(db, poolEntry.Meta.Name)
+	if err != nil {
+		return false, nil
+	}
+	for _, pkgID := range entry.Available {
+		if pkgID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
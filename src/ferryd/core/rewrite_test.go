@@ -0,0 +1,162 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"io/ioutil"
+	"libeopkg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriteMetadata(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	pkgID := filepath.Base(searchTestPackage)
+	oldMeta, err := manager.GetPoolEntry(pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+
+	if _, err := manager.RewriteMetadata(pkgID, &MetadataPatch{}); err != ErrEmptyPatch {
+		t.Fatalf("Empty patch should be rejected, got: %v", err)
+	}
+
+	summary := "Fixed summary"
+	partOf := "system.utils"
+	repos, err := manager.RewriteMetadata(pkgID, &MetadataPatch{
+		Summary: &summary,
+		PartOf:  &partOf,
+	})
+	if err != nil {
+		t.Fatalf("Failed to rewrite metadata: %v", err)
+	}
+	if len(repos) != 1 || repos[0] != "unstable" {
+		t.Fatalf("Expected only unstable to be affected, got: %v", repos)
+	}
+
+	meta, err := manager.GetPoolEntry(pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if meta.PartOf != partOf || meta.Summary[0].Value != summary {
+		t.Fatalf("Pool entry not updated: %s %s", meta.PartOf, meta.Summary[0].Value)
+	}
+	if meta.PackageHash == oldMeta.PackageHash {
+		t.Fatalf("Package hash was not updated")
+	}
+
+	// The repository must now serve the rewritten package
+	repo, err := manager.GetRepo("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo: %v", err)
+	}
+	repoPath := filepath.Join(repo.path, meta.GetPathComponent(), pkgID)
+	sha, err := FileSha1sum(repoPath)
+	if err != nil {
+		t.Fatalf("Failed to hash repo package: %v", err)
+	}
+	if sha != meta.PackageHash {
+		t.Fatalf("Repository package has wrong hash: %s", sha)
+	}
+
+	pkg, err := libeopkg.Open(repoPath)
+	if err != nil {
+		t.Fatalf("Failed to open rewritten package: %v", err)
+	}
+	defer pkg.Close()
+	if err := pkg.ReadAll(); err != nil {
+		t.Fatalf("Failed to read rewritten package: %v", err)
+	}
+	if pkg.Meta.Package.PartOf != partOf || pkg.Meta.Package.Summary[0].Value != summary {
+		t.Fatalf("Rewritten package has wrong metadata")
+	}
+	if pkg.Meta.Package.Name != oldMeta.Name || len(pkg.Files.File) == 0 {
+		t.Fatalf("Rewritten package lost data")
+	}
+	if pkg.FindFile("install.tar.xz") == nil {
+		t.Fatalf("Rewritten package lost install.tar.xz")
+	}
+}
+
+func TestSwapRewrittenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rewrite")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nano.eopkg")
+	tmpPath := path + ".rewrite"
+	content := func(path string) string {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	// The old file comes back when the entry couldn't be updated
+	ioutil.WriteFile(path, []byte("old"), 00644)
+	ioutil.WriteFile(tmpPath, []byte("new"), 00644)
+	undo, err := swapRewrittenFile(tmpPath, path, true)
+	if err != nil {
+		t.Fatalf("Failed to swap file: %v", err)
+	}
+	if got := content(path); got != "new" {
+		t.Fatalf("Rewritten file wasn't moved into place: %s", got)
+	}
+	undo(false)
+	if got := content(path); got != "old" {
+		t.Fatalf("Old file wasn't put back: %s", got)
+	}
+
+	ioutil.WriteFile(tmpPath, []byte("new"), 00644)
+	if undo, err = swapRewrittenFile(tmpPath, path, true); err != nil {
+		t.Fatalf("Failed to swap file: %v", err)
+	}
+	undo(true)
+	if got := content(path); got != "new" {
+		t.Fatalf("Rewritten file wasn't kept: %s", got)
+	}
+	if PathExists(path+".orig") || PathExists(tmpPath) {
+		t.Fatalf("Old file was left behind")
+	}
+
+	// A new content addressed file is removed again
+	newPath := filepath.Join(dir, "ab", "nano.eopkg")
+	ioutil.WriteFile(tmpPath, []byte("newer"), 00644)
+	if undo, err = swapRewrittenFile(tmpPath, newPath, false); err != nil {
+		t.Fatalf("Failed to swap file: %v", err)
+	}
+	undo(false)
+	if PathExists(newPath) || content(path) != "new" {
+		t.Fatalf("New file wasn't removed again")
+	}
+}
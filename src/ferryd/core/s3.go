@@ -0,0 +1,271 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultS3Region is used when no region is configured, which is what
+	// most S3 compatible services expect
+	DefaultS3Region = "us-east-1"
+
+	// DefaultS3Timeout is how long each request may take, including the
+	// upload of its body, if not configured
+	DefaultS3Timeout = 10 * time.Minute
+
+	// s3EmptyPayload is the SHA256 of an empty request body
+	s3EmptyPayload = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// s3TimeFormat is the timestamp format used when signing requests
+	s3TimeFormat = "20060102T150405Z"
+)
+
+// S3Config describes a bucket on an S3 compatible service
+type S3Config struct {
+	Endpoint      string // i.e. "https://s3.eu-west-1.amazonaws.com"
+	Region        string // Defaults to DefaultS3Region
+	Bucket        string
+	Prefix        string // Prepended to every key, i.e. "solus/"
+	AccessKey     string
+	SecretKey     string
+	VirtualHosted bool          // Address the bucket as a subdomain of the endpoint
+	Timeout       time.Duration // Per request, defaults to DefaultS3Timeout
+}
+
+// An S3Store publishes repository trees to an S3 compatible bucket, signing
+// each request with AWS Signature Version 4
+type S3Store struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time // Overridden in tests
+}
+
+// s3Error is the body of a failed S3 request
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// NewS3Store will validate the configuration and return a new S3Store
+func NewS3Store(config *S3Config) (*S3Store, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("no S3 bucket has been set")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 credentials have not been set")
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %v", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", config.Endpoint)
+	}
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("S3 timeout cannot be negative: %v", config.Timeout)
+	}
+	s := &S3Store{
+		config:   *config,
+		endpoint: endpoint,
+		now:      time.Now,
+	}
+	if s.config.Region == "" {
+		s.config.Region = DefaultS3Region
+	}
+	if s.config.Timeout == 0 {
+		s.config.Timeout = DefaultS3Timeout
+	}
+	s.client = &http.Client{Timeout: s.config.Timeout}
+	return s, nil
+}
+
+// String will describe the bucket, i.e. "s3://packages/solus"
+func (s *S3Store) String() string {
+	return "s3://" + path.Join(s.config.Bucket, s.config.Prefix)
+}
+
+// objectURL returns the URL for the key within our bucket
+func (s *S3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	key = strings.TrimPrefix(s.config.Prefix+key, "/")
+	if s.config.VirtualHosted {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = path.Join("/", u.Path, key)
+	} else {
+		u.Path = path.Join("/", u.Path, s.config.Bucket, key)
+	}
+	// Send the path exactly as it is signed
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+// s3EscapePath will URI encode each segment of the path as required by the
+// canonical request, which is stricter than url.PathEscape
+func s3EscapePath(p string) string {
+	var buf bytes.Buffer
+	for _, c := range []byte(p) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+			buf.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~', c == '/':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// hmacSHA256 returns the HMAC of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign will add the AWS Signature Version 4 authorization to the request,
+// covering the host and every header already set on it
+func (s *S3Store) sign(req *http.Request, payloadHash string) {
+	now := s.now().UTC()
+	amzDate := now.Format(s3TimeFormat)
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := strings.Join([]string{date, s.config.Region, "s3", "aws4_request"}, "/")
+	toSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// do will sign and send the request, turning any failure into an error
+func (s *S3Store) do(req *http.Request, payloadHash string) error {
+	s.sign(req, payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	s3err := s3Error{}
+	if xml.Unmarshal(body, &s3err) == nil && s3err.Code != "" {
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, s3err.Code, s3err.Message)
+	}
+	return fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+}
+
+// contentType returns the Content-Type to store with the key
+func contentType(key string) string {
+	switch {
+	case strings.HasSuffix(key, ".xml"):
+		return "application/xml"
+	case strings.HasSuffix(key, ".xz"):
+		return "application/x-xz"
+	case strings.HasSuffix(key, ".sha1sum"):
+		return "text/plain"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// Put will upload the local file to the key
+func (s *S3Store) Put(key, localPath string, size int64, sha256 string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key).String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType(key))
+	return s.do(req, sha256)
+}
+
+// Delete will remove the object from the bucket. S3 doesn't consider a
+// missing key to be an error.
+func (s *S3Store) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(req, s3EmptyPayload)
+}
@@ -0,0 +1,471 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libdb"
+	"libeopkg"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	// DatabaseBucketSearch is the root bucket for the search index
+	DatabaseBucketSearch = "search"
+
+	// DatabaseBucketSearchToken maps each token to the documents containing it
+	DatabaseBucketSearchToken = "token"
+
+	// DatabaseBucketSearchDocument stores the searchable text for each
+	// published package, keyed by "repo/name"
+	DatabaseBucketSearchDocument = "document"
+
+	// SearchSchemaVersion is the current version of search documents
+	SearchSchemaVersion = "1.0"
+)
+
+var (
+	// searchBuiltKey marks that the search index has been populated at
+	// least once, so that existing installations are indexed on upgrade
+	searchBuiltKey = []byte("built")
+)
+
+// A SearchDocument holds the searchable text of the published release of a
+// package within a single repository
+type SearchDocument struct {
+	SchemaVersion string
+	Repo          string
+	Name          string
+	PackageID     string // Pool ID, used to skip unchanged packages
+	Version       string
+	Release       int
+	Summary       string
+	Description   string
+	Tokens        []string // Tokens this document is filed under
+}
+
+// A SearchPosting lists every document containing a token
+type SearchPosting struct {
+	Documents []string
+}
+
+// SearchQuery describes a search request
+type SearchQuery struct {
+	Pattern string // Substring, or regular expression if Regex is set
+	Regex   bool
+	Repo    string // Limit to this repository, or all if empty
+}
+
+// The SearchIndex is a small inverted index over package names, summaries
+// and descriptions, which is kept up to date every time a repository is
+// indexed.
+type SearchIndex struct {
+	mut *sync.Mutex
+}
+
+// Init will prepare the search index for use
+func (s *SearchIndex) Init(ctx *Context, db libdb.Database) error {
+	s.mut = &sync.Mutex{}
+	return nil
+}
+
+// Close doesn't currently do anything
+func (s *SearchIndex) Close() {}
+
+// searchDocumentKey returns the key for a package within a repository
+func searchDocumentKey(repoID, name string) string {
+	return fmt.Sprintf("%s/%s", repoID, name)
+}
+
+// localisedText will return the default language value of the field
+func localisedText(fields []libeopkg.LocalisedField) string {
+	for _, f := range fields {
+		if f.Lang == "" || f.Lang == "en" {
+			return strings.TrimSpace(f.Value)
+		}
+	}
+	if len(fields) > 0 {
+		return strings.TrimSpace(fields[0].Value)
+	}
+	return ""
+}
+
+// tokenize will split the text into unique lower case words
+func tokenize(seen map[string]bool, text string) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range words {
+		if len(w) > 1 {
+			seen[w] = true
+		}
+	}
+}
+
+// newSearchDocument will build the document for a published package
+func newSearchDocument(repoID string, entry *PoolEntry) *SearchDocument {
+	doc := &SearchDocument{
+		SchemaVersion: SearchSchemaVersion,
+		Repo:          repoID,
+		Name:          entry.Meta.Name,
+		PackageID:     entry.Name,
+		Version:       entry.Meta.GetVersion(),
+		Release:       entry.Meta.GetRelease(),
+		Summary:       localisedText(entry.Meta.Summary),
+		Description:   localisedText(entry.Meta.Description),
+	}
+
+	seen := make(map[string]bool)
+	// Whole name is always a token so that "foo-devel" can be found as-is
+	seen[strings.ToLower(doc.Name)] = true
+	tokenize(seen, doc.Name)
+	tokenize(seen, doc.Summary)
+	tokenize(seen, doc.Description)
+	for t := range seen {
+		doc.Tokens = append(doc.Tokens, t)
+	}
+	sort.Strings(doc.Tokens)
+	return doc
+}
+
+// postingChanges collects the documents to be added to or removed from each
+// token's posting list, so that each posting is only rewritten once however
+// many documents change
+type postingChanges map[string]*postingChange
+
+type postingChange struct {
+	add    []string
+	remove []string
+}
+
+// record will note that the document key is to be added to, or removed
+// from, the posting list of each token
+func (c postingChanges) record(docKey string, tokens []string, add bool) {
+	for _, token := range tokens {
+		change, ok := c[token]
+		if !ok {
+			change = &postingChange{}
+			c[token] = change
+		}
+		if add {
+			change.add = append(change.add, docKey)
+		} else {
+			change.remove = append(change.remove, docKey)
+		}
+	}
+}
+
+// updatePostings will apply the changes to each token's posting list.
+// Removals are applied before additions, so a document which is both
+// removed and added for a token remains in its posting.
+func (s *SearchIndex) updatePostings(db libdb.Database, changes postingChanges) error {
+	bucket := db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchToken))
+
+	for token, change := range changes {
+		posting := &SearchPosting{}
+		if err := bucket.GetObject([]byte(token), posting); err != nil && err != libdb.ErrNotFound {
+			return err
+		}
+
+		removed := make(map[string]bool, len(change.remove))
+		for _, docKey := range change.remove {
+			removed[docKey] = true
+		}
+		docs := make([]string, 0, len(posting.Documents)+len(change.add))
+		for _, docKey := range posting.Documents {
+			if !removed[docKey] {
+				docs = append(docs, docKey)
+			}
+		}
+		docs = append(docs, change.add...)
+		sort.Strings(docs)
+		docs = uniqueStrings(docs)
+
+		if stringsEqual(docs, posting.Documents) {
+			continue
+		}
+		if len(docs) == 0 {
+			if err := bucket.DeleteObject([]byte(token)); err != nil {
+				return err
+			}
+			continue
+		}
+		posting.Documents = docs
+		if err := bucket.PutObject([]byte(token), posting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uniqueStrings will drop the duplicates from the sorted slice, in place
+func uniqueStrings(sorted []string) []string {
+	ret := sorted[:0]
+	for _, str := range sorted {
+		if len(ret) == 0 || str != ret[len(ret)-1] {
+			ret = append(ret, str)
+		}
+	}
+	return ret
+}
+
+// stringsEqual will determine if both slices hold the same strings
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// getDocuments will return every search document, optionally limited to
+// a single repository
+func (s *SearchIndex) getDocuments(db libdb.Database, repoID string) (map[string]*SearchDocument, error) {
+	ret := make(map[string]*SearchDocument)
+	bucket := db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchDocument))
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		doc := &SearchDocument{}
+		if err := bucket.Decode(v, doc); err != nil {
+			return err
+		}
+		if repoID == "" || doc.Repo == repoID {
+			ret[string(k)] = doc
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// removeDocument will drop the document, recording the removal of its
+// postings in changes
+func (s *SearchIndex) removeDocument(db libdb.Database, changes postingChanges, docKey string, doc *SearchDocument) error {
+	changes.record(docKey, doc.Tokens, false)
+	return db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchDocument)).DeleteObject([]byte(docKey))
+}
+
+// IndexRepo will bring the search documents for the repository in line
+// with its currently published packages. Unchanged packages are skipped,
+// so this is cheap to call after every repository operation.
+func (s *SearchIndex) IndexRepo(db libdb.Database, pool *Pool, repo *Repository) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	existing, err := s.getDocuments(db, repo.ID)
+	if err != nil {
+		return err
+	}
+
+	entries, err := repo.GetEntries(db)
+	if err != nil {
+		return err
+	}
+
+	docBucket := db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchDocument))
+	changes := postingChanges{}
+
+	for _, entry := range entries {
+		if entry.Published == "" {
+			continue
+		}
+		key := searchDocumentKey(repo.ID, entry.Name)
+		old, ok := existing[key]
+		delete(existing, key)
+		if ok && old.PackageID == entry.Published {
+			continue
+		}
+
+		poolEntry, err := pool.GetEntry(db, entry.Published)
+		if err != nil {
+			return err
+		}
+		doc := newSearchDocument(repo.ID, poolEntry)
+
+		if ok {
+			changes.record(key, old.Tokens, false)
+		}
+		changes.record(key, doc.Tokens, true)
+		if err := docBucket.PutObject([]byte(key), doc); err != nil {
+			return err
+		}
+	}
+
+	// Anything left over is no longer in the repository
+	for key, doc := range existing {
+		if err := s.removeDocument(db, changes, key, doc); err != nil {
+			return err
+		}
+	}
+	return s.updatePostings(db, changes)
+}
+
+// RemoveRepo will remove every search document for the repository
+func (s *SearchIndex) RemoveRepo(db libdb.Database, repoID string) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	existing, err := s.getDocuments(db, repoID)
+	if err != nil {
+		return err
+	}
+	changes := postingChanges{}
+	for key, doc := range existing {
+		if err := s.removeDocument(db, changes, key, doc); err != nil {
+			return err
+		}
+	}
+	return s.updatePostings(db, changes)
+}
+
+// IsBuilt will determine whether the index has ever been populated
+func (s *SearchIndex) IsBuilt(db libdb.Database) bool {
+	has, err := db.Bucket([]byte(DatabaseBucketSearch)).HasObject(searchBuiltKey)
+	return err == nil && has
+}
+
+// MarkBuilt will record that the index has been populated
+func (s *SearchIndex) MarkBuilt(db libdb.Database) error {
+	return db.Bucket([]byte(DatabaseBucketSearch)).PutObject(searchBuiltKey, &SearchPosting{})
+}
+
+// Search will return all documents matching the query, sorted by name and
+// then repository.
+//
+// For substring searches we only need to look at documents filed under a
+// token containing each word of the pattern. Regular expressions can match
+// across words, so they are checked against every document.
+func (s *SearchIndex) Search(db libdb.Database, query *SearchQuery) ([]*SearchDocument, error) {
+	var match func(string) bool
+
+	if query.Regex {
+		re, err := regexp.Compile("(?i)" + query.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	} else {
+		needle := strings.ToLower(query.Pattern)
+		match = func(text string) bool {
+			return strings.Contains(strings.ToLower(text), needle)
+		}
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	var candidates map[string]bool
+	if !query.Regex {
+		seen := make(map[string]bool)
+		tokenize(seen, query.Pattern)
+		for word := range seen {
+			docs, err := s.tokenCandidates(db, word)
+			if err != nil {
+				return nil, err
+			}
+			candidates = intersect(candidates, docs)
+		}
+	}
+
+	docBucket := db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchDocument))
+	var ret []*SearchDocument
+
+	check := func(doc *SearchDocument) {
+		if query.Repo != "" && doc.Repo != query.Repo {
+			return
+		}
+		if match(doc.Name) || match(doc.Summary) || match(doc.Description) {
+			ret = append(ret, doc)
+		}
+	}
+
+	if candidates != nil {
+		for key := range candidates {
+			doc := &SearchDocument{}
+			if err := docBucket.GetObject([]byte(key), doc); err != nil {
+				return nil, err
+			}
+			check(doc)
+		}
+	} else {
+		docs, err := s.getDocuments(db, query.Repo)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			check(doc)
+		}
+	}
+
+	sort.Slice(ret, func(a, b int) bool {
+		if ret[a].Name == ret[b].Name {
+			return ret[a].Repo < ret[b].Repo
+		}
+		return ret[a].Name < ret[b].Name
+	})
+	return ret, nil
+}
+
+// tokenCandidates will return every document filed under a token which
+// contains the word
+func (s *SearchIndex) tokenCandidates(db libdb.Database, word string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	bucket := db.Bucket([]byte(DatabaseBucketSearch)).Bucket([]byte(DatabaseBucketSearchToken))
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		if !strings.Contains(string(k), word) {
+			return nil
+		}
+		posting := &SearchPosting{}
+		if err := bucket.Decode(v, posting); err != nil {
+			return err
+		}
+		for _, doc := range posting.Documents {
+			ret[doc] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// intersect returns the documents present in both sets. A nil set means
+// no restriction has been applied yet.
+func intersect(a, b map[string]bool) map[string]bool {
+	if a == nil {
+		return b
+	}
+	ret := make(map[string]bool)
+	for k := range a {
+		if b[k] {
+			ret[k] = true
+		}
+	}
+	return ret
+}
@@ -0,0 +1,76 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"testing"
+)
+
+const (
+	searchTestPackage = "../../libeopkg/testdata/nano-2.7.1-63-1-x86_64.eopkg"
+)
+
+func TestSearch(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.CreateRepo("shannon"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	queries := []struct {
+		query SearchQuery
+		found int
+	}{
+		{SearchQuery{Pattern: "nano"}, 1},
+		{SearchQuery{Pattern: "TEXT EDITOR"}, 1},
+		{SearchQuery{Pattern: "edit"}, 1},
+		{SearchQuery{Pattern: "emacs"}, 0},
+		{SearchQuery{Pattern: "nano", Repo: "shannon"}, 0},
+		{SearchQuery{Pattern: "^na.o$", Regex: true}, 1},
+		{SearchQuery{Pattern: "inspired.*pico", Regex: true}, 1},
+	}
+	for _, q := range queries {
+		docs, err := manager.Search(&q.query)
+		if err != nil {
+			t.Fatalf("Failed to search for %v: %v", q.query, err)
+		}
+		if len(docs) != q.found {
+			t.Fatalf("Expected %d results for %v, got %d", q.found, q.query, len(docs))
+		}
+	}
+
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	docs, err := manager.Search(&SearchQuery{Pattern: "nano"})
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+	if len(docs) != 0 {
+		t.Fatalf("Deleted repository still has %d search results", len(docs))
+	}
+}
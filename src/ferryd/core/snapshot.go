@@ -0,0 +1,278 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DatabaseBucketSnapshot is the root bucket for all repository snapshots
+	DatabaseBucketSnapshot = "snapshot"
+
+	// SnapshotSchemaVersion is the current version of a Snapshot
+	SnapshotSchemaVersion = "1.1"
+
+	// DefaultUndoRetention is how long automatic snapshots are kept for
+	DefaultUndoRetention = 24 * time.Hour
+)
+
+// A Snapshot is an immutable record of every RepoEntry within a repository
+// at a point in time.
+//
+// Only the package IDs are stored, and the snapshot holds its own reference
+// on each pool entry, so the packages stay in the pool for as long as the
+// snapshot exists, even if they're removed from the repository itself.
+type Snapshot struct {
+	SchemaVersion string
+	Repo          string
+	Name          string
+	Created       time.Time
+	Entries       []*RepoEntry
+
+	// Automatic snapshots are taken before destructive jobs so they may be
+	// undone, and expire after the undo retention period
+	Automatic   bool
+	JobID       string
+	Description string // Description of the job
+}
+
+// PackageCount returns the number of packages (not deltas) in the snapshot
+func (s *Snapshot) PackageCount() int {
+	count := 0
+	for _, entry := range s.Entries {
+		count += len(entry.Available)
+	}
+	return count
+}
+
+// poolIDs returns every pool entry referenced by the snapshot
+func (s *Snapshot) poolIDs() []string {
+	var ret []string
+	for _, entry := range s.Entries {
+		ret = append(ret, entry.Available...)
+		ret = append(ret, entry.Deltas...)
+	}
+	return ret
+}
+
+// Expires returns when an automatic snapshot will be removed
+func (s *Snapshot) Expires(retention time.Duration) time.Time {
+	return s.Created.Add(retention)
+}
+
+// The SnapshotManager records and restores repository snapshots
+type SnapshotManager struct {
+	retention time.Duration // How long to keep automatic snapshots
+	mut       *sync.Mutex
+}
+
+// Init will set up the default undo retention
+func (s *SnapshotManager) Init(ctx *Context, db libdb.Database) error {
+	s.retention = DefaultUndoRetention
+	s.mut = &sync.Mutex{}
+	return nil
+}
+
+// SetUndoRetention will change how long automatic snapshots are kept for.
+// A retention of 0 disables automatic snapshots.
+func (s *SnapshotManager) SetUndoRetention(retention time.Duration) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.retention = retention
+}
+
+// UndoRetention returns how long automatic snapshots are kept for
+func (s *SnapshotManager) UndoRetention() time.Duration {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.retention
+}
+
+// Close doesn't currently do anything
+func (s *SnapshotManager) Close() {}
+
+// DefaultSnapshotName returns a name for a snapshot based on the current time
+func DefaultSnapshotName() string {
+	return time.Now().UTC().Format("20060102-150405")
+}
+
+// snapshotPrefix returns the prefix shared by every snapshot key of the
+// repository. The length of the ID is included as either it or the snapshot
+// name may contain a '/'.
+func snapshotPrefix(repoID string) []byte {
+	return []byte(fmt.Sprintf("%d:%s/", len(repoID), repoID))
+}
+
+// snapshotKey returns the key for a named snapshot of the repository
+func snapshotKey(repoID, name string) []byte {
+	return append(snapshotPrefix(repoID), name...)
+}
+
+// GetSnapshot will return the named snapshot of the repository
+func (s *SnapshotManager) GetSnapshot(db libdb.Database, repoID, name string) (*Snapshot, error) {
+	snap := &Snapshot{}
+	if err := db.Bucket([]byte(DatabaseBucketSnapshot)).GetObject(snapshotKey(repoID, name), snap); err != nil {
+		return nil, notFoundf("The snapshot '%s' does not exist for repository '%s'", name, repoID)
+	}
+	return snap, nil
+}
+
+// GetSnapshots will return all snapshots of the repository, oldest first.
+// If repoID is empty, the snapshots of every repository are returned.
+func (s *SnapshotManager) GetSnapshots(db libdb.Database, repoID string) ([]*Snapshot, error) {
+	var ret []*Snapshot
+	bucket := db.Bucket([]byte(DatabaseBucketSnapshot))
+	collect := func(k, v []byte) error {
+		snap := &Snapshot{}
+		if err := bucket.Decode(v, snap); err != nil {
+			return err
+		}
+		ret = append(ret, snap)
+		return nil
+	}
+	var err error
+	if repoID == "" {
+		err = bucket.ForEach(collect)
+	} else {
+		err = bucket.ForEachPrefix(snapshotPrefix(repoID), collect)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(a, b int) bool {
+		return ret[a].Created.Before(ret[b].Created)
+	})
+	return ret, nil
+}
+
+// CreateSnapshot will record the current state of the repository under the
+// given name, taking a pool reference for every package and delta within it.
+func (s *SnapshotManager) CreateSnapshot(db libdb.Database, pool *Pool, repo *Repository, name string) (*Snapshot, error) {
+	snap := &Snapshot{
+		SchemaVersion: SnapshotSchemaVersion,
+		Repo:          repo.ID,
+		Name:          name,
+	}
+	if err := s.putSnapshot(db, pool, repo, snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// CreateUndoSnapshot will automatically snapshot the repository before the
+// job modifies it, and then remove any automatic snapshots which are past
+// the retention period.
+func (s *SnapshotManager) CreateUndoSnapshot(logger *log.Entry, db libdb.Database, pool *Pool, repo *Repository, jobID, description string) error {
+	if s.UndoRetention() <= 0 {
+		return nil
+	}
+	snap := &Snapshot{
+		SchemaVersion: SnapshotSchemaVersion,
+		Repo:          repo.ID,
+		Name:          "undo-" + jobID,
+		Automatic:     true,
+		JobID:         jobID,
+		Description:   description,
+	}
+	if err := s.putSnapshot(db, pool, repo, snap); err != nil {
+		return err
+	}
+	return s.PruneUndoSnapshots(logger, db, pool)
+}
+
+// putSnapshot will fill the snapshot with the repository entries and store it
+func (s *SnapshotManager) putSnapshot(db libdb.Database, pool *Pool, repo *Repository, snap *Snapshot) error {
+	bucket := db.Bucket([]byte(DatabaseBucketSnapshot))
+	key := snapshotKey(repo.ID, snap.Name)
+
+	if has, err := bucket.HasObject(key); err != nil {
+		return err
+	} else if has {
+		return fmt.Errorf("The snapshot '%s' already exists for repository '%s'", snap.Name, repo.ID)
+	}
+
+	entries, err := repo.GetEntries(db)
+	if err != nil {
+		return err
+	}
+	snap.Created = time.Now().UTC()
+	snap.Entries = entries
+
+	for _, id := range snap.poolIDs() {
+		if err := pool.RefEntry(db, id); err != nil {
+			return err
+		}
+	}
+
+	return bucket.PutObject(key, snap)
+}
+
+// PruneUndoSnapshots will remove every automatic snapshot which is older
+// than the undo retention period
+func (s *SnapshotManager) PruneUndoSnapshots(logger *log.Entry, db libdb.Database, pool *Pool) error {
+	snaps, err := s.GetSnapshots(db, "")
+	if err != nil {
+		return err
+	}
+	retention := s.UndoRetention()
+	now := time.Now().UTC()
+	for _, snap := range snaps {
+		if !snap.Automatic || now.Before(snap.Expires(retention)) {
+			continue
+		}
+		if err := s.DeleteSnapshot(logger, db, pool, snap.Repo, snap.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUndoSnapshot will return the automatic snapshot taken before the job
+func (s *SnapshotManager) GetUndoSnapshot(db libdb.Database, jobID string) (*Snapshot, error) {
+	snaps, err := s.GetSnapshots(db, "")
+	if err != nil {
+		return nil, err
+	}
+	retention := s.UndoRetention()
+	now := time.Now().UTC()
+	for _, snap := range snaps {
+		if snap.Automatic && snap.JobID == jobID && now.Before(snap.Expires(retention)) {
+			return snap, nil
+		}
+	}
+	return nil, notFoundf("No undo is available for job '%s'", jobID)
+}
+
+// DeleteSnapshot will remove the snapshot and release its pool references
+func (s *SnapshotManager) DeleteSnapshot(logger *log.Entry, db libdb.Database, pool *Pool, repoID, name string) error {
+	snap, err := s.GetSnapshot(db, repoID, name)
+	if err != nil {
+		return err
+	}
+	for _, id := range snap.poolIDs() {
+		if err := pool.UnrefEntry(logger, db, id); err != nil {
+			return err
+		}
+	}
+	return db.Bucket([]byte(DatabaseBucketSnapshot)).DeleteObject(snapshotKey(repoID, name))
+}
@@ -0,0 +1,224 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// countPackages returns the number of package names in the repository
+func countPackages(t *testing.T, manager *Manager, repoID string) int {
+	names, err := manager.GetPackageNames(repoID)
+	if err != nil {
+		t.Fatalf("Failed to list packages in %s: %v", repoID, err)
+	}
+	return len(names)
+}
+
+func TestSnapshot(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	snap, err := manager.CreateSnapshot("unstable", "before")
+	if err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+	if snap.PackageCount() != 1 {
+		t.Fatalf("Expected 1 package in snapshot, got %d", snap.PackageCount())
+	}
+	if _, err := manager.CreateSnapshot("unstable", "before"); err == nil {
+		t.Fatalf("Should not be able to create a duplicate snapshot")
+	}
+
+	if err := manager.RemoveSource("unstable", "nano", -1); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if n := countPackages(t, manager, "unstable"); n != 0 {
+		t.Fatalf("Expected empty repository after removal, got %d", n)
+	}
+
+	// The snapshot must keep the package in the pool
+	pool, err := manager.GetPoolItems()
+	if err != nil {
+		t.Fatalf("Failed to get pool items: %v", err)
+	}
+	if len(pool) != 1 {
+		t.Fatalf("Expected snapshot to retain 1 pool item, got %d", len(pool))
+	}
+
+	if err := manager.RestoreSnapshot("unstable", "before"); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+	if n := countPackages(t, manager, "unstable"); n != 1 {
+		t.Fatalf("Expected 1 package after restore, got %d", n)
+	}
+
+	// Deleted repositories can be brought back too
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if err := manager.RestoreSnapshot("unstable", "before"); err != nil {
+		t.Fatalf("Failed to restore deleted repo: %v", err)
+	}
+	if n := countPackages(t, manager, "unstable"); n != 1 {
+		t.Fatalf("Expected 1 package in restored repo, got %d", n)
+	}
+
+	if err := manager.DeleteSnapshot("unstable", "before"); err != nil {
+		t.Fatalf("Failed to delete snapshot: %v", err)
+	}
+	snaps, err := manager.GetSnapshots("unstable")
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	if len(snaps) != 0 {
+		t.Fatalf("Expected no snapshots, got %d", len(snaps))
+	}
+
+	// Now only the repository holds a reference
+	if err := manager.RemoveSource("unstable", "nano", -1); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+	if pool, err = manager.GetPoolItems(); err != nil {
+		t.Fatalf("Failed to get pool items: %v", err)
+	}
+	if len(pool) != 0 {
+		t.Fatalf("Expected empty pool, got %d", len(pool))
+	}
+}
+
+func TestUndoSnapshot(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.AddPackages("unstable", []string{searchTestPackage}, false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+
+	if err := manager.CreateUndoSnapshot("unstable", "job1", "Remove nano"); err != nil {
+		t.Fatalf("Failed to create undo snapshot: %v", err)
+	}
+	if err := manager.RemoveSource("unstable", "nano", -1); err != nil {
+		t.Fatalf("Failed to remove source: %v", err)
+	}
+
+	snap, err := manager.GetUndoSnapshot("job1")
+	if err != nil {
+		t.Fatalf("Failed to find undo snapshot: %v", err)
+	}
+	if err := manager.RestoreSnapshot(snap.Repo, snap.Name); err != nil {
+		t.Fatalf("Failed to undo job: %v", err)
+	}
+	if n := countPackages(t, manager, "unstable"); n != 1 {
+		t.Fatalf("Expected 1 package after undo, got %d", n)
+	}
+
+	// Nothing is recorded for missing repositories
+	if err := manager.CreateUndoSnapshot("shannon", "job2", "Delete shannon"); err != nil {
+		t.Fatalf("Undo snapshot of missing repo should be skipped: %v", err)
+	}
+	if _, err := manager.GetUndoSnapshot("job2"); err == nil {
+		t.Fatalf("Should not have an undo snapshot for a missing repo")
+	}
+
+	// Expired snapshots are pruned
+	manager.SetUndoRetention(time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	snaps, err := manager.GetUndoSnapshots()
+	if err != nil {
+		t.Fatalf("Failed to list undo snapshots: %v", err)
+	}
+	if len(snaps) != 0 {
+		t.Fatalf("Expected expired undo snapshots to be pruned, got %d", len(snaps))
+	}
+	if _, err := manager.GetUndoSnapshot("job1"); err == nil {
+		t.Fatalf("Should not be able to undo an expired job")
+	}
+}
+
+func TestSnapshotKeys(t *testing.T) {
+	path := initTestArea(t)
+	manager, err := NewManager(path)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("a"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if _, err := manager.CreateSnapshot("a", "b/c"); err != nil {
+		t.Fatalf("Failed to create snapshot: %v", err)
+	}
+
+	// Would have shared the key "a/b/c" with the snapshot above
+	bucket := manager.db.Bucket([]byte(DatabaseBucketSnapshot))
+	other := &Snapshot{SchemaVersion: SnapshotSchemaVersion, Repo: "a/b", Name: "c"}
+	if err := bucket.PutObject(snapshotKey(other.Repo, other.Name), other); err != nil {
+		t.Fatalf("Failed to store snapshot: %v", err)
+	}
+	if snap, err := manager.GetSnapshot("a", "b/c"); err != nil || snap.Repo != "a" {
+		t.Fatalf("Snapshot was overwritten by another repository's: %v", err)
+	}
+	if snaps, err := manager.GetSnapshots("a"); err != nil || len(snaps) != 1 {
+		t.Fatalf("Expected 1 snapshot of a, got %d: %v", len(snaps), err)
+	}
+
+	// Snapshots written by an older ferryd are moved to their new key
+	legacy := &Snapshot{SchemaVersion: "1.0", Repo: "a", Name: "old"}
+	if err := bucket.PutObject([]byte("a/old"), legacy); err != nil {
+		t.Fatalf("Failed to store snapshot: %v", err)
+	}
+	if err := manager.db.Bucket([]byte(DatabaseBucketSchema)).PutObject([]byte(DatabaseBucketSnapshot), "1.0"); err != nil {
+		t.Fatalf("Failed to reset schema: %v", err)
+	}
+	manager.Close()
+
+	if manager, err = NewManager(path); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	defer manager.Close()
+	snap, err := manager.GetSnapshot("a", "old")
+	if err != nil {
+		t.Fatalf("Old snapshot wasn't migrated: %v", err)
+	}
+	if snap.SchemaVersion != SnapshotSchemaVersion {
+		t.Fatalf("Old snapshot is still at %s", snap.SchemaVersion)
+	}
+	bucket = manager.db.Bucket([]byte(DatabaseBucketSnapshot))
+	if has, _ := bucket.HasObject([]byte("a/old")); has {
+		t.Fatalf("Old snapshot key wasn't removed")
+	}
+	if snaps, err := manager.GetSnapshots("a"); err != nil || len(snaps) != 2 {
+		t.Fatalf("Expected 2 snapshots of a, got %d: %v", len(snaps), err)
+	}
+}
@@ -0,0 +1,204 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"libdb"
+	"os"
+	"sync"
+)
+
+// A DiskSpaceError is returned when an operation is refused because it would
+// leave less than the minimum free space on the base directory's filesystem
+type DiskSpaceError struct {
+	Path    string // Directory on the filesystem that is short of space
+	Needed  uint64 // Bytes the operation expects to write
+	Free    uint64 // Bytes available right now
+	MinFree uint64 // Bytes which must remain free
+}
+
+// Error will explain how short of space we are
+func (e *DiskSpaceError) Error() string {
+	return fmt.Sprintf("insufficient disk space for %s: %d bytes needed, %d free with %d reserved", e.Path, e.Needed, e.Free, e.MinFree)
+}
+
+// A QuotaError is returned when an operation is refused because it would take
+// a repository over its size quota
+type QuotaError struct {
+	Repo   string
+	Quota  int64 // Most bytes the repository may use
+	Used   int64 // Bytes the repository uses right now
+	Needed int64 // Bytes the operation expects to add
+}
+
+// Error will explain how far over the quota we'd be
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("repository '%s' would exceed its quota: %d bytes needed, %d of %d used", e.Repo, e.Needed, e.Used, e.Quota)
+}
+
+// SpaceRefusedFunc is called whenever an operation is refused with either a
+// DiskSpaceError or a QuotaError
+type SpaceRefusedFunc func(err error)
+
+// A SpaceGuard refuses imports and deltas before they can fill the
+// filesystem, which would otherwise risk corrupting the database.
+type SpaceGuard struct {
+	path     string // Base directory, whose filesystem we check
+	minFree  uint64
+	refused  SpaceRefusedFunc
+	refusals uint64 // Operations refused since startup
+	mut      *sync.Mutex
+}
+
+// newSpaceGuard will return a guard for the filesystem containing path,
+// which won't refuse anything until a minimum is set
+func newSpaceGuard(path string) *SpaceGuard {
+	return &SpaceGuard{
+		path: path,
+		mut:  &sync.Mutex{},
+	}
+}
+
+// SetMinFree will change how many bytes must always remain free
+func (s *SpaceGuard) SetMinFree(minFree uint64) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.minFree = minFree
+}
+
+// MinFree returns how many bytes must always remain free
+func (s *SpaceGuard) MinFree() uint64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.minFree
+}
+
+// SetRefusedFunc will set the function called whenever something is refused
+func (s *SpaceGuard) SetRefusedFunc(f SpaceRefusedFunc) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.refused = f
+}
+
+// Refusals returns how many operations have been refused since startup
+func (s *SpaceGuard) Refusals() uint64 {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.refusals
+}
+
+// refuse will count the error and report it to the refused function, if any
+func (s *SpaceGuard) refuse(err error) error {
+	s.mut.Lock()
+	s.refusals++
+	f := s.refused
+	s.mut.Unlock()
+	if f != nil {
+		f(err)
+	}
+	return err
+}
+
+// CheckDisk will return a DiskSpaceError if writing needed bytes would leave
+// less than the minimum free for the base directory
+func (s *SpaceGuard) CheckDisk(needed uint64) error {
+	return s.CheckDiskAt(s.path, needed)
+}
+
+// CheckDiskAt will return a DiskSpaceError if writing needed bytes would leave
+// less than the minimum free on the filesystem containing path
+func (s *SpaceGuard) CheckDiskAt(path string, needed uint64) error {
+	minFree := s.MinFree()
+	if minFree == 0 {
+		return nil
+	}
+	free, _, err := DiskUsage(path)
+	if err != nil {
+		return err
+	}
+	if free < minFree || free-minFree < needed {
+		return s.refuse(&DiskSpaceError{
+			Path:    path,
+			Needed:  needed,
+			Free:    free,
+			MinFree: minFree,
+		})
+	}
+	return nil
+}
+
+// CheckQuota will return a QuotaError if adding needed bytes would take the
+// repository over its quota
+func (s *SpaceGuard) CheckQuota(db libdb.Database, pool *Pool, repo *Repository, needed int64) error {
+	if repo.Quota == 0 {
+		return nil
+	}
+	used, err := repo.Size(db, pool)
+	if err != nil {
+		return err
+	}
+	if used+needed > repo.Quota {
+		return s.refuse(&QuotaError{
+			Repo:   repo.ID,
+			Quota:  repo.Quota,
+			Used:   used,
+			Needed: needed,
+		})
+	}
+	return nil
+}
+
+// Size will return the total size of the packages and deltas in the
+// repository. Files shared with other repositories are counted in full.
+func (r *Repository) Size(db libdb.Database, pool *Pool) (int64, error) {
+	entries, err := r.GetEntries(db)
+	if err != nil {
+		return 0, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.Available...)
+		ids = append(ids, entry.Deltas...)
+	}
+	return poolEntriesSize(db, pool, ids)
+}
+
+// poolEntriesSize will return the total size of the pool entries
+func poolEntriesSize(db libdb.Database, pool *Pool, ids []string) (int64, error) {
+	poolEntries, err := pool.GetEntries(db, ids)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range poolEntries {
+		size += entry.Meta.PackageSize
+	}
+	return size, nil
+}
+
+// filesSize will return the combined size of the files. Any we can't stat are
+// skipped, leaving the import itself to report them once it reaches them.
+func filesSize(paths []string) int64 {
+	var size int64
+	for _, path := range paths {
+		if st, err := os.Stat(path); err == nil {
+			size += st.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,101 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSpaceQuota(t *testing.T) {
+	manager, err := NewManager(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	var refused []error
+	manager.SetSpaceRefusedFunc(func(err error) {
+		refused = append(refused, err)
+	})
+
+	pkgs := []string{
+		"../../libeopkg/testdata/delta/nano-2.8.5-75-1-x86_64.eopkg",
+		"../../libeopkg/testdata/delta/nano-2.8.6-76-1-x86_64.eopkg",
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	if err := manager.SetQuota("unstable", -1); err == nil {
+		t.Fatalf("Negative quota should be refused")
+	}
+
+	// Room for exactly one package
+	size := filesSize(pkgs[:1])
+	if err := manager.SetQuota("unstable", size); err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+	if err := manager.BulkAddPackages("unstable", pkgs, nil, nil); err == nil {
+		t.Fatalf("Import over the quota should be refused")
+	}
+	if err := manager.AddPackages("unstable", pkgs[:1], false, nil); err != nil {
+		t.Fatalf("Failed to add package within quota: %v", err)
+	}
+	used, err := manager.RepoSize("unstable")
+	if err != nil {
+		t.Fatalf("Failed to get repo size: %v", err)
+	}
+	if used != size {
+		t.Fatalf("Expected repo size %d, got %d", size, used)
+	}
+	err = manager.AddPackages("unstable", pkgs[1:], false, nil)
+	if qerr, ok := err.(*QuotaError); !ok || qerr.Used != size || qerr.Repo != "unstable" {
+		t.Fatalf("Expected a QuotaError, got %v", err)
+	}
+	if len(refused) != 2 {
+		t.Fatalf("Expected 2 refusals to be reported, got %v", refused)
+	}
+
+	// Lifting the quota but demanding impossible free space
+	if err := manager.SetQuota("unstable", 0); err != nil {
+		t.Fatalf("Failed to clear quota: %v", err)
+	}
+	_, total, err := DiskUsage(manager.ctx.BaseDir)
+	if err != nil {
+		t.Fatalf("Failed to get disk usage: %v", err)
+	}
+	manager.SetMinFreeSpace(total + 1)
+	err = manager.AddPackages("unstable", pkgs[1:], false, nil)
+	if _, ok := err.(*DiskSpaceError); !ok {
+		t.Fatalf("Expected a DiskSpaceError, got %v", err)
+	}
+	meta, err := manager.GetPoolEntry(filepath.Base(pkgs[0]))
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	if _, err := manager.CreateDelta("unstable", meta, meta, nil, nil); err == nil {
+		t.Fatalf("Delta should be refused without free space")
+	}
+	if len(refused) != 4 || manager.SpaceRefusals() != 4 {
+		t.Fatalf("Expected 4 refusals to be reported, got %v", refused)
+	}
+
+	manager.SetMinFreeSpace(0)
+	if err := manager.AddPackages("unstable", pkgs[1:], false, nil); err != nil {
+		t.Fatalf("Failed to add package: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// StorageRoots allow the pool and the trees of individual repositories to
+// live outside of the base directory, i.e. to spread huge repositories across
+// several mounts. Each root is laid out like the base directory, so the pool
+// is kept in "pool" and each repository in "repo/<id>" beneath it.
+//
+// The database, deltas and incoming directories always stay in the base
+// directory, where each repository tree stored elsewhere is symlinked.
+type StorageRoots struct {
+	Pool  string            // Root for the pool, empty for the base directory
+	Repos map[string]string // Repository ID to the root for its tree
+}
+
+// SetStorageRoots will place the pool and repository trees in the given
+// roots, which must already exist
+func (c *Context) SetStorageRoots(roots *StorageRoots) error {
+	if roots.Pool != "" {
+		root, err := storageRoot(roots.Pool)
+		if err != nil {
+			return err
+		}
+		c.PoolDir = filepath.Join(root, PoolPathComponent)
+	}
+	c.RepoRoots = make(map[string]string)
+	for id, path := range roots.Repos {
+		root, err := storageRoot(path)
+		if err != nil {
+			return err
+		}
+		if root != c.BaseDir {
+			c.RepoRoots[id] = root
+		}
+	}
+	return nil
+}
+
+// storageRoot will return the absolute path of the root, as long as it exists.
+// Roots are never created, as they're expected to be mount points and we
+// mustn't quietly fill the filesystem beneath one that isn't mounted.
+func storageRoot(path string) (string, error) {
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	st, err := os.Stat(root)
+	if err != nil {
+		return "", fmt.Errorf("storage root %s is unavailable: %v", root, err)
+	}
+	if !st.IsDir() {
+		return "", fmt.Errorf("storage root %s is not a directory", root)
+	}
+	return root, nil
+}
+
+// deviceID returns the ID of the device containing path
+func deviceID(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Dev), nil
+}
+
+// sameDevice determines if both paths are on the same filesystem, and so
+// could be hard linked. If we can't tell, we assume they are.
+func sameDevice(a, b string) bool {
+	devA, err := deviceID(a)
+	if err != nil {
+		return true
+	}
+	devB, err := deviceID(b)
+	if err != nil {
+		return true
+	}
+	return devA == devB
+}
+
+// linkRepoTree will ensure the symlink in the base directory points to the
+// repository tree stored in its own root
+func linkRepoTree(link, tree string) error {
+	st, err := os.Lstat(link)
+	if os.IsNotExist(err) {
+		return os.Symlink(tree, link)
+	}
+	if err != nil {
+		return err
+	}
+	if st.Mode()&os.ModeSymlink == 0 {
+		return fmt.Errorf("%s must be moved to %s before its storage root can be used", link, tree)
+	}
+	current, err := os.Readlink(link)
+	if err != nil {
+		return err
+	}
+	if current == tree {
+		return nil
+	}
+	log.WithFields(log.Fields{
+		"link": link,
+		"from": current,
+		"to":   tree,
+	}).Warning("Repository storage root changed")
+	if err = os.Remove(link); err != nil {
+		return err
+	}
+	return os.Symlink(tree, link)
+}
+
+// warnCrossDevice will warn about each repository tree that can't be hard
+// linked to the pool, as every package will have to be copied into it
+func (r *RepositoryManager) warnCrossDevice(poolDir string) {
+	for id, root := range r.roots {
+		if !sameDevice(root, poolDir) {
+			log.WithFields(log.Fields{
+				"repo": id,
+				"root": root,
+				"pool": poolDir,
+			}).Warning("Repository storage root is on another filesystem to the pool, packages will be copied")
+		}
+	}
+	if !sameDevice(r.repoBase, poolDir) {
+		log.WithFields(log.Fields{
+			"path": r.repoBase,
+			"pool": poolDir,
+		}).Warning("Repository directory is on another filesystem to the pool, packages will be copied")
+	}
+}
@@ -0,0 +1,123 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageRoots(t *testing.T) {
+	dir, err := filepath.Abs(initTestArea(t))
+	if err != nil {
+		t.Fatalf("Failed to resolve test area: %v", err)
+	}
+	poolRoot := filepath.Join(dir, "poolroot")
+	repoRoot := filepath.Join(dir, "reporoot")
+	base := filepath.Join(dir, "base")
+	for _, p := range []string{poolRoot, repoRoot, base} {
+		if err := os.MkdirAll(p, 00755); err != nil {
+			t.Fatalf("Failed to create %s: %v", p, err)
+		}
+	}
+
+	// Roots are never created for us
+	roots := &StorageRoots{Pool: filepath.Join(dir, "missing")}
+	if _, err := NewManagerWithStorage(base, "", roots); err == nil {
+		t.Fatalf("A missing storage root should be refused")
+	}
+
+	roots = &StorageRoots{
+		Pool:  poolRoot,
+		Repos: map[string]string{"unstable": repoRoot},
+	}
+	manager, err := NewManagerWithStorage(base, "", roots)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+
+	for _, repoID := range []string{"unstable", "shannon"} {
+		if err := manager.CreateRepo(repoID); err != nil {
+			t.Fatalf("Failed to create repo: %v", err)
+		}
+		if err := manager.AddPackages(repoID, []string{searchTestPackage}, false, nil); err != nil {
+			t.Fatalf("Failed to add package: %v", err)
+		}
+	}
+
+	pkgID := filepath.Base(searchTestPackage)
+	entry, err := manager.pool.GetEntry(manager.db, pkgID)
+	if err != nil {
+		t.Fatalf("Failed to get pool entry: %v", err)
+	}
+	relPath := filepath.Join(entry.Meta.GetPathComponent(), pkgID)
+	if !PathExists(filepath.Join(poolRoot, PoolPathComponent, relPath)) {
+		t.Fatalf("Pool file should be stored in the pool root")
+	}
+
+	// The tree lives in its root, and is still reachable from the base
+	tree := filepath.Join(repoRoot, RepoPathComponent, "unstable")
+	link := filepath.Join(base, RepoPathComponent, "unstable")
+	if !PathExists(filepath.Join(tree, relPath)) || !PathExists(filepath.Join(link, relPath)) {
+		t.Fatalf("Package should be in the repository root and reachable from the base")
+	}
+	if target, err := os.Readlink(link); err != nil || target != tree {
+		t.Fatalf("Expected %s to link to %s, got %s: %v", link, tree, target, err)
+	}
+	if !PathExists(filepath.Join(base, RepoPathComponent, "shannon", relPath)) {
+		t.Fatalf("Repositories without a root should stay in the base")
+	}
+
+	if err := manager.DeleteRepo("unstable"); err != nil {
+		t.Fatalf("Failed to delete repo: %v", err)
+	}
+	if PathExists(tree) {
+		t.Fatalf("Repository tree should have been removed from its root")
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Fatalf("Repository link should have been removed: %v", err)
+	}
+}
+
+func TestStorageRootConflict(t *testing.T) {
+	dir := initTestArea(t)
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	if err := manager.CreateRepo("unstable"); err != nil {
+		t.Fatalf("Failed to create repo: %v", err)
+	}
+	manager.Close()
+
+	// The existing tree must be moved by hand before it can be used
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(root, 00755); err != nil {
+		t.Fatalf("Failed to create root: %v", err)
+	}
+	roots := &StorageRoots{Repos: map[string]string{"unstable": root}}
+	manager, err = NewManagerWithStorage(dir, "", roots)
+	if err != nil {
+		t.Fatalf("Failed to initialise manager: %v", err)
+	}
+	defer manager.Close()
+	if _, err := manager.GetRepo("unstable"); err == nil {
+		t.Fatalf("A repository with its tree in the base shouldn't use a new root")
+	}
+}
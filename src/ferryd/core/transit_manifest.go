@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 )
@@ -28,6 +29,14 @@ import (
 const (
 	// TransitManifestSuffix is the extension that a valid transit manifest must have
 	TransitManifestSuffix = ".tram"
+
+	// TransitManifestVersion1 is the original format, listing only the path
+	// and sha256 of each file
+	TransitManifestVersion1 = "1.0"
+
+	// TransitManifestVersion2 adds the size and component of each file, along
+	// with the identity of the builder
+	TransitManifestVersion2 = "2.0"
 )
 
 var (
@@ -45,6 +54,9 @@ var (
 
 	// ErrIllegalUpload is returned when someone is a spanner and tries uploading an unsupported file
 	ErrIllegalUpload = errors.New("The manifest file is NOT an eopkg")
+
+	// ErrMissingBuilder will be returned when a v2 manifest has no [builder]
+	ErrMissingBuilder = errors.New("Manifest does not identify the builder")
 )
 
 // A TransitManifestHeader is required in all .tram uploads to ensure that both
@@ -57,6 +69,16 @@ type TransitManifestHeader struct {
 	Target string `toml:"target"`
 }
 
+// A TransitManifestBuilder identifies the build server responsible for the
+// upload, and is required from version 2 onwards
+type TransitManifestBuilder struct {
+	// Name of the builder, i.e. its hostname
+	Name string `toml:"name"`
+
+	// Optional identifier of the build on that builder
+	Build string `toml:"build"`
+}
+
 // A TransitManifest is provided by build servers to validate the upload of
 // packages into the incoming directory.
 //
@@ -67,6 +89,9 @@ type TransitManifest struct {
 	// version agnostic.
 	Manifest TransitManifestHeader `toml:"manifest"`
 
+	// Who built the payload, from version 2 onwards
+	Builder TransitManifestBuilder `toml:"builder"`
+
 	// A list of files that accompanied this .tram upload
 	File []TransitManifestFile `toml:"file"`
 
@@ -99,6 +124,14 @@ type TransitManifestFile struct {
 
 	// Cryptographic checksum to allow integrity checks post-upload/pre-merge
 	Sha256 string `toml:"sha256"`
+
+	// Size in bytes, allowing truncated uploads to be rejected without
+	// hashing them. Required from version 2 onwards.
+	Size int64 `toml:"size"`
+
+	// Component the builder expects the package to be part of. This is only
+	// a hint, and may be empty.
+	Component string `toml:"component"`
 }
 
 // NewTransitManifest will attempt to load the transit manifest from the
@@ -127,7 +160,15 @@ func NewTransitManifest(path string) (*TransitManifest, error) {
 	ret.Manifest.Target = strings.TrimSpace(ret.Manifest.Target)
 	ret.Manifest.Version = strings.TrimSpace(ret.Manifest.Version)
 
-	if ret.Manifest.Version != "1.0" {
+	switch ret.Manifest.Version {
+	case TransitManifestVersion1:
+	case TransitManifestVersion2:
+		ret.Builder.Name = strings.TrimSpace(ret.Builder.Name)
+		ret.Builder.Build = strings.TrimSpace(ret.Builder.Build)
+		if len(ret.Builder.Name) < 1 {
+			return nil, ErrMissingBuilder
+		}
+	default:
 		return nil, ErrInvalidHeader
 	}
 
@@ -143,10 +184,14 @@ func NewTransitManifest(path string) (*TransitManifest, error) {
 		f := &ret.File[i]
 		f.Path = strings.TrimSpace(f.Path)
 		f.Sha256 = strings.TrimSpace(f.Sha256)
+		f.Component = strings.TrimSpace(f.Component)
 
 		if len(f.Path) < 1 || len(f.Sha256) < 1 {
 			return nil, ErrInvalidPayload
 		}
+		if ret.Manifest.Version != TransitManifestVersion1 && f.Size < 1 {
+			return nil, ErrInvalidPayload
+		}
 
 		if !strings.HasSuffix(f.Path, ".eopkg") {
 			return nil, ErrIllegalUpload
@@ -158,8 +203,22 @@ func NewTransitManifest(path string) (*TransitManifest, error) {
 
 // ValidatePayload will verify the files listed in the manifest locally, ensuring
 // that they actually exist, and that the hashes match to prevent any corrupted
-// uploads being inadvertently imported
+// uploads being inadvertently imported. When the manifest lists sizes, every
+// file is checked for truncation before anything is hashed.
 func (t *TransitManifest) ValidatePayload() error {
+	for i := range t.File {
+		f := &t.File[i]
+		if f.Size < 1 {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(t.dir, f.Path))
+		if err != nil {
+			return err
+		}
+		if st.Size() != f.Size {
+			return fmt.Errorf("Invalid size for '%s'. Local: %d, expected: %d", f.Path, st.Size(), f.Size)
+		}
+	}
 	for i := range t.File {
 		f := &t.File[i]
 		path := filepath.Join(t.dir, f.Path)
@@ -17,11 +17,17 @@
 package core
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 const (
-	transitTestFile = "testdata/nano.tram"
+	transitTestFile   = "testdata/nano.tram"
+	transitTestFileV2 = "testdata/nano-v2.tram"
 )
 
 func TestTransitManifest(t *testing.T) {
@@ -45,3 +51,103 @@ func TestTransitManifest(t *testing.T) {
 		t.Fatalf("Invalid sha in tram file: %s", tm.File[0].Sha256)
 	}
 }
+
+func TestTransitManifestV2(t *testing.T) {
+	tm, err := NewTransitManifest(transitTestFileV2)
+	if err != nil {
+		t.Fatalf("Failed to load valid v2 tram file: %v", err)
+	}
+	if tm.Manifest.Version != TransitManifestVersion2 {
+		t.Fatalf("Invalid header version: %s", tm.Manifest.Version)
+	}
+	if tm.Builder.Name != "build-1.solus-project.com" || tm.Builder.Build != "nano-2.7.5-68" {
+		t.Fatalf("Invalid builder in tram file: %+v", tm.Builder)
+	}
+	if len(tm.File) != 2 {
+		t.Fatalf("Invalid number of files in payload: %d", len(tm.File))
+	}
+	if tm.File[0].Size != 565864 || tm.File[1].Component != "debug" {
+		t.Fatalf("Invalid file metadata in tram file: %+v", tm.File)
+	}
+
+	// Older manifests have no use for the builder
+	tm, err = NewTransitManifest(transitTestFile)
+	if err != nil {
+		t.Fatalf("Failed to load valid tram file: %v", err)
+	}
+	if tm.Builder.Name != "" || tm.File[0].Size != 0 {
+		t.Fatalf("v1 tram file shouldn't carry v2 metadata: %+v", tm)
+	}
+}
+
+func TestTransitManifestV2Invalid(t *testing.T) {
+	dir := initTestArea(t)
+	valid, err := ioutil.ReadFile(transitTestFileV2)
+	if err != nil {
+		t.Fatalf("Failed to read tram file: %v", err)
+	}
+
+	broken := map[string]struct {
+		blob     string
+		expected error
+	}{
+		"nobuilder.tram": {
+			strings.Replace(string(valid), `name = "build-1.solus-project.com"`, "", 1),
+			ErrMissingBuilder,
+		},
+		"nosize.tram": {
+			strings.Replace(string(valid), "size = 148944", "", 1),
+			ErrInvalidPayload,
+		},
+		"version.tram": {
+			strings.Replace(string(valid), `version = "2.0"`, `version = "3.0"`, 1),
+			ErrInvalidHeader,
+		},
+	}
+	for name, b := range broken {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(b.blob), 00644); err != nil {
+			t.Fatalf("Failed to write tram file: %v", err)
+		}
+		if _, err := NewTransitManifest(path); err != b.expected {
+			t.Fatalf("Expected %v loading %s, got %v", b.expected, name, err)
+		}
+	}
+}
+
+func TestTransitManifestValidateSize(t *testing.T) {
+	dir := initTestArea(t)
+	pkgPath := filepath.Join(dir, filepath.Base(searchTestPackage))
+	if err := CopyFile(searchTestPackage, pkgPath); err != nil {
+		t.Fatalf("Failed to copy package: %v", err)
+	}
+	sha, err := FileSha256sum(pkgPath)
+	if err != nil {
+		t.Fatalf("Failed to hash package: %v", err)
+	}
+	st, err := os.Stat(pkgPath)
+	if err != nil {
+		t.Fatalf("Failed to stat package: %v", err)
+	}
+
+	for _, size := range []int64{st.Size(), st.Size() + 1} {
+		path := filepath.Join(dir, "nano.tram")
+		blob := fmt.Sprintf("[manifest]\nversion = \"2.0\"\ntarget = \"unstable\"\n\n"+
+			"[builder]\nname = \"test\"\n\n"+
+			"[[file]]\npath = \"%s\"\nsha256 = \"%s\"\nsize = %d\n", filepath.Base(pkgPath), sha, size)
+		if err := ioutil.WriteFile(path, []byte(blob), 00644); err != nil {
+			t.Fatalf("Failed to write tram file: %v", err)
+		}
+		tm, err := NewTransitManifest(path)
+		if err != nil {
+			t.Fatalf("Failed to load valid tram file: %v", err)
+		}
+		err = tm.ValidatePayload()
+		if size == st.Size() && err != nil {
+			t.Fatalf("Failed to validate payload: %v", err)
+		}
+		if size != st.Size() && (err == nil || !strings.Contains(err.Error(), "Invalid size")) {
+			t.Fatalf("Expected a size mismatch, got %v", err)
+		}
+	}
+}
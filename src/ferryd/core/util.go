@@ -17,14 +17,12 @@
 package core
 
 import (
-	"crypto/sha1"
-	"crypto/sha256"
-	"encoding/hex"
 	"io"
 	"io/ioutil"
 	"libeopkg"
 	"os"
 	"path/filepath"
+	"syscall"
 )
 
 // CopyFile will copy the file and permissions to the new target
@@ -59,11 +57,11 @@ func CopyFile(source, dest string) error {
 	return nil
 }
 
-// LinkOrCopyFile is a helper which will initially try to hard link,
-// however if we hit an error (because we tried a cross-filesystem hardlink)
-// we'll try to copy instead.
+// LinkOrCopyFile is a helper which will hard link the file when the target
+// directory is on the same filesystem, otherwise it is copied. Should the
+// link fail anyway, i.e. on filesystems without hard links, we copy instead.
 func LinkOrCopyFile(source, dest string, forceCopy bool) error {
-	if forceCopy {
+	if forceCopy || !sameDevice(source, filepath.Dir(dest)) {
 		return CopyFile(source, dest)
 	}
 	if os.Link(source, dest) == nil {
@@ -98,32 +96,6 @@ func RemovePackageParents(path string) error {
 	return nil
 }
 
-// FileSha1sum is a quick wrapper to grab the sha1sum for the given file
-func FileSha1sum(path string) (string, error) {
-	mfile, err := MapFile(path)
-	if err != nil {
-		return "", err
-	}
-	defer mfile.Close()
-	h := sha1.New()
-	// Pump from memory into hash for zero-copy sha1sum
-	h.Write(mfile.Data)
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
-
-// FileSha256sum is a quick wrapper to grab the sha256sum for the given file
-func FileSha256sum(path string) (string, error) {
-	mfile, err := MapFile(path)
-	if err != nil {
-		return "", err
-	}
-	defer mfile.Close()
-	h := sha256.New()
-	// Pump from memory into hash for zero-copy sha1sum
-	h.Write(mfile.Data)
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
-
 // WriteSha1sum will take the sha1sum of the input path and then dump it to
 // the given output path
 func WriteSha1sum(inpPath, outPath string) error {
@@ -153,14 +125,46 @@ func PathExists(path string) bool {
 	return false
 }
 
+// DirectorySize will return the total size of all regular files found
+// under the given path
+func DirectorySize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// DiskUsage will return the number of bytes available to unprivileged
+// users, and the total size, of the filesystem containing path
+func DiskUsage(path string) (free uint64, total uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	free = st.Bavail * uint64(st.Bsize)
+	total = st.Blocks * uint64(st.Bsize)
+	return free, total, nil
+}
+
 // ProduceDelta will attempt to batch the delta production between the
-// two listed file paths and then copy it into the final targetPath
-func ProduceDelta(tmpDir, oldPackage, newPackage, targetPath string) error {
+// two listed file paths and then copy it into the final targetPath.
+// The progress function is optional, as is the cancel channel which will
+// abandon production once closed.
+func ProduceDelta(tmpDir, oldPackage, newPackage, targetPath string, progress libeopkg.DeltaProgressFunc, cancel <-chan struct{}) error {
 	del, err := libeopkg.NewDeltaProducer(tmpDir, oldPackage, newPackage)
 	if err != nil {
 		return err
 	}
 	defer del.Close()
+	del.SetProgressFunc(progress)
+	del.SetCancel(cancel)
 	path, err := del.Commit()
 	if err != nil {
 		return err
@@ -0,0 +1,267 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"ferryd/core"
+	"ferryd/jobs"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventHistory is how many recent events are kept for clients which
+	// reconnect with a Last-Event-ID
+	eventHistory = 256
+
+	// eventBacklog is how many events may be waiting for a client before it
+	// is considered too slow and disconnected
+	eventBacklog = 64
+
+	// eventKeepalive is how often an idle stream is sent a comment, so that
+	// clients can tell a quiet daemon from a dead connection
+	eventKeepalive = 30 * time.Second
+)
+
+// An eventSubscriber is a single client of the event stream
+type eventSubscriber struct {
+	ch     chan *libferry.Event
+	filter map[string]bool // Empty for every event
+}
+
+// wants will determine if the subscriber asked for the event
+func (e *eventSubscriber) wants(event *libferry.Event) bool {
+	return len(e.filter) == 0 || e.filter[event.Event]
+}
+
+// An EventHub hands out daemon events to each client of the event stream,
+// remembering the most recent so that reconnecting clients miss nothing.
+type EventHub struct {
+	mut     *sync.Mutex
+	nextID  uint64
+	history []*libferry.Event
+	subs    map[*eventSubscriber]bool
+	closed  bool
+}
+
+// NewEventHub will return a new EventHub with no subscribers
+func NewEventHub() *EventHub {
+	return &EventHub{
+		mut:    &sync.Mutex{},
+		nextID: 1,
+		subs:   make(map[*eventSubscriber]bool),
+	}
+}
+
+// Publish will stamp the event and send it to every interested subscriber.
+// Subscribers which have fallen too far behind are dropped rather than
+// holding up the caller.
+func (h *EventHub) Publish(event *libferry.Event) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	if h.closed {
+		return
+	}
+	event.ID = h.nextID
+	event.Time = time.Now().UTC()
+	h.nextID++
+
+	h.history = append(h.history, event)
+	if len(h.history) > eventHistory {
+		h.history = h.history[len(h.history)-eventHistory:]
+	}
+
+	for sub := range h.subs {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			log.WithFields(log.Fields{
+				"event": event.Event,
+			}).Warning("Dropping slow event stream client")
+			close(sub.ch)
+			delete(h.subs, sub)
+		}
+	}
+}
+
+// Subscribe will return a new subscriber for the named events, or every event
+// if none are given, along with any remembered events after lastID.
+func (h *EventHub) Subscribe(events []string, lastID uint64) (*eventSubscriber, []*libferry.Event) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	sub := &eventSubscriber{
+		ch:     make(chan *libferry.Event, eventBacklog),
+		filter: make(map[string]bool),
+	}
+	for _, event := range events {
+		sub.filter[event] = true
+	}
+	if h.closed {
+		close(sub.ch)
+		return sub, nil
+	}
+	h.subs[sub] = true
+
+	// An ID from before a restart means nothing to us now
+	if lastID == 0 || lastID >= h.nextID {
+		return sub, nil
+	}
+	var backlog []*libferry.Event
+	for _, event := range h.history {
+		if event.ID > lastID && sub.wants(event) {
+			backlog = append(backlog, event)
+		}
+	}
+	return sub, backlog
+}
+
+// Unsubscribe will stop sending events to the subscriber
+func (h *EventHub) Unsubscribe(sub *eventSubscriber) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.subs[sub] {
+		close(sub.ch)
+		delete(h.subs, sub)
+	}
+}
+
+// Close will end every stream, and ignore any further events
+func (h *EventHub) Close() {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.ch)
+		delete(h.subs, sub)
+	}
+}
+
+// writeEvent will send a single event to the stream
+func writeEvent(w http.ResponseWriter, event *libferry.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, data)
+	return err
+}
+
+// StreamEvents will stream daemon events to the client as server-sent events
+// until either side goes away. The events may be limited with a comma
+// separated "events" parameter, and clients may resume from the ID they last
+// saw by setting the Last-Event-ID header.
+func (s *Server) StreamEvents(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.sendInternalError(errors.New("streaming is not supported"), w, r)
+		return
+	}
+
+	var events []string
+	if filter := r.URL.Query().Get("events"); filter != "" {
+		events = strings.Split(filter, ",")
+	}
+	var lastID uint64
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(id, 10, 64); err != nil {
+			s.sendStockError(fmt.Errorf("invalid event ID: %v", err), w, r)
+			return
+		}
+	}
+
+	sub, backlog := s.events.Subscribe(events, lastID)
+	defer s.events.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range backlog {
+		if err := writeEvent(w, event); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// jobRetired will publish the outcome of each job
+func (s *Server) jobRetired(job *libferry.Job) {
+	event := libferry.EventJobCompleted
+	if job.Failed {
+		event = libferry.EventJobFailed
+	}
+	s.events.Publish(&libferry.Event{Event: event, Job: job})
+}
+
+// jobEvent will publish each job as it is queued and started, or if it
+// becomes overdue
+func (s *Server) jobEvent(event string, job *libferry.Job) {
+	switch event {
+	case jobs.JobQueued:
+		event = libferry.EventJobQueued
+	case jobs.JobStarted:
+		event = libferry.EventJobStarted
+	case jobs.JobOverdue:
+		event = libferry.EventJobOverdue
+		s.webhooks.JobOverdue(job)
+	}
+	s.events.Publish(&libferry.Event{Event: event, Job: job})
+}
+
+// repoEvent will publish changes to the repositories, running the
+// post-publish hooks for each new index unless we're a standby
+func (s *Server) repoEvent(event, repoID string) {
+	s.events.Publish(&libferry.Event{Event: event, Repo: repoID})
+	if event == core.RepoIndexed && !s.inStandby() {
+		s.hooks.RepoIndexed(repoID)
+	}
+}
@@ -0,0 +1,220 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// indexCacheControl is sent with the eopkg index artifacts, which change
+	// with every index operation and must always be revalidated
+	indexCacheControl = "public, max-age=60, must-revalidate"
+
+	// packageCacheControl is sent with .eopkg files, which are immutable once
+	// they've been published. The ID of a package is unique to the instance.
+	packageCacheControl = "public, max-age=31536000, immutable"
+)
+
+// A FileServer provides read-only HTTP access to the published repository
+// tree so that small deployments don't need to run a separate web server.
+//
+// It's deliberately very dumb. We only serve regular files, never list
+// directories, and never follow requests outside of the repository root.
+// Range requests and sendfile are provided by net/http when we hand it an
+// *os.File.
+//
+// Index artifacts are sent with a strong ETag of their content, so pollers
+// revalidating them with If-None-Match only download a new index. Everything
+// may be revalidated with If-Modified-Since.
+type FileServer struct {
+	srv     *http.Server
+	root    string
+	address string
+	socket  net.Listener
+	running bool
+
+	etags   map[string]*fileETag // Index artifacts hashed so far, keyed by path
+	etagMut *sync.Mutex
+}
+
+// A fileETag is the ETag of a file, for as long as it's the same file
+type fileETag struct {
+	info os.FileInfo
+	etag string
+}
+
+// NewFileServer will return a new FileServer for the given repository root,
+// which will listen on address once started.
+func NewFileServer(root, address string) *FileServer {
+	f := &FileServer{
+		root:    root,
+		address: address,
+		etags:   make(map[string]*fileETag),
+		etagMut: &sync.Mutex{},
+	}
+	f.srv = &http.Server{
+		Handler:      f,
+		ReadTimeout:  30 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		WriteTimeout: 0, // Large packages on slow links are fine
+	}
+	return f
+}
+
+// Start will bind the listening socket and begin serving in the background
+func (f *FileServer) Start() error {
+	l, err := net.Listen("tcp", f.address)
+	if err != nil {
+		return err
+	}
+	f.socket = l
+	f.running = true
+
+	log.WithFields(log.Fields{
+		"address": f.address,
+		"root":    f.root,
+	}).Info("Serving repositories over HTTP")
+
+	go func() {
+		if err := f.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"address": f.address,
+				"error":   err,
+			}).Error("Error in serving repositories over HTTP")
+		}
+	}()
+	return nil
+}
+
+// Close will shut down the file server
+func (f *FileServer) Close() {
+	if !f.running {
+		return
+	}
+	f.running = false
+	f.srv.Close()
+}
+
+// cacheControlFor will return the appropriate Cache-Control header for
+// the given file name.
+func cacheControlFor(name string) string {
+	if isIndexFile(name) {
+		return indexCacheControl
+	}
+	if strings.HasSuffix(filepath.Base(name), ".eopkg") {
+		return packageCacheControl
+	}
+	return "public, max-age=300"
+}
+
+// isIndexFile determines if the file is one of the index artifacts, which
+// are replaced with every index operation
+func isIndexFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), "eopkg-index.")
+}
+
+// etagFor will return the strong ETag of the open file, only hashing it if
+// it was replaced since the last time. Indexes are swapped into place rather
+// than written over, so a new index is always a different file.
+func (f *FileServer) etagFor(fullPath string, fi *os.File, st os.FileInfo) (string, error) {
+	f.etagMut.Lock()
+	cached, ok := f.etags[fullPath]
+	f.etagMut.Unlock()
+	if ok && os.SameFile(cached.info, st) && cached.info.Size() == st.Size() && cached.info.ModTime().Equal(st.ModTime()) {
+		return cached.etag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, fi); err != nil {
+		return "", err
+	}
+	if _, err := fi.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+
+	f.etagMut.Lock()
+	f.etags[fullPath] = &fileETag{info: st, etag: etag}
+	f.etagMut.Unlock()
+	return etag, nil
+}
+
+// ServeHTTP implements the http.Handler interface to serve files from
+// the repository root.
+func (f *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// path.Clean on a rooted path ensures we can never walk out of root
+	clean := path.Clean("/" + r.URL.Path)
+	for _, component := range strings.Split(clean, "/") {
+		if strings.HasPrefix(component, ".") {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	fullPath := filepath.Join(f.root, filepath.FromSlash(clean))
+	fi, err := os.Open(fullPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer fi.Close()
+
+	st, err := fi.Stat()
+	if err != nil || !st.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControlFor(fullPath))
+	if strings.HasSuffix(fullPath, ".eopkg") {
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	if isIndexFile(fullPath) {
+		etag, err := f.etagFor(fullPath, fi, st)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"path":  fullPath,
+				"error": err,
+			}).Error("Failed to hash index for ETag")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", etag)
+	}
+
+	// ServeContent handles Range, HEAD, If-None-Match and If-Modified-Since
+	// for us
+	http.ServeContent(w, r, st.Name(), st.ModTime(), fi)
+}
@@ -19,12 +19,26 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"ferryd/core"
 	"ferryd/jobs"
+	"fmt"
 	"github.com/julienschmidt/httprouter"
 	log "github.com/sirupsen/logrus"
 	"libferry"
 	"net/http"
+	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// statusWaitTimeout is the longest we'll block a WaitStatus call, which
+	// must be lower than the client's own timeout
+	statusWaitTimeout = 15 * time.Second
 )
 
 // getMethodOrigin helps us determine the caller so that we can print
@@ -40,277 +54,2042 @@ func getMethodCaller() string {
 	return ""
 }
 
+// requestError is an error with a known category, for those which can't be
+// recognised from the error alone
+type requestError struct {
+	kind libferry.ErrorKind
+	err  error
+}
+
+// Error implements the error interface
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// errorKind will determine the category of the error to report to the
+// client. Anything not otherwise recognised is blamed on the request.
+func errorKind(err error) libferry.ErrorKind {
+	if e, ok := err.(*requestError); ok {
+		return e.kind
+	}
+	switch {
+	case err == jobs.ErrUnknownJob, core.IsNotFound(err):
+		return libferry.ErrorNotFound
+	case err == jobs.ErrIdempotencyConflict:
+		return libferry.ErrorConflict
+	default:
+		return libferry.ErrorInvalid
+	}
+}
+
 // sendStockError is a utility to send a standard response to the ferry
-// client that embeds the error message from ourside.
+// client that embeds the error message from ourside, with the status
+// matching the kind of error.
 func (s *Server) sendStockError(err error, w http.ResponseWriter, r *http.Request) {
+	kind := errorKind(err)
 	response := libferry.Response{
 		Error:       true,
 		ErrorString: err.Error(),
+		ErrorKind:   kind,
 	}
 	log.WithFields(log.Fields{
 		"error":  err,
+		"kind":   kind,
 		"method": getMethodCaller(),
 	}).Error("Client communication error")
+	s.writeResponse(kind.StatusCode(), &response, w)
+}
+
+// sendInternalError will send the error to the client, making clear that
+// ferryd is at fault rather than the request
+func (s *Server) sendInternalError(err error, w http.ResponseWriter, r *http.Request) {
+	s.sendStockError(&requestError{kind: libferry.ErrorInternal, err: err}, w, r)
+}
+
+// sendResponse will encode the successful response to the client
+func (s *Server) sendResponse(response interface{}, w http.ResponseWriter, r *http.Request) {
+	s.writeResponse(http.StatusOK, response, w)
+}
+
+// writeResponse will encode the response to the client with the given
+// status. Should encoding fail there's no response to embed the error in,
+// so the client only sees the status.
+func (s *Server) writeResponse(status int, response interface{}, w http.ResponseWriter) {
 	buf := bytes.Buffer{}
-	if e2 := json.NewEncoder(&buf).Encode(&response); e2 != nil {
-		http.Error(w, e2.Error(), http.StatusInternalServerError)
+	if err := json.NewEncoder(&buf).Encode(response); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to encode response")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
 	w.Write(buf.Bytes())
 }
 
-// GetStatus will return the current status of the ferryd instance
-func (s *Server) GetStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	ret := libferry.StatusRequest{
+// NotFound will reply to requests for unknown API routes
+func (s *Server) NotFound(w http.ResponseWriter, r *http.Request) {
+	s.sendStockError(&requestError{
+		kind: libferry.ErrorNotFound,
+		err:  fmt.Errorf("unknown API endpoint: %s", r.URL.Path),
+	}, w, r)
+}
+
+// MethodNotAllowed will reply to requests using the wrong method for a route
+func (s *Server) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	s.writeResponse(http.StatusMethodNotAllowed, &libferry.Response{
+		Error:       true,
+		ErrorString: fmt.Sprintf("method %s is not allowed for %s", r.Method, r.URL.Path),
+		ErrorKind:   libferry.ErrorInvalid,
+	}, w)
+}
+
+// buildStatus will collect the current status of the ferryd instance
+func (s *Server) buildStatus() (*libferry.StatusRequest, error) {
+	ret := &libferry.StatusRequest{
 		TimeStarted: s.timeStarted,
 		Version:     libferry.Version,
+		Standby:     s.inStandby(),
+		Degraded:    s.degraded,
 	}
 
+	// Grab the generation first so that we never miss a change
+	ret.Generation = s.store.Generation()
+
 	// Stuff the active jobs in
 	jo, err := s.store.ActiveJobs()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	ret.CurrentJobs = jo
 
 	fj, err := s.store.FailedJobs()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	ret.FailedJobs = fj
 
 	cj, err := s.store.CompletedJobs()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	ret.CompletedJobs = cj
 
-	buf := bytes.Buffer{}
-	if err := json.NewEncoder(&buf).Encode(&ret); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	seq, async, err := s.store.QueueLengths()
+	if err != nil {
+		return nil, err
+	}
+	ret.Queues.Sequential = seq
+	ret.Queues.Async = async
+	ret.Workers = s.jproc.WorkerStatus()
+	ret.Storage = s.storageStatus()
+	s.watchMut.Lock()
+	ret.Incoming = s.incomingStatus
+	s.watchMut.Unlock()
+
+	conflicts, err := s.manager.GetConflicts("")
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range conflicts {
+		ret.Conflicts = append(ret.Conflicts, libferry.ReleaseConflict{
+			Time:       c.Time,
+			Repo:       c.Repo,
+			Name:       c.Name,
+			Release:    c.Release,
+			Existing:   c.Existing,
+			New:        c.New,
+			Resolution: c.Resolution,
+		})
+	}
+
+	publishes, err := s.manager.GetPublishStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range publishes {
+		ret.Publish = append(ret.Publish, libferry.PublishStatus{
+			Target:      p.Target,
+			Repo:        p.Repo,
+			Pending:     p.Pending,
+			Synced:      p.Synced,
+			Failures:    p.Failures,
+			Error:       p.Error,
+			NextAttempt: p.NextAttempt,
+		})
+	}
+
+	// Progress is only known to the workers
+	for _, w := range ret.Workers {
+		if w.Progress == nil {
+			continue
+		}
+		for _, j := range ret.CurrentJobs {
+			if j.ID == w.JobID {
+				j.Progress = w.Progress
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// writeStatus will encode the status to the client
+func (s *Server) writeStatus(w http.ResponseWriter, r *http.Request) {
+	ret, err := s.buildStatus()
+	if err != nil {
+		s.sendInternalError(err, w, r)
 		return
 	}
-	w.Write(buf.Bytes())
+
+	s.sendResponse(ret, w, r)
+}
+
+// GetCapabilities will report the operations we support, and the schema
+// versions we write, so that clients can check they're compatible
+func (s *Server) GetCapabilities(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	kinds, _, err := s.manager.SchemaStatus()
+	if err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+
+	req := libferry.CapabilitiesRequest{
+		Version:    libferry.Version,
+		APIVersion: libferry.APIVersion,
+		Operations: []string{},
+		Schemas:    make(map[string]string),
+	}
+	for _, route := range s.routes {
+		req.Operations = append(req.Operations, route.method+" "+route.path)
+	}
+	for _, kind := range kinds {
+		req.Schemas[kind.Kind] = kind.Current
+	}
+	s.sendResponse(&req, w, r)
+}
+
+// GetStatus will return the current status of the ferryd instance
+func (s *Server) GetStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.writeStatus(w, r)
+}
+
+// WaitStatus is a long-poll variant of GetStatus. It will only return once
+// the job generation differs from the "since" query parameter, or after
+// a short timeout, allowing clients to monitor the job queues without
+// repeatedly polling.
+func (s *Server) WaitStatus(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		s.sendStockError(fmt.Errorf("invalid generation: %v", err), w, r)
+		return
+	}
+	s.store.WaitForChange(since, statusWaitTimeout)
+	s.writeStatus(w, r)
+}
+
+// storageStatus will determine the size of our databases and the free space
+// in our base directory. Failures here are only logged, as they shouldn't
+// prevent the rest of the status being reported.
+func (s *Server) storageStatus() libferry.StorageStatus {
+	var ret libferry.StorageStatus
+	var err error
+
+	baseDir := s.config.BaseDir
+	if ret.DatabaseSize, err = core.DirectorySize(filepath.Join(baseDir, core.DatabasePathComponent)); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to determine database size")
+	}
+	if ret.JobDatabaseSize, err = core.DirectorySize(filepath.Join(baseDir, core.JobDbPathComponent)); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to determine job database size")
+	}
+	if ret.DiskFree, ret.DiskTotal, err = core.DiskUsage(baseDir); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Warning("Failed to determine free disk space")
+	}
+	ret.MinFree = s.manager.MinFreeSpace()
+	ret.Pressure = err == nil && ret.DiskFree < ret.MinFree
+	ret.Refused = s.manager.SpaceRefusals()
+	ret.PoolLayout = string(s.manager.PoolLayout())
+	if store := s.manager.ObjectStore(); store != nil {
+		ret.ObjectStore = store.String()
+	}
+	return ret
 }
 
 // GetRepos will attempt to serialise our known repositories into a response
 func (s *Server) GetRepos(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	req := libferry.RepoListingRequest{}
-	repos, err := s.manager.GetRepos()
+	summaries, err := s.manager.GetRepoSummaries()
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.sendInternalError(err, w, r)
 		return
 	}
-	for _, repo := range repos {
-		req.Repository = append(req.Repository, repo.ID)
-	}
-	buf := bytes.Buffer{}
-	if err := json.NewEncoder(&buf).Encode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	for _, summary := range summaries {
+		req.Repository = append(req.Repository, summary.ID)
+		req.Summaries = append(req.Summaries, libferry.RepoSummary{
+			ID:       summary.ID,
+			Packages: summary.Packages,
+			Releases: summary.Releases,
+			Deltas:   summary.Deltas,
+			Size:     summary.Size,
+			Held:     summary.Held,
+			Indexed:  summary.Indexed,
+			LastIndex: libferry.IndexStatus{
+				Begin: summary.LastIndex.Time,
+				End:   summary.LastIndex.Time.Add(summary.LastIndex.Duration),
+				Error: summary.LastIndex.Error,
+			},
+			Dirty: summary.Dirty,
+		})
 	}
-	w.Write(buf.Bytes())
+	s.sendResponse(&req, w, r)
 }
 
-// GetPoolItems will handle responding with the currently known pool items
+// GetPoolItems will handle responding with the currently known pool items,
+// with optional filtering and pagination
 func (s *Server) GetPoolItems(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	req := libferry.PoolListingRequest{}
-	pools, err := s.manager.GetPoolItems()
+	query := &core.PoolQuery{
+		Prefix:   r.URL.Query().Get("prefix"),
+		Orphaned: r.URL.Query().Get("orphaned") == "true",
+	}
+	var err error
+	if query.Offset, err = queryInt(r, "offset", 0); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if query.Limit, err = queryInt(r, "limit", 0); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	pools, total, err := s.manager.ListPoolItems(query)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.sendInternalError(err, w, r)
 		return
 	}
+	req := libferry.PoolListingRequest{
+		Total:  total,
+		Offset: query.Offset,
+	}
 	for _, pool := range pools {
 		req.Item = append(req.Item, libferry.PoolItem{
 			ID:       pool.Name,
 			RefCount: int(pool.RefCount),
+			Alias:    pool.Alias,
 		})
 	}
-	buf := bytes.Buffer{}
-	if err := json.NewEncoder(&buf).Encode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Write(buf.Bytes())
+	s.sendResponse(&req, w, r)
 }
 
-// CreateRepo will handle remote requests for repository creation
-func (s *Server) CreateRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	log.WithFields(log.Fields{
-		"id": id,
-	}).Info("Repository creation requested")
-	s.jproc.PushJob(jobs.NewCreateRepoJob(id))
+// queryInt will parse an optional non-negative integer query parameter
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", name, v)
+	}
+	return n, nil
 }
 
-// DeleteRepo will handle remote requests for repository deletion
-func (s *Server) DeleteRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	log.WithFields(log.Fields{
-		"id": id,
-	}).Info("Repository deletion requested")
-	s.jproc.PushJob(jobs.NewDeleteRepoJob(id))
-}
+// GetPackages will list the published packages within a repository, with
+// optional glob filtering and pagination
+func (s *Server) GetPackages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
 
-// DeltaRepo will handle remote requests for repository deltaing
-func (s *Server) DeltaRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	log.WithFields(log.Fields{
-		"id": id,
-	}).Info("Repository delta requested")
-	s.jproc.PushJob(jobs.NewDeltaRepoJob(id))
-}
+	pkgs, err := s.manager.ListPackages(p.ByName("id"), r.URL.Query().Get("glob"))
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
 
-// IndexRepo will handle remote requests for repository indexing
-func (s *Server) IndexRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-	log.WithFields(log.Fields{
-		"id": id,
-	}).Info("Repository indexing requested")
-	s.jproc.PushJob(jobs.NewIndexRepoJob(id))
-}
+	req := libferry.PackageListingRequest{
+		Total:  len(pkgs),
+		Offset: offset,
+		Items:  []libferry.PackageItem{},
+	}
+	if offset > len(pkgs) {
+		offset = len(pkgs)
+	}
+	pkgs = pkgs[offset:]
+	if limit > 0 && limit < len(pkgs) {
+		pkgs = pkgs[:limit]
+	}
+	for _, pkg := range pkgs {
+		req.Items = append(req.Items, libferry.PackageItem{
+			Name:    pkg.Meta.Name,
+			Version: pkg.Meta.GetVersion(),
+			Release: pkg.Meta.GetRelease(),
+			Size:    pkg.Meta.PackageSize,
+			ID:      pkg.Name,
+		})
+	}
 
-// ImportPackages will bulk-import the packages in the request
-func (s *Server) ImportPackages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
+	s.sendResponse(&req, w, r)
+}
 
-	req := libferry.ImportRequest{}
+// convertProvenance will return the client representation of the provenance
+func convertProvenance(prov *core.Provenance) *libferry.Provenance {
+	if prov == nil {
+		return nil
+	}
+	return &libferry.Provenance{
+		Builder:  prov.Builder,
+		Build:    prov.Build,
+		Manifest: prov.Manifest,
+		Uploaded: prov.Uploaded,
+		JobID:    prov.JobID,
+	}
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+// GetPackageInfo will return everything we know about a single package
+// within a repository
+func (s *Server) GetPackageInfo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	info, err := s.manager.GetPackageInfo(p.ByName("id"), p.ByName("package"))
+	if err != nil {
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"id":        id,
-		"npackages": len(req.Path),
-	}).Info("Repository bulk import requested")
+	req := libferry.PackageInfoRequest{
+		Available: []libferry.PackageItem{},
+		Deltas:    []libferry.DeltaItem{},
+	}
+	if info.Published != nil {
+		req.Package = info.Published.Meta
+		req.Provenance = convertProvenance(info.Published.Provenance)
+	}
+	for _, pkg := range info.Available {
+		req.Available = append(req.Available, libferry.PackageItem{
+			Name:       pkg.Meta.Name,
+			Version:    pkg.Meta.GetVersion(),
+			Release:    pkg.Meta.GetRelease(),
+			Size:       pkg.Meta.PackageSize,
+			ID:         pkg.Name,
+			Provenance: convertProvenance(pkg.Provenance),
+		})
+	}
+	for _, delta := range info.Deltas {
+		item := libferry.DeltaItem{
+			ID:   delta.Name,
+			Size: delta.Meta.PackageSize,
+		}
+		if delta.Delta != nil {
+			item.FromRelease = delta.Delta.FromRelease
+			item.ToRelease = delta.Delta.ToRelease
+		}
+		req.Deltas = append(req.Deltas, item)
+	}
 
-	s.jproc.PushJob(jobs.NewBulkAddJob(id, req.Path))
+	s.sendResponse(&req, w, r)
 }
 
-// CloneRepo will proxy a job to clone an existing repository
-func (s *Server) CloneRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
-
-	req := libferry.CloneRepoRequest{}
+// Search will find packages across one or all repositories
+func (s *Server) Search(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	q := r.URL.Query()
+	query := &core.SearchQuery{
+		Pattern: q.Get("q"),
+		Regex:   q.Get("regex") == "true",
+		Repo:    q.Get("repo"),
+	}
+	if query.Pattern == "" {
+		s.sendStockError(errors.New("search pattern cannot be empty"), w, r)
+		return
+	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	docs, err := s.manager.Search(query)
+	if err != nil {
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"source":    id,
-		"target":    req.CloneName,
-		"fullClone": req.CopyAll,
-	}).Info("Repository clone requested")
+	req := libferry.SearchRequest{
+		Results: []libferry.SearchResult{},
+	}
+	for _, doc := range docs {
+		req.Results = append(req.Results, libferry.SearchResult{
+			Repo:    doc.Repo,
+			Name:    doc.Name,
+			Version: doc.Version,
+			Release: doc.Release,
+			Summary: doc.Summary,
+			ID:      doc.PackageID,
+		})
+	}
 
-	s.jproc.PushJob(jobs.NewCloneRepoJob(id, req.CloneName, req.CopyAll))
+	s.sendResponse(&req, w, r)
 }
 
-// PullRepo will proxy a job to pull an existing repository
-func (s *Server) PullRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	target := p.ByName("id")
+// diffItems will convert the core diff entries for the client
+func diffItems(entries []*core.DiffEntry) []libferry.DiffItem {
+	ret := []libferry.DiffItem{}
+	for _, e := range entries {
+		item := libferry.DiffItem{
+			Name: e.Name,
+		}
+		if e.Source != nil {
+			item.SourceVersion = e.Source.GetVersion()
+			item.SourceRelease = e.Source.GetRelease()
+		}
+		if e.Target != nil {
+			item.TargetVersion = e.Target.GetVersion()
+			item.TargetRelease = e.Target.GetRelease()
+		}
+		ret = append(ret, item)
+	}
+	return ret
+}
 
-	req := libferry.PullRepoRequest{}
+// DiffRepos will report the differences between two repositories
+func (s *Server) DiffRepos(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sourceID := p.ByName("id")
+	targetID := p.ByName("target")
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	diff, err := s.manager.DiffRepos(sourceID, targetID)
+	if err != nil {
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"source": req.Source,
-		"target": target,
-	}).Info("Repository pull requested")
+	req := libferry.RepoDiffRequest{
+		Source:       sourceID,
+		Target:       targetID,
+		Newer:        diffItems(diff.Newer),
+		Missing:      diffItems(diff.Missing),
+		Obsolete:     diffItems(diff.Obsolete),
+		Inconsistent: diffItems(diff.Inconsistent),
+		Held:         diffItems(diff.Held),
+		Banned:       diffItems(diff.Banned),
+	}
 
-	s.jproc.PushJob(jobs.NewPullRepoJob(req.Source, target))
+	s.sendResponse(&req, w, r)
 }
 
-// RemoveSource will proxy a job to remove an existing set of packages by source name + relno
-func (s *Server) RemoveSource(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	target := p.ByName("id")
+// historyPackages will convert the core history packages for the client
+func historyPackages(pkgs []core.HistoryPackage) []libferry.HistoryPackage {
+	ret := []libferry.HistoryPackage{}
+	for _, p := range pkgs {
+		ret = append(ret, libferry.HistoryPackage{
+			Name: p.Name,
+			ID:   p.ID,
+		})
+	}
+	return ret
+}
 
-	req := libferry.RemoveSourceRequest{}
+// GetHistory will return the changes made to a repository, newest first
+func (s *Server) GetHistory(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"source":  req.Source,
-		"release": req.Release,
-		"repo":    target,
-	}).Info("Source removal requested")
+	entries, err := s.manager.GetHistory(id, r.URL.Query().Get("package"), limit)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
 
-	s.jproc.PushJob(jobs.NewRemoveSourceJob(target, req.Source, req.Release))
-}
+	req := libferry.HistoryRequest{
+		Repo:  id,
+		Items: []libferry.HistoryItem{},
+	}
+	for _, e := range entries {
+		req.Items = append(req.Items, libferry.HistoryItem{
+			Time:        e.Time,
+			JobID:       e.JobID,
+			Operation:   e.Operation,
+			Description: e.Description,
+			Params:      e.Params,
+			Added:       historyPackages(e.Added),
+			Removed:     historyPackages(e.Removed),
+			Error:       e.Error,
+		})
+	}
 
-// CopySource will proxy a job to copy a package by source&relno into target
-func (s *Server) CopySource(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	sourceRepo := p.ByName("id")
+	s.sendResponse(&req, w, r)
+}
 
-	req := libferry.CopySourceRequest{}
+// GetRepoConfig will return the settings of a repository
+func (s *Server) GetRepoConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	repo, err := s.manager.GetRepo(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"sourceName": req.Source,
-		"release":    req.Release,
-		"from":       sourceRepo,
-		"to":         req.Target,
-	}).Info("Source copy requested")
+	policy := repo.DeltaPolicy
+	req := libferry.RepoConfigRequest{
+		Repo: id,
+		Delta: libferry.DeltaPolicy{
+			Enabled:     !policy.Disabled,
+			MaxDeltas:   policy.MaxDeltas,
+			MinDistance: policy.MinDistance,
+			MaxSize:     policy.MaxSize,
+		},
+		VerifyHashes:   repo.VerifyHashes,
+		ConflictPolicy: string(repo.ConflictPolicy),
+		VerifyIndex:    repo.VerifyIndex,
+		Architectures:  repo.Architectures,
+		Quota:          repo.Quota,
+
+		ComponentIndexes: repo.ComponentIndexes,
+		VerifyDeltas:     repo.VerifyDeltas,
+		VersionedIndexes: repo.VersionedIndexes,
+	}
+	if req.ConflictPolicy == "" {
+		req.ConflictPolicy = string(core.ConflictKeep)
+	}
+	if req.Architectures == nil {
+		req.Architectures = []string{}
+	}
+	if req.Used, err = s.manager.RepoSize(id); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
 
-	s.jproc.PushJob(jobs.NewCopySourceJob(sourceRepo, req.Target, req.Source, req.Release))
+	s.sendResponse(&req, w, r)
 }
 
-// TrimPackages will proxy a job to remove excess fat from a repo
-func (s *Server) TrimPackages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	target := p.ByName("id")
+// SetRepoConfig will change the settings of a repository. This is blocking.
+func (s *Server) SetRepoConfig(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
 
-	req := libferry.TrimPackagesRequest{}
+	req := libferry.RepoConfigRequest{}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.sendStockError(err, w, r)
 		return
 	}
 
-	log.WithFields(log.Fields{
-		"repo":    target,
-		"maxKeep": req.MaxKeep,
-	}).Info("Package trim requested")
-
-	s.jproc.PushJob(jobs.NewTrimPackagesJob(target, req.MaxKeep))
-}
+	policy := &core.DeltaPolicy{
+		Disabled:    !req.Delta.Enabled,
+		MaxDeltas:   req.Delta.MaxDeltas,
+		MinDistance: req.Delta.MinDistance,
+		MaxSize:     req.Delta.MaxSize,
+	}
 
-// TrimObsolete will proxy a job to remove obsolete packages from a repo
-func (s *Server) TrimObsolete(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	id := p.ByName("id")
 	log.WithFields(log.Fields{
-		"id": id,
-	}).Info("Obsoletes trim requested")
-	s.jproc.PushJob(jobs.NewTrimObsoleteJob(id))
-}
+		"repo":        id,
+		"deltas":      req.Delta.Enabled,
+		"maxDeltas":   policy.MaxDeltas,
+		"minDistance": policy.MinDistance,
+		"maxSize":     policy.MaxSize,
+		"verify":      req.VerifyHashes,
+		"conflicts":   req.ConflictPolicy,
+		"verifyIndex": req.VerifyIndex,
+		"archs":       req.Architectures,
+		"components":  req.ComponentIndexes,
+		"verifyDelta": req.VerifyDeltas,
+		"versioned":   req.VersionedIndexes,
+		"quota":       req.Quota,
+	}).Info("Repository configuration changed")
 
-// ResetCompleted will ask the job store to remove completed jobs. This is blocking.
-func (s *Server) ResetCompleted(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	if err := s.store.ResetCompleted(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	conflictPolicy := core.ConflictPolicy(req.ConflictPolicy)
+	if err := conflictPolicy.Validate(); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	for _, arch := range req.Architectures {
+		if err := core.ValidateArchitecture(arch); err != nil {
+			s.sendStockError(err, w, r)
+			return
+		}
 	}
-}
 
-// ResetFailed will ask the job store to remove failed jobs. This is blocking.
-func (s *Server) ResetFailed(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-	if err := s.store.ResetFailed(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.manager.SetDeltaPolicy(id, policy); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetVerifyHashes(id, req.VerifyHashes); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetConflictPolicy(id, conflictPolicy); err != nil {
+		s.sendStockError(err, w, r)
+		return
 	}
+	if err := s.manager.SetVerifyIndex(id, req.VerifyIndex); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetQuota(id, req.Quota); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetArchitectures(id, req.Architectures); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetComponentIndexes(id, req.ComponentIndexes); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetVerifyDeltas(id, req.VerifyDeltas); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if err := s.manager.SetVersionedIndexes(id, req.VersionedIndexes); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// GetHeld will list the sources held in a repository
+func (s *Server) GetHeld(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	repo, err := s.manager.GetRepo(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.HoldRequest{
+		Repo:    id,
+		Sources: repo.Held,
+	}
+	if req.Sources == nil {
+		req.Sources = []string{}
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// decodeHoldRequest will read the sources to hold or release from the request
+func (s *Server) decodeHoldRequest(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	req := libferry.HoldRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return nil, false
+	}
+	if len(req.Sources) == 0 {
+		s.sendStockError(errors.New("no sources given"), w, r)
+		return nil, false
+	}
+	for _, source := range req.Sources {
+		if source == "" {
+			s.sendStockError(errors.New("source names cannot be empty"), w, r)
+			return nil, false
+		}
+	}
+	return req.Sources, true
+}
+
+// HoldSources will stop sources being pulled or promoted into a repository.
+// This is blocking.
+func (s *Server) HoldSources(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	sources, ok := s.decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":    id,
+		"sources": sources,
+	}).Info("Holding sources")
+
+	for _, source := range sources {
+		if err := s.manager.HoldSource(id, source); err != nil {
+			s.sendStockError(err, w, r)
+			return
+		}
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// UnholdSources will release held sources in a repository. This is blocking.
+func (s *Server) UnholdSources(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	sources, ok := s.decodeHoldRequest(w, r)
+	if !ok {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":    id,
+		"sources": sources,
+	}).Info("Releasing held sources")
+
+	for _, source := range sources {
+		if err := s.manager.UnholdSource(id, source); err != nil {
+			s.sendStockError(err, w, r)
+			return
+		}
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// GetBans will list the bans of a repository
+func (s *Server) GetBans(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	repo, err := s.manager.GetRepo(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.BanRequest{
+		Repo: id,
+		Bans: []libferry.Ban{},
+	}
+	for _, ban := range repo.Bans {
+		req.Bans = append(req.Bans, libferry.Ban{
+			Name:        ban.Name,
+			Source:      ban.Source,
+			ReleaseFrom: ban.ReleaseFrom,
+			ReleaseTo:   ban.ReleaseTo,
+			Reason:      ban.Reason,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// decodeBanRequest will read the bans to add or lift from the request
+func (s *Server) decodeBanRequest(w http.ResponseWriter, r *http.Request) ([]*core.Ban, bool) {
+	req := libferry.BanRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return nil, false
+	}
+	if len(req.Bans) == 0 {
+		s.sendStockError(errors.New("no bans given"), w, r)
+		return nil, false
+	}
+
+	var bans []*core.Ban
+	for _, ban := range req.Bans {
+		b := &core.Ban{
+			Name:        ban.Name,
+			Source:      ban.Source,
+			ReleaseFrom: ban.ReleaseFrom,
+			ReleaseTo:   ban.ReleaseTo,
+			Reason:      ban.Reason,
+		}
+		if err := b.Validate(); err != nil {
+			s.sendStockError(err, w, r)
+			return nil, false
+		}
+		bans = append(bans, b)
+	}
+	return bans, true
+}
+
+// AddBans will stop matching packages being introduced into a repository.
+// This is blocking.
+func (s *Server) AddBans(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	bans, ok := s.decodeBanRequest(w, r)
+	if !ok {
+		return
+	}
+
+	for _, ban := range bans {
+		log.WithFields(log.Fields{
+			"repo":   id,
+			"ban":    ban.String(),
+			"reason": ban.Reason,
+		}).Info("Adding ban")
+
+		if err := s.manager.AddBan(id, ban); err != nil {
+			s.sendStockError(err, w, r)
+			return
+		}
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// RemoveBans will lift bans from a repository. This is blocking.
+func (s *Server) RemoveBans(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	bans, ok := s.decodeBanRequest(w, r)
+	if !ok {
+		return
+	}
+
+	for _, ban := range bans {
+		log.WithFields(log.Fields{
+			"repo": id,
+			"ban":  ban.String(),
+		}).Info("Lifting ban")
+
+		if err := s.manager.RemoveBan(id, ban); err != nil {
+			s.sendStockError(err, w, r)
+			return
+		}
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// queueJob will queue the job, returning its ID. When the request carries an
+// idempotency key which already queued a job, the ID of that job is returned
+// instead.
+func (s *Server) queueJob(job *jobs.JobEntry, r *http.Request) (string, error) {
+	key := r.Header.Get(libferry.IdempotencyKeyHeader)
+	if len(key) > libferry.MaxIdempotencyKeyLength {
+		return "", fmt.Errorf("%s is longer than %d bytes", libferry.IdempotencyKeyHeader, libferry.MaxIdempotencyKeyLength)
+	}
+	return s.jproc.PushJobOnce(job, key)
+}
+
+// pushJob will queue the job, replying with its ID so that the client can
+// follow it
+func (s *Server) pushJob(job *jobs.JobEntry, w http.ResponseWriter, r *http.Request) {
+	jobID, err := s.queueJob(job, r)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	resp := libferry.JobResponse{
+		JobID: jobID,
+	}
+	s.sendResponse(&resp, w, r)
+}
+
+// batchJob will return the job for a single operation of a batch
+func batchJob(op *libferry.BatchOperation) (*jobs.JobEntry, error) {
+	if op.Repo == "" {
+		return nil, fmt.Errorf("%s requires a repository", op.Op)
+	}
+	switch op.Op {
+	case libferry.BatchCreateRepo:
+		return jobs.NewCreateRepoJob(op.Repo), nil
+	case libferry.BatchImport:
+		if len(op.Paths) == 0 {
+			return nil, errors.New("import requires packages")
+		}
+		for _, path := range op.Paths {
+			if !filepath.IsAbs(path) {
+				return nil, fmt.Errorf("import path must be absolute: %s", path)
+			}
+		}
+		return jobs.NewBulkAddJob(op.Repo, op.Paths), nil
+	case libferry.BatchIndex:
+		return jobs.NewIndexRepoJob(op.Repo), nil
+	case libferry.BatchDelta:
+		return jobs.NewDeltaRepoJob(op.Repo, ""), nil
+	case libferry.BatchClone, libferry.BatchPull:
+		if op.From == "" {
+			return nil, fmt.Errorf("%s requires a source repository", op.Op)
+		}
+		if op.Op == libferry.BatchClone {
+			return jobs.NewCloneRepoJob(op.From, op.Repo, op.Full), nil
+		}
+		return jobs.NewPullRepoJob(op.From, op.Repo), nil
+	default:
+		return nil, fmt.Errorf("unknown batch operation: %s", op.Op)
+	}
+}
+
+// Batch will queue every operation in the request as a chain of jobs, each
+// depending on the last, replying with the ID of each job
+func (s *Server) Batch(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := libferry.BatchRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	// Retrying a batch can't be made safe one job at a time
+	if r.Header.Get(libferry.IdempotencyKeyHeader) != "" {
+		s.sendStockError(fmt.Errorf("%s is not supported for batches", libferry.IdempotencyKeyHeader), w, r)
+		return
+	}
+	if len(req.Operations) == 0 {
+		s.sendStockError(errors.New("the batch is empty"), w, r)
+		return
+	}
+
+	// Validate everything before queueing anything
+	chain := make([]*jobs.JobEntry, 0, len(req.Operations))
+	for i := range req.Operations {
+		job, err := batchJob(&req.Operations[i])
+		if err != nil {
+			s.sendStockError(fmt.Errorf("operation %d: %v", i+1, err), w, r)
+			return
+		}
+		chain = append(chain, job)
+	}
+
+	log.WithFields(log.Fields{
+		"operations": len(chain),
+	}).Info("Batch requested")
+
+	ids, err := s.jproc.PushChain(chain)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	resp := libferry.BatchResponse{
+		JobIDs: ids,
+	}
+	s.sendResponse(&resp, w, r)
+}
+
+// GetJob will report on a single job, along with how many of the jobs it
+// queued are still pending
+func (s *Server) GetJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	job, state, err := s.store.GetJob(id)
+	if err != nil {
+		s.sendStockError(&requestError{kind: errorKind(err), err: fmt.Errorf("%v: %s", err, id)}, w, r)
+		return
+	}
+	req := libferry.JobStatusRequest{
+		Job:   *job,
+		State: state,
+	}
+	// Only jobs without a parent have children, which carry its ID as their
+	// family, so a child job is only ever waiting on itself
+	if job.ParentID == "" {
+		if req.Pending, err = s.store.FamilySize(job.ID); err != nil {
+			s.sendInternalError(err, w, r)
+			return
+		}
+	} else if state == libferry.JobQueued || state == libferry.JobRunning {
+		req.Pending = 1
+	}
+	s.sendResponse(&req, w, r)
+}
+
+// GetCompletedJobs will list the successfully completed jobs, newest first,
+// with optional filtering by repository and pagination
+func (s *Server) GetCompletedJobs(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	s.listJobs(s.store.CompletedJobs, w, r)
+}
+
+// GetFailedJobs will list the failed jobs, newest first, with optional
+// filtering by repository and pagination
+func (s *Server) GetFailedJobs(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	s.listJobs(s.store.FailedJobs, w, r)
+}
+
+// listJobs will send a page of the jobs returned by fetch
+func (s *Server) listJobs(fetch func() ([]*libferry.Job, error), w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	all, err := fetch()
+	if err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+	repo := r.URL.Query().Get("repo")
+	var matches libferry.JobSet
+	for _, j := range all {
+		if repo == "" || j.Repo == repo {
+			matches = append(matches, j)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		return matches[a].Timing.End.After(matches[b].Timing.End)
+	})
+
+	req := libferry.JobListingRequest{
+		Total:  len(matches),
+		Offset: offset,
+		Items:  libferry.JobSet{},
+	}
+	if offset > len(matches) {
+		offset = len(matches)
+	}
+	matches = matches[offset:]
+	if limit > 0 && limit < len(matches) {
+		matches = matches[:limit]
+	}
+	req.Items = append(req.Items, matches...)
+
+	s.sendResponse(&req, w, r)
+}
+
+// CreateRepo will handle remote requests for repository creation
+func (s *Server) CreateRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Repository creation requested")
+	s.pushJob(jobs.NewCreateRepoJob(id), w, r)
+}
+
+// confirmed will check that the destructive action has been confirmed by
+// the client. If not, a ConfirmationResponse is sent and false is returned.
+func (s *Server) confirmed(action string, conf *libferry.Confirmation, w http.ResponseWriter, r *http.Request) bool {
+	cr, err := s.confirmations.Check(action, conf)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return false
+	}
+	if cr == nil {
+		return true
+	}
+
+	log.WithFields(log.Fields{
+		"action": action,
+	}).Info("Confirmation required for destructive action")
+
+	s.writeResponse(http.StatusPreconditionRequired, cr, w)
+	return false
+}
+
+// DeleteRepo will handle remote requests for repository deletion
+func (s *Server) DeleteRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.DeleteRepoRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if !s.confirmed(fmt.Sprintf("Delete repository '%s'", id), &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Repository deletion requested")
+	s.pushJob(jobs.NewDeleteRepoJob(id), w, r)
+}
+
+// DeltaRepo will handle remote requests for repository deltaing
+func (s *Server) DeltaRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	historyID := r.URL.Query().Get("history")
+	log.WithFields(log.Fields{
+		"id":      id,
+		"history": historyID,
+	}).Info("Repository delta requested")
+	s.pushJob(jobs.NewDeltaRepoJob(id, historyID), w, r)
+}
+
+// RetryDeltas will forget the failed deltas of a package, and queue another
+// attempt at them followed by an index
+func (s *Server) RetryDeltas(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.DeltaRetryRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if req.Package == "" {
+		s.sendStockError(errors.New("a package name must be given"), w, r)
+		return
+	}
+
+	cleared, err := s.manager.RetryDeltas(id, req.Package)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	log.WithFields(log.Fields{
+		"id":      id,
+		"package": req.Package,
+		"cleared": len(cleared),
+	}).Info("Delta retry requested")
+	s.pushJob(jobs.NewDeltaIndexJob(id, req.Package), w, r)
+}
+
+// IndexRepo will handle remote requests for repository indexing
+func (s *Server) IndexRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Repository indexing requested")
+	s.pushJob(jobs.NewIndexRepoJob(id), w, r)
+}
+
+// ImportPackages will bulk-import the packages in the request
+func (s *Server) ImportPackages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.ImportRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id":        id,
+		"npackages": len(req.Path),
+	}).Info("Repository bulk import requested")
+
+	s.pushJob(jobs.NewBulkAddJob(id, req.Path), w, r)
+}
+
+// ImportDirectory will proxy a job to import every package within a
+// directory visible to the daemon
+func (s *Server) ImportDirectory(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.ImportDirectoryRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		s.sendStockError(fmt.Errorf("import path must be absolute: %s", req.Path), w, r)
+		return
+	}
+	if st, err := os.Stat(req.Path); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	} else if !st.IsDir() {
+		s.sendStockError(fmt.Errorf("not a directory: %s", req.Path), w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id":        id,
+		"path":      req.Path,
+		"recursive": req.Recursive,
+	}).Info("Repository directory import requested")
+
+	s.pushJob(jobs.NewImportDirectoryJob(id, req.Path, req.Recursive), w, r)
+}
+
+// CloneRepo will proxy a job to clone an existing repository
+func (s *Server) CloneRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.CloneRepoRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":    id,
+		"target":    req.CloneName,
+		"fullClone": req.CopyAll,
+	}).Info("Repository clone requested")
+
+	s.pushJob(jobs.NewCloneRepoJob(id, req.CloneName, req.CopyAll), w, r)
+}
+
+// PullRepo will proxy a job to pull an existing repository
+func (s *Server) PullRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	target := p.ByName("id")
+
+	req := libferry.PullRepoRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source": req.Source,
+		"target": target,
+	}).Info("Repository pull requested")
+
+	s.pushJob(jobs.NewPullRepoJob(req.Source, target), w, r)
+}
+
+// PullSource will proxy a job to pull a single source into the target
+func (s *Server) PullSource(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	target := p.ByName("id")
+
+	req := libferry.PullSourceRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if req.SourceName == "" {
+		s.sendStockError(errors.New("a source name must be given"), w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":     req.Source,
+		"sourceName": req.SourceName,
+		"target":     target,
+	}).Info("Source pull requested")
+
+	s.pushJob(jobs.NewPullSourceJob(req.Source, target, req.SourceName), w, r)
+}
+
+// Promote will proxy a job to promote a source & release into the target,
+// subject to the policy checks
+func (s *Server) Promote(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sourceRepo := p.ByName("id")
+
+	req := libferry.PromoteRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if req.Release < 1 {
+		s.sendStockError(errors.New("a specific release must be promoted"), w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"sourceName": req.Source,
+		"release":    req.Release,
+		"from":       sourceRepo,
+		"to":         req.Target,
+		"move":       req.Move,
+	}).Info("Source promotion requested")
+
+	s.pushJob(jobs.NewPromoteSourceJob(sourceRepo, req.Target, req.Source, req.Release, req.Move), w, r)
+}
+
+// RemoveSource will proxy a job to remove an existing set of packages by source name + relno
+func (s *Server) RemoveSource(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	target := p.ByName("id")
+
+	req := libferry.RemoveSourceRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	action := fmt.Sprintf("Remove all releases of '%s' from '%s'", req.Source, target)
+	if req.Release > 0 {
+		action = fmt.Sprintf("Remove release %d of '%s' from '%s'", req.Release, req.Source, target)
+	}
+	if !s.confirmed(action, &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"source":  req.Source,
+		"release": req.Release,
+		"repo":    target,
+	}).Info("Source removal requested")
+
+	s.pushJob(jobs.NewRemoveSourceJob(target, req.Source, req.Release), w, r)
+}
+
+// CopySource will proxy a job to copy a package by source&relno into target
+func (s *Server) CopySource(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	sourceRepo := p.ByName("id")
+
+	req := libferry.CopySourceRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"sourceName": req.Source,
+		"release":    req.Release,
+		"from":       sourceRepo,
+		"to":         req.Target,
+	}).Info("Source copy requested")
+
+	s.pushJob(jobs.NewCopySourceJob(sourceRepo, req.Target, req.Source, req.Release), w, r)
+}
+
+// TrimPackages will proxy a job to remove excess fat from a repo
+func (s *Server) TrimPackages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	target := p.ByName("id")
+
+	req := libferry.TrimPackagesRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if !s.confirmed(fmt.Sprintf("Trim '%s' to %d releases per package", target, req.MaxKeep), &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":    target,
+		"maxKeep": req.MaxKeep,
+	}).Info("Package trim requested")
+
+	s.pushJob(jobs.NewTrimPackagesJob(target, req.MaxKeep), w, r)
+}
+
+// TrimDeltas will proxy a job to remove stale deltas from a repo
+func (s *Server) TrimDeltas(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Stale delta removal requested")
+	s.pushJob(jobs.NewTrimDeltasJob(id), w, r)
+}
+
+// TrimTemp will proxy a job to remove temporary files left behind by jobs
+// that didn't finish, if they're older than the "age" query parameter
+func (s *Server) TrimTemp(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	maxAge := s.config.Temp.MaxAge.Duration
+	if v := r.URL.Query().Get("age"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil || age <= 0 {
+			s.sendStockError(fmt.Errorf("invalid value for age: %s", v), w, r)
+			return
+		}
+		maxAge = age
+	}
+	log.WithFields(log.Fields{
+		"maxAge": maxAge,
+	}).Info("Temporary file cleanup requested")
+	s.pushJob(jobs.NewCleanTempJob(maxAge), w, r)
+}
+
+// MigratePool will proxy a job to move the pool to another layout
+func (s *Server) MigratePool(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	layout := core.PoolLayout(p.ByName("layout"))
+	if err := layout.Validate(); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	log.WithFields(log.Fields{
+		"layout": layout,
+	}).Info("Pool layout migration requested")
+	s.pushJob(jobs.NewMigratePoolJob(layout), w, r)
+}
+
+// RewriteMetadata will proxy a job to patch the metadata of a stored package
+func (s *Server) RewriteMetadata(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.RewriteMetadataRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	patch := &core.MetadataPatch{
+		Summary:     req.Summary,
+		Description: req.Description,
+		PartOf:      req.PartOf,
+		License:     req.License,
+	}
+	if patch.IsEmpty() {
+		s.sendStockError(core.ErrEmptyPatch, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id":      id,
+		"changes": patch.String(),
+	}).Info("Metadata rewrite requested")
+
+	s.pushJob(jobs.NewRewriteMetadataJob(id, patch), w, r)
+}
+
+// ValidateIndex will proxy a job to check the published index of a repo
+func (s *Server) ValidateIndex(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Index validation requested")
+	s.pushJob(jobs.NewValidateIndexJob(id), w, r)
+}
+
+// RelinkRepo will proxy a job to relink a repo tree against the pool
+func (s *Server) RelinkRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Repository relink requested")
+	s.pushJob(jobs.NewRelinkRepoJob(id), w, r)
+}
+
+// TrimObsolete will proxy a job to remove obsolete packages from a repo
+func (s *Server) TrimObsolete(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.TrimObsoleteRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if !s.confirmed(fmt.Sprintf("Remove obsolete packages from '%s'", id), &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Obsoletes trim requested")
+	s.pushJob(jobs.NewTrimObsoleteJob(id), w, r)
+}
+
+// ResetCompleted will ask the job store to remove completed jobs. This is blocking.
+func (s *Server) ResetCompleted(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if err := s.store.ResetCompleted(); err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// ResetFailed will ask the job store to remove failed jobs. This is blocking.
+func (s *Server) ResetFailed(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	if err := s.store.ResetFailed(); err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
+
+// CreateSnapshot will proxy a job to snapshot a repository
+func (s *Server) CreateSnapshot(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.SnapshotRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":     id,
+		"snapshot": req.Name,
+	}).Info("Snapshot creation requested")
+
+	s.pushJob(jobs.NewCreateSnapshotJob(id, req.Name), w, r)
+}
+
+// GetSnapshots will list the snapshots of a repository
+func (s *Server) GetSnapshots(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	snaps, err := s.manager.GetSnapshots(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.SnapshotListingRequest{
+		Repo:      id,
+		Snapshots: []libferry.SnapshotItem{},
+	}
+	for _, snap := range snaps {
+		req.Snapshots = append(req.Snapshots, libferry.SnapshotItem{
+			Name:      snap.Name,
+			Created:   snap.Created,
+			Packages:  snap.PackageCount(),
+			Automatic: snap.Automatic,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// BackupDatabase will stream a snapshot of the database to the client. Once
+// we've begun sending we can no longer report an error, so the connection
+// is aborted instead, leaving the client with a truncated backup.
+func (s *Server) BackupDatabase(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if err := s.manager.BackupDatabase(w); err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to back up database")
+		panic(http.ErrAbortHandler)
+	}
+	log.Info("Database backed up")
+}
+
+// ExportRepo will stream the repository to the client as a portable archive.
+// As with BackupDatabase, failures once we've begun sending abort the
+// connection, leaving the client with a truncated archive.
+func (s *Server) ExportRepo(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	if _, err := s.manager.GetRepo(id); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err := s.manager.ExportRepo(id, w); err != nil {
+		log.WithFields(log.Fields{
+			"id":    id,
+			"error": err,
+		}).Error("Failed to export repository")
+		panic(http.ErrAbortHandler)
+	}
+	log.WithFields(log.Fields{
+		"id": id,
+	}).Info("Repository exported")
+}
+
+// ImportRepo will store the uploaded repository archive, and proxy a job to
+// create the repository from it. The repository is named by the "name"
+// parameter, or keeps the name it was exported with.
+func (s *Server) ImportRepo(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	path, err := s.manager.StageRepoArchive(r.Body)
+	if err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+	manifest, err := core.ReadArchiveManifest(path)
+	if err != nil {
+		os.Remove(path)
+		s.sendStockError(err, w, r)
+		return
+	}
+	id := r.URL.Query().Get("name")
+	if id == "" {
+		id = manifest.Repo
+	}
+	if _, err := s.manager.GetRepo(id); err == nil {
+		os.Remove(path)
+		s.sendStockError(&requestError{
+			kind: libferry.ErrorConflict,
+			err:  fmt.Errorf("The specified repository '%s' already exists", id),
+		}, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"id":       id,
+		"exported": manifest.Repo,
+		"files":    len(manifest.Pool),
+	}).Info("Repository import requested")
+
+	jobID, err := s.queueJob(jobs.NewImportRepoJob(id, path), r)
+	if err != nil {
+		os.Remove(path)
+		s.sendStockError(err, w, r)
+		return
+	}
+	s.sendResponse(&libferry.JobResponse{JobID: jobID}, w, r)
+}
+
+// GetRepoManifest will describe every package, delta and asset of the
+// repository, for replicas to compare against
+func (s *Server) GetRepoManifest(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	manifest, err := s.manager.RepoManifest(p.ByName("id"))
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.RepoManifestRequest{
+		Repo:    manifest.Repo,
+		Entries: []libferry.ManifestEntry{},
+		Files:   []libferry.ManifestFile{},
+		Assets:  []string{},
+	}
+	for _, entry := range manifest.Entries {
+		req.Entries = append(req.Entries, libferry.ManifestEntry{
+			Name:      entry.Name,
+			Published: entry.Published,
+			Available: entry.Available,
+			Deltas:    entry.Deltas,
+		})
+	}
+	for _, entry := range manifest.Pool {
+		file := libferry.ManifestFile{
+			ID:          entry.Name,
+			Size:        entry.Size,
+			ContentHash: entry.ContentHash,
+			Provenance:  convertProvenance(entry.Provenance),
+		}
+		if entry.Delta != nil {
+			file.Delta = &libferry.ManifestDelta{
+				FromRelease: entry.Delta.FromRelease,
+				FromID:      entry.Delta.FromID,
+				ToRelease:   entry.Delta.ToRelease,
+				ToID:        entry.Delta.ToID,
+				TargetSize:  entry.Delta.TargetSize,
+			}
+		}
+		req.Files = append(req.Files, file)
+	}
+	req.Assets = append(req.Assets, manifest.Assets...)
+	s.sendResponse(&req, w, r)
+}
+
+// GetPoolFile will send the file of a pool entry, so that replicas can fetch
+// the packages and deltas they're missing
+func (s *Server) GetPoolFile(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	f, err := s.manager.OpenPoolFile(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		s.sendInternalError(err, w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, id, st.ModTime(), f)
+}
+
+// GetMigrationStatus will report the schema of the database
+func (s *Server) GetMigrationStatus(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	kinds, applied, err := s.manager.SchemaStatus()
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.MigrationStatusRequest{
+		Kinds:   []libferry.SchemaKind{},
+		Applied: []libferry.AppliedMigration{},
+	}
+	for _, kind := range kinds {
+		k := libferry.SchemaKind{
+			Kind:     kind.Kind,
+			Current:  kind.Current,
+			Database: kind.Database,
+			Pending:  []string{},
+		}
+		for _, m := range kind.Pending {
+			k.Pending = append(k.Pending, fmt.Sprintf("%s -> %s: %s", m.From, m.To, m.Description))
+		}
+		req.Kinds = append(req.Kinds, k)
+	}
+	for _, m := range applied {
+		req.Applied = append(req.Applied, libferry.AppliedMigration{
+			Kind:        m.Kind,
+			From:        m.From,
+			To:          m.To,
+			Description: m.Description,
+			Records:     m.Records,
+			Time:        m.Time,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// GetIndexReport will return the problems found during the last index
+func (s *Server) GetIndexReport(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	report, err := s.manager.GetIndexReport(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.IndexReportRequest{
+		Repo:     id,
+		Time:     report.Time,
+		Packages: report.Packages,
+		Findings: []libferry.IndexFinding{},
+	}
+	for _, f := range report.Findings {
+		req.Findings = append(req.Findings, libferry.IndexFinding{
+			Kind:    f.Kind,
+			Package: f.Package,
+			Detail:  f.Detail,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// GetDeltaStats will report how effective the deltas of a repository are
+func (s *Server) GetDeltaStats(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	limit, err := queryInt(r, "limit", 0)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	stats, err := s.manager.DeltaStats(id, limit)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.DeltaStatsRequest{
+		Repo:        id,
+		Deltas:      stats.Deltas,
+		Current:     stats.Current,
+		Unknown:     stats.Unknown,
+		Bytes:       stats.Bytes,
+		TargetBytes: stats.TargetBytes,
+		Saved:       stats.Saved(),
+		Ratio:       stats.Ratio(),
+		Worst:       []libferry.DeltaStat{},
+	}
+	for _, d := range stats.Worst {
+		req.Worst = append(req.Worst, libferry.DeltaStat{
+			ID:          d.ID,
+			Package:     d.Package,
+			FromRelease: d.FromRelease,
+			ToRelease:   d.ToRelease,
+			Size:        d.Size,
+			TargetSize:  d.TargetSize,
+			Ratio:       d.Ratio(),
+			Current:     d.Current,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// GetDeltaSkips will list the deltas skipped for the packages of a repository
+func (s *Server) GetDeltaSkips(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	skips, err := s.manager.GetDeltaSkips(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	now := time.Now().UTC()
+	req := libferry.DeltaSkipListRequest{
+		Skips: []libferry.DeltaSkip{},
+	}
+	for _, skip := range skips {
+		req.Skips = append(req.Skips, libferry.DeltaSkip{
+			ID:          skip.Name,
+			FromID:      skip.Delta.FromID,
+			ToID:        skip.Delta.ToID,
+			FromRelease: skip.Delta.FromRelease,
+			ToRelease:   skip.Delta.ToRelease,
+			Reason:      string(skip.SkipReason()),
+			Failures:    skip.Failures,
+			Marked:      skip.Marked,
+			Expires:     skip.Expires,
+			Expired:     skip.Expired(now),
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// GetAssets will list the assets installed in a repository
+func (s *Server) GetAssets(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	assets, err := s.manager.GetAssets(id)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.AssetListingRequest{
+		Repo:   id,
+		Assets: []libferry.AssetItem{},
+	}
+	for _, asset := range assets {
+		req.Assets = append(req.Assets, libferry.AssetItem{
+			Name:     asset.Name,
+			Size:     asset.Size,
+			Modified: asset.Modified,
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// GetAsset will return the contents of a single repository asset
+func (s *Server) GetAsset(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+	name := p.ByName("name")
+
+	data, err := s.manager.GetAsset(id, name)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	req := libferry.AssetRequest{
+		Repo: id,
+		Name: name,
+		Data: data,
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// SetAsset will install a repository asset, and proxy a job to reindex the
+// repository so that it is published. The asset itself is set immediately
+// so that validation failures can be reported to the client.
+func (s *Server) SetAsset(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.AssetRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":  id,
+		"asset": req.Name,
+		"size":  len(req.Data),
+	}).Info("Repository asset upload requested")
+
+	if err := s.manager.SetAsset(id, req.Name, req.Data); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	s.pushJob(jobs.NewIndexRepoJob(id), w, r)
+}
+
+// RestoreSnapshot will proxy a job to rewind a repository to a snapshot
+func (s *Server) RestoreSnapshot(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.SnapshotRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if _, err := s.manager.GetSnapshot(id, req.Name); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if !s.confirmed(fmt.Sprintf("Restore repository '%s' to snapshot '%s'", id, req.Name), &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":     id,
+		"snapshot": req.Name,
+	}).Info("Snapshot restore requested")
+
+	s.pushJob(jobs.NewRestoreSnapshotJob(id, req.Name), w, r)
+}
+
+// DeleteSnapshot will proxy a job to remove a snapshot
+func (s *Server) DeleteSnapshot(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	id := p.ByName("id")
+
+	req := libferry.SnapshotRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if _, err := s.manager.GetSnapshot(id, req.Name); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	if !s.confirmed(fmt.Sprintf("Delete snapshot '%s' of repository '%s'", req.Name, id), &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"repo":     id,
+		"snapshot": req.Name,
+	}).Info("Snapshot deletion requested")
+
+	s.pushJob(jobs.NewDeleteSnapshotJob(id, req.Name), w, r)
+}
+
+// GetUndoJobs will list every job which can still be undone
+func (s *Server) GetUndoJobs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	snaps, err := s.manager.GetUndoSnapshots()
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	retention := s.manager.UndoRetention()
+	req := libferry.UndoListingRequest{
+		Items: []libferry.UndoItem{},
+	}
+	for _, snap := range snaps {
+		req.Items = append(req.Items, libferry.UndoItem{
+			JobID:       snap.JobID,
+			Repo:        snap.Repo,
+			Description: snap.Description,
+			Created:     snap.Created,
+			Expires:     snap.Expires(retention),
+		})
+	}
+
+	s.sendResponse(&req, w, r)
+}
+
+// UndoJob will proxy a job to restore the snapshot taken before a job ran
+func (s *Server) UndoJob(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	jobID := p.ByName("job")
+
+	req := libferry.UndoRequest{}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	snap, err := s.manager.GetUndoSnapshot(jobID)
+	if err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+
+	action := fmt.Sprintf("Undo job %s (%s) by restoring '%s' to %s", jobID, snap.Description, snap.Repo,
+		snap.Created.Local().Format("2006-01-02 15:04:05"))
+	if !s.confirmed(action, &req.Confirmation, w, r) {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"jobID":    jobID,
+		"repo":     snap.Repo,
+		"snapshot": snap.Name,
+	}).Info("Job undo requested")
+
+	s.pushJob(jobs.NewRestoreSnapshotJob(snap.Repo, snap.Name), w, r)
 }
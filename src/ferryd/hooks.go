@@ -0,0 +1,357 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+)
+
+const (
+	// EventHookFailed is sent when a post-publish hook fails
+	EventHookFailed = "hook.failed"
+
+	// DefaultHookTimeout is how long a hook may run for if not configured
+	DefaultHookTimeout = 30 * time.Second
+)
+
+// HookVars are the variables available to the templates of a hook
+type HookVars struct {
+	Repo  string   // ID of the repository just indexed
+	Path  string   // The repository tree on disk
+	Files []string // Index artifacts just published, relative to the tree
+	Time  string   // When the index was published, RFC 3339 in UTC
+}
+
+// A PostHook is run after each successful index of a repository, either as
+// a shell command or an HTTP request, i.e. to purge the index from a CDN
+type PostHook struct {
+	name    string
+	command *template.Template
+	url     *template.Template
+	method  string
+	body    *template.Template
+	headers map[string]*template.Template
+	repos   []string
+	timeout time.Duration
+}
+
+// parseHookTemplate will parse one of the templated settings of a hook,
+// trying it out so that unknown variables are caught up front
+func parseHookTemplate(hook, field, text string) (*template.Template, error) {
+	t, err := template.New(hook + "." + field).Option("missingkey=error").Parse(text)
+	if err == nil {
+		err = t.Execute(ioutil.Discard, &HookVars{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("hook %s has an invalid %s template: %v", hook, field, err)
+	}
+	return t, nil
+}
+
+// NewPostHook will return the hook for the configuration, once validated
+func NewPostHook(config *HookConfig) (*PostHook, error) {
+	if config.Name == "" {
+		return nil, errors.New("hooks must have a name")
+	}
+	if (config.Command == "") == (config.URL == "") {
+		return nil, fmt.Errorf("hook %s needs either a command or a url", config.Name)
+	}
+	if config.Timeout.Duration < 0 {
+		return nil, fmt.Errorf("hook %s timeout cannot be negative: %v", config.Name, config.Timeout.Duration)
+	}
+	hook := &PostHook{
+		name:    config.Name,
+		method:  strings.ToUpper(config.Method),
+		repos:   config.Repos,
+		timeout: config.Timeout.Duration,
+		headers: make(map[string]*template.Template),
+	}
+	if hook.timeout == 0 {
+		hook.timeout = DefaultHookTimeout
+	}
+
+	var err error
+	if config.Command != "" {
+		if config.Method != "" || config.Body != "" || len(config.Headers) > 0 {
+			return nil, fmt.Errorf("hook %s only takes a method, body or headers with a url", config.Name)
+		}
+		if hook.command, err = parseHookTemplate(config.Name, "command", config.Command); err != nil {
+			return nil, err
+		}
+		return hook, nil
+	}
+
+	if hook.url, err = parseHookTemplate(config.Name, "url", config.URL); err != nil {
+		return nil, err
+	}
+	if hook.body, err = parseHookTemplate(config.Name, "body", config.Body); err != nil {
+		return nil, err
+	}
+	for k, v := range config.Headers {
+		if hook.headers[k], err = parseHookTemplate(config.Name, "header "+k, v); err != nil {
+			return nil, err
+		}
+	}
+	switch hook.method {
+	case "":
+		hook.method = http.MethodPost
+	case http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, "PURGE", "BAN":
+	default:
+		return nil, fmt.Errorf("hook %s has an unsupported method: %s", config.Name, config.Method)
+	}
+	return hook, nil
+}
+
+// wants returns true if the hook runs for the repository. An empty
+// repository list means every repository.
+func (h *PostHook) wants(repoID string) bool {
+	if len(h.repos) == 0 {
+		return true
+	}
+	for _, repo := range h.repos {
+		if repo == repoID {
+			return true
+		}
+	}
+	return false
+}
+
+// render will execute the template with the variables
+func render(t *template.Template, vars *HookVars) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// run will run the hook for the variables, until the timeout or until ctx
+// is cancelled
+func (h *PostHook) run(ctx context.Context, client *http.Client, vars *HookVars) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	if h.command != nil {
+		return h.runCommand(ctx, vars)
+	}
+	return h.runRequest(ctx, client, vars)
+}
+
+// runCommand will run the hook's command with the shell. The variables are
+// also set in the environment, which is safer than templating them into
+// the command.
+func (h *PostHook) runCommand(ctx context.Context, vars *HookVars) error {
+	command, err := render(h.command, vars)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"FERRYD_REPO="+vars.Repo,
+		"FERRYD_REPO_PATH="+vars.Path,
+		"FERRYD_FILES="+strings.Join(vars.Files, " "),
+		"FERRYD_TIME="+vars.Time,
+	)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Kill everything the shell started too, which would otherwise keep
+	// running with our output open, holding us up until it's done
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		case <-done:
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("killed: %v", ctx.Err())
+		}
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			lines := strings.Split(msg, "\n")
+			return fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+		}
+		return err
+	}
+	return nil
+}
+
+// runRequest will send the hook's HTTP request, which must succeed with a
+// 2xx status
+func (h *PostHook) runRequest(ctx context.Context, client *http.Client, vars *HookVars) error {
+	url, err := render(h.url, vars)
+	if err != nil {
+		return err
+	}
+	body, err := render(h.body, vars)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(h.method, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for k, t := range h.headers {
+		v, err := render(t, vars)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// A HookRunner runs the post-publish hooks after each index. Like webhooks
+// they run in the background, never holding up the job queue, and failures
+// are only logged and sent on as a "hook.failed" event.
+type HookRunner struct {
+	hooks    []*PostHook
+	root     string // Repository trees live here
+	mut      *sync.RWMutex
+	client   *http.Client
+	webhooks *WebhookNotifier
+	ctx      context.Context // Cancelled to kill running hooks on Stop
+	cancel   context.CancelFunc
+	wg       *sync.WaitGroup // Running hooks
+}
+
+// NewHookRunner will return a runner with no hooks configured, for the
+// repository trees within root
+func NewHookRunner(root string, webhooks *WebhookNotifier) *HookRunner {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HookRunner{
+		root:     root,
+		mut:      &sync.RWMutex{},
+		client:   &http.Client{},
+		webhooks: webhooks,
+		ctx:      ctx,
+		cancel:   cancel,
+		wg:       &sync.WaitGroup{},
+	}
+}
+
+// SetHooks will replace the configured hooks
+func (r *HookRunner) SetHooks(hooks []*PostHook) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.hooks = hooks
+}
+
+// Stop will kill any hooks still running and wait for them to finish. No
+// more hooks are run afterwards.
+func (r *HookRunner) Stop() {
+	r.mut.Lock()
+	r.cancel()
+	r.mut.Unlock()
+	r.wg.Wait()
+}
+
+// RepoIndexed will run every hook interested in the repository
+func (r *HookRunner) RepoIndexed(repoID string) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	var vars *HookVars
+	for _, hook := range r.hooks {
+		if !hook.wants(repoID) {
+			continue
+		}
+		if vars == nil {
+			vars = r.vars(repoID)
+		}
+		r.wg.Add(1)
+		go r.run(hook, vars)
+	}
+}
+
+// vars will return the variables for the hooks of the repository
+func (r *HookRunner) vars(repoID string) *HookVars {
+	vars := &HookVars{
+		Repo: repoID,
+		Path: filepath.Join(r.root, repoID),
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+	paths, _ := filepath.Glob(filepath.Join(vars.Path, "eopkg-index.*"))
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".new") {
+			vars.Files = append(vars.Files, filepath.Base(p))
+		}
+	}
+	return vars
+}
+
+// run will run a single hook, reporting any failure
+func (r *HookRunner) run(hook *PostHook, vars *HookVars) {
+	defer r.wg.Done()
+	fields := log.Fields{
+		"hook": hook.name,
+		"repo": vars.Repo,
+	}
+	started := time.Now()
+	if err := hook.run(r.ctx, r.client, vars); err != nil {
+		fields["error"] = err
+		// Killed by Stop, which is no fault of the hook's
+		if r.ctx.Err() != nil {
+			log.WithFields(fields).Warning("Post-publish hook stopped by shutdown")
+			return
+		}
+		log.WithFields(fields).Error("Post-publish hook failed")
+		r.webhooks.Send(&WebhookEvent{
+			Event:   EventHookFailed,
+			Time:    time.Now().UTC(),
+			Repo:    vars.Repo,
+			Message: fmt.Sprintf("%s: %v", hook.name, err),
+		})
+		return
+	}
+	fields["duration"] = time.Since(started)
+	log.WithFields(fields).Info("Ran post-publish hook")
+}
@@ -0,0 +1,219 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHookVars are the variables the hooks under test are run with
+var testHookVars = &HookVars{
+	Repo:  "unstable",
+	Path:  "/srv/ferryd/root/repo/unstable",
+	Files: []string{"eopkg-index.xml", "eopkg-index.xml.xz"},
+	Time:  "2017-10-17T12:00:00Z",
+}
+
+// newTestHook will return the hook for the configuration, failing the test
+// if it isn't valid
+func newTestHook(t *testing.T, config *HookConfig) *PostHook {
+	hook, err := NewPostHook(config)
+	if err != nil {
+		t.Fatalf("Failed to create hook: %v", err)
+	}
+	return hook
+}
+
+func TestNewPostHook(t *testing.T) {
+	hook := newTestHook(t, &HookConfig{Name: "purge", URL: "https://cdn.example.com/{{.Repo}}"})
+	if hook.method != http.MethodPost {
+		t.Fatalf("Expected the method to default to POST, got %s", hook.method)
+	}
+	if hook.timeout != DefaultHookTimeout {
+		t.Fatalf("Expected the default timeout, got %v", hook.timeout)
+	}
+
+	hook = newTestHook(t, &HookConfig{Name: "purge", URL: "https://cdn.example.com/", Method: "purge"})
+	if hook.method != "PURGE" {
+		t.Fatalf("Expected the method to be upper cased, got %s", hook.method)
+	}
+
+	hook = newTestHook(t, &HookConfig{Name: "sync", Command: "rsync -a {{.Path}}/ mirror:", Timeout: Duration{time.Minute}})
+	if hook.command == nil || hook.url != nil {
+		t.Fatalf("Expected a command hook")
+	}
+	if hook.timeout != time.Minute {
+		t.Fatalf("Expected the configured timeout, got %v", hook.timeout)
+	}
+}
+
+func TestNewPostHookInvalid(t *testing.T) {
+	invalid := map[string]*HookConfig{
+		"no name":           {Command: "true"},
+		"neither":           {Name: "hook"},
+		"both":              {Name: "hook", Command: "true", URL: "https://cdn.example.com/"},
+		"command method":    {Name: "hook", Command: "true", Method: "PUT"},
+		"command body":      {Name: "hook", Command: "true", Body: "{{.Repo}}"},
+		"command headers":   {Name: "hook", Command: "true", Headers: map[string]string{"X-Repo": "{{.Repo}}"}},
+		"bad template":      {Name: "hook", Command: "echo {{.Repo"},
+		"unknown key":       {Name: "hook", URL: "https://cdn.example.com/{{.Repository}}"},
+		"unknown in body":   {Name: "hook", URL: "https://cdn.example.com/", Body: "{{.Package}}"},
+		"unknown in header": {Name: "hook", URL: "https://cdn.example.com/", Headers: map[string]string{"X-Repo": "{{.Name}}"}},
+		"bad method":        {Name: "hook", URL: "https://cdn.example.com/", Method: "PATCH"},
+		"negative timeout":  {Name: "hook", Command: "true", Timeout: Duration{-time.Second}},
+	}
+	for name, config := range invalid {
+		if _, err := NewPostHook(config); err == nil {
+			t.Fatalf("Expected an error for a hook with %s", name)
+		}
+	}
+}
+
+func TestPostHookWants(t *testing.T) {
+	every := newTestHook(t, &HookConfig{Name: "every", Command: "true"})
+	some := newTestHook(t, &HookConfig{Name: "some", Command: "true", Repos: []string{"unstable", "shannon"}})
+
+	for _, repo := range []string{"unstable", "shannon", "testing"} {
+		if !every.wants(repo) {
+			t.Fatalf("Hook without repositories should run for %s", repo)
+		}
+	}
+	if !some.wants("unstable") || !some.wants("shannon") {
+		t.Fatalf("Hook should run for its repositories")
+	}
+	if some.wants("testing") {
+		t.Fatalf("Hook shouldn't run for other repositories")
+	}
+}
+
+func TestPostHookRequest(t *testing.T) {
+	var method, uri, body, header string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		method, uri, body, header = r.Method, r.URL.RequestURI(), string(data), r.Header.Get("X-Files")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	hook := newTestHook(t, &HookConfig{
+		Name:    "purge",
+		URL:     srv.URL + "/purge/{{.Repo}}?at={{.Time}}",
+		Method:  "PUT",
+		Body:    `{"path": "{{.Path}}"}`,
+		Headers: map[string]string{"X-Files": `{{range .Files}}{{.}};{{end}}`},
+	})
+	if err := hook.run(context.Background(), srv.Client(), testHookVars); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+	if method != "PUT" {
+		t.Fatalf("Wrong method: %s", method)
+	}
+	if uri != "/purge/unstable?at=2017-10-17T12:00:00Z" {
+		t.Fatalf("Wrong URL: %s", uri)
+	}
+	if body != `{"path": "/srv/ferryd/root/repo/unstable"}` {
+		t.Fatalf("Wrong body: %s", body)
+	}
+	if header != "eopkg-index.xml;eopkg-index.xml.xz;" {
+		t.Fatalf("Wrong header: %s", header)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := hook.run(context.Background(), srv.Client(), testHookVars); err == nil {
+		t.Fatalf("Expected a failing status to be an error")
+	}
+}
+
+func TestPostHookCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ferryd-hooks")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	hook := newTestHook(t, &HookConfig{Name: "sync", Command: `echo "{{.Repo}} $FERRYD_FILES" > ` + out})
+	if err := hook.run(context.Background(), nil, testHookVars); err != nil {
+		t.Fatalf("Hook failed: %v", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Hook didn't run: %v", err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "unstable eopkg-index.xml eopkg-index.xml.xz" {
+		t.Fatalf("Wrong output from hook: %s", got)
+	}
+
+	hook = newTestHook(t, &HookConfig{Name: "sync", Command: "echo ignored; echo broken >&2; exit 3"})
+	err = hook.run(context.Background(), nil, testHookVars)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Expected the failure with the last line of output, got %v", err)
+	}
+
+	hook = newTestHook(t, &HookConfig{Name: "sync", Command: "sleep 10", Timeout: Duration{50 * time.Millisecond}})
+	started := time.Now()
+	if err := hook.run(context.Background(), nil, testHookVars); err == nil {
+		t.Fatalf("Expected the hook to time out")
+	}
+	if time.Since(started) > 5*time.Second {
+		t.Fatalf("Hook wasn't killed when it timed out")
+	}
+}
+
+func TestHookRunnerStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ferryd-hooks")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	started := filepath.Join(dir, "started")
+	out := filepath.Join(dir, "out")
+
+	r := NewHookRunner(dir, NewWebhookNotifier())
+	r.SetHooks([]*PostHook{
+		newTestHook(t, &HookConfig{Name: "slow", Command: "touch " + started + "; sleep 10", Repos: []string{"unstable"}}),
+		newTestHook(t, &HookConfig{Name: "touch", Command: "touch " + out, Repos: []string{"shannon"}}),
+	})
+	r.RepoIndexed("unstable")
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(started); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	stopping := time.Now()
+	r.Stop()
+	if time.Since(stopping) > 5*time.Second {
+		t.Fatalf("Running hook wasn't killed by Stop")
+	}
+
+	r.RepoIndexed("shannon")
+	r.Stop()
+	if _, err := os.Stat(out); err == nil {
+		t.Fatalf("Hook was run after Stop")
+	}
+}
@@ -20,11 +20,16 @@ import (
 	"ferryd/core"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 // BulkAddJobHandler is responsible for indexing repositories and should only
 // ever be used in sequential queues.
 type BulkAddJobHandler struct {
+	logger       *log.Entry        // Scoped to the job being executed
+	progress     *ProgressReporter // Report how many packages were added
+	stats        *JobStats         // Record which packages were added
+	jobID        string            // Recorded in the provenance of the packages
 	repoID       string
 	packagePaths []string
 }
@@ -44,6 +49,10 @@ func NewBulkAddJobHandler(j *JobEntry) (*BulkAddJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &BulkAddJobHandler{
+		logger:       j.Logger(),
+		progress:     j.Progress(),
+		stats:        j.Stats(),
+		jobID:        j.CorrelationID,
 		repoID:       j.Params[0],
 		packagePaths: j.Params[1:],
 	}, nil
@@ -51,13 +60,26 @@ func NewBulkAddJobHandler(j *JobEntry) (*BulkAddJobHandler, error) {
 
 // Execute will attempt the mass-import of packages passed to the job
 func (j *BulkAddJobHandler) Execute(_ *Processor, manager *core.Manager) error {
-	if err := manager.AddPackages(j.repoID, j.packagePaths, false); err != nil {
+	prov := &core.Provenance{
+		Uploaded: time.Now().UTC(),
+		JobID:    j.jobID,
+	}
+	err := manager.BulkAddPackages(j.repoID, j.packagePaths, prov, func(done, total int) {
+		j.progress.Update(j.repoID, "Adding packages", int64(done), int64(total))
+	})
+	if err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Added packages to repository")
+	j.stats.addPoolPackages(manager, j.packagePaths)
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Added packages to repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *BulkAddJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *BulkAddJobHandler) Describe() string {
 	return fmt.Sprintf("Add %v packages to repository '%s'", len(j.packagePaths), j.repoID)
@@ -0,0 +1,74 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// CleanTempJobHandler is responsible for removing leftover temporary files,
+// and should only ever be used in sequential queues.
+type CleanTempJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	maxAge time.Duration
+}
+
+// NewCleanTempJob will return a job suitable for adding to the job processor
+func NewCleanTempJob(maxAge time.Duration) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       CleanTemp,
+		Params:     []string{maxAge.String()},
+	}
+}
+
+// NewCleanTempJobHandler will create a job handler for the input job and ensure it validates
+func NewCleanTempJobHandler(j *JobEntry) (*CleanTempJobHandler, error) {
+	if len(j.Params) != 1 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	maxAge, err := time.ParseDuration(j.Params[0])
+	if err != nil || maxAge < 0 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &CleanTempJobHandler{
+		logger: j.Logger(),
+		maxAge: maxAge,
+	}, nil
+}
+
+// Execute will remove the temporary files which have been left behind
+func (j *CleanTempJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	cleanup, err := manager.CleanTemp(j.maxAge)
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"maxAge":    j.maxAge,
+		"removed":   len(cleanup.Paths),
+		"reclaimed": cleanup.Bytes,
+	}).Info("Cleaned up temporary files")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *CleanTempJobHandler) Describe() string {
+	return fmt.Sprintf("Remove temporary files older than %v", j.maxAge)
+}
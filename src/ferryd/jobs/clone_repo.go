@@ -24,6 +24,7 @@ import (
 
 // CloneRepoJobHandler is responsible for cloning an existing repository
 type CloneRepoJobHandler struct {
+	logger    *log.Entry // Scoped to the job being executed
 	repoID    string
 	newClone  string
 	cloneMode string
@@ -48,6 +49,7 @@ func NewCloneRepoJobHandler(j *JobEntry) (*CloneRepoJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &CloneRepoJobHandler{
+		logger:    j.Logger(),
 		repoID:    j.Params[0],
 		newClone:  j.Params[1],
 		cloneMode: j.Params[2],
@@ -64,10 +66,15 @@ func (j *CloneRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error
 	if err := manager.CloneRepo(j.repoID, j.newClone, fullClone); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Cloned repository")
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Cloned repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *CloneRepoJobHandler) MutatedRepos() []string {
+	return []string{j.newClone}
+}
+
 // Describe returns a human readable description for this job
 func (j *CloneRepoJobHandler) Describe() string {
 	return fmt.Sprintf("Clone repository '%s' into '%s'", j.repoID, j.newClone)
@@ -0,0 +1,110 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+// sameWork will determine if both jobs would do exactly the same thing.
+// Indexes only need to run after everything either depends on, so their
+// dependencies are merged instead.
+func (j *JobEntry) sameWork(other *JobEntry) bool {
+	if j.Type != other.Type || j.ParentID != other.ParentID || !sameStrings(j.Params, other.Params) {
+		return false
+	}
+	return j.Type == IndexRepo || sameStrings(j.DependsOn, other.DependsOn)
+}
+
+// mergeDependencies will add the dependencies of other to the job, returning
+// true if any were new
+func (j *JobEntry) mergeDependencies(other *JobEntry) bool {
+	changed := false
+	for _, dep := range other.DependsOn {
+		known := false
+		for _, have := range j.DependsOn {
+			if have == dep {
+				known = true
+				break
+			}
+		}
+		if !known {
+			j.DependsOn = append(j.DependsOn, dep)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// sameStrings will determine if both slices hold the same strings in order
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// coalesceJob is called before j is added to the queue, and will decide
+// whether it can be merged into a pending job which already does the same
+// work. If so, that job is returned as into, and j isn't queued. Otherwise
+// j is to be queued, after replaced is taken out of the queue if set.
+//
+// Order doesn't matter within the async queue, so any pending duplicate
+// will do. Sequential jobs may depend on the jobs queued before them, so
+// they're only merged into a duplicate at the very end of the queue. The
+// exception is IndexRepo, which only has to run after everything queued
+// before it: a pending index of the same repo is taken out of the queue,
+// and j takes over its ID so that anyone following it can carry on. Jobs
+// which others explicitly depend on are left alone, as j may well depend on
+// those in turn.
+//
+// Neither queued job is modified, as nothing has been journaled yet.
+func coalesceJob(queue []*JobEntry, j *JobEntry, sequential bool) (into, replaced *JobEntry) {
+	var last, match *JobEntry
+	depended := make(map[string]bool)
+
+	for _, entry := range queue {
+		last = entry
+		for _, dep := range entry.DependsOn {
+			depended[dep] = true
+		}
+		if !entry.Claimed && match == nil && entry.sameWork(j) {
+			match = entry
+			if !sequential {
+				break
+			}
+		}
+	}
+	if match == nil || (sequential && depended[match.CorrelationID]) {
+		return nil, nil
+	}
+
+	if !sequential || match == last {
+		j.Logger().WithField("mergedInto", match.CorrelationID).Info("Merged job into pending duplicate")
+		return match, nil
+	}
+	if j.Type != IndexRepo {
+		return nil, nil
+	}
+
+	j.Logger().WithField("replacing", match.CorrelationID).Info("Moved pending index to the end of the queue")
+	j.CorrelationID = match.CorrelationID
+	j.Timing.Queued = match.Timing.Queued
+	j.mergeDependencies(match)
+	return nil, match
+}
@@ -25,6 +25,8 @@ import (
 
 // CopySourceJobHandler is responsible for removing packages by identifiers
 type CopySourceJobHandler struct {
+	logger  *log.Entry // Scoped to the job being executed
+	stats   *JobStats  // Record the source that was copied
 	repoID  string
 	target  string
 	source  string
@@ -50,6 +52,8 @@ func NewCopySourceJobHandler(j *JobEntry) (*CopySourceJobHandler, error) {
 		return nil, err
 	}
 	return &CopySourceJobHandler{
+		logger:  j.Logger(),
+		stats:   j.Stats(),
 		repoID:  j.Params[0],
 		target:  j.Params[1],
 		source:  j.Params[2],
@@ -62,7 +66,8 @@ func (j *CopySourceJobHandler) Execute(_ *Processor, manager *core.Manager) erro
 	if err := manager.CopySource(j.repoID, j.target, j.source, j.release); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
+	j.stats.AddSource(j.source)
+	j.logger.WithFields(log.Fields{
 		"from":          j.repoID,
 		"to":            j.target,
 		"source":        j.source,
@@ -71,6 +76,11 @@ func (j *CopySourceJobHandler) Execute(_ *Processor, manager *core.Manager) erro
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *CopySourceJobHandler) MutatedRepos() []string {
+	return []string{j.target}
+}
+
 // Describe returns a human readable description for this job
 func (j *CopySourceJobHandler) Describe() string {
 	return fmt.Sprintf("Copy sources by id '%s' (rel: %d) in '%s' to '%s'", j.source, j.release, j.repoID, j.target)
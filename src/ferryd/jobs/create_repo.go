@@ -25,6 +25,7 @@ import (
 // CreateRepoJobHandler is responsible for creating new repositories and should only
 // ever be used in sequential queues.
 type CreateRepoJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
 	repoID string
 }
 
@@ -43,6 +44,7 @@ func NewCreateRepoJobHandler(j *JobEntry) (*CreateRepoJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &CreateRepoJobHandler{
+		logger: j.Logger(),
 		repoID: j.Params[0],
 	}, nil
 }
@@ -52,10 +54,15 @@ func (j *CreateRepoJobHandler) Execute(_ *Processor, manager *core.Manager) erro
 	if err := manager.CreateRepo(j.repoID); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Created repository")
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Created repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *CreateRepoJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *CreateRepoJobHandler) Describe() string {
 	return fmt.Sprintf("Create repository '%s'", j.repoID)
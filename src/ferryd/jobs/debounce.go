@@ -0,0 +1,164 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// DefaultIndexDelay is how long a repository must go without changes
+// before an index requested with ScheduleIndex runs
+const DefaultIndexDelay = 10 * time.Second
+
+// pendingIndex is an index waiting for its repository to go quiet
+type pendingIndex struct {
+	timer    *time.Timer
+	watching []string // Jobs still able to change the repository
+}
+
+// An indexDebouncer collapses the indexes requested during a burst of
+// imports into one. Each request, and each job it was asked to watch
+// retiring, pushes the index back by the delay, so that it only runs once
+// nothing has changed the repository for that long.
+type indexDebouncer struct {
+	jproc    *Processor
+	delay    time.Duration
+	pending  map[string]*pendingIndex // Keyed by repository
+	watching map[string]string        // Repository of each watched job
+	mut      *sync.Mutex
+}
+
+// newIndexDebouncer will return an indexDebouncer pushing its indexes to
+// jproc. Until a delay is set, indexes are pushed straight away.
+func newIndexDebouncer(jproc *Processor) *indexDebouncer {
+	return &indexDebouncer{
+		jproc:    jproc,
+		pending:  make(map[string]*pendingIndex),
+		watching: make(map[string]string),
+		mut:      &sync.Mutex{},
+	}
+}
+
+// setDelay will change how long a repository must be quiet before it is
+// indexed. Indexes already waiting keep their current deadline.
+func (d *indexDebouncer) setDelay(delay time.Duration) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	d.delay = delay
+}
+
+// schedule will index the repository once the delay has passed since this,
+// or any later, change to it. The index is also held back until the delay
+// has passed since each of the watched jobs retired.
+func (d *indexDebouncer) schedule(repoID string, watch []string) error {
+	d.mut.Lock()
+	if d.delay <= 0 {
+		d.mut.Unlock()
+		return d.push(repoID, watch)
+	}
+	defer d.mut.Unlock()
+
+	index, ok := d.pending[repoID]
+	if !ok {
+		index = &pendingIndex{}
+		index.timer = time.AfterFunc(d.delay, func() { d.fire(repoID, index) })
+		d.pending[repoID] = index
+	} else {
+		index.timer.Reset(d.delay)
+	}
+	for _, id := range watch {
+		d.watching[id] = repoID
+		index.watching = append(index.watching, id)
+	}
+	return nil
+}
+
+// retired is told about every job leaving the queues, so that the index of
+// a repository it may have changed is pushed back
+func (d *indexDebouncer) retired(id string) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	repoID, ok := d.watching[id]
+	if !ok {
+		return
+	}
+	delete(d.watching, id)
+	index, ok := d.pending[repoID]
+	if !ok {
+		return
+	}
+	for i, watched := range index.watching {
+		if watched == id {
+			index.watching = append(index.watching[:i], index.watching[i+1:]...)
+			break
+		}
+	}
+	index.timer.Reset(d.delay)
+}
+
+// fire will push the index once the repository has gone quiet. Should any
+// watched job still be running the index goes ahead regardless, publishing
+// what is ready, and stays pending until those jobs retire and push it back
+// once more.
+func (d *indexDebouncer) fire(repoID string, index *pendingIndex) {
+	d.mut.Lock()
+	if d.pending[repoID] != index {
+		d.mut.Unlock()
+		return
+	}
+	if len(index.watching) == 0 {
+		delete(d.pending, repoID)
+	}
+	d.mut.Unlock()
+
+	if err := d.push(repoID, nil); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  repoID,
+			"error": err,
+		}).Error("Failed to push debounced index")
+	}
+}
+
+// push will queue the index now, after the given jobs
+func (d *indexDebouncer) push(repoID string, dependsOn []string) error {
+	index := NewIndexRepoJob(repoID)
+	index.DependsOn = dependsOn
+	return d.jproc.PushJob(index)
+}
+
+// flush will push every waiting index now, each depending on the jobs it
+// was still watching, so that they're journaled before we shut down
+func (d *indexDebouncer) flush() {
+	d.mut.Lock()
+	pending := d.pending
+	d.pending = make(map[string]*pendingIndex)
+	d.watching = make(map[string]string)
+	d.mut.Unlock()
+
+	for repoID, index := range pending {
+		index.timer.Stop()
+		if err := d.push(repoID, index.watching); err != nil {
+			log.WithFields(log.Fields{
+				"repo":  repoID,
+				"error": err,
+			}).Error("Failed to push debounced index")
+		}
+	}
+}
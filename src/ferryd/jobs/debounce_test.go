@@ -0,0 +1,85 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScheduleIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debounce")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("Failed to open job store: %v", err)
+	}
+	defer store.Close()
+
+	// Never begun, so whatever is pushed stays queued
+	jproc := NewProcessor(nil, store, 1)
+	queued := func() []string {
+		active, err := store.ActiveJobs()
+		if err != nil {
+			t.Fatalf("Failed to list jobs: %v", err)
+		}
+		var ret []string
+		for _, job := range active {
+			if job.Type == string(IndexRepo) {
+				ret = append(ret, job.Repo)
+			}
+		}
+		return ret
+	}
+
+	// Without a delay the index is pushed straight away
+	if err := jproc.ScheduleIndex("stable", "0123456789abcdef"); err != nil {
+		t.Fatalf("Failed to schedule index: %v", err)
+	}
+	if repos := queued(); len(repos) != 1 || repos[0] != "stable" {
+		t.Fatalf("Index wasn't pushed straight away: %v", repos)
+	}
+
+	jproc.SetIndexDelay(100 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		if err := jproc.ScheduleIndex("unstable"); err != nil {
+			t.Fatalf("Failed to schedule index: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if repos := queued(); len(repos) != 1 {
+		t.Fatalf("Index was pushed before the repository went quiet: %v", repos)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if repos := queued(); len(repos) != 2 || repos[1] != "unstable" {
+		t.Fatalf("Expected one debounced index, got: %v", repos)
+	}
+
+	// Waiting indexes are journaled on close
+	if err := jproc.ScheduleIndex("testing", "fedcba9876543210"); err != nil {
+		t.Fatalf("Failed to schedule index: %v", err)
+	}
+	jproc.Close()
+	if repos := queued(); len(repos) != 3 || repos[2] != "testing" {
+		t.Fatalf("Waiting index wasn't flushed: %v", repos)
+	}
+}
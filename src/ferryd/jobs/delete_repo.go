@@ -25,6 +25,8 @@ import (
 // DeleteRepoJobHandler is responsible for creating new repositories and should only
 // ever be used in sequential queues.
 type DeleteRepoJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	jobID  string     // Names the undo snapshot
 	repoID string
 }
 
@@ -43,19 +45,29 @@ func NewDeleteRepoJobHandler(j *JobEntry) (*DeleteRepoJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &DeleteRepoJobHandler{
+		logger: j.Logger(),
+		jobID:  j.CorrelationID,
 		repoID: j.Params[0],
 	}, nil
 }
 
 // Execute will delete an existing repository
 func (j *DeleteRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.CreateUndoSnapshot(j.repoID, j.jobID, j.Describe()); err != nil {
+		return err
+	}
 	if err := manager.DeleteRepo(j.repoID); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Deleted repository")
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Deleted repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *DeleteRepoJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *DeleteRepoJobHandler) Describe() string {
 	return fmt.Sprintf("Delete repository '%s'", j.repoID)
@@ -20,8 +20,8 @@ import (
 	"ferryd/core"
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"libdb"
 	"libeopkg"
-	"os"
 	"sort"
 )
 
@@ -29,18 +29,27 @@ import (
 // ever be used in async queues. Deltas may take some time to produce and
 // shouldn't be allowed to block the sequential processing queue.
 type DeltaJobHandler struct {
+	logger      *log.Entry // Scoped to the job being executed
+	jobID       string     // Parent of the delta production jobs
 	repoID      string
 	packageName string
+	historyID   string // Optional repository holding older releases
 	indexRepo   bool
-	nDeltas     int // Track how many deltas we actually produce
+	children    []*JobEntry // Delta production jobs to be pushed
 }
 
-// NewDeltaJob will return a job suitable for adding to the job processor
-func NewDeltaJob(repoID, packageID string) *JobEntry {
+// NewDeltaJob will return a job suitable for adding to the job processor.
+// If historyID is set, older releases in that repository will also be
+// considered as the source of a delta.
+func NewDeltaJob(repoID, packageID, historyID string) *JobEntry {
+	params := []string{repoID, packageID}
+	if historyID != "" {
+		params = append(params, historyID)
+	}
 	return &JobEntry{
 		sequential: false,
 		Type:       Delta,
-		Params:     []string{repoID, packageID},
+		Params:     params,
 	}
 }
 
@@ -56,40 +65,72 @@ func NewDeltaIndexJob(repoID, packageID string) *JobEntry {
 
 // NewDeltaJobHandler will create a job handler for the input job and ensure it validates
 func NewDeltaJobHandler(j *JobEntry, indexRepo bool) (*DeltaJobHandler, error) {
-	if len(j.Params) != 2 {
+	if len(j.Params) != 2 && len(j.Params) != 3 {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
-	return &DeltaJobHandler{
+	handler := &DeltaJobHandler{
+		logger:      j.Logger(),
+		jobID:       j.CorrelationID,
 		repoID:      j.Params[0],
 		packageName: j.Params[1],
 		indexRepo:   indexRepo,
-		nDeltas:     0,
-	}, nil
+	}
+	if len(j.Params) == 3 {
+		handler.historyID = j.Params[2]
+	}
+	return handler, nil
 }
 
-// executeInternal is the common code shared in the delta jobs, and is
-// split out to save duplication.
-func (j *DeltaJobHandler) executeInternal(manager *core.Manager) error {
-	pkgs, err := manager.GetPackages(j.repoID, j.packageName)
+// executeInternal works out which deltas are needed for the package, and
+// prepares a DeltaPair job to produce each of them.
+func (j *DeltaJobHandler) executeInternal(jproc *Processor, manager *core.Manager) error {
+	repo, err := manager.GetRepo(j.repoID)
 	if err != nil {
 		return err
 	}
-
-	// Need at least 2 packages for a delta op.
-	if len(pkgs) < 2 {
-		log.WithFields(log.Fields{
+	if repo.DeltaPolicy.Disabled {
+		j.logger.WithFields(log.Fields{
 			"repo":    j.repoID,
 			"package": j.packageName,
-		}).Debug("No delta is possible")
+		}).Debug("Deltas disabled for repository")
+		return nil
+	}
+
+	pkgs, err := manager.GetPackages(j.repoID, j.packageName)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to delta to
+	if len(pkgs) < 1 {
 		return nil
 	}
 
 	sort.Sort(libeopkg.PackageSet(pkgs))
 	tip := pkgs[len(pkgs)-1]
+	candidates := pkgs[:len(pkgs)-1]
+
+	if j.historyID != "" {
+		older, err := j.historyCandidates(manager, pkgs, tip)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, older...)
+	}
+
+	candidates = repo.DeltaPolicy.SelectCandidates(tip, candidates)
+
+	// Need at least one older release for a delta op.
+	if len(candidates) < 1 {
+		j.logger.WithFields(log.Fields{
+			"repo":    j.repoID,
+			"package": j.packageName,
+		}).Debug("No delta is possible")
+		return nil
+	}
 
 	// Process all potential deltas
-	for i := 0; i < len(pkgs)-1; i++ {
-		old := pkgs[i]
+	for _, old := range candidates {
 		fields := log.Fields{
 			"old":  old.GetID(),
 			"new":  tip.GetID(),
@@ -113,107 +154,95 @@ func (j *DeltaJobHandler) executeInternal(manager *core.Manager) error {
 			continue
 		}
 
-		mapping := &core.DeltaInformation{
-			FromID:      old.GetID(),
-			ToID:        tip.GetID(),
-			FromRelease: old.GetRelease(),
-			ToRelease:   tip.GetRelease(),
-		}
-
 		// Before we go off creating it - does the delta package exist already?
 		// If so, just re-ref it for usage within the new repo
 		entry, err := manager.GetPoolEntry(deltaID)
 		if entry != nil && err == nil {
 			if err := manager.RefDelta(j.repoID, deltaID); err != nil {
 				fields["error"] = err
-				log.WithFields(fields).Error("Failed to ref existing delta")
+				j.logger.WithFields(fields).Error("Failed to ref existing delta")
 				return err
 			}
-			log.WithFields(fields).Info("Reused existing delta")
+			j.logger.WithFields(fields).Info("Reused existing delta")
 			continue
 		}
 
-		deltaPath, err := manager.CreateDelta(j.repoID, old, tip)
-		if err != nil {
-			fields["error"] = err
-			if err == libeopkg.ErrDeltaPointless {
-				// Non-fatal, ask the manager to record this delta as a no-go
-				log.WithFields(fields).Info("Delta not possible, marked permanently")
-				if err := manager.MarkDeltaFailed(deltaID, mapping); err != nil {
-					fields["error"] = err
-					log.WithFields(fields).Error("Failed to mark delta failure")
-					return err
-				}
-				continue
-			} else if err == libeopkg.ErrMismatchedDelta {
-				log.WithFields(fields).Error("Package delta candidates do not match")
-				continue
-			} else {
-				// Genuinely an issue now
-				log.WithFields(fields).Error("Error in delta production")
-				return err
-			}
-		}
-
-		j.nDeltas++
-
-		fields["path"] = deltaPath
-		// Produced a delta!
-		log.WithFields(fields).Info("Successfully producing delta package")
-
-		// Let's get it included now.
-		if err = j.includeDelta(manager, mapping, deltaPath); err != nil {
-			fields["error"] = err
-			log.WithFields(fields).Error("Failed to include delta package")
-			return err
-		}
+		j.children = append(j.children, NewDeltaPairJob(j.jobID, j.repoID, j.packageName, old.GetID(), tip.GetID()))
 	}
 
 	return nil
 }
 
-// includeDelta will wrap up the basic functionality to get a delta package
-// imported into a target repository.
-func (j *DeltaJobHandler) includeDelta(manager *core.Manager, mapping *core.DeltaInformation, deltaPath string) error {
-	// Try to insert the delta
-	if err := manager.AddDelta(j.repoID, deltaPath, mapping); err != nil {
-		return err
+// historyCandidates returns the releases in the history repository which
+// are older than the tip and not already present in our own repository
+func (j *DeltaJobHandler) historyCandidates(manager *core.Manager, pkgs []*libeopkg.MetaPackage, tip *libeopkg.MetaPackage) ([]*libeopkg.MetaPackage, error) {
+	if _, err := manager.GetRepo(j.historyID); err != nil {
+		return nil, err
 	}
 
-	// Delete the deltaPath if the add is successful
-	return os.Remove(deltaPath)
-}
-
-// Execute will delta the target package within the target repository.
-func (j *DeltaJobHandler) Execute(_ *Processor, manager *core.Manager) error {
-	err := j.executeInternal(manager)
+	// The package may simply not exist in the history repository
+	history, err := manager.GetPackages(j.historyID, j.packageName)
+	if err == libdb.ErrNotFound {
+		return nil, nil
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if !j.indexRepo {
-		return nil
+
+	known := make(map[string]bool)
+	for _, p := range pkgs {
+		known[p.GetID()] = true
 	}
-	// Ask that our repository now be reindexed because we've added deltas but
-	// only if we've successfully produced some delta packages
-	if j.nDeltas < 1 {
-		return nil
+
+	var ret []*libeopkg.MetaPackage
+	for _, p := range history {
+		if known[p.GetID()] || p.GetRelease() >= tip.GetRelease() {
+			continue
+		}
+		ret = append(ret, p)
 	}
+	return ret, nil
+}
 
-	if err := manager.Index(j.repoID); err != nil {
-		log.WithFields(log.Fields{
-			"repo":  j.repoID,
-			"error": err,
-		}).Error("Failed to index repository")
+// Execute will delta the target package within the target repository. If
+// asked to, the repository is indexed once every delta has been produced.
+func (j *DeltaJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+	if err := j.executeInternal(jproc, manager); err != nil {
 		return err
 	}
 
+	// The index is scheduled first, so that it can't miss a delta retiring.
+	// Until this job is retired the index can't run, so it also waits for
+	// every delta to be queued.
+	if j.indexRepo && len(j.children) > 0 {
+		watch := []string{j.jobID}
+		for _, child := range j.children {
+			child.CorrelationID = newCorrelationID()
+			watch = append(watch, child.CorrelationID)
+		}
+		if err := jproc.ScheduleIndex(j.repoID, watch...); err != nil {
+			return err
+		}
+	}
+
+	// Production is expensive, so every delta gets its own job to spread a
+	// package with many releases across all workers
+	for _, child := range j.children {
+		if err := jproc.PushJob(child); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Describe returns a human readable description for this job
 func (j *DeltaJobHandler) Describe() string {
+	desc := fmt.Sprintf("Delta package '%s' on '%s'", j.packageName, j.repoID)
+	if j.historyID != "" {
+		desc += fmt.Sprintf(" with history from '%s'", j.historyID)
+	}
 	if j.indexRepo {
-		return fmt.Sprintf("Delta package '%s' on '%s', then re-index", j.packageName, j.repoID)
+		desc += ", then re-index"
 	}
-	return fmt.Sprintf("Delta package '%s' on '%s'", j.packageName, j.repoID)
+	return desc
 }
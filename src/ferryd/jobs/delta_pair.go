@@ -0,0 +1,185 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libeopkg"
+	"os"
+)
+
+// DeltaPairJobHandler is responsible for producing a single delta package,
+// and is always the child of a Delta job. It should only ever be used in
+// async queues.
+type DeltaPairJobHandler struct {
+	logger      *log.Entry        // Scoped to the job being executed
+	progress    *ProgressReporter // Report how far along the delta is
+	stats       *JobStats         // Record the delta once it's included
+	cancel      <-chan struct{}   // Closed if we run past our timeout
+	repoID      string
+	packageName string
+	oldID       string
+	newID       string
+}
+
+// NewDeltaPairJob will return a job to produce the delta between the two
+// given package IDs, as a child of the given parent job.
+func NewDeltaPairJob(parentID, repoID, packageName, oldID, newID string) *JobEntry {
+	return &JobEntry{
+		sequential: false,
+		Type:       DeltaPair,
+		Params:     []string{repoID, packageName, oldID, newID},
+		ParentID:   parentID,
+	}
+}
+
+// NewDeltaPairJobHandler will create a job handler for the input job and ensure it validates.
+// Jobs queued by older versions may carry a fifth parameter, which is ignored
+// as the index now depends on the job instead.
+func NewDeltaPairJobHandler(j *JobEntry) (*DeltaPairJobHandler, error) {
+	if len(j.Params) != 4 && len(j.Params) != 5 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &DeltaPairJobHandler{
+		logger:      j.Logger(),
+		progress:    j.Progress(),
+		stats:       j.Stats(),
+		cancel:      j.Done(),
+		repoID:      j.Params[0],
+		packageName: j.Params[1],
+		oldID:       j.Params[2],
+		newID:       j.Params[3],
+	}, nil
+}
+
+// Execute will produce the delta package and include it in the repository
+func (j *DeltaPairJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	fields := log.Fields{
+		"old":  j.oldID,
+		"new":  j.newID,
+		"repo": j.repoID,
+	}
+
+	old, err := manager.GetPoolEntry(j.oldID)
+	if err != nil {
+		return err
+	}
+	tip, err := manager.GetPoolEntry(j.newID)
+	if err != nil {
+		return err
+	}
+
+	deltaID := libeopkg.ComputeDeltaName(old, tip)
+
+	// A sibling in another repository may have beaten us to it
+	if manager.GetDeltaFailed(deltaID) {
+		return nil
+	}
+	hasDelta, err := manager.HasDelta(j.repoID, j.packageName, deltaID)
+	if err != nil {
+		return err
+	}
+	if hasDelta {
+		return nil
+	}
+	if entry, err := manager.GetPoolEntry(deltaID); entry != nil && err == nil {
+		return manager.RefDelta(j.repoID, deltaID)
+	}
+
+	mapping := &core.DeltaInformation{
+		FromID:      old.GetID(),
+		ToID:        tip.GetID(),
+		FromRelease: old.GetRelease(),
+		ToRelease:   tip.GetRelease(),
+	}
+
+	deltaPath, err := manager.CreateDelta(j.repoID, old, tip, func(p libeopkg.DeltaProgress) {
+		j.progress.Update(deltaID, p.Stage, p.Bytes, p.TotalBytes)
+	}, j.cancel)
+	if err != nil {
+		fields["error"] = err
+		if err == libeopkg.ErrDeltaPointless {
+			// Non-fatal, ask the manager to record this delta as a no-go
+			j.logger.WithFields(fields).Info("Delta not possible, marked permanently")
+			if err := manager.MarkDeltaPointless(deltaID, mapping); err != nil {
+				fields["error"] = err
+				j.logger.WithFields(fields).Error("Failed to mark delta as pointless")
+				return err
+			}
+			return nil
+		} else if err == libeopkg.ErrMismatchedDelta {
+			j.logger.WithFields(fields).Error("Package delta candidates do not match")
+			return nil
+		} else if err == libeopkg.ErrDeltaCancelled {
+			return err
+		}
+		// Genuinely an issue now, so back off from it for a while
+		j.logger.WithFields(fields).Error("Error in delta production")
+		if err := manager.MarkDeltaFailed(deltaID, mapping); err != nil {
+			fields["error"] = err
+			j.logger.WithFields(fields).Error("Failed to mark delta failure")
+		}
+		return err
+	}
+
+	fields["path"] = deltaPath
+	// Produced a delta!
+	j.logger.WithFields(fields).Info("Successfully producing delta package")
+
+	// Let's get it included now.
+	if err = j.includeDelta(manager, mapping, deltaPath); err != nil {
+		fields["error"] = err
+		j.logger.WithFields(fields).Error("Failed to include delta package")
+		if _, ok := err.(*core.DeltaVerifyError); ok {
+			// Broken, so never let it near the index and back off from it
+			os.Remove(deltaPath)
+			if err := manager.MarkDeltaFailed(deltaID, mapping); err != nil {
+				fields["error"] = err
+				j.logger.WithFields(fields).Error("Failed to mark delta failure")
+			}
+		}
+		return err
+	}
+	j.stats.addPoolPackages(manager, []string{deltaID})
+
+	// Record how worthwhile it was, to help tune the delta policy
+	if delta, err := manager.GetPoolEntry(deltaID); err == nil && tip.PackageSize > 0 {
+		fields["size"] = delta.PackageSize
+		fields["ratio"] = fmt.Sprintf("%.2f", float64(delta.PackageSize)/float64(tip.PackageSize))
+		j.logger.WithFields(fields).Info("Included delta package")
+	}
+	return nil
+}
+
+// includeDelta will wrap up the basic functionality to get a delta package
+// imported into a target repository.
+func (j *DeltaPairJobHandler) includeDelta(manager *core.Manager, mapping *core.DeltaInformation, deltaPath string) error {
+	// Try to insert the delta
+	if err := manager.AddDelta(j.repoID, deltaPath, mapping); err != nil {
+		return err
+	}
+
+	// Delete the deltaPath if the add is successful
+	return os.Remove(deltaPath)
+}
+
+// Describe returns a human readable description for this job
+func (j *DeltaPairJobHandler) Describe() string {
+	return fmt.Sprintf("Delta '%s' to '%s' on '%s'", j.oldID, j.newID, j.repoID)
+}
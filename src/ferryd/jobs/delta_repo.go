@@ -25,26 +25,39 @@ import (
 // DeltaRepoJobHandler is responsible for delta'ing repositories and should only
 // ever be used in sequential queues.
 type DeltaRepoJobHandler struct {
-	repoID string
+	logger    *log.Entry // Scoped to the job being executed
+	repoID    string
+	historyID string // Optional repository holding older releases
 }
 
-// NewDeltaRepoJob will return a job suitable for adding to the job processor
-func NewDeltaRepoJob(id string) *JobEntry {
+// NewDeltaRepoJob will return a job suitable for adding to the job processor.
+// If historyID is set, older releases in that repository will also be used
+// to produce deltas, i.e. when the repository has been pulled from.
+func NewDeltaRepoJob(id, historyID string) *JobEntry {
+	params := []string{id}
+	if historyID != "" {
+		params = append(params, historyID)
+	}
 	return &JobEntry{
 		sequential: true,
 		Type:       DeltaRepo,
-		Params:     []string{id},
+		Params:     params,
 	}
 }
 
 // NewDeltaRepoJobHandler will create a job handler for the input job and ensure it validates
 func NewDeltaRepoJobHandler(j *JobEntry) (*DeltaRepoJobHandler, error) {
-	if len(j.Params) != 1 {
+	if len(j.Params) != 1 && len(j.Params) != 2 {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
-	return &DeltaRepoJobHandler{
+	handler := &DeltaRepoJobHandler{
+		logger: j.Logger(),
 		repoID: j.Params[0],
-	}, nil
+	}
+	if len(j.Params) == 2 {
+		handler.historyID = j.Params[1]
+	}
+	return handler, nil
 }
 
 // Execute will delta the given repository if possible
@@ -60,9 +73,15 @@ func (j *DeltaRepoJobHandler) Execute(jproc *Processor, manager *core.Manager) e
 		return err
 	}
 
+	if j.historyID != "" {
+		if _, err := manager.GetRepo(j.historyID); err != nil {
+			return err
+		}
+	}
+
 	// Skip an empty repository
 	if len(packageNames) < 1 {
-		log.WithFields(log.Fields{
+		j.logger.WithFields(log.Fields{
 			"repo": j.repoID,
 		}).Warning("Requested delta for empty repository")
 		return nil
@@ -70,7 +89,7 @@ func (j *DeltaRepoJobHandler) Execute(jproc *Processor, manager *core.Manager) e
 
 	// Fire off parallel delta jobs for every package in this repository
 	for _, name := range packageNames {
-		jproc.PushJob(NewDeltaJob(j.repoID, name))
+		jproc.PushJob(NewDeltaJob(j.repoID, name, j.historyID))
 	}
 
 	return nil
@@ -78,5 +97,8 @@ func (j *DeltaRepoJobHandler) Execute(jproc *Processor, manager *core.Manager) e
 
 // Describe returns a human readable description for this job
 func (j *DeltaRepoJobHandler) Describe() string {
+	if j.historyID != "" {
+		return fmt.Sprintf("Produce deltas for '%s' with history from '%s'", j.repoID, j.historyID)
+	}
 	return fmt.Sprintf("Produce deltas for '%s'", j.repoID)
 }
@@ -0,0 +1,153 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ImportDirectoryJobHandler is responsible for importing every package found
+// within a directory, and should only ever be used in sequential queues.
+type ImportDirectoryJobHandler struct {
+	logger    *log.Entry        // Scoped to the job being executed
+	progress  *ProgressReporter // Report how many packages were added
+	stats     *JobStats         // Record which packages were added
+	jobID     string            // Recorded in the provenance of the packages
+	repoID    string
+	path      string
+	recursive bool
+}
+
+// NewImportDirectoryJob will return a job suitable for adding to the job processor
+func NewImportDirectoryJob(id, path string, recursive bool) *JobEntry {
+	params := []string{id, path}
+	if recursive {
+		params = append(params, "recursive")
+	}
+	return &JobEntry{
+		sequential: true,
+		Type:       ImportDirectory,
+		Params:     params,
+	}
+}
+
+// NewImportDirectoryJobHandler will create a job handler for the input job and ensure it validates
+func NewImportDirectoryJobHandler(j *JobEntry) (*ImportDirectoryJobHandler, error) {
+	if len(j.Params) < 2 || len(j.Params) > 3 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &ImportDirectoryJobHandler{
+		logger:    j.Logger(),
+		progress:  j.Progress(),
+		stats:     j.Stats(),
+		jobID:     j.CorrelationID,
+		repoID:    j.Params[0],
+		path:      j.Params[1],
+		recursive: len(j.Params) == 3,
+	}, nil
+}
+
+// isImportable will determine if the file is a package that can be imported.
+// Deltas are skipped, as they're produced by ferryd itself.
+func isImportable(name string) bool {
+	return strings.HasSuffix(name, ".eopkg") && !strings.HasSuffix(name, ".delta.eopkg")
+}
+
+// findPackages will return the path of every importable package within the
+// directory, in lexical order
+func (j *ImportDirectoryJobHandler) findPackages() ([]string, error) {
+	var paths []string
+
+	if !j.recursive {
+		files, err := ioutil.ReadDir(j.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			if fi.Mode().IsRegular() && isImportable(fi.Name()) {
+				paths = append(paths, filepath.Join(j.path, fi.Name()))
+			}
+		}
+		return paths, nil
+	}
+
+	err := filepath.Walk(j.path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && isImportable(fi.Name()) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Execute will find the packages and add them through the bulk import
+func (j *ImportDirectoryJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	paths, err := j.findPackages()
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no packages found in %s", j.path)
+	}
+
+	j.logger.WithFields(log.Fields{
+		"path":      j.path,
+		"npackages": len(paths),
+	}).Info("Importing packages from directory")
+
+	prov := &core.Provenance{
+		Uploaded: time.Now().UTC(),
+		JobID:    j.jobID,
+	}
+	err = manager.BulkAddPackages(j.repoID, paths, prov, func(done, total int) {
+		j.progress.Update(j.repoID, "Adding packages", int64(done), int64(total))
+	})
+	if err != nil {
+		return err
+	}
+	j.stats.addPoolPackages(manager, paths)
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Added packages to repository")
+	return nil
+}
+
+// MutatedRepos returns the repository modified by this job
+func (j *ImportDirectoryJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
+// Describe returns a human readable description for this job
+func (j *ImportDirectoryJobHandler) Describe() string {
+	if j.recursive {
+		return fmt.Sprintf("Add packages under '%s' to repository '%s'", j.path, j.repoID)
+	}
+	return fmt.Sprintf("Add packages in '%s' to repository '%s'", j.path, j.repoID)
+}
@@ -0,0 +1,83 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// ImportRepoJobHandler is responsible for creating a repository from an
+// uploaded archive, and should only ever be used in sequential queues.
+type ImportRepoJobHandler struct {
+	logger      *log.Entry // Scoped to the job being executed
+	stats       *JobStats  // Record how many packages were imported
+	repoID      string
+	archivePath string
+}
+
+// NewImportRepoJob will return a job suitable for adding to the job processor.
+// The archive is removed once the job has run.
+func NewImportRepoJob(id, archivePath string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       ImportRepo,
+		Params:     []string{id, archivePath},
+	}
+}
+
+// NewImportRepoJobHandler will create a job handler for the input job and ensure it validates
+func NewImportRepoJobHandler(j *JobEntry) (*ImportRepoJobHandler, error) {
+	if len(j.Params) != 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &ImportRepoJobHandler{
+		logger:      j.Logger(),
+		stats:       j.Stats(),
+		repoID:      j.Params[0],
+		archivePath: j.Params[1],
+	}, nil
+}
+
+// Execute will import the archive into the new repository, and then
+// remove the archive whether or not it could be imported
+func (j *ImportRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	defer os.Remove(j.archivePath)
+
+	manifest, err := manager.ImportRepo(j.archivePath, j.repoID)
+	if err != nil {
+		return err
+	}
+	var bytes int64
+	for _, entry := range manifest.Pool {
+		bytes += entry.Size
+	}
+	j.stats.AddPackages(len(manifest.Pool), bytes)
+	j.logger.WithFields(log.Fields{
+		"repo":     j.repoID,
+		"exported": manifest.Repo,
+		"files":    len(manifest.Pool),
+	}).Info("Imported repository archive")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *ImportRepoJobHandler) Describe() string {
+	return fmt.Sprintf("Import repository '%s' from an archive", j.repoID)
+}
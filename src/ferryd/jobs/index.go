@@ -25,6 +25,7 @@ import (
 // IndexRepoJobHandler is responsible for indexing repositories and should only
 // ever be used in sequential queues.
 type IndexRepoJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
 	repoID string
 }
 
@@ -43,6 +44,7 @@ func NewIndexRepoJobHandler(j *JobEntry) (*IndexRepoJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &IndexRepoJobHandler{
+		logger: j.Logger(),
 		repoID: j.Params[0],
 	}, nil
 }
@@ -52,7 +54,7 @@ func (j *IndexRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error
 	if err := manager.Index(j.repoID); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Indexed repository")
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Indexed repository")
 	return nil
 }
 
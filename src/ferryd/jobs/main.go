@@ -18,11 +18,16 @@ package jobs
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/hex"
 	"ferryd/core"
 	"fmt"
+	log "github.com/sirupsen/logrus"
 	"libferry"
+	"time"
 )
 
 // JobType is a numerical representation of a kind of job
@@ -33,6 +38,9 @@ const (
 	// BulkAdd is a sequential job which will attempt to add all of the packages
 	BulkAdd JobType = "BulkAdd"
 
+	// CleanTemp is a sequential job to remove leftover temporary files
+	CleanTemp = "CleanTemp"
+
 	// CopySource is a sequential job to copy from one repo to another
 	CopySource = "CopySource"
 
@@ -42,40 +50,92 @@ const (
 	// CreateRepo is a sequential job which will attempt to create a new repo
 	CreateRepo = "CreateRepo"
 
+	// CreateSnapshot is a sequential job which records the state of a repo
+	CreateSnapshot = "CreateSnapshot"
+
 	// DeleteRepo is a sequential job which will attempt to delete a repository
 	DeleteRepo = "DeleteRepo"
 
+	// DeleteSnapshot is a sequential job which removes a repo snapshot
+	DeleteSnapshot = "DeleteSnapshot"
+
 	// Delta is a parallel job which will attempt the construction of deltas for
 	// a given package name + repo
 	Delta = "Delta"
 
 	// DeltaIndex is created in response to transit manifest events, and will
-	// cause the repository to be reindexed after each delta job continues
+	// queue an index of the repository depending on every delta it produces
 	DeltaIndex = "Delta+Index"
 
+	// DeltaPair is a parallel job which will produce a single delta package
+	// on behalf of a Delta job
+	DeltaPair = "DeltaPair"
+
 	// DeltaRepo is a sequential job which creates Delta jobs for every package in
 	// a repo
 	DeltaRepo = "DeltaRepo"
 
+	// ImportDirectory is a sequential job which will add all of the packages
+	// found in a directory on the server
+	ImportDirectory = "ImportDirectory"
+
+	// ImportRepo is a sequential job which creates a repository from an
+	// exported archive
+	ImportRepo = "ImportRepo"
+
 	// IndexRepo is a sequential job that requests the repository be re-indexed
 	IndexRepo = "IndexRepo"
 
+	// MigratePool is a sequential job to move the pool to another layout
+	MigratePool = "MigratePool"
+
+	// PromoteSource is a sequential job to promote a source & release from
+	// one repo to another, subject to policy checks
+	PromoteSource = "PromoteSource"
+
 	// PullRepo is a sequential job that will attempt to pull a repo
 	PullRepo = "PullRepo"
 
+	// PullSource is a sequential job that will pull a single source from
+	// one repo into another
+	PullSource = "PullSource"
+
+	// RelinkRepo is a sequential job to replace files in a repo tree which
+	// no longer match the pool
+	RelinkRepo = "RelinkRepo"
+
 	// RemoveSource is a sequential job that will attempt removal of packages
 	RemoveSource = "RemoveSource"
 
+	// ReplicateRepo is a sequential job which brings a repo in line with the
+	// same repo on a primary ferryd
+	ReplicateRepo = "ReplicateRepo"
+
+	// RestoreSnapshot is a sequential job which rewinds a repo to a snapshot
+	RestoreSnapshot = "RestoreSnapshot"
+
+	// RewriteMetadata is a sequential job to patch the metadata of a stored
+	// package and republish it
+	RewriteMetadata = "RewriteMetadata"
+
 	// TransitProcess is a sequential job that will process the incoming uploads
 	// directory, dealing with each .tram upload
 	TransitProcess = "TransitProcess"
 
+	// TrimDeltas is a sequential job to remove deltas which no longer lead
+	// to the published release of a package
+	TrimDeltas = "TrimDeltas"
+
 	// TrimObsolete is a sequential job to permanently remove obsolete packages
 	// from a repo
 	TrimObsolete = "TrimObsolete"
 
 	// TrimPackages is a sequential job to trim fat from a repository
 	TrimPackages = "TrimPackages"
+
+	// ValidateIndex is a sequential job to check a published index against
+	// the files in the repository
+	ValidateIndex = "ValidateIndex"
 )
 
 // A JobHandler is created for each JobEntry, to provide specialised handling
@@ -89,6 +149,28 @@ type JobHandler interface {
 	Describe() string
 }
 
+// A RepoMutator is a JobHandler which modifies the packages within one or
+// more repositories. The changes it makes are recorded in the history of
+// each repository.
+type RepoMutator interface {
+	JobHandler
+
+	// MutatedRepos returns the repositories this job may modify
+	MutatedRepos() []string
+}
+
+// A Recoverer is a JobHandler which can pick up after an earlier attempt at
+// the job was interrupted by ferryd stopping, i.e. when it has partially
+// changed a repository and so can't simply run again.
+type Recoverer interface {
+	JobHandler
+
+	// Recover is called at startup, before the interrupted job would be
+	// queued again. It returns true if it finished the job itself, and so
+	// it needn't run again. An error fails the job.
+	Recover(proc *Processor, m *core.Manager) (bool, error)
+}
+
 // JobEntry is an entry in the JobQueue
 type JobEntry struct {
 	id         []byte // Unique ID for this job
@@ -98,11 +180,32 @@ type JobEntry struct {
 	Params     []string
 	Timing     libferry.TimingInformation // Store all timing information
 
+	// CorrelationID is assigned when the job is first pushed, and is attached
+	// to every log line emitted on behalf of the job
+	CorrelationID string
+
+	// ParentID is the CorrelationID of the job which pushed this one, if any
+	ParentID string
+
+	// DependsOn lists the CorrelationIDs of jobs which must succeed before
+	// this one is claimed. Should any of them fail, this job fails without
+	// running.
+	DependsOn []string
+
 	// Not serialised, set by the worker on claim
 	description string
+	repo        string
 
 	// Not serialised, stored by the worker if the job fails
 	failure error
+
+	// Not serialised, set by the worker on claim
+	progress *ProgressReporter
+	stats    *JobStats
+
+	// Not serialised, set by the worker on claim if the job has a timeout
+	ctx     context.Context
+	timeout time.Duration
 }
 
 // Serialize uses Gob encoding to convert a JobEntry to a byte slice
@@ -131,38 +234,192 @@ func (j *JobEntry) GetID() string {
 	return fmt.Sprintf("%v", binary.BigEndian.Uint64(j.id))
 }
 
+// newCorrelationID will return a new random identifier for a job. Unlike the
+// storage ID this is unique across both the sequential and async queues.
+func newCorrelationID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Family will return the CorrelationID shared by the job, its parent and
+// its siblings
+func (j *JobEntry) Family() string {
+	if j.ParentID != "" {
+		return j.ParentID
+	}
+	return j.CorrelationID
+}
+
+// waitingOn will determine if any job this one depends on is still pending
+func (j *JobEntry) waitingOn(pending map[string]*JobEntry) bool {
+	for _, dep := range j.DependsOn {
+		if pending[dep] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// jobRepo will return the repository the job acts on, if any. Jobs which
+// change more than one report the first, i.e. the target of a promotion.
+func jobRepo(j *JobEntry, handler JobHandler) string {
+	if mutator, ok := handler.(RepoMutator); ok {
+		if repos := mutator.MutatedRepos(); len(repos) > 0 {
+			return repos[0]
+		}
+		return ""
+	}
+	switch j.Type {
+	case CleanTemp, MigratePool, RewriteMetadata:
+		return ""
+	}
+	if len(j.Params) == 0 {
+		return ""
+	}
+	return j.Params[0]
+}
+
+// Logger will return a log entry with the job identifiers already attached,
+// so that all log lines for a job can be found again.
+func (j *JobEntry) Logger() *log.Entry {
+	fields := log.Fields{
+		"jobID":   j.CorrelationID,
+		"jobType": j.Type,
+	}
+	if j.ParentID != "" {
+		fields["parentID"] = j.ParentID
+	}
+	return log.WithFields(fields)
+}
+
+// Progress will return the reporter for the job's progress, which is nil
+// unless the job is being executed
+func (j *JobEntry) Progress() *ProgressReporter {
+	return j.progress
+}
+
+// Stats will return the collector for what the job processed, which is nil
+// unless the job is being executed
+func (j *JobEntry) Stats() *JobStats {
+	return j.stats
+}
+
+// Done will return a channel which is closed once the job has run past its
+// timeout, so that long running handlers can stop early. It is nil unless the
+// job is being executed with a timeout.
+func (j *JobEntry) Done() <-chan struct{} {
+	if j.ctx == nil {
+		return nil
+	}
+	return j.ctx.Done()
+}
+
+// Context will return a context which is done once the job has run past its
+// timeout, for handlers waiting on requests to finish
+func (j *JobEntry) Context() context.Context {
+	if j.ctx == nil {
+		return context.Background()
+	}
+	return j.ctx
+}
+
+// Cancelled will return an error if the job has run past its timeout
+func (j *JobEntry) Cancelled() error {
+	select {
+	case <-j.Done():
+		return fmt.Errorf("job exceeded its timeout of %v", j.timeout)
+	default:
+		return nil
+	}
+}
+
+// Completed will return the record of this job as it is stored once the job
+// has been retired
+func (j *JobEntry) Completed() *libferry.Job {
+	ret := &libferry.Job{
+		ID:          j.CorrelationID,
+		ParentID:    j.ParentID,
+		DependsOn:   j.DependsOn,
+		Timing:      j.Timing,
+		Description: j.description,
+		Type:        string(j.Type),
+		Repo:        j.repo,
+	}
+	ret.Sources, ret.PackageCount, ret.BytesProcessed = j.stats.get()
+
+	// Mark relevant failure fields
+	if j.failure != nil {
+		ret.Error = j.failure.Error()
+		ret.Failed = true
+	}
+	return ret
+}
+
 // NewJobHandler will return a handler that is loaded only during the execution
 // of a previously serialised job
 func NewJobHandler(j *JobEntry) (JobHandler, error) {
 	switch j.Type {
 	case BulkAdd:
 		return NewBulkAddJobHandler(j)
+	case CleanTemp:
+		return NewCleanTempJobHandler(j)
 	case CopySource:
 		return NewCopySourceJobHandler(j)
 	case CloneRepo:
 		return NewCloneRepoJobHandler(j)
 	case CreateRepo:
 		return NewCreateRepoJobHandler(j)
+	case CreateSnapshot:
+		return NewCreateSnapshotJobHandler(j)
 	case DeleteRepo:
 		return NewDeleteRepoJobHandler(j)
+	case DeleteSnapshot:
+		return NewDeleteSnapshotJobHandler(j)
 	case Delta:
 		return NewDeltaJobHandler(j, false)
+	case DeltaPair:
+		return NewDeltaPairJobHandler(j)
 	case DeltaRepo:
 		return NewDeltaRepoJobHandler(j)
 	case DeltaIndex:
 		return NewDeltaJobHandler(j, true)
+	case ImportDirectory:
+		return NewImportDirectoryJobHandler(j)
+	case ImportRepo:
+		return NewImportRepoJobHandler(j)
 	case IndexRepo:
 		return NewIndexRepoJobHandler(j)
+	case RelinkRepo:
+		return NewRelinkRepoJobHandler(j)
 	case RemoveSource:
 		return NewRemoveSourceJobHandler(j)
+	case ReplicateRepo:
+		return NewReplicateRepoJobHandler(j)
+	case MigratePool:
+		return NewMigratePoolJobHandler(j)
+	case PromoteSource:
+		return NewPromoteSourceJobHandler(j)
 	case PullRepo:
 		return NewPullRepoJobHandler(j)
+	case PullSource:
+		return NewPullSourceJobHandler(j)
+	case RestoreSnapshot:
+		return NewRestoreSnapshotJobHandler(j)
+	case RewriteMetadata:
+		return NewRewriteMetadataJobHandler(j)
 	case TransitProcess:
 		return NewTransitJobHandler(j)
+	case TrimDeltas:
+		return NewTrimDeltasJobHandler(j)
 	case TrimObsolete:
 		return NewTrimObsoleteJobHandler(j)
 	case TrimPackages:
 		return NewTrimPackagesJobHandler(j)
+	case ValidateIndex:
+		return NewValidateIndexJobHandler(j)
 	default:
 		return nil, fmt.Errorf("unknown job type '%s'", j.Type)
 	}
@@ -0,0 +1,88 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"testing"
+)
+
+func TestNewJobHandler(t *testing.T) {
+	summary := "A text editor"
+	jobs := []*JobEntry{
+		NewBulkAddJob("unstable", []string{"nano-2.8.7-63-1-x86_64.eopkg"}),
+		NewCleanTempJob(core.DefaultTempMaxAge),
+		NewCloneRepoJob("unstable", "stable", true),
+		NewCopySourceJob("unstable", "stable", "nano", 63),
+		NewCreateRepoJob("unstable"),
+		NewCreateSnapshotJob("unstable", "before-sync"),
+		NewDeleteRepoJob("unstable"),
+		NewDeleteSnapshotJob("unstable", "before-sync"),
+		NewDeltaJob("unstable", "nano", ""),
+		NewDeltaIndexJob("unstable", "nano"),
+		NewDeltaPairJob("0123456789abcdef", "unstable", "nano", "nano-2.8.6-62-1-x86_64.eopkg", "nano-2.8.7-63-1-x86_64.eopkg"),
+		NewDeltaRepoJob("unstable", ""),
+		NewImportDirectoryJob("unstable", "/srv/import", true),
+		NewImportRepoJob("unstable", "/var/lib/ferryd/imports/upload-1234"),
+		NewIndexRepoJob("unstable"),
+		NewMigratePoolJob(core.PoolLayoutContent),
+		NewPromoteSourceJob("unstable", "stable", "nano", 63, false),
+		NewPullRepoJob("unstable", "stable"),
+		NewPullSourceJob("unstable", "stable", "nano"),
+		NewRelinkRepoJob("unstable"),
+		NewRemoveSourceJob("unstable", "nano", 63),
+		NewReplicateRepoJob("unstable", "/run/ferryd-primary.sock"),
+		NewRestoreSnapshotJob("unstable", "before-sync"),
+		NewRewriteMetadataJob("nano-2.8.7-63-1-x86_64.eopkg", &core.MetadataPatch{Summary: &summary}),
+		NewTransitJob("/srv/incoming/nano-2.8.7-63.tram", ""),
+		NewTrimDeltasJob("unstable"),
+		NewTrimObsoleteJob("unstable"),
+		NewTrimPackagesJob("unstable", 3),
+		NewValidateIndexJob("unstable"),
+	}
+
+	for _, job := range jobs {
+		// Handlers are only ever created for jobs loaded from the store
+		serial, err := job.Serialize()
+		if err != nil {
+			t.Fatalf("Failed to serialise %s job: %v", job.Type, err)
+		}
+		stored, err := Deserialize(serial)
+		if err != nil {
+			t.Fatalf("Failed to deserialise %s job: %v", job.Type, err)
+		}
+		handler, err := NewJobHandler(stored)
+		if err != nil {
+			t.Fatalf("No handler for %s job: %v", job.Type, err)
+		}
+		if handler.Describe() == "" {
+			t.Fatalf("Handler for %s job has no description", job.Type)
+		}
+	}
+}
+
+func TestNewJobHandlerInvalid(t *testing.T) {
+	if _, err := NewJobHandler(&JobEntry{Type: "Frobnicate"}); err == nil {
+		t.Fatalf("Unknown job type should have no handler")
+	}
+	if _, err := NewJobHandler(&JobEntry{Type: DeleteRepo}); err == nil {
+		t.Fatalf("DeleteRepo job without a repository should not validate")
+	}
+	if _, err := NewJobHandler(&JobEntry{Type: MigratePool, Params: []string{"bogus"}}); err == nil {
+		t.Fatalf("MigratePool job with an unknown layout should not validate")
+	}
+}
@@ -0,0 +1,76 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// MigratePoolJobHandler is responsible for moving the pool to another layout,
+// and should only ever be used in sequential queues.
+type MigratePoolJobHandler struct {
+	logger   *log.Entry        // Scoped to the job being executed
+	progress *ProgressReporter // Report how many entries were migrated
+	layout   core.PoolLayout
+}
+
+// NewMigratePoolJob will return a job suitable for adding to the job processor
+func NewMigratePoolJob(layout core.PoolLayout) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       MigratePool,
+		Params:     []string{string(layout)},
+	}
+}
+
+// NewMigratePoolJobHandler will create a job handler for the input job and ensure it validates
+func NewMigratePoolJobHandler(j *JobEntry) (*MigratePoolJobHandler, error) {
+	if len(j.Params) != 1 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	layout := core.PoolLayout(j.Params[0])
+	if err := layout.Validate(); err != nil {
+		return nil, err
+	}
+	return &MigratePoolJobHandler{
+		logger:   j.Logger(),
+		progress: j.Progress(),
+		layout:   layout,
+	}, nil
+}
+
+// Execute will switch the pool layout and relocate every existing file
+func (j *MigratePoolJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	moved, err := manager.MigratePoolLayout(j.layout, func(done, total int) {
+		j.progress.Update("pool", "Migrating pool entries", int64(done), int64(total))
+	})
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"layout": j.layout,
+		"moved":  moved,
+	}).Info("Migrated pool layout")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *MigratePoolJobHandler) Describe() string {
+	return fmt.Sprintf("Migrate the pool to the %s layout", j.layout)
+}
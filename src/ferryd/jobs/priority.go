@@ -0,0 +1,206 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Each job belongs to a class, which may be given its own CPU and I/O
+// priority so that heavy jobs don't starve the ones users are waiting on
+const (
+	// ClassDelta is every job producing deltas, the heaviest of all
+	ClassDelta = "delta"
+
+	// ClassIndex is indexing, which users are usually waiting on
+	ClassIndex = "index"
+
+	// ClassDefault is every other job
+	ClassDefault = "default"
+)
+
+// ioprio_set constants, from linux/ioprio.h
+const (
+	ioprioClassShift = 13
+	ioprioWhoProcess = 1
+)
+
+// ioClasses maps the names accepted in Priority.IOClass to the kernel's
+// I/O scheduling classes
+var ioClasses = map[string]int{
+	"realtime":    1,
+	"best-effort": 2,
+	"idle":        3,
+}
+
+// cgroupRoot is where the unified cgroup hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// A Priority controls the CPU and I/O scheduling of one class of jobs. The
+// zero value leaves the daemon's own scheduling alone.
+//
+// Priorities are applied to the thread of the worker running the job, and so
+// to any program it runs, i.e. xz, and to the goroutines reading and hashing
+// packages on its behalf. Only the hashing of chunks already read, and work
+// shared with other jobs such as the database, run with the daemon's own.
+type Priority struct {
+	Nice    int    // Added to the daemon's niceness, as with nice(1)
+	IOClass string // "realtime", "best-effort" or "idle", empty leaves it alone
+	IOLevel int    // 0 (highest) to 7 within the "realtime" or "best-effort" class
+	Cgroup  string // Threaded cgroup v2 directory to run the job in, if set
+}
+
+// JobClass will return the priority class of the given job type
+func JobClass(t JobType) string {
+	switch t {
+	case Delta, DeltaIndex, DeltaPair, DeltaRepo:
+		return ClassDelta
+	case IndexRepo, ValidateIndex:
+		return ClassIndex
+	default:
+		return ClassDefault
+	}
+}
+
+// Validate will ensure the priority can actually be applied
+func (p *Priority) Validate() error {
+	if p.Nice < -39 || p.Nice > 39 {
+		return fmt.Errorf("nice must be between -39 and 39: %d", p.Nice)
+	}
+	if p.IOClass != "" {
+		if _, ok := ioClasses[p.IOClass]; !ok {
+			return fmt.Errorf("unknown I/O class: %s", p.IOClass)
+		}
+	}
+	if p.IOLevel < 0 || p.IOLevel > 7 {
+		return fmt.Errorf("I/O level must be between 0 and 7: %d", p.IOLevel)
+	}
+	if p.Cgroup != "" {
+		if !filepath.IsAbs(p.Cgroup) {
+			return fmt.Errorf("cgroup must be an absolute path: %s", p.Cgroup)
+		}
+		if _, err := os.Stat(filepath.Join(p.Cgroup, "cgroup.threads")); err != nil {
+			return fmt.Errorf("not a cgroup v2 directory: %s", p.Cgroup)
+		}
+	}
+	return nil
+}
+
+// A threadPriority is the scheduling actually applied to a worker's thread
+type threadPriority struct {
+	nice   int
+	ioprio int
+	cgroup string // Empty if we never found out
+}
+
+// currentPriority will return the scheduling of the calling thread, which
+// when called before any jobs have run is the daemon's own
+func currentPriority() threadPriority {
+	var ret threadPriority
+
+	// The raw syscall returns 20 - nice, so it's never negative
+	if prio, err := syscall.Getpriority(syscall.PRIO_PROCESS, 0); err == nil {
+		ret.nice = 20 - prio
+	}
+	if prio, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0); errno == 0 {
+		ret.ioprio = int(prio)
+	}
+
+	// Only the unified hierarchy is of any use to us, i.e. "0::/ferryd.service"
+	if data, err := ioutil.ReadFile("/proc/self/cgroup"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if strings.HasPrefix(line, "0::") {
+				ret.cgroup = filepath.Join(cgroupRoot, strings.TrimPrefix(line, "0::"))
+			}
+		}
+	}
+	return ret
+}
+
+// resolve will work out the scheduling for the priority, on top of the
+// daemon's own
+func (p *Priority) resolve(base threadPriority) threadPriority {
+	ret := base
+	ret.nice = base.nice + p.Nice
+	if ret.nice < -20 {
+		ret.nice = -20
+	} else if ret.nice > 19 {
+		ret.nice = 19
+	}
+	if p.IOClass != "" {
+		level := p.IOLevel
+		if p.IOClass == "idle" {
+			level = 0
+		}
+		ret.ioprio = ioClasses[p.IOClass]<<ioprioClassShift | level
+	}
+	if p.Cgroup != "" {
+		ret.cgroup = p.Cgroup
+	}
+	return ret
+}
+
+// apply will change the scheduling of the calling thread, which must be
+// locked to its goroutine, only touching what differs from old
+func (t *threadPriority) apply(old *threadPriority) error {
+	tid := syscall.Gettid()
+	if old.nice != t.nice {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, t.nice); err != nil {
+			return fmt.Errorf("failed to set nice %d: %v", t.nice, err)
+		}
+	}
+	if old.ioprio != t.ioprio {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(t.ioprio)); errno != 0 {
+			return fmt.Errorf("failed to set I/O priority: %v", errno)
+		}
+	}
+	if t.cgroup != "" && old.cgroup != t.cgroup {
+		threads := filepath.Join(t.cgroup, "cgroup.threads")
+		if err := ioutil.WriteFile(threads, []byte(strconv.Itoa(tid)), 0644); err != nil {
+			return fmt.Errorf("failed to join cgroup %s: %v", t.cgroup, err)
+		}
+	}
+	return nil
+}
+
+// SetPriorities will change the priority of each class of job, with those
+// not given running at the daemon's own priority. Each worker picks up the
+// change when it starts its next job.
+func (j *Processor) SetPriorities(priorities map[string]Priority) {
+	ret := make(map[string]Priority, len(priorities))
+	for class, p := range priorities {
+		ret[class] = p
+	}
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.priorities = ret
+}
+
+// jobPriority will return the scheduling a job of the given type runs with
+func (j *Processor) jobPriority(t JobType) threadPriority {
+	j.mut.Lock()
+	p := j.priorities[JobClass(t)]
+	j.mut.Unlock()
+	return p.resolve(j.basePriority)
+}
@@ -0,0 +1,118 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestJobPriority(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	jproc := NewProcessor(nil, store, 1)
+	jproc.basePriority = threadPriority{nice: 15, ioprio: 2<<ioprioClassShift | 4, cgroup: "/sys/fs/cgroup/ferryd"}
+	jproc.SetPriorities(map[string]Priority{
+		ClassDelta: {Nice: 10, IOClass: "idle", IOLevel: 5},
+		ClassIndex: {Nice: -5, IOClass: "best-effort", IOLevel: 1, Cgroup: "/sys/fs/cgroup/ferryd/index"},
+	})
+
+	tests := []struct {
+		t    JobType
+		want threadPriority
+	}{
+		// Clamped to the highest nice, and idle has no levels
+		{DeltaPair, threadPriority{19, 3 << ioprioClassShift, "/sys/fs/cgroup/ferryd"}},
+		{IndexRepo, threadPriority{10, 2<<ioprioClassShift | 1, "/sys/fs/cgroup/ferryd/index"}},
+		{CopySource, jproc.basePriority},
+	}
+	for _, test := range tests {
+		if got := jproc.jobPriority(test.t); got != test.want {
+			t.Fatalf("Wrong priority for %s: %+v, expected %+v", test.t, got, test.want)
+		}
+	}
+}
+
+// runLocked will run f on a goroutine locked to a thread that's thrown away
+// afterwards, so that the test doesn't change the scheduling of any other
+func runLocked(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		f()
+	}()
+	<-done
+}
+
+func TestPriorityApply(t *testing.T) {
+	var err error
+	var old, want, got threadPriority
+	runLocked(func() {
+		old = currentPriority()
+		want = old
+		if want.nice < 19 {
+			want.nice++
+		}
+		want.ioprio = 3 << ioprioClassShift
+		if err = want.apply(&old); err == nil {
+			got = currentPriority()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Failed to apply priority: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Wrong priority applied: %+v, expected %+v", got, want)
+	}
+
+	// Nothing else should have been touched
+	if now := currentPriority(); now.nice != old.nice {
+		t.Fatalf("Priority leaked out of the thread: %+v, expected %+v", now, old)
+	}
+}
+
+func TestWorkerThreadSetup(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	jproc := NewProcessor(nil, store, 1)
+	job := NewDeltaPairJob("0123456789abcdef", "unstable", "nano", "nano-2.8.6-62-1-x86_64.eopkg", "nano-2.8.7-63-1-x86_64.eopkg")
+	w := NewWorkerAsync(jproc)
+	w.priority = jproc.basePriority
+	if w.threadSetup(job) != nil {
+		t.Fatalf("Expected no setup for a job at the daemon's priority")
+	}
+
+	w.priority.ioprio = 3 << ioprioClassShift
+	setup := w.threadSetup(job)
+	if setup == nil {
+		t.Fatalf("Expected a setup for a job with its own priority")
+	}
+	var got threadPriority
+	runLocked(func() {
+		setup()
+		got = currentPriority()
+	})
+	if got != w.priority {
+		t.Fatalf("Helper didn't take on the job's priority: %+v, expected %+v", got, w.priority)
+	}
+}
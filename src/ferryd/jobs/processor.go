@@ -18,26 +18,62 @@ package jobs
 
 import (
 	"ferryd/core"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"libferry"
 	"runtime"
 	"sync"
+	"time"
 )
 
+// A JobListener is notified each time a job has been retired, whether it
+// succeeded or not
+type JobListener func(job *libferry.Job)
+
+// Job events passed to a JobEventListener
+const (
+	// JobQueued is reported once a job is in a queue. Jobs merged into one
+	// already queued aren't reported.
+	JobQueued = "queued"
+
+	// JobStarted is reported when a worker begins executing a job
+	JobStarted = "started"
+
+	// JobOverdue is reported by the watchdog once a job has been running for
+	// longer than its JobLimit expects
+	JobOverdue = "overdue"
+)
+
+// A JobEventListener is notified as each job is queued, started or becomes
+// overdue. Like a JobListener, it mustn't block.
+type JobEventListener func(event string, job *libferry.Job)
+
 // A Processor is responsible for the main dispatch and bulking of jobs
 // to ensure they're handled in the most optimal fashion.
 type Processor struct {
-	manager *core.Manager
-	store   *JobStore
-	wg      *sync.WaitGroup
-	closed  bool
-	njobs   int
-	workers []*Worker
+	manager   *core.Manager
+	store     *JobStore
+	wg        *sync.WaitGroup
+	closed    bool
+	started   bool
+	njobs     int
+	workers   []*Worker
+	mut       *sync.Mutex // Protects workers, listeners, limits and priorities
+	listeners []JobListener
+	watchers  []JobEventListener
+	limits    map[JobType]JobLimit
+
+	priorities   map[string]Priority // Keyed by the job class
+	basePriority threadPriority      // The daemon's own scheduling
+
+	indexer *indexDebouncer // Collapses bursts of indexes into one
+
+	watchdogStop chan struct{}
 }
 
-// NewProcessor will return a new Processor with the specified number
-// of jobs. Note that "njobs" only refers to the number of *background jobs*,
-// the majority of operations will run sequentially
-func NewProcessor(m *core.Manager, store *JobStore, njobs int) *Processor {
+// resolveJobCount will turn the requested number of background jobs into
+// the actual number we'll run, and update the runtime limits to match
+func resolveJobCount(njobs int) int {
 	// If we set to -1, we'll automatically set to half of the system core count
 	// because we use xz -T 2 (so twice the number of threads ..)
 	if njobs < 0 {
@@ -60,14 +96,29 @@ func NewProcessor(m *core.Manager, store *JobStore, njobs int) *Processor {
 		"maxProcs":    njobs + 5,
 	}).Info("Set runtime job limits")
 
+	return njobs
+}
+
+// NewProcessor will return a new Processor with the specified number
+// of jobs. Note that "njobs" only refers to the number of *background jobs*,
+// the majority of operations will run sequentially
+func NewProcessor(m *core.Manager, store *JobStore, njobs int) *Processor {
+	njobs = resolveJobCount(njobs)
+
 	ret := &Processor{
 		manager: m,
 		store:   store,
 		wg:      &sync.WaitGroup{},
 		closed:  false,
 		njobs:   njobs,
+		mut:     &sync.Mutex{},
+
+		basePriority: currentPriority(),
+		watchdogStop: make(chan struct{}),
 	}
 
+	ret.indexer = newIndexDebouncer(ret)
+
 	// Construct worker pool
 	ret.workers = append(ret.workers, NewWorkerSequential(ret))
 	for i := 0; i < njobs; i++ {
@@ -79,7 +130,9 @@ func NewProcessor(m *core.Manager, store *JobStore, njobs int) *Processor {
 
 // Close an existing Processor, waiting for all jobs to complete
 func (j *Processor) Close() {
+	j.mut.Lock()
 	if j.closed {
+		j.mut.Unlock()
 		return
 	}
 	j.closed = true
@@ -88,27 +141,236 @@ func (j *Processor) Close() {
 	for _, j := range j.workers {
 		j.Stop()
 	}
+	close(j.watchdogStop)
+	j.mut.Unlock()
 
 	j.wg.Wait()
+
+	// Journal the indexes still waiting so they run after a restart
+	j.indexer.flush()
 }
 
 // Begin will start the main job processor in parallel
 func (j *Processor) Begin() {
-	if j.closed {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if j.closed || j.started {
 		return
 	}
-	j.wg.Add(j.njobs + 1)
+	j.started = true
+	j.wg.Add(j.njobs + 2)
 	for _, j := range j.workers {
 		go j.Start()
 	}
+	go j.watchdog()
+}
+
+// SetJobCount will change the number of background workers at runtime.
+// Surplus workers are asked to stop and will finish their current job
+// before exiting, so no in-flight work is lost.
+func (j *Processor) SetJobCount(njobs int) {
+	njobs = resolveJobCount(njobs)
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	if j.closed || njobs == j.njobs {
+		return
+	}
+
+	// Worker 0 is always the sequential worker
+	for len(j.workers)-1 < njobs {
+		w := NewWorkerAsync(j)
+		j.workers = append(j.workers, w)
+		if j.started {
+			j.wg.Add(1)
+			go w.Start()
+		}
+	}
+	for len(j.workers)-1 > njobs {
+		last := len(j.workers) - 1
+		j.workers[last].Stop()
+		j.workers = j.workers[:last]
+	}
+
+	log.WithFields(log.Fields{
+		"old": j.njobs,
+		"new": njobs,
+	}).Info("Changed number of background jobs")
+	j.njobs = njobs
+}
+
+// WorkerStatus will return the current status of every worker, with the
+// sequential worker always first
+func (j *Processor) WorkerStatus() []libferry.WorkerStatus {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	ret := make([]libferry.WorkerStatus, 0, len(j.workers))
+	for i, w := range j.workers {
+		st := w.Status()
+		st.ID = i
+		ret = append(ret, st)
+	}
+	return ret
+}
+
+// AddListener will register a function to be called each time a job is
+// retired. Listeners are called from the worker goroutine, so they should
+// not block.
+func (j *Processor) AddListener(listener JobListener) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.listeners = append(j.listeners, listener)
+}
+
+// notifyRetired will pass the completed job to all listeners
+func (j *Processor) notifyRetired(job *JobEntry) {
+	j.indexer.retired(job.CorrelationID)
+
+	j.mut.Lock()
+	listeners := j.listeners
+	j.mut.Unlock()
+
+	if len(listeners) == 0 {
+		return
+	}
+	completed := job.Completed()
+	for _, listener := range listeners {
+		listener(completed)
+	}
+}
+
+// AddEventListener will register a function to be called each time a job
+// is queued or started
+func (j *Processor) AddEventListener(listener JobEventListener) {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.watchers = append(j.watchers, listener)
+}
+
+// notifyEvent will pass the job to all event listeners
+func (j *Processor) notifyEvent(event string, job *JobEntry) {
+	j.mut.Lock()
+	watchers := j.watchers
+	j.mut.Unlock()
+
+	if len(watchers) == 0 {
+		return
+	}
+	ret := &libferry.Job{
+		ID:          job.CorrelationID,
+		ParentID:    job.ParentID,
+		DependsOn:   job.DependsOn,
+		Timing:      job.Timing,
+		Description: job.description,
+		Type:        string(job.Type),
+		Repo:        job.repo,
+	}
+	if ret.Description == "" {
+		if hnd, err := NewJobHandler(job); err == nil {
+			ret.Description = hnd.Describe()
+			ret.Repo = jobRepo(job, hnd)
+		}
+	}
+	for _, watcher := range watchers {
+		watcher(event, ret)
+	}
+}
+
+// SetIndexDelay will change how long a repository must go without changes
+// before an index requested with ScheduleIndex runs. With no delay the
+// index is pushed straight away.
+func (j *Processor) SetIndexDelay(delay time.Duration) {
+	j.indexer.setDelay(delay)
+}
+
+// ScheduleIndex will index the repository once nothing has changed it for
+// the index delay, collapsing the indexes requested during a burst of
+// imports into one. The jobs in watch may still change the repository, so
+// the index is pushed back as each of them retires. Without a delay the
+// index is pushed straight away, depending on the watched jobs.
+func (j *Processor) ScheduleIndex(repoID string, watch ...string) error {
+	return j.indexer.schedule(repoID, watch)
 }
 
 // PushJob will automatically determine which queue to push a job to and place
-// it there for immediate execution
-func (j *Processor) PushJob(job *JobEntry) {
-	if job.sequential {
-		j.store.PushSequentialJob(job)
-	} else {
-		j.store.PushAsyncJob(job)
+// it there for immediate execution. Once pushed, the job's CorrelationID
+// identifies it, unless it was merged into a pending job doing the same work.
+func (j *Processor) PushJob(job *JobEntry) error {
+	_, err := j.PushJobOnce(job, "")
+	return err
+}
+
+// PushJobOnce is PushJob, except that a job already queued with the same
+// idempotency key is returned in place of a duplicate. The ID of the job
+// queued for the key is returned, and an empty key behaves as PushJob.
+func (j *Processor) PushJobOnce(job *JobEntry, key string) (string, error) {
+	id, err := j.store.PushJobOnce(job, key)
+	if err == nil && id == job.CorrelationID {
+		j.notifyEvent(JobQueued, job)
+	}
+	return id, err
+}
+
+// PushChain will queue the jobs so that each only runs once the job before
+// it has succeeded, returning the ID of every job. Only sequential jobs may
+// be chained, as the sequential queue already runs them in order.
+func (j *Processor) PushChain(chain []*JobEntry) ([]string, error) {
+	for _, job := range chain {
+		if !job.sequential {
+			return nil, fmt.Errorf("cannot chain %s jobs", job.Type)
+		}
+	}
+
+	var ids []string
+	for i, job := range chain {
+		if i > 0 {
+			job.DependsOn = []string{ids[i-1]}
+		}
+		id, err := j.PushJobOnce(job, "")
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// failDependents will fail every queued job depending on the failed job,
+// and in turn every job depending on those
+func (j *Processor) failDependents(failed *JobEntry) {
+	dependents, err := j.store.ClaimDependents(failed.CorrelationID)
+	if err != nil {
+		failed.Logger().WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to find jobs depending on failed job")
+		return
+	}
+
+	for _, job := range dependents {
+		job.failure = fmt.Errorf("job %s which this depends on failed", failed.CorrelationID)
+		if hnd, err := NewJobHandler(job); err == nil {
+			job.description = hnd.Describe()
+			job.repo = jobRepo(job, hnd)
+		}
+		job.Timing.End = time.Now().UTC()
+
+		retire := j.store.RetireAsyncJob
+		if job.sequential {
+			retire = j.store.RetireSequentialJob
+		}
+		if err := retire(job); err != nil {
+			job.Logger().WithFields(log.Fields{
+				"error": err,
+				"id":    job.GetID(),
+			}).Error("Error in retiring job")
+		}
+		job.Logger().WithFields(log.Fields{
+			"dependency": failed.CorrelationID,
+		}).Error("Dropped job as a job it depends on failed")
+
+		j.notifyRetired(job)
+		j.failDependents(job)
 	}
 }
@@ -0,0 +1,70 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"libferry"
+	"sync"
+)
+
+// A ProgressReporter allows a running job to report how far along it is.
+// Progress is only held in memory, as it changes far too often to be
+// written to the job store.
+//
+// All methods are safe to call on a nil ProgressReporter, which is what a
+// handler will have when it isn't being executed by a worker.
+type ProgressReporter struct {
+	mut      *sync.Mutex
+	progress *libferry.JobProgress // nil until the first update
+}
+
+// newProgressReporter will return a new ProgressReporter with no progress
+func newProgressReporter() *ProgressReporter {
+	return &ProgressReporter{
+		mut: &sync.Mutex{},
+	}
+}
+
+// Update will record the current progress of the job
+func (p *ProgressReporter) Update(current, stage string, done, total int64) {
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.progress = &libferry.JobProgress{
+		Current: current,
+		Stage:   stage,
+		Done:    done,
+		Total:   total,
+	}
+}
+
+// Get will return a copy of the current progress, or nil if the job hasn't
+// reported any
+func (p *ProgressReporter) Get() *libferry.JobProgress {
+	if p == nil {
+		return nil
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	if p.progress == nil {
+		return nil
+	}
+	ret := *p.progress
+	return &ret
+}
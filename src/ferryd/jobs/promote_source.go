@@ -0,0 +1,120 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// PromoteSourceJobHandler is responsible for promoting a source & release
+// from one repository to another once it passes the policy checks
+type PromoteSourceJobHandler struct {
+	logger  *log.Entry // Scoped to the job being executed
+	stats   *JobStats  // Record the source that was promoted
+	jobID   string     // Names the undo snapshot
+	repoID  string
+	target  string
+	source  string
+	release int
+	move    bool
+}
+
+// NewPromoteSourceJob will return a job suitable for adding to the job processor.
+// When move is set the packages are removed from repoID once promoted.
+func NewPromoteSourceJob(repoID, target, source string, release int, move bool) *JobEntry {
+	params := []string{repoID, target, source, fmt.Sprintf("%d", release)}
+	if move {
+		params = append(params, "move")
+	}
+	return &JobEntry{
+		sequential: true,
+		Type:       PromoteSource,
+		Params:     params,
+	}
+}
+
+// NewPromoteSourceJobHandler will create a job handler for the input job and ensure it validates
+func NewPromoteSourceJobHandler(j *JobEntry) (*PromoteSourceJobHandler, error) {
+	if len(j.Params) != 4 && len(j.Params) != 5 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	rel, err := strconv.ParseInt(j.Params[3], 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return &PromoteSourceJobHandler{
+		logger:  j.Logger(),
+		stats:   j.Stats(),
+		jobID:   j.CorrelationID,
+		repoID:  j.Params[0],
+		target:  j.Params[1],
+		source:  j.Params[2],
+		release: int(rel),
+		move:    len(j.Params) == 5,
+	}, nil
+}
+
+// Execute will check the promotion is allowed before carrying it out
+func (j *PromoteSourceJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+	// Don't bother with a snapshot for a promotion we'll refuse
+	if err := manager.CheckPromotion(j.repoID, j.target, j.source, j.release); err != nil {
+		return err
+	}
+	if err := manager.CreateUndoSnapshot(j.target, j.jobID, j.Describe()); err != nil {
+		return err
+	}
+
+	names, err := manager.PromoteSource(j.repoID, j.target, j.source, j.release, j.move)
+	if err != nil {
+		return err
+	}
+
+	j.logger.WithFields(log.Fields{
+		"from":          j.repoID,
+		"to":            j.target,
+		"source":        j.source,
+		"releaseNumber": j.release,
+		"move":          j.move,
+	}).Info("Promoted source")
+	j.stats.AddSource(j.source)
+	j.stats.AddPackages(len(names), 0)
+
+	// Deltas in the target lead from whatever it published before
+	for _, name := range names {
+		jproc.PushJob(NewDeltaIndexJob(j.target, name))
+	}
+	return nil
+}
+
+// MutatedRepos returns the target repository, and the source when moving
+func (j *PromoteSourceJobHandler) MutatedRepos() []string {
+	if j.move {
+		return []string{j.target, j.repoID}
+	}
+	return []string{j.target}
+}
+
+// Describe returns a human readable description for this job
+func (j *PromoteSourceJobHandler) Describe() string {
+	if j.move {
+		return fmt.Sprintf("Move source '%s' (rel: %d) from '%s' to '%s'", j.source, j.release, j.repoID, j.target)
+	}
+	return fmt.Sprintf("Promote source '%s' (rel: %d) from '%s' to '%s'", j.source, j.release, j.repoID, j.target)
+}
@@ -24,6 +24,9 @@ import (
 
 // PullRepoJobHandler is responsible for cloning an existing repository
 type PullRepoJobHandler struct {
+	logger   *log.Entry // Scoped to the job being executed
+	stats    *JobStats  // Record how many packages were pulled
+	jobID    string     // Names the undo snapshot
 	sourceID string
 	targetID string
 }
@@ -43,6 +46,9 @@ func NewPullRepoJobHandler(j *JobEntry) (*PullRepoJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &PullRepoJobHandler{
+		logger:   j.Logger(),
+		stats:    j.Stats(),
+		jobID:    j.CorrelationID,
 		sourceID: j.Params[0],
 		targetID: j.Params[1],
 	}, nil
@@ -50,15 +56,33 @@ func NewPullRepoJobHandler(j *JobEntry) (*PullRepoJobHandler, error) {
 
 // Execute will attempt to pull the repos
 func (j *PullRepoJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+	// Pulling newer packages hides the previous release from the index
+	diff, err := manager.DiffRepos(j.sourceID, j.targetID)
+	if err != nil {
+		return err
+	}
+	if len(diff.Newer) > 0 {
+		if err := manager.CreateUndoSnapshot(j.targetID, j.jobID, j.Describe()); err != nil {
+			return err
+		}
+	}
+	for _, d := range diff.Banned {
+		j.logger.WithFields(log.Fields{
+			"id":     d.SourceID,
+			"target": j.targetID,
+		}).Warning("Not pulling banned package")
+	}
+
 	changedNames, err := manager.PullRepo(j.sourceID, j.targetID)
 	if err != nil {
-		return nil
+		return err
 	}
 
-	log.WithFields(log.Fields{
+	j.logger.WithFields(log.Fields{
 		"source": j.sourceID,
 		"target": j.targetID,
 	}).Info("Pulled repository")
+	j.stats.AddPackages(len(changedNames), 0)
 
 	// Create delta job in this repository on the changed names
 	// Don't cause indexing because it causes noise
@@ -69,6 +93,11 @@ func (j *PullRepoJobHandler) Execute(jproc *Processor, manager *core.Manager) er
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *PullRepoJobHandler) MutatedRepos() []string {
+	return []string{j.targetID}
+}
+
 // Describe returns a human readable description for this job
 func (j *PullRepoJobHandler) Describe() string {
 	return fmt.Sprintf("Pull repository '%s' into '%s'", j.sourceID, j.targetID)
@@ -0,0 +1,105 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// PullSourceJobHandler is responsible for pulling a single source from one
+// repository into another
+type PullSourceJobHandler struct {
+	logger     *log.Entry // Scoped to the job being executed
+	stats      *JobStats  // Record how many packages were pulled
+	jobID      string     // Names the undo snapshot
+	sourceID   string
+	targetID   string
+	sourceName string
+}
+
+// NewPullSourceJob will return a job suitable for adding to the job processor
+func NewPullSourceJob(sourceID, targetID, sourceName string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       PullSource,
+		Params:     []string{sourceID, targetID, sourceName},
+	}
+}
+
+// NewPullSourceJobHandler will create a job handler for the input job and ensure it validates
+func NewPullSourceJobHandler(j *JobEntry) (*PullSourceJobHandler, error) {
+	if len(j.Params) != 3 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &PullSourceJobHandler{
+		logger:     j.Logger(),
+		stats:      j.Stats(),
+		jobID:      j.CorrelationID,
+		sourceID:   j.Params[0],
+		targetID:   j.Params[1],
+		sourceName: j.Params[2],
+	}, nil
+}
+
+// Execute will attempt to pull the source
+func (j *PullSourceJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+	// Pulling newer packages hides the previous release from the index
+	diff, err := manager.DiffRepos(j.sourceID, j.targetID)
+	if err != nil {
+		return err
+	}
+	for _, d := range diff.Newer {
+		if d.Source.Source.Name == j.sourceName {
+			if err := manager.CreateUndoSnapshot(j.targetID, j.jobID, j.Describe()); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	changedNames, err := manager.PullSource(j.sourceID, j.targetID, j.sourceName)
+	if err != nil {
+		return err
+	}
+
+	j.logger.WithFields(log.Fields{
+		"source":     j.sourceID,
+		"target":     j.targetID,
+		"sourceName": j.sourceName,
+		"packages":   len(changedNames),
+	}).Info("Pulled source")
+	j.stats.AddSource(j.sourceName)
+	j.stats.AddPackages(len(changedNames), 0)
+
+	for _, pkg := range changedNames {
+		jproc.PushJob(NewDeltaIndexJob(j.targetID, pkg))
+	}
+
+	return nil
+}
+
+// MutatedRepos returns the repository modified by this job
+func (j *PullSourceJobHandler) MutatedRepos() []string {
+	return []string{j.targetID}
+}
+
+// Describe returns a human readable description for this job
+func (j *PullSourceJobHandler) Describe() string {
+	return fmt.Sprintf("Pull source '%s' from '%s' into '%s'", j.sourceName, j.sourceID, j.targetID)
+}
@@ -0,0 +1,121 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// RecoverJobs will deal with every job which was running when ferryd last
+// stopped, and must be called before Begin. Jobs with a recovery hook are
+// given the chance to tidy up after themselves, or to finish off what the
+// earlier attempt started, and everything else is simply queued again.
+func (j *Processor) RecoverJobs() {
+	for _, job := range j.store.InterruptedJobs() {
+		j.recoverJob(job)
+	}
+}
+
+// recoverJob will either queue the interrupted job again, or retire it if
+// its recovery hook finished it or failed
+func (j *Processor) recoverJob(job *JobEntry) {
+	logger := job.Logger()
+	handler, err := NewJobHandler(job)
+	if err != nil {
+		// Fails again in the worker, where it's reported properly
+		j.requeue(job)
+		return
+	}
+
+	recoverer, ok := handler.(Recoverer)
+	if !ok {
+		logger.Info("Queued interrupted job again")
+		j.requeue(job)
+		return
+	}
+
+	var done bool
+	err = j.withHistory(job, handler, func() error {
+		var err error
+		done, err = recoverer.Recover(j, j.manager.WithLogger(logger))
+		return err
+	})
+	job.description = handler.Describe()
+	job.repo = jobRepo(job, handler)
+
+	switch {
+	case err != nil:
+		job.failure = err
+		logger.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to recover interrupted job")
+	case done:
+		logger.Info("Finished interrupted job during recovery")
+	default:
+		logger.Info("Queued interrupted job again")
+		j.requeue(job)
+		return
+	}
+
+	job.Timing.End = time.Now().UTC()
+	retire := j.store.RetireAsyncJob
+	if job.sequential {
+		retire = j.store.RetireSequentialJob
+	}
+	if err := retire(job); err != nil {
+		logger.WithFields(log.Fields{
+			"error": err,
+			"id":    job.GetID(),
+		}).Error("Error in retiring job")
+	}
+	j.notifyRetired(job)
+	if job.failure != nil {
+		j.failDependents(job)
+	}
+}
+
+// withHistory will run op on behalf of the job's handler, recording any
+// repository changes it makes in the history
+func (j *Processor) withHistory(job *JobEntry, handler JobHandler, op func() error) error {
+	mutator, ok := handler.(RepoMutator)
+	if !ok {
+		return op()
+	}
+
+	entry := &core.HistoryEntry{
+		JobID:     job.CorrelationID,
+		Operation: string(job.Type),
+		Params:    job.Params,
+	}
+	return j.manager.RecordHistory(entry, mutator.MutatedRepos(), func() error {
+		err := op()
+		// Some jobs know more about themselves once they've run
+		entry.Description = handler.Describe()
+		return err
+	})
+}
+
+// requeue will queue the interrupted job again, reporting any failure
+func (j *Processor) requeue(job *JobEntry) {
+	if err := j.store.Requeue(job); err != nil {
+		job.Logger().WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to queue interrupted job again")
+	}
+}
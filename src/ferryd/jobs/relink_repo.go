@@ -0,0 +1,86 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// RelinkRepoJobHandler is responsible for repairing the links between a
+// repository tree and the pool, and should only ever be used in sequential
+// queues.
+type RelinkRepoJobHandler struct {
+	logger   *log.Entry        // Scoped to the job being executed
+	progress *ProgressReporter // Report how many files were checked
+	stats    *JobStats         // Record how many files were checked
+	repoID   string
+}
+
+// NewRelinkRepoJob will return a job suitable for adding to the job processor
+func NewRelinkRepoJob(id string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       RelinkRepo,
+		Params:     []string{id},
+	}
+}
+
+// NewRelinkRepoJobHandler will create a job handler for the input job and ensure it validates
+func NewRelinkRepoJobHandler(j *JobEntry) (*RelinkRepoJobHandler, error) {
+	if len(j.Params) != 1 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &RelinkRepoJobHandler{
+		logger:   j.Logger(),
+		progress: j.Progress(),
+		stats:    j.Stats(),
+		repoID:   j.Params[0],
+	}, nil
+}
+
+// Execute will relink every file in the repository tree against the pool.
+// Files which can't be relinked are left as they were, failing the job once
+// the rest are done.
+func (j *RelinkRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	result, err := manager.RelinkRepo(j.repoID, func(done, total int) {
+		j.progress.Update(j.repoID, "Relinking files", int64(done), int64(total))
+	})
+	if result != nil {
+		j.stats.AddPackages(result.Checked, 0)
+	}
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":     j.repoID,
+		"checked":  result.Checked,
+		"relinked": result.Relinked,
+		"failed":   len(result.Failed),
+	}).Info("Relinked repository")
+	if len(result.Failed) > 0 {
+		return fmt.Errorf("failed to relink %d files: %s", len(result.Failed), strings.Join(result.Failed, ", "))
+	}
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *RelinkRepoJobHandler) Describe() string {
+	return fmt.Sprintf("Relink repository '%s' against the pool", j.repoID)
+}
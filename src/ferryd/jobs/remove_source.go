@@ -25,6 +25,9 @@ import (
 
 // RemoveSourceJobHandler is responsible for removing packages by identifiers
 type RemoveSourceJobHandler struct {
+	logger  *log.Entry // Scoped to the job being executed
+	stats   *JobStats  // Record the source that was removed
+	jobID   string     // Names the undo snapshot
 	repoID  string
 	source  string
 	release int
@@ -49,6 +52,9 @@ func NewRemoveSourceJobHandler(j *JobEntry) (*RemoveSourceJobHandler, error) {
 		return nil, err
 	}
 	return &RemoveSourceJobHandler{
+		logger:  j.Logger(),
+		stats:   j.Stats(),
+		jobID:   j.CorrelationID,
 		repoID:  j.Params[0],
 		source:  j.Params[1],
 		release: int(rel),
@@ -57,10 +63,14 @@ func NewRemoveSourceJobHandler(j *JobEntry) (*RemoveSourceJobHandler, error) {
 
 // Execute will remove the source&rel match from the repo
 func (j *RemoveSourceJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.CreateUndoSnapshot(j.repoID, j.jobID, j.Describe()); err != nil {
+		return err
+	}
 	if err := manager.RemoveSource(j.repoID, j.source, j.release); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
+	j.stats.AddSource(j.source)
+	j.logger.WithFields(log.Fields{
 		"repo":          j.repoID,
 		"source":        j.source,
 		"releaseNumber": j.release,
@@ -68,6 +78,11 @@ func (j *RemoveSourceJobHandler) Execute(_ *Processor, manager *core.Manager) er
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *RemoveSourceJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *RemoveSourceJobHandler) Describe() string {
 	return fmt.Sprintf("Remove sources by id '%s' (rel: %d) in '%s'", j.source, j.release, j.repoID)
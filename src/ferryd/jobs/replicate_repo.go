@@ -0,0 +1,171 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"context"
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"libferry"
+)
+
+// ReplicateRepoJobHandler is responsible for bringing a repository in line
+// with the same repository on a primary ferryd, and should only ever be
+// used in sequential queues.
+type ReplicateRepoJobHandler struct {
+	logger   *log.Entry // Scoped to the job being executed
+	progress *ProgressReporter
+	stats    *JobStats       // Record how much was fetched
+	ctx      context.Context // Done once the job passes its timeout
+	repoID   string
+	primary  string // Socket of the primary
+}
+
+// NewReplicateRepoJob will return a job suitable for adding to the job processor
+func NewReplicateRepoJob(id, primary string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       ReplicateRepo,
+		Params:     []string{id, primary},
+	}
+}
+
+// NewReplicateRepoJobHandler will create a job handler for the input job and ensure it validates
+func NewReplicateRepoJobHandler(j *JobEntry) (*ReplicateRepoJobHandler, error) {
+	if len(j.Params) != 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &ReplicateRepoJobHandler{
+		logger:   j.Logger(),
+		progress: j.Progress(),
+		stats:    j.Stats(),
+		ctx:      j.Context(),
+		repoID:   j.Params[0],
+		primary:  j.Params[1],
+	}, nil
+}
+
+// replicaSource fetches files from the primary over its API
+type replicaSource struct {
+	ctx     context.Context
+	client  *libferry.Client
+	handler *ReplicateRepoJobHandler
+	fetched int64
+}
+
+// FetchPoolFile will download the pool file from the primary
+func (s *replicaSource) FetchPoolFile(id string, w io.Writer) error {
+	s.handler.progress.Update(id, "Fetching from primary", s.fetched, 0)
+	if err := s.client.FetchPoolFileContext(s.ctx, id, w); err != nil {
+		return err
+	}
+	s.fetched++
+	return nil
+}
+
+// FetchAsset will download the asset of the repository from the primary
+func (s *replicaSource) FetchAsset(name string) ([]byte, error) {
+	return s.client.GetAssetContext(s.ctx, s.handler.repoID, name)
+}
+
+// convertManifest will return the manifest sent by the primary as we'd
+// have written it ourselves
+func convertManifest(mq *libferry.RepoManifestRequest) *core.RepoArchive {
+	manifest := &core.RepoArchive{
+		FormatVersion: core.ArchiveFormatVersion,
+		Repo:          mq.Repo,
+		Assets:        mq.Assets,
+	}
+	for _, entry := range mq.Entries {
+		manifest.Entries = append(manifest.Entries, &core.RepoEntry{
+			SchemaVersion: core.RepoSchemaVersion,
+			Name:          entry.Name,
+			Available:     entry.Available,
+			Published:     entry.Published,
+			Deltas:        entry.Deltas,
+		})
+	}
+	for _, file := range mq.Files {
+		entry := &core.ArchivePoolEntry{
+			Name:        file.ID,
+			Size:        file.Size,
+			ContentHash: file.ContentHash,
+		}
+		if file.Delta != nil {
+			entry.Delta = &core.DeltaInformation{
+				FromRelease: file.Delta.FromRelease,
+				FromID:      file.Delta.FromID,
+				ToRelease:   file.Delta.ToRelease,
+				ToID:        file.Delta.ToID,
+				TargetSize:  file.Delta.TargetSize,
+			}
+		}
+		if prov := file.Provenance; prov != nil {
+			entry.Provenance = &core.Provenance{
+				Builder:  prov.Builder,
+				Build:    prov.Build,
+				Manifest: prov.Manifest,
+				Uploaded: prov.Uploaded,
+				JobID:    prov.JobID,
+			}
+		}
+		manifest.Pool = append(manifest.Pool, entry)
+	}
+	return manifest
+}
+
+// Execute will fetch the manifest of the repository from the primary, and
+// then whatever packages and deltas we don't have yet
+func (j *ReplicateRepoJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	client := libferry.NewClient(j.primary)
+	defer client.Close()
+	// Large files may take a while, the job timeout applies instead
+	client.SetTimeout(0)
+
+	mq, err := client.GetRepoManifestContext(j.ctx, j.repoID)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest from primary: %v", err)
+	}
+	source := &replicaSource{
+		ctx:     j.ctx,
+		client:  client,
+		handler: j,
+	}
+	result, err := manager.ReplicateRepo(convertManifest(mq), source)
+	if result != nil {
+		j.stats.AddPackages(result.Fetched, result.Bytes)
+	}
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":    j.repoID,
+		"primary": j.primary,
+		"fetched": result.Fetched,
+		"added":   result.Added,
+		"removed": result.Removed,
+		"assets":  result.Assets,
+	}).Info("Replicated repository")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *ReplicateRepoJobHandler) Describe() string {
+	return fmt.Sprintf("Replicate repository '%s' from %s", j.repoID, j.primary)
+}
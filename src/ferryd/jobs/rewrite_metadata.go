@@ -0,0 +1,104 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strings"
+)
+
+// RewriteMetadataJobHandler is responsible for patching the metadata of a
+// stored package, and should only ever be used in sequential queues.
+type RewriteMetadataJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	pkgID  string
+	patch  *core.MetadataPatch
+}
+
+// NewRewriteMetadataJob will return a job suitable for adding to the job processor
+func NewRewriteMetadataJob(pkgID string, patch *core.MetadataPatch) *JobEntry {
+	params := []string{pkgID}
+	if patch.Summary != nil {
+		params = append(params, "summary="+*patch.Summary)
+	}
+	if patch.Description != nil {
+		params = append(params, "description="+*patch.Description)
+	}
+	if patch.PartOf != nil {
+		params = append(params, "partof="+*patch.PartOf)
+	}
+	for _, license := range patch.License {
+		params = append(params, "license="+license)
+	}
+	return &JobEntry{
+		sequential: true,
+		Type:       RewriteMetadata,
+		Params:     params,
+	}
+}
+
+// NewRewriteMetadataJobHandler will create a job handler for the input job and ensure it validates
+func NewRewriteMetadataJobHandler(j *JobEntry) (*RewriteMetadataJobHandler, error) {
+	if len(j.Params) < 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	patch := &core.MetadataPatch{}
+	for _, param := range j.Params[1:] {
+		splits := strings.SplitN(param, "=", 2)
+		if len(splits) != 2 {
+			return nil, fmt.Errorf("job has invalid parameters")
+		}
+		value := splits[1]
+		switch splits[0] {
+		case "summary":
+			patch.Summary = &value
+		case "description":
+			patch.Description = &value
+		case "partof":
+			patch.PartOf = &value
+		case "license":
+			patch.License = append(patch.License, value)
+		default:
+			return nil, fmt.Errorf("job has invalid parameters")
+		}
+	}
+	return &RewriteMetadataJobHandler{
+		logger: j.Logger(),
+		pkgID:  j.Params[0],
+		patch:  patch,
+	}, nil
+}
+
+// Execute will rewrite the package and republish every repository using it
+func (j *RewriteMetadataJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	repos, err := manager.RewriteMetadata(j.pkgID, j.patch)
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"id":    j.pkgID,
+		"repos": strings.Join(repos, ","),
+	}).Info("Rewrote package metadata")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *RewriteMetadataJobHandler) Describe() string {
+	return fmt.Sprintf("Rewrite metadata of '%s' (%s)", j.pkgID, j.patch)
+}
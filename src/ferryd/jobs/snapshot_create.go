@@ -0,0 +1,75 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// CreateSnapshotJobHandler is responsible for recording repository snapshots
+// and should only ever be used in sequential queues.
+type CreateSnapshotJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	repoID string
+	name   string
+}
+
+// NewCreateSnapshotJob will return a job suitable for adding to the job processor.
+// If name is empty, the snapshot is named after the current time.
+func NewCreateSnapshotJob(repoID, name string) *JobEntry {
+	if name == "" {
+		name = core.DefaultSnapshotName()
+	}
+	return &JobEntry{
+		sequential: true,
+		Type:       CreateSnapshot,
+		Params:     []string{repoID, name},
+	}
+}
+
+// NewCreateSnapshotJobHandler will create a job handler for the input job and ensure it validates
+func NewCreateSnapshotJobHandler(j *JobEntry) (*CreateSnapshotJobHandler, error) {
+	if len(j.Params) != 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &CreateSnapshotJobHandler{
+		logger: j.Logger(),
+		repoID: j.Params[0],
+		name:   j.Params[1],
+	}, nil
+}
+
+// Execute will record the current state of the repository
+func (j *CreateSnapshotJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	snap, err := manager.CreateSnapshot(j.repoID, j.name)
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":     j.repoID,
+		"snapshot": j.name,
+		"packages": snap.PackageCount(),
+	}).Info("Created repository snapshot")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *CreateSnapshotJobHandler) Describe() string {
+	return fmt.Sprintf("Create snapshot '%s' of repository '%s'", j.name, j.repoID)
+}
@@ -0,0 +1,69 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeleteSnapshotJobHandler is responsible for removing repository snapshots
+// and should only ever be used in sequential queues.
+type DeleteSnapshotJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	repoID string
+	name   string
+}
+
+// NewDeleteSnapshotJob will return a job suitable for adding to the job processor
+func NewDeleteSnapshotJob(repoID, name string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       DeleteSnapshot,
+		Params:     []string{repoID, name},
+	}
+}
+
+// NewDeleteSnapshotJobHandler will create a job handler for the input job and ensure it validates
+func NewDeleteSnapshotJobHandler(j *JobEntry) (*DeleteSnapshotJobHandler, error) {
+	if len(j.Params) != 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &DeleteSnapshotJobHandler{
+		logger: j.Logger(),
+		repoID: j.Params[0],
+		name:   j.Params[1],
+	}, nil
+}
+
+// Execute will remove the snapshot, releasing its hold on the pool
+func (j *DeleteSnapshotJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.DeleteSnapshot(j.repoID, j.name); err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":     j.repoID,
+		"snapshot": j.name,
+	}).Info("Deleted repository snapshot")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *DeleteSnapshotJobHandler) Describe() string {
+	return fmt.Sprintf("Delete snapshot '%s' of repository '%s'", j.name, j.repoID)
+}
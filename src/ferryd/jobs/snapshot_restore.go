@@ -0,0 +1,79 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// RestoreSnapshotJobHandler is responsible for rewinding repositories to a
+// snapshot and should only ever be used in sequential queues.
+type RestoreSnapshotJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	jobID  string     // Names the undo snapshot
+	repoID string
+	name   string
+}
+
+// NewRestoreSnapshotJob will return a job suitable for adding to the job processor
+func NewRestoreSnapshotJob(repoID, name string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       RestoreSnapshot,
+		Params:     []string{repoID, name},
+	}
+}
+
+// NewRestoreSnapshotJobHandler will create a job handler for the input job and ensure it validates
+func NewRestoreSnapshotJobHandler(j *JobEntry) (*RestoreSnapshotJobHandler, error) {
+	if len(j.Params) != 2 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &RestoreSnapshotJobHandler{
+		logger: j.Logger(),
+		jobID:  j.CorrelationID,
+		repoID: j.Params[0],
+		name:   j.Params[1],
+	}, nil
+}
+
+// Execute will rewind the repository to the snapshot
+func (j *RestoreSnapshotJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.CreateUndoSnapshot(j.repoID, j.jobID, j.Describe()); err != nil {
+		return err
+	}
+	if err := manager.RestoreSnapshot(j.repoID, j.name); err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":     j.repoID,
+		"snapshot": j.name,
+	}).Info("Restored repository snapshot")
+	return nil
+}
+
+// MutatedRepos returns the repository modified by this job
+func (j *RestoreSnapshotJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
+// Describe returns a human readable description for this job
+func (j *RestoreSnapshotJobHandler) Describe() string {
+	return fmt.Sprintf("Restore repository '%s' to snapshot '%s'", j.repoID, j.name)
+}
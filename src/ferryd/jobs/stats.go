@@ -0,0 +1,96 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"path/filepath"
+	"sync"
+)
+
+// JobStats collects what a running job has processed, so that clients can
+// filter jobs without picking apart their descriptions. Like progress, it's
+// only held in memory until the job is retired.
+//
+// All methods are safe to call on a nil JobStats, which is what a handler
+// will have when it isn't being executed by a worker.
+type JobStats struct {
+	mut      *sync.Mutex
+	sources  []string
+	packages int
+	bytes    int64
+}
+
+// newJobStats will return a new JobStats with nothing recorded
+func newJobStats() *JobStats {
+	return &JobStats{
+		mut: &sync.Mutex{},
+	}
+}
+
+// AddSource will record that the job acted on the named source
+func (s *JobStats) AddSource(name string) {
+	if s == nil || name == "" {
+		return
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for _, have := range s.sources {
+		if have == name {
+			return
+		}
+	}
+	s.sources = append(s.sources, name)
+}
+
+// AddPackages will record that the job processed n more packages, totalling
+// the given number of bytes if known
+func (s *JobStats) AddPackages(n int, bytes int64) {
+	if s == nil {
+		return
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.packages += n
+	s.bytes += bytes
+}
+
+// addPoolPackages will record the packages, which must already be in the
+// pool, along with their sources
+func (s *JobStats) addPoolPackages(manager *core.Manager, pkgs []string) {
+	if s == nil {
+		return
+	}
+	for _, pkg := range pkgs {
+		meta, err := manager.GetPoolEntry(filepath.Base(pkg))
+		if err != nil {
+			continue
+		}
+		s.AddSource(meta.Source.Name)
+		s.AddPackages(1, int64(meta.PackageSize))
+	}
+}
+
+// get will return a copy of everything recorded so far
+func (s *JobStats) get() (sources []string, packages int, bytes int64) {
+	if s == nil {
+		return nil, 0, 0
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]string(nil), s.sources...), s.packages, s.bytes
+}
@@ -17,6 +17,7 @@
 package jobs
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"ferryd/core"
@@ -45,6 +46,26 @@ var (
 	// ErrBreakLoop is used only to break the foreach internally.
 	ErrBreakLoop = errors.New("loop breaker")
 
+	// ErrUnknownJob is returned when a job isn't queued, running, or in the
+	// completion records
+	ErrUnknownJob = errors.New("Unknown job")
+
+	// BucketIdempotency maps idempotency keys to the job they first queued
+	BucketIdempotency = []byte("Idempotency")
+
+	// BucketIdempotencyExpiry is a subbucket of BucketIdempotency ordering
+	// the keys by when they were recorded, so that they can be forgotten
+	// without visiting every key
+	BucketIdempotencyExpiry = []byte("Expiry")
+
+	// ErrIdempotencyConflict is returned when an idempotency key is reused
+	// to queue a different kind of job
+	ErrIdempotencyConflict = errors.New("Idempotency key was already used for a different job")
+
+	// BucketRunning marks each job which has been claimed, by CorrelationID,
+	// so that jobs interrupted by ferryd stopping can be recovered
+	BucketRunning = []byte("Running")
+
 	// BucketRecord is used as a subbucket for records
 	BucketRecord = []byte("Record")
 
@@ -55,12 +76,62 @@ var (
 const (
 	// MaxJobsStored is the maximum amount of jobs we can store before rotating
 	MaxJobsStored = 100
+
+	// IdempotencyWindow is how long an idempotency key is remembered for,
+	// during which pushing with the same key returns the original job
+	IdempotencyWindow = 24 * time.Hour
 )
 
-// JobStore handles the storage and manipulation of incomplete jobs
+// JobStore handles the storage and manipulation of incomplete jobs.
+//
+// The queues live in memory, and the queue buckets only serve as a journal
+// so that pending jobs survive a restart: each job is written once when it
+// is pushed, and deleted as it is retired. Claims only leave a marker in the
+// BucketRunning, so that jobs interrupted by ferryd stopping can be tidied
+// up after before they're queued again.
 type JobStore struct {
 	db     libdb.Database
-	modMut *sync.Mutex
+	modMut *sync.Mutex // Protects the queues and pending
+
+	sequential *jobQueue
+	async      *jobQueue
+	pending    map[string]*JobEntry // Every queued or running job by CorrelationID
+
+	interrupted []*JobEntry // Jobs which were running when ferryd last stopped
+
+	genMut  *sync.Mutex   // Protects gen and genChan
+	gen     uint64        // Incremented every time a job changes state
+	genChan chan struct{} // Closed and replaced on every change
+}
+
+// A jobQueue holds the jobs in one of the queues, in the order they were
+// pushed, along with the bucket journaling them
+type jobQueue struct {
+	bucket []byte
+	jobs   []*JobEntry
+}
+
+// remove will take the job with the given storage ID out of the queue
+func (q *jobQueue) remove(id []byte) *JobEntry {
+	for i, j := range q.jobs {
+		if bytes.Equal(j.id, id) {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			return j
+		}
+	}
+	return nil
+}
+
+// IdempotencyRecord stores the job queued with an idempotency key
+type IdempotencyRecord struct {
+	JobID   string
+	Type    JobType
+	Created time.Time
+}
+
+// RunningRecord marks a job as claimed
+type RunningRecord struct {
+	Claimed time.Time
 }
 
 // IndexRecord is just a simple helper to store the index record..
@@ -71,6 +142,9 @@ type IndexRecord struct {
 // NewStore creates a fully initialized JobStore and sets up Bolt Buckets as needed
 func NewStore(path string) (*JobStore, error) {
 	ctx, err := core.NewContext(path)
+	if err != nil {
+		return nil, err
+	}
 
 	// Open the database if we can
 	db, err := libdb.Open(ctx.JobDbPath)
@@ -79,8 +153,13 @@ func NewStore(path string) (*JobStore, error) {
 	}
 
 	s := &JobStore{
-		db:     db,
-		modMut: &sync.Mutex{},
+		db:         db,
+		modMut:     &sync.Mutex{},
+		sequential: &jobQueue{bucket: BucketSequentialJobs},
+		async:      &jobQueue{bucket: BucketAsyncJobs},
+		pending:    make(map[string]*JobEntry),
+		genMut:     &sync.Mutex{},
+		genChan:    make(chan struct{}),
 	}
 
 	if err := s.setup(); err != nil {
@@ -98,120 +177,198 @@ func (s *JobStore) Close() {
 	}
 }
 
-// setup is called during our early start to perform any relevant cleanup
-// and repairs from previous runs.
-func (s *JobStore) setup() error {
-	if err := s.UnclaimSequential(); err != nil {
-		return err
+// changed is called whenever a job is pushed, claimed or retired, or the
+// completion records are reset, to wake up anyone waiting on a change
+func (s *JobStore) changed() {
+	s.genMut.Lock()
+	defer s.genMut.Unlock()
+	s.gen++
+	close(s.genChan)
+	s.genChan = make(chan struct{})
+}
+
+// Generation will return the current change generation of the store
+func (s *JobStore) Generation() uint64 {
+	s.genMut.Lock()
+	defer s.genMut.Unlock()
+	return s.gen
+}
+
+// watch will return the current generation of the store, along with a
+// channel which is closed as soon as it changes
+func (s *JobStore) watch() (uint64, <-chan struct{}) {
+	s.genMut.Lock()
+	defer s.genMut.Unlock()
+	return s.gen, s.genChan
+}
+
+// WaitForChange will block until the store generation moves beyond since,
+// or the timeout expires, and return the current generation.
+func (s *JobStore) WaitForChange(since uint64, timeout time.Duration) uint64 {
+	gen, ch := s.watch()
+	if gen != since {
+		return gen
 	}
-	return s.UnclaimAsync()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+	case <-timer.C:
+	}
+	return s.Generation()
 }
 
-// unclaimJobs will mark any previously claimed jobs as unclaimed again.
-// This is only used during the initial start up ferryd as part of a
-// recovery option
-func (s *JobStore) unclaimJobs(bucketID []byte) error {
+// setup is called during our early start to load the queues back from the
+// journal. Jobs which were running when ferryd stopped remain claimed until
+// they're recovered with InterruptedJobs and Requeue.
+func (s *JobStore) setup() error {
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
 
-	return s.db.Update(func(db libdb.Database) error {
-		bucket := db.Bucket(bucketID)
-
-		// Loop all claimed jobs, unclaim them
-		return bucket.ForEach(func(id, value []byte) error {
+	for _, q := range []*jobQueue{s.sequential, s.async} {
+		bucket := s.db.Bucket(q.bucket)
+		err := bucket.ForEach(func(id, value []byte) error {
 			j := &JobEntry{}
 			if err := bucket.Decode(value, j); err != nil {
 				return err
 			}
-			if !j.Claimed {
-				return nil
-			}
+			j.id = append([]byte(nil), id...)
+			j.sequential = q == s.sequential
+			j.Claimed = false
 			j.Timing.Begin = time.Time{}
 			j.Timing.End = time.Time{}
-			j.Claimed = false
+			q.jobs = append(q.jobs, j)
+			s.pending[j.CorrelationID] = j
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
 
-			return bucket.PutObject(id, j)
+	if err := sweepIdempotencyKeys(s.db); err != nil {
+		return err
+	}
+
+	return s.db.Update(func(db libdb.Database) error {
+		bucket := db.Bucket(BucketRunning)
+		var stale [][]byte
+		err := bucket.ForEach(func(id, value []byte) error {
+			record := RunningRecord{}
+			if err := bucket.Decode(value, &record); err != nil {
+				return err
+			}
+			j := s.pending[string(id)]
+			if j == nil {
+				// The job is long gone, so there's nothing to recover
+				stale = append(stale, append([]byte(nil), id...))
+				return nil
+			}
+			j.Claimed = true
+			j.Timing.Begin = record.Claimed
+			s.interrupted = append(s.interrupted, j)
+			return nil
 		})
+		if err != nil {
+			return err
+		}
+		for _, id := range stale {
+			if err := bucket.DeleteObject(id); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
-// UnclaimSequential will find all claimed sequential jobs and unclaim them again
-func (s *JobStore) UnclaimSequential() error {
-	return s.unclaimJobs([]byte(BucketSequentialJobs))
+// InterruptedJobs will return the jobs which were running when ferryd last
+// stopped. They stay claimed until each has been passed to Requeue, or is
+// retired if there's no point running it again.
+func (s *JobStore) InterruptedJobs() []*JobEntry {
+	s.modMut.Lock()
+	defer s.modMut.Unlock()
+
+	ret := make([]*JobEntry, 0, len(s.interrupted))
+	for _, j := range s.interrupted {
+		interrupted := *j
+		ret = append(ret, &interrupted)
+	}
+	s.interrupted = nil
+	return ret
 }
 
-// UnclaimAsync will find all claimed async jobs and unclaim them again
-func (s *JobStore) UnclaimAsync() error {
-	return s.unclaimJobs([]byte(BucketAsyncJobs))
+// Requeue will unclaim an interrupted job so that it runs again
+func (s *JobStore) Requeue(j *JobEntry) error {
+	s.modMut.Lock()
+	defer s.modMut.Unlock()
+
+	if err := s.db.Bucket(BucketRunning).DeleteObject([]byte(j.CorrelationID)); err != nil {
+		return err
+	}
+	if entry := s.pending[j.CorrelationID]; entry != nil {
+		entry.Claimed = false
+		entry.Timing.Begin = time.Time{}
+	}
+	s.changed()
+	return nil
 }
 
-// claimJobInternal handles the similarity of the async/sync operations, grabbing
-// the first available job and stuffing it back in as a claimed job. Note that
-// in order to preserve order + sanity, we actually employ a mutex internally
-// to mutate the state of each job, and return them sequentially.
+// claimJobInternal handles the similarity of the async/sync operations,
+// grabbing the first available job in the queue and marking it as claimed.
+// Only the claim marker is written to disk.
+// The caller is given its own copy of the job to execute.
+//
+// Jobs are only available once every job they depend on has been retired.
+// Those depending on a failed job are failed along with it, so anything still
+// waiting here is waiting on jobs which are yet to finish.
 //
 // While more than one async job may be running at a time, we funnel job
 // claim/retire calls.
-func (s *JobStore) claimJobInternal(bucketID []byte) (*JobEntry, error) {
+func (s *JobStore) claimJobInternal(q *jobQueue) (*JobEntry, error) {
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
 
 	var job *JobEntry
-
-	err := s.db.Update(func(db libdb.Database) error {
-		bucket := db.Bucket(bucketID)
-
-		// Attempt to find relevant job, break when we have it + id
-		err := bucket.ForEach(func(id, value []byte) error {
-			j := &JobEntry{}
-			if err := bucket.Decode(value, j); err != nil {
-				return err
-			}
-			if !j.Claimed {
-				j.Claimed = true
-
-				// Got the job so mark our begin time
-				j.Timing.Begin = time.Now().UTC()
-				// Got a usable job now.
-				job = j
-				job.id = make([]byte, len(id))
-				copy(job.id, id)
-				return ErrBreakLoop
-			}
-			return nil
-		})
-
-		if err != ErrBreakLoop {
-			return err
+	for _, j := range q.jobs {
+		if j.Claimed || j.waitingOn(s.pending) {
+			continue
 		}
-
-		// Serialise the new guy
-		return bucket.PutObject(job.id, job)
-	})
-
-	if err != nil {
-		return nil, err
+		job = j
+		break
 	}
-
 	if job == nil {
 		return nil, ErrEmptyQueue
 	}
 
-	return job, nil
+	// Got the job so mark our begin time
+	now := time.Now().UTC()
+	record := &RunningRecord{Claimed: now}
+	if err := s.db.Bucket(BucketRunning).PutObject([]byte(job.CorrelationID), record); err != nil {
+		return nil, err
+	}
+	job.Claimed = true
+	job.Timing.Begin = now
+
+	s.changed()
+	ret := *job
+	return &ret, nil
 }
 
 // ClaimAsyncJob gets the first available asynchronous job, if one exists
 func (s *JobStore) ClaimAsyncJob() (*JobEntry, error) {
-	return s.claimJobInternal([]byte(BucketAsyncJobs))
+	return s.claimJobInternal(s.async)
 }
 
 // ClaimSequentialJob gets the first available synchronous job, if one exists
 func (s *JobStore) ClaimSequentialJob() (*JobEntry, error) {
-	return s.claimJobInternal([]byte(BucketSequentialJobs))
+	return s.claimJobInternal(s.sequential)
 }
 
-// Used to mark the completion of a job and store in the appropriate bucket
-func (s *JobStore) markCompletion(j *JobEntry) error {
+// markCompletion will record the retired job in the appropriate completion
+// bucket, rotating through MaxJobsStored records
+func markCompletion(db libdb.Database, j *JobEntry) error {
 	var bucketID []byte
 	if j.failure != nil {
 		bucketID = BucketFailJobs
@@ -219,9 +376,7 @@ func (s *JobStore) markCompletion(j *JobEntry) error {
 		bucketID = BucketSuccessJobs
 	}
 
-	// We're already locked at this point so its safe to work out our next
-	// index
-	bucket := s.db.Bucket(bucketID).Bucket(BucketRecord)
+	bucket := db.Bucket(bucketID).Bucket(BucketRecord)
 	record := IndexRecord{
 		Index: 0,
 	}
@@ -247,130 +402,394 @@ func (s *JobStore) markCompletion(j *JobEntry) error {
 	// now stuff it into a new key object
 	nextID := make([]byte, 8)
 	binary.BigEndian.PutUint64(nextID, record.Index)
-
-	// We'll need to figure out how to truncate our buckets..
-	return s.db.Update(func(db libdb.Database) error {
-		bucket := db.Bucket(bucketID)
-
-		storeJob := libferry.Job{
-			Timing:      j.Timing,
-			Description: j.description,
-		}
-
-		// Mark relevant failure fields
-		if j.failure != nil {
-			storeJob.Error = j.failure.Error()
-			storeJob.Failed = true
-		}
-
-		return bucket.PutObject(nextID, &storeJob)
-	})
+	return db.Bucket(bucketID).PutObject(nextID, j.Completed())
 }
 
-// RetireAsyncJob removes a completed asynchronous job
-func (s *JobStore) RetireAsyncJob(j *JobEntry) error {
+// retireJobInternal will take the completed job out of its queue, removing
+// it from the journal and recording its completion in one transaction
+func (s *JobStore) retireJobInternal(q *jobQueue, j *JobEntry) error {
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
 
 	err := s.db.Update(func(db libdb.Database) error {
-		return db.Bucket(BucketAsyncJobs).DeleteObject(j.id)
+		if err := db.Bucket(q.bucket).DeleteObject(j.id); err != nil {
+			return err
+		}
+		if err := db.Bucket(BucketRunning).DeleteObject([]byte(j.CorrelationID)); err != nil {
+			return err
+		}
+		return markCompletion(db, j)
 	})
-
 	if err != nil {
 		return err
 	}
-	return s.markCompletion(j)
+
+	if entry := q.remove(j.id); entry != nil {
+		delete(s.pending, entry.CorrelationID)
+	}
+	s.changed()
+	return nil
+}
+
+// RetireAsyncJob removes a completed asynchronous job
+func (s *JobStore) RetireAsyncJob(j *JobEntry) error {
+	return s.retireJobInternal(s.async, j)
 }
 
 // RetireSequentialJob removes a completed synchronous job
 func (s *JobStore) RetireSequentialJob(j *JobEntry) error {
+	return s.retireJobInternal(s.sequential, j)
+}
+
+// ClaimDependents will claim every queued job which depends on the job with
+// the given correlation ID, so that they can be failed in its place
+func (s *JobStore) ClaimDependents(id string) ([]*JobEntry, error) {
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
 
-	err := s.db.Update(func(db libdb.Database) error {
-		return db.Bucket(BucketSequentialJobs).DeleteObject(j.id)
-	})
-
-	if err != nil {
-		return err
+	var ret []*JobEntry
+	now := time.Now().UTC()
+	for _, q := range []*jobQueue{s.sequential, s.async} {
+		for _, j := range q.jobs {
+			if j.Claimed {
+				continue
+			}
+			for _, dep := range j.DependsOn {
+				if dep == id {
+					j.Claimed = true
+					j.Timing.Begin = now
+					claimed := *j
+					ret = append(ret, &claimed)
+					break
+				}
+			}
+		}
+	}
+	if len(ret) > 0 {
+		s.changed()
 	}
-	return s.markCompletion(j)
+	return ret, nil
 }
 
 // pushJobInternal is identical between sync and async jobs, it
-// just needs to know which bucket to store the job in.
-func (s *JobStore) pushJobInternal(j *JobEntry, bk []byte) error {
+// just needs to know which queue to store the job in. If a key is given and
+// a job was already queued with it, or the job could be merged into one that
+// is pending, nothing is pushed and the ID of that job is returned instead.
+func (s *JobStore) pushJobInternal(j *JobEntry, q *jobQueue, key string) (string, error) {
 	// Prep the job prior to insertion
 	j.Timing.Queued = time.Now().UTC()
 	j.Claimed = false
-
-	j.id = s.db.Bucket(bk).NextSequence()
+	j.sequential = q == s.sequential
+	if j.CorrelationID == "" {
+		j.CorrelationID = newCorrelationID()
+	}
 
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
 
-	return s.db.Update(func(db libdb.Database) error {
-		bucket := db.Bucket(bk)
-		// Use next natural sequence in the bucket
+	var existing string
+	var into, replaced *JobEntry
+	var merged []string
+	err := s.db.Update(func(db libdb.Database) error {
+		var err error
+		if key != "" {
+			if existing, err = findIdempotencyKey(db, key, j); err != nil || existing != "" {
+				return err
+			}
+		}
+
+		bucket := db.Bucket(q.bucket)
+		into, replaced = coalesceJob(q.jobs, j, j.sequential)
+		switch {
+		case into != nil:
+			existing = into.CorrelationID
+			update := *into
+			update.DependsOn = append([]string(nil), into.DependsOn...)
+			if update.mergeDependencies(j) {
+				merged = update.DependsOn
+				if err = bucket.PutObject(update.id, &update); err != nil {
+					return err
+				}
+			}
+		case replaced != nil:
+			if err = bucket.DeleteObject(replaced.id); err != nil {
+				return err
+			}
+		}
+		if existing == "" {
+			// Use next natural sequence in the bucket
+			j.id = bucket.NextSequence()
+			if err = bucket.PutObject(j.id, j); err != nil {
+				return err
+			}
+		}
 
-		j.id = bucket.NextSequence()
-		return bucket.PutObject(j.id, j)
+		if key == "" {
+			return nil
+		}
+		jobID := existing
+		if jobID == "" {
+			jobID = j.CorrelationID
+		}
+		return recordIdempotencyKey(db, key, j.Type, jobID)
 	})
+	if err != nil {
+		return "", err
+	}
+	if existing != "" {
+		if merged != nil {
+			into.DependsOn = merged
+		}
+		return existing, nil
+	}
+
+	if replaced != nil {
+		q.remove(replaced.id)
+	}
+	entry := *j
+	q.jobs = append(q.jobs, &entry)
+	s.pending[entry.CorrelationID] = &entry
+	s.changed()
+	return j.CorrelationID, nil
+}
+
+// expiryKey will order the idempotency key by the time it was recorded
+func expiryKey(created time.Time, key string) []byte {
+	ret := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(ret, uint64(created.UnixNano()))
+	return append(ret, key...)
+}
+
+// pruneIdempotencyKeys will forget every key recorded before the
+// IdempotencyWindow, visiting only those which have expired
+func pruneIdempotencyKeys(db libdb.Database, now time.Time) error {
+	bucket := db.Bucket(BucketIdempotency)
+	expiry := bucket.Bucket(BucketIdempotencyExpiry)
+	cutoff := expiryKey(now.Add(-IdempotencyWindow), "")
+
+	var expired [][]byte
+	err := expiry.ForEachRange(nil, cutoff, func(id, value []byte) error {
+		expired = append(expired, append([]byte(nil), id...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range expired {
+		if err := expiry.DeleteObject(id); err != nil {
+			return err
+		}
+		// The key may have been recorded again since
+		key := id[8:]
+		record := IdempotencyRecord{}
+		if err := bucket.GetObject(key, &record); err != nil {
+			if err == libdb.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		if bytes.Equal(expiryKey(record.Created, ""), id[:8]) {
+			if err := bucket.DeleteObject(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sweepIdempotencyKeys is called once during setup to forget any expired
+// keys which were recorded before the expiry subbucket existed
+func sweepIdempotencyKeys(db libdb.Database) error {
+	return db.Update(func(db libdb.Database) error {
+		bucket := db.Bucket(BucketIdempotency)
+		now := time.Now().UTC()
+
+		var expired [][]byte
+		err := bucket.ForEach(func(id, value []byte) error {
+			record := IdempotencyRecord{}
+			if err := bucket.Decode(value, &record); err != nil {
+				return err
+			}
+			if now.Sub(record.Created) > IdempotencyWindow {
+				expired = append(expired, append([]byte(nil), id...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := bucket.DeleteObject(id); err != nil {
+				return err
+			}
+		}
+		return pruneIdempotencyKeys(db, now)
+	})
+}
+
+// findIdempotencyKey will return the ID of the job already queued with the
+// key, if any. Keys that have outlived the IdempotencyWindow are forgotten
+// on the way.
+func findIdempotencyKey(db libdb.Database, key string, j *JobEntry) (string, error) {
+	now := time.Now().UTC()
+	if err := pruneIdempotencyKeys(db, now); err != nil {
+		return "", err
+	}
+
+	record := IdempotencyRecord{}
+	err := db.Bucket(BucketIdempotency).GetObject([]byte(key), &record)
+	if err == nil && now.Sub(record.Created) <= IdempotencyWindow {
+		if record.Type != j.Type {
+			return "", ErrIdempotencyConflict
+		}
+		return record.JobID, nil
+	}
+	if err != nil && err != libdb.ErrNotFound {
+		return "", err
+	}
+	return "", nil
+}
+
+// recordIdempotencyKey will remember that the key queued the job
+func recordIdempotencyKey(db libdb.Database, key string, jobType JobType, jobID string) error {
+	record := IdempotencyRecord{
+		JobID:   jobID,
+		Type:    jobType,
+		Created: time.Now().UTC(),
+	}
+	bucket := db.Bucket(BucketIdempotency)
+	if err := bucket.PutObject([]byte(key), &record); err != nil {
+		return err
+	}
+	return bucket.Bucket(BucketIdempotencyExpiry).PutObject(expiryKey(record.Created, key), &record.Created)
 }
 
 // PushSequentialJob will enqueue a new sequential job
 func (s *JobStore) PushSequentialJob(j *JobEntry) error {
-	return s.pushJobInternal(j, BucketSequentialJobs)
+	_, err := s.pushJobInternal(j, s.sequential, "")
+	return err
 }
 
 // PushAsyncJob will enqueue a new asynchronous job
 func (s *JobStore) PushAsyncJob(j *JobEntry) error {
-	return s.pushJobInternal(j, BucketAsyncJobs)
+	_, err := s.pushJobInternal(j, s.async, "")
+	return err
+}
+
+// PushJobOnce will enqueue the job unless one has already been queued with
+// the same idempotency key within the IdempotencyWindow, returning the ID
+// of whichever job is queued for the key
+func (s *JobStore) PushJobOnce(j *JobEntry, key string) (string, error) {
+	if j.sequential {
+		return s.pushJobInternal(j, s.sequential, key)
+	}
+	return s.pushJobInternal(j, s.async, key)
 }
 
 // ActiveJobs will attempt to return a list of active jobs within
 // the scheduler suitable for consumption by the CLI client
 func (s *JobStore) ActiveJobs() ([]*libferry.Job, error) {
-	var ret []*libferry.Job
-
-	if err := s.cloneCurrentJobs(&ret, []byte(BucketSequentialJobs)); err != nil {
-		return nil, err
-	}
+	s.modMut.Lock()
+	defer s.modMut.Unlock()
 
-	if err := s.cloneCurrentJobs(&ret, []byte(BucketAsyncJobs)); err != nil {
-		return nil, err
+	var ret []*libferry.Job
+	for _, q := range []*jobQueue{s.sequential, s.async} {
+		for _, j := range q.jobs {
+			hnd, err := NewJobHandler(j)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, &libferry.Job{
+				ID:          j.CorrelationID,
+				ParentID:    j.ParentID,
+				DependsOn:   j.DependsOn,
+				Description: hnd.Describe(),
+				Type:        string(j.Type),
+				Repo:        jobRepo(j, hnd),
+				Timing:      j.Timing,
+			})
+		}
 	}
-
 	return ret, nil
 }
 
-// cloneCurrentJobs will push clones of our jobs out to the libferry API
-func (s *JobStore) cloneCurrentJobs(ret *[]*libferry.Job, bucketID []byte) error {
+// QueueLengths will return the number of jobs currently in the sequential
+// and async queues, including any jobs that are being executed
+func (s *JobStore) QueueLengths() (sequential int, async int, err error) {
 	s.modMut.Lock()
 	defer s.modMut.Unlock()
+	return len(s.sequential.jobs), len(s.async.jobs), nil
+}
 
-	return s.db.Bucket(bucketID).View(func(db libdb.ReadOnlyView) error {
-		return db.ForEach(func(k, v []byte) error {
-			j := &JobEntry{}
-			if err := db.Decode(v, j); err != nil {
-				return err
-			}
+// FamilySize will return the number of queued or running jobs which belong
+// to the given family, i.e. the parent job and all of its children
+func (s *JobStore) FamilySize(family string) (int, error) {
+	s.modMut.Lock()
+	defer s.modMut.Unlock()
 
-			// Now stuff the job into the ret
-			hnd, err := NewJobHandler(j)
-			if err != nil {
-				return err
-			}
+	count := 0
+	for _, j := range s.pending {
+		if j.Family() == family {
+			count++
+		}
+	}
+	return count, nil
+}
 
-			r := &libferry.Job{
-				Description: hnd.Describe(),
-				Timing:      j.Timing,
-			}
-			*ret = append(*ret, r)
+// GetJob will find the job with the given correlation ID, whether it is
+// still queued, running or has been retired. Retired jobs can only be found
+// while they remain in the completion records.
+func (s *JobStore) GetJob(id string) (*libferry.Job, libferry.JobState, error) {
+	s.modMut.Lock()
+	defer s.modMut.Unlock()
 
-			return nil
+	if j := s.pending[id]; j != nil {
+		hnd, err := NewJobHandler(j)
+		if err != nil {
+			return nil, "", err
+		}
+		ret := &libferry.Job{
+			ID:          j.CorrelationID,
+			ParentID:    j.ParentID,
+			DependsOn:   j.DependsOn,
+			Description: hnd.Describe(),
+			Type:        string(j.Type),
+			Repo:        jobRepo(j, hnd),
+			Timing:      j.Timing,
+		}
+		if j.Claimed {
+			return ret, libferry.JobRunning, nil
+		}
+		return ret, libferry.JobQueued, nil
+	}
+
+	var ret *libferry.Job
+	for _, bucketID := range [][]byte{BucketSuccessJobs, BucketFailJobs} {
+		err := s.db.Bucket(bucketID).View(func(db libdb.ReadOnlyView) error {
+			return db.ForEach(func(k, v []byte) error {
+				j := &libferry.Job{}
+				if err := db.Decode(v, j); err != nil {
+					return err
+				}
+				if j.ID != id {
+					return nil
+				}
+				ret = j
+				return ErrBreakLoop
+			})
 		})
-	})
+		if err != nil && err != ErrBreakLoop {
+			return nil, "", err
+		}
+		if ret != nil {
+			if ret.Failed {
+				return ret, libferry.JobFailed, nil
+			}
+			return ret, libferry.JobCompleted, nil
+		}
+	}
+
+	return nil, "", ErrUnknownJob
 }
 
 // CompletedJobs will return all successfully completed jobs still stored
@@ -428,11 +847,13 @@ func (s *JobStore) resetInternal(bucketID []byte) error {
 	}
 
 	// batch delete the jobs
-	return s.db.Bucket(bucketID).Update(func(db libdb.Database) error {
+	err = s.db.Bucket(bucketID).Update(func(db libdb.Database) error {
 		return db.ForEach(func(k, v []byte) error {
 			return db.DeleteObject(k)
 		})
 	})
+	s.changed()
+	return err
 }
 
 // ResetCompleted will remove all completion records from our store and reset the pointer
@@ -0,0 +1,247 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"io/ioutil"
+	"libdb"
+	"libferry"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestStore will open a job store within a new temporary directory,
+// returning the directory so that the store can be opened again
+func openTestStore(t *testing.T) (*JobStore, string) {
+	dir, err := ioutil.TempDir("", "jobstore")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	store, err := NewStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to open job store: %v", err)
+	}
+	return store, dir
+}
+
+// pushTestJob will push the job onto the queue it belongs in
+func pushTestJob(t *testing.T, store *JobStore, j *JobEntry) string {
+	id, err := store.PushJobOnce(j, "")
+	if err != nil {
+		t.Fatalf("Failed to push %s job: %v", j.Type, err)
+	}
+	return id
+}
+
+func TestStoreReplay(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+
+	create := pushTestJob(t, store, NewCreateRepoJob("unstable"))
+	index := NewIndexRepoJob("unstable")
+	index.DependsOn = []string{create}
+	pushTestJob(t, store, index)
+	delta := pushTestJob(t, store, NewDeltaJob("unstable", "nano", ""))
+
+	claimed, err := store.ClaimSequentialJob()
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if claimed.CorrelationID != create {
+		t.Fatalf("Claimed %s, expected %s", claimed.CorrelationID, create)
+	}
+	store.Close()
+
+	if store, err = NewStore(dir); err != nil {
+		t.Fatalf("Failed to open job store again: %v", err)
+	}
+	defer store.Close()
+
+	sequential, async, err := store.QueueLengths()
+	if err != nil {
+		t.Fatalf("Failed to count jobs: %v", err)
+	}
+	if sequential != 2 || async != 1 {
+		t.Fatalf("Expected 2 sequential and 1 async job, got %d and %d", sequential, async)
+	}
+	job, state, err := store.GetJob(index.CorrelationID)
+	if err != nil {
+		t.Fatalf("Failed to find index job: %v", err)
+	}
+	if state != libferry.JobQueued || len(job.DependsOn) != 1 || job.DependsOn[0] != create {
+		t.Fatalf("Index job wasn't replayed with its dependency: %s %v", state, job.DependsOn)
+	}
+	if _, state, err = store.GetJob(delta); err != nil || state != libferry.JobQueued {
+		t.Fatalf("Delta job wasn't replayed: %s %v", state, err)
+	}
+
+	// The claimed job stays claimed until it's recovered
+	interrupted := store.InterruptedJobs()
+	if len(interrupted) != 1 || interrupted[0].CorrelationID != create {
+		t.Fatalf("Expected the create job to be interrupted, got %v", interrupted)
+	}
+	if _, state, _ = store.GetJob(create); state != libferry.JobRunning {
+		t.Fatalf("Interrupted job should be running until requeued, got %s", state)
+	}
+	if _, err = store.ClaimSequentialJob(); err != ErrEmptyQueue {
+		t.Fatalf("Nothing should be claimable before the job is requeued: %v", err)
+	}
+	if err = store.Requeue(interrupted[0]); err != nil {
+		t.Fatalf("Failed to requeue job: %v", err)
+	}
+	if claimed, err = store.ClaimSequentialJob(); err != nil || claimed.CorrelationID != create {
+		t.Fatalf("Requeued job wasn't claimed again: %v", err)
+	}
+}
+
+func TestStoreDependencies(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	create := pushTestJob(t, store, NewCreateRepoJob("unstable"))
+	delta := NewDeltaJob("unstable", "nano", "")
+	delta.DependsOn = []string{create}
+	pushTestJob(t, store, delta)
+
+	// The async job waits on the sequential queue
+	if _, err := store.ClaimAsyncJob(); err != ErrEmptyQueue {
+		t.Fatalf("Job was claimed before its dependency finished: %v", err)
+	}
+	claimed, err := store.ClaimSequentialJob()
+	if err != nil {
+		t.Fatalf("Failed to claim job: %v", err)
+	}
+	if _, err = store.ClaimAsyncJob(); err != ErrEmptyQueue {
+		t.Fatalf("Job was claimed while its dependency was running: %v", err)
+	}
+	if err = store.RetireSequentialJob(claimed); err != nil {
+		t.Fatalf("Failed to retire job: %v", err)
+	}
+	if claimed, err = store.ClaimAsyncJob(); err != nil {
+		t.Fatalf("Job wasn't claimable once its dependency finished: %v", err)
+	}
+	if claimed.CorrelationID != delta.CorrelationID {
+		t.Fatalf("Claimed %s, expected %s", claimed.CorrelationID, delta.CorrelationID)
+	}
+
+	// Dependents of a failed job are claimed so they can fail with it
+	create = pushTestJob(t, store, NewCreateRepoJob("stable"))
+	index := NewIndexRepoJob("stable")
+	index.DependsOn = []string{create}
+	pushTestJob(t, store, index)
+	dependents, err := store.ClaimDependents(create)
+	if err != nil {
+		t.Fatalf("Failed to claim dependents: %v", err)
+	}
+	if len(dependents) != 1 || dependents[0].CorrelationID != index.CorrelationID {
+		t.Fatalf("Expected the index job to be claimed, got %v", dependents)
+	}
+}
+
+func TestStoreCoalesce(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	// Any pending async duplicate will do
+	first := pushTestJob(t, store, NewDeltaJob("unstable", "nano", ""))
+	pushTestJob(t, store, NewDeltaJob("unstable", "vim", ""))
+	if id := pushTestJob(t, store, NewDeltaJob("unstable", "nano", "")); id != first {
+		t.Fatalf("Duplicate delta job wasn't merged: %s != %s", id, first)
+	}
+
+	// Other sequential jobs are only merged at the end of the queue
+	create := pushTestJob(t, store, NewCreateRepoJob("unstable"))
+	if id := pushTestJob(t, store, NewCreateRepoJob("unstable")); id != create {
+		t.Fatalf("Duplicate create job at the end of the queue wasn't merged")
+	}
+	index := pushTestJob(t, store, NewIndexRepoJob("unstable"))
+	if id := pushTestJob(t, store, NewCreateRepoJob("unstable")); id == create {
+		t.Fatalf("Create job was merged into a duplicate earlier in the queue")
+	}
+
+	// Whereas an index takes over the pending one and moves to the end
+	if id := pushTestJob(t, store, NewIndexRepoJob("unstable")); id != index {
+		t.Fatalf("Index job didn't take over the pending index: %s != %s", id, index)
+	}
+	active, err := store.ActiveJobs()
+	if err != nil {
+		t.Fatalf("Failed to list jobs: %v", err)
+	}
+	var types []string
+	for _, job := range active {
+		types = append(types, job.Type)
+	}
+	want := []string{string(CreateRepo), string(CreateRepo), string(IndexRepo), string(Delta), string(Delta)}
+	if !sameStrings(types, want) {
+		t.Fatalf("Expected queue %v, got %v", want, types)
+	}
+	if active[2].ID != index {
+		t.Fatalf("Index job at the end of the queue has ID %s, expected %s", active[2].ID, index)
+	}
+}
+
+func TestStoreIdempotency(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	first, err := store.PushJobOnce(NewCreateRepoJob("unstable"), "create-unstable")
+	if err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+	// The key wins over whatever the job would do
+	id, err := store.PushJobOnce(NewCreateRepoJob("stable"), "create-unstable")
+	if err != nil {
+		t.Fatalf("Failed to push job again: %v", err)
+	}
+	if id != first {
+		t.Fatalf("Pushing with the same key gave %s, expected %s", id, first)
+	}
+	if sequential, _, _ := store.QueueLengths(); sequential != 1 {
+		t.Fatalf("Expected 1 queued job, got %d", sequential)
+	}
+	if _, err = store.PushJobOnce(NewIndexRepoJob("unstable"), "create-unstable"); err != ErrIdempotencyConflict {
+		t.Fatalf("Reusing the key for another job type should conflict: %v", err)
+	}
+
+	// Expired keys are forgotten the next time a key is pushed
+	created := time.Now().UTC().Add(-IdempotencyWindow - time.Hour)
+	bucket := store.db.Bucket(BucketIdempotency)
+	record := IdempotencyRecord{JobID: "0123456789abcdef", Type: IndexRepo, Created: created}
+	if err = bucket.PutObject([]byte("expired"), &record); err != nil {
+		t.Fatalf("Failed to store key: %v", err)
+	}
+	if err = bucket.Bucket(BucketIdempotencyExpiry).PutObject(expiryKey(created, "expired"), &created); err != nil {
+		t.Fatalf("Failed to store key expiry: %v", err)
+	}
+	if _, err = store.PushJobOnce(NewIndexRepoJob("unstable"), "index-unstable"); err != nil {
+		t.Fatalf("Failed to push job: %v", err)
+	}
+	if err = bucket.GetObject([]byte("expired"), &record); err != libdb.ErrNotFound {
+		t.Fatalf("Expired key wasn't forgotten: %v", err)
+	}
+	if has, _ := bucket.Bucket(BucketIdempotencyExpiry).HasObject(expiryKey(created, "expired")); has {
+		t.Fatalf("Expiry of the forgotten key wasn't removed")
+	}
+	if id, err = store.PushJobOnce(NewCreateRepoJob("stable"), "create-unstable"); err != nil || id != first {
+		t.Fatalf("Key still within the window was forgotten: %s %v", id, err)
+	}
+}
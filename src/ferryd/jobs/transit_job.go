@@ -26,58 +26,201 @@ import (
 
 // TransitJobHandler is responsible for accepting new upload payloads in the repository
 type TransitJobHandler struct {
+	logger   *log.Entry // Scoped to the job being executed
+	stats    *JobStats  // Record which packages were imported
+	jobID    string     // Recorded in the provenance of the packages
 	path     string
+	repoID   string // Set when uploaded to a repository's own incoming directory
 	manifest *core.TransitManifest
 }
 
-// NewTransitJob will return a job suitable for adding to the job processor
-func NewTransitJob(path string) *JobEntry {
+// NewTransitJob will return a job suitable for adding to the job processor.
+// When repoID is set the manifest must target that repository.
+func NewTransitJob(path, repoID string) *JobEntry {
+	params := []string{path}
+	if repoID != "" {
+		params = append(params, repoID)
+	}
 	return &JobEntry{
 		sequential: true,
 		Type:       TransitProcess,
-		Params:     []string{path},
+		Params:     params,
 	}
 }
 
 // NewTransitJobHandler will create a job handler for the input job and ensure it validates
 func NewTransitJobHandler(j *JobEntry) (*TransitJobHandler, error) {
-	if len(j.Params) != 1 {
+	if len(j.Params) < 1 || len(j.Params) > 2 {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
-	return &TransitJobHandler{
-		path: j.Params[0],
-	}, nil
+	handler := &TransitJobHandler{
+		logger: j.Logger(),
+		stats:  j.Stats(),
+		jobID:  j.CorrelationID,
+		path:   j.Params[0],
+	}
+	if len(j.Params) == 2 {
+		handler.repoID = j.Params[1]
+	}
+	return handler, nil
 }
 
-// Execute will process incoming .tram files for potential repo inclusion
-func (j *TransitJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+// load will read the manifest and ensure it may be imported, returning the
+// provenance to record for its packages
+func (j *TransitJobHandler) load(manager *core.Manager) (*core.Provenance, error) {
 	tram, err := core.NewTransitManifest(j.path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if err = tram.ValidatePayload(); err != nil {
-		return err
+		return nil, err
 	}
 
 	j.manifest = tram
 
 	// Sanity.
 	repo := j.manifest.Manifest.Target
+	if j.repoID != "" && repo != j.repoID {
+		return nil, fmt.Errorf("manifest targets '%s' but was uploaded for '%s'", repo, j.repoID)
+	}
 	if _, err := manager.GetRepo(repo); err != nil {
+		return nil, err
+	}
+
+	// The manifest is written last, so its upload time stands for the payload
+	st, err := os.Stat(j.path)
+	if err != nil {
+		return nil, err
+	}
+	return &core.Provenance{
+		Builder:  tram.Builder.Name,
+		Build:    tram.Builder.Build,
+		Manifest: tram.ID(),
+		Uploaded: st.ModTime().UTC(),
+		JobID:    j.jobID,
+	}, nil
+}
+
+// Execute will process incoming .tram files for potential repo inclusion
+func (j *TransitJobHandler) Execute(jproc *Processor, manager *core.Manager) error {
+	prov, err := j.load(manager)
+	if err != nil {
 		return err
 	}
 
-	// Now try to merge into the repo
-	pkgs := tram.GetPaths()
-	if err = manager.AddPackages(repo, pkgs, true); err != nil {
+	// Now try to merge into the repo. Uploads tend to arrive in bursts, so
+	// the index is left to the debouncer.
+	repo := j.manifest.Manifest.Target
+	if err = manager.StagePackages(repo, j.manifest.GetPaths(), true, prov); err != nil {
+		return err
+	}
+	if err = jproc.ScheduleIndex(repo); err != nil {
 		return err
 	}
 
-	log.WithFields(log.Fields{
-		"target": repo,
-		"id":     j.manifest.ID(),
-	}).Info("Successfully processed manifest upload")
+	return j.finish(jproc, manager)
+}
+
+// Recover will work out how far an interrupted import got. The manifest is
+// only removed once everything else is done, so without it there's nothing
+// left to do. If only some of the packages made it into the repository, the
+// rest are imported here, as running the job again would refuse those which
+// are already there.
+func (j *TransitJobHandler) Recover(jproc *Processor, manager *core.Manager) (bool, error) {
+	if !core.PathExists(j.path) {
+		j.logger.Info("Manifest was already processed")
+		return true, nil
+	}
+	tram, err := core.NewTransitManifest(j.path)
+	if err != nil {
+		// Execute will fail for the same reason
+		return false, nil
+	}
+
+	repo := tram.Manifest.Target
+	pkgs := tram.GetPaths()
+	var remaining []string
+	for _, pkg := range pkgs {
+		has, err := manager.HasPackage(repo, pkg)
+		if err != nil {
+			return false, nil
+		}
+		if !has {
+			remaining = append(remaining, pkg)
+		}
+	}
+	if len(remaining) == len(pkgs) {
+		// Nothing made it in, so it may as well run again
+		return false, nil
+	}
+
+	j.logger.WithFields(log.Fields{
+		"id":        tram.ID(),
+		"remaining": len(remaining),
+	}).Info("Completing partially imported manifest")
+
+	if len(remaining) == 0 {
+		// The upload may have been partly removed already, so it can't be
+		// validated again
+		j.manifest = tram
+		if err := manager.Index(repo); err != nil {
+			return false, err
+		}
+		return true, j.finish(jproc, manager)
+	}
+
+	prov, err := j.load(manager)
+	if err != nil {
+		return false, err
+	}
+	if err = manager.AddPackages(repo, remaining, true, prov); err != nil {
+		return false, err
+	}
+	return true, j.finish(jproc, manager)
+}
+
+// finish will remove the upload once its packages are in the repository,
+// and schedule deltas for them
+func (j *TransitJobHandler) finish(jproc *Processor, manager *core.Manager) error {
+	tram := j.manifest
+	repo := tram.Manifest.Target
+	pkgs := tram.GetPaths()
+
+	fields := log.Fields{
+		"target":  repo,
+		"id":      j.manifest.ID(),
+		"version": tram.Manifest.Version,
+	}
+	if tram.Builder.Name != "" {
+		fields["builder"] = tram.Builder.Name
+	}
+	if tram.Builder.Build != "" {
+		fields["build"] = tram.Builder.Build
+	}
+	j.logger.WithFields(fields).Info("Successfully processed manifest upload")
+	j.stats.addPoolPackages(manager, pkgs)
+
+	// Component hints are only advisory, but a mismatch usually means that
+	// the builder and the package disagree on what was built
+	for i := range tram.File {
+		f := &tram.File[i]
+		if f.Component == "" {
+			continue
+		}
+		meta, err := manager.GetPoolEntry(f.Path)
+		if err != nil {
+			continue
+		}
+		if meta.PartOf != f.Component {
+			j.logger.WithFields(log.Fields{
+				"id":        j.manifest.ID(),
+				"file":      f.Path,
+				"component": meta.PartOf,
+				"hint":      f.Component,
+			}).Warning("Package component differs from the manifest")
+		}
+	}
 
 	// Append the manifest path because now we'll want to delete these
 	pkgs = append(pkgs, j.path)
@@ -87,7 +230,7 @@ func (j *TransitJobHandler) Execute(jproc *Processor, manager *core.Manager) err
 			continue
 		}
 		if err := os.Remove(p); err != nil {
-			log.WithFields(log.Fields{
+			j.logger.WithFields(log.Fields{
 				"file":  p,
 				"id":    j.manifest.ID(),
 				"error": err,
@@ -103,7 +246,7 @@ func (j *TransitJobHandler) Execute(jproc *Processor, manager *core.Manager) err
 		pkgID := filepath.Base(pkg)
 		p, ent := manager.GetPoolEntry(pkgID)
 		if ent != nil {
-			return err
+			return nil
 		}
 		jproc.PushJob(NewDeltaIndexJob(repo, p.Name))
 	}
@@ -111,6 +254,16 @@ func (j *TransitJobHandler) Execute(jproc *Processor, manager *core.Manager) err
 	return nil
 }
 
+// MutatedRepos returns the target repository of the manifest
+func (j *TransitJobHandler) MutatedRepos() []string {
+	tram, err := core.NewTransitManifest(j.path)
+	if err != nil {
+		// Execute will fail for the same reason
+		return nil
+	}
+	return []string{tram.Manifest.Target}
+}
+
 // Describe returns a human readable description for this job
 func (j *TransitJobHandler) Describe() string {
 	if j.manifest == nil {
@@ -0,0 +1,68 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrimDeltasJobHandler is responsible for removing stale deltas and should
+// only ever be used in sequential queues.
+type TrimDeltasJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	repoID string
+}
+
+// NewTrimDeltasJob will return a job suitable for adding to the job processor
+func NewTrimDeltasJob(id string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       TrimDeltas,
+		Params:     []string{id},
+	}
+}
+
+// NewTrimDeltasJobHandler will create a job handler for the input job and ensure it validates
+func NewTrimDeltasJobHandler(j *JobEntry) (*TrimDeltasJobHandler, error) {
+	if len(j.Params) != 1 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &TrimDeltasJobHandler{
+		logger: j.Logger(),
+		repoID: j.Params[0],
+	}, nil
+}
+
+// Execute will remove any deltas which no longer lead to a published package
+func (j *TrimDeltasJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	removed, err := manager.InvalidateDeltas(j.repoID)
+	if err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{
+		"repo":   j.repoID,
+		"deltas": len(removed),
+	}).Info("Trimmed stale deltas in repository")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *TrimDeltasJobHandler) Describe() string {
+	return fmt.Sprintf("Trim stale deltas from repository '%s'", j.repoID)
+}
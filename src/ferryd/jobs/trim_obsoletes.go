@@ -25,6 +25,8 @@ import (
 // TrimObsoleteJobHandler is responsible for indexing repositories and should only
 // ever be used in sequential queues.
 type TrimObsoleteJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	jobID  string     // Names the undo snapshot
 	repoID string
 }
 
@@ -43,19 +45,29 @@ func NewTrimObsoleteJobHandler(j *JobEntry) (*TrimObsoleteJobHandler, error) {
 		return nil, fmt.Errorf("job has invalid parameters")
 	}
 	return &TrimObsoleteJobHandler{
+		logger: j.Logger(),
+		jobID:  j.CorrelationID,
 		repoID: j.Params[0],
 	}, nil
 }
 
 // Execute will try to remove any excessive packages marked as Obsolete
 func (j *TrimObsoleteJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.CreateUndoSnapshot(j.repoID, j.jobID, j.Describe()); err != nil {
+		return err
+	}
 	if err := manager.TrimObsolete(j.repoID); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{"repo": j.repoID}).Info("Trimmed obsoletes in repository")
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Trimmed obsoletes in repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *TrimObsoleteJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *TrimObsoleteJobHandler) Describe() string {
 	return fmt.Sprintf("Trim obsoletes from repository '%s'", j.repoID)
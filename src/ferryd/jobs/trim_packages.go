@@ -25,6 +25,8 @@ import (
 
 // TrimPackagesJobHandler is responsible for removing packages by identifiers
 type TrimPackagesJobHandler struct {
+	logger  *log.Entry // Scoped to the job being executed
+	jobID   string     // Names the undo snapshot
 	repoID  string
 	maxKeep int
 }
@@ -48,6 +50,8 @@ func NewTrimPackagesJobHandler(j *JobEntry) (*TrimPackagesJobHandler, error) {
 		return nil, err
 	}
 	return &TrimPackagesJobHandler{
+		logger:  j.Logger(),
+		jobID:   j.CorrelationID,
 		repoID:  j.Params[0],
 		maxKeep: int(keep),
 	}, nil
@@ -55,16 +59,24 @@ func NewTrimPackagesJobHandler(j *JobEntry) (*TrimPackagesJobHandler, error) {
 
 // Execute will attempt removal of excessive packages in the index
 func (j *TrimPackagesJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.CreateUndoSnapshot(j.repoID, j.jobID, j.Describe()); err != nil {
+		return err
+	}
 	if err := manager.TrimPackages(j.repoID, j.maxKeep); err != nil {
 		return err
 	}
-	log.WithFields(log.Fields{
+	j.logger.WithFields(log.Fields{
 		"repo":    j.repoID,
 		"maxKeep": j.maxKeep,
 	}).Info("Trimmed packages in repository")
 	return nil
 }
 
+// MutatedRepos returns the repository modified by this job
+func (j *TrimPackagesJobHandler) MutatedRepos() []string {
+	return []string{j.repoID}
+}
+
 // Describe returns a human readable description for this job
 func (j *TrimPackagesJobHandler) Describe() string {
 	return fmt.Sprintf("Trim packages to maximum of %d in '%s'", j.maxKeep, j.repoID)
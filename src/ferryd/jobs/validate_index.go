@@ -0,0 +1,64 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// ValidateIndexJobHandler is responsible for checking a published index and should
+// only ever be used in sequential queues.
+type ValidateIndexJobHandler struct {
+	logger *log.Entry // Scoped to the job being executed
+	repoID string
+}
+
+// NewValidateIndexJob will return a job suitable for adding to the job processor
+func NewValidateIndexJob(id string) *JobEntry {
+	return &JobEntry{
+		sequential: true,
+		Type:       ValidateIndex,
+		Params:     []string{id},
+	}
+}
+
+// NewValidateIndexJobHandler will create a job handler for the input job and ensure it validates
+func NewValidateIndexJobHandler(j *JobEntry) (*ValidateIndexJobHandler, error) {
+	if len(j.Params) != 1 {
+		return nil, fmt.Errorf("job has invalid parameters")
+	}
+	return &ValidateIndexJobHandler{
+		logger: j.Logger(),
+		repoID: j.Params[0],
+	}, nil
+}
+
+// Execute will validate the published index against the repository tree
+func (j *ValidateIndexJobHandler) Execute(_ *Processor, manager *core.Manager) error {
+	if err := manager.ValidateIndex(j.repoID); err != nil {
+		return err
+	}
+	j.logger.WithFields(log.Fields{"repo": j.repoID}).Info("Validated repository index")
+	return nil
+}
+
+// Describe returns a human readable description for this job
+func (j *ValidateIndexJobHandler) Describe() string {
+	return fmt.Sprintf("Validate index of repository '%s'", j.repoID)
+}
@@ -0,0 +1,158 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// watchdogInterval is how often the watchdog looks over the running jobs
+const watchdogInterval = 30 * time.Second
+
+// A JobLimit controls how long a type of job should run for
+type JobLimit struct {
+
+	// Expected is the longest the job should normally take. The watchdog
+	// flags jobs running for longer, unless it is 0.
+	Expected time.Duration
+
+	// Timeout is how long the job may run before it is asked to stop, or 0
+	// to let it run forever. Stopping relies on the handler checking in, so
+	// a job blocked on I/O can only be flagged.
+	Timeout time.Duration
+}
+
+// DefaultJobLimits holds the limits for every type of job, before any
+// configured overrides. Only delta production stops when asked to, which
+// is why few jobs have a timeout.
+var DefaultJobLimits = map[JobType]JobLimit{
+	BulkAdd:         {Expected: 30 * time.Minute},
+	CleanTemp:       {Expected: 15 * time.Minute},
+	CopySource:      {Expected: 5 * time.Minute},
+	CloneRepo:       {Expected: 30 * time.Minute},
+	CreateRepo:      {Expected: time.Minute},
+	CreateSnapshot:  {Expected: 5 * time.Minute},
+	DeleteRepo:      {Expected: 15 * time.Minute},
+	DeleteSnapshot:  {Expected: 5 * time.Minute},
+	Delta:           {Expected: 5 * time.Minute},
+	DeltaIndex:      {Expected: 5 * time.Minute},
+	DeltaPair:       {Expected: 30 * time.Minute, Timeout: 2 * time.Hour},
+	DeltaRepo:       {Expected: 15 * time.Minute},
+	ImportDirectory: {Expected: 30 * time.Minute},
+	ImportRepo:      {Expected: time.Hour},
+	IndexRepo:       {Expected: 15 * time.Minute},
+	MigratePool:     {Expected: 2 * time.Hour},
+	PromoteSource:   {Expected: 5 * time.Minute},
+	PullRepo:        {Expected: 30 * time.Minute},
+	PullSource:      {Expected: 5 * time.Minute},
+	RelinkRepo:      {Expected: time.Hour},
+	RemoveSource:    {Expected: 5 * time.Minute},
+	ReplicateRepo:   {Expected: time.Hour},
+	RestoreSnapshot: {Expected: 15 * time.Minute},
+	RewriteMetadata: {Expected: 5 * time.Minute},
+	TransitProcess:  {Expected: 15 * time.Minute},
+	TrimDeltas:      {Expected: 15 * time.Minute},
+	TrimObsolete:    {Expected: 15 * time.Minute},
+	TrimPackages:    {Expected: 15 * time.Minute},
+	ValidateIndex:   {Expected: 15 * time.Minute},
+}
+
+// SetJobLimits will override the default limits for the given job types.
+// Jobs which are already running keep the limits they started with.
+func (j *Processor) SetJobLimits(limits map[JobType]JobLimit) {
+	ret := make(map[JobType]JobLimit, len(DefaultJobLimits))
+	for t, limit := range DefaultJobLimits {
+		ret[t] = limit
+	}
+	for t, limit := range limits {
+		ret[t] = limit
+	}
+
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	j.limits = ret
+}
+
+// jobLimit will return the limits for the type of job
+func (j *Processor) jobLimit(t JobType) JobLimit {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+	if j.limits == nil {
+		return DefaultJobLimits[t]
+	}
+	return j.limits[t]
+}
+
+// startTimeout will set the job up to be cancelled once it passes its
+// timeout, returning the function to release the timer once it is done
+func (j *JobEntry) startTimeout(timeout time.Duration) context.CancelFunc {
+	if timeout <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	j.ctx = ctx
+	j.timeout = timeout
+	return cancel
+}
+
+// watchdog will periodically look for jobs which are taking longer than
+// they should, until the processor is closed
+func (j *Processor) watchdog() {
+	defer j.wg.Done()
+
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.watchdogStop:
+			return
+		case now := <-ticker.C:
+			j.mut.Lock()
+			workers := j.workers
+			j.mut.Unlock()
+
+			for _, w := range workers {
+				j.checkWorker(w, now)
+			}
+		}
+	}
+}
+
+// checkWorker will report the worker's job the first time it runs for longer
+// than expected, and again if it is cancelled for passing its timeout
+func (j *Processor) checkWorker(w *Worker, now time.Time) {
+	job, limit, overdue, timedOut := w.checkOverdue(now)
+	if job == nil {
+		return
+	}
+	fields := log.Fields{
+		"id":    job.GetID(),
+		"async": !w.sequential,
+	}
+	if overdue {
+		fields["expected"] = limit.Expected.String()
+		job.Logger().WithFields(fields).Warning("Job is taking longer than expected")
+		j.notifyEvent(JobOverdue, job)
+	}
+	if timedOut {
+		fields["timeout"] = limit.Timeout.String()
+		job.Logger().WithFields(fields).Warning("Job exceeded its timeout and was asked to stop")
+	}
+}
@@ -0,0 +1,80 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jobs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestJobTimeout(t *testing.T) {
+	store, dir := openTestStore(t)
+	defer os.RemoveAll(dir)
+	defer store.Close()
+
+	jproc := NewProcessor(nil, store, 1)
+	jproc.SetJobLimits(map[JobType]JobLimit{
+		DeltaPair: {Expected: 10 * time.Millisecond, Timeout: 50 * time.Millisecond},
+	})
+	limit := jproc.jobLimit(DeltaPair)
+
+	job := NewDeltaPairJob("0123456789abcdef", "unstable", "nano", "nano-2.8.6-62-1-x86_64.eopkg", "nano-2.8.7-63-1-x86_64.eopkg")
+	release := job.startTimeout(limit.Timeout)
+	defer release()
+	if err := job.Cancelled(); err != nil {
+		t.Fatalf("Job was cancelled straight away: %v", err)
+	}
+
+	w := NewWorkerAsync(jproc)
+	w.setBusy(job, limit)
+	start := w.Status().Since
+
+	if running, _, _, _ := w.checkOverdue(start); running != nil {
+		t.Fatalf("Job was flagged before it was overdue")
+	}
+	_, _, overdue, timedOut := w.checkOverdue(start.Add(20 * time.Millisecond))
+	if !overdue || timedOut {
+		t.Fatalf("Expected the job to be overdue only, got %v %v", overdue, timedOut)
+	}
+	if running, _, _, _ := w.checkOverdue(start.Add(30 * time.Millisecond)); running != nil {
+		t.Fatalf("Overdue job was flagged twice")
+	}
+	_, _, overdue, timedOut = w.checkOverdue(start.Add(time.Second))
+	if overdue || !timedOut {
+		t.Fatalf("Expected the job to time out only, got %v %v", overdue, timedOut)
+	}
+	if status := w.Status(); !status.Overdue || !status.TimedOut {
+		t.Fatalf("Worker status doesn't report the timeout: %+v", status)
+	}
+
+	select {
+	case <-job.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Job wasn't cancelled once it passed its timeout")
+	}
+	if err := job.Cancelled(); err == nil {
+		t.Fatalf("Job should report that it was cancelled")
+	}
+
+	// Jobs without a timeout run forever
+	index := NewIndexRepoJob("unstable")
+	defer index.startTimeout(0)()
+	if index.Done() != nil || index.Cancelled() != nil {
+		t.Fatalf("Job without a timeout can be cancelled")
+	}
+}
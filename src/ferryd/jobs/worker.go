@@ -18,7 +18,10 @@ package jobs
 
 import (
 	"ferryd/core"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"libferry"
+	"runtime"
 	"sync"
 	"time"
 )
@@ -29,38 +32,31 @@ type JobFetcher func() (*JobEntry, error)
 // JobReaper will be provided by either the Async or Sequential retire functions
 type JobReaper func(j *JobEntry) error
 
-var (
-	// timeIndexes allow us to gradually increase our sleep duration
-	timeIndexes = []time.Duration{
-		time.Millisecond * 100,
-		time.Millisecond * 500,
-		time.Second * 1,
-		time.Second * 5,
-		time.Second * 10,
-		time.Second * 15,
-		time.Second * 20,
-		time.Second * 30,
-		time.Second * 40,
-		time.Second * 50,
-		time.Second * 60,
-	}
-)
+// claimRetryDelay is how long a worker waits before trying again when it
+// failed to claim a job, as the store won't necessarily change in the meantime
+const claimRetryDelay = 5 * time.Second
 
 // A Worker is used to execute some portion of the incoming workload, and will
-// keep polling for the correct job type to process
+// sleep until the store tells it there may be a job of the correct type
 type Worker struct {
 	sequential bool
 	exit       chan int
-	ticker     *time.Ticker
 	wg         *sync.WaitGroup
 	manager    *core.Manager
 	store      *JobStore
 	processor  *Processor
 
-	timeIndex int // Increment time index to match timeIndexes, or wrap
-
 	fetcher JobFetcher // Fetch a new job
 	reaper  JobReaper  // Purge an old job
+
+	priority threadPriority // Scheduling currently applied to our thread
+
+	statusMut *sync.Mutex // Protects the status fields below
+	job       *JobEntry   // Currently executing job, if any
+	since     time.Time   // When the current job began
+	limit     JobLimit    // Limits of the current job
+	overdue   bool        // Current job has run for longer than expected
+	timedOut  bool        // Current job has run past its timeout
 }
 
 // newWorker is an internal method to initialise a worker for usage
@@ -76,11 +72,10 @@ func newWorker(processor *Processor, sequential bool) *Worker {
 		sequential: sequential,
 		wg:         processor.wg,
 		exit:       make(chan int, 1),
-		ticker:     nil, // Init this when we start up
 		manager:    processor.manager,
 		store:      processor.store,
 		processor:  processor,
-		timeIndex:  -1,
+		statusMut:  &sync.Mutex{},
 	}
 
 	// Set up appropriate functions for dealing with jobs
@@ -110,119 +105,222 @@ func NewWorkerSequential(processor *Processor) *Worker {
 // Stop will demand that all new requests are no longer processed
 func (w *Worker) Stop() {
 	w.exit <- 1
-	if w.ticker != nil {
-		w.ticker.Stop()
-	}
 }
 
-// Start will begin the main execution of this worker, and will continuously
-// poll for new jobs with an increasing increment (with a ceiling limit)
+// Start will begin the main execution of this worker, claiming jobs until
+// the queue is empty and then sleeping until the store changes
 func (w *Worker) Start() {
 	defer w.wg.Done()
 
-	// Let's get our ticker initialised
-	w.setTimeIndex(0)
+	// Job priorities are applied to our thread, so we must keep it. It's
+	// thrown away when we exit rather than going back to the runtime.
+	runtime.LockOSThread()
+	w.priority = w.processor.basePriority
 
 	for {
+		// Bail now if we've been told to go home, even if jobs remain
 		select {
 		case <-w.exit:
-			// Bail now, we've been told to go home
 			return
+		default:
+		}
 
-		case <-w.ticker.C:
-			// Try to grab a job
-			job, err := w.fetcher()
-
-			// Report the error
-			if err != nil {
-				if err != ErrEmptyQueue {
-					log.WithFields(log.Fields{
-						"error": err,
-						"async": !w.sequential,
-					}).Error("Failed to grab a work queue item")
-				}
-				w.setTimeIndex(w.timeIndex + 1)
-				continue
-			}
-
-			// Got a job, now process it
-			w.processJob(job)
-
-			// Now we mark end time so we can calculate how long it took
-			job.Timing.End = time.Now().UTC()
+		// Grab the change channel before looking so that we can't miss a
+		// job pushed in the meantime
+		_, changed := w.store.watch()
 
-			// Mark the job as dealt with
-			err = w.reaper(job)
+		// Try to grab a job
+		job, err := w.fetcher()
 
-			// Report failure in retiring the job
-			if err != nil {
+		// Report the error
+		if err != nil {
+			var retry <-chan time.Time
+			if err != ErrEmptyQueue {
 				log.WithFields(log.Fields{
 					"error": err,
-					"id":    job.GetID(),
-					"type":  job.Type,
 					"async": !w.sequential,
-				}).Error("Error in retiring job")
+				}).Error("Failed to grab a work queue item")
+				retry = time.After(claimRetryDelay)
 			}
+			select {
+			case <-w.exit:
+				return
+			case <-changed:
+			case <-retry:
+			}
+			continue
+		}
+
+		// Got a job, now process it
+		w.processJob(job)
+		w.setBusy(nil, JobLimit{})
+
+		// Now we mark end time so we can calculate how long it took
+		job.Timing.End = time.Now().UTC()
+
+		// Mark the job as dealt with
+		err = w.reaper(job)
 
-			// We had a job, so we must reset the timeout period
-			w.setTimeIndex(0)
+		// Report failure in retiring the job
+		if err != nil {
+			job.Logger().WithFields(log.Fields{
+				"error": err,
+				"id":    job.GetID(),
+				"async": !w.sequential,
+			}).Error("Error in retiring job")
+		}
+
+		w.processor.notifyRetired(job)
+		if job.failure != nil {
+			w.processor.failDependents(job)
 		}
 	}
 }
 
-// setTimeIndex will update the time index, and reset the ticker if needed
-// so that we increment the wait period. It will cap the time index to the
-// highest index available (60)
-func (w *Worker) setTimeIndex(newTimeIndex int) {
-	maxIndex := len(timeIndexes)
-	// Sequential queue has to be more responsive
-	if w.sequential {
-		maxIndex = 4
+// setBusy will update the status of the worker for reporting purposes,
+// with a nil job marking the worker as idle
+func (w *Worker) setBusy(job *JobEntry, limit JobLimit) {
+	w.statusMut.Lock()
+	defer w.statusMut.Unlock()
+	w.job = job
+	w.limit = limit
+	w.overdue = false
+	w.timedOut = false
+	if job != nil {
+		w.since = time.Now().UTC()
+	} else {
+		w.since = time.Time{}
 	}
-	if newTimeIndex >= maxIndex {
-		newTimeIndex = maxIndex - 1
+}
+
+// checkOverdue will flag the current job once it has run for longer than
+// expected, and again once it passes its timeout. The job is only returned
+// when one of these has newly happened.
+func (w *Worker) checkOverdue(now time.Time) (job *JobEntry, limit JobLimit, overdue, timedOut bool) {
+	w.statusMut.Lock()
+	defer w.statusMut.Unlock()
+	if w.job == nil {
+		return nil, limit, false, false
+	}
+	running := now.Sub(w.since)
+	if !w.overdue && w.limit.Expected > 0 && running > w.limit.Expected {
+		w.overdue = true
+		overdue = true
+	}
+	if !w.timedOut && w.limit.Timeout > 0 && running > w.limit.Timeout {
+		w.timedOut = true
+		timedOut = true
 	}
-	// No sense resetting our ticker
-	if w.timeIndex == newTimeIndex {
+	if !overdue && !timedOut {
+		return nil, limit, false, false
+	}
+	return w.job, w.limit, overdue, timedOut
+}
+
+// Status will return the current status of this worker
+func (w *Worker) Status() libferry.WorkerStatus {
+	w.statusMut.Lock()
+	defer w.statusMut.Unlock()
+	ret := libferry.WorkerStatus{
+		Sequential: w.sequential,
+		Busy:       w.job != nil,
+		Since:      w.since,
+		Overdue:    w.overdue,
+		TimedOut:   w.timedOut,
+	}
+	if w.job != nil {
+		ret.Description = w.job.description
+		ret.JobID = w.job.CorrelationID
+		ret.Progress = w.job.progress.Get()
+	}
+	return ret
+}
+
+// executeJob will run the handler, recording any repository changes it
+// makes in the history
+func (w *Worker) executeJob(job *JobEntry, handler JobHandler) error {
+	return w.processor.withHistory(job, handler, func() error {
+		manager := w.manager.WithLogger(job.Logger()).WithThreadSetup(w.threadSetup(job))
+		return handler.Execute(w.processor, manager)
+	})
+}
+
+// setPriority will switch our thread to the scheduling for the job's class,
+// if it isn't running with it already. The job still runs if that fails.
+func (w *Worker) setPriority(job *JobEntry) {
+	want := w.processor.jobPriority(job.Type)
+	if want == w.priority {
 		return
 	}
-	w.timeIndex = newTimeIndex
-	if w.ticker != nil {
-		w.ticker.Stop()
+	if err := want.apply(&w.priority); err != nil {
+		job.Logger().WithFields(log.Fields{
+			"class": JobClass(job.Type),
+			"error": err,
+		}).Warning("Failed to set job priority")
+	}
+	w.priority = want
+}
+
+// threadSetup will return the setup giving the goroutines the job spreads
+// its work over the job's scheduling, or nil if it has the daemon's own
+func (w *Worker) threadSetup(job *JobEntry) core.ThreadSetup {
+	want, base := w.priority, w.processor.basePriority
+	if want == base {
+		return nil
+	}
+	return func() {
+		// Left locked so the thread goes away with the goroutine
+		runtime.LockOSThread()
+		if err := want.apply(&base); err != nil {
+			// Most likely the worker couldn't either, and has said so
+			job.Logger().WithField("error", err).Debug("Failed to set helper priority")
+		}
 	}
-	w.ticker = time.NewTicker(timeIndexes[w.timeIndex])
 }
 
 // processJob will actually examine the given job and figure out how
-// to execute it. Each Worker can only execute a single job at a time
+// to execute it. Each Worker can only execute a single job at a time.
 func (w *Worker) processJob(job *JobEntry) {
+	job.progress = newProgressReporter()
+	job.stats = newJobStats()
+	limit := w.processor.jobLimit(job.Type)
+	release := job.startTimeout(limit.Timeout)
+	defer release()
 	handler, err := NewJobHandler(job)
 
+	logger := job.Logger()
 	fields := log.Fields{
 		"id":    job.GetID(),
-		"type":  job.Type,
 		"async": !w.sequential,
 	}
 
 	if err != nil {
 		fields["error"] = err
 		job.failure = err
-		log.WithFields(fields).Error("No known job handler, cannot continue with job")
+		logger.WithFields(fields).Error("No known job handler, cannot continue with job")
 		return
 	}
 
 	// Safely have a handler now
 	job.description = handler.Describe()
+	job.repo = jobRepo(job, handler)
 	fields["description"] = job.description
+	w.setPriority(job)
+	w.setBusy(job, limit)
+	w.processor.notifyEvent(JobStarted, job)
 
 	// Try to execute it, report the error
-	if err := handler.Execute(w.processor, w.manager); err != nil {
+	if err := w.executeJob(job, handler); err != nil {
+		// Make it clear why the handler gave up
+		if cancelled := job.Cancelled(); cancelled != nil {
+			err = fmt.Errorf("%v: %v", cancelled, err)
+		}
 		fields["error"] = err
 		job.failure = err
-		log.WithFields(fields).Error("Job failed with error")
+		logger.WithFields(fields).Error("Job failed with error")
 		return
 	}
 
 	// Succeeded
-	log.WithFields(fields).Info("Job completed successfully")
+	logger.WithFields(fields).Info("Job completed successfully")
 }
@@ -0,0 +1,189 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// inFlightRetry is how long clients are asked to wait when too many
+	// requests are already being handled
+	inFlightRetry = time.Second
+)
+
+// A RateLimit allows Rate requests per second to an operation on average,
+// with up to Burst of them at once
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// A tokenBucket enforces a RateLimit. Each request takes a token, and the
+// tokens are refilled at the rate allowed.
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// take will take a token from the bucket, or return how long it will be
+// until one is available
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if burst := float64(b.limit.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// The RequestLimiter stops clients flooding the daemon, by capping how many
+// requests are handled at once and how often each operation may be used
+type RequestLimiter struct {
+	maxInFlight int // 0 for no limit
+	inFlight    int
+	buckets     map[string]*tokenBucket // Keyed by the operation
+	mut         *sync.Mutex
+}
+
+// NewRequestLimiter will return a RequestLimiter which allows everything
+func NewRequestLimiter() *RequestLimiter {
+	return &RequestLimiter{
+		buckets: make(map[string]*tokenBucket),
+		mut:     &sync.Mutex{},
+	}
+}
+
+// SetLimits will replace the limits in use. Requests already being handled
+// still count towards the new in-flight cap.
+func (l *RequestLimiter) SetLimits(maxInFlight int, limits map[string]RateLimit) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	now := time.Now()
+	buckets := make(map[string]*tokenBucket)
+	for op, limit := range limits {
+		bucket, ok := l.buckets[op]
+		if !ok || bucket.limit != limit {
+			bucket = &tokenBucket{
+				limit:  limit,
+				tokens: float64(limit.Burst),
+				last:   now,
+			}
+		}
+		buckets[op] = bucket
+	}
+	l.maxInFlight = maxInFlight
+	l.buckets = buckets
+}
+
+// acquire will admit a request for the operation, or return how long the
+// client should wait before trying again. Admitted requests which count
+// towards the in-flight cap must be released once handled.
+func (l *RequestLimiter) acquire(op string, counted bool) (time.Duration, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if counted && l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+		return inFlightRetry, fmt.Errorf("too many requests in flight, limit is %d", l.maxInFlight)
+	}
+	if bucket, ok := l.buckets[op]; ok {
+		if wait := bucket.take(time.Now()); wait > 0 {
+			return wait, fmt.Errorf("rate limit exceeded for %s", op)
+		}
+	}
+	if counted {
+		l.inFlight++
+	}
+	return 0, nil
+}
+
+// release will mark an admitted request as handled
+func (l *RequestLimiter) release() {
+	l.mut.Lock()
+	l.inFlight--
+	l.mut.Unlock()
+}
+
+// limit will wrap the handler of the operation so that requests are refused
+// once over the limits. Requests that wait for something to happen are held
+// open indefinitely, so they don't count towards the in-flight cap.
+func (s *Server) limit(op string, wait bool, handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		retry, err := s.limiter.acquire(op, !wait)
+		if err != nil {
+			s.sendRateLimited(retry, err, w)
+			return
+		}
+		if !wait {
+			defer s.limiter.release()
+		}
+		handle(w, r, p)
+	}
+}
+
+// sendRateLimited will refuse the request, telling the client when to try
+// again. It's only logged for debugging, as whoever is flooding us would
+// otherwise flood the log too.
+func (s *Server) sendRateLimited(retry time.Duration, err error, w http.ResponseWriter) {
+	log.WithFields(log.Fields{
+		"error":       err,
+		"retry_after": retry,
+	}).Debug("Refused request over the limits")
+	seconds := int(math.Ceil(retry.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	s.writeResponse(http.StatusTooManyRequests, &libferry.Response{
+		Error:       true,
+		ErrorString: err.Error(),
+		ErrorKind:   libferry.ErrorRateLimited,
+	}, w)
+}
+
+// applyLimits will set the request limits from the configuration, warning
+// about limits on operations we don't have
+func (s *Server) applyLimits(config *Config) {
+	known := make(map[string]bool)
+	for _, route := range s.routes {
+		known[route.name] = true
+	}
+	limits := config.API.rateLimits()
+	for op := range limits {
+		if !known[op] {
+			log.WithFields(log.Fields{
+				"operation": op,
+			}).Warning("Ignoring rate limit for unknown operation")
+			delete(limits, op)
+		}
+	}
+	s.limiter.SetLimits(config.API.MaxInFlight, limits)
+}
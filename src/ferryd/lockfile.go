@@ -144,6 +144,33 @@ func (l *LockFile) Unlock() error {
 	return nil
 }
 
+// Verify will ensure we still hold the lockfile, and that it hasn't been
+// removed or replaced by another process since we locked it
+func (l *LockFile) Verify() error {
+	if l.fd == nil || !l.owner {
+		return errors.New("lockfile is not held")
+	}
+	pid, err := l.readPID()
+	if err != nil {
+		return err
+	}
+	if pid != l.ourPID {
+		return fmt.Errorf("lockfile names process %d", pid)
+	}
+	ours, err := l.fd.Stat()
+	if err != nil {
+		return err
+	}
+	theirs, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(ours, theirs) {
+		return errors.New("lockfile was replaced")
+	}
+	return nil
+}
+
 // readPID is a simple utility to extract the PID from a file
 func (l *LockFile) readPID() (int, error) {
 	l.conlock.RLock()
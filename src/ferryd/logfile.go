@@ -0,0 +1,176 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"libeopkg"
+	"os"
+	"sync"
+)
+
+// A LogFile is the destination for all of our logging. It will rotate itself
+// once it exceeds the maximum size, keeping a limited number of (optionally
+// compressed) backups around.
+//
+// It can also be asked to Reopen the file, which allows external tools such
+// as logrotate to move the log out from under us.
+type LogFile struct {
+	path       string      // Path of the active log file
+	maxSize    int64       // Rotate when we'd exceed this many bytes. 0 disables
+	maxBackups int         // How many rotated files to keep around
+	compress   bool        // Whether to compress rotated files
+	mut        *sync.Mutex // Writes may come from any goroutine
+	file       *os.File    // The currently open log file
+	size       int64       // Current size of the log file
+}
+
+// OpenLogFile will open (or create) the log file at the given path for
+// appending.
+func OpenLogFile(path string, maxSize int64, maxBackups int, compress bool) (*LogFile, error) {
+	l := &LogFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		compress:   compress,
+		mut:        &sync.Mutex{},
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open the log file for appending and learn the current size
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 00644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file = f
+	l.size = st.Size()
+	return nil
+}
+
+// Write implements io.Writer, rotating the file first if this write would
+// take it over the maximum size.
+func (l *LogFile) Write(p []byte) (int, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(p)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			// Keep logging to the old file, rotation is best effort
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", l.path, err)
+		}
+	}
+
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// Reopen will close and reopen the log file, i.e. after logrotate has
+// moved it away.
+func (l *LogFile) Reopen() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.open()
+}
+
+// SetRotation will change the rotation settings, taking effect from the
+// next write
+func (l *LogFile) SetRotation(maxSize int64, maxBackups int, compress bool) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.maxSize = maxSize
+	l.maxBackups = maxBackups
+	l.compress = compress
+}
+
+// Close will close the underlying file
+func (l *LogFile) Close() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// backupName returns the name for the numbered backup
+func (l *LogFile) backupName(n int) string {
+	if l.compress {
+		return fmt.Sprintf("%s.%d%s", l.path, n, libeopkg.InternalCompressor().Suffix())
+	}
+	return fmt.Sprintf("%s.%d", l.path, n)
+}
+
+// rotate will shift all existing backups up by one, dropping the oldest, and
+// then move the current log into the first backup slot. Must be called with
+// the lock held.
+func (l *LogFile) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file = nil
+
+	if l.maxBackups < 1 {
+		// No backups wanted, just start again
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+
+	// Drop the oldest and shift everything else up
+	os.Remove(l.backupName(l.maxBackups))
+	for i := l.maxBackups - 1; i > 0; i-- {
+		if err := os.Rename(l.backupName(i), l.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	rotated := fmt.Sprintf("%s.1", l.path)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	if !l.compress {
+		return nil
+	}
+	return libeopkg.InternalCompressor().CompressFile(rotated, false)
+}
@@ -23,12 +23,19 @@ import (
 	"github.com/spf13/pflag"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 var (
 	// If systemd is enabled, we'll talk to it.
 	systemdEnabled = false
 
+	// configPath is the TOML configuration file, reloaded on SIGHUP
+	configPath = DefaultConfigPath
+
+	// The remaining flags mirror the configuration file, and take priority
+	// over it when explicitly set.
+
 	// baseDir is where we expect to operate
 	baseDir = "/var/lib/ferryd"
 
@@ -37,6 +44,30 @@ var (
 
 	// How many jobs we're allowed to use. By default, half of the system cores (xz -T 2)
 	backgroundJobCount = -1
+
+	// If set, we'll serve the repository tree over HTTP on this address
+	httpAddress = ""
+
+	// Either "text" or "json", controlling the log file format
+	logFormat = "text"
+
+	// Rotate the log file once it reaches this many megabytes (0 to disable)
+	logMaxSize = 0
+
+	// Number of rotated log files to retain
+	logMaxBackups = 5
+
+	// Whether rotated log files are compressed
+	logCompress = false
+
+	// Start as a standby, only serving reads until promoted
+	standbyMode = false
+
+	// If set, restore the database from this backup and exit
+	restorePath = ""
+
+	// If set, migrate the database to this backend and exit
+	migrateBackend = ""
 )
 
 const (
@@ -44,47 +75,117 @@ const (
 	LockFilePath = "ferryd.lock"
 )
 
+// setLogFormat will configure logrus for the given format
+func setLogFormat(format string) {
+	switch format {
+	case "json":
+		// Full timestamps so that the log can be machine-filtered
+		log.SetFormatter(&log.JSONFormatter{
+			TimestampFormat: time.RFC3339Nano,
+		})
+	default:
+		form := &log.TextFormatter{
+			DisableColors: true,
+		}
+		form.FullTimestamp = true
+		form.TimestampFormat = "15:04:05"
+		log.SetFormatter(form)
+	}
+}
+
+// restoreDatabase will replace the database with the backup file
+func restoreDatabase(baseDir, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return core.RestoreDatabase(baseDir, f)
+}
+
 func mainLoop() {
+	pflag.StringVarP(&configPath, "config", "c", DefaultConfigPath, "Set the configuration file for ferryd")
 	pflag.StringVarP(&baseDir, "base", "d", "/var/lib/ferryd", "Set the base directory for ferryd")
 	pflag.StringVarP(&socketPath, "socket", "s", "/run/ferryd.sock", "Set the socket path for ferryd")
 	pflag.IntVarP(&backgroundJobCount, "jobs", "j", -1, "Number of jobs to use (-1 is 50% of cores)")
+	pflag.StringVar(&httpAddress, "http", "", "Serve repositories read-only over HTTP on this address (i.e. :8080)")
+	pflag.StringVar(&logFormat, "log-format", "text", "Set the log file format (text, json)")
+	pflag.IntVar(&logMaxSize, "log-max-size", 0, "Rotate ferryd.log at this size in MiB (0 disables rotation)")
+	pflag.IntVar(&logMaxBackups, "log-max-backups", 5, "Number of rotated log files to keep")
+	pflag.BoolVar(&logCompress, "log-compress", false, "Compress rotated log files")
+	pflag.BoolVar(&standbyMode, "standby", false, "Start as a standby, only serving reads until promoted")
+	pflag.StringVar(&restorePath, "restore-db", "", "Restore the database from a backup-db file and exit")
+	pflag.StringVar(&migrateBackend, "migrate-db", "", "Migrate the database to another backend (leveldb, bolt) and exit")
+	for _, flag := range []string{"jobs", "http", "log-format", "log-max-size", "log-max-backups", "log-compress"} {
+		pflag.CommandLine.MarkDeprecated(flag, "set it in the configuration file instead")
+	}
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] [check-config]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "check-config validates the configuration and prints the effective one\n\n")
+		pflag.PrintDefaults()
+	}
 	pflag.Parse()
 
-	// We write to a logfile..
-	form := &log.TextFormatter{
-		DisableColors: true,
+	var command string
+	if pflag.NArg() > 0 {
+		command = pflag.Arg(0)
+		if command != "check-config" || pflag.NArg() > 1 {
+			pflag.Usage()
+			os.Exit(1)
+		}
 	}
 
-	form.FullTimestamp = true
-	form.TimestampFormat = "15:04:05"
-	log.SetFormatter(form)
-
-	// Ensure all joined directories are correct
-	b, err := filepath.Abs(baseDir)
+	config, err := LoadConfig(configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Cannot resolve directory %v: %v\n", baseDir, err)
+		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
-	baseDir = b
+
+	if command == "check-config" {
+		if err := checkConfig(config, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// We write to a logfile..
+	setLogFormat(config.Log.Format)
 
 	// Must have a valid baseDir
-	if !core.PathExists(baseDir) {
-		fmt.Fprintf(os.Stderr, "Base directory does not exist: %s\n", baseDir)
+	if !core.PathExists(config.BaseDir) {
+		fmt.Fprintf(os.Stderr, "Base directory does not exist: %s\n", config.BaseDir)
 		os.Exit(1)
 	}
 
+	// Restoring and migrating are one-shot operations while the daemon is stopped
+	if restorePath != "" {
+		if err := restoreDatabase(config.BaseDir, restorePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to restore database: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if migrateBackend != "" {
+		if err := core.MigrateDatabase(config.BaseDir, migrateBackend); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate database: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Need to get a lock file before we can even grab the log file
-	srv, err := NewServer()
+	srv, err := NewServer(config)
 	if err != nil {
-		lockPath := filepath.Join(baseDir, LockFilePath)
+		lockPath := filepath.Join(config.BaseDir, LockFilePath)
 		fmt.Fprintf(os.Stderr, "Failed to start ferryd: %v (lockfile: %v)\n", err, lockPath)
 		os.Exit(1)
 	}
 	defer srv.Close()
 
-	// We'll just keep logging for ever, don't expect rotation..
-	logPath := filepath.Join(baseDir, "ferryd.log")
-	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 00644)
+	// Rotate internally if asked, otherwise SIGHUP allows logrotate usage
+	logPath := filepath.Join(config.BaseDir, "ferryd.log")
+	logFile, err := OpenLogFile(logPath, int64(config.Log.MaxSize)*1024*1024, config.Log.MaxBackups, config.Log.Compress)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to open log file: %s %v\n", logPath, err)
 		os.Exit(1)
@@ -92,6 +193,7 @@ func mainLoop() {
 	defer logFile.Close()
 
 	log.SetOutput(logFile)
+	srv.logFile = logFile
 
 	// Now we can safely use logrus..
 	log.Info("Initialising server")
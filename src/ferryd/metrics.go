@@ -0,0 +1,143 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"time"
+)
+
+// metricsContentType is the Prometheus text exposition format
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// A MetricsServer exposes gauges for each repository over HTTP in the
+// Prometheus text format, at /metrics, so that stale or failing indexes can
+// be alerted on.
+type MetricsServer struct {
+	srv     *http.Server
+	manager *core.Manager
+	address string
+	socket  net.Listener
+	running bool
+}
+
+// NewMetricsServer will return a new MetricsServer reporting on the
+// manager's repositories, which will listen on address once started.
+func NewMetricsServer(manager *core.Manager, address string) *MetricsServer {
+	m := &MetricsServer{
+		manager: manager,
+		address: address,
+	}
+	m.srv = &http.Server{
+		Handler:      m,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	return m
+}
+
+// Start will bind the listening socket and begin serving in the background
+func (m *MetricsServer) Start() error {
+	l, err := net.Listen("tcp", m.address)
+	if err != nil {
+		return err
+	}
+	m.socket = l
+	m.running = true
+
+	log.WithFields(log.Fields{
+		"address": m.address,
+	}).Info("Serving metrics over HTTP")
+
+	go func() {
+		if err := m.srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			log.WithFields(log.Fields{
+				"address": m.address,
+				"error":   err,
+			}).Error("Error in serving metrics over HTTP")
+		}
+	}()
+	return nil
+}
+
+// Close will shut down the metrics server
+func (m *MetricsServer) Close() {
+	if !m.running {
+		return
+	}
+	m.running = false
+	m.srv.Close()
+}
+
+// ServeHTTP implements the http.Handler interface to serve the metrics
+func (m *MetricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Only the stored records are needed, which is cheap enough per scrape
+	repos, err := m.manager.GetRepos()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	gauge := func(name, help string, value func(repo *core.Repository) float64) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, repo := range repos {
+			fmt.Fprintf(&buf, "%s{repo=%q} %v\n", name, repo.ID, value(repo))
+		}
+	}
+	gauge("ferryd_repo_index_dirty", "Whether packages changed since the last successful index.", func(repo *core.Repository) float64 {
+		return boolGauge(repo.Dirty)
+	})
+	gauge("ferryd_repo_last_index_success", "Whether the last index succeeded.", func(repo *core.Repository) float64 {
+		return boolGauge(repo.LastIndex.Succeeded())
+	})
+	gauge("ferryd_repo_last_index_timestamp_seconds", "When the last index started, 0 if never indexed.", func(repo *core.Repository) float64 {
+		if repo.LastIndex.Time.IsZero() {
+			return 0
+		}
+		return float64(repo.LastIndex.Time.UnixNano()) / 1e9
+	})
+	gauge("ferryd_repo_last_index_duration_seconds", "How long the last index took.", func(repo *core.Repository) float64 {
+		return repo.LastIndex.Duration.Seconds()
+	})
+
+	w.Header().Set("Content-Type", metricsContentType)
+	w.Write(buf.Bytes())
+}
+
+// boolGauge will return the gauge value for b
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -19,63 +19,376 @@ package main
 import (
 	"ferryd/core"
 	"ferryd/jobs"
+	"fmt"
 	"github.com/radu-munteanu/fsnotify"
 	log "github.com/sirupsen/logrus"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-// InitWatcher will set up the watcher for the first time
-func (s *Server) InitWatcher() error {
+const (
+	// incomingSettleTime is how often pending uploads are checked, and so how
+	// long they must go unchanged before they're processed
+	incomingSettleTime = 2 * time.Second
+
+	// incomingRescanInterval is how often each incoming directory is scanned
+	// for manifests the watcher never told us about, i.e. after an event
+	// queue overflow or on network filesystems
+	incomingRescanInterval = time.Minute
+
+	// incomingStaleAge is how long a file may sit in an incoming directory
+	// before we warn about it
+	incomingStaleAge = 30 * time.Minute
+
+	// partialUploadSuffix may be used while uploading a file, which is then
+	// renamed into place once complete. Such files are never processed.
+	partialUploadSuffix = ".part"
+)
+
+// An incomingWatcher monitors a single incoming directory for .tram uploads.
+// The shared incoming directory accepts manifests for any repository, while
+// those dropped into a repository's own directory may only target it.
+//
+// The maps are only used by the goroutine handling the watcher's events.
+type incomingWatcher struct {
+	path    string
+	repoID  string // Empty for the shared incoming directory
+	watcher *fsnotify.Watcher
+	stop    chan struct{}
+
+	pending map[string]*pendingUpload // Manifests waiting for uploads to settle
+	handled map[string]time.Time      // Modification time of manifests already pushed
+	warned  map[string]bool           // Stale files we've already warned about
+}
+
+// pendingUpload is a manifest whose upload may still be in progress
+type pendingUpload struct {
+	signature string
+	complete  bool      // Every payload file is present
+	queued    time.Time // When we first saw the manifest
+	changed   time.Time // When the signature last changed
+}
+
+// newIncomingWatcher will begin monitoring the directory
+func newIncomingWatcher(path, repoID string) (*incomingWatcher, error) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	if err = watcher.Add(path); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return &incomingWatcher{
+		path:    path,
+		repoID:  repoID,
+		watcher: watcher,
+		stop:    make(chan struct{}),
+		pending: make(map[string]*pendingUpload),
+		handled: make(map[string]time.Time),
+		warned:  make(map[string]bool),
+	}, nil
+}
+
+// uploadSignature will describe the size and modification time of the
+// manifest and each payload file it lists, which only stops changing once
+// the upload is complete. complete is false if any payload file is missing.
+func uploadSignature(path string) (sig string, complete bool, err error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", false, err
+	}
+	sig = fmt.Sprintf("%d:%d", st.Size(), st.ModTime().UnixNano())
+
+	tram, err := core.NewTransitManifest(path)
+	if err != nil {
+		// Either still being written or broken, and the transit job will
+		// report the latter once it has settled
+		return sig, true, nil
+	}
+	complete = true
+	for _, p := range tram.GetPaths() {
+		if st, err = os.Stat(p); err != nil {
+			sig += " missing"
+			complete = false
+			continue
+		}
+		sig += fmt.Sprintf(" %d:%d", st.Size(), st.ModTime().UnixNano())
+	}
+	return sig, complete, nil
+}
+
+// InitWatcher will set up the watcher for the first time
+func (s *Server) InitWatcher() error {
 	// Monitor the incoming dir
-	if err = watcher.Add(s.manager.IncomingPath); err != nil {
+	watcher, err := newIncomingWatcher(s.manager.IncomingPath, "")
+	if err != nil {
 		return err
 	}
-	s.watchChan = make(chan bool)
-	s.watcher = watcher
+	s.watchers[""] = watcher
 	return nil
 }
 
-// WatchIncoming will wait for events on the incoming directory
+// WatchIncoming will wait for events on the incoming directories
 // and process incoming .tram files
 func (s *Server) WatchIncoming() {
+	s.watchMut.Lock()
+	s.startWatcher(s.watchers[""])
+	s.watchMut.Unlock()
+
+	repos, err := s.manager.GetRepos()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to list repositories to watch")
+		return
+	}
+	for _, repo := range repos {
+		s.watchRepo(repo.ID)
+	}
+}
+
+// startWatcher will handle events for the watcher until it is stopped
+func (s *Server) startWatcher(w *incomingWatcher) {
 	s.watchGroup.Add(1)
 	go func() {
 		defer s.watchGroup.Done()
+
+		settle := time.NewTicker(incomingSettleTime)
+		defer settle.Stop()
+		rescan := time.NewTicker(incomingRescanInterval)
+		defer rescan.Stop()
+
+		// Pick up anything uploaded while we weren't watching
+		s.rescanIncoming(w)
+
 		for {
 			select {
-			case event := <-s.watcher.Events:
-				// Not interested in subdirs
-				if filepath.Dir(event.Name) != s.manager.IncomingPath {
-					continue
-				}
-				if event.Op&fsnotify.Update == fsnotify.Update {
-					if strings.HasSuffix(event.Name, core.TransitManifestSuffix) {
-						s.processTransitManifest(filepath.Base(event.Name))
-					}
+			case event := <-w.watcher.Events:
+				s.handleIncoming(w, event)
+			case err, ok := <-w.watcher.Errors:
+				if !ok {
+					// Closed as we're being stopped
+					return
 				}
-			case <-s.watchChan:
+				// Usually the event queue overflowing, so look for
+				// whatever we missed
+				log.WithFields(log.Fields{
+					"path":  w.path,
+					"error": err,
+				}).Warning("Error watching incoming directory")
+				s.rescanIncoming(w)
+			case <-settle.C:
+				s.settleUploads(w)
+			case <-rescan.C:
+				s.rescanIncoming(w)
+			case <-w.stop:
 				return
 			}
 		}
 	}()
 }
 
+// handleIncoming will deal with a single event from one of the watchers
+func (s *Server) handleIncoming(w *incomingWatcher, event fsnotify.Event) {
+	// Not interested in subdirs
+	if filepath.Dir(event.Name) != w.path {
+		return
+	}
+
+	// Repositories' own incoming directories come and go with them
+	if w.repoID == "" && event.Op&fsnotify.Create == fsnotify.Create {
+		if st, err := os.Stat(event.Name); err == nil && st.IsDir() {
+			s.watchRepo(filepath.Base(event.Name))
+		}
+		return
+	}
+	if w.repoID == "" && event.Op&fsnotify.Remove == fsnotify.Remove {
+		s.unwatchRepo(filepath.Base(event.Name))
+		return
+	}
+
+	if event.Op&fsnotify.Update == fsnotify.Update {
+		if strings.HasSuffix(event.Name, core.TransitManifestSuffix) {
+			w.queueUpload(filepath.Base(event.Name))
+		}
+	}
+}
+
+// queueUpload will hold back the manifest until its upload has settled
+func (w *incomingWatcher) queueUpload(name string) {
+	sig, complete, err := uploadSignature(filepath.Join(w.path, name))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if p, ok := w.pending[name]; ok {
+		p.signature = sig
+		p.complete = complete
+		p.changed = now
+		return
+	}
+	w.pending[name] = &pendingUpload{
+		signature: sig,
+		complete:  complete,
+		queued:    now,
+		changed:   now,
+	}
+}
+
+// settleUploads will process each pending manifest once neither it nor its
+// payload has changed for incomingSettleTime. Manifests whose payload hasn't
+// fully arrived are given until incomingStaleAge before the transit job is
+// left to report the missing files.
+func (s *Server) settleUploads(w *incomingWatcher) {
+	now := time.Now()
+	for name, p := range w.pending {
+		sig, complete, err := uploadSignature(filepath.Join(w.path, name))
+		if err != nil {
+			delete(w.pending, name)
+			continue
+		}
+		if sig != p.signature {
+			p.signature = sig
+			p.complete = complete
+			p.changed = now
+			continue
+		}
+		if now.Sub(p.changed) < incomingSettleTime {
+			continue
+		}
+		if !p.complete && now.Sub(p.queued) < incomingStaleAge {
+			continue
+		}
+		delete(w.pending, name)
+		s.processTransitManifest(w, name)
+	}
+}
+
+// rescanIncoming will look over the whole incoming directory, queueing any
+// manifests that we never received an event for and warning about files
+// that have been left lying around.
+func (s *Server) rescanIncoming(w *incomingWatcher) {
+	files, err := ioutil.ReadDir(w.path)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"path":  w.path,
+			"error": err,
+		}).Error("Failed to rescan incoming directory")
+		return
+	}
+
+	var missed, stale uint64
+	present := make(map[string]bool)
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		name := fi.Name()
+		present[name] = true
+
+		if strings.HasSuffix(name, core.TransitManifestSuffix) && w.pending[name] == nil {
+			if modTime, ok := w.handled[name]; !ok || !modTime.Equal(fi.ModTime()) {
+				log.WithFields(log.Fields{
+					"path": w.path,
+					"id":   name,
+				}).Warning("Found unprocessed transit manifest")
+				missed++
+				w.queueUpload(name)
+			}
+		}
+
+		if time.Since(fi.ModTime()) > incomingStaleAge && !w.warned[name] {
+			log.WithFields(log.Fields{
+				"path":     w.path,
+				"file":     name,
+				"modified": fi.ModTime(),
+				"partial":  strings.HasSuffix(name, partialUploadSuffix),
+			}).Warning("Stale file in incoming directory")
+			stale++
+			w.warned[name] = true
+		}
+	}
+
+	// Forget about anything that has since gone away
+	for name := range w.handled {
+		if !present[name] {
+			delete(w.handled, name)
+		}
+	}
+	for name := range w.warned {
+		if !present[name] {
+			delete(w.warned, name)
+		}
+	}
+
+	s.watchMut.Lock()
+	s.incomingStatus.LastRescan = time.Now().UTC()
+	s.incomingStatus.MissedManifests += missed
+	s.incomingStatus.StaleFiles += stale
+	s.watchMut.Unlock()
+}
+
+// watchRepo will begin monitoring the incoming directory of the repository,
+// unless we already are
+func (s *Server) watchRepo(id string) {
+	s.watchMut.Lock()
+	defer s.watchMut.Unlock()
+
+	if _, ok := s.watchers[id]; ok || s.watchers[""] == nil {
+		return
+	}
+	repo, err := s.manager.GetRepo(id)
+	if err != nil {
+		return
+	}
+	watcher, err := newIncomingWatcher(repo.IncomingPath(), id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"repo":  id,
+			"error": err,
+		}).Error("Failed to watch repository incoming directory")
+		return
+	}
+	s.watchers[id] = watcher
+	s.startWatcher(watcher)
+}
+
+// unwatchRepo will stop monitoring the incoming directory of the repository
+func (s *Server) unwatchRepo(id string) {
+	s.watchMut.Lock()
+	defer s.watchMut.Unlock()
+
+	if watcher, ok := s.watchers[id]; ok && id != "" {
+		close(watcher.stop)
+		watcher.watcher.Close()
+		delete(s.watchers, id)
+	}
+}
+
 // StopWatching will force the fsnotify code to shut down
 func (s *Server) StopWatching() {
-	s.watchChan <- true
+	s.watchMut.Lock()
+	watchers := s.watchers
+	s.watchers = make(map[string]*incomingWatcher)
+	s.watchMut.Unlock()
+
+	for _, watcher := range watchers {
+		close(watcher.stop)
+	}
 	s.watchGroup.Wait()
+	for _, watcher := range watchers {
+		watcher.watcher.Close()
+	}
 }
 
-// processTransitManifest is invoked when a .tram file is closed in our incoming
-// directory. We'll now push it for further processing
-func (s *Server) processTransitManifest(name string) {
-	fullpath := filepath.Join(s.manager.IncomingPath, name)
+// processTransitManifest is invoked when a .tram file is closed in one of our
+// incoming directories. We'll now push it for further processing
+func (s *Server) processTransitManifest(w *incomingWatcher, name string) {
+	fullpath := filepath.Join(w.path, name)
 
 	st, err := os.Stat(fullpath)
 	if err != nil {
@@ -86,8 +399,13 @@ func (s *Server) processTransitManifest(name string) {
 		return
 	}
 
-	log.WithFields(log.Fields{
+	fields := log.Fields{
 		"id": name,
-	}).Info("Received transit manifest upload")
-	s.jproc.PushJob(jobs.NewTransitJob(fullpath))
+	}
+	if w.repoID != "" {
+		fields["repo"] = w.repoID
+	}
+	log.WithFields(fields).Info("Received transit manifest upload")
+	w.handled[name] = st.ModTime()
+	s.jproc.PushJob(jobs.NewTransitJob(fullpath, w.repoID))
 }
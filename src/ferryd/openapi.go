@@ -0,0 +1,310 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"libferry"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// An apiDoc describes an API route, so that the OpenAPI document we serve
+// is built from the routes themselves and can't fall out of date
+type apiDoc struct {
+	Summary     string
+	Query       []apiParam  // Optional query parameters
+	Request     interface{} // Type of the request body, if any
+	Response    interface{} // Type of a successful reply, if JSON
+	ContentType string      // Of a successful reply, when it isn't JSON
+	Confirm     bool        // Destructive, so the reply may ask for confirmation
+	Privileged  bool        // Only available to root and the authorization group
+	Wait        bool        // Held open until something happens, so not counted as in flight
+	Standby     bool        // Allowed while we're a standby, even though it changes things
+}
+
+// An apiParam is an optional query parameter of a route
+type apiParam struct {
+	Name        string
+	Type        string // OpenAPI type, i.e. "integer"
+	Description string
+}
+
+// An apiRoute is a route registered with the router
+type apiRoute struct {
+	method string
+	path   string
+	name   string // Name of the handler, used as the operation ID
+	doc    apiDoc
+}
+
+// Common query parameters
+var (
+	offsetParam = apiParam{"offset", "integer", "Number of items to skip"}
+	limitParam  = apiParam{"limit", "integer", "Most items to return, 0 for all"}
+)
+
+// handlerName will return the name of the method implementing the handler
+func handlerName(handle httprouter.Handle) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(handle).Pointer())
+	if fn == nil {
+		return ""
+	}
+	name := strings.TrimSuffix(fn.Name(), "-fm")
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// openAPIPath will convert the router path to an OpenAPI path, returning the
+// names of the path parameters
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// schemaBuilder produces the JSON schemas of the types used by the API,
+// collecting named structs as components so that they're only described once
+type schemaBuilder struct {
+	components map[string]interface{}
+	names      map[reflect.Type]string
+}
+
+// newSchemaBuilder will return a schemaBuilder with no components
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		components: make(map[string]interface{}),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schema will return the schema of values of type t, as encoding/json would
+// encode them
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + b.component(t)}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// component will describe the named struct under components, returning
+// the name it was given
+func (b *schemaBuilder) component(t reflect.Type) string {
+	if name, ok := b.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := b.components[name]; taken {
+		name = strings.Title(t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]) + name
+	}
+	// Claim the name first, as the struct may refer to itself
+	b.names[t] = name
+	b.components[name] = nil
+	b.components[name] = b.structSchema(t)
+	return name
+}
+
+// structSchema will return the schema of the struct, with embedded structs
+// flattened into it as encoding/json does
+func (b *schemaBuilder) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	b.addFields(t, props)
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+}
+
+// addFields will add the schema of each encoded field of the struct to props
+func (b *schemaBuilder) addFields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(ft, props)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+		}
+		props[name] = b.schema(f.Type)
+	}
+}
+
+// jsonContent will return the content of a JSON body of the given type
+func (b *schemaBuilder) jsonContent(v interface{}) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object"}
+	if v != nil {
+		schema = b.schema(reflect.TypeOf(v))
+	}
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// operation will describe a single route
+func (b *schemaBuilder) operation(route *apiRoute, pathParams []string) map[string]interface{} {
+	doc := &route.doc
+	op := map[string]interface{}{
+		"operationId": route.name,
+		"summary":     doc.Summary,
+	}
+
+	params := []interface{}{}
+	for _, name := range pathParams {
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, q := range doc.Query {
+		params = append(params, map[string]interface{}{
+			"name":        q.Name,
+			"in":          "query",
+			"description": q.Description,
+			"schema":      map[string]interface{}{"type": q.Type},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if doc.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  b.jsonContent(doc.Request),
+		}
+	}
+
+	success := map[string]interface{}{"description": "Success"}
+	if doc.ContentType != "" {
+		schema := map[string]interface{}{"type": "string", "format": "binary"}
+		if doc.Response != nil {
+			schema = b.schema(reflect.TypeOf(doc.Response))
+		}
+		success["content"] = map[string]interface{}{
+			doc.ContentType: map[string]interface{}{"schema": schema},
+		}
+	} else {
+		success["content"] = b.jsonContent(doc.Response)
+	}
+	responses := map[string]interface{}{
+		"200": success,
+		"default": map[string]interface{}{
+			"description": "Error, categorised by ErrorKind",
+			"content":     b.jsonContent(libferry.Response{}),
+		},
+	}
+	if doc.Privileged {
+		responses[fmt.Sprintf("%d", http.StatusForbidden)] = map[string]interface{}{
+			"description": "The client isn't allowed to make this request",
+			"content":     b.jsonContent(libferry.Response{}),
+		}
+	}
+	if doc.Confirm {
+		responses[fmt.Sprintf("%d", http.StatusPreconditionRequired)] = map[string]interface{}{
+			"description": "Confirmation required, repeat the request with the token",
+			"content":     b.jsonContent(libferry.ConfirmationResponse{}),
+		}
+	}
+	op["responses"] = responses
+	return op
+}
+
+// openAPIDocument will describe every route as an OpenAPI 3 document
+func (s *Server) openAPIDocument() map[string]interface{} {
+	b := newSchemaBuilder()
+	paths := make(map[string]interface{})
+	for i := range s.routes {
+		route := &s.routes[i]
+		path, params := openAPIPath(route.path)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		item[strings.ToLower(route.method)] = b.operation(route, params)
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "ferryd",
+			"description": "API of the Solus package repository manager, served on its unix socket",
+			"version":     libferry.Version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": b.components,
+		},
+	}
+}
+
+// GetOpenAPI will describe the API as an OpenAPI 3 document, so that clients
+// may be generated for it
+func (s *Server) GetOpenAPI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	s.sendResponse(s.openAPIDocument(), w, r)
+}
@@ -0,0 +1,116 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"time"
+)
+
+const (
+	// publishCheckInterval is how often we look for failed pushes which are
+	// due to be retried
+	publishCheckInterval = 30 * time.Second
+)
+
+// wakePublisher will have the publisher look for pending pushes now
+func (s *Server) wakePublisher() {
+	select {
+	case s.publishWake <- struct{}{}:
+	default:
+	}
+}
+
+// startPublisher will push indexed repositories to the publish targets in
+// the background until the server is closed
+func (s *Server) startPublisher() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.publishCancel = cancel
+	s.manager.SetPublishPendingFunc(s.wakePublisher)
+
+	s.publishGroup.Add(1)
+	go func() {
+		defer s.publishGroup.Done()
+
+		ticker := time.NewTicker(publishCheckInterval)
+		defer ticker.Stop()
+
+		// Anything left pending from before a restart
+		s.publishDue(ctx)
+
+		for {
+			select {
+			case <-s.publishWake:
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			s.publishDue(ctx)
+		}
+	}()
+}
+
+// stopPublisher will abort any push in progress and wait for the publisher
+func (s *Server) stopPublisher() {
+	if s.publishCancel == nil {
+		return
+	}
+	s.publishCancel()
+	s.publishGroup.Wait()
+}
+
+// publishDue will attempt every push which is due, one at a time
+func (s *Server) publishDue(ctx context.Context) {
+	due, err := s.manager.DuePublishes()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"error": err,
+		}).Error("Failed to find pending pushes")
+		return
+	}
+	for _, pending := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		fields := log.Fields{
+			"target": pending.Target,
+			"repo":   pending.Repo,
+		}
+		started := time.Now()
+		state, err := s.manager.Publish(ctx, pending.Target, pending.Repo)
+		if err != nil {
+			if state != nil {
+				fields["failures"] = state.Failures
+				fields["retry"] = state.NextAttempt
+			}
+			fields["error"] = err
+			log.WithFields(fields).Error("Failed to push repository to publish target")
+			s.webhooks.PublishFailed(pending.Target, pending.Repo, err)
+			s.events.Publish(&libferry.Event{
+				Event:   EventPublishFailed,
+				Repo:    pending.Repo,
+				Message: fmt.Sprintf("%s: %v", pending.Target, err),
+			})
+			continue
+		}
+		fields["duration"] = time.Since(started)
+		log.WithFields(fields).Info("Pushed repository to publish target")
+	}
+}
@@ -0,0 +1,110 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"errors"
+	"ferryd/core"
+	"ferryd/jobs"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libdb"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// errDegraded is sent to clients asking a degraded ferryd to change anything
+	errDegraded = errors.New("the database is corrupted and couldn't be recovered, so only reads are served")
+)
+
+// openManager will open the repositories, recovering the database should it
+// have been corrupted, i.e. if we were killed during a compaction. When it
+// can't be recovered we carry on in degraded mode, only serving reads, so
+// that the repositories stay available while the operator steps in.
+func (s *Server) openManager() (*core.Manager, error) {
+	base, backend, roots := s.config.BaseDir, s.config.Database, s.config.Storage.roots()
+	m, err := core.NewManagerWithStorage(base, backend, roots)
+	if !libdb.IsCorrupted(err) {
+		return m, err
+	}
+
+	// Nothing else has the chance to set them up before we need them
+	s.webhooks.SetHooks(s.config.Webhooks)
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Error("Database is corrupted, attempting recovery")
+	s.notifyDatabase(EventDatabaseCorrupted, err.Error())
+
+	backup, err := core.RecoverDatabase(base)
+	if err == nil {
+		if m, err = core.NewManagerWithStorage(base, backend, roots); err == nil {
+			log.WithFields(log.Fields{
+				"backup": backup,
+			}).Warning("Recovered corrupted database, some recent changes may be lost")
+			s.notifyDatabase(EventDatabaseRecovered, fmt.Sprintf("recovered, the damaged database is kept at %s", backup))
+			return m, nil
+		}
+	}
+	log.WithFields(log.Fields{
+		"error":  err,
+		"backup": backup,
+	}).Error("Failed to recover database, only serving reads")
+
+	// The database may be too damaged to open even for reading, in which
+	// case we read from a repaired copy of it instead
+	m, err = core.NewManagerReadOnlyWithStorage(base, backend, roots)
+	if libdb.IsCorrupted(err) {
+		m, err = core.NewManagerRecoveredWithStorage(base, backend, roots)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.degraded = true
+	s.notifyDatabase(EventDatabaseDegraded, "recovery failed, only serving reads")
+	return m, nil
+}
+
+// openJobStore will open the job database, recovering it should it have
+// been corrupted. Unlike the repositories there's no point carrying on
+// without it.
+func (s *Server) openJobStore() (*jobs.JobStore, error) {
+	st, err := jobs.NewStore(s.config.BaseDir)
+	if !libdb.IsCorrupted(err) {
+		return st, err
+	}
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Error("Job database is corrupted, attempting recovery")
+	backup, err := libdb.Recover(filepath.Join(s.config.BaseDir, core.JobDbPathComponent))
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"backup": backup,
+	}).Warning("Recovered corrupted job database")
+	return jobs.NewStore(s.config.BaseDir)
+}
+
+// notifyDatabase will tell the operator what happened to the database
+func (s *Server) notifyDatabase(event, message string) {
+	s.webhooks.Send(&WebhookEvent{
+		Event:   event,
+		Time:    time.Now().UTC(),
+		Message: message,
+	})
+}
@@ -0,0 +1,190 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"context"
+	"ferryd/jobs"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReplicationInterval is how often every followed repository is
+	// replicated, in case the event for a change was missed
+	DefaultReplicationInterval = 15 * time.Minute
+
+	// replicationRetry is how long we wait before reconnecting to the
+	// primary after losing its event stream
+	replicationRetry = 30 * time.Second
+)
+
+// The Replicator keeps our repositories in step with those of a primary
+// ferryd. It follows the primary's event stream, queueing a ReplicateRepo
+// job whenever the primary publishes a new index for a repository we follow.
+type Replicator struct {
+	jproc  *jobs.Processor
+	config ReplicationConfig // What we're currently following
+	cancel context.CancelFunc
+	group  *sync.WaitGroup
+	mut    *sync.Mutex
+}
+
+// NewReplicator will return a Replicator which isn't following anything yet
+func NewReplicator(jproc *jobs.Processor) *Replicator {
+	return &Replicator{
+		jproc: jproc,
+		group: &sync.WaitGroup{},
+		mut:   &sync.Mutex{},
+	}
+}
+
+// SetConfig will start following the configured primary, restarting if the
+// configuration changed. Replication stops when no primary is set.
+func (r *Replicator) SetConfig(config ReplicationConfig) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.cancel != nil && reflect.DeepEqual(config, r.config) {
+		return
+	}
+	r.stopLocked()
+	r.config = config
+	if config.Primary == "" {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.group.Add(2)
+	go r.follow(ctx, config)
+	go r.poll(ctx, config)
+	log.WithFields(log.Fields{
+		"primary": config.Primary,
+		"repos":   config.Repos,
+	}).Info("Replicating from primary")
+}
+
+// Stop will stop following the primary. Replications already queued are
+// left to run.
+func (r *Replicator) Stop() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.stopLocked()
+}
+
+// stopLocked does the work of Stop, and requires that mut is already held
+func (r *Replicator) stopLocked() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.group.Wait()
+	r.cancel = nil
+}
+
+// queue will schedule replication of the repository
+func (r *Replicator) queue(config ReplicationConfig, repoID string) {
+	if err := r.jproc.PushJob(jobs.NewReplicateRepoJob(repoID, config.Primary)); err != nil {
+		log.WithFields(log.Fields{
+			"repo":  repoID,
+			"error": err,
+		}).Error("Failed to queue replication")
+	}
+}
+
+// queueAll will schedule replication of every repository we follow
+func (r *Replicator) queueAll(ctx context.Context, client *libferry.Client, config ReplicationConfig) error {
+	repos, err := client.GetReposContext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, repoID := range repos {
+		if config.follows(repoID) {
+			r.queue(config, repoID)
+		}
+	}
+	return nil
+}
+
+// follow will replicate each repository the primary indexes until ctx is
+// done, reconnecting whenever the event stream is lost. Everything is
+// replicated after connecting, to catch up with anything missed meanwhile.
+func (r *Replicator) follow(ctx context.Context, config ReplicationConfig) {
+	defer r.group.Done()
+
+	client := libferry.NewClient(config.Primary)
+	defer client.Close()
+
+	events := []string{libferry.EventIndexPublished, libferry.EventRepoCreated}
+	for {
+		err := r.queueAll(ctx, client, config)
+		if err == nil {
+			err = client.EventsContext(ctx, events, 0, func(event *libferry.Event) error {
+				if config.follows(event.Repo) {
+					r.queue(config, event.Repo)
+				}
+				return nil
+			})
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.WithFields(log.Fields{
+			"primary": config.Primary,
+			"error":   err,
+			"retry":   replicationRetry,
+		}).Warning("Lost contact with the primary")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(replicationRetry):
+		}
+	}
+}
+
+// poll will periodically replicate every repository we follow until ctx is
+// done, in case the event for a change never reached us
+func (r *Replicator) poll(ctx context.Context, config ReplicationConfig) {
+	defer r.group.Done()
+	if config.Interval.Duration <= 0 {
+		return
+	}
+
+	client := libferry.NewClient(config.Primary)
+	defer client.Close()
+
+	ticker := time.NewTicker(config.Interval.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := r.queueAll(ctx, client, config); err != nil && ctx.Err() == nil {
+			log.WithFields(log.Fields{
+				"primary": config.Primary,
+				"error":   err,
+			}).Warning("Failed to list repositories on the primary")
+		}
+	}
+}
@@ -17,32 +17,41 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"ferryd/core"
 	"ferryd/jobs"
+	"fmt"
 	"github.com/coreos/go-systemd/activation"
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/julienschmidt/httprouter"
-	"github.com/radu-munteanu/fsnotify"
 	log "github.com/sirupsen/logrus"
+	"libeopkg"
+	"libferry"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"reflect"
 	"sync"
 	"syscall"
 	"time"
 )
 
 // Server sits on a unix socket accepting connections from authenticated
-// client, i.e. root or those in the "ferry" group
+// client, i.e. root or those in the "ferry" group. Every client identified
+// by its peer credentials may query the daemon, but only those may use the
+// privileged operations.
 type Server struct {
 	srv     *http.Server
 	running bool
 	router  *httprouter.Router
 	socket  net.Listener
 
+	// Every API route, reported as capabilities and in the OpenAPI document
+	routes []apiRoute
+
 	// We store a global lock file ..
 	lockFile *LockFile
 	lockPath string
@@ -50,30 +59,78 @@ type Server struct {
 	// When we first started up.
 	timeStarted time.Time
 
-	manager    *core.Manager     // heart of the story
-	store      *jobs.JobStore    // Storage for jobs processor
-	jproc      *jobs.Processor   // Allow scheduling jobs
-	watcher    *fsnotify.Watcher // Monitor incoming uploads
-	watchChan  chan bool         // Allow terminating the watcher
-	watchGroup *sync.WaitGroup   // Allow blocking watch terminate.
+	manager    *core.Manager               // heart of the story
+	store      *jobs.JobStore              // Storage for jobs processor
+	jproc      *jobs.Processor             // Allow scheduling jobs
+	watchers   map[string]*incomingWatcher // Monitor incoming uploads, keyed by repo
+	watchMut   *sync.Mutex                 // Guard adding and removing watchers
+	watchGroup *sync.WaitGroup             // Allow blocking watch terminate.
+
+	incomingStatus libferry.IncomingStatus // Rescan results, guarded by watchMut
+
 	socketPath string
+	files      *FileServer    // Optional read-only HTTP repository server
+	metrics    *MetricsServer // Optional Prometheus metrics
+	logFile    *LogFile       // Reopened on SIGHUP
+
+	config    *Config          // Current configuration, replaced on SIGHUP
+	configMut *sync.Mutex      // Serialise reloads
+	webhooks  *WebhookNotifier // Notify remote hosts of events
+	hooks     *HookRunner      // Run post-publish hooks after each index
+	events    *EventHub        // Stream events to clients
+
+	confirmations *ConfirmationStore // Pending destructive actions
+	limiter       *RequestLimiter    // Refuse requests over the configured limits
+	auth          *Authorizer        // Decide who may use privileged operations
+
+	publishWake   chan struct{}      // Poke the publisher when a push is due
+	publishCancel context.CancelFunc // Abort the publisher on close
+	publishGroup  *sync.WaitGroup
+
+	replicator *Replicator  // Follow a primary ferryd, if configured
+	cleaner    *TempCleaner // Remove leftover temporary files
+
+	standby    bool          // Only serve reads until promoted
+	promoted   bool          // Was a standby, so never replicates again
+	standbyMut *sync.RWMutex // Guards standby and promoted
+
+	degraded bool // The database couldn't be recovered, so only serve reads
 }
 
 // NewServer will return a newly initialised Server which is currently unbound
-func NewServer() (*Server, error) {
+func NewServer(config *Config) (*Server, error) {
 	router := httprouter.New()
 	s := &Server{
 		srv: &http.Server{
-			Handler: router,
+			Handler:     router,
+			ConnContext: peerContext,
 		},
 		running:     false,
 		router:      router,
 		timeStarted: time.Now().UTC(),
+		watchers:    make(map[string]*incomingWatcher),
+		watchMut:    &sync.Mutex{},
 		watchGroup:  &sync.WaitGroup{},
+		config:      config,
+		configMut:   &sync.Mutex{},
+		webhooks:    NewWebhookNotifier(),
+		events:      NewEventHub(),
+
+		confirmations: NewConfirmationStore(),
+		limiter:       NewRequestLimiter(),
+		auth:          NewAuthorizer(),
+
+		publishWake:  make(chan struct{}, 1),
+		publishGroup: &sync.WaitGroup{},
+
+		standby:    config.Standby,
+		standbyMut: &sync.RWMutex{},
 	}
 
+	s.hooks = NewHookRunner(filepath.Join(config.BaseDir, core.RepoPathComponent), s.webhooks)
+
 	// Before we can actually bind the socket, we must lock the file
-	s.lockPath = filepath.Join(baseDir, LockFilePath)
+	s.lockPath = filepath.Join(config.BaseDir, LockFilePath)
 	lfile, err := NewLockFile(s.lockPath)
 	s.lockFile = lfile
 
@@ -86,37 +143,394 @@ func NewServer() (*Server, error) {
 		return nil, err
 	}
 
+	// Unknown routes get the same error responses as everything else
+	router.NotFound = http.HandlerFunc(s.NotFound)
+	router.MethodNotAllowed = http.HandlerFunc(s.MethodNotAllowed)
+
 	// Set up the API bits
-	router.GET("/api/v1/status", s.GetStatus)
+	s.handle(http.MethodGet, "/api/v1/openapi.json", s.GetOpenAPI, apiDoc{
+		Summary: "Describe the API as an OpenAPI 3 document",
+	})
+	s.handle(http.MethodGet, "/api/v1/capabilities", s.GetCapabilities, apiDoc{
+		Summary:  "Report the operations and schema versions supported",
+		Response: libferry.CapabilitiesRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/status", s.GetStatus, apiDoc{
+		Summary:  "Report the status of the daemon and its jobs",
+		Response: libferry.StatusRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/status/wait", s.WaitStatus, apiDoc{
+		Summary:  "Report the status once the job generation changes",
+		Query:    []apiParam{{"since", "integer", "Generation of the last status seen"}},
+		Response: libferry.StatusRequest{},
+		Wait:     true,
+	})
+	s.handle(http.MethodGet, "/api/v1/job/:id", s.GetJob, apiDoc{
+		Summary:  "Report on a single job",
+		Response: libferry.JobStatusRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/jobs/completed", s.GetCompletedJobs, apiDoc{
+		Summary:  "List successfully completed jobs, newest first",
+		Query:    []apiParam{{"repo", "string", "Only list jobs acting on this repository"}, offsetParam, limitParam},
+		Response: libferry.JobListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/jobs/failed", s.GetFailedJobs, apiDoc{
+		Summary:  "List failed jobs, newest first",
+		Query:    []apiParam{{"repo", "string", "Only list jobs acting on this repository"}, offsetParam, limitParam},
+		Response: libferry.JobListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/events", s.StreamEvents, apiDoc{
+		Summary:     "Stream daemon events as server-sent events",
+		Query:       []apiParam{{"events", "string", "Comma separated events to stream, all by default"}},
+		Response:    libferry.Event{},
+		ContentType: "text/event-stream",
+		Wait:        true,
+	})
 
 	// Repo management
-	router.GET("/api/v1/create/repo/:id", s.CreateRepo)
-	router.GET("/api/v1/remove/repo/:id", s.DeleteRepo)
-	router.GET("/api/v1/delta/repo/:id", s.DeltaRepo)
-	router.GET("/api/v1/index/repo/:id", s.IndexRepo)
+	s.handle(http.MethodGet, "/api/v1/create/repo/:id", s.CreateRepo, apiDoc{
+		Summary:  "Queue the creation of a repository",
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/remove/repo/:id", s.DeleteRepo, apiDoc{
+		Summary:    "Queue the deletion of a repository",
+		Request:    libferry.DeleteRepoRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/delta/repo/:id", s.DeltaRepo, apiDoc{
+		Summary:  "Queue delta production for every package in a repository",
+		Query:    []apiParam{{"history", "string", "Only produce deltas for packages changed by this history entry"}},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/delta/retry/:id", s.RetryDeltas, apiDoc{
+		Summary:  "Forget which deltas of a package failed, and queue producing them again",
+		Request:  libferry.DeltaRetryRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodGet, "/api/v1/index/repo/:id", s.IndexRepo, apiDoc{
+		Summary:  "Queue the indexing of a repository",
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodGet, "/api/v1/validate/index/:id", s.ValidateIndex, apiDoc{
+		Summary:  "Queue a check of the published index of a repository",
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodGet, "/api/v1/relink/repo/:id", s.RelinkRepo, apiDoc{
+		Summary:  "Queue relinking the files of a repository against the pool",
+		Response: libferry.JobResponse{},
+	})
 
 	// Client sends us data
-	router.POST("/api/v1/import/:id", s.ImportPackages)
-	router.POST("/api/v1/clone/:id", s.CloneRepo)
-	router.POST("/api/v1/copy/source/:id", s.CopySource)
-	router.POST("/api/v1/pull/:id", s.PullRepo)
+	s.handle(http.MethodPost, "/api/v1/import/:id", s.ImportPackages, apiDoc{
+		Summary:  "Queue the import of packages into a repository",
+		Request:  libferry.ImportRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/import-directory/:id", s.ImportDirectory, apiDoc{
+		Summary:    "Queue the import of every package in a directory",
+		Request:    libferry.ImportDirectoryRequest{},
+		Response:   libferry.JobResponse{},
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/clone/:id", s.CloneRepo, apiDoc{
+		Summary:  "Queue the cloning of a repository",
+		Request:  libferry.CloneRepoRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/copy/source/:id", s.CopySource, apiDoc{
+		Summary:  "Queue copying a source release to another repository",
+		Request:  libferry.CopySourceRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/pull/:id", s.PullRepo, apiDoc{
+		Summary:  "Queue pulling newer packages from another repository",
+		Request:  libferry.PullRepoRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/pull-source/:id", s.PullSource, apiDoc{
+		Summary:  "Queue pulling a single source from another repository",
+		Request:  libferry.PullSourceRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/promote/:id", s.Promote, apiDoc{
+		Summary:  "Queue the promotion of a source release to another repository",
+		Request:  libferry.PromoteRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodPost, "/api/v1/rewrite/:id", s.RewriteMetadata, apiDoc{
+		Summary:    "Queue a rewrite of the metadata of a stored package",
+		Request:    libferry.RewriteMetadataRequest{},
+		Response:   libferry.JobResponse{},
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/batch", s.Batch, apiDoc{
+		Summary:  "Queue a chain of jobs, each depending on the last",
+		Request:  libferry.BatchRequest{},
+		Response: libferry.BatchResponse{},
+	})
 
 	// Removal
-	router.POST("/api/v1/remove/source/:id", s.RemoveSource)
-	router.POST("/api/v1/trim/packages/:id", s.TrimPackages)
-	router.GET("/api/v1/trim/obsoletes/:id", s.TrimObsolete)
+	s.handle(http.MethodPost, "/api/v1/remove/source/:id", s.RemoveSource, apiDoc{
+		Summary:    "Queue the removal of a source from a repository",
+		Request:    libferry.RemoveSourceRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/trim/packages/:id", s.TrimPackages, apiDoc{
+		Summary:    "Queue trimming old releases from a repository",
+		Request:    libferry.TrimPackagesRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/trim/obsoletes/:id", s.TrimObsolete, apiDoc{
+		Summary:    "Queue the removal of obsolete packages from a repository",
+		Request:    libferry.TrimObsoleteRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/trim/deltas/:id", s.TrimDeltas, apiDoc{
+		Summary:  "Queue the removal of stale deltas from a repository",
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodGet, "/api/v1/trim/temp", s.TrimTemp, apiDoc{
+		Summary:  "Queue the removal of leftover temporary files",
+		Query:    []apiParam{{"age", "string", "Only remove files older than this duration"}},
+		Response: libferry.JobResponse{},
+	})
 
 	// Reset jobs are special and go straight to the store
 	// We can't queue them as a job because we'd be in catch 22..
-	router.GET("/api/v1/reset/completed", s.ResetCompleted)
-	router.GET("/api/v1/reset/failed", s.ResetFailed)
+	s.handle(http.MethodGet, "/api/v1/reset/completed", s.ResetCompleted, apiDoc{
+		Summary:    "Forget the completed jobs",
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/reset/failed", s.ResetFailed, apiDoc{
+		Summary:    "Forget the failed jobs",
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+
+	// Database maintenance
+	s.handle(http.MethodGet, "/api/v1/backup/db", s.BackupDatabase, apiDoc{
+		Summary:     "Stream a backup of the database",
+		ContentType: "application/octet-stream",
+		Privileged:  true,
+	})
+	s.handle(http.MethodGet, "/api/v1/migrations", s.GetMigrationStatus, apiDoc{
+		Summary:  "Report the schema of the database",
+		Response: libferry.MigrationStatusRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/migrate/pool/:layout", s.MigratePool, apiDoc{
+		Summary:    "Queue moving the pool to another layout",
+		Response:   libferry.JobResponse{},
+		Privileged: true,
+	})
+
+	// Move repositories between instances
+	s.handle(http.MethodGet, "/api/v1/repo/export/:id", s.ExportRepo, apiDoc{
+		Summary:     "Export a repository and its packages as a tar archive",
+		ContentType: "application/x-tar",
+	})
+	s.handle(http.MethodPost, "/api/v1/repo/import", s.ImportRepo, apiDoc{
+		Summary:    "Queue creating a repository from an uploaded tar archive",
+		Query:      []apiParam{{"name", "string", "Name of the new repository, if not the exported one"}},
+		Response:   libferry.JobResponse{},
+		Privileged: true,
+	})
+
+	// Replication
+	s.handle(http.MethodGet, "/api/v1/repo/manifest/:id", s.GetRepoManifest, apiDoc{
+		Summary:  "Describe every package, delta and asset of a repository",
+		Response: libferry.RepoManifestRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/pool/file/:id", s.GetPoolFile, apiDoc{
+		Summary:     "Download the file of a pool entry",
+		ContentType: "application/octet-stream",
+	})
+
+	s.handle(http.MethodPost, "/api/v1/standby/promote", s.PromoteStandby, apiDoc{
+		Summary:    "Promote a standby to primary, so that it accepts writes",
+		Request:    libferry.PromoteStandbyRequest{},
+		Response:   libferry.Response{},
+		Confirm:    true,
+		Standby:    true,
+		Privileged: true,
+	})
 
 	// List commands
-	router.GET("/api/v1/list/repos", s.GetRepos)
-	router.GET("/api/v1/list/pool", s.GetPoolItems)
+	s.handle(http.MethodGet, "/api/v1/list/repos", s.GetRepos, apiDoc{
+		Summary:  "List the repositories, with a summary of each",
+		Response: libferry.RepoListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/list/pool", s.GetPoolItems, apiDoc{
+		Summary: "List the pool entries",
+		Query: []apiParam{
+			{"prefix", "string", "Only list entries whose ID begins with this"},
+			{"orphaned", "boolean", "Only list entries no repository refers to when true"},
+			offsetParam,
+			limitParam,
+		},
+		Response: libferry.PoolListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/list/packages/:id", s.GetPackages, apiDoc{
+		Summary:  "List the published packages of a repository",
+		Query:    []apiParam{{"glob", "string", "Only list packages with matching names"}, offsetParam, limitParam},
+		Response: libferry.PackageListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/info/:id/:package", s.GetPackageInfo, apiDoc{
+		Summary:  "Report everything known about a package in a repository",
+		Response: libferry.PackageInfoRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/search", s.Search, apiDoc{
+		Summary:  "Search for packages across repositories",
+		Query:    []apiParam{{"q", "string", "Pattern to search for"}, {"regex", "boolean", "Treat the pattern as a regular expression"}, {"repo", "string", "Only search this repository"}},
+		Response: libferry.SearchRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/diff/:id/:target", s.DiffRepos, apiDoc{
+		Summary:  "Report the differences between two repositories",
+		Response: libferry.RepoDiffRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/history/:id", s.GetHistory, apiDoc{
+		Summary:  "List the changes made to a repository, newest first",
+		Query:    []apiParam{{"package", "string", "Only list changes to this package"}, limitParam},
+		Response: libferry.HistoryRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/repo/config/:id", s.GetRepoConfig, apiDoc{
+		Summary:  "Report the settings of a repository",
+		Response: libferry.RepoConfigRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/repo/config/:id", s.SetRepoConfig, apiDoc{
+		Summary:    "Change the settings of a repository",
+		Request:    libferry.RepoConfigRequest{},
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/report/:id", s.GetIndexReport, apiDoc{
+		Summary:  "Report the problems found by the last index of a repository",
+		Response: libferry.IndexReportRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/delta/stats/:id", s.GetDeltaStats, apiDoc{
+		Summary:  "Report how much the deltas of a repository save, least effective first",
+		Query:    []apiParam{limitParam},
+		Response: libferry.DeltaStatsRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/delta/skipped/:id", s.GetDeltaSkips, apiDoc{
+		Summary:  "List the deltas of a repository which are skipped as pointless or failed",
+		Response: libferry.DeltaSkipListRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/hold/list/:id", s.GetHeld, apiDoc{
+		Summary:  "List the sources held in a repository",
+		Response: libferry.HoldRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/hold/add/:id", s.HoldSources, apiDoc{
+		Summary:    "Hold sources in a repository",
+		Request:    libferry.HoldRequest{},
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/hold/remove/:id", s.UnholdSources, apiDoc{
+		Summary:    "Release held sources in a repository",
+		Request:    libferry.HoldRequest{},
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/ban/list/:id", s.GetBans, apiDoc{
+		Summary:  "List the bans of a repository",
+		Response: libferry.BanRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/ban/add/:id", s.AddBans, apiDoc{
+		Summary:    "Ban packages from a repository",
+		Request:    libferry.BanRequest{},
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/ban/remove/:id", s.RemoveBans, apiDoc{
+		Summary:    "Lift bans from a repository",
+		Request:    libferry.BanRequest{},
+		Response:   libferry.Response{},
+		Privileged: true,
+	})
+
+	// Assets
+	s.handle(http.MethodGet, "/api/v1/asset/list/:id", s.GetAssets, apiDoc{
+		Summary:  "List the assets installed in a repository",
+		Response: libferry.AssetListingRequest{},
+	})
+	s.handle(http.MethodGet, "/api/v1/asset/get/:id/:name", s.GetAsset, apiDoc{
+		Summary:  "Return the contents of a repository asset",
+		Response: libferry.AssetRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/asset/set/:id", s.SetAsset, apiDoc{
+		Summary:    "Install a repository asset and queue a reindex",
+		Request:    libferry.AssetRequest{},
+		Response:   libferry.JobResponse{},
+		Privileged: true,
+	})
+
+	// Snapshots
+	s.handle(http.MethodPost, "/api/v1/snapshot/create/:id", s.CreateSnapshot, apiDoc{
+		Summary:  "Queue a snapshot of a repository",
+		Request:  libferry.SnapshotRequest{},
+		Response: libferry.JobResponse{},
+	})
+	s.handle(http.MethodGet, "/api/v1/snapshot/list/:id", s.GetSnapshots, apiDoc{
+		Summary:  "List the snapshots of a repository",
+		Response: libferry.SnapshotListingRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/snapshot/restore/:id", s.RestoreSnapshot, apiDoc{
+		Summary:    "Queue restoring a repository to a snapshot",
+		Request:    libferry.SnapshotRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodPost, "/api/v1/snapshot/delete/:id", s.DeleteSnapshot, apiDoc{
+		Summary:    "Queue the deletion of a snapshot",
+		Request:    libferry.SnapshotRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
+	s.handle(http.MethodGet, "/api/v1/undo", s.GetUndoJobs, apiDoc{
+		Summary:  "List the jobs which can still be undone",
+		Response: libferry.UndoListingRequest{},
+	})
+	s.handle(http.MethodPost, "/api/v1/undo/:job", s.UndoJob, apiDoc{
+		Summary:    "Queue undoing a job by restoring the snapshot taken before it",
+		Request:    libferry.UndoRequest{},
+		Response:   libferry.JobResponse{},
+		Confirm:    true,
+		Privileged: true,
+	})
 	return s, nil
 }
 
+// handle will register the API route behind the request limits, and record
+// it with its documentation as one of the operations we support. Privileged
+// routes are only available to authorized clients.
+func (s *Server) handle(method, path string, handle httprouter.Handle, doc apiDoc) {
+	name := handlerName(handle)
+	if doc.writes(method) {
+		handle = s.readOnly(handle)
+	}
+	if doc.Privileged {
+		handle = s.authorize(name, handle)
+	}
+	s.router.Handle(method, path, s.limit(name, doc.Wait, handle))
+	s.routes = append(s.routes, apiRoute{
+		method: method,
+		path:   path,
+		name:   name,
+		doc:    doc,
+	})
+}
+
 // killHandler will ensure we cleanly tear down on a ctrl+c/sigint
 func (s *Server) killHandler() {
 	ch := make(chan os.Signal, 1)
@@ -130,13 +544,180 @@ func (s *Server) killHandler() {
 	}()
 }
 
+// hupHandler will reopen the log file and reload the configuration on
+// SIGHUP, so that logrotate and tuning changes don't require a restart
+func (s *Server) hupHandler() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			if s.logFile != nil {
+				if err := s.logFile.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				} else {
+					log.Info("Reopened log file")
+				}
+			}
+			s.Reload()
+		}
+	}()
+}
+
+// Reload will load the configuration file again and apply any changes that
+// can be made at runtime. Jobs that are already executing are unaffected.
+func (s *Server) Reload() {
+	s.configMut.Lock()
+	defer s.configMut.Unlock()
+
+	// Close may already have torn everything down
+	if !s.running {
+		return
+	}
+
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"config": configPath,
+			"error":  err,
+		}).Error("Failed to reload configuration, keeping existing settings")
+		return
+	}
+
+	old := s.config
+	if config.BaseDir != old.BaseDir || config.Socket != old.Socket {
+		log.WithFields(log.Fields{
+			"base":   config.BaseDir,
+			"socket": config.Socket,
+		}).Warning("Changing the base directory or socket requires a restart")
+		config.BaseDir = old.BaseDir
+		config.Socket = old.Socket
+	}
+	if !reflect.DeepEqual(config.Storage, old.Storage) {
+		log.Warning("Changing the storage settings requires a restart")
+		config.Storage = old.Storage
+	}
+	if config.Standby != old.Standby {
+		log.Warning("Changing standby mode requires a restart, use promote-standby to promote")
+		config.Standby = old.Standby
+	}
+
+	s.applyConfig(config)
+
+	// Restart the file server if it moved
+	if config.HTTP != old.HTTP {
+		if s.files != nil {
+			s.files.Close()
+			s.files = nil
+		}
+		if config.HTTP != "" {
+			s.files = NewFileServer(filepath.Join(config.BaseDir, core.RepoPathComponent), config.HTTP)
+			if err := s.files.Start(); err != nil {
+				log.WithFields(log.Fields{
+					"address": config.HTTP,
+					"error":   err,
+				}).Error("Failed to start HTTP file server")
+				s.files = nil
+			}
+		}
+	}
+
+	// Likewise the metrics
+	if config.Metrics != old.Metrics {
+		if s.metrics != nil {
+			s.metrics.Close()
+			s.metrics = nil
+		}
+		if config.Metrics != "" {
+			s.metrics = NewMetricsServer(s.manager, config.Metrics)
+			if err := s.metrics.Start(); err != nil {
+				log.WithFields(log.Fields{
+					"address": config.Metrics,
+					"error":   err,
+				}).Error("Failed to start metrics server")
+				s.metrics = nil
+			}
+		}
+	}
+
+	if s.jproc != nil {
+		s.jproc.SetJobCount(config.Jobs)
+		s.jproc.SetJobLimits(config.jobLimits())
+		s.jproc.SetIndexDelay(config.Index.Debounce.Duration)
+		// Already validated when loading the configuration
+		priorities, _ := config.jobPriorities()
+		s.jproc.SetPriorities(priorities)
+	}
+	s.standbyMut.RLock()
+	if s.replicator != nil && !s.promoted && !s.degraded {
+		s.replicator.SetConfig(config.Replication)
+	}
+	s.standbyMut.RUnlock()
+	if s.cleaner != nil {
+		s.cleaner.SetConfig(config.Temp)
+	}
+
+	s.config = config
+	log.WithFields(log.Fields{
+		"config": configPath,
+	}).Info("Reloaded configuration")
+}
+
+// applyConfig will apply those settings which don't need any special
+// handling to change at runtime. Everything was validated when the
+// configuration was loaded, so errors are ignored here.
+func (s *Server) applyConfig(config *Config) {
+	setLogFormat(config.Log.Format)
+	if s.logFile != nil {
+		s.logFile.SetRotation(int64(config.Log.MaxSize)*1024*1024, config.Log.MaxBackups, config.Log.Compress)
+	}
+	libeopkg.SetXzOptions(config.Compression.Level, config.Compression.Threads)
+	libeopkg.SetXzMemoryBudget(config.Compression.Memory)
+	libeopkg.SetXzCompressor(config.Compression.Xz)
+	if xz := libeopkg.XzCompressor(); xz.Name() != config.Compression.Xz {
+		log.WithFields(log.Fields{
+			"compressor": config.Compression.Xz,
+			"using":      xz.Name(),
+		}).Warning("xz tool not found, using the slower pure Go xz")
+	}
+	libeopkg.SetInternalCompressor(config.Compression.Internal)
+	s.webhooks.SetHooks(config.Webhooks)
+	hooks, _ := config.postHooks()
+	s.hooks.SetHooks(hooks)
+	s.manager.SetUndoRetention(config.Undo.Duration)
+	s.manager.SetMinFreeSpace(uint64(config.Disk.MinFree) * 1024 * 1024)
+	core.SetHashOptions(config.Hash.ChunkSize*1024, config.Hash.ReadAhead, config.Hash.Parallel)
+	core.SetIndexWriteOptions(config.Index.Buffer*1024, config.Index.Preallocate)
+	s.manager.SetDeltaSkipExpiry(config.Delta.SkipExpiry.Duration)
+	defaults, _ := config.RepoDefaults.settings()
+	s.manager.SetRepoDefaults(defaults)
+	targets, _ := config.publishTargets()
+	s.manager.SetPublishTargets(targets)
+	s.applyLimits(config)
+	s.auth.SetGroup(config.Auth.Group)
+}
+
+// spaceRefused is called by the manager whenever an import or delta is refused
+// to protect the disk, or by a repository quota
+func (s *Server) spaceRefused(err error) {
+	log.WithFields(log.Fields{
+		"error": err,
+	}).Warning("Refused operation to protect disk space")
+	s.webhooks.SpaceRefused(err)
+
+	event := &libferry.Event{Event: EventDiskLow, Message: err.Error()}
+	if qerr, ok := err.(*core.QuotaError); ok {
+		event.Event = EventQuotaExceeded
+		event.Repo = qerr.Repo
+	}
+	s.events.Publish(event)
+}
+
 // Bind will attempt to set up the listener on the unix socket
 // prior to serving.
 func (s *Server) Bind() error {
 	var listener net.Listener
 
-	// Set from global CLI flag
-	s.socketPath = socketPath
+	s.socketPath = s.config.Socket
 
 	// Check if we're systemd activated.
 	if _, b := os.LookupEnv("LISTEN_FDS"); b {
@@ -164,28 +745,65 @@ func (s *Server) Bind() error {
 		listener = l
 	}
 
-	m, e := core.NewManager(baseDir)
+	m, e := s.openManager()
 	if e != nil {
 		return e
 	}
 	s.manager = m
+	s.manager.SetSpaceRefusedFunc(s.spaceRefused)
+	s.manager.SetRepoEventFunc(s.repoEvent)
 
-	st, e := jobs.NewStore(baseDir)
+	objects, e := s.config.Storage.S3.store()
+	if e != nil {
+		return e
+	}
+	s.manager.SetObjectStore(objects)
+
+	s.applyConfig(s.config)
+
+	st, e := s.openJobStore()
 	if e != nil {
 		return e
 	}
 	s.store = st
 
-	s.jproc = jobs.NewProcessor(s.manager, s.store, backgroundJobCount)
+	s.jproc = jobs.NewProcessor(s.manager, s.store, s.config.Jobs)
+	s.jproc.SetJobLimits(s.config.jobLimits())
+	s.jproc.SetIndexDelay(s.config.Index.Debounce.Duration)
+	priorities, _ := s.config.jobPriorities()
+	s.jproc.SetPriorities(priorities)
+	s.jproc.AddListener(s.webhooks.JobRetired)
+	s.jproc.AddListener(s.jobRetired)
+	s.jproc.AddEventListener(s.jobEvent)
+	s.replicator = NewReplicator(s.jproc)
+	s.cleaner = NewTempCleaner(s.jproc)
 
 	// Set up watching the manager's incoming directory
 	if err := s.InitWatcher(); err != nil {
 		return err
 	}
 
+	// Only serve the repositories if asked to
+	if s.config.HTTP != "" {
+		s.files = NewFileServer(filepath.Join(s.config.BaseDir, core.RepoPathComponent), s.config.HTTP)
+	}
+	if s.config.Metrics != "" {
+		s.metrics = NewMetricsServer(s.manager, s.config.Metrics)
+	}
+
 	uid := os.Getuid()
 	gid := os.Getgid()
 	if !systemdEnabled {
+		// Let the authorized group reach us, if we're allowed to
+		if group, ok := s.auth.Group(); ok {
+			if e = os.Chown(s.socketPath, uid, group); e == nil {
+				gid = group
+			} else {
+				log.WithFields(log.Fields{
+					"error": e,
+				}).Warning("Failed to give the authorization group the socket")
+			}
+		}
 		// Avoid umask issues
 		if e = os.Chown(s.socketPath, uid, gid); e != nil {
 			return e
@@ -204,14 +822,40 @@ func (s *Server) Serve() error {
 	if s.socket == nil {
 		return errors.New("Cannot serve without a bound server socket")
 	}
+	s.configMut.Lock()
 	s.running = true
+	s.configMut.Unlock()
 	s.killHandler()
+	s.hupHandler()
 	defer func() {
+		s.configMut.Lock()
 		s.running = false
+		s.configMut.Unlock()
 	}()
-	// Serve the job queue
+	// Serve the job queue, once anything interrupted last time is dealt with.
+	// Without a working database they're left for the next start.
+	s.sweepTemp()
+	if !s.degraded {
+		s.jproc.RecoverJobs()
+	}
 	s.jproc.Begin()
-	s.WatchIncoming()
+	if s.degraded {
+		log.Error("Running degraded, only serving reads until the database is repaired")
+	} else if s.inStandby() {
+		log.WithFields(log.Fields{
+			"primary": s.config.Replication.Primary,
+		}).Info("Running as a standby, only serving reads")
+	} else {
+		s.startPrimary()
+	}
+	if !s.degraded {
+		s.replicator.SetConfig(s.config.Replication)
+	}
+	s.cleaner.SetConfig(s.config.Temp)
+
+	if err := s.startListeners(); err != nil {
+		return err
+	}
 
 	if systemdEnabled {
 		daemon.SdNotify(false, "READY=1")
@@ -224,21 +868,58 @@ func (s *Server) Serve() error {
 	return nil
 }
 
+// startListeners will start the file and metrics servers, if configured.
+// Reload replaces them, so they're only touched with the configMut held.
+func (s *Server) startListeners() error {
+	s.configMut.Lock()
+	defer s.configMut.Unlock()
+	if s.files != nil {
+		if err := s.files.Start(); err != nil {
+			return err
+		}
+	}
+	if s.metrics != nil {
+		if err := s.metrics.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close will shut down and cleanup the socket
 func (s *Server) Close() {
+	// Once we're no longer running, Reload leaves the listeners alone
+	s.configMut.Lock()
 	if !s.running {
+		s.configMut.Unlock()
 		return
 	}
+	s.running = false
+	if s.files != nil {
+		s.files.Close()
+		s.files = nil
+	}
+	if s.metrics != nil {
+		s.metrics.Close()
+		s.metrics = nil
+	}
+	s.configMut.Unlock()
+
 	if s.lockFile != nil {
 		s.lockFile.Unlock()
 		s.lockFile.Clean()
 		s.lockFile = nil
 	}
 	s.StopWatching()
+	s.replicator.Stop()
+	s.cleaner.Stop()
 	s.jproc.Close()
+	s.stopPublisher()
+	s.hooks.Stop()
 	s.store.Close()
 	s.manager.Close()
-	s.running = false
+	// Streams would otherwise hold up the shutdown
+	s.events.Close()
 	s.srv.Shutdown(nil)
 
 	// We don't technically fully own it if systemd created it
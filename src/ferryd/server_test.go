@@ -0,0 +1,50 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestReloadStopped will ensure a reload racing with shutdown doesn't start
+// listeners that Close would then leak
+func TestReloadStopped(t *testing.T) {
+	s, cleanup := newTestServer(t)
+	defer cleanup()
+
+	path := filepath.Join(s.config.BaseDir, "ferryd.conf")
+	conf := fmt.Sprintf("base = %q\nhttp = \"127.0.0.1:0\"\n", s.config.BaseDir)
+	if err := ioutil.WriteFile(path, []byte(conf), 00644); err != nil {
+		t.Fatalf("Failed to write configuration: %v", err)
+	}
+	defer func(old string) { configPath = old }(configPath)
+	configPath = path
+
+	// Never served, or already closed
+	s.Close()
+	s.Reload()
+	if s.files != nil {
+		s.files.Close()
+		t.Fatalf("Reload started the file server after Close")
+	}
+	if s.config.HTTP != "" {
+		t.Fatalf("Reload applied the configuration after Close")
+	}
+}
@@ -0,0 +1,183 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"ferryd/jobs"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"net/http"
+	"time"
+)
+
+const (
+	// primaryCheckTimeout is how long we wait for the primary to answer
+	// before deciding it's gone
+	primaryCheckTimeout = 5 * time.Second
+)
+
+var (
+	// errStandby is sent to clients asking a standby to change anything
+	errStandby = errors.New("this ferryd is a standby and only serves reads until promoted")
+)
+
+// writes will determine if the route changes anything, so must be refused
+// while in standby. Anything other than a GET does, as does any GET which
+// queues a job.
+func (d *apiDoc) writes(method string) bool {
+	if d.Standby {
+		return false
+	}
+	if method != http.MethodGet {
+		return true
+	}
+	_, ok := d.Response.(libferry.JobResponse)
+	return ok
+}
+
+// inStandby will determine if we're currently a standby
+func (s *Server) inStandby() bool {
+	s.standbyMut.RLock()
+	defer s.standbyMut.RUnlock()
+	return s.standby
+}
+
+// readOnly will wrap the handler of a route which changes anything, so that
+// it's refused while we're a standby or degraded
+func (s *Server) readOnly(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+		if s.degraded {
+			s.sendStockError(&requestError{kind: libferry.ErrorReadOnly, err: errDegraded}, w, r)
+			return
+		}
+		if s.inStandby() {
+			s.sendStockError(&requestError{kind: libferry.ErrorReadOnly, err: errStandby}, w, r)
+			return
+		}
+		handle(w, r, p)
+	}
+}
+
+// startPrimary will start everything which only a primary does: importing
+// uploads and pushing to the publish targets
+func (s *Server) startPrimary() {
+	s.WatchIncoming()
+	s.startPublisher()
+}
+
+// primaryAlive will determine if the primary at the socket still answers
+func primaryAlive(socket string) bool {
+	client := libferry.NewClient(socket)
+	defer client.Close()
+	client.SetTimeout(primaryCheckTimeout)
+	client.SetRetries(0)
+	_, err := client.GetStatus()
+	return err == nil
+}
+
+// checkReplicationIdle will ensure no replication is queued or running, so
+// that nothing is left half replicated when we start accepting writes
+func (s *Server) checkReplicationIdle() error {
+	active, err := s.store.ActiveJobs()
+	if err != nil {
+		return err
+	}
+	for _, job := range active {
+		if job.Type == jobs.ReplicateRepo {
+			return &requestError{
+				kind: libferry.ErrorConflict,
+				err:  fmt.Errorf("replication of '%s' is still pending, try again once it completes", job.Repo),
+			}
+		}
+	}
+	return nil
+}
+
+// promote will turn the standby into a primary. We must still hold the
+// lockfile, the primary must be gone unless ignorePrimary is set, and any
+// replication still queued must have completed. Replication then stops for
+// good, and we start accepting writes.
+func (s *Server) promote(ignorePrimary bool) error {
+	// Serialises promotions, and stops a reload restarting replication
+	s.configMut.Lock()
+	defer s.configMut.Unlock()
+
+	if !s.inStandby() {
+		return &requestError{kind: libferry.ErrorConflict, err: errors.New("this ferryd is not a standby")}
+	}
+	if s.degraded {
+		return &requestError{kind: libferry.ErrorConflict, err: errDegraded}
+	}
+	if err := s.lockFile.Verify(); err != nil {
+		return fmt.Errorf("refusing to promote without the lockfile: %v", err)
+	}
+	primary := s.config.Replication.Primary
+	if primary != "" && !ignorePrimary && primaryAlive(primary) {
+		return &requestError{
+			kind: libferry.ErrorConflict,
+			err:  fmt.Errorf("the primary at %s is still running, stop it before promoting", primary),
+		}
+	}
+
+	// Nothing new may be queued while we check what's left
+	s.replicator.Stop()
+	if err := s.checkReplicationIdle(); err != nil {
+		s.replicator.SetConfig(s.config.Replication)
+		return err
+	}
+
+	s.standbyMut.Lock()
+	s.standby = false
+	s.promoted = true
+	s.standbyMut.Unlock()
+	s.startPrimary()
+
+	log.WithFields(log.Fields{
+		"primary": primary,
+	}).Warning("Promoted standby to primary, now accepting writes")
+	s.webhooks.Send(&WebhookEvent{
+		Event: EventStandbyPromoted,
+		Time:  time.Now().UTC(),
+	})
+	s.events.Publish(&libferry.Event{Event: EventStandbyPromoted})
+	return nil
+}
+
+// PromoteStandby will turn this standby into a primary, once confirmed
+func (s *Server) PromoteStandby(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	req := libferry.PromoteStandbyRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	if !s.inStandby() {
+		s.sendStockError(&requestError{kind: libferry.ErrorConflict, err: errors.New("this ferryd is not a standby")}, w, r)
+		return
+	}
+	if !s.confirmed("Promote this standby to primary", &req.Confirmation, w, r) {
+		return
+	}
+	if err := s.promote(req.IgnorePrimary); err != nil {
+		s.sendStockError(err, w, r)
+		return
+	}
+	s.sendResponse(&libferry.Response{}, w, r)
+}
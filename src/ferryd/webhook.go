@@ -0,0 +1,247 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"ferryd/core"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"libferry"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// EventJobCompleted is sent when a job completes successfully
+	EventJobCompleted = libferry.EventJobCompleted
+
+	// EventJobFailed is sent when a job fails
+	EventJobFailed = libferry.EventJobFailed
+
+	// EventJobOverdue is sent when a job has been running for longer than
+	// expected, and may be stuck
+	EventJobOverdue = libferry.EventJobOverdue
+
+	// EventDiskLow is sent when an import or delta is refused as it would
+	// leave too little free disk space
+	EventDiskLow = "disk.low"
+
+	// EventQuotaExceeded is sent when an import or delta is refused by the
+	// quota of a repository
+	EventQuotaExceeded = "repo.quota"
+
+	// EventPublishFailed is sent when a repository couldn't be pushed to a
+	// publish target
+	EventPublishFailed = "publish.failed"
+
+	// EventStandbyPromoted is sent once a standby has been promoted to
+	// primary and accepts writes
+	EventStandbyPromoted = "standby.promoted"
+
+	// EventDatabaseCorrupted is sent when the database is found to be
+	// corrupted at startup, before recovery is attempted
+	EventDatabaseCorrupted = "db.corrupted"
+
+	// EventDatabaseRecovered is sent once a corrupted database has been
+	// recovered
+	EventDatabaseRecovered = "db.recovered"
+
+	// EventDatabaseDegraded is sent when a corrupted database couldn't be
+	// recovered, and only reads are served
+	EventDatabaseDegraded = "db.degraded"
+)
+
+// webhookEvents are all of the events which may be sent to webhooks
+var webhookEvents = []string{
+	EventJobCompleted,
+	EventJobFailed,
+	EventJobOverdue,
+	EventDiskLow,
+	EventQuotaExceeded,
+	EventPublishFailed,
+	EventStandbyPromoted,
+	EventDatabaseCorrupted,
+	EventDatabaseRecovered,
+	EventDatabaseDegraded,
+	EventHookFailed,
+}
+
+// WebhookEvent is the JSON body POSTed to each webhook
+type WebhookEvent struct {
+	Event   string        `json:"event"`
+	Time    time.Time     `json:"time"`
+	Job     *libferry.Job `json:"job,omitempty"`
+	Repo    string        `json:"repo,omitempty"`
+	Message string        `json:"message,omitempty"`
+}
+
+// A WebhookNotifier sends events to the configured webhooks. Delivery is
+// best effort, we never hold up the job queue waiting on a remote host.
+type WebhookNotifier struct {
+	hooks  []WebhookConfig
+	mut    *sync.RWMutex
+	client *http.Client
+}
+
+// NewWebhookNotifier will return a notifier with no hooks configured
+func NewWebhookNotifier() *WebhookNotifier {
+	return &WebhookNotifier{
+		mut: &sync.RWMutex{},
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// SetHooks will replace the configured webhooks
+func (w *WebhookNotifier) SetHooks(hooks []WebhookConfig) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.hooks = hooks
+}
+
+// JobRetired implements jobs.JobListener
+func (w *WebhookNotifier) JobRetired(job *libferry.Job) {
+	event := EventJobCompleted
+	if job.Failed {
+		event = EventJobFailed
+	}
+	w.Send(&WebhookEvent{
+		Event: event,
+		Time:  time.Now().UTC(),
+		Job:   job,
+	})
+}
+
+// JobOverdue will notify interested webhooks that a job is taking longer
+// than expected
+func (w *WebhookNotifier) JobOverdue(job *libferry.Job) {
+	w.Send(&WebhookEvent{
+		Event: EventJobOverdue,
+		Time:  time.Now().UTC(),
+		Job:   job,
+	})
+}
+
+// SpaceRefused implements core.SpaceRefusedFunc
+func (w *WebhookNotifier) SpaceRefused(err error) {
+	event := &WebhookEvent{
+		Event:   EventDiskLow,
+		Time:    time.Now().UTC(),
+		Message: err.Error(),
+	}
+	if qerr, ok := err.(*core.QuotaError); ok {
+		event.Event = EventQuotaExceeded
+		event.Repo = qerr.Repo
+	}
+	w.Send(event)
+}
+
+// PublishFailed will notify interested webhooks that a push to a publish
+// target failed
+func (w *WebhookNotifier) PublishFailed(target, repoID string, err error) {
+	w.Send(&WebhookEvent{
+		Event:   EventPublishFailed,
+		Time:    time.Now().UTC(),
+		Repo:    repoID,
+		Message: fmt.Sprintf("%s: %v", target, err),
+	})
+}
+
+// Send will dispatch the event to every webhook interested in it
+func (w *WebhookNotifier) Send(event *WebhookEvent) {
+	w.mut.RLock()
+	defer w.mut.RUnlock()
+
+	var body []byte
+	for i := range w.hooks {
+		hook := w.hooks[i]
+		if !hook.wants(event.Event) {
+			continue
+		}
+		if body == nil {
+			b, err := json.Marshal(event)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"event": event.Event,
+					"error": err,
+				}).Error("Failed to encode webhook event")
+				return
+			}
+			body = b
+		}
+		go w.post(hook.URL, event.Event, body)
+	}
+}
+
+// post will send the body to a single webhook URL
+func (w *WebhookNotifier) post(url, event string, body []byte) {
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("unexpected status: %s", resp.Status)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"url":   url,
+			"event": event,
+			"error": err,
+		}).Warning("Failed to deliver webhook")
+	}
+}
+
+// validate will ensure the webhook has a usable URL, and only subscribes to
+// events which exist
+func (h *WebhookConfig) validate() error {
+	u, err := url.Parse(h.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook url must be an http or https URL: %s", h.URL)
+	}
+	for _, event := range h.Events {
+		known := false
+		for _, e := range webhookEvents {
+			if e == event {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("webhook %s subscribes to an unknown event: %s", h.URL, event)
+		}
+	}
+	return nil
+}
+
+// wants returns true if the webhook is subscribed to the event. An empty
+// event list means every event.
+func (h *WebhookConfig) wants(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DefaultBackend is used when a database is opened by a plain path
+const DefaultBackend = "leveldb"
+
+// openFunc will open the store for a backend at the given path
+type openFunc func(path string, readOnly bool) (store, error)
+
+// backends maps every URI scheme to the backend implementing it
+var backends = make(map[string]openFunc)
+
+// registerBackend will make the backend available to Open
+func registerBackend(scheme string, f openFunc) {
+	backends[scheme] = f
+}
+
+// Backends will return the name of every available backend
+func Backends() []string {
+	var ret []string
+	for scheme := range backends {
+		ret = append(ret, scheme)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ParseURI will split a database URI such as bolt:///var/lib/ferryd/db
+// into the backend and path. A plain path uses the DefaultBackend.
+func ParseURI(uri string) (backend, path string, err error) {
+	backend, path = DefaultBackend, uri
+	if i := strings.Index(uri, "://"); i >= 0 {
+		backend, path = uri[:i], uri[i+3:]
+	}
+	if _, ok := backends[backend]; !ok {
+		return "", "", fmt.Errorf("unknown database backend: %s", backend)
+	}
+	return backend, path, nil
+}
+
+// openURI will open the database in the given mode
+func openURI(uri string, readOnly bool) (Database, error) {
+	backend, path, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	s, err := backends[backend](path, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return newRootHandle(s, readOnly), nil
+}
+
+// Copy will replace the entire contents of dst with those of src, which
+// may be using a different backend
+func Copy(dst, src Database) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(src.Backup(w))
+	}()
+	err := dst.Restore(r)
+	r.Close()
+	return err
+}
@@ -0,0 +1,148 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// backupMagic begins every backup stream, and is bumped whenever the format
+// changes incompatibly
+var backupMagic = []byte("libdb-backup-1\n")
+
+// maxBackupField is the largest key or value we'll accept in a backup, to
+// avoid allocating wildly for a corrupt stream
+const maxBackupField = 1 << 30
+
+// ErrTruncatedBackup is returned when a backup stream ends early, which is
+// typically the result of the backup being interrupted
+var ErrTruncatedBackup = errors.New("backup is truncated")
+
+// A backup is the magic header followed by each key value pair, where the
+// key and value are each prefixed by their length as a uvarint. Keys are
+// never empty, so an empty key marks the end of the stream.
+
+// writeBackupField will write a single length-prefixed field
+func writeBackupField(w io.Writer, field []byte) error {
+	prefix := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(prefix, uint64(len(field)))
+	if _, err := w.Write(prefix[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(field)
+	return err
+}
+
+// readBackupField will read a single length-prefixed field
+func readBackupField(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxBackupField {
+		return nil, fmt.Errorf("backup field too large: %d bytes", length)
+	}
+	field := make([]byte, length)
+	if _, err = io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// readBackup will pass every key value pair in the backup to the function,
+// returning an error if the stream is malformed or incomplete
+func readBackup(input io.Reader, f DbForeachFunc) error {
+	r := bufio.NewReader(input)
+	magic := make([]byte, len(backupMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, backupMagic) {
+		return errors.New("not a libdb backup")
+	}
+	for {
+		key, err := readBackupField(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedBackup
+		} else if err != nil {
+			return err
+		}
+		if len(key) == 0 {
+			return nil
+		}
+		value, err := readBackupField(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrTruncatedBackup
+		} else if err != nil {
+			return err
+		}
+		if err = f(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// Backup will stream every key in a snapshot of the database. As every
+// backend shares the same key layout, a backup may be restored into any
+// backend.
+func (h *dbHandle) Backup(output io.Writer) error {
+	w := bufio.NewWriter(output)
+	if _, err := w.Write(backupMagic); err != nil {
+		return err
+	}
+
+	err := h.db.snapshot(func(key, value []byte) error {
+		if err := writeBackupField(w, key); err != nil {
+			return err
+		}
+		return writeBackupField(w, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Terminate the stream so that truncation can be detected
+	if err = writeBackupField(w, nil); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// Restore will atomically replace the database contents with the backup.
+// Nothing is written unless the backup is read in full.
+func (h *dbHandle) Restore(input io.Reader) error {
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	return h.update(func(tx *dbHandle) error {
+		// Drop everything we have now, then lay down the backup
+		err := tx.db.snapshot(func(key, value []byte) error {
+			tx.batch.Delete(key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return readBackup(input, func(key, value []byte) error {
+			tx.batch.Put(key, value)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,263 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+func init() {
+	registerBackend("bolt", openBolt)
+}
+
+// boltBucket holds every key, as libdb implements buckets within the keys
+var boltBucket = []byte("libdb")
+
+// boltChunkSize is how many keys we'll read per transaction when iterating,
+// so that the callbacks can run outside of any transaction. Bolt will
+// deadlock if a goroutine holding a read transaction begins a write.
+const boltChunkSize = 512
+
+// boltLockTimeout is how long we'll wait on another process holding the
+// database before giving up
+const boltLockTimeout = time.Second
+
+// boltStore keeps everything within a single bolt file, trading write
+// throughput for a simpler on-disk format that is always consistent
+type boltStore struct {
+	db       *bolt.DB
+	copyPath string // Set when we're reading from a copy of the database
+}
+
+// openBolt will open the bolt database file at path
+func openBolt(path string, readOnly bool) (store, error) {
+	if readOnly {
+		return openBoltReadOnly(path)
+	}
+	db, err := bolt.Open(path, 00644, &bolt.Options{Timeout: boltLockTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+// openBoltReadOnly will open the database without write access. When the
+// database is locked by a writer we'll open a private copy instead.
+func openBoltReadOnly(path string) (store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	options := &bolt.Options{
+		ReadOnly: true,
+		Timeout:  boltLockTimeout,
+	}
+	db, err := bolt.Open(path, 00644, options)
+	if err == nil {
+		return &boltStore{db: db}, nil
+	} else if err != bolt.ErrTimeout {
+		return nil, err
+	}
+
+	// Bolt only ever commits by rewriting a meta page, so any torn write
+	// in our copy will fall back to the previous transaction
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		var copyFd *os.File
+		if copyFd, err = ioutil.TempFile("", "libdb-readonly"); err != nil {
+			return nil, err
+		}
+		copyFd.Close()
+		copyPath := copyFd.Name()
+		if err = copyFile(path, copyPath); err == nil {
+			if db, err = bolt.Open(copyPath, 00644, options); err == nil {
+				return &boltStore{db: db, copyPath: copyPath}, nil
+			}
+		}
+		os.Remove(copyPath)
+	}
+	return nil, err
+}
+
+// seek will find the key within the bucket, returning nil if it is missing
+func (s *boltStore) seek(tx *bolt.Tx, key []byte) []byte {
+	b := tx.Bucket(boltBucket)
+	if b == nil {
+		return nil
+	}
+	k, v := b.Cursor().Seek(key)
+	if !bytes.Equal(k, key) {
+		return nil
+	}
+	// Empty values must still be found
+	if v == nil {
+		v = []byte{}
+	}
+	return v
+}
+
+func (s *boltStore) get(key []byte) ([]byte, error) {
+	var ret []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := s.seek(tx, key); v != nil {
+			ret = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err == nil && ret == nil {
+		return nil, ErrNotFound
+	}
+	return ret, err
+}
+
+func (s *boltStore) getMany(keys [][]byte) ([][]byte, error) {
+	ret := make([][]byte, len(keys))
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for i, key := range keys {
+			if v := s.seek(tx, key); v != nil {
+				ret[i] = append([]byte{}, v...)
+			}
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (s *boltStore) has(key []byte) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		found = s.seek(tx, key) != nil
+		return nil
+	})
+	return found, err
+}
+
+func (s *boltStore) write(b *batch) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for _, op := range b.ops {
+			var err error
+			if op.delete {
+				err = bucket.Delete(op.key)
+			} else {
+				err = bucket.Put(op.key, op.value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// first will position the cursor at the start of the range
+func first(c *bolt.Cursor, r *keyRange) ([]byte, []byte) {
+	if r.start == nil {
+		return c.First()
+	}
+	return c.Seek(r.start)
+}
+
+func (s *boltStore) iterate(r *keyRange, f DbForeachFunc) error {
+	chunk := &keyRange{start: r.start, limit: r.limit}
+	for {
+		var keys, values [][]byte
+		more := false
+		err := s.db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(boltBucket)
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for k, v := first(c, chunk); k != nil && chunk.contains(k); k, v = c.Next() {
+				if len(keys) == boltChunkSize {
+					more = true
+					break
+				}
+				keys = append(keys, append([]byte{}, k...))
+				values = append(values, append([]byte{}, v...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i := range keys {
+			if err := f(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+		if !more {
+			return nil
+		}
+		// Resume from the key immediately after the last one
+		lastKey := keys[len(keys)-1]
+		chunk.start = append(append(make([]byte, 0, len(lastKey)+1), lastKey...), 0)
+	}
+}
+
+func (s *boltStore) last(r *keyRange) ([]byte, error) {
+	var ret []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		var k []byte
+		if r.limit == nil {
+			k, _ = c.Last()
+		} else if k, _ = c.Seek(r.limit); k == nil {
+			k, _ = c.Last()
+		} else {
+			k, _ = c.Prev()
+		}
+		if k != nil && r.contains(k) {
+			ret = append([]byte{}, k...)
+		}
+		return nil
+	})
+	return ret, err
+}
+
+func (s *boltStore) snapshot(f DbForeachFunc) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(f)
+	})
+}
+
+func (s *boltStore) close() error {
+	err := s.db.Close()
+	if s.copyPath != "" {
+		os.Remove(s.copyPath)
+	}
+	return err
+}
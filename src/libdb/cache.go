@@ -0,0 +1,197 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// A CopyFunc copies the object src into dst, both being pointers to the type
+// stored in a cached bucket. The copy must not share anything that a reader
+// of the object may go on to modify.
+type CopyFunc func(dst, src interface{})
+
+// objectCache holds the most recently read objects of a bucket, decoded.
+// It only ever holds what has been committed to the store, and is
+// invalidated as each transaction commits.
+type objectCache struct {
+	size    int
+	copy    CopyFunc
+	objects map[string]*list.Element
+	lru     *list.List // Most recently used at the front
+	gen     uint64     // Bumped by every invalidation
+	mut     *sync.Mutex
+}
+
+type cachedObject struct {
+	key    string
+	object interface{}
+}
+
+func newObjectCache(size int, copy CopyFunc) *objectCache {
+	return &objectCache{
+		size:    size,
+		copy:    copy,
+		objects: make(map[string]*list.Element),
+		lru:     list.New(),
+		mut:     &sync.Mutex{},
+	}
+}
+
+// lookup returns the cached object, which must not be modified
+func (c *objectCache) lookup(key []byte) (interface{}, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	elem, ok := c.objects[string(key)]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cachedObject).object, true
+}
+
+// get will copy the cached object into o, returning false if we don't have it
+func (c *objectCache) get(key []byte, o interface{}) bool {
+	object, ok := c.lookup(key)
+	if ok {
+		c.copy(o, object)
+	}
+	return ok
+}
+
+// generation must be obtained before reading an object from the store, so
+// that add can tell if it has been written since
+func (c *objectCache) generation() uint64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.gen
+}
+
+// add will cache a copy of the object read from the store, unless anything
+// was committed after gen was obtained, as it may no longer be current
+func (c *objectCache) add(key []byte, gen uint64, o interface{}) {
+	object := reflect.New(reflect.TypeOf(o).Elem()).Interface()
+	c.copy(object, o)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.objects[string(key)]; ok {
+		elem.Value.(*cachedObject).object = object
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.objects[string(key)] = c.lru.PushFront(&cachedObject{key: string(key), object: object})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.objects, oldest.Value.(*cachedObject).key)
+	}
+}
+
+// invalidate will drop every object written by the batch
+func (c *objectCache) invalidate(b *batch) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gen++
+	for _, op := range b.ops {
+		if elem, ok := c.objects[string(op.key)]; ok {
+			c.lru.Remove(elem)
+			delete(c.objects, string(op.key))
+		}
+	}
+}
+
+// purge will drop every object
+func (c *objectCache) purge() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.gen++
+	c.objects = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// cacheRegistry holds the object caches for every bucket, and is shared
+// between all handles to the same database
+type cacheRegistry struct {
+	caches map[string]*objectCache // bucket prefix -> cache
+	mut    *sync.RWMutex
+}
+
+func newCacheRegistry() *cacheRegistry {
+	return &cacheRegistry{
+		caches: make(map[string]*objectCache),
+		mut:    &sync.RWMutex{},
+	}
+}
+
+// get returns the cache for the bucket, if it has one
+func (r *cacheRegistry) get(bucket []byte) *objectCache {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.caches[string(bucket)]
+}
+
+// invalidate will drop everything the batch wrote from every cache
+func (r *cacheRegistry) invalidate(b *batch) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	for _, c := range r.caches {
+		c.invalidate(b)
+	}
+}
+
+// purge will empty every cache
+func (r *cacheRegistry) purge() {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	for _, c := range r.caches {
+		c.purge()
+	}
+}
+
+// Cache will keep up to size of the most recently read objects of this
+// bucket in memory, decoded, so that reading them again skips the store.
+// The bucket must only hold objects of one type, as the cached objects are
+// handed out by copying them into the reader's object.
+func (h *dbHandle) Cache(size int, copy CopyFunc) error {
+	if size < 1 || copy == nil {
+		return errors.New("cache requires a size and a copy function")
+	}
+	h.caches.mut.Lock()
+	defer h.caches.mut.Unlock()
+	h.caches.caches[string(h.prefix)] = newObjectCache(size, copy)
+	return nil
+}
+
+// cacheFor returns the cache to use when reading key. A transaction must
+// read its own writes from the store, so it gets none for keys it wrote.
+func (h *dbHandle) cacheFor(key []byte) *objectCache {
+	c := h.caches.get(h.prefix)
+	if c == nil || h.batch == nil {
+		return c
+	}
+	if _, written := h.batch.lookup(key); written {
+		return nil
+	}
+	return c
+}
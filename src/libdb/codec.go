@@ -0,0 +1,234 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DefaultCodec is used to encode values unless the database is told
+// otherwise. msgpack may be chosen with SetCodec, but only becomes the
+// default once a vendored msgpack library replaces our own encoder.
+const DefaultCodec = "gob"
+
+// codecMarker begins every tagged value, followed by the codec's tag. A gob
+// stream never begins with a zero byte, so values written before tagging
+// existed are still recognised as gob.
+const codecMarker = 0
+
+// maxPendingMigrations bounds how many rewritten values we'll hold on to
+// until the next transaction commits them
+const maxPendingMigrations = 1024
+
+// A Codec converts objects to and from the bytes stored in the database
+type Codec interface {
+	// Encode will serialise the object
+	Encode(o interface{}) ([]byte, error)
+
+	// Decode will deserialise the input into the pointer o
+	Decode(input []byte, o interface{}) error
+}
+
+// codecEntry is a registered Codec along with the tag marking its values
+type codecEntry struct {
+	name  string
+	tag   byte
+	codec Codec
+}
+
+var (
+	codecsByName = make(map[string]*codecEntry)
+	codecsByTag  = make(map[byte]*codecEntry)
+	codecMut     sync.RWMutex
+)
+
+func init() {
+	RegisterCodec("gob", 1, gobCodec{})
+	RegisterCodec("msgpack", 2, msgpackCodec{})
+}
+
+// RegisterCodec will make the codec available to every database. The tag is
+// stored with each value the codec writes, so must never be reused for a
+// different codec.
+func RegisterCodec(name string, tag byte, c Codec) error {
+	codecMut.Lock()
+	defer codecMut.Unlock()
+	if _, ok := codecsByName[name]; ok {
+		return fmt.Errorf("codec %s is already registered", name)
+	}
+	if existing, ok := codecsByTag[tag]; ok {
+		return fmt.Errorf("codec tag %d is already used by %s", tag, existing.name)
+	}
+	entry := &codecEntry{name: name, tag: tag, codec: c}
+	codecsByName[name] = entry
+	codecsByTag[tag] = entry
+	return nil
+}
+
+// Codecs will return the name of every available codec
+func Codecs() []string {
+	codecMut.RLock()
+	defer codecMut.RUnlock()
+	var ret []string
+	for name := range codecsByName {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// CodecOf will return the name of the codec that wrote the stored value
+func CodecOf(value []byte) (string, error) {
+	entry, _, err := codecFor(value)
+	if err != nil {
+		return "", err
+	}
+	return entry.name, nil
+}
+
+// codecFor will find the codec for a stored value, and return its payload
+func codecFor(value []byte) (*codecEntry, []byte, error) {
+	codecMut.RLock()
+	defer codecMut.RUnlock()
+	if len(value) < 2 || value[0] != codecMarker {
+		return codecsByName["gob"], value, nil
+	}
+	entry, ok := codecsByTag[value[1]]
+	if !ok {
+		return nil, nil, fmt.Errorf("value uses unknown codec tag %d", value[1])
+	}
+	return entry, value[2:], nil
+}
+
+// gobCodec was the only encoding before codecs were pluggable
+type gobCodec struct{}
+
+func (gobCodec) Encode(o interface{}) ([]byte, error) {
+	return NewGobEncoderLight().EncodeType(o)
+}
+
+func (gobCodec) Decode(input []byte, o interface{}) error {
+	return NewGobDecoderLight().DecodeType(input, o)
+}
+
+// migration is a value that was read with an outdated codec, and the same
+// object encoded with the current one
+type migration struct {
+	old     []byte
+	updated []byte
+}
+
+// transcoder holds the codec in use and the values waiting to be migrated,
+// and is shared between all handles to the same database
+type transcoder struct {
+	current *codecEntry
+	pending map[string]migration
+	mut     *sync.Mutex
+}
+
+func newTranscoder() *transcoder {
+	codecMut.RLock()
+	defer codecMut.RUnlock()
+	return &transcoder{
+		current: codecsByName[DefaultCodec],
+		pending: make(map[string]migration),
+		mut:     &sync.Mutex{},
+	}
+}
+
+// setCodec will switch the codec used for every value written from now on
+func (t *transcoder) setCodec(name string) error {
+	codecMut.RLock()
+	entry, ok := codecsByName[name]
+	codecMut.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown codec: %s", name)
+	}
+	t.mut.Lock()
+	t.current = entry
+	t.mut.Unlock()
+	return nil
+}
+
+// codec will return the codec in use
+func (t *transcoder) codec() *codecEntry {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	return t.current
+}
+
+// encode will tag the object's encoding with the codec used
+func (t *transcoder) encode(o interface{}) ([]byte, error) {
+	entry := t.codec()
+	payload, err := entry.codec.Encode(o)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]byte, 2, len(payload)+2)
+	ret[0], ret[1] = codecMarker, entry.tag
+	return append(ret, payload...), nil
+}
+
+// decode will decode the value with whichever codec wrote it, and return
+// that codec
+func (t *transcoder) decode(value []byte, o interface{}) (*codecEntry, error) {
+	entry, payload, err := codecFor(value)
+	if err != nil {
+		return nil, err
+	}
+	return entry, entry.codec.Decode(payload, o)
+}
+
+// queue will remember that the stored value should be replaced with updated
+func (t *transcoder) queue(key, old, updated []byte) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+	if len(t.pending) < maxPendingMigrations {
+		t.pending[string(key)] = migration{old: old, updated: updated}
+	}
+}
+
+// flush will add the queued migrations to the transaction, skipping any
+// value that has changed since it was read. This must only be called with
+// the transaction lock held.
+func (t *transcoder) flush(tx *dbHandle, s store) error {
+	t.mut.Lock()
+	pending := t.pending
+	if len(pending) > 0 {
+		t.pending = make(map[string]migration)
+	}
+	t.mut.Unlock()
+
+	for key, m := range pending {
+		if _, written := tx.batch.lookup([]byte(key)); written {
+			continue
+		}
+		current, err := s.get([]byte(key))
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if bytes.Equal(current, m.old) {
+			tx.batch.Put([]byte(key), m.updated)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	rootBucketPrefix = []byte("|rootBucket|-")
+	bucketPrefix     = []byte("|bucket|")
+)
+
+// dbHandle implements buckets, encoding and indexes over a backend's store
+type dbHandle struct {
+	prefixBytes *keyRange
+	prefix      []byte
+	keyPrefix   []byte
+	db          store
+	batch       *batch         // Usually nil but set for write transactions
+	seqLock     *sync.Mutex    // Must ensure we have atomic view of DB for sequence
+	txLock      *sync.Mutex    // Serialises write transactions
+	indexes     *indexRegistry // Secondary indexes for every bucket
+	caches      *cacheRegistry // Decoded objects for cached buckets
+	transcoder  *transcoder    // Encodes values for every bucket
+	readOnly    bool           // Refuse all writes
+}
+
+// rootHandle is the concrete type returned when opening a database
+type rootHandle struct {
+	dbHandle
+	closable
+}
+
+// newRootHandle will wrap the store up as the root of a database
+func newRootHandle(db store, readOnly bool) *rootHandle {
+	handle := &rootHandle{}
+	handle.db = db
+	handle.readOnly = readOnly
+	handle.prefix = []byte("|rootBucket|")
+	handle.keyPrefix = []byte("|rootBucket|-")
+	handle.prefixBytes = prefixRange(handle.keyPrefix)
+	handle.seqLock = &sync.Mutex{}
+	handle.txLock = &sync.Mutex{}
+	handle.indexes = newIndexRegistry()
+	handle.caches = newCacheRegistry()
+	handle.transcoder = newTranscoder()
+	handle.initClosable()
+	return handle
+}
+
+// Close the underlying store, after writing any pending migrations
+func (r *rootHandle) Close() {
+	if !r.close() {
+		return
+	}
+	if !r.readOnly {
+		r.update(func(tx *dbHandle) error {
+			return nil
+		})
+	}
+	r.db.close()
+}
+
+func (h *dbHandle) getRealKey(id []byte) []byte {
+	return []byte(fmt.Sprintf("%s-%s", string(h.prefix), string(id)))
+}
+
+func (h *dbHandle) GetObject(id []byte, outObject interface{}) error {
+	key := h.getRealKey(id)
+	cache := h.cacheFor(key)
+	var gen uint64
+	if cache != nil {
+		if cache.get(key, outObject) {
+			return nil
+		}
+		gen = cache.generation()
+	}
+
+	val, err := h.db.get(key)
+	if err != nil {
+		return err
+	}
+	current, err := h.decodeStored(key, val, outObject)
+	if err != nil {
+		return err
+	}
+	if cache != nil && current {
+		cache.add(key, gen, outObject)
+	}
+	return nil
+}
+
+// decodeStored will decode the value stored under key, returning whether it
+// was written by the current codec. Values written by an older codec are
+// migrated to the current one, immediately if we're within a transaction,
+// otherwise when the next one commits.
+func (h *dbHandle) decodeStored(key, val []byte, o interface{}) (bool, error) {
+	codec, err := h.transcoder.decode(val, o)
+	if err != nil {
+		return false, err
+	}
+	if codec == h.transcoder.codec() {
+		return true, nil
+	}
+	if h.readOnly {
+		return false, nil
+	}
+	updated, err := h.transcoder.encode(o)
+	if err != nil {
+		// Still readable, so try again next time
+		return false, nil
+	}
+	if h.batch != nil {
+		h.batch.Put(key, updated)
+	} else {
+		h.transcoder.queue(key, val, updated)
+	}
+	return false, nil
+}
+
+// GetObjects will read all of the objects from a single view of the store,
+// other than those we have cached
+func (h *dbHandle) GetObjects(ids [][]byte, newObject func(i int) interface{}) error {
+	var keys [][]byte
+	var index []int
+	caches := make([]*objectCache, len(ids))
+	gens := make([]uint64, len(ids))
+	for i, id := range ids {
+		key := h.getRealKey(id)
+		if cache := h.cacheFor(key); cache != nil {
+			gens[i] = cache.generation()
+			if object, ok := cache.lookup(key); ok {
+				cache.copy(newObject(i), object)
+				continue
+			}
+			caches[i] = cache
+		}
+		keys = append(keys, key)
+		index = append(index, i)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	values, err := h.db.getMany(keys)
+	if err != nil {
+		return err
+	}
+	for j, val := range values {
+		if val == nil {
+			continue
+		}
+		i := index[j]
+		o := newObject(i)
+		current, err := h.decodeStored(keys[j], val, o)
+		if err != nil {
+			return err
+		}
+		if caches[i] != nil && current {
+			caches[i].add(keys[j], gens[i], o)
+		}
+	}
+	return nil
+}
+
+func (h *dbHandle) HasObject(id []byte) (bool, error) {
+	return h.db.has(h.getRealKey(id))
+}
+
+func (h *dbHandle) PutObject(id []byte, inObject interface{}) error {
+	if bytes.HasPrefix(id, bucketPrefix) || bytes.HasPrefix(id, rootBucketPrefix) {
+		return fmt.Errorf("key uses reserved bucket notation: %v", string(id))
+	}
+
+	if h.readOnly {
+		return ErrReadOnly
+	}
+	if h.batch == nil {
+		return h.update(func(tx *dbHandle) error {
+			return tx.PutObject(id, inObject)
+		})
+	}
+	by, err := h.transcoder.encode(inObject)
+	if err != nil {
+		return err
+	}
+	h.batch.Put(h.getRealKey(id), by)
+	if indexes := h.indexes.get(h.prefix); indexes != nil {
+		return h.updateIndexes(h.batch, indexes, id, by)
+	}
+	return nil
+}
+
+// PutObjects will write all of the objects in one transaction, in key order
+// so that the batch is deterministic
+func (h *dbHandle) PutObjects(objects map[string]interface{}) error {
+	ids := make([]string, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	return h.update(func(tx *dbHandle) error {
+		for _, id := range ids {
+			if err := tx.PutObject([]byte(id), objects[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (h *dbHandle) DeleteObject(id []byte) error {
+	if bytes.HasPrefix(id, bucketPrefix) || bytes.HasPrefix(id, rootBucketPrefix) {
+		return fmt.Errorf("key uses reserved bucket notation: %v", string(id))
+	}
+	if h.readOnly {
+		return ErrReadOnly
+	}
+
+	if h.batch == nil {
+		return h.update(func(tx *dbHandle) error {
+			return tx.DeleteObject(id)
+		})
+	}
+	h.batch.Delete(h.getRealKey(id))
+	if indexes := h.indexes.get(h.prefix); indexes != nil {
+		return h.updateIndexes(h.batch, indexes, id, nil)
+	}
+	return nil
+}
+
+func (h *dbHandle) Decode(input []byte, o interface{}) error {
+	_, err := h.transcoder.decode(input, o)
+	return err
+}
+
+// SetCodec will choose the codec for every value written from now on.
+// Cached objects are dropped so that reading them migrates them.
+func (h *dbHandle) SetCodec(name string) error {
+	if err := h.transcoder.setCodec(name); err != nil {
+		return err
+	}
+	h.caches.purge()
+	return nil
+}
+
+func (h *dbHandle) ForEach(f DbForeachFunc) error {
+	return h.iterate(h.prefixBytes, f)
+}
+
+// ForEachPrefix will only visit keys beginning with prefix
+func (h *dbHandle) ForEachPrefix(prefix []byte, f DbForeachFunc) error {
+	return h.iterate(prefixRange(h.getRealKey(prefix)), f)
+}
+
+// ForEachRange will visit the keys within [start, end)
+func (h *dbHandle) ForEachRange(start, end []byte, f DbForeachFunc) error {
+	r := &keyRange{
+		start: h.prefixBytes.start,
+		limit: h.prefixBytes.limit,
+	}
+	if start != nil {
+		r.start = h.getRealKey(start)
+	}
+	if end != nil {
+		r.limit = h.getRealKey(end)
+	}
+	return h.iterate(r, f)
+}
+
+// iterate will pass every key value pair in the range to the function
+func (h *dbHandle) iterate(r *keyRange, f DbForeachFunc) error {
+	return h.db.iterate(r, func(key, value []byte) error {
+		// Pass a modified key that preserves bucket structure but is usable
+		// in debugging, etc.
+		return f(bytes.TrimPrefix(key, h.keyPrefix), value)
+	})
+}
+
+// Close is a no-op for our handle
+func (h *dbHandle) Close() {}
+
+func (h *dbHandle) Bucket(id []byte) Database {
+	var newID []byte
+	if h.prefix != nil {
+		newID = []byte(fmt.Sprintf("%s-%s-%s", string(bucketPrefix), string(h.prefix), id))
+	} else {
+		newID = []byte(fmt.Sprintf("%s-%s", string(bucketPrefix), id))
+	}
+	keyPrefix := []byte(fmt.Sprintf("%s-", string(newID)))
+	ret := &dbHandle{
+		db:          h.db,
+		prefix:      newID,
+		keyPrefix:   keyPrefix,
+		prefixBytes: prefixRange(keyPrefix),
+		batch:       h.batch,
+		seqLock:     h.seqLock,
+		txLock:      h.txLock,
+		indexes:     h.indexes,
+		caches:      h.caches,
+		transcoder:  h.transcoder,
+		readOnly:    h.readOnly,
+	}
+	return ret
+}
+
+func (h *dbHandle) View(f ReadOnlyFunc) error {
+	return f(h)
+}
+
+// Update is a bit cheeky in that we create a clone of ourselves
+// to utilise a batch object, and then execute the passed function
+// within the context of that batch. Reads made by the function see
+// its own writes, and no other Update may run until it completes,
+// so read-modify-write cycles are safe. Nested calls join the
+// outer transaction.
+//
+// If the function doesn't return an error, we'll allow the database
+// to try and write. Otherwise, we'll discard the entire batch and
+// return the functions error.
+func (h *dbHandle) Update(f WriterFunc) error {
+	return h.update(func(tx *dbHandle) error {
+		return f(tx)
+	})
+}
+
+// NextSequence will return the next natural key to be used for inserts,
+// when the application only needs a unique record, not a specific key.
+func (h *dbHandle) NextSequence() []byte {
+	h.seqLock.Lock()
+	defer h.seqLock.Unlock()
+
+	var retKey uint64
+	key, err := h.db.last(h.prefixBytes)
+	if err != nil || key == nil {
+		retKey = 0
+	} else {
+		newKey := bytes.TrimPrefix(key, h.keyPrefix)
+
+		if len(newKey) != 8 {
+			retKey = 0
+		} else {
+			// Increment last key by 1
+			retKey = binary.BigEndian.Uint64(newKey) + 1
+		}
+	}
+	byt := make([]byte, 8)
+	binary.BigEndian.PutUint64(byt, retKey)
+	return byt
+}
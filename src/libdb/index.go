@@ -0,0 +1,185 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+var (
+	indexPrefix      = []byte("|index|")      // Index entries, key -> id
+	indexRevPrefix   = []byte("|indexRev|")   // Index keys stored for each id
+	indexBuiltPrefix = []byte("|indexBuilt|") // Marks an index as populated
+)
+
+// indexSeparator splits the index key from the object ID in an index entry,
+// so that a lookup can never match a key which merely begins with ours
+const indexSeparator = 0
+
+// indexRegistry holds the secondary indexes for every bucket, and is shared
+// between all handles to the same database
+type indexRegistry struct {
+	indexes map[string]map[string]IndexFunc // bucket prefix -> name -> func
+	unbuilt map[string]bool                 // Indexes we couldn't populate
+	mut     *sync.RWMutex
+}
+
+func newIndexRegistry() *indexRegistry {
+	return &indexRegistry{
+		indexes: make(map[string]map[string]IndexFunc),
+		unbuilt: make(map[string]bool),
+		mut:     &sync.RWMutex{},
+	}
+}
+
+// get returns the indexes registered for the bucket
+func (i *indexRegistry) get(bucket []byte) map[string]IndexFunc {
+	i.mut.RLock()
+	defer i.mut.RUnlock()
+	return i.indexes[string(bucket)]
+}
+
+// register will add the index for the bucket
+func (i *indexRegistry) register(bucket []byte, name string, f IndexFunc) {
+	i.mut.Lock()
+	defer i.mut.Unlock()
+	idx, ok := i.indexes[string(bucket)]
+	if !ok {
+		idx = make(map[string]IndexFunc)
+		i.indexes[string(bucket)] = idx
+	}
+	idx[name] = f
+}
+
+// indexKey builds a key within one of the index namespaces
+func (h *dbHandle) indexKey(namespace []byte, name string, suffix []byte) []byte {
+	key := []byte(fmt.Sprintf("%s-%s-%s-", namespace, h.prefix, name))
+	return append(key, suffix...)
+}
+
+// indexEntryKey returns the key recording that id has the given index key
+func (h *dbHandle) indexEntryKey(name string, key, id []byte) []byte {
+	ret := h.indexKey(indexPrefix, name, key)
+	ret = append(ret, indexSeparator)
+	return append(ret, id...)
+}
+
+// decoderFor returns a decode function for the encoded object
+func (h *dbHandle) decoderFor(encoded []byte) func(o interface{}) error {
+	return func(o interface{}) error {
+		return h.Decode(encoded, o)
+	}
+}
+
+// updateIndexes will replace the index entries for id in the batch. If the
+// object is being deleted, encoded is nil. This must be called within a
+// transaction so that the old entries can't change underneath us.
+func (h *dbHandle) updateIndexes(b *batch, indexes map[string]IndexFunc, id, encoded []byte) error {
+	for name, f := range indexes {
+		revKey := h.indexKey(indexRevPrefix, name, id)
+
+		// Drop whatever we indexed the old object as
+		oldEncoded, err := h.db.get(revKey)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if err == nil {
+			var oldKeys [][]byte
+			if err := h.Decode(oldEncoded, &oldKeys); err != nil {
+				return err
+			}
+			for _, key := range oldKeys {
+				b.Delete(h.indexEntryKey(name, key, id))
+			}
+		}
+
+		if encoded == nil {
+			b.Delete(revKey)
+			continue
+		}
+
+		keys, err := f(id, h.decoderFor(encoded))
+		if err != nil {
+			return fmt.Errorf("failed to index %s in %s: %v", string(id), name, err)
+		}
+		for _, key := range keys {
+			b.Put(h.indexEntryKey(name, key, id), nil)
+		}
+		revEncoded, err := h.transcoder.encode(keys)
+		if err != nil {
+			return err
+		}
+		b.Put(revKey, revEncoded)
+	}
+	return nil
+}
+
+// Index will register the index for this bucket, populating it from the
+// existing objects if it has never been built before.
+func (h *dbHandle) Index(name string, f IndexFunc) error {
+	h.indexes.register(h.prefix, name, f)
+
+	builtKey := h.indexKey(indexBuiltPrefix, name, nil)
+	if has, err := h.db.has(builtKey); err != nil || has {
+		return err
+	}
+
+	// Can't populate it, so lookups must fail rather than come up short
+	if h.readOnly {
+		h.indexes.mut.Lock()
+		h.indexes.unbuilt[string(builtKey)] = true
+		h.indexes.mut.Unlock()
+		return nil
+	}
+
+	return h.update(func(tx *dbHandle) error {
+		// Someone else may have built it while we waited
+		if has, err := tx.db.has(builtKey); err != nil || has {
+			return err
+		}
+		only := map[string]IndexFunc{name: f}
+		err := tx.db.iterate(prefixRange(tx.keyPrefix), func(key, value []byte) error {
+			id := bytes.TrimPrefix(key, tx.keyPrefix)
+			return tx.updateIndexes(tx.batch, only, id, value)
+		})
+		if err != nil {
+			return err
+		}
+		tx.batch.Put(builtKey, nil)
+		return nil
+	})
+}
+
+// LookupIndex will return the IDs of every object indexed with the key
+func (h *dbHandle) LookupIndex(name string, key []byte) ([][]byte, error) {
+	h.indexes.mut.RLock()
+	unbuilt := h.indexes.unbuilt[string(h.indexKey(indexBuiltPrefix, name, nil))]
+	h.indexes.mut.RUnlock()
+	if unbuilt {
+		return nil, fmt.Errorf("index %s has not been built yet", name)
+	}
+
+	prefix := h.indexEntryKey(name, key, nil)
+	var ret [][]byte
+	err := h.db.iterate(prefixRange(prefix), func(key, value []byte) error {
+		ret = append(ret, append([]byte{}, bytes.TrimPrefix(key, prefix)...))
+		return nil
+	})
+	return ret, err
+}
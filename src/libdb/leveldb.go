@@ -17,203 +17,170 @@
 package libdb
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
 	"github.com/syndtr/goleveldb/leveldb"
+	lerrors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/util"
-	"sync"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 )
 
-var (
-	rootBucketPrefix = []byte("|rootBucket|-")
-	bucketPrefix     = []byte("|bucket|")
-)
-
-// levelDbHandle wraps leveldb up in private API
-type levelDbHandle struct {
-	prefixBytes *util.Range
-	prefix      []byte
-	keyPrefix   []byte
-	db          *leveldb.DB
-	batch       *leveldb.Batch // Usually nil but set for write transactions
-	seqLock     *sync.Mutex    // Must ensure we have atomic view of DB for sequence
+func init() {
+	registerBackend("leveldb", openLevelDB)
+	registerRecovery("leveldb", recoverLevelDB, openLevelDBRecovered)
 }
 
-// levelDb is our concrete type
-type levelDb struct {
-	levelDbHandle
-	closable
+// levelDbStore keeps everything within a leveldb database
+type levelDbStore struct {
+	db      *leveldb.DB
+	copyDir string // Set when we're reading from a copy of the database
 }
 
-func newLevelDBHandle(storagePath string) (*levelDb, error) {
-	ldb, err := leveldb.OpenFile(storagePath, nil)
+// openLevelDB will open the leveldb database in the storage directory
+func openLevelDB(storagePath string, readOnly bool) (store, error) {
+	s := &levelDbStore{}
+	var err error
+	if readOnly {
+		s.db, s.copyDir, err = openLevelDBReadOnly(storagePath)
+	} else {
+		s.db, err = leveldb.OpenFile(storagePath, nil)
+	}
 	if err != nil {
+		if lerrors.IsCorrupted(err) {
+			return nil, &CorruptedError{Path: storagePath, Err: err}
+		}
 		return nil, err
 	}
-	handle := &levelDb{}
-	handle.db = ldb
-	handle.prefix = []byte("|rootBucket|")
-	handle.keyPrefix = []byte("|rootBucket|-")
-	handle.prefixBytes = util.BytesPrefix(handle.prefix)
-	handle.seqLock = &sync.Mutex{}
-	handle.initClosable()
-	return handle, nil
+	return s, nil
 }
 
-// Close the existing levelDbHandle
-func (l *levelDb) Close() {
-	if l.close() {
-		l.db.Close()
+// backupLevelDB will copy every file of the database into backup, whether
+// or not the database can be opened
+func backupLevelDB(storagePath, backup string) error {
+	files, err := ioutil.ReadDir(storagePath)
+	if err != nil {
+		return err
 	}
+	if err := os.MkdirAll(backup, 00755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.Name() == "LOCK" || !file.Mode().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(storagePath, file.Name()), filepath.Join(backup, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (l *levelDbHandle) getRealKey(id []byte) []byte {
-	return []byte(fmt.Sprintf("%s-%s", string(l.prefix), string(id)))
-}
-
-func (l *levelDbHandle) GetObject(id []byte, outObject interface{}) error {
-	val, err := l.db.Get(l.getRealKey(id), nil)
+// recoverLevelDB will copy every file of the database into backup, then
+// rebuild its manifest from whichever tables can still be read
+func recoverLevelDB(storagePath, backup string) error {
+	if err := backupLevelDB(storagePath, backup); err != nil {
+		return err
+	}
+	db, err := leveldb.RecoverFile(storagePath, nil)
 	if err != nil {
 		return err
 	}
-
-	return l.Decode(val, outObject)
+	return db.Close()
 }
 
-func (l *levelDbHandle) HasObject(id []byte) (bool, error) {
-	return l.db.Has(l.getRealKey(id), nil)
+// openLevelDBRecovered will rebuild a private copy of the database, which is
+// removed again on close
+func openLevelDBRecovered(storagePath string) (store, error) {
+	copyDir, err := ioutil.TempDir("", "libdb-recovered")
+	if err != nil {
+		return nil, err
+	}
+	if err = backupLevelDB(storagePath, copyDir); err == nil {
+		var db *leveldb.DB
+		if db, err = leveldb.RecoverFile(copyDir, nil); err == nil {
+			return &levelDbStore{db: db, copyDir: copyDir}, nil
+		}
+	}
+	os.RemoveAll(copyDir)
+	return nil, err
 }
 
-func (l *levelDbHandle) PutObject(id []byte, inObject interface{}) error {
-	if bytes.HasPrefix(id, bucketPrefix) || bytes.HasPrefix(id, rootBucketPrefix) {
-		return fmt.Errorf("key uses reserved bucket notation: %v", string(id))
+func (s *levelDbStore) get(key []byte) ([]byte, error) {
+	val, err := s.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
 	}
+	return val, err
+}
 
-	tr := NewGobEncoderLight()
-	by, err := tr.EncodeType(inObject)
+func (s *levelDbStore) getMany(keys [][]byte) ([][]byte, error) {
+	snap, err := s.db.GetSnapshot()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if l.batch != nil {
-		l.batch.Put(l.getRealKey(id), by)
-		return nil
+	defer snap.Release()
+
+	ret := make([][]byte, len(keys))
+	for i, key := range keys {
+		val, err := snap.Get(key, nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ret[i] = val
 	}
-	return l.db.Put(l.getRealKey(id), by, nil)
+	return ret, nil
 }
 
-func (l *levelDbHandle) DeleteObject(id []byte) error {
-	if bytes.HasPrefix(id, bucketPrefix) || bytes.HasPrefix(id, rootBucketPrefix) {
-		return fmt.Errorf("key uses reserved bucket notation: %v", string(id))
-	}
-
-	if l.batch != nil {
-		l.batch.Delete(l.getRealKey(id))
-		return nil
-	}
-	return l.db.Delete(l.getRealKey(id), nil)
+func (s *levelDbStore) has(key []byte) (bool, error) {
+	return s.db.Has(key, nil)
 }
 
-func (l *levelDbHandle) Decode(input []byte, o interface{}) error {
-	tr := NewGobDecoderLight()
-	if err := tr.DecodeType(input, o); err != nil {
-		return err
+func (s *levelDbStore) write(b *batch) error {
+	lb := &leveldb.Batch{}
+	for _, op := range b.ops {
+		if op.delete {
+			lb.Delete(op.key)
+		} else {
+			lb.Put(op.key, op.value)
+		}
 	}
-	return nil
+	return s.db.Write(lb, nil)
 }
 
-func (l *levelDbHandle) ForEach(f DbForeachFunc) error {
-	// No matching on prefixes..
-	iter := l.db.NewIterator(l.prefixBytes, nil)
+func (s *levelDbStore) iterate(r *keyRange, f DbForeachFunc) error {
+	iter := s.db.NewIterator(&util.Range{Start: r.start, Limit: r.limit}, nil)
 	defer iter.Release()
 
 	for iter.Next() {
-		key := iter.Key()
-		value := iter.Value()
-
-		// Pass a modified key that preserves bucket structure but is usable
-		// in debugging, etc.
-		newKey := bytes.TrimPrefix(key, l.keyPrefix)
-
-		if err := f(newKey, value); err != nil {
+		if err := f(iter.Key(), iter.Value()); err != nil {
 			return err
 		}
 	}
 	return iter.Error()
 }
 
-// Close is a no-op for our handle
-func (l *levelDbHandle) Close() {}
+func (s *levelDbStore) last(r *keyRange) ([]byte, error) {
+	iter := s.db.NewIterator(&util.Range{Start: r.start, Limit: r.limit}, nil)
+	defer iter.Release()
 
-func (l *levelDbHandle) Bucket(id []byte) Database {
-	var newID []byte
-	if l.prefix != nil {
-		newID = []byte(fmt.Sprintf("%s-%s-%s", string(bucketPrefix), string(l.prefix), id))
-	} else {
-		newID = []byte(fmt.Sprintf("%s-%s", string(bucketPrefix), id))
-	}
-	ret := &levelDbHandle{
-		db:          l.db,
-		prefix:      newID,
-		keyPrefix:   []byte(fmt.Sprintf("%s-", string(newID))),
-		prefixBytes: util.BytesPrefix(newID),
-		batch:       l.batch,
-		seqLock:     l.seqLock,
+	if !iter.Last() {
+		return nil, iter.Error()
 	}
-	return ret
+	return append([]byte{}, iter.Key()...), nil
 }
 
-func (l *levelDbHandle) View(f ReadOnlyFunc) error {
-	return f(l)
+// snapshot is simply a full iteration, as leveldb iterators already see a
+// consistent view of the database
+func (s *levelDbStore) snapshot(f DbForeachFunc) error {
+	return s.iterate(&keyRange{}, f)
 }
 
-// Update is a bit cheeky in that we create a clone of ourselves
-// to utilise a batch object, and then execute the passed function
-// within the context of that batch.
-//
-// If the function doesn't return an error, we'll allow the database
-// to try and write. Otherwise, we'll discard the entire batch and
-// return the functions error.
-func (l *levelDbHandle) Update(f WriterFunc) error {
-	clone := levelDbHandle{
-		db:          l.db,
-		prefix:      l.prefix,
-		keyPrefix:   l.keyPrefix,
-		prefixBytes: l.prefixBytes,
-		batch:       &leveldb.Batch{},
-		seqLock:     l.seqLock,
-	}
-	err := f(&clone)
-	if err != nil {
-		clone.batch.Reset()
-		return err
-	}
-	return clone.db.Write(clone.batch, nil)
-}
-
-// NextSequence will return the next natural key to be used for inserts,
-// when the application only needs a unique record, not a specific key.
-func (l *levelDbHandle) NextSequence() []byte {
-	l.seqLock.Lock()
-	defer l.seqLock.Unlock()
-
-	iter := l.db.NewIterator(l.prefixBytes, nil)
-	defer iter.Release()
-	var retKey uint64
-	if !iter.Last() {
-		retKey = 0
-	} else {
-		key := iter.Key()
-		newKey := bytes.TrimPrefix(key, l.keyPrefix)
-
-		if len(newKey) != 8 {
-			retKey = 0
-		} else {
-			// Increment last key by 1
-			retKey = binary.BigEndian.Uint64(newKey) + 1
-		}
+func (s *levelDbStore) close() error {
+	err := s.db.Close()
+	if s.copyDir != "" {
+		os.RemoveAll(s.copyDir)
 	}
-	byt := make([]byte, 8)
-	binary.BigEndian.PutUint64(byt, retKey)
-	return byt
+	return err
 }
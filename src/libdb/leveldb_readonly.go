@@ -0,0 +1,102 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// copyAttempts is how many times we'll try to copy a live database, as a
+// compaction may remove a table while we're copying it
+const copyAttempts = 3
+
+// openLevelDBReadOnly will open the database without write access. When the
+// database is locked by a writer we'll open a private copy instead, whose
+// path is returned so that it can be removed on close.
+func openLevelDBReadOnly(storagePath string) (*leveldb.DB, string, error) {
+	options := &opt.Options{
+		ReadOnly:       true,
+		ErrorIfMissing: true,
+	}
+	ldb, err := leveldb.OpenFile(storagePath, options)
+	if err != syscall.EWOULDBLOCK {
+		return ldb, "", err
+	}
+
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		var copyDir string
+		if copyDir, err = ioutil.TempDir("", "libdb-readonly"); err != nil {
+			return nil, "", err
+		}
+		if err = copyLevelDB(storagePath, copyDir); err == nil {
+			if ldb, err = leveldb.OpenFile(copyDir, options); err == nil {
+				return ldb, copyDir, nil
+			}
+		}
+		os.RemoveAll(copyDir)
+	}
+	return nil, "", err
+}
+
+// copyLevelDB will copy the database files, starting with the CURRENT file
+// so that we never pick up a manifest newer than the tables we copy
+func copyLevelDB(source, dest string) error {
+	files, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(filepath.Join(source, "CURRENT"), filepath.Join(dest, "CURRENT")); err != nil {
+		return err
+	}
+	for _, file := range files {
+		switch file.Name() {
+		case "CURRENT", "LOCK", "LOG", "LOG.old":
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(source, file.Name()), filepath.Join(dest, file.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile will copy a single file into place
+func copyFile(source, dest string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
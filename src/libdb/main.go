@@ -17,12 +17,23 @@
 package libdb
 
 import (
+	"errors"
+	"io"
 	"sync"
 )
 
+// ErrReadOnly is returned when attempting to write to a database that was
+// opened with OpenReadOnly
+var ErrReadOnly = errors.New("database is opened read-only")
+
 // DbForeachFunc is used in the root (untyped buckets)
 type DbForeachFunc func(key, val []byte) error
 
+// An IndexFunc returns the secondary index keys for the object stored under
+// id. The object itself is obtained by passing a pointer of the expected type
+// to decode, so that existing objects may also be indexed.
+type IndexFunc func(id []byte, decode func(o interface{}) error) ([][]byte, error)
+
 // A Closable is a handle or database that can be closed
 type Closable interface {
 	// Close the database
@@ -36,6 +47,11 @@ type ReadOnlyView interface {
 	// Get an object from storage
 	GetObject(id []byte, o interface{}) error
 
+	// Get many objects from storage in one go. newObject returns the
+	// pointer to decode the object for ids[i] into, and is only called
+	// for the objects that exist.
+	GetObjects(ids [][]byte, newObject func(i int) interface{}) error
+
 	// Determine if an object with that ID exists already
 	HasObject(id []byte) (bool, error)
 
@@ -44,6 +60,18 @@ type ReadOnlyView interface {
 
 	// For every key value pair, run the given function
 	ForEach(f DbForeachFunc) error
+
+	// For every key value pair where the key begins with prefix, run the
+	// given function
+	ForEachPrefix(prefix []byte, f DbForeachFunc) error
+
+	// For every key value pair from start up to but not including end, in
+	// key order, run the given function. A nil start or end leaves that
+	// side of the range open.
+	ForEachRange(start, end []byte, f DbForeachFunc) error
+
+	// Return the IDs of every object with the given key in the named index
+	LookupIndex(name string, key []byte) ([][]byte, error)
 }
 
 // WriterView allows destructive write actions within the database
@@ -54,6 +82,9 @@ type WriterView interface {
 
 	// Put an object into storage (unique key)
 	PutObject(id []byte, o interface{}) error
+
+	// Put many objects into storage within a single transaction
+	PutObjects(objects map[string]interface{}) error
 }
 
 // A ReadOnlyFunc is expected by the Database.View method
@@ -70,6 +101,17 @@ type Database interface {
 	// Return a subset of the database for usage
 	Bucket(id []byte) Database
 
+	// Index will register a secondary index on this bucket, which is then
+	// maintained by every PutObject and DeleteObject. Existing objects are
+	// indexed the first time the index is registered, so the name must
+	// change if the IndexFunc begins returning different keys.
+	Index(name string, f IndexFunc) error
+
+	// Cache will keep the most recently read objects of this bucket in
+	// memory, up to size of them. Objects are handed out by copying them
+	// with the CopyFunc, and are dropped as soon as they're written.
+	Cache(size int, copy CopyFunc) error
+
 	// NextSequence returns the next natural sequence for insert-order-centric applications
 	// Note this will cause implementations to lock while finding the natural sequence
 	NextSequence() []byte
@@ -81,6 +123,19 @@ type Database interface {
 	// Obtain a read-write view of the database in a transaction
 	Update(f WriterFunc) error
 
+	// SetCodec will choose the codec used for every value written from now
+	// on, across the entire database. Values written by other codecs remain
+	// readable, and are migrated when read by GetObject or GetObjects.
+	SetCodec(name string) error
+
+	// Backup will write a consistent snapshot of the entire database,
+	// regardless of the bucket it is called on
+	Backup(w io.Writer) error
+
+	// Restore will replace the entire database with a snapshot previously
+	// written by Backup
+	Restore(r io.Reader) error
+
 	// Close the database (might no-op)
 	Close()
 }
@@ -107,8 +162,17 @@ func (c *closable) close() bool {
 }
 
 // Open will return an opaque representation of the underlying database
-// implementation suitable for usage within ferryd
-func Open(path string) (Database, error) {
-	// For now we're just using leveldb
-	return newLevelDBHandle(path)
+// implementation suitable for usage within ferryd. The backend is selected
+// by the URI scheme, i.e. bolt:///var/lib/ferryd/db, and a plain path will
+// use the DefaultBackend.
+func Open(uri string) (Database, error) {
+	return openURI(uri, false)
+}
+
+// OpenReadOnly will return a view of the database that refuses all writes.
+// If the database is currently held open by another process, such as a
+// running daemon, a point-in-time copy is read instead so that we never
+// contend for its lock.
+func OpenReadOnly(uri string) (Database, error) {
+	return openURI(uri, true)
 }
@@ -0,0 +1,720 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// ErrTruncatedValue is returned when a msgpack value ends unexpectedly
+var ErrTruncatedValue = errors.New("msgpack: unexpected end of value")
+
+// msgpack type markers, see https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+	mpFixMap   = 0x80
+	mpFixArray = 0x90
+	mpFixStr   = 0xa0
+)
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// msgpackCodec stores structs as maps keyed by field name, so much like gob
+// fields may be added or removed without breaking existing values. Unlike
+// gob, no type descriptions are written with every value, making it far
+// cheaper for the small objects we store.
+//
+// Only the types we store are handled. This should give way to a vendored
+// msgpack library once one is added as a submodule alongside our other
+// dependencies, with a new codec tag. There is no protobuf codec, as that
+// needs message types generated for every record.
+type msgpackCodec struct{}
+
+// structField is an exported field of a struct
+type structField struct {
+	name  string
+	index int
+}
+
+// structFields caches the exported fields of every struct type we've seen
+var structFields sync.Map
+
+// isBinaryType determines whether values of the type, such as time.Time,
+// know how to store themselves
+func isBinaryType(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Implements(binaryMarshalerType) && reflect.PtrTo(t).Implements(binaryUnmarshalerType)
+}
+
+// fieldsOf returns the exported fields of the struct type
+func fieldsOf(t reflect.Type) []structField {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]structField)
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		if f := t.Field(i); f.PkgPath == "" {
+			fields = append(fields, structField{name: f.Name, index: i})
+		}
+	}
+	structFields.Store(t, fields)
+	return fields
+}
+
+// Encode will write the object as msgpack
+func (msgpackCodec) Encode(o interface{}) ([]byte, error) {
+	e := &msgpackEncoder{}
+	if err := e.encode(reflect.ValueOf(o)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Decode will read the msgpack input into the pointer o
+func (msgpackCodec) Decode(input []byte, o interface{}) error {
+	v := reflect.ValueOf(o)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("msgpack: cannot decode into %T", o)
+	}
+	d := &msgpackDecoder{buf: input}
+	if err := d.decode(v.Elem()); err != nil {
+		return err
+	}
+	if d.pos != len(d.buf) {
+		return fmt.Errorf("msgpack: %d trailing bytes", len(d.buf)-d.pos)
+	}
+	return nil
+}
+
+// msgpackEncoder appends values to its buffer
+type msgpackEncoder struct {
+	buf []byte
+}
+
+func (e *msgpackEncoder) writeByte(b byte) {
+	e.buf = append(e.buf, b)
+}
+
+func (e *msgpackEncoder) write16(b byte, n uint16) {
+	e.buf = append(e.buf, b, 0, 0)
+	binary.BigEndian.PutUint16(e.buf[len(e.buf)-2:], n)
+}
+
+func (e *msgpackEncoder) write32(b byte, n uint32) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(e.buf[len(e.buf)-4:], n)
+}
+
+func (e *msgpackEncoder) write64(b byte, n uint64) {
+	e.buf = append(e.buf, b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.buf[len(e.buf)-8:], n)
+}
+
+func (e *msgpackEncoder) writeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.writeUint(uint64(n))
+	case n >= -32:
+		e.writeByte(byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, mpInt8, byte(n))
+	case n >= math.MinInt16:
+		e.write16(mpInt16, uint16(n))
+	case n >= math.MinInt32:
+		e.write32(mpInt32, uint32(n))
+	default:
+		e.write64(mpInt64, uint64(n))
+	}
+}
+
+func (e *msgpackEncoder) writeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.writeByte(byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, mpUint8, byte(n))
+	case n <= math.MaxUint16:
+		e.write16(mpUint16, uint16(n))
+	case n <= math.MaxUint32:
+		e.write32(mpUint32, uint32(n))
+	default:
+		e.write64(mpUint64, n)
+	}
+}
+
+// writeHeader will write the length header for a str, array or map, using
+// the fixed form if it fits
+func (e *msgpackEncoder) writeHeader(fix byte, fixMax int, b8, b16, b32 byte, n int) {
+	switch {
+	case n <= fixMax:
+		e.writeByte(fix | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		e.buf = append(e.buf, b8, byte(n))
+	case n <= math.MaxUint16:
+		e.write16(b16, uint16(n))
+	default:
+		e.write32(b32, uint32(n))
+	}
+}
+
+func (e *msgpackEncoder) writeString(s string) {
+	e.writeHeader(mpFixStr, 31, mpStr8, mpStr16, mpStr32, len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *msgpackEncoder) writeBytes(b []byte) {
+	switch {
+	case len(b) <= math.MaxUint8:
+		e.buf = append(e.buf, mpBin8, byte(len(b)))
+	case len(b) <= math.MaxUint16:
+		e.write16(mpBin16, uint16(len(b)))
+	default:
+		e.write32(mpBin32, uint32(len(b)))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.writeByte(mpNil)
+		return nil
+	}
+
+	if isBinaryType(v.Type()) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		e.writeBytes(b)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.writeByte(mpTrue)
+		} else {
+			e.writeByte(mpFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.write32(mpFloat32, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.write64(mpFloat64, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.writeByte(mpNil)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.writeByte(mpNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return nil
+		}
+		return e.encodeArray(v)
+	case reflect.Array:
+		return e.encodeArray(v)
+	case reflect.Map:
+		if v.IsNil() {
+			e.writeByte(mpNil)
+			return nil
+		}
+		return e.encodeMap(v)
+	case reflect.Struct:
+		return e.encodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeArray(v reflect.Value) error {
+	e.writeHeader(mpFixArray, 15, 0, mpArray16, mpArray32, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeMap will write the map in key order, so that equal maps are always
+// stored identically
+func (e *msgpackEncoder) encodeMap(v reflect.Value) error {
+	type entry struct {
+		key   []byte
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	for _, key := range v.MapKeys() {
+		k := &msgpackEncoder{}
+		if err := k.encode(key); err != nil {
+			return err
+		}
+		entries = append(entries, entry{k.buf, v.MapIndex(key)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return string(entries[i].key) < string(entries[j].key)
+	})
+
+	e.writeHeader(mpFixMap, 15, 0, mpMap16, mpMap32, len(entries))
+	for _, ent := range entries {
+		e.buf = append(e.buf, ent.key...)
+		if err := e.encode(ent.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *msgpackEncoder) encodeStruct(v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	e.writeHeader(mpFixMap, 15, 0, mpMap16, mpMap32, len(fields))
+	for _, f := range fields {
+		e.writeString(f.name)
+		if err := e.encode(v.Field(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackDecoder reads values from its buffer
+type msgpackDecoder struct {
+	buf []byte
+	pos int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.buf)-d.pos < n {
+		return nil, ErrTruncatedValue
+	}
+	ret := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return ret, nil
+}
+
+func (d *msgpackDecoder) readByte() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// readUint will read a big endian unsigned integer of n bytes
+func (d *msgpackDecoder) readUint(n int) (uint64, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// readLength will read the length following a str, bin, array or map marker
+func (d *msgpackDecoder) readLength(b byte) (int, error) {
+	var n uint64
+	var err error
+	switch {
+	case b&0xe0 == mpFixStr:
+		return int(b & 0x1f), nil
+	case b&0xf0 == mpFixArray, b&0xf0 == mpFixMap:
+		return int(b & 0x0f), nil
+	case b == mpStr8, b == mpBin8:
+		n, err = d.readUint(1)
+	case b == mpStr16, b == mpBin16, b == mpArray16, b == mpMap16:
+		n, err = d.readUint(2)
+	case b == mpStr32, b == mpBin32, b == mpArray32, b == mpMap32:
+		n, err = d.readUint(4)
+	default:
+		return 0, fmt.Errorf("msgpack: unexpected type 0x%02x", b)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, ErrTruncatedValue
+	}
+	return int(n), nil
+}
+
+func isStr(b byte) bool {
+	return b&0xe0 == mpFixStr || b == mpStr8 || b == mpStr16 || b == mpStr32
+}
+
+func isBin(b byte) bool {
+	return b == mpBin8 || b == mpBin16 || b == mpBin32
+}
+
+func isArray(b byte) bool {
+	return b&0xf0 == mpFixArray || b == mpArray16 || b == mpArray32
+}
+
+func isMap(b byte) bool {
+	return b&0xf0 == mpFixMap || b == mpMap16 || b == mpMap32
+}
+
+// readRaw will read a str or bin value without copying it
+func (d *msgpackDecoder) readRaw() ([]byte, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	if !isStr(b) && !isBin(b) {
+		return nil, fmt.Errorf("msgpack: expected str or bin, got 0x%02x", b)
+	}
+	n, err := d.readLength(b)
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+// readNumber will read any numeric value, returning it as whichever of the
+// three forms it was stored as
+func (d *msgpackDecoder) readNumber() (i int64, u uint64, f float64, kind reflect.Kind, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return
+	}
+	switch {
+	case b <= 0x7f:
+		return 0, uint64(b), 0, reflect.Uint64, nil
+	case b >= 0xe0:
+		return int64(int8(b)), 0, 0, reflect.Int64, nil
+	case b >= mpUint8 && b <= mpUint64:
+		u, err = d.readUint(1 << (b - mpUint8))
+		return 0, u, 0, reflect.Uint64, err
+	case b >= mpInt8 && b <= mpInt64:
+		n := 1 << (b - mpInt8)
+		if u, err = d.readUint(n); err != nil {
+			return
+		}
+		// Sign extend from the stored width
+		shift := uint(64 - 8*n)
+		return int64(u<<shift) >> shift, 0, 0, reflect.Int64, nil
+	case b == mpFloat32:
+		u, err = d.readUint(4)
+		return 0, 0, float64(math.Float32frombits(uint32(u))), reflect.Float64, err
+	case b == mpFloat64:
+		u, err = d.readUint(8)
+		return 0, 0, math.Float64frombits(u), reflect.Float64, err
+	}
+	return 0, 0, 0, reflect.Invalid, fmt.Errorf("msgpack: expected number, got 0x%02x", b)
+}
+
+// skip will step over the next value, used for fields we no longer have
+func (d *msgpackDecoder) skip() error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case b <= 0x7f, b >= 0xe0, b == mpNil, b == mpFalse, b == mpTrue:
+		return nil
+	case b >= mpUint8 && b <= mpUint64:
+		_, err = d.next(1 << (b - mpUint8))
+	case b >= mpInt8 && b <= mpInt64:
+		_, err = d.next(1 << (b - mpInt8))
+	case b == mpFloat32:
+		_, err = d.next(4)
+	case b == mpFloat64:
+		_, err = d.next(8)
+	case isStr(b), isBin(b):
+		var n int
+		if n, err = d.readLength(b); err == nil {
+			_, err = d.next(n)
+		}
+	case isArray(b), isMap(b):
+		var n int
+		if n, err = d.readLength(b); err != nil {
+			return err
+		}
+		if isMap(b) {
+			n *= 2
+		}
+		for i := 0; i < n && err == nil; i++ {
+			err = d.skip()
+		}
+	default:
+		err = fmt.Errorf("msgpack: unexpected type 0x%02x", b)
+	}
+	return err
+}
+
+func (d *msgpackDecoder) decode(v reflect.Value) error {
+	if d.pos >= len(d.buf) {
+		return ErrTruncatedValue
+	}
+	if d.buf[d.pos] == mpNil {
+		d.pos++
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	if isBinaryType(v.Type()) {
+		b, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if b != mpTrue && b != mpFalse {
+			return fmt.Errorf("msgpack: expected bool, got 0x%02x", b)
+		}
+		v.SetBool(b == mpTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, u, _, kind, err := d.readNumber()
+		if err != nil {
+			return err
+		}
+		if kind == reflect.Uint64 {
+			if u > math.MaxInt64 {
+				return fmt.Errorf("msgpack: %d overflows %s", u, v.Type())
+			}
+			i = int64(u)
+		} else if kind != reflect.Int64 {
+			return fmt.Errorf("msgpack: cannot decode float into %s", v.Type())
+		}
+		if v.OverflowInt(i) {
+			return fmt.Errorf("msgpack: %d overflows %s", i, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, u, _, kind, err := d.readNumber()
+		if err != nil {
+			return err
+		}
+		if kind == reflect.Int64 {
+			if i < 0 {
+				return fmt.Errorf("msgpack: %d overflows %s", i, v.Type())
+			}
+			u = uint64(i)
+		} else if kind != reflect.Uint64 {
+			return fmt.Errorf("msgpack: cannot decode float into %s", v.Type())
+		}
+		if v.OverflowUint(u) {
+			return fmt.Errorf("msgpack: %d overflows %s", u, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		i, u, f, kind, err := d.readNumber()
+		if err != nil {
+			return err
+		}
+		switch kind {
+		case reflect.Int64:
+			f = float64(i)
+		case reflect.Uint64:
+			f = float64(u)
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		b, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(v.Elem())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.readRaw()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte{}, b...))
+			return nil
+		}
+		return d.decodeArray(v)
+	case reflect.Array:
+		return d.decodeArray(v)
+	case reflect.Map:
+		return d.decodeMap(v)
+	case reflect.Struct:
+		return d.decodeStruct(v)
+	default:
+		return fmt.Errorf("msgpack: cannot decode into %s", v.Type())
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeArray(v reflect.Value) error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if !isArray(b) {
+		return fmt.Errorf("msgpack: expected array for %s, got 0x%02x", v.Type(), b)
+	}
+	n, err := d.readLength(b)
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Slice {
+		v.Set(reflect.MakeSlice(v.Type(), n, n))
+	}
+	for i := 0; i < n; i++ {
+		if i >= v.Len() {
+			if err := d.skip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := d.decode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) decodeMap(v reflect.Value) error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if !isMap(b) {
+		return fmt.Errorf("msgpack: expected map for %s, got 0x%02x", v.Type(), b)
+	}
+	n, err := d.readLength(b)
+	if err != nil {
+		return err
+	}
+	t := v.Type()
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, n))
+	}
+	for i := 0; i < n; i++ {
+		key := reflect.New(t.Key()).Elem()
+		if err := d.decode(key); err != nil {
+			return err
+		}
+		value := reflect.New(t.Elem()).Elem()
+		if err := d.decode(value); err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	}
+	return nil
+}
+
+// decodeStruct will set the fields present in the value, by name. Fields we
+// don't know about are skipped.
+func (d *msgpackDecoder) decodeStruct(v reflect.Value) error {
+	b, err := d.readByte()
+	if err != nil {
+		return err
+	}
+	if !isMap(b) {
+		return fmt.Errorf("msgpack: expected map for %s, got 0x%02x", v.Type(), b)
+	}
+	n, err := d.readLength(b)
+	if err != nil {
+		return err
+	}
+	fields := fieldsOf(v.Type())
+	for i := 0; i < n; i++ {
+		name, err := d.readRaw()
+		if err != nil {
+			return err
+		}
+		f := findField(fields, i, name)
+		if f == nil {
+			err = d.skip()
+		} else {
+			err = d.decode(v.Field(f.index))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findField will return the named field, trying the ith first as the fields
+// are normally stored in order
+func findField(fields []structField, i int, name []byte) *structField {
+	if i < len(fields) && fields[i].name == string(name) {
+		return &fields[i]
+	}
+	for j := range fields {
+		if fields[j].name == string(name) {
+			return &fields[j]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRecord is much like the records we store, with a bit of everything
+type testRecord struct {
+	Name     string
+	Release  int
+	Size     uint64
+	Ratio    float64
+	Frozen   bool
+	Deps     []string
+	Hash     []byte
+	Sums     [2]uint32
+	Meta     map[string]string
+	Parent   *testRecord
+	Children []*testRecord
+	Added    time.Time
+	private  int
+}
+
+// makeStrings returns n strings, to cover every length of array
+func makeStrings(n int) []string {
+	ret := make([]string, n)
+	for i := range ret {
+		ret[i] = strings.Repeat("x", i%8)
+	}
+	return ret
+}
+
+// makeMap returns a map of n entries, to cover every length of map
+func makeMap(n int) map[string]int {
+	ret := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		ret[strconv.Itoa(i)] = i
+	}
+	return ret
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	added := time.Date(2017, 10, 17, 12, 30, 0, 500, time.UTC)
+	tests := []struct {
+		value  interface{}
+		marker byte // First byte of the encoding
+	}{
+		{true, mpTrue},
+		{false, mpFalse},
+		{0, 0x00},
+		{127, 0x7f},
+		{128, mpUint8},
+		{255, mpUint8},
+		{256, mpUint16},
+		{math.MaxUint16, mpUint16},
+		{math.MaxUint16 + 1, mpUint32},
+		{int64(math.MaxUint32), mpUint32},
+		{int64(math.MaxUint32 + 1), mpUint64},
+		{int64(math.MaxInt64), mpUint64},
+		{-1, 0xff},
+		{-32, 0xe0},
+		{-33, mpInt8},
+		{math.MinInt8, mpInt8},
+		{math.MinInt8 - 1, mpInt16},
+		{math.MinInt16, mpInt16},
+		{math.MinInt16 - 1, mpInt32},
+		{int64(math.MinInt32), mpInt32},
+		{int64(math.MinInt32 - 1), mpInt64},
+		{int64(math.MinInt64), mpInt64},
+		{int8(-100), mpInt8},
+		{int16(1000), mpUint16},
+		{int32(-70000), mpInt32},
+		{uint8(200), mpUint8},
+		{uint16(60000), mpUint16},
+		{uint32(math.MaxUint32), mpUint32},
+		{uint64(math.MaxUint64), mpUint64},
+		{uint(42), 0x2a},
+		{float32(1.5), mpFloat32},
+		{math.Pi, mpFloat64},
+		{"", mpFixStr},
+		{strings.Repeat("s", 31), mpFixStr | 31},
+		{strings.Repeat("s", 32), mpStr8},
+		{strings.Repeat("s", 255), mpStr8},
+		{strings.Repeat("s", 256), mpStr16},
+		{strings.Repeat("s", math.MaxUint16+1), mpStr32},
+		{[]byte{}, mpBin8},
+		{bytes.Repeat([]byte{1}, 255), mpBin8},
+		{bytes.Repeat([]byte{1}, 256), mpBin16},
+		{bytes.Repeat([]byte{1}, math.MaxUint16+1), mpBin32},
+		{[]string{}, mpFixArray},
+		{makeStrings(15), mpFixArray | 15},
+		{makeStrings(16), mpArray16},
+		{makeStrings(math.MaxUint16 + 1), mpArray32},
+		{[3]int{1, -2, 300}, mpFixArray | 3},
+		{map[string]int{}, mpFixMap},
+		{makeMap(15), mpFixMap | 15},
+		{makeMap(16), mpMap16},
+		{makeMap(math.MaxUint16 + 1), mpMap32},
+		{map[int]string{-1: "a", 1000: "b"}, mpFixMap | 2},
+		{added, mpBin8},
+		{&testRecord{Name: "nano"}, mpFixMap | 12},
+		{testRecord{
+			Name:    "nano",
+			Release: 63,
+			Size:    1 << 40,
+			Ratio:   0.25,
+			Frozen:  true,
+			Deps:    []string{"glibc", "ncurses"},
+			Hash:    []byte{0xde, 0xad, 0xbe, 0xef},
+			Sums:    [2]uint32{1, math.MaxUint32},
+			Meta:    map[string]string{"license": "GPL-3.0"},
+			Parent:  &testRecord{Name: "nano-docs", Meta: map[string]string{}},
+			Children: []*testRecord{
+				{Name: "a", Added: added},
+				nil,
+			},
+			Added: added,
+		}, mpFixMap | 12},
+	}
+
+	c := msgpackCodec{}
+	for _, test := range tests {
+		b, err := c.Encode(test.value)
+		if err != nil {
+			t.Fatalf("Failed to encode %T: %v", test.value, err)
+		}
+		if b[0] != test.marker {
+			t.Fatalf("Wrong encoding for %T %.40v: began 0x%02x, expected 0x%02x", test.value, test.value, b[0], test.marker)
+		}
+		got := reflect.New(reflect.TypeOf(test.value))
+		if err := c.Decode(b, got.Interface()); err != nil {
+			t.Fatalf("Failed to decode %T: %v", test.value, err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), test.value) {
+			t.Fatalf("%T changed in a round trip:\n%.200v\n%.200v", test.value, got.Elem().Interface(), test.value)
+		}
+	}
+}
+
+func TestMsgpackNil(t *testing.T) {
+	c := msgpackCodec{}
+	b, err := c.Encode(&testRecord{Name: "nano"})
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// Nil values are cleared rather than left as they were
+	got := &testRecord{
+		Deps:   []string{"stale"},
+		Hash:   []byte{1},
+		Meta:   map[string]string{"stale": "yes"},
+		Parent: &testRecord{},
+	}
+	if err := c.Decode(b, got); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if got.Deps != nil || got.Hash != nil || got.Meta != nil || got.Parent != nil {
+		t.Fatalf("Nil fields weren't cleared: %+v", got)
+	}
+
+	var p *testRecord
+	if b, err = c.Encode(p); err != nil {
+		t.Fatalf("Failed to encode nil: %v", err)
+	}
+	if !bytes.Equal(b, []byte{mpNil}) {
+		t.Fatalf("Wrong encoding for nil: %v", b)
+	}
+}
+
+// recordV1 is a record as it was first stored
+type recordV1 struct {
+	Name     string
+	Release  int
+	Obsolete []string
+	Nested   map[string][]int
+	Owner    struct{ Name, Email string }
+}
+
+// recordV2 is the same record once fields were added, removed, reordered
+// and widened
+type recordV2 struct {
+	Release int64
+	Name    string
+	Summary string
+	Tags    map[string]bool
+	Since   time.Time
+}
+
+func TestMsgpackChangedStruct(t *testing.T) {
+	c := msgpackCodec{}
+	v1 := &recordV1{
+		Name:     "nano",
+		Release:  63,
+		Obsolete: []string{"pico"},
+		Nested:   map[string][]int{"a": {1, 2}},
+	}
+	v1.Owner.Name = "Solus"
+	b, err := c.Encode(v1)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+
+	// Removed fields are skipped, and added ones left alone
+	var v2 recordV2
+	if err := c.Decode(b, &v2); err != nil {
+		t.Fatalf("Failed to decode into newer struct: %v", err)
+	}
+	want := recordV2{Name: "nano", Release: 63}
+	if !reflect.DeepEqual(v2, want) {
+		t.Fatalf("Wrong newer struct:\n%+v\n%+v", v2, want)
+	}
+
+	// And the other way around, i.e. when rolling back
+	v2.Summary = "Small text editor"
+	v2.Tags = map[string]bool{"editor": true}
+	v2.Since = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	if b, err = c.Encode(&v2); err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	var old recordV1
+	if err := c.Decode(b, &old); err != nil {
+		t.Fatalf("Failed to decode into older struct: %v", err)
+	}
+	if !reflect.DeepEqual(old, recordV1{Name: "nano", Release: 63}) {
+		t.Fatalf("Wrong older struct: %+v", old)
+	}
+}
+
+func TestMsgpackInvalid(t *testing.T) {
+	c := msgpackCodec{}
+	b, err := c.Encode(&testRecord{Name: "nano", Deps: []string{"glibc"}})
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	var r testRecord
+	for i := 0; i < len(b); i++ {
+		if err := c.Decode(b[:i], &r); err != ErrTruncatedValue {
+			t.Fatalf("Expected truncated value at %d bytes, got %v", i, err)
+		}
+	}
+	if err := c.Decode(append(b, 0), &r); err == nil {
+		t.Fatalf("Expected an error for trailing bytes")
+	}
+	if err := c.Decode(b, r); err == nil {
+		t.Fatalf("Expected an error decoding into a non-pointer")
+	}
+
+	big, _ := c.Encode(300)
+	var small int8
+	if err := c.Decode(big, &small); err == nil {
+		t.Fatalf("Expected an error for an overflowing int")
+	}
+	negative, _ := c.Encode(-1)
+	var u uint
+	if err := c.Decode(negative, &u); err == nil {
+		t.Fatalf("Expected an error for a negative uint")
+	}
+	str, _ := c.Encode("nano")
+	var n int
+	if err := c.Decode(str, &n); err == nil {
+		t.Fatalf("Expected an error decoding a string into an int")
+	}
+	if _, err := c.Encode(make(chan int)); err == nil {
+		t.Fatalf("Expected an error encoding a channel")
+	}
+}
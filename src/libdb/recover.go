@@ -0,0 +1,102 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"fmt"
+	"time"
+)
+
+// A CorruptedError is returned when a database can't be opened because it
+// is corrupted, i.e. after the process was killed during a compaction.
+// Recover may be able to repair it.
+type CorruptedError struct {
+	Path string
+	Err  error
+}
+
+// Error will return the human readable form of the corruption
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("database %s is corrupted: %v", e.Path, e.Err)
+}
+
+// IsCorrupted will determine if the database couldn't be opened because it
+// is corrupted
+func IsCorrupted(err error) bool {
+	_, ok := err.(*CorruptedError)
+	return ok
+}
+
+// recoverFunc will copy the store of a backend at path into backup, before
+// repairing the store in place
+type recoverFunc func(path, backup string) error
+
+// openRecoveredFunc will open a repaired copy of the corrupted store at
+// path, leaving the store itself alone
+type openRecoveredFunc func(path string) (store, error)
+
+// recoverers maps the URI scheme of every backend which can be repaired
+var recoverers = make(map[string]recoverFunc)
+
+// recoveredOpeners maps the URI scheme of every backend which can be
+// repaired to how a repaired copy is opened
+var recoveredOpeners = make(map[string]openRecoveredFunc)
+
+// registerRecovery will make the backend's repair available to Recover and
+// OpenRecovered
+func registerRecovery(scheme string, f recoverFunc, open openRecoveredFunc) {
+	recoverers[scheme] = f
+	recoveredOpeners[scheme] = open
+}
+
+// Recover will attempt to repair the corrupted database at the URI, keeping
+// everything that can still be read. The database is copied aside first, and
+// the path of the copy is returned. It must not be open while recovering.
+func Recover(uri string) (string, error) {
+	backend, path, err := ParseURI(uri)
+	if err != nil {
+		return "", err
+	}
+	f, ok := recoverers[backend]
+	if !ok {
+		return "", fmt.Errorf("the %s backend cannot be recovered", backend)
+	}
+	backup := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102-150405"))
+	if err := f(path, backup); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// OpenRecovered will return a read-only view of a repaired copy of the
+// corrupted database at the URI, for when it can't be repaired in place. The
+// database itself is left untouched, and the copy is removed on close.
+func OpenRecovered(uri string) (Database, error) {
+	backend, path, err := ParseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := recoveredOpeners[backend]
+	if !ok {
+		return nil, fmt.Errorf("the %s backend cannot be recovered", backend)
+	}
+	s, err := f(path)
+	if err != nil {
+		return nil, err
+	}
+	return newRootHandle(s, true), nil
+}
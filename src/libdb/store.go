@@ -0,0 +1,149 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+)
+
+// ErrNotFound is returned when reading a key that doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// A store is the raw ordered key value storage that a backend provides.
+// Buckets, encoding and indexes are all implemented on top of it, so that
+// every backend shares the same key layout.
+type store interface {
+	// Return the value for the key, or ErrNotFound
+	get(key []byte) ([]byte, error)
+
+	// Return the values for every key from a consistent view, with nil
+	// for those that don't exist
+	getMany(keys [][]byte) ([][]byte, error)
+
+	// Determine whether the key exists
+	has(key []byte) (bool, error)
+
+	// Atomically apply every operation in the batch
+	write(b *batch) error
+
+	// Visit every key in the range in order. The function is not called
+	// within any storage transaction, so it may freely use the store.
+	iterate(r *keyRange, f DbForeachFunc) error
+
+	// Return the last key in the range, or nil if it's empty
+	last(r *keyRange) ([]byte, error)
+
+	// Visit every key in a consistent view of the store. The function
+	// must not use the store.
+	snapshot(f DbForeachFunc) error
+
+	// Close the underlying storage
+	close() error
+}
+
+// A keyRange covers the keys from start up to but not including limit.
+// A nil start or limit leaves that side of the range open.
+type keyRange struct {
+	start []byte
+	limit []byte
+}
+
+// contains will determine whether the key lies within the range
+func (r *keyRange) contains(key []byte) bool {
+	if r.start != nil && string(key) < string(r.start) {
+		return false
+	}
+	return r.limit == nil || string(key) < string(r.limit)
+}
+
+// prefixRange returns the range of every key beginning with prefix
+func prefixRange(prefix []byte) *keyRange {
+	var limit []byte
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if c := prefix[i]; c < 0xff {
+			limit = make([]byte, i+1)
+			copy(limit, prefix)
+			limit[i] = c + 1
+			break
+		}
+	}
+	return &keyRange{start: prefix, limit: limit}
+}
+
+// batchOp is a single put or delete within a batch
+type batchOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// A batch collects writes to be applied atomically
+type batch struct {
+	ops    []batchOp
+	latest map[string]int // Index of the last op for each key
+}
+
+// record will add the operation to the batch
+func (b *batch) record(op batchOp) {
+	if b.latest == nil {
+		b.latest = make(map[string]int)
+	}
+	b.latest[string(op.key)] = len(b.ops)
+	b.ops = append(b.ops, op)
+}
+
+// Put will record the key being set to value
+func (b *batch) Put(key, value []byte) {
+	b.record(batchOp{
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+// Delete will record the key being removed
+func (b *batch) Delete(key []byte) {
+	b.record(batchOp{
+		key:    append([]byte{}, key...),
+		delete: true,
+	})
+}
+
+// lookup will return the last operation on the key, if there is one
+func (b *batch) lookup(key []byte) (*batchOp, bool) {
+	i, ok := b.latest[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return &b.ops[i], true
+}
+
+// within will return the last operation on each key in the range, in key
+// order
+func (b *batch) within(r *keyRange) []batchOp {
+	var ret []batchOp
+	for key, i := range b.latest {
+		if r.contains([]byte(key)) {
+			ret = append(ret, b.ops[i])
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return bytes.Compare(ret[i].key, ret[j].key) < 0
+	})
+	return ret
+}
@@ -0,0 +1,167 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libdb
+
+import (
+	"bytes"
+)
+
+// txStore layers the pending writes of a transaction over the store, so that
+// reads within the transaction see its own writes. As transactions hold the
+// write lock, nothing else can change the store underneath us.
+type txStore struct {
+	store
+	pending *batch
+}
+
+func (t *txStore) get(key []byte) ([]byte, error) {
+	if op, ok := t.pending.lookup(key); ok {
+		if op.delete {
+			return nil, ErrNotFound
+		}
+		return append([]byte{}, op.value...), nil
+	}
+	return t.store.get(key)
+}
+
+func (t *txStore) getMany(keys [][]byte) ([][]byte, error) {
+	ret := make([][]byte, len(keys))
+	var stored [][]byte
+	var storedIndex []int
+	for i, key := range keys {
+		op, ok := t.pending.lookup(key)
+		if !ok {
+			stored = append(stored, key)
+			storedIndex = append(storedIndex, i)
+		} else if !op.delete {
+			ret[i] = append([]byte{}, op.value...)
+		}
+	}
+	if len(stored) == 0 {
+		return ret, nil
+	}
+	values, err := t.store.getMany(stored)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range storedIndex {
+		ret[i] = values[j]
+	}
+	return ret, nil
+}
+
+func (t *txStore) has(key []byte) (bool, error) {
+	if op, ok := t.pending.lookup(key); ok {
+		return !op.delete, nil
+	}
+	return t.store.has(key)
+}
+
+// write will join the batch to the transaction
+func (t *txStore) write(b *batch) error {
+	for _, op := range b.ops {
+		t.pending.record(op)
+	}
+	return nil
+}
+
+// iterate will merge the pending writes into the store's keys. Writes made
+// while iterating aren't visited.
+func (t *txStore) iterate(r *keyRange, f DbForeachFunc) error {
+	ops := t.pending.within(r)
+	i := 0
+
+	// emitPending will visit the pending keys sorting before until
+	emitPending := func(until []byte) error {
+		for ; i < len(ops) && (until == nil || bytes.Compare(ops[i].key, until) < 0); i++ {
+			if ops[i].delete {
+				continue
+			}
+			if err := f(ops[i].key, ops[i].value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := t.store.iterate(r, func(key, value []byte) error {
+		if err := emitPending(key); err != nil {
+			return err
+		}
+		// Pending writes replace what is stored
+		if i < len(ops) && bytes.Equal(ops[i].key, key) {
+			op := ops[i]
+			i++
+			if op.delete {
+				return nil
+			}
+			return f(op.key, op.value)
+		}
+		return f(key, value)
+	})
+	if err != nil {
+		return err
+	}
+	return emitPending(nil)
+}
+
+func (t *txStore) last(r *keyRange) ([]byte, error) {
+	var ret []byte
+	err := t.iterate(r, func(key, value []byte) error {
+		ret = append(ret[:0], key...)
+		return nil
+	})
+	return ret, err
+}
+
+func (t *txStore) snapshot(f DbForeachFunc) error {
+	return t.iterate(&keyRange{}, f)
+}
+
+// close is a no-op, the store belongs to the database
+func (t *txStore) close() error {
+	return nil
+}
+
+// update will run the function within a transaction, joining the current one
+// if there is one. Transactions are serialised by a database-wide lock, so
+// anything read within one remains valid until it commits. The lock must be
+// the last one taken by any caller.
+func (h *dbHandle) update(f func(tx *dbHandle) error) error {
+	if h.batch != nil {
+		return f(h)
+	}
+
+	h.txLock.Lock()
+	defer h.txLock.Unlock()
+
+	tx := *h
+	tx.batch = &batch{}
+	tx.db = &txStore{store: h.db, pending: tx.batch}
+	if err := f(&tx); err != nil {
+		return err
+	}
+	if err := h.transcoder.flush(&tx, h.db); err != nil {
+		return err
+	}
+	if len(tx.batch.ops) == 0 {
+		return nil
+	}
+	err := h.db.write(tx.batch)
+	h.caches.invalidate(tx.batch)
+	return err
+}
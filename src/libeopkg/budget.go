@@ -0,0 +1,134 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"runtime"
+	"sync"
+)
+
+// xzPresetMemory is roughly how many MiB xz needs to compress on a single
+// thread with each preset, as listed in the xz manual
+var xzPresetMemory = []int64{3, 9, 17, 32, 48, 94, 94, 186, 370, 674}
+
+// xzBudget is shared by every xz compression, so that parallel deltas and
+// indexes can't exhaust the memory of the host between them
+var xzBudget = &memoryBudget{}
+
+// memoryBudget hands out bytes of memory in the order they were asked for,
+// holding back callers until enough has been given back
+type memoryBudget struct {
+	mut     sync.Mutex
+	limit   int64 // 0 for no limit
+	used    int64
+	waiters []*budgetWaiter
+}
+
+// budgetWaiter is a caller queued until the budget has room for it
+type budgetWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+// acquire will block until n bytes are available, returning how many were
+// actually taken, to be handed to release. Asking for more than the whole
+// budget takes all of it, so the caller runs alone rather than never.
+func (b *memoryBudget) acquire(n int64) int64 {
+	b.mut.Lock()
+	if b.limit <= 0 {
+		b.mut.Unlock()
+		return 0
+	}
+	if n > b.limit {
+		n = b.limit
+	}
+	if len(b.waiters) == 0 && b.used+n <= b.limit {
+		b.used += n
+		b.mut.Unlock()
+		return n
+	}
+	w := &budgetWaiter{n: n, ready: make(chan struct{})}
+	b.waiters = append(b.waiters, w)
+	b.mut.Unlock()
+
+	<-w.ready
+	return n
+}
+
+// release will give back bytes taken by acquire
+func (b *memoryBudget) release(n int64) {
+	if n == 0 {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.used -= n
+	b.wake()
+}
+
+// setLimit will change the size of the budget, where 0 removes the limit
+func (b *memoryBudget) setLimit(limit int64) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.limit = limit
+	b.wake()
+}
+
+// wake will let queued callers through in order while there's room. The
+// lock must be held.
+func (b *memoryBudget) wake() {
+	for len(b.waiters) > 0 {
+		w := b.waiters[0]
+		if b.limit > 0 && b.used > 0 && b.used+w.n > b.limit {
+			return
+		}
+		b.used += w.n
+		b.waiters = b.waiters[1:]
+		close(w.ready)
+	}
+}
+
+// usage returns the bytes in use, the limit and how many callers are queued
+func (b *memoryBudget) usage() (int64, int64, int) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.used, b.limit, len(b.waiters)
+}
+
+// SetXzMemoryBudget will limit how many MiB all xz compressions running at
+// once may use between them. Compressions beyond the budget wait their turn.
+// A budget of 0 removes the limit.
+func SetXzMemoryBudget(mib int) {
+	if mib < 0 {
+		mib = 0
+	}
+	xzBudget.setLimit(int64(mib) << 20)
+}
+
+// xzCompressMemory estimates the bytes needed to compress with the preset
+// on the given number of threads. Each thread beyond the first also buffers
+// its input and output blocks, which are three times the dictionary size.
+func xzCompressMemory(level, threads int) int64 {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	mem := xzPresetMemory[level] << 20
+	if threads > 1 {
+		mem = int64(threads) * (mem + 6*int64(xzDictCaps[level]))
+	}
+	return mem
+}
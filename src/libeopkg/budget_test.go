@@ -0,0 +1,82 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"testing"
+	"time"
+)
+
+// waitForQueue will wait until the budget has the given number of waiters
+func waitForQueue(t *testing.T, b *memoryBudget, waiting int) {
+	for i := 0; i < 1000; i++ {
+		if _, _, n := b.usage(); n == waiting {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d callers to be queued", waiting)
+}
+
+func TestMemoryBudget(t *testing.T) {
+	b := &memoryBudget{}
+
+	// No limit takes nothing
+	if n := b.acquire(100); n != 0 {
+		t.Fatalf("Expected nothing taken without a limit, got %d", n)
+	}
+
+	b.setLimit(100)
+	first := b.acquire(60)
+	if first != 60 {
+		t.Fatalf("Expected 60 taken, got %d", first)
+	}
+
+	// Callers beyond the budget queue in order, even when a later one fits
+	order := make(chan int64, 2)
+	go func() { order <- b.acquire(50) }()
+	waitForQueue(t, b, 1)
+	go func() { order <- b.acquire(500) }()
+	waitForQueue(t, b, 2)
+	if used, _, _ := b.usage(); used != 60 {
+		t.Fatalf("Expected 60 in use while queued, got %d", used)
+	}
+
+	b.release(first)
+	if n := <-order; n != 50 {
+		t.Fatalf("Expected the first caller through first, got %d", n)
+	}
+	b.release(50)
+
+	// Asking for more than the whole budget runs alone
+	if n := <-order; n != 100 {
+		t.Fatalf("Expected the whole budget to be taken, got %d", n)
+	}
+	b.release(100)
+	if used, _, waiting := b.usage(); used != 0 || waiting != 0 {
+		t.Fatalf("Expected the budget to be empty, got %d used and %d waiting", used, waiting)
+	}
+}
+
+func TestXzCompressMemory(t *testing.T) {
+	if got := xzCompressMemory(6, 1); got != 94<<20 {
+		t.Fatalf("Expected 94MiB for -6 on one thread, got %d", got)
+	}
+	if got := xzCompressMemory(6, 2); got <= 2*xzCompressMemory(6, 1) {
+		t.Fatalf("Threads should need more than the single thread memory each, got %d", got)
+	}
+}
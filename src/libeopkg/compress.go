@@ -0,0 +1,347 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A Compressor is able to compress and decompress files in place, in the
+// same fashion as the xz tool. Compressing "foo" leaves "foo" + Suffix()
+// behind, and decompressing that file gives back "foo".
+type Compressor interface {
+
+	// Name is used to select the compressor in the configuration
+	Name() string
+
+	// Suffix is appended to the name of compressed files, i.e. ".xz"
+	Suffix() string
+
+	// CompressFile will compress the input file in place
+	CompressFile(inputPath string, keepOriginal bool) error
+
+	// DecompressFile will decompress the input file in place
+	DecompressFile(inputPath string, keepOriginal bool) error
+}
+
+var (
+	// ErrUnknownCompressor is returned when asking for a compressor by a
+	// name that hasn't been registered
+	ErrUnknownCompressor = errors.New("Unknown compressor")
+
+	// compressors are all of the known compressors by name
+	compressors = make(map[string]Compressor)
+
+	// compressorMut protects the registry and the selected compressors
+	compressorMut sync.RWMutex
+
+	// xzCompressor produces the install.tar.xz and index files, and must
+	// produce real xz files as eopkg has to be able to read them
+	xzCompressor Compressor
+
+	// internalCompressor is used for artifacts only ferryd itself reads
+	internalCompressor Compressor
+)
+
+const (
+	// DefaultXzCompressor uses the host xz tool
+	DefaultXzCompressor = "xz"
+
+	// DefaultInternalCompressor doesn't need any host tools
+	DefaultInternalCompressor = "gzip"
+)
+
+func init() {
+	RegisterCompressor(&execCompressor{
+		name:       "xz",
+		suffix:     ".xz",
+		compress:   xzCompressArgs,
+		decompress: []string{"unxz", "-T", "2"},
+	})
+	RegisterCompressor(&execCompressor{
+		name:       "pigz",
+		suffix:     ".gz",
+		compress:   func() []string { return []string{"pigz"} },
+		decompress: []string{"pigz", "-d"},
+	})
+	RegisterCompressor(&execCompressor{
+		name:          "zstd",
+		suffix:        ".zst",
+		compress:      func() []string { return []string{"zstd", "-q", "-T0"} },
+		decompress:    []string{"zstd", "-q", "-d"},
+		keepByDefault: true,
+	})
+	RegisterCompressor(&goXzCompressor{})
+	RegisterCompressor(&goGzipCompressor{})
+
+	xzCompressor = usableXzCompressor(compressors[DefaultXzCompressor])
+	internalCompressor = compressors[DefaultInternalCompressor]
+}
+
+// RegisterCompressor will make the compressor available for selection,
+// replacing any existing compressor with the same name
+func RegisterCompressor(c Compressor) {
+	compressorMut.Lock()
+	defer compressorMut.Unlock()
+	compressors[c.Name()] = c
+}
+
+// GetCompressor will return the compressor registered with the given name
+func GetCompressor(name string) (Compressor, error) {
+	compressorMut.RLock()
+	defer compressorMut.RUnlock()
+	c, ok := compressors[name]
+	if !ok {
+		return nil, fmt.Errorf("%v: %s", ErrUnknownCompressor, name)
+	}
+	return c, nil
+}
+
+// CompressorNames will return the names of all known compressors
+func CompressorNames() []string {
+	compressorMut.RLock()
+	defer compressorMut.RUnlock()
+	var ret []string
+	for name := range compressors {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SetXzCompressor will select the compressor used by XzFile and UnxzFile.
+// Only compressors producing xz files may be used. Should the host not have
+// the tool it runs, the pure Go implementation is selected in its place.
+func SetXzCompressor(name string) error {
+	c, err := GetCompressor(name)
+	if err != nil {
+		return err
+	}
+	if c.Suffix() != ".xz" {
+		return fmt.Errorf("Compressor %s does not produce xz files", name)
+	}
+	compressorMut.Lock()
+	defer compressorMut.Unlock()
+	xzCompressor = usableXzCompressor(c)
+	return nil
+}
+
+// usableXzCompressor will return the pure Go xz implementation in place of
+// a compressor whose tool isn't installed
+func usableXzCompressor(c Compressor) Compressor {
+	if ext, ok := c.(*execCompressor); ok {
+		if _, err := ext.lookPath(); err != nil {
+			return compressors["go-xz"]
+		}
+	}
+	return c
+}
+
+// SetInternalCompressor will select the compressor returned by
+// InternalCompressor
+func SetInternalCompressor(name string) error {
+	c, err := GetCompressor(name)
+	if err != nil {
+		return err
+	}
+	compressorMut.Lock()
+	defer compressorMut.Unlock()
+	internalCompressor = c
+	return nil
+}
+
+// InternalCompressor returns the compressor to use for files which will only
+// ever be read by ferryd itself, where the format doesn't matter
+func InternalCompressor() Compressor {
+	compressorMut.RLock()
+	defer compressorMut.RUnlock()
+	return internalCompressor
+}
+
+// XzCompressor returns the compressor used by XzFile and UnxzFile, which is
+// only the one selected if its tool is installed
+func XzCompressor() Compressor {
+	compressorMut.RLock()
+	defer compressorMut.RUnlock()
+	return xzCompressor
+}
+
+// xzCompressArgs returns the xz command line for the current options
+func xzCompressArgs() []string {
+	level, threads := xzOptions()
+	return []string{
+		"xz",
+		fmt.Sprintf("-%d", level),
+		"-T", fmt.Sprintf("%d", threads),
+	}
+}
+
+// execCompressor runs an external tool which behaves like xz, i.e. it
+// accepts -k to keep the input file around
+type execCompressor struct {
+	name          string
+	suffix        string
+	compress      func() []string // Evaluated each time, options may change
+	decompress    []string
+	keepByDefault bool // Pass --rm to get rid of the input file
+}
+
+func (e *execCompressor) Name() string   { return e.name }
+func (e *execCompressor) Suffix() string { return e.suffix }
+
+// lookPath will ensure the tool is actually installed
+func (e *execCompressor) lookPath() (string, error) {
+	return exec.LookPath(e.compress()[0])
+}
+
+// run will execute the command against the input file
+func (e *execCompressor) run(cmd []string, inputPath string, keepOriginal bool) error {
+	cmd = append(cmd, inputPath)
+	if keepOriginal && !e.keepByDefault {
+		cmd = append(cmd, "-k")
+	} else if !keepOriginal && e.keepByDefault {
+		cmd = append(cmd, "--rm")
+	}
+	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+func (e *execCompressor) CompressFile(inputPath string, keepOriginal bool) error {
+	return e.run(e.compress(), inputPath, keepOriginal)
+}
+
+func (e *execCompressor) DecompressFile(inputPath string, keepOriginal bool) error {
+	return e.run(append([]string{}, e.decompress...), inputPath, keepOriginal)
+}
+
+// goXzCompressor is a pure Go xz implementation, for hosts without xz. It
+// is considerably slower than the real thing.
+type goXzCompressor struct{}
+
+func (g *goXzCompressor) Name() string   { return "go-xz" }
+func (g *goXzCompressor) Suffix() string { return ".xz" }
+
+// xzDictCaps are the dictionary sizes used by each xz preset
+var xzDictCaps = []int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20,
+	8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+func (g *goXzCompressor) CompressFile(inputPath string, keepOriginal bool) error {
+	level, _ := xzOptions()
+	config := xz.WriterConfig{DictCap: xzDictCaps[level]}
+	return transformFile(inputPath, inputPath+g.Suffix(), keepOriginal, func(w io.Writer, r io.Reader) error {
+		xw, err := config.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(xw, r); err != nil {
+			xw.Close()
+			return err
+		}
+		return xw.Close()
+	})
+}
+
+func (g *goXzCompressor) DecompressFile(inputPath string, keepOriginal bool) error {
+	return transformFile(inputPath, strings.TrimSuffix(inputPath, g.Suffix()), keepOriginal, func(w io.Writer, r io.Reader) error {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, xr)
+		return err
+	})
+}
+
+// goGzipCompressor uses the standard library gzip implementation
+type goGzipCompressor struct{}
+
+func (g *goGzipCompressor) Name() string   { return "gzip" }
+func (g *goGzipCompressor) Suffix() string { return ".gz" }
+
+func (g *goGzipCompressor) CompressFile(inputPath string, keepOriginal bool) error {
+	return transformFile(inputPath, inputPath+g.Suffix(), keepOriginal, func(w io.Writer, r io.Reader) error {
+		gw := gzip.NewWriter(w)
+		if _, err := io.Copy(gw, r); err != nil {
+			gw.Close()
+			return err
+		}
+		return gw.Close()
+	})
+}
+
+func (g *goGzipCompressor) DecompressFile(inputPath string, keepOriginal bool) error {
+	return transformFile(inputPath, strings.TrimSuffix(inputPath, g.Suffix()), keepOriginal, func(w io.Writer, r io.Reader) error {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		_, err = io.Copy(w, gr)
+		return err
+	})
+}
+
+// transformFile will write the transformed contents of inputPath to
+// outputPath, removing inputPath afterwards unless asked to keep it. The
+// output is removed again on failure.
+func transformFile(inputPath, outputPath string, keepOriginal bool, transform func(w io.Writer, r io.Reader) error) error {
+	if inputPath == outputPath {
+		return fmt.Errorf("Unknown suffix on %s", inputPath)
+	}
+
+	src, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	err = transform(dst, src)
+	if err2 := dst.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return err
+	}
+
+	if keepOriginal {
+		return nil
+	}
+	return os.Remove(inputPath)
+}
@@ -0,0 +1,151 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("ferryd compression test\n"), 4096)
+	inputPath := filepath.Join(dir, "input")
+
+	for _, name := range CompressorNames() {
+		c, err := GetCompressor(name)
+		if err != nil {
+			t.Fatalf("Failed to get compressor %s: %v", name, err)
+		}
+		if ext, ok := c.(*execCompressor); ok {
+			if _, err := ext.lookPath(); err != nil {
+				t.Logf("Skipping %s, tool not installed", name)
+				continue
+			}
+		}
+
+		if err := ioutil.WriteFile(inputPath, data, 00644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+		if err := c.CompressFile(inputPath, false); err != nil {
+			t.Fatalf("%s failed to compress: %v", name, err)
+		}
+		if _, err := os.Stat(inputPath); !os.IsNotExist(err) {
+			t.Fatalf("%s should have removed the input file", name)
+		}
+		if err := c.DecompressFile(inputPath+c.Suffix(), true); err != nil {
+			t.Fatalf("%s failed to decompress: %v", name, err)
+		}
+		if _, err := os.Stat(inputPath + c.Suffix()); err != nil {
+			t.Fatalf("%s should have kept the compressed file: %v", name, err)
+		}
+		got, err := ioutil.ReadFile(inputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("%s did not round trip the data", name)
+		}
+		os.Remove(inputPath)
+		os.Remove(inputPath + c.Suffix())
+	}
+}
+
+func TestSetXzCompressor(t *testing.T) {
+	defer SetXzCompressor(DefaultXzCompressor)
+
+	if err := SetXzCompressor("gzip"); err == nil {
+		t.Fatalf("Should not be able to produce xz files with gzip")
+	}
+	if err := SetXzCompressor("lzip"); err == nil {
+		t.Fatalf("Should not be able to select an unknown compressor")
+	}
+	if err := SetXzCompressor("go-xz"); err != nil {
+		t.Fatalf("Failed to select pure Go xz: %v", err)
+	}
+
+	// Deltas must still be produced without the host xz tool
+	producer, err := NewDeltaProducer("TESTING", deltaOldPkg, deltaNewPkg)
+	if err != nil {
+		t.Fatalf("Failed to create delta producer for existing pkgs: %v", err)
+	}
+	defer producer.Close()
+	path, err := producer.Commit()
+	if err != nil {
+		t.Fatalf("Failed to produce delta with pure Go xz: %v", err)
+	}
+	os.Remove(path)
+}
+
+func TestXzCompressorFallback(t *testing.T) {
+	defer SetXzCompressor(DefaultXzCompressor)
+
+	dir, err := ioutil.TempDir("", "compress")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Nothing can be found on an empty PATH
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir)
+
+	if err := SetXzCompressor("xz"); err != nil {
+		t.Fatalf("Failed to select xz: %v", err)
+	}
+	if name := XzCompressor().Name(); name != "go-xz" {
+		t.Fatalf("Expected pure Go xz without the xz tool, got %s", name)
+	}
+
+	data := bytes.Repeat([]byte("ferryd compression test\n"), 4096)
+	inputPath := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(inputPath, data, 00644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	if err := XzFile(inputPath, false); err != nil {
+		t.Fatalf("Failed to compress without the xz tool: %v", err)
+	}
+	if err := UnxzFile(inputPath+".xz", false); err != nil {
+		t.Fatalf("Failed to decompress without the xz tool: %v", err)
+	}
+	got, err := ioutil.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Pure Go xz did not round trip the data")
+	}
+
+	// The tool is used again once it's installed
+	os.Setenv("PATH", path)
+	if err := SetXzCompressor("xz"); err != nil {
+		t.Fatalf("Failed to select xz: %v", err)
+	}
+	if _, err := exec.LookPath("xz"); err == nil && XzCompressor().Name() != "xz" {
+		t.Fatalf("Expected the xz tool once installed, got %s", XzCompressor().Name())
+	}
+}
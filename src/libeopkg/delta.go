@@ -18,7 +18,6 @@ package libeopkg
 
 import (
 	"archive/tar"
-	"archive/zip"
 	"errors"
 	"fmt"
 	"io"
@@ -34,8 +33,38 @@ type DeltaProducer struct {
 	new     *Package
 	baseDir string
 	diffMap map[string]int
+
+	progressFunc DeltaProgressFunc // Optional
+	progress     DeltaProgress
+	cancel       <-chan struct{} // Optional
+}
+
+// DeltaProgress describes how far along the production of a delta is
+type DeltaProgress struct {
+	Stage      string // One of the DeltaStage constants
+	Files      int    // Files copied into the delta so far
+	TotalFiles int    // Files which will be copied into the delta
+	Bytes      int64  // Bytes copied into the delta so far
+	TotalBytes int64  // Bytes which will be copied into the delta
 }
 
+// A DeltaProgressFunc is called periodically while a delta is produced
+type DeltaProgressFunc func(p DeltaProgress)
+
+const (
+	// DeltaStageExtract is reported while the new install.tar is extracted
+	DeltaStageExtract = "extract"
+
+	// DeltaStageCopy is reported while new files are copied into the delta
+	DeltaStageCopy = "copy"
+
+	// DeltaStageCompress is reported while the delta install.tar is compressed
+	DeltaStageCompress = "compress"
+
+	// DeltaStageAssemble is reported while the final .delta.eopkg is written
+	DeltaStageAssemble = "assemble"
+)
+
 var (
 	// ErrMismatchedDelta is returned when the input packages should never be delta'd,
 	// i.e. they're unrelated
@@ -44,6 +73,10 @@ var (
 	// ErrDeltaPointless is returned when it is quite literally pointless to bother making
 	// a delta package, due to the packages having exactly the same content.
 	ErrDeltaPointless = errors.New("File set is the same, no point in creating delta")
+
+	// ErrDeltaCancelled is returned by Commit when it was asked to stop before
+	// the delta was complete
+	ErrDeltaCancelled = errors.New("Delta production was cancelled")
 )
 
 // NewDeltaProducer will return a new delta producer for the given input packages
@@ -98,6 +131,59 @@ func NewDeltaProducer(baseDir string, pkgOld string, pkgNew string) (*DeltaProdu
 	return ret, nil
 }
 
+// SetProgressFunc will set a function to be called periodically during
+// Commit, to report progress
+func (d *DeltaProducer) SetProgressFunc(fn DeltaProgressFunc) {
+	d.progressFunc = fn
+}
+
+// SetCancel will allow Commit to be abandoned by closing the channel. Commit
+// stops at the next file or stage, returning ErrDeltaCancelled.
+func (d *DeltaProducer) SetCancel(cancel <-chan struct{}) {
+	d.cancel = cancel
+}
+
+// cancelled will determine if we've been asked to stop
+func (d *DeltaProducer) cancelled() error {
+	select {
+	case <-d.cancel:
+		return ErrDeltaCancelled
+	default:
+		return nil
+	}
+}
+
+// setStage will report that we've moved on to the next stage, unless we've
+// been asked to stop
+func (d *DeltaProducer) setStage(stage string) error {
+	if err := d.cancelled(); err != nil {
+		return err
+	}
+	d.progress.Stage = stage
+	d.reportProgress()
+	return nil
+}
+
+// reportProgress will pass the current progress to the progress function
+func (d *DeltaProducer) reportProgress() {
+	if d.progressFunc != nil {
+		d.progressFunc(d.progress)
+	}
+}
+
+// progressWriter counts the bytes written through it as copy progress
+type progressWriter struct {
+	w io.Writer
+	d *DeltaProducer
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.d.progress.Bytes += int64(n)
+	p.d.reportProgress()
+	return n, err
+}
+
 // Close the DeltaProducer
 func (d *DeltaProducer) Close() error {
 	if d.old != nil {
@@ -152,8 +238,10 @@ func (d *DeltaProducer) produceInstallBall() (string, error) {
 		}
 		for _, p := range s {
 			d.diffMap[strings.TrimSuffix(p.Path, "/")] = 1
+			d.progress.TotalBytes += p.Size
 		}
 	}
+	d.progress.TotalFiles = len(d.diffMap)
 
 	// All the same files
 	if len(d.diffMap) == len(d.new.Files.File) {
@@ -187,6 +275,9 @@ func (d *DeltaProducer) produceInstallBall() (string, error) {
 	tw.Flush()
 	tw.Close()
 
+	if err = d.setStage(DeltaStageCompress); err != nil {
+		return "", err
+	}
 	if err = XzFile(installTar, false); err != nil {
 		return "", err
 	}
@@ -199,9 +290,15 @@ func (d *DeltaProducer) produceInstallBall() (string, error) {
 func (d *DeltaProducer) copyInstallPartial(tw *tar.Writer) error {
 
 	// Ensure we have tarball ready for use
+	if err := d.setStage(DeltaStageExtract); err != nil {
+		return err
+	}
 	if err := d.new.ExtractTarball(d.baseDir); err != nil {
 		return err
 	}
+	if err := d.setStage(DeltaStageCopy); err != nil {
+		return err
+	}
 
 	inpFile := filepath.Join(d.baseDir, "install.tar")
 	fi, err := os.Open(inpFile)
@@ -228,126 +325,76 @@ func (d *DeltaProducer) copyInstallPartial(tw *tar.Writer) error {
 		if _, ok := d.diffMap[checkName]; !ok {
 			continue
 		}
+		if err = d.cancelled(); err != nil {
+			return err
+		}
 
 		if err = tw.WriteHeader(header); err != nil {
 			return err
 		}
 		if header.Typeflag == tar.TypeReg || header.Typeflag == tar.TypeRegA {
-			if _, err = io.Copy(tw, tarfile); err != nil {
+			if _, err = io.Copy(&progressWriter{tw, d}, tarfile); err != nil {
 				return err
 			}
 		}
+		d.progress.Files++
+		d.reportProgress()
 	}
 	return tw.Flush()
 }
 
 // copyZipPartial will iterate the central zip directory and skip only the
-// install.tar.xz files, whilst copying everything else into the new zip
-func (d *DeltaProducer) copyZipPartial(zw *zip.Writer) error {
+// install.tar.xz files, whilst copying everything else into the new package
+func (d *DeltaProducer) copyZipPartial(pw *PackageWriter) error {
 	for _, zipFile := range d.new.zipFile.File {
 		// Skip any kind of install.tar internally
 		if strings.HasPrefix(zipFile.Name, "install.tar") {
 			continue
 		}
-
-		iop, err := zipFile.Open()
-		if err != nil {
+		if err := pw.CopyFile(zipFile); err != nil {
 			return err
 		}
-		defer iop.Close()
-
-		zwh := &zip.FileHeader{}
-		*zwh = zipFile.FileHeader
-
-		// Copy the File member across (it implements FileHeader)
-		w, err := zw.CreateHeader(zwh)
-		if err != nil {
-			return err
-		}
-		if _, err = io.Copy(w, iop); err != nil {
-			return err
-		}
-		iop.Close() // be really sure we close it..
 	}
 	return nil
 }
 
-func (d *DeltaProducer) pushInstallBall(zipFile *zip.Writer, xzFileName string) error {
-	// No install.tar.xz to write as we have no different files
-	if len(d.diffMap) == 0 {
-		return nil
-	}
-	f, err := os.Open(xzFileName)
-	if err != nil {
-		return err
-	}
-	// Stat for header
-	fst, err := f.Stat()
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	fh, err := zip.FileInfoHeader(fst)
-	if err != nil {
-		return err
-	}
-
-	// Ensure it's always the right name.
-	fh.Name = "install.tar.xz"
-
-	w, err := zipFile.CreateHeader(fh)
-	if err != nil {
-		return err
-	}
-
-	if _, err = io.Copy(w, f); err != nil {
-		return err
-	}
-
-	return zipFile.Flush()
-}
-
 // Commit will attempt to produce a delta between the 2 eopkg files
 // This will be performed in temporary storage so must then be copied into
 // the final resting location, and unlinked, before it can be used.
 func (d *DeltaProducer) Commit() (string, error) {
 	xzFileName, err := d.produceInstallBall()
-	var zipFileName string
 	defer func() {
 		if xzFileName != "" {
 			os.Remove(xzFileName)
 		}
-		// If we're successful, we don't delete this
-		if zipFileName != "" {
-			os.Remove(zipFileName)
-		}
 	}()
 	if err != nil {
 		return "", err
 	}
-	fpath := filepath.Join(d.baseDir, ComputeDeltaName(&d.old.Meta.Package, &d.new.Meta.Package))
-	out, err := os.Create(fpath)
-	if err != nil {
+	if err = d.setStage(DeltaStageAssemble); err != nil {
 		return "", err
 	}
-	zipFileName = out.Name()
-	zip := zip.NewWriter(out)
-	if err = d.copyZipPartial(zip); err != nil {
+	fpath := filepath.Join(d.baseDir, ComputeDeltaName(&d.old.Meta.Package, &d.new.Meta.Package))
+	pw, err := NewPackageWriter(fpath)
+	if err != nil {
 		return "", err
 	}
+	// If we're successful, this does nothing
+	defer pw.Close()
 
-	// Now copy our install.tar.xz into the mix
-	if err = d.pushInstallBall(zip, xzFileName); err != nil {
+	if err = d.copyZipPartial(pw); err != nil {
 		return "", err
 	}
 
-	ret := "" + zipFileName
+	// Now copy our install.tar.xz into the mix, unless we have no different files
+	if len(d.diffMap) > 0 {
+		if err = pw.WriteInstallTarball(xzFileName); err != nil {
+			return "", err
+		}
+	}
 
-	if err := zip.Close(); err != nil {
+	if err = pw.Commit(); err != nil {
 		return "", err
 	}
-
-	zipFileName = ""
-	return ret, nil
+	return fpath, nil
 }
@@ -17,7 +17,9 @@
 package libeopkg
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -55,3 +57,143 @@ func TestBasicDelta(t *testing.T) {
 	}
 
 }
+
+func TestDeltaProgress(t *testing.T) {
+	producer, err := NewDeltaProducer("TESTING", deltaOldPkg, deltaNewPkg)
+	if err != nil {
+		t.Fatalf("Failed to create delta producer for existing pkgs: %v", err)
+	}
+	defer producer.Close()
+
+	var stages []string
+	var last DeltaProgress
+	producer.SetProgressFunc(func(p DeltaProgress) {
+		if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+			stages = append(stages, p.Stage)
+		}
+		last = p
+	})
+
+	path, err := producer.Commit()
+	if err != nil {
+		t.Fatalf("Failed to produce delta packages: %v", err)
+	}
+	defer os.Remove(path)
+
+	expected := []string{DeltaStageExtract, DeltaStageCopy, DeltaStageCompress, DeltaStageAssemble}
+	if len(stages) != len(expected) {
+		t.Fatalf("Expected stages %v, got %v", expected, stages)
+	}
+	for i := range expected {
+		if stages[i] != expected[i] {
+			t.Fatalf("Expected stages %v, got %v", expected, stages)
+		}
+	}
+	if last.Files != last.TotalFiles || last.TotalFiles == 0 {
+		t.Fatalf("Expected all %d files to be copied, got %d", last.TotalFiles, last.Files)
+	}
+	if last.Bytes != last.TotalBytes || last.TotalBytes == 0 {
+		t.Fatalf("Expected all %d bytes to be copied, got %d", last.TotalBytes, last.Bytes)
+	}
+}
+
+func TestDeltaCancel(t *testing.T) {
+	producer, err := NewDeltaProducer("TESTING", deltaOldPkg, deltaNewPkg)
+	if err != nil {
+		t.Fatalf("Failed to create delta producer for existing pkgs: %v", err)
+	}
+	defer producer.Close()
+
+	// Stop as soon as the first file has been copied
+	cancel := make(chan struct{})
+	producer.SetCancel(cancel)
+	var stages []string
+	producer.SetProgressFunc(func(p DeltaProgress) {
+		if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+			stages = append(stages, p.Stage)
+		}
+		if p.Files == 1 && p.Stage == DeltaStageCopy {
+			select {
+			case <-cancel:
+			default:
+				close(cancel)
+			}
+		}
+	})
+
+	path, err := producer.Commit()
+	if err != ErrDeltaCancelled {
+		if path != "" {
+			os.Remove(path)
+		}
+		t.Fatalf("Expected the delta to be cancelled, got: %v", err)
+	}
+	for _, stage := range stages {
+		if stage == DeltaStageCompress || stage == DeltaStageAssemble {
+			t.Fatalf("Cancelled delta reached the %s stage", stage)
+		}
+	}
+}
+
+func TestVerifyDelta(t *testing.T) {
+	producer, err := NewDeltaProducer("TESTING", deltaOldPkg, deltaNewPkg)
+	if err != nil {
+		t.Fatalf("Failed to create delta producer for existing pkgs: %v", err)
+	}
+	defer producer.Close()
+	path, err := producer.Commit()
+	if err != nil {
+		t.Fatalf("Failed to produce delta packages: %v", err)
+	}
+	defer os.Remove(path)
+
+	open := func(path string) *Package {
+		pkg, err := Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", path, err)
+		}
+		return pkg
+	}
+	delta, old, tip := open(path), open(deltaOldPkg), open(deltaNewPkg)
+	defer delta.Close()
+	defer old.Close()
+	defer tip.Close()
+
+	if err = delta.VerifyDelta(old, tip); err != nil {
+		t.Fatalf("Valid delta failed verification: %v", err)
+	}
+	if err = delta.VerifyDelta(tip, old); err != ErrMismatchedDelta {
+		t.Fatalf("Expected reversed packages to be refused, got: %v", err)
+	}
+	other := open(eopkgTestFile)
+	defer other.Close()
+	if _, ok := delta.VerifyDelta(other, tip).(*VerifyError); !ok {
+		t.Fatalf("Delta from another release should fail verification")
+	}
+
+	// A delta without its files can't produce the target
+	dir, err := ioutil.TempDir("", "verify-delta")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pw, err := NewPackageWriter(filepath.Join(dir, delta.ID))
+	if err != nil {
+		t.Fatalf("Failed to create package writer: %v", err)
+	}
+	defer pw.Close()
+	if err = pw.WriteMetadata(delta.Meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err = pw.WriteFiles(delta.Files); err != nil {
+		t.Fatalf("Failed to write files: %v", err)
+	}
+	if err = pw.Commit(); err != nil {
+		t.Fatalf("Failed to commit package: %v", err)
+	}
+	broken := open(filepath.Join(dir, delta.ID))
+	defer broken.Close()
+	if _, ok := broken.VerifyDelta(old, tip).(*VerifyError); !ok {
+		t.Fatalf("Delta missing its files should fail verification")
+	}
+}
@@ -0,0 +1,47 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"encoding/xml"
+	"os"
+)
+
+// An Index is the eopkg-index.xml describing a binary repository, containing
+// the metadata of each published package along with the repository assets.
+type Index struct {
+	XMLName      xml.Name      `xml:"PISI"`
+	Distribution *Distribution `xml:"Distribution,omitempty"`
+	Packages     []MetaPackage `xml:"Package"`
+	Components   []Component   `xml:"Component"`
+	Groups       []Group       `xml:"Group"`
+}
+
+// NewIndex will load the Index data from the XML file
+func NewIndex(xmlfile string) (*Index, error) {
+	fi, err := os.Open(xmlfile)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+	index := &Index{}
+	dec := xml.NewDecoder(fi)
+	if err = dec.Decode(index); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
@@ -136,9 +136,9 @@ type MetaPackage struct {
 	DistributionRelease string // Name/ID if this distro release, i.e. "1"
 	Architecture        string // i.e. x86_64
 	InstalledSize       int64  // How much disk space this package takes up
-	PackageSize         int64  // Actual size on disk of the .eopkg
-	PackageHash         string // Sha1sum for this package
-	PackageURI          string // Relative location to the package
+	PackageSize         int64  `xml:",omitempty"` // Actual size on disk of the .eopkg
+	PackageHash         string `xml:",omitempty"` // Sha1sum for this package
+	PackageURI          string `xml:",omitempty"` // Relative location to the package
 
 	// DeltaPackages are only emitted in the index itself
 	DeltaPackages *[]Delta `xml:"DeltaPackages>Delta,omitempty"`
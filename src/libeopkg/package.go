@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 //
@@ -90,6 +91,22 @@ func (p *Package) FindFile(path string) *zip.File {
 	return nil
 }
 
+// FileHeaders will return the headers for every file stored within the
+// archive, in their original order.
+func (p *Package) FileHeaders() []*zip.File {
+	return p.zipFile.File
+}
+
+// BuildTime will return when the package was built, as recorded by the
+// timestamp of metadata.xml within the archive
+func (p *Package) BuildTime() (time.Time, error) {
+	f := p.FindFile("metadata.xml")
+	if f == nil {
+		return time.Time{}, ErrEopkgCorrupted
+	}
+	return f.Modified, nil
+}
+
 // ReadMetadata will read the `metadata.xml` file within the archive and
 // deserialize it into something accessible within the .eopkg container.
 func (p *Package) ReadMetadata() error {
@@ -18,7 +18,9 @@ package libeopkg
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -95,3 +97,131 @@ func TestPackageFiles(t *testing.T) {
 		t.Fatalf("Invalid file mode on nano: %s", wanted.FileMode().String())
 	}
 }
+
+func TestPackageWriter(t *testing.T) {
+	pkg, err := Open(eopkgTestFile)
+	if err != nil {
+		t.Fatalf("Error opening valid .eopkg file: %v", err)
+	}
+	defer pkg.Close()
+	if err = pkg.ReadAll(); err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "writer")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	outPath := filepath.Join(dir, "nano-2.7.1-63-1-x86_64.eopkg")
+
+	pw, err := NewPackageWriter(outPath)
+	if err != nil {
+		t.Fatalf("Failed to create package writer: %v", err)
+	}
+	defer pw.Close()
+
+	pkg.Meta.Package.Summary[0].Value = "Rewritten summary"
+	if err = pw.WriteMetadata(pkg.Meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err = pw.WriteFiles(pkg.Files); err != nil {
+		t.Fatalf("Failed to write files: %v", err)
+	}
+	if err = pw.CopyFile(pkg.FindFile("install.tar.xz")); err != nil {
+		t.Fatalf("Failed to copy install.tar.xz: %v", err)
+	}
+	if err = pw.Commit(); err != nil {
+		t.Fatalf("Failed to commit package: %v", err)
+	}
+	if err = pw.WriteFiles(pkg.Files); err != ErrWriterFinished {
+		t.Fatalf("Should not be able to write after commit: %v", err)
+	}
+
+	out, err := Open(outPath)
+	if err != nil {
+		t.Fatalf("Failed to open written package: %v", err)
+	}
+	defer out.Close()
+	if err = out.ReadAll(); err != nil {
+		t.Fatalf("Failed to read written package: %v", err)
+	}
+	if out.Meta.Package.Name != "nano" || out.Meta.Package.GetRelease() != 63 {
+		t.Fatalf("Wrong package written: %s-%d", out.Meta.Package.Name, out.Meta.Package.GetRelease())
+	}
+	if out.Meta.Package.Summary[0].Value != "Rewritten summary" {
+		t.Fatalf("Summary was not rewritten: %s", out.Meta.Package.Summary[0].Value)
+	}
+	if out.Meta.Package.Summary[0].Lang != "en" {
+		t.Fatalf("Summary language was lost: %s", out.Meta.Package.Summary[0].Lang)
+	}
+	if len(out.Files.File) != len(pkg.Files.File) {
+		t.Fatalf("Expected %d files, got %d", len(pkg.Files.File), len(out.Files.File))
+	}
+	if err = out.ExtractTarball(dir); err != nil {
+		t.Fatalf("Failed to extract install.tar.xz: %v", err)
+	}
+}
+
+func TestVerifyFiles(t *testing.T) {
+	pkg, err := Open(eopkgTestFile)
+	if err != nil {
+		t.Fatalf("Error opening valid .eopkg file: %v", err)
+	}
+	defer pkg.Close()
+	if err = pkg.VerifyFiles(); err != nil {
+		t.Fatalf("Valid package failed verification: %v", err)
+	}
+	if err = pkg.ReadMetadata(); err != nil {
+		t.Fatalf("Failed to read package: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	outPath := filepath.Join(dir, "nano-2.7.1-63-1-x86_64.eopkg")
+
+	// Record a bogus hash for the first real file
+	var corrupted *File
+	for _, f := range pkg.Files.File {
+		if !f.IsDir() {
+			corrupted = f
+			break
+		}
+	}
+	corrupted.Hash = "da39a3ee5e6b4b0d3255bfef95601890afd80709"
+
+	pw, err := NewPackageWriter(outPath)
+	if err != nil {
+		t.Fatalf("Failed to create package writer: %v", err)
+	}
+	defer pw.Close()
+	if err = pw.WriteMetadata(pkg.Meta); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err = pw.WriteFiles(pkg.Files); err != nil {
+		t.Fatalf("Failed to write files: %v", err)
+	}
+	if err = pw.CopyFile(pkg.FindFile("install.tar.xz")); err != nil {
+		t.Fatalf("Failed to copy install.tar.xz: %v", err)
+	}
+	if err = pw.Commit(); err != nil {
+		t.Fatalf("Failed to commit package: %v", err)
+	}
+
+	out, err := Open(outPath)
+	if err != nil {
+		t.Fatalf("Failed to open written package: %v", err)
+	}
+	defer out.Close()
+	err = out.VerifyFiles()
+	verr, ok := err.(*VerifyError)
+	if !ok {
+		t.Fatalf("Corrupted package should fail verification, got: %v", err)
+	}
+	if verr.Path != corrupted.Path {
+		t.Fatalf("Wrong file reported as corrupt: %s", verr.Path)
+	}
+}
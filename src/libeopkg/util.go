@@ -18,8 +18,18 @@ package libeopkg
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
+	"sync"
+)
+
+var (
+	// xzLevel is the compression preset used for xz
+	xzLevel = 6
+
+	// xzThreads is the number of threads the xz tool is allowed to use
+	xzThreads = 2
+
+	// xzMut protects the xz settings which may be changed at runtime
+	xzMut sync.RWMutex
 )
 
 // DISCLAIMER: This stuff is just supporting the existing eopkg stuff.
@@ -49,37 +59,45 @@ func IsDeltaPossible(oldPackage, newPackage *MetaPackage) bool {
 		oldPackage.Architecture == newPackage.Architecture
 }
 
-// XzFile is a simple wrapper around the xz utility to compress the input
-// file. This will be performed in place and leave a ".xz" suffixed file in
-// place
+// SetXzOptions will change the compression preset and thread count used
+// for all future calls to XzFile. Invalid values are ignored.
+func SetXzOptions(level, threads int) {
+	xzMut.Lock()
+	defer xzMut.Unlock()
+	if level >= 0 && level <= 9 {
+		xzLevel = level
+	}
+	if threads >= 0 {
+		xzThreads = threads
+	}
+}
+
+// xzOptions returns the current xz level and thread count
+func xzOptions() (int, int) {
+	xzMut.RLock()
+	defer xzMut.RUnlock()
+	return xzLevel, xzThreads
+}
+
+// XzFile will compress the input file with the selected xz compressor. This
+// will be performed in place and leave a ".xz" suffixed file in place
 // Keep original determines whether we'll keep the original file
+//
+// The compression waits until the memory it needs fits in the xz memory
+// budget, if one is set.
 func XzFile(inputPath string, keepOriginal bool) error {
-	cmd := []string{
-		"xz",
-		"-6",
-		"-T", "2",
-		inputPath,
+	c := XzCompressor()
+	level, threads := xzOptions()
+	if _, ok := c.(*goXzCompressor); ok {
+		threads = 1
 	}
-	if keepOriginal {
-		cmd = append(cmd, "-k")
-	}
-	c := exec.Command(cmd[0], cmd[1:]...)
-	c.Stderr = os.Stderr
-	return c.Run()
+	n := xzBudget.acquire(xzCompressMemory(level, threads))
+	defer xzBudget.release(n)
+	return c.CompressFile(inputPath, keepOriginal)
 }
 
 // UnxzFile will decompress the input XZ file and leave a new file in place
 // without the .xz suffix
 func UnxzFile(inputPath string, keepOriginal bool) error {
-	cmd := []string{
-		"unxz",
-		"-T", "2",
-		inputPath,
-	}
-	if keepOriginal {
-		cmd = append(cmd, "-k")
-	}
-	c := exec.Command(cmd[0], cmd[1:]...)
-	c.Stderr = os.Stderr
-	return c.Run()
+	return XzCompressor().DecompressFile(inputPath, keepOriginal)
 }
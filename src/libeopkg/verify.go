@@ -0,0 +1,212 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/ulikunitz/xz"
+	"io"
+	"strings"
+)
+
+// A VerifyError is returned when the contents of install.tar.xz do not agree
+// with the record in files.xml
+type VerifyError struct {
+	Path   string // Path of the offending file
+	Reason string // What went wrong
+}
+
+// Error will return a human readable description of the failure
+func (v *VerifyError) Error() string {
+	return fmt.Sprintf("%s: %s", v.Path, v.Reason)
+}
+
+// VerifyFiles will stream the install.tar.xz and ensure that every file
+// recorded in files.xml is present with the correct hash, catching corrupted
+// packages before they're distributed. This is relatively expensive as the
+// entire tarball must be decompressed.
+//
+// As with eopkg, the hash of a symlink is the hash of its target path.
+func (p *Package) VerifyFiles() error {
+	if err := p.ReadFiles(); err != nil {
+		return err
+	}
+
+	seen, err := p.tarballHashes()
+	if err != nil {
+		return err
+	}
+
+	// Everything expecting a hash must be found exactly once
+	expected := make(map[string]string)
+	for _, f := range p.Files.File {
+		if f.IsDir() {
+			continue
+		}
+		expected[f.Path] = f.Hash
+	}
+
+	for path, hash := range expected {
+		sum, ok := seen[path]
+		if !ok {
+			return &VerifyError{path, "missing from install.tar.xz"}
+		}
+		if sum != hash {
+			return &VerifyError{path, fmt.Sprintf("hash mismatch (expected %s, got %s)", hash, sum)}
+		}
+	}
+	return nil
+}
+
+// tarballHashes will stream the install.tar.xz, returning the hash of every
+// file within it by path. Directories aren't included.
+func (p *Package) tarballHashes() (map[string]string, error) {
+	tarball := p.FindFile("install.tar.xz")
+	if tarball == nil {
+		return nil, ErrEopkgCorrupted
+	}
+
+	fi, err := tarball.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Close()
+
+	xzReader, err := xz.NewReader(fi)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]string)
+	tarReader := tar.NewReader(xzReader)
+	h := sha1.New()
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		path := strings.TrimPrefix(strings.TrimPrefix(header.Name, "./"), "/")
+		h.Reset()
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			if _, err = io.Copy(h, tarReader); err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			io.WriteString(h, header.Linkname)
+		case tar.TypeLink:
+			// Hardlinks share the contents of an earlier member
+			target := strings.TrimPrefix(strings.TrimPrefix(header.Linkname, "./"), "/")
+			sum, ok := seen[target]
+			if !ok {
+				return nil, &VerifyError{path, "hardlink to unknown file " + target}
+			}
+			seen[path] = sum
+			continue
+		default:
+			continue
+		}
+		seen[path] = hex.EncodeToString(h.Sum(nil))
+	}
+	return seen, nil
+}
+
+// VerifyDelta will ensure that p is a delta package which really turns the
+// from package into the to package. The delta must describe the to package,
+// carry its files.xml, and its install.tar.xz must hold every file of to
+// which isn't already in from, with the hashes recorded in files.xml.
+// Like VerifyFiles, this decompresses the entire delta tarball.
+func (p *Package) VerifyDelta(from, to *Package) error {
+	for _, pkg := range []*Package{p, from, to} {
+		if err := pkg.ReadAll(); err != nil {
+			return err
+		}
+	}
+
+	meta, target := &p.Meta.Package, &to.Meta.Package
+	if !IsDeltaPossible(&from.Meta.Package, target) {
+		return ErrMismatchedDelta
+	}
+	if name := ComputeDeltaName(&from.Meta.Package, target); p.ID != name {
+		return &VerifyError{p.ID, "expected delta to be named " + name}
+	}
+	if meta.Name != target.Name || meta.GetRelease() != target.GetRelease() ||
+		meta.GetVersion() != target.GetVersion() || meta.Architecture != target.Architecture {
+		return &VerifyError{"metadata.xml", fmt.Sprintf("describes %s-%s-%d, not %s-%s-%d",
+			meta.Name, meta.GetVersion(), meta.GetRelease(),
+			target.Name, target.GetVersion(), target.GetRelease())}
+	}
+
+	// The delta replaces the files.xml of the old package entirely
+	expected := make(map[string]string)
+	for _, f := range to.Files.File {
+		expected[f.Path] = f.Hash
+	}
+	if len(p.Files.File) != len(expected) {
+		return &VerifyError{"files.xml", "does not match the target package"}
+	}
+	for _, f := range p.Files.File {
+		if hash, ok := expected[f.Path]; !ok || hash != f.Hash {
+			return &VerifyError{f.Path, "files.xml does not match the target package"}
+		}
+	}
+
+	// Only files with content new to the target need to be in the delta
+	oldHashes := make(map[string]bool)
+	for _, f := range from.Files.File {
+		oldHashes[f.Hash] = true
+	}
+	needed := make(map[string]string)
+	for _, f := range to.Files.File {
+		if f.IsDir() || oldHashes[f.Hash] {
+			continue
+		}
+		needed[f.Path] = f.Hash
+	}
+
+	seen := make(map[string]string)
+	if p.FindFile("install.tar.xz") != nil {
+		var err error
+		if seen, err = p.tarballHashes(); err != nil {
+			return err
+		}
+	}
+	for path, sum := range seen {
+		hash, ok := expected[path]
+		if !ok {
+			return &VerifyError{path, "not part of the target package"}
+		}
+		if sum != hash {
+			return &VerifyError{path, fmt.Sprintf("hash mismatch (expected %s, got %s)", hash, sum)}
+		}
+	}
+	for path := range needed {
+		if _, ok := seen[path]; !ok {
+			return &VerifyError{path, "missing from the delta install.tar.xz"}
+		}
+	}
+	return nil
+}
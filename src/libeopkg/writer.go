@@ -0,0 +1,188 @@
+//
+// Copyright © 2016-2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libeopkg
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	// ErrWriterFinished is returned when trying to use a PackageWriter
+	// after it has been committed or closed
+	ErrWriterFinished = errors.New("Package writer has already finished")
+)
+
+// A PackageWriter is used to compose a new .eopkg archive from its parts.
+// The parts are written in the order they're given, which should be the
+// metadata.xml, files.xml, any extra files and finally the install.tar.xz
+// to match the layout produced by eopkg itself.
+//
+// Nothing is considered complete until Commit is called, and a writer that
+// is closed without being committed will remove the partial archive.
+type PackageWriter struct {
+	path     string
+	file     *os.File
+	zip      *zip.Writer
+	modified time.Time // Stamped on the files we write
+}
+
+// NewPackageWriter will create a new .eopkg archive at the given path
+func NewPackageWriter(path string) (*PackageWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PackageWriter{
+		path:     path,
+		file:     f,
+		zip:      zip.NewWriter(f),
+		modified: time.Now().UTC(),
+	}, nil
+}
+
+// create will begin a new compressed file within the archive
+func (w *PackageWriter) create(name string) (io.Writer, error) {
+	if w.zip == nil {
+		return nil, ErrWriterFinished
+	}
+	return w.zip.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: w.modified,
+	})
+}
+
+// writeXML will serialise the value into the named file of the archive
+func (w *PackageWriter) writeXML(name string, start xml.StartElement, v interface{}) error {
+	out, err := w.create(name)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "    ")
+	if err := enc.EncodeElement(v, start); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// WriteMetadata will serialise the package metadata into metadata.xml
+func (w *PackageWriter) WriteMetadata(meta *Metadata) error {
+	return w.writeXML("metadata.xml", xml.StartElement{Name: xml.Name{Local: "PISI"}}, meta)
+}
+
+// WriteFiles will serialise the file records into files.xml
+func (w *PackageWriter) WriteFiles(files *Files) error {
+	return w.writeXML("files.xml", xml.StartElement{Name: xml.Name{Local: "Files"}}, files)
+}
+
+// WriteFile will add a file with the given name to the archive, taking the
+// contents from the reader
+func (w *PackageWriter) WriteFile(name string, r io.Reader) error {
+	out, err := w.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, r)
+	return err
+}
+
+// CopyFile will copy a file from another archive, i.e. an existing
+// package, keeping its name and attributes
+func (w *PackageWriter) CopyFile(f *zip.File) error {
+	if w.zip == nil {
+		return ErrWriterFinished
+	}
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	hdr := f.FileHeader
+	out, err := w.zip.CreateHeader(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// WriteInstallTarball will add the already compressed tarball at the given
+// path as the install.tar.xz of the package
+func (w *PackageWriter) WriteInstallTarball(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := zip.FileInfoHeader(st)
+	if err != nil {
+		return err
+	}
+	// Ensure it's always the right name.
+	hdr.Name = "install.tar.xz"
+
+	if w.zip == nil {
+		return ErrWriterFinished
+	}
+	out, err := w.zip.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, f)
+	return err
+}
+
+// Commit will finish writing the archive, which is then ready for use
+func (w *PackageWriter) Commit() error {
+	if w.zip == nil {
+		return ErrWriterFinished
+	}
+	err := w.zip.Close()
+	if err2 := w.file.Close(); err == nil {
+		err = err2
+	}
+	w.zip = nil
+	w.file = nil
+	if err != nil {
+		os.Remove(w.path)
+	}
+	return err
+}
+
+// Close will abandon the archive if it hasn't been committed, removing the
+// partially written file
+func (w *PackageWriter) Close() error {
+	if w.zip == nil {
+		return nil
+	}
+	w.zip = nil
+	w.file.Close()
+	w.file = nil
+	return os.Remove(w.path)
+}
@@ -0,0 +1,101 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libferry
+
+import (
+	"context"
+	"errors"
+)
+
+// A Batch collects operations to be queued together with a single request,
+// i.e. to provision a repository. The daemon chains the jobs so that each
+// only runs once the one before it has succeeded, and the rest are failed
+// as soon as one of them fails.
+type Batch struct {
+	client *Client
+	ops    []BatchOperation
+}
+
+// Batch will return a new, empty, Batch to be submitted with this client
+func (c *Client) Batch() *Batch {
+	return &Batch{client: c}
+}
+
+// add will append the operation to the batch
+func (b *Batch) add(op BatchOperation) *Batch {
+	b.ops = append(b.ops, op)
+	return b
+}
+
+// CreateRepo will add the creation of a new repository to the batch
+func (b *Batch) CreateRepo(repoID string) *Batch {
+	return b.add(BatchOperation{Op: BatchCreateRepo, Repo: repoID})
+}
+
+// Import will add an import of the packages, given as absolute paths, to
+// the batch
+func (b *Batch) Import(repoID string, pkgs []string) *Batch {
+	return b.add(BatchOperation{Op: BatchImport, Repo: repoID, Paths: pkgs})
+}
+
+// Index will add an index of the repository to the batch
+func (b *Batch) Index(repoID string) *Batch {
+	return b.add(BatchOperation{Op: BatchIndex, Repo: repoID})
+}
+
+// Delta will add the creation of deltas for the repository to the batch.
+// Only the scheduling of the delta jobs is waited for, not the deltas.
+func (b *Batch) Delta(repoID string) *Batch {
+	return b.add(BatchOperation{Op: BatchDelta, Repo: repoID})
+}
+
+// Clone will add a clone of an existing repository to the batch
+func (b *Batch) Clone(repoID, newClone string, copyAll bool) *Batch {
+	return b.add(BatchOperation{Op: BatchClone, Repo: newClone, From: repoID, Full: copyAll})
+}
+
+// Pull will add a pull of one repository into another to the batch
+func (b *Batch) Pull(sourceRepo, targetRepo string) *Batch {
+	return b.add(BatchOperation{Op: BatchPull, Repo: targetRepo, From: sourceRepo})
+}
+
+// Len returns the number of operations in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Submit will queue every operation in the batch, returning the ID of the
+// job for each operation in order. Wait on the last to know when the whole
+// batch is done.
+func (b *Batch) Submit() ([]string, error) {
+	return b.SubmitContext(context.Background())
+}
+
+// SubmitContext is Submit, with the request bound to ctx
+func (b *Batch) SubmitContext(ctx context.Context) ([]string, error) {
+	if len(b.ops) == 0 {
+		return nil, errors.New("the batch is empty")
+	}
+	bq := BatchRequest{
+		Operations: b.ops,
+	}
+	var br BatchResponse
+	if err := b.client.postResponse(ctx, b.client.formURI("api/v1/batch"), &bq, &br); err != nil {
+		return nil, err
+	}
+	return br.JobIDs, nil
+}
@@ -0,0 +1,202 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libferry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorKind categorises why ferryd refused or failed a request, so that
+// clients can handle errors without matching their messages
+type ErrorKind string
+
+const (
+	// ErrorInvalid is sent when the request is malformed or not allowed
+	ErrorInvalid ErrorKind = "invalid"
+
+	// ErrorForbidden is sent when the client isn't allowed to make the request
+	ErrorForbidden ErrorKind = "forbidden"
+
+	// ErrorNotFound is sent when something named by the request doesn't exist
+	ErrorNotFound ErrorKind = "not-found"
+
+	// ErrorConflict is sent when the request clashes with an earlier one
+	ErrorConflict ErrorKind = "conflict"
+
+	// ErrorUnavailable is sent when ferryd can't handle the request right now
+	ErrorUnavailable ErrorKind = "unavailable"
+
+	// ErrorInternal is sent when ferryd failed through no fault of the request
+	ErrorInternal ErrorKind = "internal"
+
+	// ErrorRateLimited is sent when the client is making too many requests
+	ErrorRateLimited ErrorKind = "rate-limited"
+
+	// ErrorReadOnly is sent when a standby is asked to change anything
+	ErrorReadOnly ErrorKind = "read-only"
+)
+
+// StatusCode will return the HTTP status sent with errors of this kind
+func (k ErrorKind) StatusCode() int {
+	switch k {
+	case ErrorForbidden:
+		return http.StatusForbidden
+	case ErrorNotFound:
+		return http.StatusNotFound
+	case ErrorConflict, ErrorReadOnly:
+		return http.StatusConflict
+	case ErrorUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrorInternal:
+		return http.StatusInternalServerError
+	case ErrorRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// errorKindForStatus will guess the kind of error from the HTTP status, for
+// replies which don't say
+func errorKindForStatus(status int) ErrorKind {
+	switch {
+	case status == http.StatusForbidden:
+		return ErrorForbidden
+	case status == http.StatusNotFound:
+		return ErrorNotFound
+	case status == http.StatusConflict:
+		return ErrorConflict
+	case status == http.StatusServiceUnavailable:
+		return ErrorUnavailable
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimited
+	case status >= 500:
+		return ErrorInternal
+	default:
+		return ErrorInvalid
+	}
+}
+
+// An Error is returned by the Client when ferryd refuses or fails a request
+type Error struct {
+	Kind    ErrorKind
+	Status  int    // HTTP status of the reply
+	Message string // Error message from ferryd
+
+	// How long ferryd asked us to wait before trying again, if it did
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// newError will return the Error for a reply from ferryd
+func newError(resp *http.Response, fc *Response) *Error {
+	kind := fc.ErrorKind
+	if kind == "" {
+		kind = errorKindForStatus(resp.StatusCode)
+	}
+	return &Error{
+		Kind:       kind,
+		Status:     resp.StatusCode,
+		Message:    fc.ErrorString,
+		RetryAfter: retryAfter(resp),
+	}
+}
+
+// unexpectedResponse will return the error for a failed reply which couldn't
+// be decoded, i.e. one sent by a proxy rather than ferryd
+func unexpectedResponse(resp *http.Response) error {
+	return &Error{
+		Kind:       errorKindForStatus(resp.StatusCode),
+		Status:     resp.StatusCode,
+		Message:    fmt.Sprintf("unexpected response: %s", resp.Status),
+		RetryAfter: retryAfter(resp),
+	}
+}
+
+// retryAfter will return how long the Retry-After header of the reply asks
+// us to wait, or 0 if there isn't one we understand
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// readError will return the error for a failed reply whose body isn't
+// otherwise decoded
+func readError(resp *http.Response) error {
+	var fc Response
+	if err := json.NewDecoder(resp.Body).Decode(&fc); err != nil || !fc.Error {
+		return unexpectedResponse(resp)
+	}
+	return newError(resp, &fc)
+}
+
+// isKind will determine if err is an Error of the given kind
+func isKind(err error, kind ErrorKind) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == kind
+}
+
+// IsInvalid will determine if ferryd refused the request as invalid
+func IsInvalid(err error) bool {
+	return isKind(err, ErrorInvalid)
+}
+
+// IsForbidden will determine if ferryd refused the request because the
+// client isn't allowed to make it
+func IsForbidden(err error) bool {
+	return isKind(err, ErrorForbidden)
+}
+
+// IsNotFound will determine if ferryd couldn't find something named by the
+// request, i.e. a repository or job
+func IsNotFound(err error) bool {
+	return isKind(err, ErrorNotFound)
+}
+
+// IsConflict will determine if the request clashed with an earlier one
+func IsConflict(err error) bool {
+	return isKind(err, ErrorConflict)
+}
+
+// IsRateLimited will determine if ferryd refused the request because too
+// many are being made, so that it may be tried again after Error.RetryAfter
+func IsRateLimited(err error) bool {
+	return isKind(err, ErrorRateLimited)
+}
+
+// IsReadOnly will determine if ferryd refused the request because it is a
+// standby, which only serves reads until it is promoted
+func IsReadOnly(err error) bool {
+	return isKind(err, ErrorReadOnly)
+}
+
+// IsUnavailable will determine if ferryd couldn't handle the request right
+// now, so that it may be tried again later
+func IsUnavailable(err error) bool {
+	return isKind(err, ErrorUnavailable)
+}
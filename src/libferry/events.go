@@ -0,0 +1,107 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libferry
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrEventStreamClosed is returned by Events when the daemon ends the stream,
+// i.e. as it shuts down
+var ErrEventStreamClosed = errors.New("the event stream was closed by the daemon")
+
+// An EventFunc is passed each event as it arrives. Returning an error stops
+// the stream.
+type EventFunc func(event *Event) error
+
+// Events will follow the daemon's event stream, passing each event to f until
+// it returns an error or the stream fails. Only the named events are sent, or
+// every event if none are given.
+//
+// After reconnecting, pass the ID of the last event seen as lastID to receive
+// any missed events the daemon still remembers. Use 0 to only see new ones.
+func (c *Client) Events(events []string, lastID uint64, f EventFunc) error {
+	return c.EventsContext(context.Background(), events, lastID, f)
+}
+
+// EventsContext is Events, with the stream bound to ctx. The client timeout
+// doesn't apply, so the stream only ends once ctx is done.
+func (c *Client) EventsContext(ctx context.Context, events []string, lastID uint64, f EventFunc) error {
+	uri := c.formURI("api/v1/events")
+	if len(events) > 0 {
+		uri += "?events=" + url.QueryEscape(strings.Join(events, ","))
+	}
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID > 0 {
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(lastID, 10))
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+
+	// Each event is a block of "field: value" lines ended by a blank line.
+	// We only need the data, which the daemon never splits over lines.
+	reader := bufio.NewReader(resp.Body)
+	var data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ErrEventStreamClosed
+			}
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			if data == "" {
+				continue
+			}
+			event := &Event{}
+			if err := json.Unmarshal([]byte(data), event); err != nil {
+				return err
+			}
+			data = ""
+			if err := f(event); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "data:"):
+			data += strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		}
+	}
+}
@@ -22,39 +22,97 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 )
 
 const (
 	// Version of the ferry client library
 	Version = "0.0.1"
+
+	// APIVersion is bumped whenever the API changes in a way that older
+	// clients or daemons can't cope with
+	APIVersion = 1
+
+	// DefaultTimeout is how long a request may take unless its context
+	// already has a deadline
+	DefaultTimeout = 20 * time.Second
+
+	// DefaultRetries is how many times a read is retried when the daemon
+	// can't be reached
+	DefaultRetries = 3
+
+	// retryBackoff is the delay before the first retry, doubling each time
+	retryBackoff = 250 * time.Millisecond
+
+	// waitStatusTimeout is the least time a WaitStatus call is given, as the
+	// daemon will block it for up to 15 seconds
+	waitStatusTimeout = 30 * time.Second
+
+	// jobPollInterval is how often WaitForJob asks after the job
+	jobPollInterval = 500 * time.Millisecond
+
+	// IdempotencyKeyHeader may be set on requests which queue a job, so
+	// that repeating the request returns the job queued the first time
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// MaxIdempotencyKeyLength is the longest idempotency key accepted
+	MaxIdempotencyKeyLength = 255
 )
 
-// A Client is used to communicate with the system ferryd
+// A Client is used to communicate with the system ferryd. Calls which queue
+// a job return its ID, which can be followed with GetJob or WaitForJob.
 type Client struct {
-	client *http.Client
+	client  *http.Client
+	timeout time.Duration
+	retries int
+	key     string // Idempotency key sent with each request
 }
 
 // NewClient will return a new Client for the local unix socket, suitable
 // for communicating with the daemon.
 func NewClient(address string) *Client {
+	dialer := &net.Dialer{}
 	return &Client{
 		client: &http.Client{
 			Transport: &http.Transport{
 				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-					return net.Dial("unix", address)
+					return dialer.DialContext(ctx, "unix", address)
 				},
 				DisableKeepAlives:     false,
 				IdleConnTimeout:       30 * time.Second,
 				ExpectContinueTimeout: 1 * time.Second,
 			},
-			Timeout: 20 * time.Second,
 		},
+		timeout: DefaultTimeout,
+		retries: DefaultRetries,
 	}
 }
 
+// SetTimeout will change how long each request may take when its context
+// has no deadline. A timeout of 0 leaves such requests to run until done.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.timeout = timeout
+}
+
+// SetRetries will change how many times a read is retried when the daemon
+// can't be reached or is unavailable
+func (c *Client) SetRetries(retries int) {
+	c.retries = retries
+}
+
+// SetIdempotencyKey will send the key with every following request. While
+// ferryd remembers the key, calls which would queue another job of the same
+// kind return the ID of the job queued with the key instead. An empty key
+// stops sending it.
+func (c *Client) SetIdempotencyKey(key string) {
+	c.key = key
+}
+
 // Close will kill any idle connections still in "keep-alive" and ensure we're
 // not leaking file descriptors.
 func (c *Client) Close() {
@@ -66,187 +124,1335 @@ func (c *Client) formURI(part string) string {
 	return fmt.Sprintf("http://localhost.localdomain:0/%s", part)
 }
 
+// cancelBody will release the request's context once the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// do will send the request, bounded by our timeout unless the context
+// already has a deadline. Any body is sent as JSON.
+func (c *Client) do(ctx context.Context, method, url string, body io.Reader) (*http.Response, error) {
+	return c.doContent(ctx, method, url, "application/json; charset=utf-8", body)
+}
+
+// doContent is do, with a body of the given content type
+func (c *Client) doContent(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Response, error) {
+	cancel := context.CancelFunc(func() {})
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.key != "" {
+		req.Header.Set(IdempotencyKeyHeader, c.key)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// get will GET the url, retrying with backoff while the daemon can't be
+// reached or is unavailable, waiting at least as long as it asks us to. It
+// must only be used for requests which change nothing, as a retried request
+// may have been seen already.
+func (c *Client) get(ctx context.Context, url string) (*http.Response, error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, http.MethodGet, url, nil)
+		if attempt >= c.retries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+		delay := backoff
+		if resp != nil {
+			if wait := retryAfter(resp); wait > delay {
+				delay = wait
+			}
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+// shouldRetry determines if the request never reached the daemon, or the
+// daemon asked us to come back later, either because it's unavailable or
+// we're over its request limits. Timeouts aren't retried, as a busy daemon
+// would only be kept busier.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		urlErr, ok := err.(*url.Error)
+		if !ok {
+			return false
+		}
+		opErr, ok := urlErr.Err.(*net.OpError)
+		return ok && opErr.Op == "dial" && !opErr.Timeout()
+	}
+	return resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusTooManyRequests
+}
+
 // GetRepos will grab a list of repos from the daemon
 func (c *Client) GetRepos() ([]string, error) {
+	return c.GetReposContext(context.Background())
+}
+
+// GetReposContext is GetRepos, with the request bound to ctx
+func (c *Client) GetReposContext(ctx context.Context) ([]string, error) {
 	var lq RepoListingRequest
-	resp, err := c.client.Get(c.formURI("api/v1/list/repos"))
-	if err != nil {
+	if err := c.getResponse(ctx, c.formURI("api/v1/list/repos"), &lq); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if err = json.NewDecoder(resp.Body).Decode(&lq); err != nil {
+	return lq.Repository, nil
+}
+
+// GetRepoSummaries will return the summary of every repository known to
+// the daemon
+func (c *Client) GetRepoSummaries() ([]RepoSummary, error) {
+	return c.GetRepoSummariesContext(context.Background())
+}
+
+// GetRepoSummariesContext is GetRepoSummaries, with the request bound to ctx
+func (c *Client) GetRepoSummariesContext(ctx context.Context) ([]RepoSummary, error) {
+	var lq RepoListingRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/list/repos"), &lq); err != nil {
 		return nil, err
 	}
-	return lq.Repository, nil
+	return lq.Summaries, nil
 }
 
 // GetPoolItems will grab a list of pool items from the daemon
 func (c *Client) GetPoolItems() ([]PoolItem, error) {
+	return c.GetPoolItemsContext(context.Background())
+}
+
+// GetPoolItemsContext is GetPoolItems, with the request bound to ctx
+func (c *Client) GetPoolItemsContext(ctx context.Context) ([]PoolItem, error) {
 	var lq PoolListingRequest
-	resp, err := c.client.Get(c.formURI("api/v1/list/pool"))
-	if err != nil {
+	if err := c.getResponse(ctx, c.formURI("api/v1/list/pool"), &lq); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if err = json.NewDecoder(resp.Body).Decode(&lq); err != nil {
+	return lq.Item, nil
+}
+
+// ListPoolItems will return the pool items whose IDs begin with prefix, or
+// all of them if it is empty. When orphaned is set only items no repository
+// refers to are returned. offset and limit select a page of the matches,
+// with a limit of 0 returning all of them.
+func (c *Client) ListPoolItems(prefix string, orphaned bool, offset, limit int) (*PoolListingRequest, error) {
+	return c.ListPoolItemsContext(context.Background(), prefix, orphaned, offset, limit)
+}
+
+// ListPoolItemsContext is ListPoolItems, with the request bound to ctx
+func (c *Client) ListPoolItemsContext(ctx context.Context, prefix string, orphaned bool, offset, limit int) (*PoolListingRequest, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if orphaned {
+		query.Set("orphaned", "true")
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	uri := c.formURI("api/v1/list/pool")
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	var lq PoolListingRequest
+	if err := c.getResponse(ctx, uri, &lq); err != nil {
 		return nil, err
 	}
-	return lq.Item, nil
+	return &lq, nil
 }
 
-// A helper to wrap the trivial functionality, chaining off
-// the appropriate errors, etc.
-func (c *Client) getBasicResponse(url string, outT interface{}) error {
-	resp, e := c.client.Get(url)
+// responder is implemented by every type embedding a Response, allowing us
+// to check for errors in any reply from the daemon
+type responder interface {
+	response() *Response
+}
+
+func (r *Response) response() *Response {
+	return r
+}
+
+// getResponse will decode the reply from a GET request into outT, and
+// return the embedded error if there was one.
+func (c *Client) getResponse(ctx context.Context, url string, outT responder) error {
+	resp, e := c.get(ctx, url)
 	if e != nil {
 		return e
 	}
+	return decodeResponse(resp, outT)
+}
+
+// decodeResponse will decode the reply into outT, and return the embedded
+// error if there was one.
+func decodeResponse(resp *http.Response, outT responder) error {
 	defer resp.Body.Close()
-	if resp.ContentLength > 0 {
-		if e = json.NewDecoder(resp.Body).Decode(outT); e != nil {
-			return e
+	if e := json.NewDecoder(resp.Body).Decode(outT); e != nil {
+		if resp.StatusCode != http.StatusOK {
+			return unexpectedResponse(resp)
+		}
+		// Blocking requests reply with an empty body when they succeed
+		if e == io.EOF {
+			return nil
 		}
+		return e
 	}
-	fc := outT.(*Response)
+	fc := outT.response()
 	if !fc.Error {
 		return nil
 	}
-	return errors.New(fc.ErrorString)
+	return newError(resp, fc)
 }
 
 // A helper to wrap the trivial functionality, chaining off
-// the appropriate errors, etc.
-func (c *Client) postBasicResponse(url string, inT interface{}, outT interface{}) error {
+// the appropriate errors, etc. It isn't retried, as the request may
+// change something.
+func (c *Client) getBasicResponse(ctx context.Context, url string, outT responder) error {
+	resp, e := c.do(ctx, http.MethodGet, url, nil)
+	if e != nil {
+		return e
+	}
+	return decodeResponse(resp, outT)
+}
+
+// getJob will make a GET request which queues a job, returning the ID of
+// the job. It isn't retried, as the job may have been queued already.
+func (c *Client) getJob(ctx context.Context, url string) (string, error) {
+	resp, e := c.do(ctx, http.MethodGet, url, nil)
+	if e != nil {
+		return "", e
+	}
+	var jr JobResponse
+	if e = decodeResponse(resp, &jr); e != nil {
+		return "", e
+	}
+	return jr.JobID, nil
+}
+
+// postResponse will POST inT and decode the reply into outT, returning the
+// embedded error if there was one.
+func (c *Client) postResponse(ctx context.Context, url string, inT interface{}, outT responder) error {
 	b := &bytes.Buffer{}
-	enc := json.NewEncoder(b)
-	if err := enc.Encode(inT); err != nil {
+	if err := json.NewEncoder(b).Encode(inT); err != nil {
 		return err
 	}
-
-	resp, e := c.client.Post(url, "application/json; charset=utf-8", b)
+	resp, e := c.do(ctx, http.MethodPost, url, b)
 	if e != nil {
 		return e
 	}
+	return decodeResponse(resp, outT)
+}
 
-	defer resp.Body.Close()
-	if resp.ContentLength > 0 {
-		if e = json.NewDecoder(resp.Body).Decode(outT); e != nil {
-			return e
-		}
+// postJob will make a POST request which queues a job, returning the ID of
+// the job
+func (c *Client) postJob(ctx context.Context, url string, inT interface{}) (string, error) {
+	var jr JobResponse
+	if err := c.postResponse(ctx, url, inT, &jr); err != nil {
+		return "", err
 	}
+	return jr.JobID, nil
+}
 
-	fc := outT.(*Response)
-	if !fc.Error {
-		return nil
+// A ConfirmationError is returned by destructive calls that haven't been
+// confirmed. Repeat the call with the Token to go ahead with the action.
+type ConfirmationError struct {
+	ConfirmationResponse
+}
+
+// Error implements the error interface
+func (c *ConfirmationError) Error() string {
+	return fmt.Sprintf("confirmation required: %s", c.Action)
+}
+
+// postDestructive will POST a destructive request, returning the ID of the
+// queued job, or a *ConfirmationError if ferryd requires the action to be
+// confirmed
+func (c *Client) postDestructive(ctx context.Context, url string, inT interface{}) (string, error) {
+	var reply struct {
+		ConfirmationResponse
+		JobID string `json:"jobID"`
+	}
+	if err := c.postResponse(ctx, url, inT, &reply); err != nil {
+		return "", err
+	}
+	if reply.Token != "" {
+		return "", &ConfirmationError{reply.ConfirmationResponse}
 	}
-	return errors.New(fc.ErrorString)
+	return reply.JobID, nil
 }
 
 // CreateRepo will attempt to create a repository in the daemon
-func (c *Client) CreateRepo(id string) error {
+func (c *Client) CreateRepo(id string) (string, error) {
+	return c.CreateRepoContext(context.Background(), id)
+}
+
+// CreateRepoContext is CreateRepo, with the request bound to ctx
+func (c *Client) CreateRepoContext(ctx context.Context, id string) (string, error) {
 	uri := c.formURI("/api/v1/create/repo/" + id)
-	return c.getBasicResponse(uri, &Response{})
+	return c.getJob(ctx, uri)
 }
 
 // DeleteRepo will attempt to delete a remote repository
-func (c *Client) DeleteRepo(id string) error {
-	uri := c.formURI("/api/v1/remove/repo/" + id)
-	return c.getBasicResponse(uri, &Response{})
+func (c *Client) DeleteRepo(id string, conf Confirmation) (string, error) {
+	return c.DeleteRepoContext(context.Background(), id, conf)
+}
+
+// DeleteRepoContext is DeleteRepo, with the request bound to ctx
+func (c *Client) DeleteRepoContext(ctx context.Context, id string, conf Confirmation) (string, error) {
+	dq := DeleteRepoRequest{
+		Confirmation: conf,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/remove/repo/"+id), &dq)
+}
+
+// PromoteStandby will ask a standby ferryd to become a primary, so that it
+// accepts writes. Unless ignorePrimary is set, the promotion is refused
+// while the primary it replicates from still answers.
+func (c *Client) PromoteStandby(ignorePrimary bool, conf Confirmation) error {
+	return c.PromoteStandbyContext(context.Background(), ignorePrimary, conf)
 }
 
-// DeltaRepo will attempt to reproduce deltas in the given repo
-func (c *Client) DeltaRepo(id string) error {
+// PromoteStandbyContext is PromoteStandby, with the request bound to ctx
+func (c *Client) PromoteStandbyContext(ctx context.Context, ignorePrimary bool, conf Confirmation) error {
+	pq := PromoteStandbyRequest{
+		Confirmation:  conf,
+		IgnorePrimary: ignorePrimary,
+	}
+	_, err := c.postDestructive(ctx, c.formURI("api/v1/standby/promote"), &pq)
+	return err
+}
+
+// DeltaRepo will attempt to reproduce deltas in the given repo. If historyID
+// is set, older releases from that repository are also used to produce
+// deltas.
+func (c *Client) DeltaRepo(id, historyID string) (string, error) {
+	return c.DeltaRepoContext(context.Background(), id, historyID)
+}
+
+// DeltaRepoContext is DeltaRepo, with the request bound to ctx
+func (c *Client) DeltaRepoContext(ctx context.Context, id, historyID string) (string, error) {
 	uri := c.formURI("/api/v1/delta/repo/" + id)
-	return c.getBasicResponse(uri, &Response{})
+	if historyID != "" {
+		uri += "?history=" + url.QueryEscape(historyID)
+	}
+	return c.getJob(ctx, uri)
 }
 
 // IndexRepo will attempt to index a repository in the daemon
-func (c *Client) IndexRepo(id string) error {
+func (c *Client) IndexRepo(id string) (string, error) {
+	return c.IndexRepoContext(context.Background(), id)
+}
+
+// IndexRepoContext is IndexRepo, with the request bound to ctx
+func (c *Client) IndexRepoContext(ctx context.Context, id string) (string, error) {
 	uri := c.formURI("/api/v1/index/repo/" + id)
-	return c.getBasicResponse(uri, &Response{})
+	return c.getJob(ctx, uri)
 }
 
 // ImportPackages will ask ferryd to import the named packages with absolute
 // paths
-func (c *Client) ImportPackages(repoID string, pkgs []string) error {
+func (c *Client) ImportPackages(repoID string, pkgs []string) (string, error) {
+	return c.ImportPackagesContext(context.Background(), repoID, pkgs)
+}
+
+// ImportPackagesContext is ImportPackages, with the request bound to ctx
+func (c *Client) ImportPackagesContext(ctx context.Context, repoID string, pkgs []string) (string, error) {
 	iq := ImportRequest{
 		Path: pkgs,
 	}
-	return c.postBasicResponse(c.formURI("api/v1/import/"+repoID), &iq, &Response{})
+	return c.postJob(ctx, c.formURI("api/v1/import/"+repoID), &iq)
+}
+
+// ImportDirectory will ask ferryd to import every package within the
+// directory, which must be an absolute path visible to the daemon. Packages
+// in subdirectories are only included if recursive is set.
+func (c *Client) ImportDirectory(repoID, path string, recursive bool) (string, error) {
+	return c.ImportDirectoryContext(context.Background(), repoID, path, recursive)
+}
+
+// ImportDirectoryContext is ImportDirectory, with the request bound to ctx
+func (c *Client) ImportDirectoryContext(ctx context.Context, repoID, path string, recursive bool) (string, error) {
+	iq := ImportDirectoryRequest{
+		Path:      path,
+		Recursive: recursive,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/import-directory/"+repoID), &iq)
 }
 
 // CloneRepo will ask the backend to clone an existing repository into a new repository
-func (c *Client) CloneRepo(repoID, newClone string, copyAll bool) error {
+func (c *Client) CloneRepo(repoID, newClone string, copyAll bool) (string, error) {
+	return c.CloneRepoContext(context.Background(), repoID, newClone, copyAll)
+}
+
+// CloneRepoContext is CloneRepo, with the request bound to ctx
+func (c *Client) CloneRepoContext(ctx context.Context, repoID, newClone string, copyAll bool) (string, error) {
 	cq := CloneRepoRequest{
 		CloneName: newClone,
 		CopyAll:   copyAll,
 	}
-	return c.postBasicResponse(c.formURI("api/v1/clone/"+repoID), &cq, &Response{})
+	return c.postJob(ctx, c.formURI("api/v1/clone/"+repoID), &cq)
 }
 
 // PullRepo will ask the backend to pull from target into repoID
-func (c *Client) PullRepo(sourceID, targetID string) error {
+func (c *Client) PullRepo(sourceID, targetID string) (string, error) {
+	return c.PullRepoContext(context.Background(), sourceID, targetID)
+}
+
+// PullRepoContext is PullRepo, with the request bound to ctx
+func (c *Client) PullRepoContext(ctx context.Context, sourceID, targetID string) (string, error) {
 	pq := PullRepoRequest{
 		Source: sourceID,
 	}
-	return c.postBasicResponse(c.formURI("api/v1/pull/"+targetID), &pq, &Response{})
+	return c.postJob(ctx, c.formURI("api/v1/pull/"+targetID), &pq)
+}
+
+// PullSource will ask the backend to pull the tip of the named source from
+// sourceID into targetID
+func (c *Client) PullSource(sourceID, targetID, sourceName string) (string, error) {
+	return c.PullSourceContext(context.Background(), sourceID, targetID, sourceName)
+}
+
+// PullSourceContext is PullSource, with the request bound to ctx
+func (c *Client) PullSourceContext(ctx context.Context, sourceID, targetID, sourceName string) (string, error) {
+	pq := PullSourceRequest{
+		Source:     sourceID,
+		SourceName: sourceName,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/pull-source/"+targetID), &pq)
+}
+
+// RetryDeltas will ask the backend to forget which deltas of the package
+// failed, and attempt them again before reindexing the repository
+func (c *Client) RetryDeltas(repoID, pkgName string) (string, error) {
+	return c.RetryDeltasContext(context.Background(), repoID, pkgName)
+}
+
+// RetryDeltasContext is RetryDeltas, with the request bound to ctx
+func (c *Client) RetryDeltasContext(ctx context.Context, repoID, pkgName string) (string, error) {
+	rq := DeltaRetryRequest{
+		Package: pkgName,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/delta/retry/"+url.PathEscape(repoID)), &rq)
 }
 
 // RemoveSource will ask the backend to remove packages by source name
-func (c *Client) RemoveSource(repoID, sourceID string, relno int) error {
+func (c *Client) RemoveSource(repoID, sourceID string, relno int, conf Confirmation) (string, error) {
+	return c.RemoveSourceContext(context.Background(), repoID, sourceID, relno, conf)
+}
+
+// RemoveSourceContext is RemoveSource, with the request bound to ctx
+func (c *Client) RemoveSourceContext(ctx context.Context, repoID, sourceID string, relno int, conf Confirmation) (string, error) {
 	sq := RemoveSourceRequest{
-		Source:  sourceID,
-		Release: relno,
+		Confirmation: conf,
+		Source:       sourceID,
+		Release:      relno,
 	}
-	return c.postBasicResponse(c.formURI("api/v1/remove/source/"+repoID), &sq, &Response{})
+	return c.postDestructive(ctx, c.formURI("api/v1/remove/source/"+repoID), &sq)
 }
 
 // CopySource will ask the backend to copy packages by source name
-func (c *Client) CopySource(fromID, targetID, sourceID string, relno int) error {
+func (c *Client) CopySource(fromID, targetID, sourceID string, relno int) (string, error) {
+	return c.CopySourceContext(context.Background(), fromID, targetID, sourceID, relno)
+}
+
+// CopySourceContext is CopySource, with the request bound to ctx
+func (c *Client) CopySourceContext(ctx context.Context, fromID, targetID, sourceID string, relno int) (string, error) {
 	sq := CopySourceRequest{
 		Source:  sourceID,
 		Target:  targetID,
 		Release: relno,
 	}
-	return c.postBasicResponse(c.formURI("api/v1/copy/source/"+fromID), &sq, &Response{})
+	return c.postJob(ctx, c.formURI("api/v1/copy/source/"+fromID), &sq)
+}
+
+// Promote will ask the backend to promote the source & release from one
+// repository into the target, checking it is safe to do so first
+func (c *Client) Promote(fromID, targetID, sourceID string, relno int, move bool) (string, error) {
+	return c.PromoteContext(context.Background(), fromID, targetID, sourceID, relno, move)
+}
+
+// PromoteContext is Promote, with the request bound to ctx
+func (c *Client) PromoteContext(ctx context.Context, fromID, targetID, sourceID string, relno int, move bool) (string, error) {
+	pq := PromoteRequest{
+		Source:  sourceID,
+		Target:  targetID,
+		Release: relno,
+		Move:    move,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/promote/"+fromID), &pq)
 }
 
 // TrimPackages will request that packages in the repo are trimmed to maxKeep
-func (c *Client) TrimPackages(repoID string, maxKeep int) error {
+func (c *Client) TrimPackages(repoID string, maxKeep int, conf Confirmation) (string, error) {
+	return c.TrimPackagesContext(context.Background(), repoID, maxKeep, conf)
+}
+
+// TrimPackagesContext is TrimPackages, with the request bound to ctx
+func (c *Client) TrimPackagesContext(ctx context.Context, repoID string, maxKeep int, conf Confirmation) (string, error) {
 	tq := TrimPackagesRequest{
-		MaxKeep: maxKeep,
+		Confirmation: conf,
+		MaxKeep:      maxKeep,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/trim/packages/"+repoID), &tq)
+}
+
+// TrimDeltas will request that deltas which no longer lead to a published
+// package are removed
+func (c *Client) TrimDeltas(repoID string) (string, error) {
+	return c.TrimDeltasContext(context.Background(), repoID)
+}
+
+// TrimDeltasContext is TrimDeltas, with the request bound to ctx
+func (c *Client) TrimDeltasContext(ctx context.Context, repoID string) (string, error) {
+	uri := c.formURI("/api/v1/trim/deltas/" + repoID)
+	return c.getJob(ctx, uri)
+}
+
+// TrimTemp will request that temporary files left behind by jobs which
+// didn't finish are removed, once older than maxAge. A zero maxAge uses
+// the daemon's configured age.
+func (c *Client) TrimTemp(maxAge time.Duration) (string, error) {
+	return c.TrimTempContext(context.Background(), maxAge)
+}
+
+// TrimTempContext is TrimTemp, with the request bound to ctx
+func (c *Client) TrimTempContext(ctx context.Context, maxAge time.Duration) (string, error) {
+	uri := c.formURI("/api/v1/trim/temp")
+	if maxAge > 0 {
+		uri += "?" + url.Values{"age": {maxAge.String()}}.Encode()
 	}
-	return c.postBasicResponse(c.formURI("api/v1/trim/packages/"+repoID), &tq, &Response{})
+	return c.getJob(ctx, uri)
+}
+
+// MigratePool will request that the pool is moved to the layout, either
+// "name" or "sha256"
+func (c *Client) MigratePool(layout string) (string, error) {
+	return c.MigratePoolContext(context.Background(), layout)
+}
+
+// MigratePoolContext is MigratePool, with the request bound to ctx
+func (c *Client) MigratePoolContext(ctx context.Context, layout string) (string, error) {
+	uri := c.formURI("/api/v1/migrate/pool/" + layout)
+	return c.getJob(ctx, uri)
+}
+
+// RewriteMetadata will request that the metadata of the stored package is
+// patched, and every repository containing it republished
+func (c *Client) RewriteMetadata(pkgID string, req *RewriteMetadataRequest) (string, error) {
+	return c.RewriteMetadataContext(context.Background(), pkgID, req)
+}
+
+// RewriteMetadataContext is RewriteMetadata, with the request bound to ctx
+func (c *Client) RewriteMetadataContext(ctx context.Context, pkgID string, req *RewriteMetadataRequest) (string, error) {
+	return c.postJob(ctx, c.formURI("api/v1/rewrite/"+pkgID), req)
+}
+
+// ValidateIndex will request that the published index of the repository is
+// checked against the files on disk
+func (c *Client) ValidateIndex(repoID string) (string, error) {
+	return c.ValidateIndexContext(context.Background(), repoID)
+}
+
+// ValidateIndexContext is ValidateIndex, with the request bound to ctx
+func (c *Client) ValidateIndexContext(ctx context.Context, repoID string) (string, error) {
+	uri := c.formURI("/api/v1/validate/index/" + repoID)
+	return c.getJob(ctx, uri)
+}
+
+// RelinkRepo will request that every file in the repository tree is
+// replaced by the pool's file, where they no longer match
+func (c *Client) RelinkRepo(repoID string) (string, error) {
+	return c.RelinkRepoContext(context.Background(), repoID)
+}
+
+// RelinkRepoContext is RelinkRepo, with the request bound to ctx
+func (c *Client) RelinkRepoContext(ctx context.Context, repoID string) (string, error) {
+	uri := c.formURI("/api/v1/relink/repo/" + repoID)
+	return c.getJob(ctx, uri)
 }
 
 // TrimObsolete will request that all packages marked obsolete are removed
-func (c *Client) TrimObsolete(repoID string) error {
-	uri := c.formURI("/api/v1/trim/obsoletes/" + repoID)
-	return c.getBasicResponse(uri, &Response{})
+func (c *Client) TrimObsolete(repoID string, conf Confirmation) (string, error) {
+	return c.TrimObsoleteContext(context.Background(), repoID, conf)
+}
+
+// TrimObsoleteContext is TrimObsolete, with the request bound to ctx
+func (c *Client) TrimObsoleteContext(ctx context.Context, repoID string, conf Confirmation) (string, error) {
+	tq := TrimObsoleteRequest{
+		Confirmation: conf,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/trim/obsoletes/"+repoID), &tq)
+}
+
+// GetIndexReport will return the problems found during the last index of
+// the repository
+func (c *Client) GetIndexReport(repoID string) (*IndexReportRequest, error) {
+	return c.GetIndexReportContext(context.Background(), repoID)
+}
+
+// GetIndexReportContext is GetIndexReport, with the request bound to ctx
+func (c *Client) GetIndexReportContext(ctx context.Context, repoID string) (*IndexReportRequest, error) {
+	var rq IndexReportRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/report/"+url.PathEscape(repoID)), &rq); err != nil {
+		return nil, err
+	}
+	return &rq, nil
+}
+
+// GetDeltaStats will report how much the deltas of the repository save over
+// their full packages, listing at most limit of the least effective deltas
+// or all of them if limit is 0
+func (c *Client) GetDeltaStats(repoID string, limit int) (*DeltaStatsRequest, error) {
+	return c.GetDeltaStatsContext(context.Background(), repoID, limit)
+}
+
+// GetDeltaStatsContext is GetDeltaStats, with the request bound to ctx
+func (c *Client) GetDeltaStatsContext(ctx context.Context, repoID string, limit int) (*DeltaStatsRequest, error) {
+	uri := c.formURI("api/v1/delta/stats/" + url.PathEscape(repoID))
+	if limit > 0 {
+		uri += "?limit=" + strconv.Itoa(limit)
+	}
+	var rq DeltaStatsRequest
+	if err := c.getResponse(ctx, uri, &rq); err != nil {
+		return nil, err
+	}
+	return &rq, nil
+}
+
+// GetDeltaSkips will return every delta skipped for the packages of the
+// repository, either as pointless or after failing
+func (c *Client) GetDeltaSkips(repoID string) ([]DeltaSkip, error) {
+	return c.GetDeltaSkipsContext(context.Background(), repoID)
+}
+
+// GetDeltaSkipsContext is GetDeltaSkips, with the request bound to ctx
+func (c *Client) GetDeltaSkipsContext(ctx context.Context, repoID string) ([]DeltaSkip, error) {
+	var rq DeltaSkipListRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/delta/skipped/"+url.PathEscape(repoID)), &rq); err != nil {
+		return nil, err
+	}
+	return rq.Skips, nil
+}
+
+// GetMigrationStatus will return the schema of every kind of record in the
+// daemon's database, and the migrations applied to it
+func (c *Client) GetMigrationStatus() (*MigrationStatusRequest, error) {
+	return c.GetMigrationStatusContext(context.Background())
+}
+
+// GetMigrationStatusContext is GetMigrationStatus, with the request bound to ctx
+func (c *Client) GetMigrationStatusContext(ctx context.Context) (*MigrationStatusRequest, error) {
+	var rq MigrationStatusRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/migrations"), &rq); err != nil {
+		return nil, err
+	}
+	return &rq, nil
+}
+
+// BackupDatabase will write a snapshot of the daemon's database to w
+func (c *Client) BackupDatabase(w io.Writer) error {
+	return c.BackupDatabaseContext(context.Background(), w)
+}
+
+// BackupDatabaseContext is BackupDatabase, with the request bound to ctx
+func (c *Client) BackupDatabaseContext(ctx context.Context, w io.Writer) error {
+	resp, err := c.get(ctx, c.formURI("api/v1/backup/db"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ExportRepo will write the repository, with every package and delta it
+// holds, to w as a tar archive which ImportRepo can load into another daemon
+func (c *Client) ExportRepo(repoID string, w io.Writer) error {
+	return c.ExportRepoContext(context.Background(), repoID, w)
+}
+
+// ExportRepoContext is ExportRepo, with the request bound to ctx
+func (c *Client) ExportRepoContext(ctx context.Context, repoID string, w io.Writer) error {
+	resp, err := c.get(ctx, c.formURI("api/v1/repo/export/"+repoID))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// ImportRepo will upload an archive written by ExportRepo, requesting that
+// a repository is created from it. The repository keeps the name it was
+// exported with unless name is set.
+func (c *Client) ImportRepo(r io.Reader, name string) (string, error) {
+	return c.ImportRepoContext(context.Background(), r, name)
+}
+
+// ImportRepoContext is ImportRepo, with the request bound to ctx
+func (c *Client) ImportRepoContext(ctx context.Context, r io.Reader, name string) (string, error) {
+	uri := c.formURI("api/v1/repo/import")
+	if name != "" {
+		uri += "?name=" + url.QueryEscape(name)
+	}
+	resp, err := c.doContent(ctx, http.MethodPost, uri, "application/x-tar", r)
+	if err != nil {
+		return "", err
+	}
+	var jr JobResponse
+	if err = decodeResponse(resp, &jr); err != nil {
+		return "", err
+	}
+	return jr.JobID, nil
+}
+
+// GetRepoManifest will describe every package, delta and asset held by
+// the repository
+func (c *Client) GetRepoManifest(repoID string) (*RepoManifestRequest, error) {
+	return c.GetRepoManifestContext(context.Background(), repoID)
+}
+
+// GetRepoManifestContext is GetRepoManifest, with the request bound to ctx
+func (c *Client) GetRepoManifestContext(ctx context.Context, repoID string) (*RepoManifestRequest, error) {
+	var mq RepoManifestRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/repo/manifest/"+url.PathEscape(repoID)), &mq); err != nil {
+		return nil, err
+	}
+	return &mq, nil
+}
+
+// FetchPoolFile will write the file of the pool entry, i.e. a package or
+// delta, to w
+func (c *Client) FetchPoolFile(id string, w io.Writer) error {
+	return c.FetchPoolFileContext(context.Background(), id, w)
+}
+
+// FetchPoolFileContext is FetchPoolFile, with the request bound to ctx
+func (c *Client) FetchPoolFileContext(ctx context.Context, id string, w io.Writer) error {
+	resp, err := c.get(ctx, c.formURI("api/v1/pool/file/"+url.PathEscape(id)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// WriteOpenAPI will write the OpenAPI document describing the daemon's API
+// to w, for use with client generators
+func (c *Client) WriteOpenAPI(w io.Writer) error {
+	return c.WriteOpenAPIContext(context.Background(), w)
+}
+
+// WriteOpenAPIContext is WriteOpenAPI, with the request bound to ctx
+func (c *Client) WriteOpenAPIContext(ctx context.Context, w io.Writer) error {
+	resp, err := c.get(ctx, c.formURI("api/v1/openapi.json"))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// GetAssets will return the assets installed in the repository
+func (c *Client) GetAssets(repoID string) ([]AssetItem, error) {
+	return c.GetAssetsContext(context.Background(), repoID)
+}
+
+// GetAssetsContext is GetAssets, with the request bound to ctx
+func (c *Client) GetAssetsContext(ctx context.Context, repoID string) ([]AssetItem, error) {
+	var aq AssetListingRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/asset/list/"+url.PathEscape(repoID)), &aq); err != nil {
+		return nil, err
+	}
+	return aq.Assets, nil
+}
+
+// GetAsset will return the contents of the named repository asset
+func (c *Client) GetAsset(repoID, name string) ([]byte, error) {
+	return c.GetAssetContext(context.Background(), repoID, name)
+}
+
+// GetAssetContext is GetAsset, with the request bound to ctx
+func (c *Client) GetAssetContext(ctx context.Context, repoID, name string) ([]byte, error) {
+	var aq AssetRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/asset/get/"+url.PathEscape(repoID)+"/"+url.PathEscape(name)), &aq); err != nil {
+		return nil, err
+	}
+	return aq.Data, nil
+}
+
+// SetAsset will ask ferryd to validate and install the repository asset,
+// after which the repository is reindexed
+func (c *Client) SetAsset(repoID, name string, data []byte) (string, error) {
+	return c.SetAssetContext(context.Background(), repoID, name, data)
+}
+
+// SetAssetContext is SetAsset, with the request bound to ctx
+func (c *Client) SetAssetContext(ctx context.Context, repoID, name string, data []byte) (string, error) {
+	aq := AssetRequest{
+		Name: name,
+		Data: data,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/asset/set/"+url.PathEscape(repoID)), &aq)
+}
+
+// CreateSnapshot will ask ferryd to record the current state of the
+// repository. An empty name will use the current time.
+func (c *Client) CreateSnapshot(repoID, name string) (string, error) {
+	return c.CreateSnapshotContext(context.Background(), repoID, name)
+}
+
+// CreateSnapshotContext is CreateSnapshot, with the request bound to ctx
+func (c *Client) CreateSnapshotContext(ctx context.Context, repoID, name string) (string, error) {
+	sq := SnapshotRequest{
+		Name: name,
+	}
+	return c.postJob(ctx, c.formURI("api/v1/snapshot/create/"+repoID), &sq)
+}
+
+// GetSnapshots will return all snapshots of the repository, oldest first
+func (c *Client) GetSnapshots(repoID string) ([]SnapshotItem, error) {
+	return c.GetSnapshotsContext(context.Background(), repoID)
+}
+
+// GetSnapshotsContext is GetSnapshots, with the request bound to ctx
+func (c *Client) GetSnapshotsContext(ctx context.Context, repoID string) ([]SnapshotItem, error) {
+	var sq SnapshotListingRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/snapshot/list/"+url.PathEscape(repoID)), &sq); err != nil {
+		return nil, err
+	}
+	return sq.Snapshots, nil
+}
+
+// RestoreSnapshot will ask ferryd to rewind the repository to the snapshot
+func (c *Client) RestoreSnapshot(repoID, name string, conf Confirmation) (string, error) {
+	return c.RestoreSnapshotContext(context.Background(), repoID, name, conf)
+}
+
+// RestoreSnapshotContext is RestoreSnapshot, with the request bound to ctx
+func (c *Client) RestoreSnapshotContext(ctx context.Context, repoID, name string, conf Confirmation) (string, error) {
+	sq := SnapshotRequest{
+		Confirmation: conf,
+		Name:         name,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/snapshot/restore/"+repoID), &sq)
+}
+
+// DeleteSnapshot will ask ferryd to remove the snapshot
+func (c *Client) DeleteSnapshot(repoID, name string, conf Confirmation) (string, error) {
+	return c.DeleteSnapshotContext(context.Background(), repoID, name, conf)
+}
+
+// DeleteSnapshotContext is DeleteSnapshot, with the request bound to ctx
+func (c *Client) DeleteSnapshotContext(ctx context.Context, repoID, name string, conf Confirmation) (string, error) {
+	sq := SnapshotRequest{
+		Confirmation: conf,
+		Name:         name,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/snapshot/delete/"+repoID), &sq)
+}
+
+// GetUndoJobs will return every job which can still be undone
+func (c *Client) GetUndoJobs() ([]UndoItem, error) {
+	return c.GetUndoJobsContext(context.Background())
+}
+
+// GetUndoJobsContext is GetUndoJobs, with the request bound to ctx
+func (c *Client) GetUndoJobsContext(ctx context.Context) ([]UndoItem, error) {
+	var uq UndoListingRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/undo"), &uq); err != nil {
+		return nil, err
+	}
+	return uq.Items, nil
+}
+
+// UndoJob will ask ferryd to restore the repository to how it was before
+// the job ran
+func (c *Client) UndoJob(jobID string, conf Confirmation) (string, error) {
+	return c.UndoJobContext(context.Background(), jobID, conf)
+}
+
+// UndoJobContext is UndoJob, with the request bound to ctx
+func (c *Client) UndoJobContext(ctx context.Context, jobID string, conf Confirmation) (string, error) {
+	uq := UndoRequest{
+		Confirmation: conf,
+	}
+	return c.postDestructive(ctx, c.formURI("api/v1/undo/"+jobID), &uq)
+}
+
+// GetRepoConfig will return the settings of the repository
+func (c *Client) GetRepoConfig(repoID string) (*RepoConfigRequest, error) {
+	return c.GetRepoConfigContext(context.Background(), repoID)
+}
+
+// GetRepoConfigContext is GetRepoConfig, with the request bound to ctx
+func (c *Client) GetRepoConfigContext(ctx context.Context, repoID string) (*RepoConfigRequest, error) {
+	var rq RepoConfigRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/repo/config/"+url.PathEscape(repoID)), &rq); err != nil {
+		return nil, err
+	}
+	return &rq, nil
+}
+
+// SetRepoConfig will replace the settings of the repository
+func (c *Client) SetRepoConfig(repoID string, config *RepoConfigRequest) error {
+	return c.SetRepoConfigContext(context.Background(), repoID, config)
+}
+
+// SetRepoConfigContext is SetRepoConfig, with the request bound to ctx
+func (c *Client) SetRepoConfigContext(ctx context.Context, repoID string, config *RepoConfigRequest) error {
+	return c.postResponse(ctx, c.formURI("api/v1/repo/config/"+url.PathEscape(repoID)), config, &Response{})
+}
+
+// GetHeld will return the sources held in the repository
+func (c *Client) GetHeld(repoID string) ([]string, error) {
+	return c.GetHeldContext(context.Background(), repoID)
+}
+
+// GetHeldContext is GetHeld, with the request bound to ctx
+func (c *Client) GetHeldContext(ctx context.Context, repoID string) ([]string, error) {
+	var hq HoldRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/hold/list/"+url.PathEscape(repoID)), &hq); err != nil {
+		return nil, err
+	}
+	return hq.Sources, nil
+}
+
+// HoldSources will stop the sources being pulled or promoted into the
+// repository until they are released again
+func (c *Client) HoldSources(repoID string, sources []string) error {
+	return c.HoldSourcesContext(context.Background(), repoID, sources)
+}
+
+// HoldSourcesContext is HoldSources, with the request bound to ctx
+func (c *Client) HoldSourcesContext(ctx context.Context, repoID string, sources []string) error {
+	hq := HoldRequest{
+		Repo:    repoID,
+		Sources: sources,
+	}
+	return c.postResponse(ctx, c.formURI("api/v1/hold/add/"+repoID), &hq, &Response{})
+}
+
+// UnholdSources will release the held sources in the repository
+func (c *Client) UnholdSources(repoID string, sources []string) error {
+	return c.UnholdSourcesContext(context.Background(), repoID, sources)
+}
+
+// UnholdSourcesContext is UnholdSources, with the request bound to ctx
+func (c *Client) UnholdSourcesContext(ctx context.Context, repoID string, sources []string) error {
+	hq := HoldRequest{
+		Repo:    repoID,
+		Sources: sources,
+	}
+	return c.postResponse(ctx, c.formURI("api/v1/hold/remove/"+repoID), &hq, &Response{})
+}
+
+// GetBans will return the bans of the repository
+func (c *Client) GetBans(repoID string) ([]Ban, error) {
+	return c.GetBansContext(context.Background(), repoID)
+}
+
+// GetBansContext is GetBans, with the request bound to ctx
+func (c *Client) GetBansContext(ctx context.Context, repoID string) ([]Ban, error) {
+	var bq BanRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/ban/list/"+url.PathEscape(repoID)), &bq); err != nil {
+		return nil, err
+	}
+	return bq.Bans, nil
+}
+
+// AddBan will stop matching packages from being introduced into the repository
+func (c *Client) AddBan(repoID string, ban Ban) error {
+	return c.AddBanContext(context.Background(), repoID, ban)
+}
+
+// AddBanContext is AddBan, with the request bound to ctx
+func (c *Client) AddBanContext(ctx context.Context, repoID string, ban Ban) error {
+	bq := BanRequest{
+		Repo: repoID,
+		Bans: []Ban{ban},
+	}
+	return c.postResponse(ctx, c.formURI("api/v1/ban/add/"+repoID), &bq, &Response{})
+}
+
+// RemoveBan will lift the ban covering the same packages from the repository
+func (c *Client) RemoveBan(repoID string, ban Ban) error {
+	return c.RemoveBanContext(context.Background(), repoID, ban)
+}
+
+// RemoveBanContext is RemoveBan, with the request bound to ctx
+func (c *Client) RemoveBanContext(ctx context.Context, repoID string, ban Ban) error {
+	bq := BanRequest{
+		Repo: repoID,
+		Bans: []Ban{ban},
+	}
+	return c.postResponse(ctx, c.formURI("api/v1/ban/remove/"+repoID), &bq, &Response{})
+}
+
+// GetCapabilities will return the operations and schema versions supported
+// by the daemon
+func (c *Client) GetCapabilities() (*CapabilitiesRequest, error) {
+	return c.GetCapabilitiesContext(context.Background())
+}
+
+// GetCapabilitiesContext is GetCapabilities, with the request bound to ctx
+func (c *Client) GetCapabilitiesContext(ctx context.Context) (*CapabilitiesRequest, error) {
+	var cq CapabilitiesRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/capabilities"), &cq); err != nil {
+		return nil, err
+	}
+	return &cq, nil
+}
+
+// A CompatibilityError is returned by CheckCompatibility when the daemon was
+// built with a different version of libferry
+type CompatibilityError struct {
+	DaemonVersion string // Empty if the daemon predates capabilities
+	APIVersion    int    // API version of the daemon
+}
+
+// Breaking will return true if the daemon speaks another version of the
+// API, such that requests are likely to fail
+func (e *CompatibilityError) Breaking() bool {
+	return e.APIVersion != APIVersion
+}
+
+// Error implements the error interface
+func (e *CompatibilityError) Error() string {
+	if e.DaemonVersion == "" {
+		return "ferryd does not report its capabilities, and is likely older than this client"
+	}
+	if e.Breaking() {
+		return fmt.Sprintf("ferryd speaks API version %d, but this client speaks version %d", e.APIVersion, APIVersion)
+	}
+	return fmt.Sprintf("ferryd is version %s, but this client is version %s", e.DaemonVersion, Version)
+}
+
+// CheckCompatibility will ask the daemon for its capabilities, returning a
+// *CompatibilityError if it doesn't match this library. Other errors mean
+// that the daemon couldn't be asked. It isn't retried, so that callers
+// aren't kept waiting twice when the daemon is down.
+func (c *Client) CheckCompatibility() error {
+	return c.CheckCompatibilityContext(context.Background())
+}
+
+// CheckCompatibilityContext is CheckCompatibility, with the request bound to ctx
+func (c *Client) CheckCompatibilityContext(ctx context.Context) error {
+	caps := &CapabilitiesRequest{}
+	resp, err := c.do(ctx, http.MethodGet, c.formURI("api/v1/capabilities"), nil)
+	if err == nil {
+		err = decodeResponse(resp, caps)
+	}
+	if err != nil {
+		if IsNotFound(err) {
+			return &CompatibilityError{}
+		}
+		return err
+	}
+	if caps.APIVersion != APIVersion || caps.Version != Version {
+		return &CompatibilityError{
+			DaemonVersion: caps.Version,
+			APIVersion:    caps.APIVersion,
+		}
+	}
+	return nil
 }
 
 // GetStatus will return status information for the running daemon process
 func (c *Client) GetStatus() (*StatusRequest, error) {
+	return c.GetStatusContext(context.Background())
+}
+
+// GetStatusContext is GetStatus, with the request bound to ctx
+func (c *Client) GetStatusContext(ctx context.Context) (*StatusRequest, error) {
 	var sq StatusRequest
-	resp, err := c.client.Get(c.formURI("api/v1/status"))
-	if err != nil {
+	if err := c.getResponse(ctx, c.formURI("api/v1/status"), &sq); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if err = json.NewDecoder(resp.Body).Decode(&sq); err != nil {
+	return &sq, nil
+}
+
+// ListPackages will return the published packages within the repository.
+// glob may be used to filter by package name, and offset/limit to page
+// through the results. A limit of 0 returns everything.
+func (c *Client) ListPackages(repoID, glob string, offset, limit int) (*PackageListingRequest, error) {
+	return c.ListPackagesContext(context.Background(), repoID, glob, offset, limit)
+}
+
+// ListPackagesContext is ListPackages, with the request bound to ctx
+func (c *Client) ListPackagesContext(ctx context.Context, repoID, glob string, offset, limit int) (*PackageListingRequest, error) {
+	query := url.Values{}
+	if glob != "" {
+		query.Set("glob", glob)
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	uri := c.formURI("api/v1/list/packages/" + url.PathEscape(repoID))
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	var lq PackageListingRequest
+	if err := c.getResponse(ctx, uri, &lq); err != nil {
+		return nil, err
+	}
+	return &lq, nil
+}
+
+// GetHistory will return the changes made to a repository, newest first. If
+// pkgName is set, only changes to that package are returned.
+func (c *Client) GetHistory(repoID, pkgName string, limit int) ([]HistoryItem, error) {
+	return c.GetHistoryContext(context.Background(), repoID, pkgName, limit)
+}
+
+// GetHistoryContext is GetHistory, with the request bound to ctx
+func (c *Client) GetHistoryContext(ctx context.Context, repoID, pkgName string, limit int) ([]HistoryItem, error) {
+	query := url.Values{}
+	if pkgName != "" {
+		query.Set("package", pkgName)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	uri := c.formURI("api/v1/history/" + url.PathEscape(repoID))
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	var hq HistoryRequest
+	if err := c.getResponse(ctx, uri, &hq); err != nil {
+		return nil, err
+	}
+	return hq.Items, nil
+}
+
+// GetPackageInfo will return the metadata for the named package within the
+// repository, along with all available releases and deltas
+func (c *Client) GetPackageInfo(repoID, pkgName string) (*PackageInfoRequest, error) {
+	return c.GetPackageInfoContext(context.Background(), repoID, pkgName)
+}
+
+// GetPackageInfoContext is GetPackageInfo, with the request bound to ctx
+func (c *Client) GetPackageInfoContext(ctx context.Context, repoID, pkgName string) (*PackageInfoRequest, error) {
+	uri := c.formURI("api/v1/info/" + url.PathEscape(repoID) + "/" + url.PathEscape(pkgName))
+	var iq PackageInfoRequest
+	if err := c.getResponse(ctx, uri, &iq); err != nil {
+		return nil, err
+	}
+	return &iq, nil
+}
+
+// Search will find packages whose name, summary or description contain the
+// pattern, or match it as a regular expression if regex is set. If repoID
+// is empty all repositories are searched.
+func (c *Client) Search(pattern, repoID string, regex bool) ([]SearchResult, error) {
+	return c.SearchContext(context.Background(), pattern, repoID, regex)
+}
+
+// SearchContext is Search, with the request bound to ctx
+func (c *Client) SearchContext(ctx context.Context, pattern, repoID string, regex bool) ([]SearchResult, error) {
+	query := url.Values{}
+	query.Set("q", pattern)
+	if repoID != "" {
+		query.Set("repo", repoID)
+	}
+	if regex {
+		query.Set("regex", "true")
+	}
+	var sq SearchRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/search?"+query.Encode()), &sq); err != nil {
+		return nil, err
+	}
+	return sq.Results, nil
+}
+
+// DiffRepos will report how the target repository differs from the source
+func (c *Client) DiffRepos(sourceID, targetID string) (*RepoDiffRequest, error) {
+	return c.DiffReposContext(context.Background(), sourceID, targetID)
+}
+
+// DiffReposContext is DiffRepos, with the request bound to ctx
+func (c *Client) DiffReposContext(ctx context.Context, sourceID, targetID string) (*RepoDiffRequest, error) {
+	uri := c.formURI("api/v1/diff/" + url.PathEscape(sourceID) + "/" + url.PathEscape(targetID))
+	var dq RepoDiffRequest
+	if err := c.getResponse(ctx, uri, &dq); err != nil {
+		return nil, err
+	}
+	return &dq, nil
+}
+
+// WaitStatus will block until the job queues have changed since the given
+// generation, or the daemon times out the request, and then return the
+// current status. Pass the Generation of the previous status to follow
+// changes as they happen.
+func (c *Client) WaitStatus(since uint64) (*StatusRequest, error) {
+	return c.WaitStatusContext(context.Background(), since)
+}
+
+// WaitStatusContext is WaitStatus, with the request bound to ctx
+func (c *Client) WaitStatusContext(ctx context.Context, since uint64) (*StatusRequest, error) {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 && c.timeout < waitStatusTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitStatusTimeout)
+		defer cancel()
+	}
+	var sq StatusRequest
+	if err := c.getResponse(ctx, c.formURI(fmt.Sprintf("api/v1/status/wait?since=%d", since)), &sq); err != nil {
 		return nil, err
 	}
 	return &sq, nil
 }
 
+// GetJob will return the state of a job queued earlier, along with the
+// number of jobs it scheduled that are still pending
+func (c *Client) GetJob(id string) (*JobStatusRequest, error) {
+	return c.GetJobContext(context.Background(), id)
+}
+
+// GetJobContext is GetJob, with the request bound to ctx
+func (c *Client) GetJobContext(ctx context.Context, id string) (*JobStatusRequest, error) {
+	var jq JobStatusRequest
+	if err := c.getResponse(ctx, c.formURI("api/v1/job/"+url.PathEscape(id)), &jq); err != nil {
+		return nil, err
+	}
+	return &jq, nil
+}
+
+// ListCompletedJobs will return the jobs which completed successfully and
+// are still remembered by the daemon, newest first. repo may be used to
+// only list jobs acting on that repository, and offset/limit to page through
+// the results. A limit of 0 returns everything.
+func (c *Client) ListCompletedJobs(repo string, offset, limit int) (*JobListingRequest, error) {
+	return c.ListCompletedJobsContext(context.Background(), repo, offset, limit)
+}
+
+// ListCompletedJobsContext is ListCompletedJobs, with the request bound to ctx
+func (c *Client) ListCompletedJobsContext(ctx context.Context, repo string, offset, limit int) (*JobListingRequest, error) {
+	return c.listJobs(ctx, "completed", repo, offset, limit)
+}
+
+// ListFailedJobs is ListCompletedJobs, for the jobs which failed
+func (c *Client) ListFailedJobs(repo string, offset, limit int) (*JobListingRequest, error) {
+	return c.ListFailedJobsContext(context.Background(), repo, offset, limit)
+}
+
+// ListFailedJobsContext is ListFailedJobs, with the request bound to ctx
+func (c *Client) ListFailedJobsContext(ctx context.Context, repo string, offset, limit int) (*JobListingRequest, error) {
+	return c.listJobs(ctx, "failed", repo, offset, limit)
+}
+
+// listJobs will fetch a page of the completed or failed jobs
+func (c *Client) listJobs(ctx context.Context, kind, repo string, offset, limit int) (*JobListingRequest, error) {
+	query := url.Values{}
+	if repo != "" {
+		query.Set("repo", repo)
+	}
+	if offset > 0 {
+		query.Set("offset", strconv.Itoa(offset))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	uri := c.formURI("api/v1/jobs/" + kind)
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	var jq JobListingRequest
+	if err := c.getResponse(ctx, uri, &jq); err != nil {
+		return nil, err
+	}
+	return &jq, nil
+}
+
+// WaitForJob will block until the job, and every job it scheduled, has
+// finished. An error is returned if the job itself failed.
+func (c *Client) WaitForJob(id string) (*JobStatusRequest, error) {
+	return c.WaitForJobContext(context.Background(), id)
+}
+
+// WaitForJobContext is WaitForJob, giving up once ctx is done
+func (c *Client) WaitForJobContext(ctx context.Context, id string) (*JobStatusRequest, error) {
+	if id == "" {
+		return nil, errors.New("no job to wait for")
+	}
+	for {
+		jq, err := c.GetJobContext(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if jq.Finished() {
+			if jq.State == JobFailed {
+				return jq, fmt.Errorf("job %s failed: %s", id, jq.Job.Error)
+			}
+			return jq, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(jobPollInterval):
+		}
+	}
+}
+
 // ResetFailed asks the daemon to reset failed jobs
 func (c *Client) ResetFailed() error {
+	return c.ResetFailedContext(context.Background())
+}
+
+// ResetFailedContext is ResetFailed, with the request bound to ctx
+func (c *Client) ResetFailedContext(ctx context.Context) error {
 	uri := c.formURI("/api/v1/reset/failed")
-	return c.getBasicResponse(uri, &Response{})
+	return c.getBasicResponse(ctx, uri, &Response{})
 }
 
 // ResetCompleted asks the daemon to reset completed jobs
 func (c *Client) ResetCompleted() error {
+	return c.ResetCompletedContext(context.Background())
+}
+
+// ResetCompletedContext is ResetCompleted, with the request bound to ctx
+func (c *Client) ResetCompletedContext(ctx context.Context) error {
 	uri := c.formURI("/api/v1/reset/completed")
-	return c.getBasicResponse(uri, &Response{})
+	return c.getBasicResponse(ctx, uri, &Response{})
 }
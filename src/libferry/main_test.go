@@ -0,0 +1,92 @@
+//
+// Copyright © 2017 Solus Project
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libferry
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// serveTest will serve the handler on a unix socket, returning a client for
+// it and a function to stop serving
+func serveTest(t *testing.T, handler http.Handler) (*Client, func()) {
+	dir, err := ioutil.TempDir("", "libferry")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	socket := filepath.Join(dir, "ferryd.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("Failed to listen on socket: %v", err)
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(l)
+	client := NewClient(socket)
+	client.SetRetries(0)
+	return client, func() {
+		client.Close()
+		srv.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestEmptyReply(t *testing.T) {
+	client, stop := serveTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repo/config/unstable":
+			// Success without a body
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer stop()
+
+	if err := client.SetRepoConfig("unstable", &RepoConfigRequest{}); err != nil {
+		t.Fatalf("Empty success reply was treated as an error: %v", err)
+	}
+	if err := client.SetRepoConfig("shannon", &RepoConfigRequest{}); err == nil {
+		t.Fatalf("Empty failure reply was treated as a success")
+	}
+}
+
+func TestRepoConfigEscaped(t *testing.T) {
+	var paths []string
+	client, stop := serveTest(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}))
+	defer stop()
+
+	if _, err := client.GetRepoConfig("a/b c"); err != nil {
+		t.Fatalf("Failed to get repository settings: %v", err)
+	}
+	if err := client.SetRepoConfig("a/b c", &RepoConfigRequest{}); err != nil {
+		t.Fatalf("Failed to set repository settings: %v", err)
+	}
+	for _, path := range paths {
+		if path != "/api/v1/repo/config/a%2Fb%20c" {
+			t.Fatalf("Repository name wasn't escaped: %s", path)
+		}
+	}
+}
@@ -17,14 +17,33 @@
 package libferry
 
 import (
+	"libeopkg"
 	"time"
 )
 
 // Response is the base portion for all ferryd responses, and will
 // include any relevant information on errors
 type Response struct {
-	Error       bool   // Whether this response is indication of an error
-	ErrorString string // The associated error message
+	Error       bool      // Whether this response is indication of an error
+	ErrorString string    // The associated error message
+	ErrorKind   ErrorKind // Category of the error, if any
+}
+
+// A Confirmation must accompany destructive requests. Either Force is set,
+// or Token holds the token from a ConfirmationResponse for the same action.
+type Confirmation struct {
+	Force bool   `json:"force"`
+	Token string `json:"token,omitempty"`
+}
+
+// A ConfirmationResponse is returned instead of performing a destructive
+// action that hasn't been confirmed. Repeating the request with the Token
+// will perform the action.
+type ConfirmationResponse struct {
+	Response
+	Token   string    `json:"token"`
+	Action  string    `json:"action"` // Human readable description of the action
+	Expires time.Time `json:"expires"`
 }
 
 // An ImportRequest is given to ferryd to ask for the given packages to be
@@ -34,11 +53,55 @@ type ImportRequest struct {
 	Path []string `json:"path"`
 }
 
+// An ImportDirectoryRequest is given to ferryd to ask for every package
+// within a directory on the server to be included into the repository
+type ImportDirectoryRequest struct {
+	Response
+	Path      string `json:"path"`
+	Recursive bool   `json:"recursive"`
+}
+
 // RepoListingRequest allows us to ask the remote what repositories it
 // currently knows about.
 type RepoListingRequest struct {
 	Response
-	Repository []string `json:"repos"`
+	Repository []string      `json:"repos"`
+	Summaries  []RepoSummary `json:"summaries"`
+}
+
+// A RepoSummary describes a repository at a glance. Indexed is zero if the
+// repository has never been indexed.
+type RepoSummary struct {
+	ID       string    `json:"id"`
+	Packages int       `json:"packages"` // Package names in the repository
+	Releases int       `json:"releases"` // Releases available across all of them
+	Deltas   int       `json:"deltas"`
+	Size     int64     `json:"size"` // Bytes used by the packages and deltas
+	Held     int       `json:"held"` // Sources frozen against pulls and promotion
+	Indexed  time.Time `json:"indexed"`
+
+	LastIndex IndexStatus `json:"lastIndex"` // Successful or not
+	Dirty     bool        `json:"dirty"`     // Packages changed since the last successful index
+}
+
+// IndexStatus records how the last index of a repository went. Begin is
+// zero if the repository has never been indexed.
+//
+// All times must be recorded in UTC!
+type IndexStatus struct {
+	Begin time.Time `json:"begin"`
+	End   time.Time `json:"end"`
+	Error string    `json:"error,omitempty"` // Empty if the index succeeded
+}
+
+// Duration will return how long the index took
+func (i *IndexStatus) Duration() time.Duration {
+	return i.End.Sub(i.Begin)
+}
+
+// Failed will return true if the index didn't succeed
+func (i *IndexStatus) Failed() bool {
+	return i.Error != ""
 }
 
 // A PoolItem simply has an ID and a refcount, allowing us to examine our
@@ -46,12 +109,105 @@ type RepoListingRequest struct {
 type PoolItem struct {
 	ID       string `json:"id"`
 	RefCount int    `json:"refCount"`
+	Alias    string `json:"alias,omitempty"` // Item whose identical file is shared
 }
 
-// A PoolListingRequest is sent to get a listing of all pool items
+// A PoolListingRequest is sent to get a listing of the pool items. Total is
+// the number of matches before pagination.
 type PoolListingRequest struct {
 	Response
-	Item []PoolItem `json:"items"`
+	Total  int        `json:"total"`
+	Offset int        `json:"offset"`
+	Item   []PoolItem `json:"items"`
+}
+
+// A PackageItem is a brief summary of a published package
+type PackageItem struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release int    `json:"release"`
+	Size    int64  `json:"size"` // Size of the .eopkg in bytes
+	ID      string `json:"id"`   // Pool ID of the published package
+
+	Provenance *Provenance `json:"provenance,omitempty"` // Only set in package info
+}
+
+// Provenance records where a package came from, when known
+type Provenance struct {
+	Builder  string    `json:"builder,omitempty"`  // Builder that uploaded the package
+	Build    string    `json:"build,omitempty"`    // The builder's own identifier for the build
+	Manifest string    `json:"manifest,omitempty"` // Transit manifest the package arrived with
+	Uploaded time.Time `json:"uploaded"`
+	JobID    string    `json:"jobID,omitempty"` // Job which added the package
+}
+
+// A PackageListingRequest is sent to list the published packages within a
+// repository. Total is the number of matches before pagination.
+type PackageListingRequest struct {
+	Response
+	Total  int           `json:"total"`
+	Offset int           `json:"offset"`
+	Items  []PackageItem `json:"items"`
+}
+
+// A DeltaItem describes a delta package known to a repository
+type DeltaItem struct {
+	ID          string `json:"id"`
+	FromRelease int    `json:"fromRelease"`
+	ToRelease   int    `json:"toRelease"`
+	Size        int64  `json:"size"`
+}
+
+// A PackageInfoRequest returns the full metadata for the published release
+// of a package, along with every release and delta in the repository
+type PackageInfoRequest struct {
+	Response
+	Package   *libeopkg.MetaPackage `json:"package"` // nil if nothing is published
+	Available []PackageItem         `json:"available"`
+	Deltas    []DeltaItem           `json:"deltas"`
+
+	// Where the published package came from, nil if unknown
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// A SearchResult is a single package matching a search
+type SearchResult struct {
+	Repo    string `json:"repo"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Release int    `json:"release"`
+	Summary string `json:"summary"`
+	ID      string `json:"id"`
+}
+
+// A SearchRequest returns the packages matching a search
+type SearchRequest struct {
+	Response
+	Results []SearchResult `json:"results"`
+}
+
+// A DiffItem describes a package that differs between two repositories.
+// Releases are 0 where the package doesn't exist on that side.
+type DiffItem struct {
+	Name          string `json:"name"`
+	SourceVersion string `json:"sourceVersion,omitempty"`
+	SourceRelease int    `json:"sourceRelease,omitempty"`
+	TargetVersion string `json:"targetVersion,omitempty"`
+	TargetRelease int    `json:"targetRelease,omitempty"`
+}
+
+// A RepoDiffRequest reports the differences between a source and target
+// repository, as seen by a pull from the source into the target
+type RepoDiffRequest struct {
+	Response
+	Source       string     `json:"source"`
+	Target       string     `json:"target"`
+	Newer        []DiffItem `json:"newer"`        // Would be updated by a pull
+	Missing      []DiffItem `json:"missing"`      // Would be added by a pull
+	Obsolete     []DiffItem `json:"obsolete"`     // Only in the target
+	Inconsistent []DiffItem `json:"inconsistent"` // Newer in the target, would fail a pull
+	Held         []DiffItem `json:"held"`         // Newer or missing, but held in the target
+	Banned       []DiffItem `json:"banned"`       // Newer or missing, but banned in the target
 }
 
 // CloneRepoRequest is given to ferryd to ask it to clone one repo into another
@@ -67,10 +223,41 @@ type PullRepoRequest struct {
 	Source string `json:"source"`
 }
 
+// PullSourceRequest is given to ferryd to ask it to pull a single source
+// from one repo into another
+type PullSourceRequest struct {
+	Response
+	Source     string `json:"source"`
+	SourceName string `json:"sourceName"`
+}
+
+// DeltaRetryRequest is used to ask ferryd to attempt the failed deltas of a
+// package again
+type DeltaRetryRequest struct {
+	Response
+	Package string `json:"package"`
+}
+
+// DeleteRepoRequest is used to ask ferryd to delete a repository
+type DeleteRepoRequest struct {
+	Response
+	Confirmation
+}
+
+// PromoteStandbyRequest is used to ask a standby ferryd to become a primary
+// and accept writes. The promotion is refused while the primary still
+// answers, unless IgnorePrimary is set.
+type PromoteStandbyRequest struct {
+	Response
+	Confirmation
+	IgnorePrimary bool `json:"ignorePrimary"`
+}
+
 // RemoveSourceRequest is used to ask ferryd to remove all packages matching the
 // given source and relno parameters
 type RemoveSourceRequest struct {
 	Response
+	Confirmation
 	Source  string `json:"source"`
 	Release int    `json:"relno"`
 }
@@ -84,12 +271,323 @@ type CopySourceRequest struct {
 	Release int    `json:"relno"`
 }
 
+// PromoteRequest is sent to promote a source & release from one repository
+// into the target, once the policy checks pass. Move will remove it from the
+// original repository afterwards.
+type PromoteRequest struct {
+	Response
+	Target  string `json:"target"`
+	Source  string `json:"source"`
+	Release int    `json:"relno"`
+	Move    bool   `json:"move"`
+}
+
 // TrimPackagesRequest is sent when trimming excessive fat from a repository.
 type TrimPackagesRequest struct {
 	Response
+	Confirmation
 	MaxKeep int `json:"maxPackages"`
 }
 
+// TrimObsoleteRequest is sent to remove all obsolete packages from a repository
+type TrimObsoleteRequest struct {
+	Response
+	Confirmation
+}
+
+// A SnapshotItem describes a single snapshot of a repository
+type SnapshotItem struct {
+	Name      string    `json:"name"`
+	Created   time.Time `json:"created"`
+	Packages  int       `json:"packages"`
+	Automatic bool      `json:"automatic"` // Taken before a destructive job
+}
+
+// SnapshotListingRequest lists every snapshot of a repository
+type SnapshotListingRequest struct {
+	Response
+	Repo      string         `json:"repo"`
+	Snapshots []SnapshotItem `json:"snapshots"`
+}
+
+// SnapshotRequest is used to create, restore or delete a named snapshot.
+// Confirmation is only required for restore and delete.
+type SnapshotRequest struct {
+	Response
+	Confirmation
+	Name string `json:"name"`
+}
+
+// An UndoItem is a job which can still be undone
+type UndoItem struct {
+	JobID       string    `json:"jobID"`
+	Repo        string    `json:"repo"`
+	Description string    `json:"description"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+// UndoListingRequest lists every job which can still be undone
+type UndoListingRequest struct {
+	Response
+	Items []UndoItem `json:"items"`
+}
+
+// UndoRequest is sent to undo a job, restoring the repository to the
+// automatic snapshot taken before it ran
+type UndoRequest struct {
+	Response
+	Confirmation
+}
+
+// A HistoryPackage is a package added to or removed from a repository
+type HistoryPackage struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+}
+
+// A HistoryItem describes a single change to a repository
+type HistoryItem struct {
+	Time        time.Time        `json:"time"`
+	JobID       string           `json:"jobID"`
+	Operation   string           `json:"operation"`
+	Description string           `json:"description"`
+	Params      []string         `json:"params"`
+	Added       []HistoryPackage `json:"added"`
+	Removed     []HistoryPackage `json:"removed"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// HistoryRequest returns the history of a repository, newest first
+type HistoryRequest struct {
+	Response
+	Repo  string        `json:"repo"`
+	Items []HistoryItem `json:"items"`
+}
+
+// DeltaPolicy controls which deltas are produced for a repository
+type DeltaPolicy struct {
+	Enabled     bool  `json:"enabled"`
+	MaxDeltas   int   `json:"maxDeltas"`   // Per package, 0 for no limit
+	MinDistance int   `json:"minDistance"` // Minimum releases between from and to
+	MaxSize     int64 `json:"maxSize"`     // Largest package to delta in bytes, 0 for no limit
+}
+
+// RepoConfigRequest is used to retrieve or change the settings of a repository
+type RepoConfigRequest struct {
+	Response
+	Repo         string      `json:"repo"`
+	Delta        DeltaPolicy `json:"delta"`
+	VerifyHashes bool        `json:"verifyHashes"` // Check package contents on import
+
+	// How to handle duplicate release numbers: keep, reject or newer
+	ConflictPolicy string `json:"conflictPolicy"`
+
+	VerifyIndex bool `json:"verifyIndex"` // Validate the index before publishing
+
+	// Architectures packages may be built for, each indexed separately.
+	// Empty allows any architecture.
+	Architectures []string `json:"architectures"`
+
+	// Emit an index fragment for each component alongside the main index
+	ComponentIndexes bool `json:"componentIndexes"`
+
+	// Check each new delta really produces its target before it's indexed
+	VerifyDeltas bool `json:"verifyDeltas"`
+
+	// Write each index into a new generation directory, publishing every
+	// artifact at once with a single symlink flip
+	VersionedIndexes bool `json:"versionedIndexes"`
+
+	Quota int64 `json:"quota"` // Most bytes the repository may use, 0 for no limit
+	Used  int64 `json:"used"`  // Bytes used right now, ignored when changing settings
+}
+
+// HoldRequest is used to list the sources held in a repository, or to hold
+// or release sources
+type HoldRequest struct {
+	Response
+	Repo    string   `json:"repo"`
+	Sources []string `json:"sources"`
+}
+
+// A Ban stops matching packages from being introduced into a repository.
+// A release bound of 0 leaves that end of the range open.
+type Ban struct {
+	Name        string `json:"name"`
+	Source      bool   `json:"source"` // Name is a source rather than a package
+	ReleaseFrom int    `json:"releaseFrom,omitempty"`
+	ReleaseTo   int    `json:"releaseTo,omitempty"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// BanRequest is used to list the bans of a repository, or to add or lift bans
+type BanRequest struct {
+	Response
+	Repo string `json:"repo"`
+	Bans []Ban  `json:"bans"`
+}
+
+// RewriteMetadataRequest is sent to patch the metadata of a stored package.
+// Only the fields which are set will be changed.
+type RewriteMetadataRequest struct {
+	Response
+	Summary     *string  `json:"summary,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	PartOf      *string  `json:"partOf,omitempty"`
+	License     []string `json:"license,omitempty"`
+}
+
+// An AssetItem describes an asset installed in a repository, such as the
+// components.xml
+type AssetItem struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// AssetListingRequest lists the assets of a repository
+type AssetListingRequest struct {
+	Response
+	Repo   string      `json:"repo"`
+	Assets []AssetItem `json:"assets"`
+}
+
+// AssetRequest is used to retrieve or replace a single repository asset
+type AssetRequest struct {
+	Response
+	Repo string `json:"repo"`
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+// An IndexFinding is a single problem found while indexing a repository
+type IndexFinding struct {
+	Kind    string `json:"kind"`    // i.e. obsolete-dependency
+	Package string `json:"package"` // ID of the offending package
+	Detail  string `json:"detail"`
+}
+
+// IndexReportRequest carries the problems found during the last index of a
+// repository
+type IndexReportRequest struct {
+	Response
+	Repo     string         `json:"repo"`
+	Time     time.Time      `json:"time"`
+	Packages int            `json:"packages"`
+	Findings []IndexFinding `json:"findings"`
+}
+
+// A DeltaSkip records a delta which is no longer attempted, and why
+type DeltaSkip struct {
+	ID          string    `json:"id"`
+	FromID      string    `json:"fromID"`
+	ToID        string    `json:"toID"`
+	FromRelease int       `json:"fromRelease"`
+	ToRelease   int       `json:"toRelease"`
+	Reason      string    `json:"reason"`   // "pointless" or "failed"
+	Failures    int       `json:"failures"` // Times production failed
+	Marked      time.Time `json:"marked"`
+	Expires     time.Time `json:"expires"` // Zero if skipped for good
+	Expired     bool      `json:"expired"` // Will be attempted again
+}
+
+// DeltaSkipListRequest lists the deltas skipped for the packages of a
+// repository
+type DeltaSkipListRequest struct {
+	Response
+	Skips []DeltaSkip `json:"skips"`
+}
+
+// A DeltaStat describes how much one delta package saves over fetching the
+// full package it produces
+type DeltaStat struct {
+	ID          string  `json:"id"`
+	Package     string  `json:"package"`
+	FromRelease int     `json:"fromRelease"`
+	ToRelease   int     `json:"toRelease"`
+	Size        int64   `json:"size"`
+	TargetSize  int64   `json:"targetSize"`
+	Ratio       float64 `json:"ratio"`   // Size relative to the full package
+	Current     bool    `json:"current"` // Goes to the published release
+}
+
+// DeltaStatsRequest summarises how effective the deltas of a repository are
+type DeltaStatsRequest struct {
+	Response
+	Repo        string      `json:"repo"`
+	Deltas      int         `json:"deltas"`
+	Current     int         `json:"current"` // Deltas in the index
+	Unknown     int         `json:"unknown"` // Left out as their full size isn't known
+	Bytes       int64       `json:"bytes"`
+	TargetBytes int64       `json:"targetBytes"`
+	Saved       int64       `json:"saved"`
+	Ratio       float64     `json:"ratio"`
+	Worst       []DeltaStat `json:"worst"` // Least effective deltas first
+}
+
+// A SchemaKind describes the schema of one kind of record in the database
+type SchemaKind struct {
+	Kind     string   `json:"kind"`     // i.e. pool
+	Current  string   `json:"current"`  // Version written by the daemon
+	Database string   `json:"database"` // Version of the stored records, if known
+	Pending  []string `json:"pending"`  // Migrations still to be applied
+}
+
+// An AppliedMigration records a schema migration run on the database
+type AppliedMigration struct {
+	Kind        string    `json:"kind"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Description string    `json:"description"`
+	Records     int       `json:"records"` // How many records were upgraded
+	Time        time.Time `json:"time"`
+}
+
+// MigrationStatusRequest reports the schema of the daemon's database
+type MigrationStatusRequest struct {
+	Response
+	Kinds   []SchemaKind       `json:"kinds"`
+	Applied []AppliedMigration `json:"applied"`
+}
+
+// A ManifestEntry lists the packages and deltas held for one package name
+// in a repository manifest
+type ManifestEntry struct {
+	Name      string   `json:"name"`
+	Published string   `json:"published"` // ID of the tip package
+	Available []string `json:"available"`
+	Deltas    []string `json:"deltas"`
+}
+
+// A ManifestDelta records which packages a delta in the manifest goes between
+type ManifestDelta struct {
+	FromRelease int    `json:"fromRelease"`
+	FromID      string `json:"fromID"`
+	ToRelease   int    `json:"toRelease"`
+	ToID        string `json:"toID"`
+	TargetSize  int64  `json:"targetSize,omitempty"`
+}
+
+// A ManifestFile describes a pool file referenced by a repository manifest
+type ManifestFile struct {
+	ID          string         `json:"id"`
+	Size        int64          `json:"size"`
+	ContentHash string         `json:"contentHash"` // sha256sum of the file
+	Delta       *ManifestDelta `json:"delta,omitempty"`
+	Provenance  *Provenance    `json:"provenance,omitempty"`
+}
+
+// RepoManifestRequest describes everything held by a repository, so that a
+// replica can fetch whatever it's missing
+type RepoManifestRequest struct {
+	Response
+	Repo    string          `json:"repo"`
+	Entries []ManifestEntry `json:"entries"`
+	Files   []ManifestFile  `json:"files"`
+	Assets  []string        `json:"assets"`
+}
+
 // TimingInformation stores relevant timing stats on jobs so we can know what
 // kind of latency we're dealing with, etc.
 //
@@ -143,10 +641,175 @@ func (j JobSet) Swap(a, b int) {
 
 // Job is used to represent status items in the backend
 type Job struct {
-	Description string            `json:"description"`
-	Timing      TimingInformation `json:"timing"`
-	Failed      bool              `json:"failed"` // Whether it failed or not
-	Error       string            `json:"error"`  // Only set if we have Failed == true
+	ID             string            `json:"id"`                  // Correlation ID of the job, as logged
+	ParentID       string            `json:"parentID,omitempty"`  // Job which scheduled this one, if any
+	DependsOn      []string          `json:"dependsOn,omitempty"` // Jobs which must succeed before this one runs
+	Progress       *JobProgress      `json:"progress,omitempty"`  // Only set for running jobs that report it
+	Description    string            `json:"description"`
+	Type           string            `json:"type"`                     // Kind of job, i.e. "BulkAdd"
+	Repo           string            `json:"repo,omitempty"`           // Repository the job acted on, if any
+	Sources        []string          `json:"sources,omitempty"`        // Source packages the job acted on
+	PackageCount   int               `json:"packageCount,omitempty"`   // Number of packages processed
+	BytesProcessed int64             `json:"bytesProcessed,omitempty"` // Size of the packages processed, if known
+	Timing         TimingInformation `json:"timing"`
+	Failed         bool              `json:"failed"` // Whether it failed or not
+	Error          string            `json:"error"`  // Only set if we have Failed == true
+}
+
+// Events streamed by the daemon
+const (
+	// EventJobQueued is sent once a job has been queued
+	EventJobQueued = "job.queued"
+
+	// EventJobStarted is sent when a worker begins a job
+	EventJobStarted = "job.started"
+
+	// EventJobCompleted is sent when a job completes successfully
+	EventJobCompleted = "job.completed"
+
+	// EventJobFailed is sent when a job fails
+	EventJobFailed = "job.failed"
+
+	// EventJobOverdue is sent when a job has been running for longer than
+	// expected
+	EventJobOverdue = "job.overdue"
+
+	// EventIndexPublished is sent whenever a repository has a new index
+	EventIndexPublished = "index.published"
+
+	// EventRepoCreated is sent once a repository has been created
+	EventRepoCreated = "repo.created"
+
+	// EventRepoDeleted is sent once a repository has been deleted
+	EventRepoDeleted = "repo.deleted"
+)
+
+// An Event is something which happened within the daemon, as streamed from
+// the events API. IDs increase with each event, and start over whenever the
+// daemon is restarted.
+type Event struct {
+	ID      uint64    `json:"id"`
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Job     *Job      `json:"job,omitempty"`
+	Repo    string    `json:"repo,omitempty"`
+	Message string    `json:"message,omitempty"`
+}
+
+// JobState describes how far along a job is
+type JobState string
+
+const (
+	// JobQueued jobs are waiting for a worker
+	JobQueued JobState = "queued"
+
+	// JobRunning jobs are being executed right now
+	JobRunning JobState = "running"
+
+	// JobCompleted jobs finished successfully
+	JobCompleted JobState = "completed"
+
+	// JobFailed jobs finished with an error
+	JobFailed JobState = "failed"
+)
+
+// Operations which may be included in a BatchRequest
+const (
+	BatchCreateRepo = "create-repo"
+	BatchImport     = "import"
+	BatchIndex      = "index"
+	BatchDelta      = "delta"
+	BatchClone      = "clone"
+	BatchPull       = "pull"
+)
+
+// A BatchOperation is a single step of a BatchRequest
+type BatchOperation struct {
+	Op    string   `json:"op"`
+	Repo  string   `json:"repo"`            // Repository operated on
+	From  string   `json:"from,omitempty"`  // Source repository of a clone or pull
+	Paths []string `json:"paths,omitempty"` // Absolute paths of packages to import
+	Full  bool     `json:"full,omitempty"`  // Clone every package, not just the tip
+}
+
+// A BatchRequest is given to ferryd to queue several operations at once.
+// Each operation only runs once the one before it has succeeded.
+type BatchRequest struct {
+	Response
+	Operations []BatchOperation `json:"operations"`
+}
+
+// A BatchResponse lists the IDs of the jobs queued for each operation of a
+// BatchRequest, in order
+type BatchResponse struct {
+	Response
+	JobIDs []string `json:"jobIDs"`
+}
+
+// A JobResponse is returned by every request which queues a job, so that
+// the job can be followed with GetJob
+type JobResponse struct {
+	Response
+	JobID string `json:"jobID"`
+}
+
+// A JobListingRequest is sent to list the jobs which have finished, newest
+// first. Total is the number of matches before pagination.
+type JobListingRequest struct {
+	Response
+	Total  int    `json:"total"`
+	Offset int    `json:"offset"`
+	Items  JobSet `json:"items"`
+}
+
+// A JobStatusRequest describes a single job
+type JobStatusRequest struct {
+	Response
+	Job     Job      `json:"job"`
+	State   JobState `json:"state"`
+	Pending int      `json:"pending"` // Jobs it queued that haven't finished, including itself
+}
+
+// Finished will determine if the job and every job it queued are done
+func (j *JobStatusRequest) Finished() bool {
+	return (j.State == JobCompleted || j.State == JobFailed) && j.Pending == 0
+}
+
+// JobProgress is reported by long running jobs, such as delta production
+type JobProgress struct {
+	Current string `json:"current"` // What is currently being processed
+	Stage   string `json:"stage"`   // Which step of the job we're on
+	Done    int64  `json:"done"`    // Units of work completed
+	Total   int64  `json:"total"`   // Units of work expected
+}
+
+// Percent will return how much of the work has been completed
+func (p *JobProgress) Percent() float64 {
+	if p.Total <= 0 {
+		return 0
+	}
+	return float64(p.Done) / float64(p.Total) * 100
+}
+
+// CapabilitiesRequest describes what the daemon supports, so that clients
+// can find out whether they're compatible before relying on it
+type CapabilitiesRequest struct {
+	Response
+	Version    string            `json:"version"`    // Version of libferry the daemon was built with
+	APIVersion int               `json:"apiVersion"` // See APIVersion
+	Operations []string          `json:"operations"` // Every route, i.e. "GET /api/v1/job/:id"
+	Schemas    map[string]string `json:"schemas"`    // Schema version of each kind of stored record
+}
+
+// Supports will determine if the daemon has the given route, i.e.
+// "POST /api/v1/batch"
+func (c *CapabilitiesRequest) Supports(operation string) bool {
+	for _, op := range c.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
 }
 
 // StatusRequest is used to grab information from the daemon, including its
@@ -158,9 +821,118 @@ type StatusRequest struct {
 	TimeStarted time.Time `json:"timeStarted"`
 	Version     string    `json:"version"`
 
+	// Set while the daemon is a standby, only serving reads
+	Standby bool `json:"standby"`
+
+	// Set when the database was corrupted and couldn't be recovered, so
+	// only reads are served
+	Degraded bool `json:"degraded"`
+
+	// Generation changes every time a job changes state, and may be passed
+	// back to WaitStatus to wait for the next change
+	Generation uint64 `json:"generation"`
+
 	FailedJobs    JobSet `json:"failedJobs"`    // Known failed jobs
 	CurrentJobs   JobSet `json:"currentJobs"`   // Currently registered jobs
 	CompletedJobs JobSet `json:"completedJobs"` // Successfully completed jobs
+
+	Queues  QueueStatus    `json:"queues"`  // Depth of each job queue
+	Workers []WorkerStatus `json:"workers"` // What each worker is doing
+	Storage StorageStatus  `json:"storage"` // Database and disk usage
+
+	Incoming IncomingStatus `json:"incoming"` // Health of the upload watchers
+
+	Conflicts []ReleaseConflict `json:"conflicts"` // Outstanding duplicate releases
+
+	Publish []PublishStatus `json:"publish"` // Sync state of the publish targets
+}
+
+// PublishStatus is the sync state of one repository on a publish target
+type PublishStatus struct {
+	Target      string    `json:"target"`
+	Repo        string    `json:"repo"`
+	Pending     bool      `json:"pending"`  // Changed since the last push
+	Synced      time.Time `json:"synced"`   // Last successful push
+	Failures    int       `json:"failures"` // Consecutive failed pushes
+	Error       string    `json:"error,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt,omitempty"` // When a failed push is retried
+}
+
+// A ReleaseConflict is recorded when a package was imported with the same
+// release number as the published one, until a higher release is added.
+type ReleaseConflict struct {
+	Time       time.Time `json:"time"`
+	Repo       string    `json:"repo"`
+	Name       string    `json:"name"`
+	Release    int       `json:"release"`
+	Existing   string    `json:"existing"`   // Package already in the repository
+	New        string    `json:"new"`        // Package being imported
+	Resolution string    `json:"resolution"` // What the conflict policy did
+}
+
+// QueueStatus reports how many jobs are waiting in each queue, including
+// those currently being executed
+type QueueStatus struct {
+	Sequential int `json:"sequential"`
+	Async      int `json:"async"`
+}
+
+// WorkerStatus describes the current state of a single job worker
+type WorkerStatus struct {
+	ID          int          `json:"id"`
+	Sequential  bool         `json:"sequential"`
+	Busy        bool         `json:"busy"`
+	Description string       `json:"description,omitempty"` // Only set when Busy
+	Since       time.Time    `json:"since,omitempty"`       // When the current job began
+	JobID       string       `json:"jobID,omitempty"`       // Correlation ID of the current job
+	Progress    *JobProgress `json:"progress,omitempty"`    // Only set if the job reports it
+	Overdue     bool         `json:"overdue,omitempty"`     // Running for longer than expected
+	TimedOut    bool         `json:"timedOut,omitempty"`    // Asked to stop after its timeout
+}
+
+// StorageStatus reports the on disk size of the databases, and the
+// available space for the base directory
+type StorageStatus struct {
+	DatabaseSize    int64  `json:"databaseSize"`    // Main database, in bytes
+	JobDatabaseSize int64  `json:"jobDatabaseSize"` // Job database, in bytes
+	DiskFree        uint64 `json:"diskFree"`        // Bytes available to ferryd
+	DiskTotal       uint64 `json:"diskTotal"`       // Size of the filesystem
+	MinFree         uint64 `json:"minFree"`         // Bytes kept free, 0 if unchecked
+	Pressure        bool   `json:"pressure"`        // Less than MinFree is available
+	Refused         uint64 `json:"refused"`         // Imports & deltas refused for space or quota
+	PoolLayout      string `json:"poolLayout"`      // Where new pool files are stored
+	ObjectStore     string `json:"objectStore"`     // Where repositories are published, if anywhere
+}
+
+// IncomingStatus reports on the periodic rescans of the incoming directories,
+// which pick up any uploads the filesystem watchers failed to notice
+type IncomingStatus struct {
+	LastRescan      time.Time `json:"lastRescan"`
+	MissedManifests uint64    `json:"missedManifests"` // Found by rescanning rather than an event
+	StaleFiles      uint64    `json:"staleFiles"`      // Left lying in incoming for too long
+}
+
+// BusyWorkers will return the number of workers currently executing a job
+func (s *StatusRequest) BusyWorkers() int {
+	n := 0
+	for i := range s.Workers {
+		if s.Workers[i].Busy {
+			n++
+		}
+	}
+	return n
+}
+
+// OverdueWorkers will return the number of workers whose job has been
+// running for longer than expected
+func (s *StatusRequest) OverdueWorkers() int {
+	n := 0
+	for i := range s.Workers {
+		if s.Workers[i].Overdue {
+			n++
+		}
+	}
+	return n
 }
 
 // Uptime will determine the uptime of the daemon